@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/transcript"
+)
+
+// auditTranscriptFlags are the flags of the audit-transcript command
+type auditTranscriptFlags struct {
+	fs             *flag.FlagSet
+	transcriptFile *string
+	peerFile       *string
+	secure         *bool
+	allow          *string
+	help           *bool
+}
+
+// newAuditTranscriptFlags defines the flags of the audit-transcript command
+func newAuditTranscriptFlags() *auditTranscriptFlags {
+	fs := newFlagSet("audit-transcript")
+	return &auditTranscriptFlags{
+		fs:             fs,
+		transcriptFile: fs.String("transcript", "", "Transcript recorded with 'pprl -transcript'"),
+		peerFile:       fs.String("peer", "", "Peer's transcript of the same session (cross-checks digests)"),
+		secure:         fs.Bool("secure", false, "Fail if any message carries raw Bloom filters"),
+		allow:          fs.String("allow", strings.Join(transcript.DefaultAllowedTypes, ","), "Comma-separated allowed message types"),
+		help:           fs.Bool("help", false, "Show help message"),
+	}
+}
+
+func runAuditTranscriptCommand(args []string) {
+	f := newAuditTranscriptFlags()
+	f.fs.Parse(args)
+
+	if *f.help {
+		showAuditTranscriptHelp()
+		return
+	}
+
+	if *f.transcriptFile == "" && f.fs.NArg() > 0 {
+		*f.transcriptFile = f.fs.Arg(0)
+	}
+	if *f.transcriptFile == "" {
+		showAuditTranscriptHelp()
+		fatalf(UsageError, "ERROR: -transcript is required")
+	}
+
+	entries, err := transcript.Load(*f.transcriptFile)
+	if err != nil {
+		fatalf(DataError, "ERROR: %v", err)
+	}
+
+	var allowedTypes []string
+	for _, t := range strings.Split(*f.allow, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			allowedTypes = append(allowedTypes, t)
+		}
+	}
+
+	report := transcript.Audit(entries, transcript.AuditOptions{AllowedTypes: allowedTypes, Secure: *f.secure})
+
+	fmt.Println("CohortBridge Transcript Audit")
+	fmt.Println("=============================")
+	fmt.Printf("Transcript: %s\n", *f.transcriptFile)
+	fmt.Printf("Messages: %d (%d sent, %d received, %d bytes)\n", report.Entries, report.Sent, report.Received, report.Bytes)
+
+	var types []string
+	for t := range report.Types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		fmt.Printf("  %-14s %d\n", t, report.Types[t])
+	}
+	fmt.Println()
+
+	fmt.Println("Messages:")
+	for _, entry := range entries {
+		bloom := ""
+		if entry.BloomFilters > 0 {
+			bloom = fmt.Sprintf("  [%d raw Bloom filters]", entry.BloomFilters)
+		}
+		fmt.Printf("  #%-3d %-8s %-13s %9d bytes  %s%s\n", entry.Seq, entry.Direction, entry.Type, entry.Size,
+			shortFingerprint(strings.TrimPrefix(entry.Digest, "sha256:")), bloom)
+	}
+	fmt.Println()
+
+	violations := report.Violations
+	if *f.peerFile != "" {
+		peerEntries, err := transcript.Load(*f.peerFile)
+		if err != nil {
+			fatalf(DataError, "ERROR: %v", err)
+		}
+		problems := transcript.CrossCheck(entries, peerEntries)
+		if len(problems) == 0 {
+			fmt.Printf("Cross-check with %s: all message digests match\n\n", *f.peerFile)
+		}
+		violations = append(violations, problems...)
+	}
+
+	if len(violations) > 0 {
+		fmt.Printf("AUDIT FAILED: %d violation(s)\n", len(violations))
+		for _, v := range violations {
+			fmt.Printf("  - %s\n", v)
+		}
+		os.Exit(1)
+	}
+
+	if !*f.secure && report.Types["tokens"] > 0 {
+		fmt.Println("Note: Bloom filter content was not checked (use -secure to require none on the wire)")
+	}
+	fmt.Println("AUDIT PASSED")
+}
+
+func showAuditTranscriptHelp() {
+	fmt.Println("CohortBridge Transcript Audit")
+	fmt.Println("=============================")
+	fmt.Println()
+	fmt.Println("Validate a peer message transcript recorded with 'pprl -transcript'.")
+	fmt.Println("Transcripts hold only message types, sizes and SHA-256 digests -")
+	fmt.Println("never payload contents - so they can be shared with auditors.")
+	fmt.Println()
+	fmt.Println("USAGE:")
+	fmt.Println("  cohort-bridge audit-transcript -transcript FILE [OPTIONS]")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Println("  -transcript string   Transcript file to audit")
+	fmt.Println("  -peer string         Peer's transcript of the same session; verifies every")
+	fmt.Println("                       message was received exactly as it was sent")
+	fmt.Println("  -secure              Fail if any message carries raw Bloom filters")
+	fmt.Println("  -allow string        Allowed message types")
+	fmt.Println("                       (default: hello,handshake,handshake_confirm,tokens,")
+	fmt.Println("                       intersection_digest,intersection,reconciled_digest)")
+	fmt.Println("  -help                Show this help message")
+	fmt.Println()
+	fmt.Println("EXAMPLES:")
+	fmt.Println("  cohort-bridge pprl -config config.yaml -force -transcript session.jsonl")
+	fmt.Println("  cohort-bridge audit-transcript -transcript session.jsonl")
+	fmt.Println("  cohort-bridge audit-transcript -transcript a.jsonl -peer b.jsonl -secure")
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/batch"
+	"github.com/auroradata-ai/cohort-bridge/internal/store"
+)
+
+// batchRunEnvVar tells a pprl run started by batch which batch run it is ("<batch ID>/<name>"),
+// so the batch can find the run in the registry
+const batchRunEnvVar = "COHORT_BATCH_RUN"
+
+// Batch run states besides store.StatusSucceeded and store.StatusFailed
+const batchStatusSkipped = "skipped"
+
+// batchRunResult is one run of the batch summary report
+type batchRunResult struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	RunID    string  `json:"run_id,omitempty"` // pprl run in the registry
+	Matches  int     `json:"matches"`
+	Seconds  float64 `json:"seconds"`
+	Input    string  `json:"input,omitempty"`
+	Peer     string  `json:"peer,omitempty"`
+	Output   string  `json:"output"`
+	Log      string  `json:"log"`
+	Error    string  `json:"error,omitempty"`
+	started  time.Time
+	finished time.Time
+}
+
+// batchReport summarizes a batch across its runs
+type batchReport struct {
+	BatchID     string            `json:"batch_id"`
+	Manifest    string            `json:"manifest"`
+	Concurrency int               `json:"concurrency"`
+	StartedAt   time.Time         `json:"started_at"`
+	FinishedAt  time.Time         `json:"finished_at"`
+	Succeeded   int               `json:"succeeded"`
+	Failed      int               `json:"failed"`
+	Skipped     int               `json:"skipped"`
+	Matches     int               `json:"matches"`
+	Runs        []*batchRunResult `json:"runs"`
+}
+
+// batchFlags are the flags of the batch command
+type batchFlags struct {
+	fs           *flag.FlagSet
+	manifestFile *string
+	concurrency  *int
+	reportFile   *string
+	dryRun       *bool
+	force        *bool
+	help         *bool
+}
+
+// newBatchFlags defines the flags of the batch command
+func newBatchFlags() *batchFlags {
+	fs := newFlagSet("batch")
+	return &batchFlags{
+		fs:           fs,
+		manifestFile: fs.String("manifest", "", "Manifest listing the runs"),
+		concurrency:  fs.Int("concurrency", 0, "Runs executed at once (overrides the manifest's concurrency)"),
+		reportFile:   fs.String("report", "", "Write the JSON summary report to this file (overrides the manifest's report)"),
+		dryRun:       fs.Bool("dry-run", false, "List the runs without starting them"),
+		force:        fs.Bool("force", false, "Start without the confirmation prompt"),
+		help:         fs.Bool("help", false, "Show help message"),
+	}
+}
+
+func runBatchCommand(args []string) {
+	f := newBatchFlags()
+	f.fs.Parse(args)
+
+	if *f.help {
+		showBatchHelp()
+		return
+	}
+
+	if *f.manifestFile == "" {
+		showBatchHelp()
+		fatalf(UsageError, "Error: -manifest is required")
+	}
+
+	manifest, err := batch.Load(*f.manifestFile)
+	if err != nil {
+		fatalf(DataError, "ERROR: %v", err)
+	}
+	if *f.concurrency > 0 {
+		manifest.Concurrency = *f.concurrency
+	}
+	if *f.reportFile != "" {
+		manifest.Report = *f.reportFile
+	}
+
+	fmt.Println("CohortBridge Batch")
+	fmt.Println("==================")
+	fmt.Printf("  Manifest: %s\n", *f.manifestFile)
+	fmt.Printf("  Runs: %d (concurrency %d)\n", len(manifest.Runs), manifest.Concurrency)
+	if manifest.StopOnError() {
+		fmt.Println("  On failure: skip the runs not yet started")
+	} else {
+		fmt.Println("  On failure: continue with the remaining runs")
+	}
+	if manifest.Report != "" {
+		fmt.Printf("  Report: %s\n", manifest.Report)
+	}
+	fmt.Println()
+	for _, run := range manifest.Runs {
+		fmt.Printf("  %s\n", run.Name)
+		fmt.Printf("     config: %s\n", run.Config)
+		if run.Input != "" {
+			fmt.Printf("     input:  %s\n", run.Input)
+		}
+		if run.Peer != "" {
+			fmt.Printf("     peer:   %s\n", run.Peer)
+		}
+		fmt.Printf("     output: %s\n", run.Output)
+	}
+	fmt.Println()
+
+	if *f.dryRun {
+		return
+	}
+
+	if !skipConfirmation("batch", *f.force, "-force") {
+		choice := promptForChoice(fmt.Sprintf("Start %d runs?", len(manifest.Runs)), []string{"Yes, start the batch", "Cancel"})
+		if choice == 1 {
+			fmt.Println("\nBatch cancelled.")
+			os.Exit(0)
+		}
+	}
+
+	report, err := executeBatch(manifest, *f.manifestFile)
+	if err != nil {
+		fatalf(DataError, "ERROR: %v", err)
+	}
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// executeBatch runs the manifest's runs as pprl subprocesses, at most manifest.Concurrency at a
+// time, then prints and writes the summary report
+func executeBatch(manifest *batch.Manifest, manifestFile string) (*batchReport, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate the cohort-bridge executable: %w", err)
+	}
+
+	run := startRun("batch", nil)
+	run.Parameters["concurrency"] = strconv.Itoa(manifest.Concurrency)
+	run.AddInput(manifestFile)
+
+	report := &batchReport{
+		BatchID:     run.ID,
+		Manifest:    manifestFile,
+		Concurrency: manifest.Concurrency,
+		StartedAt:   time.Now().UTC(),
+	}
+	for _, r := range manifest.Runs {
+		report.Runs = append(report.Runs, &batchRunResult{
+			Name:   r.Name,
+			Status: batchStatusSkipped,
+			Input:  r.Input,
+			Peer:   r.Peer,
+			Output: r.Output,
+			Log:    filepath.Join(r.Output, "batch.log"),
+		})
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		stopped bool
+		slots   = make(chan struct{}, manifest.Concurrency)
+	)
+	for i := range manifest.Runs {
+		slots <- struct{}{}
+		mu.Lock()
+		stop := stopped
+		mu.Unlock()
+		if stop {
+			<-slots
+			break
+		}
+
+		wg.Add(1)
+		go func(spec batch.Run, result *batchRunResult) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			fmt.Printf("[%s] started\n", spec.Name)
+			err := executeBatchRun(executable, run.ID, spec, result)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.Status, result.Error = store.StatusFailed, err.Error()
+				fmt.Printf("[%s] FAILED after %s: %v (log: %s)\n", spec.Name, result.finished.Sub(result.started).Round(time.Millisecond), err, result.Log)
+				if manifest.StopOnError() {
+					stopped = true
+				}
+				return
+			}
+			result.Status = store.StatusSucceeded
+			fmt.Printf("[%s] succeeded in %s\n", spec.Name, result.finished.Sub(result.started).Round(time.Millisecond))
+		}(manifest.Runs[i], report.Runs[i])
+	}
+	wg.Wait()
+	report.FinishedAt = time.Now().UTC()
+
+	collectBatchRuns(report)
+	for _, result := range report.Runs {
+		result.Seconds = result.finished.Sub(result.started).Seconds()
+		switch result.Status {
+		case store.StatusSucceeded:
+			report.Succeeded++
+		case store.StatusFailed:
+			report.Failed++
+		default:
+			report.Skipped++
+		}
+		report.Matches += result.Matches
+	}
+	printBatchReport(report)
+
+	run.Counts["runs"] = len(report.Runs)
+	run.Counts["succeeded"] = report.Succeeded
+	run.Counts["failed"] = report.Failed
+	run.Counts["skipped"] = report.Skipped
+	run.Counts["matches"] = report.Matches
+	if manifest.Report != "" {
+		if err := saveJSONFile(report, manifest.Report); err != nil {
+			recordRun(run, err)
+			return report, fmt.Errorf("failed to write report: %w", err)
+		}
+		fmt.Printf("Report saved to: %s\n", manifest.Report)
+		run.AddOutput(manifest.Report)
+	}
+
+	var batchErr error
+	if report.Failed > 0 {
+		batchErr = fmt.Errorf("%d of %d runs failed", report.Failed, len(report.Runs))
+	}
+	recordRun(run, batchErr)
+	return report, nil
+}
+
+// executeBatchRun runs one manifest run as 'cohort-bridge pprl', logging its output to result.Log
+func executeBatchRun(executable, batchID string, spec batch.Run, result *batchRunResult) error {
+	result.started = time.Now()
+	defer func() { result.finished = time.Now() }()
+
+	if err := os.MkdirAll(spec.Output, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	logFile, err := os.Create(result.Log)
+	if err != nil {
+		return fmt.Errorf("failed to create log: %w", err)
+	}
+	defer logFile.Close()
+
+	args := []string{"pprl", "-config", spec.Config, "-output-dir", spec.Output, "-force"}
+	if spec.Input != "" {
+		args = append(args, "-input", spec.Input)
+	}
+	if spec.Peer != "" {
+		args = append(args, "-peer", spec.Peer)
+	}
+	if spec.ListenPort != 0 {
+		args = append(args, "-listen-port", strconv.Itoa(spec.ListenPort))
+	}
+	if spec.HammingThreshold != 0 {
+		args = append(args, "-hamming-threshold", strconv.FormatUint(uint64(spec.HammingThreshold), 10))
+	}
+	if spec.JaccardThreshold != 0 {
+		args = append(args, "-jaccard-threshold", strconv.FormatFloat(spec.JaccardThreshold, 'g', -1, 64))
+	}
+
+	// Runs share this process's registry, so their records are found after the batch
+	registry := runRegistry
+	if registry == "" {
+		registry = "off"
+	}
+	cmd := exec.Command(executable, args...)
+	cmd.Env = append(os.Environ(), batchRunEnvVar+"="+batchID+"/"+spec.Name, store.PathEnvVar+"="+registry)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("pprl exited: %w", err)
+	}
+	return nil
+}
+
+// collectBatchRuns fills in the run IDs and match counts the runs recorded in the registry
+func collectBatchRuns(report *batchReport) {
+	if runRegistry == "" {
+		return
+	}
+	registry, err := store.Open(runRegistry)
+	if err != nil {
+		fmt.Printf("Warning: cannot read run registry: %v\n", err)
+		return
+	}
+	defer registry.Close()
+
+	runs, err := registry.List("pprl", 0)
+	if err != nil {
+		fmt.Printf("Warning: cannot read run registry: %v\n", err)
+		return
+	}
+	byName := make(map[string]*store.Run)
+	for _, run := range runs {
+		if id := run.Parameters["batch_run"]; id != "" {
+			if _, seen := byName[id]; !seen { // Newest first
+				byName[id] = run
+			}
+		}
+	}
+	for _, result := range report.Runs {
+		run, ok := byName[report.BatchID+"/"+result.Name]
+		if !ok {
+			continue
+		}
+		result.RunID = run.ID
+		result.Matches = run.Counts["matches"]
+		if result.Status == store.StatusFailed && run.Error != "" {
+			result.Error = run.Error
+		}
+	}
+}
+
+// printBatchReport prints one line per run and the batch totals
+func printBatchReport(report *batchReport) {
+	fmt.Println()
+	fmt.Println("Batch Summary")
+	fmt.Println("=============")
+	fmt.Printf("%-20s  %-9s  %8s  %10s  %s\n", "RUN", "STATUS", "MATCHES", "DURATION", "RUN ID")
+	for _, result := range report.Runs {
+		duration := "-"
+		if result.Status != batchStatusSkipped {
+			duration = result.finished.Sub(result.started).Round(time.Millisecond).String()
+		}
+		runID := result.RunID
+		if runID == "" {
+			runID = "-"
+		}
+		fmt.Printf("%-20s  %-9s  %8d  %10s  %s\n", result.Name, result.Status, result.Matches, duration, runID)
+		if result.Error != "" {
+			fmt.Printf("%-20s  error: %s\n", "", result.Error)
+		}
+	}
+	fmt.Println()
+	fmt.Printf("%d succeeded, %d failed, %d skipped; %d matches in %s\n", report.Succeeded, report.Failed, report.Skipped,
+		report.Matches, report.FinishedAt.Sub(report.StartedAt).Round(time.Millisecond))
+}
+
+func showBatchHelp() {
+	fmt.Println("CohortBridge Batch")
+	fmt.Println("==================")
+	fmt.Println()
+	fmt.Println("Run the PPRL workflow once for every run of a manifest, such as the same linkage")
+	fmt.Println("over a series of monthly extracts, and summarize the results across runs")
+	fmt.Println()
+	fmt.Println("USAGE:")
+	fmt.Println("  cohort-bridge batch -manifest runs.yaml [OPTIONS]")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Println("  -manifest <path>     Manifest listing the runs")
+	fmt.Println("  -concurrency <n>     Runs executed at once (default: the manifest's concurrency, else 1)")
+	fmt.Println("  -report <path>       Write the JSON summary report (default: the manifest's report)")
+	fmt.Println("  -dry-run             List the runs without starting them")
+	fmt.Println("  -force               Start without the confirmation prompt")
+	fmt.Println("  -help                Show this help message")
+	fmt.Println()
+	fmt.Println("MANIFEST:")
+	fmt.Println("  concurrency: 2                 # runs executed at once (default 1)")
+	fmt.Println("  continue_on_error: true        # false skips the runs not yet started after a failure")
+	fmt.Println("  report: batch_report.json")
+	fmt.Println("  defaults:                      # shared by every run")
+	fmt.Println("    config: config.yaml")
+	fmt.Println("    peer: site-b.example.org:8081")
+	fmt.Println("  runs:")
+	fmt.Println("    - name: 2026-01")
+	fmt.Println("      input: extracts/2026-01.csv")
+	fmt.Println("      output: results/2026-01      # default batch/<name>")
+	fmt.Println("      listen_port: 8082")
+	fmt.Println("      hamming_threshold: 20        # optional; jaccard_threshold likewise")
+	fmt.Println()
+	fmt.Println("  Relative paths are relative to the manifest. Each run is 'cohort-bridge pprl -force'")
+	fmt.Println("  with the run's settings; its output is logged to <output>/batch.log. Runs executed")
+	fmt.Println("  at once need their own listen_port, and the peer must run the matching batch.")
+	fmt.Println()
+	fmt.Println("EXAMPLES:")
+	fmt.Println("  # Preview the runs")
+	fmt.Println("  cohort-bridge batch -manifest runs.yaml -dry-run")
+	fmt.Println()
+	fmt.Println("  # Run two linkages at a time from cron")
+	fmt.Println("  cohort-bridge batch -manifest runs.yaml -concurrency 2 -force")
+}
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/crypto"
+	"github.com/auroradata-ai/cohort-bridge/internal/db"
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+	"github.com/auroradata-ai/cohort-bridge/internal/synth"
+)
+
+// benchFields are tokenized when neither the config nor the data names any; they match the
+// columns of synthetic datasets
+var benchFields = []string{"name:first_name", "name:last_name", "date:date_of_birth", "gender:gender", "zip:zip_code"}
+
+// benchGrid is the set of tokenization parameters a benchmark sweeps
+type benchGrid struct {
+	BloomSizes   []int
+	BloomHashes  []int
+	MinHashSizes []int
+	QGramLengths []int
+}
+
+// size is the number of parameter combinations in the grid
+func (g benchGrid) size() int {
+	return len(g.BloomSizes) * len(g.BloomHashes) * len(g.MinHashSizes) * len(g.QGramLengths)
+}
+
+// benchResult is the outcome of one parameter combination
+type benchResult struct {
+	BloomSize   int `json:"bloom_size"`
+	BloomHashes int `json:"bloom_hashes"`
+	MinHashSize int `json:"minhash_size"`
+	QGramLength int `json:"qgram_length"`
+
+	TokenizeRate float64 `json:"tokenize_records_per_sec"`  // Records tokenized per second, both datasets
+	MatchRate    float64 `json:"match_comparisons_per_sec"` // Record pairs compared per second by the intersection protocol
+	AllocMB      float64 `json:"alloc_mb"`                  // Memory allocated while tokenizing and matching
+	HeapMB       float64 `json:"heap_mb"`                   // Live heap holding both token sets
+	TokenBytes   float64 `json:"token_bytes_per_record"`    // Encoded Bloom filter and MinHash size, as sent to a peer
+
+	// Thresholds with the best F1 on the ground truth, and the protocol's scores at them
+	HammingThreshold uint32  `json:"hamming_threshold"`
+	JaccardThreshold float64 `json:"jaccard_threshold"`
+	Precision        float64 `json:"precision"`
+	Recall           float64 `json:"recall"`
+	F1               float64 `json:"f1"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// benchFlags are the flags of the bench command
+type benchFlags struct {
+	fs           *flag.FlagSet
+	configFile   *string
+	inputA       *string
+	inputB       *string
+	truthFile    *string
+	numRecords   *int
+	overlap      *float64
+	seed         *int64
+	bloomSizes   *string
+	bloomHashes  *string
+	minHashSizes *string
+	qgramLengths *string
+	jaccardGrid  *string
+	outputFile   *string
+	help         *bool
+}
+
+// newBenchFlags defines the flags of the bench command
+func newBenchFlags() *benchFlags {
+	fs := newFlagSet("bench")
+	return &benchFlags{
+		fs:           fs,
+		configFile:   fs.String("config", "", "Config supplying the recipe, fields and assignment (optional)"),
+		inputA:       fs.String("input-a", "", "Sample dataset A (CSV); synthetic data is generated when omitted"),
+		inputB:       fs.String("input-b", "", "Sample dataset B (CSV)"),
+		truthFile:    fs.String("ground-truth", "", "True A->B matches (CSV with id1,id2) for the sample datasets"),
+		numRecords:   fs.Int("records", 1000, "Records per synthetic dataset"),
+		overlap:      fs.Float64("overlap", 0.5, "Fraction of synthetic records present in both datasets"),
+		seed:         fs.Int64("seed", 42, "Random seed for synthetic data"),
+		bloomSizes:   fs.String("bloom-sizes", "500,1000,2000", "Bloom filter sizes in bits"),
+		bloomHashes:  fs.String("bloom-hashes", "3,5,7", "Bloom filter hash counts"),
+		minHashSizes: fs.String("minhash-sizes", "64,128", "MinHash signature sizes"),
+		qgramLengths: fs.String("qgram-lengths", "2,3", "Q-gram lengths"),
+		jaccardGrid:  fs.String("jaccard-grid", "0.1:0.9:0.05", "Jaccard thresholds swept for each combination (start:end:step)"),
+		outputFile:   fs.String("output", "out/bench_results.csv", "Results (CSV, or JSON with a .json extension)"),
+		help:         fs.Bool("help", false, "Show help message"),
+	}
+}
+
+func runBenchCommand(args []string) {
+	f := newBenchFlags()
+	f.fs.Parse(args)
+
+	if *f.help {
+		showBenchHelp()
+		return
+	}
+
+	grid, err := parseBenchGrid(*f.bloomSizes, *f.bloomHashes, *f.minHashSizes, *f.qgramLengths)
+	if err != nil {
+		fatalf(UsageError, "ERROR: %v", err)
+	}
+	jaccards, err := parseJaccardGrid(*f.jaccardGrid)
+	if err != nil {
+		fatalf(UsageError, "ERROR: invalid -jaccard-grid: %v", err)
+	}
+	sample := *f.inputA != "" || *f.inputB != "" || *f.truthFile != ""
+	if sample && (*f.inputA == "" || *f.inputB == "" || *f.truthFile == "") {
+		fatalf(UsageError, "ERROR: -input-a, -input-b and -ground-truth are needed together")
+	}
+	if !sample && (*f.numRecords <= 0 || *f.overlap < 0 || *f.overlap > 1) {
+		fatalf(UsageError, "ERROR: -records must be positive and -overlap between 0 and 1")
+	}
+
+	cfg := &config.Config{}
+	if *f.configFile != "" {
+		loaded, err := config.Load(*f.configFile)
+		if err != nil {
+			fatalf(ConfigError, "ERROR: Failed to load config: %v", err)
+		}
+		cfg = loaded
+	} else {
+		cfg.SetDefaults()
+	}
+	if len(cfg.Database.Fields) == 0 {
+		cfg.Database.Fields = benchFields
+	}
+
+	fmt.Println("CohortBridge Parameter Benchmark")
+	fmt.Println("================================")
+
+	run := startRun("bench", cfg)
+	run.Parameters["bloom_sizes"] = *f.bloomSizes
+	run.Parameters["bloom_hashes"] = *f.bloomHashes
+	run.Parameters["minhash_sizes"] = *f.minHashSizes
+	run.Parameters["qgram_lengths"] = *f.qgramLengths
+	fail := func(err error) {
+		recordRun(run, err)
+		fatalf(InternalError, "ERROR: Benchmark failed: %v", err)
+	}
+
+	var rowsA, rowsB []map[string]string
+	var truth groundTruth
+	if sample {
+		fmt.Printf("Datasets: %s, %s\n", *f.inputA, *f.inputB)
+		if rowsA, err = loadBenchRows(*f.inputA); err != nil {
+			fail(err)
+		}
+		if rowsB, err = loadBenchRows(*f.inputB); err != nil {
+			fail(err)
+		}
+		if truth, err = loadGroundTruth(*f.truthFile); err != nil {
+			fail(err)
+		}
+		run.AddInput(*f.inputA)
+		run.AddInput(*f.inputB)
+		run.AddInput(*f.truthFile)
+	} else {
+		fmt.Printf("Datasets: synthetic, %d records each, %.0f%% overlap, seed %d\n", *f.numRecords, *f.overlap*100, *f.seed)
+		rowsA, rowsB, truth, err = generateBenchRows(*f.numRecords, *f.overlap, *f.seed)
+		if err != nil {
+			fail(err)
+		}
+		run.Parameters["synthetic_records"] = strconv.Itoa(*f.numRecords)
+		run.Parameters["seed"] = strconv.FormatInt(*f.seed, 10)
+	}
+	fmt.Printf("Records: %d (A), %d (B)  True matches: %d\n", len(rowsA), len(rowsB), len(truth))
+	fmt.Printf("Grid: %d combinations; thresholds are swept for each and the best F1 reported\n", grid.size())
+	fmt.Println()
+
+	var results []*benchResult
+	for _, bloomSize := range grid.BloomSizes {
+		for _, hashes := range grid.BloomHashes {
+			for _, minHashSize := range grid.MinHashSizes {
+				for _, qgram := range grid.QGramLengths {
+					recipe := cfg.Tokenization
+					recipe.BloomSize, recipe.BloomHashes = uint32(bloomSize), uint32(hashes)
+					recipe.MinHashSize, recipe.QGramLength = uint32(minHashSize), qgram
+					fmt.Printf("[%d/%d] bloom_size=%d bloom_hashes=%d minhash_size=%d qgram_length=%d\n",
+						len(results)+1, grid.size(), bloomSize, hashes, minHashSize, qgram)
+
+					result, err := benchmarkRecipe(cfg, recipe, rowsA, rowsB, truth, jaccards)
+					if err != nil {
+						result.Error = err.Error()
+						fmt.Printf("   Failed: %v\n", err)
+					} else {
+						fmt.Printf("   F1 %.3f (Hamming %d, Jaccard %.2f)  %.0f records/s  %.0f comparisons/s  %.1f MB allocated\n",
+							result.F1, result.HammingThreshold, result.JaccardThreshold, result.TokenizeRate, result.MatchRate, result.AllocMB)
+					}
+					results = append(results, result)
+				}
+			}
+		}
+	}
+	fmt.Println()
+
+	ranked := rankBenchResults(results)
+	printBenchResults(ranked)
+
+	if err := writeBenchResults(ranked, *f.outputFile); err != nil {
+		fail(fmt.Errorf("failed to write results: %w", err))
+	}
+	fmt.Printf("Results saved to: %s\n", *f.outputFile)
+	run.AddOutput(*f.outputFile)
+	run.Counts["combinations"] = len(results)
+	run.Counts["records_a"] = len(rowsA)
+	run.Counts["records_b"] = len(rowsB)
+	run.Counts["true_matches"] = len(truth)
+	recordRun(run, nil)
+}
+
+// benchmarkRecipe tokenizes both datasets with recipe, finds the thresholds with the best F1 and
+// times the intersection protocol at them
+func benchmarkRecipe(cfg *config.Config, recipe config.TokenizationConfig, rowsA, rowsB []map[string]string, truth groundTruth, jaccards []float64) (*benchResult, error) {
+	result := &benchResult{
+		BloomSize:   int(recipe.BloomSize),
+		BloomHashes: int(recipe.BloomHashes),
+		MinHashSize: int(recipe.MinHashSize),
+		QGramLength: recipe.QGramLength,
+	}
+
+	recordConfig, err := newRecordConfig(recipe, cfg.Keys)
+	if err != nil {
+		return result, fmt.Errorf("invalid recipe: %v", err)
+	}
+	if recordConfig.Columns, err = newColumnMapping(cfg); err != nil {
+		return result, fmt.Errorf("invalid column mapping: %v", err)
+	}
+	recordConfig.IDs = nil // The ground truth names the original IDs
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	baseHeap := before.HeapAlloc
+
+	start := time.Now()
+	recordsA, bytesA, err := tokenizeBenchRows(rowsA, cfg.Database.Fields, recordConfig)
+	if err != nil {
+		return result, err
+	}
+	recordsB, bytesB, err := tokenizeBenchRows(rowsB, cfg.Database.Fields, recordConfig)
+	if err != nil {
+		return result, err
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	allocated := after.TotalAlloc - before.TotalAlloc
+	result.TokenizeRate = float64(len(recordsA)+len(recordsB)) / elapsed.Seconds()
+	result.TokenBytes = float64(bytesA+bytesB) / float64(len(recordsA)+len(recordsB))
+
+	// The Hamming sweep scales with the filter, as validate's default grid does for 1000 bits
+	hammings, err := parseHammingGrid(fmt.Sprintf("0:%d:%d", recipe.BloomSize/5, max(recipe.BloomSize/100, 1)))
+	if err != nil {
+		return result, err
+	}
+	pairs, _, err := scoreValidationPairs(recordsA, recordsB, truth, hardeningScale(recipe))
+	if err != nil {
+		return result, err
+	}
+	points := match.TuneThresholds(pairs, len(truth), hammings, jaccards, cfg.Matching.Assignment)
+	pairs = nil
+	best, err := match.BestOperatingPoint(points, match.TuneMaxF1, 0)
+	if err != nil {
+		return result, err
+	}
+	result.HammingThreshold, result.JaccardThreshold = best.HammingThreshold, best.JaccardThreshold
+
+	matcher := match.NewFuzzyMatcher(&match.FuzzyMatchConfig{
+		HammingThreshold: best.HammingThreshold,
+		JaccardThreshold: best.JaccardThreshold,
+		Assignment:       cfg.Matching.Assignment,
+		DistanceScale:    hardeningScale(recipe),
+	})
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start = time.Now()
+	var secureResult *crypto.PrivateIntersectionResult
+	discardStdout(func() {
+		secureResult, err = matcher.ComputePrivateIntersection(recordsA, recordsB)
+	})
+	if err != nil {
+		return result, fmt.Errorf("intersection failed: %v", err)
+	}
+	elapsed = time.Since(start)
+	runtime.ReadMemStats(&after)
+	allocated += after.TotalAlloc - before.TotalAlloc
+	result.MatchRate = float64(len(recordsA)*len(recordsB)) / elapsed.Seconds()
+	result.AllocMB = float64(allocated) / (1 << 20)
+
+	validation := validateResults(matcher.MatchResults(secureResult), nil, truth)
+	result.Precision, result.Recall, result.F1 = validation.Precision, validation.Recall, validation.F1Score
+
+	// What remains live since tokenization began is the two token sets
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	if after.HeapAlloc > baseHeap {
+		result.HeapMB = float64(after.HeapAlloc-baseHeap) / (1 << 20)
+	}
+	runtime.KeepAlive(recordsA)
+	runtime.KeepAlive(recordsB)
+	return result, nil
+}
+
+// tokenizeBenchRows tokenizes rows as tokenize does and returns the records with the total size of
+// their encoded tokens
+func tokenizeBenchRows(rows []map[string]string, fields []string, recordConfig *pprl.RecordConfig) ([]*pprl.Record, int, error) {
+	fieldNames, normalizationConfig := parseFieldsWithNormalization(fields)
+	tokenizer, err := newRecordTokenizer(fieldNames, recordConfig, normalizationConfig)
+	if err != nil {
+		return nil, 0, err
+	}
+	records := make([]*pprl.Record, 0, len(rows))
+	size := 0
+	for i, row := range rows {
+		tokens, err := tokenizer.row(row, fmt.Sprintf("record_%d", i+1))
+		if err != nil {
+			return nil, 0, err
+		}
+		if tokens == nil {
+			continue // No data in the fields
+		}
+		record, err := tokenRecordToPPRL(TokenRecord{ID: tokens[0], BloomFilter: tokens[1], MinHash: tokens[2]})
+		if err != nil {
+			return nil, 0, err
+		}
+		records = append(records, record)
+		size += len(tokens[1]) + len(tokens[2])
+	}
+	return records, size, nil
+}
+
+// loadBenchRows reads every row of a raw CSV dataset
+func loadBenchRows(filename string) ([]map[string]string, error) {
+	csvDB, err := db.NewCSVDatabase(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", filename, err)
+	}
+	return csvDB.List(0, 1<<30) // Load all records
+}
+
+// generateBenchRows generates a synthetic dataset pair with the synth command's corruption rates
+func generateBenchRows(numRecords int, overlap float64, seed int64) ([]map[string]string, []map[string]string, groundTruth, error) {
+	dataset, err := synth.Generate(synth.Config{
+		RecordsA:    numRecords,
+		RecordsB:    numRecords,
+		Overlap:     overlap,
+		Seed:        seed,
+		Corruptions: synth.DefaultCorruptions,
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	toMaps := func(rows [][]string) []map[string]string {
+		out := make([]map[string]string, len(rows))
+		for i, row := range rows {
+			out[i] = make(map[string]string, len(synth.Header))
+			for j, column := range synth.Header {
+				out[i][column] = row[j]
+			}
+		}
+		return out
+	}
+	truth := make(groundTruth, len(dataset.GroundTruth))
+	for _, pair := range dataset.GroundTruth {
+		truth[MatchPair{ID1: pair[0], ID2: pair[1]}] = true
+	}
+	return toMaps(dataset.RowsA), toMaps(dataset.RowsB), truth, nil
+}
+
+// discardStdout runs fn with standard output discarded, silencing the matcher's progress lines
+func discardStdout(fn func()) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		fn()
+		return
+	}
+	defer devNull.Close()
+	stdout := os.Stdout
+	os.Stdout = devNull
+	defer func() { os.Stdout = stdout }()
+	fn()
+}
+
+// parseBenchGrid parses the comma-separated parameter lists of the grid
+func parseBenchGrid(bloomSizes, bloomHashes, minHashSizes, qgramLengths string) (benchGrid, error) {
+	var grid benchGrid
+	for _, list := range []struct {
+		name  string
+		spec  string
+		into  *[]int
+		limit int
+	}{
+		{"-bloom-sizes", bloomSizes, &grid.BloomSizes, 8},
+		{"-bloom-hashes", bloomHashes, &grid.BloomHashes, 1},
+		{"-minhash-sizes", minHashSizes, &grid.MinHashSizes, 1},
+		{"-qgram-lengths", qgramLengths, &grid.QGramLengths, 1},
+	} {
+		for _, field := range strings.Split(list.spec, ",") {
+			value, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || value < list.limit {
+				return grid, fmt.Errorf("invalid %s value %q (expected integers >= %d)", list.name, field, list.limit)
+			}
+			*list.into = append(*list.into, value)
+		}
+	}
+	return grid, nil
+}
+
+// rankBenchResults orders results by F1, then by matching throughput; failed combinations go last
+func rankBenchResults(results []*benchResult) []*benchResult {
+	ranked := append([]*benchResult(nil), results...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if (a.Error == "") != (b.Error == "") {
+			return a.Error == ""
+		}
+		if a.F1 != b.F1 {
+			return a.F1 > b.F1
+		}
+		return a.MatchRate > b.MatchRate
+	})
+	return ranked
+}
+
+// printBenchResults prints the ranked results and the best recipe as a config snippet
+func printBenchResults(ranked []*benchResult) {
+	fmt.Println("Results (best F1 first):")
+	fmt.Println("   Bloom  Hashes  MinHash  Q     F1  Precision  Recall  Hamming  Jaccard  Records/s  Comparisons/s  Alloc MB  Heap MB  Bytes/rec")
+	for _, r := range ranked {
+		if r.Error != "" {
+			fmt.Printf("   %5d  %6d  %7d  %d  failed: %s\n", r.BloomSize, r.BloomHashes, r.MinHashSize, r.QGramLength, r.Error)
+			continue
+		}
+		fmt.Printf("   %5d  %6d  %7d  %d  %5.3f  %9.3f  %6.3f  %7d  %7.2f  %9.0f  %13.0f  %8.1f  %7.1f  %9.0f\n",
+			r.BloomSize, r.BloomHashes, r.MinHashSize, r.QGramLength, r.F1, r.Precision, r.Recall,
+			r.HammingThreshold, r.JaccardThreshold, r.TokenizeRate, r.MatchRate, r.AllocMB, r.HeapMB, r.TokenBytes)
+	}
+	fmt.Println()
+
+	if len(ranked) == 0 || ranked[0].Error != "" {
+		return
+	}
+	best := ranked[0]
+	fmt.Println("Best combination:")
+	fmt.Println()
+	fmt.Printf("   tokenization:\n     bloom_size: %d\n     bloom_hashes: %d\n     minhash_size: %d\n     qgram_length: %d\n",
+		best.BloomSize, best.BloomHashes, best.MinHashSize, best.QGramLength)
+	fmt.Printf("   matching:\n     hamming_threshold: %d\n     jaccard_threshold: %s\n",
+		best.HammingThreshold, strconv.FormatFloat(best.JaccardThreshold, 'f', -1, 64))
+	fmt.Println()
+	fmt.Println("   Both parties must tokenize with the same recipe; confirm the thresholds with")
+	fmt.Println("   'cohort-bridge validate -tune' on data closer to production.")
+	fmt.Println()
+}
+
+// writeBenchResults writes the ranked results as CSV, or as JSON for a .json file
+func writeBenchResults(ranked []*benchResult, filename string) error {
+	if dir := filepath.Dir(filename); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		data, err := json.MarshalIndent(ranked, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filename, append(data, '\n'), 0644)
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"bloom_size", "bloom_hashes", "minhash_size", "qgram_length", "f1", "precision", "recall",
+		"hamming_threshold", "jaccard_threshold", "tokenize_records_per_sec", "match_comparisons_per_sec",
+		"alloc_mb", "heap_mb", "token_bytes_per_record", "error"})
+	for _, r := range ranked {
+		writer.Write([]string{
+			strconv.Itoa(r.BloomSize),
+			strconv.Itoa(r.BloomHashes),
+			strconv.Itoa(r.MinHashSize),
+			strconv.Itoa(r.QGramLength),
+			strconv.FormatFloat(r.F1, 'f', 4, 64),
+			strconv.FormatFloat(r.Precision, 'f', 4, 64),
+			strconv.FormatFloat(r.Recall, 'f', 4, 64),
+			strconv.FormatUint(uint64(r.HammingThreshold), 10),
+			strconv.FormatFloat(r.JaccardThreshold, 'f', 3, 64),
+			strconv.FormatFloat(r.TokenizeRate, 'f', 1, 64),
+			strconv.FormatFloat(r.MatchRate, 'f', 1, 64),
+			strconv.FormatFloat(r.AllocMB, 'f', 2, 64),
+			strconv.FormatFloat(r.HeapMB, 'f', 2, 64),
+			strconv.FormatFloat(r.TokenBytes, 'f', 1, 64),
+			r.Error,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func showBenchHelp() {
+	fmt.Println("CohortBridge Parameter Benchmark")
+	fmt.Println("================================")
+	fmt.Println()
+	fmt.Println("Tokenize and match a sample dataset pair (or synthetic data) across a grid of")
+	fmt.Println("Bloom filter sizes, hash counts, MinHash sizes and q-gram lengths, and report")
+	fmt.Println("throughput, memory and F1 against ground truth for each combination")
+	fmt.Println()
+	fmt.Println("USAGE:")
+	fmt.Println("  cohort-bridge bench [OPTIONS]")
+	fmt.Println()
+	fmt.Println("DATA:")
+	fmt.Println("  -input-a string       Sample dataset A (raw CSV)")
+	fmt.Println("  -input-b string       Sample dataset B (raw CSV)")
+	fmt.Println("  -ground-truth string  True A->B matches (CSV with id1,id2)")
+	fmt.Println("  Without them, synthetic datasets are generated as 'cohort-bridge synth' does:")
+	fmt.Println("  -records int          Records per synthetic dataset (default: 1000)")
+	fmt.Println("  -overlap float        Fraction present in both (default: 0.5)")
+	fmt.Println("  -seed int             Random seed (default: 42)")
+	fmt.Println()
+	fmt.Println("GRID (comma-separated values):")
+	fmt.Println("  -bloom-sizes string   Bloom filter sizes in bits (default: 500,1000,2000)")
+	fmt.Println("  -bloom-hashes string  Bloom filter hash counts (default: 3,5,7)")
+	fmt.Println("  -minhash-sizes string MinHash signature sizes (default: 64,128)")
+	fmt.Println("  -qgram-lengths string Q-gram lengths (default: 2,3)")
+	fmt.Println("  -jaccard-grid string  Jaccard thresholds swept per combination (default: 0.1:0.9:0.05)")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Println("  -config string        Config supplying the rest of the recipe (seed, noise, unicode),")
+	fmt.Println("                        database.fields, column_mapping and matching.assignment")
+	fmt.Println("  -output string        Results, CSV or .json (default: out/bench_results.csv)")
+	fmt.Println("  -help                 Show this help message")
+	fmt.Println()
+	fmt.Println("MEASUREMENTS:")
+	fmt.Println("  For each combination both datasets are tokenized, every record pair is scored and")
+	fmt.Println("  the thresholds with the best F1 are found (Hamming from 0 to a fifth of the filter")
+	fmt.Println("  size); the intersection protocol then runs at those thresholds. Reported:")
+	fmt.Println("  - F1, precision and recall of the protocol's matches")
+	fmt.Println("  - records tokenized per second and record pairs compared per second")
+	fmt.Println("  - memory allocated while tokenizing and matching, and the live heap of the tokens")
+	fmt.Println("  - encoded token bytes per record, which sets the size of the peer exchange")
+	fmt.Println("  Throughput depends on the machine; compare combinations from the same run.")
+	fmt.Println()
+	fmt.Println("EXAMPLES:")
+	fmt.Println("  # Default grid on 1000 synthetic records per side")
+	fmt.Println("  cohort-bridge bench")
+	fmt.Println()
+	fmt.Println("  # A site's own sample with its recipe, narrower grid, JSON report")
+	fmt.Println("  cohort-bridge bench -config config.yaml -input-a a.csv -input-b b.csv -ground-truth truth.csv \\")
+	fmt.Println("    -bloom-sizes 1000,2000 -bloom-hashes 5 -output out/bench.json")
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/crypto"
+)
+
+// checkpointBlockSize is the number of local records compared between checkpoints
+const checkpointBlockSize = 1000
+
+const checkpointVersion = 1
+
+// checkpointState is saved to <base>.checkpoint after each block of local records
+type checkpointState struct {
+	Version      int       `json:"version"`
+	Inputs       string    `json:"inputs"`        // Digest of the datasets and settings; a mismatch discards the checkpoint
+	NextLocal    int       `json:"next_local"`    // Local records before this index are done
+	PartialBytes int64     `json:"partial_bytes"` // Length of <base>.partial covered by this state
+	Candidates   int       `json:"candidates"`    // Candidate pairs in that part of <base>.partial
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// intersectionCheckpoint persists the progress of a long intersection: the next local record to
+// compare and, in <base>.partial, the candidate pairs found so far (before 1:1 assignment)
+type intersectionCheckpoint struct {
+	statePath   string
+	partialPath string
+	state       checkpointState
+	partial     *os.File
+	candidates  []crypto.PrivateMatchPair // Loaded from an earlier run
+}
+
+// inputsDigest identifies what is being intersected, so a checkpoint is only resumed for the same work
+func inputsDigest(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(h, "%d:%s\n", len(part), part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// openCheckpoint prepares the checkpoint files for base. With resume, progress saved for the same
+// inputs is loaded; otherwise (or if none matches) the intersection starts from the beginning.
+func openCheckpoint(base, inputs string, resume bool) (*intersectionCheckpoint, error) {
+	c := &intersectionCheckpoint{
+		statePath:   base + ".checkpoint",
+		partialPath: base + ".partial",
+		state:       checkpointState{Version: checkpointVersion, Inputs: inputs},
+	}
+
+	if resume {
+		if err := c.load(); err != nil {
+			fmt.Printf("   No usable checkpoint (%v); starting from the beginning\n", err)
+			c.state = checkpointState{Version: checkpointVersion, Inputs: inputs}
+			c.candidates = nil
+		}
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if c.state.NextLocal == 0 {
+		flags |= os.O_TRUNC
+		os.Remove(c.statePath) // Any earlier checkpoint no longer matches the partial file
+	}
+	partial, err := os.OpenFile(c.partialPath, flags, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint file: %w", err)
+	}
+	// Pairs written after the last saved state are dropped; their block is compared again
+	if err := partial.Truncate(c.state.PartialBytes); err != nil {
+		partial.Close()
+		return nil, fmt.Errorf("failed to open checkpoint file: %w", err)
+	}
+	if _, err := partial.Seek(c.state.PartialBytes, io.SeekStart); err != nil {
+		partial.Close()
+		return nil, fmt.Errorf("failed to open checkpoint file: %w", err)
+	}
+	c.partial = partial
+	return c, nil
+}
+
+// load reads the saved state and its candidate pairs
+func (c *intersectionCheckpoint) load() error {
+	data, err := os.ReadFile(c.statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no checkpoint at %s", c.statePath)
+		}
+		return err
+	}
+	var state checkpointState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("invalid checkpoint %s: %w", c.statePath, err)
+	}
+	if state.Version != checkpointVersion {
+		return fmt.Errorf("checkpoint %s has unsupported version %d", c.statePath, state.Version)
+	}
+	if state.Inputs != c.state.Inputs {
+		return fmt.Errorf("checkpoint %s was saved for different datasets or settings", c.statePath)
+	}
+
+	file, err := os.Open(c.partialPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := csv.NewReader(io.LimitReader(file, state.PartialBytes))
+	reader.FieldsPerRecord = 4
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid checkpoint %s: %w", c.partialPath, err)
+		}
+		hamming, err1 := strconv.ParseUint(row[2], 10, 32)
+		jaccard, err2 := strconv.ParseFloat(row[3], 64)
+		if err1 != nil || err2 != nil {
+			return fmt.Errorf("invalid checkpoint %s: bad scores for %s/%s", c.partialPath, row[0], row[1])
+		}
+		c.candidates = append(c.candidates, crypto.NewScoredMatchPair(row[0], row[1], uint32(hamming), jaccard))
+	}
+	if len(c.candidates) != state.Candidates {
+		return fmt.Errorf("checkpoint %s is incomplete (%d of %d candidate pairs)", c.partialPath, len(c.candidates), state.Candidates)
+	}
+	c.state = state
+	return nil
+}
+
+// progress returns the intersection progress to resume from, saving each completed block
+func (c *intersectionCheckpoint) progress() *crypto.IntersectionProgress {
+	return &crypto.IntersectionProgress{
+		NextLocal:  c.state.NextLocal,
+		Candidates: c.candidates,
+		BlockSize:  checkpointBlockSize,
+		OnBlock:    c.save,
+	}
+}
+
+// save appends a block's candidate pairs to the partial file and then records the new state,
+// so a state never refers to pairs that were not written
+func (c *intersectionCheckpoint) save(nextLocal int, candidates []crypto.PrivateMatchPair) error {
+	var buf strings.Builder
+	writer := csv.NewWriter(&buf)
+	for _, pair := range candidates {
+		hamming, jaccard := pair.Scores()
+		writer.Write([]string{pair.LocalID, pair.PeerID, strconv.FormatUint(uint64(hamming), 10), strconv.FormatFloat(jaccard, 'g', -1, 64)})
+	}
+	writer.Flush()
+	if _, err := c.partial.WriteString(buf.String()); err != nil {
+		return err
+	}
+	if err := c.partial.Sync(); err != nil {
+		return err
+	}
+
+	c.state.NextLocal = nextLocal
+	c.state.PartialBytes += int64(buf.Len())
+	c.state.Candidates += len(candidates)
+	c.state.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(c.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.statePath)
+}
+
+// Close closes the partial file, keeping the checkpoint for -resume
+func (c *intersectionCheckpoint) Close() {
+	c.partial.Close()
+}
+
+// Remove deletes the checkpoint once the intersection has completed
+func (c *intersectionCheckpoint) Remove() {
+	c.partial.Close()
+	os.Remove(c.partialPath)
+	os.Remove(c.statePath)
+}
+
+// resumeTokens are the padded tokens a pprl run sent to its peer. A resumed run sends the same
+// tokens, decoys included, so both peers see the exchange they checkpointed and can continue.
+type resumeTokens struct {
+	Source string     `json:"source"` // Digest of the unpadded local tokens and the recipe
+	Tokens *TokenData `json:"tokens"`
+	Decoys []string   `json:"decoys,omitempty"`
+}
+
+// saveResumeTokens writes the tokens sent to the peer; they stay local, like the token files
+func saveResumeTokens(path, source string, tokens *TokenData, decoys map[string]bool) error {
+	saved := &resumeTokens{Source: source, Tokens: tokens}
+	for id := range decoys {
+		saved.Decoys = append(saved.Decoys, id)
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// loadResumeTokens reads the tokens saved by an earlier run from the same local tokens
+func loadResumeTokens(path, source string) (*TokenData, map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var saved resumeTokens
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	if saved.Source != source || saved.Tokens == nil {
+		return nil, nil, fmt.Errorf("%s was saved for a different dataset or recipe", path)
+	}
+	decoys := make(map[string]bool, len(saved.Decoys))
+	for _, id := range saved.Decoys {
+		decoys[id] = true
+	}
+	return saved.Tokens, decoys, nil
+}
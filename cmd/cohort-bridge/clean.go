@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// cleanFlags are the flags of the clean command
+type cleanFlags struct {
+	fs         *flag.FlagSet
+	configFile *string
+	dir        *string
+	olderThan  *time.Duration
+	dryRun     *bool
+	force      *bool
+	help       *bool
+}
+
+// newCleanFlags defines the flags of the clean command
+func newCleanFlags() *cleanFlags {
+	fs := newFlagSet("clean")
+	return &cleanFlags{
+		fs:         fs,
+		configFile: fs.String("config", "config.yaml", "Configuration file with the workspace section"),
+		dir:        fs.String("dir", "", "Workspace root to clean (overrides workspace.dir)"),
+		olderThan:  fs.Duration("older-than", 0, "Only purge workspaces created longer ago than this"),
+		dryRun:     fs.Bool("dry-run", false, "List the stale workspaces without removing them"),
+		force:      fs.Bool("force", false, "Skip the confirmation prompt"),
+		help:       fs.Bool("help", false, "Show help message"),
+	}
+}
+
+func runCleanCommand(args []string) {
+	f := newCleanFlags()
+	f.fs.Parse(args)
+
+	if *f.help {
+		showCleanHelp()
+		return
+	}
+
+	cfg := loadMainConfig(*f.configFile)
+	if *f.dir != "" {
+		cfg.Workspace.Dir = *f.dir
+	}
+	root, err := workspaceRoot(cfg)
+	if err != nil {
+		fatalf(ConfigError, "ERROR: %v", err)
+	}
+
+	found, err := findWorkspaces(root, ".")
+	if err != nil {
+		fatalf(DataError, "ERROR: %v", err)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Created.Before(found[j].Created) })
+
+	var stale []workspaceEntry
+	active := 0
+	var total int64
+	for _, entry := range found {
+		switch {
+		case entry.active():
+			active++
+		case *f.olderThan > 0 && time.Since(entry.Created) < *f.olderThan:
+		default:
+			stale = append(stale, entry)
+			total += entry.Size
+		}
+	}
+
+	fmt.Printf("Workspace root: %s\n", root)
+	if active > 0 {
+		fmt.Printf("In use: %d workspace(s) of running processes (left alone)\n", active)
+	}
+	if len(stale) == 0 {
+		fmt.Println("No stale workspaces")
+		return
+	}
+	fmt.Printf("Stale: %d workspace(s), %s\n", len(stale), formatByteSize(total))
+	for _, entry := range stale {
+		fmt.Printf("  %-60s %-10s %s  %s\n", entry.Path, entry.Command, entry.Created.Local().Format("2006-01-02 15:04"), formatByteSize(entry.Size))
+	}
+	if *f.dryRun {
+		return
+	}
+
+	if !confirmStep(fmt.Sprintf("Securely delete %d stale workspace(s)? They may hold token data from earlier runs.", len(stale)), *f.force) {
+		fmt.Println("Clean cancelled")
+		return
+	}
+	failed := 0
+	for _, entry := range stale {
+		if err := secureRemoveAll(entry.Path); err != nil {
+			fmt.Printf("  Warning: %s: %v\n", entry.Path, err)
+			failed++
+		}
+	}
+	fmt.Printf("Removed %d workspace(s)\n", len(stale)-failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// formatByteSize formats a size in bytes with a binary unit, such as 1.5 MiB
+func formatByteSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func showCleanHelp() {
+	fmt.Println("CohortBridge Workspace Cleanup")
+	fmt.Println("==============================")
+	fmt.Println()
+	fmt.Println("Securely deletes stale per-run workspaces: the temporary directories of")
+	fmt.Println("pprl, validate and downloaded inputs whose process is no longer running,")
+	fmt.Println("such as workspaces kept by -debug or workspace.keep, or left by a crash.")
+	fmt.Println("Files are overwritten before removal, as they may hold token data.")
+	fmt.Println("The temp-workflow-*, temp-sender and temp_validation_tokens_*.csv leftovers")
+	fmt.Println("of earlier releases in the current directory are removed as well.")
+	fmt.Println()
+	fmt.Println("USAGE:")
+	fmt.Println("  cohort-bridge clean [OPTIONS]")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Println("  -config string        Configuration file (default: config.yaml)")
+	fmt.Println("  -dir string           Workspace root (default: workspace.dir, or the system")
+	fmt.Println("                        temp directory)")
+	fmt.Println("  -older-than duration  Only purge workspaces created longer ago than this")
+	fmt.Println("  -dry-run              List stale workspaces without removing them")
+	fmt.Println("  -force                Skip the confirmation prompt")
+	fmt.Println("  -help                 Show this help message")
+	fmt.Println()
+	fmt.Println("CONFIGURATION:")
+	fmt.Println("  workspace:")
+	fmt.Println("    dir: /var/tmp/cohort-bridge   # where workspaces are created")
+	fmt.Println("    keep: false                   # keep them after each run (as -debug does)")
+	fmt.Println()
+	fmt.Println("EXAMPLES:")
+	fmt.Println("  cohort-bridge clean -dry-run")
+	fmt.Println("  cohort-bridge clean -older-than 24h -force")
+}
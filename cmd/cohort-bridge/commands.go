@@ -0,0 +1,214 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+)
+
+// command is a cohort-bridge subcommand. The dispatcher, the main help and the interactive menu
+// are all generated from the commands table, so a command is added in one place.
+type command struct {
+	name    string
+	summary string              // One line for the main help
+	run     func(args []string) // Runs the command with the arguments after its name
+	help    func()              // Prints the command's help
+	menu    string              // Entry in the interactive menu ("" leaves the command out)
+	actions []string            // Actions named right after the command, as in "keys rotate"
+
+	// flags defines the command's flags, or those of one of its actions, without running it; the
+	// shell completions and man pages are generated from it
+	flags func(action string) *flag.FlagSet
+
+	porcelain bool // Prints a summary for the global -porcelain flag
+}
+
+// commands lists the subcommands in the order of the main help; it is filled in init because
+// the commands themselves print the main help
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "init", summary: "Generate a validated config for one party of a linkage", run: runInitCommand, help: showInitHelp,
+			flags: func(string) *flag.FlagSet { return newInitFlags().fs }},
+		{name: "tokenize", summary: "Convert PHI data to privacy-preserving tokens", run: runTokenizeCommand, help: showTokenizeHelp,
+			flags: func(string) *flag.FlagSet { return newTokenizeFlags().fs },
+			menu:  "Tokenize - Convert PHI data to privacy-preserving tokens", porcelain: true},
+		{name: "decrypt", summary: "Decrypt encrypted tokenized files", run: runDecryptCommand, help: showDecryptHelp,
+			flags: func(string) *flag.FlagSet { return newDecryptFlags().fs },
+			menu:  "Decrypt - Decrypt encrypted tokenized files"},
+		{name: "keys", summary: "Manage, rotate and inspect encryption keys", run: runKeysCommand, help: showKeysHelp,
+			flags:   func(action string) *flag.FlagSet { return newKeysFlags(action).fs },
+			actions: []string{"list", "rotate", "prune", "inspect", "store-keychain", "signing-keygen", "signing-pubkey"}},
+		{name: "intersect", summary: "Find matches between tokenized datasets", run: runIntersectCommand, help: showZKIntersectHelp,
+			flags: func(string) *flag.FlagSet { return newIntersectFlags().fs },
+			menu:  "Intersect - Find matches between tokenized datasets", porcelain: true},
+		{name: "index", summary: "Save a reusable index of a stable dataset and match new batches against it", run: runIndexCommand, help: showIndexHelp,
+			flags: indexFlags, actions: []string{"build", "query"}},
+		{name: "delta", summary: "Match records changed since the last run and merge them into its crosswalk", run: runDeltaCommand, help: showDeltaHelp,
+			flags: func(string) *flag.FlagSet { return newDeltaFlags().fs }},
+		{name: "dedupe", summary: "Cluster duplicate records within one tokenized dataset", run: runDedupeCommand, help: showDedupeHelp,
+			flags: func(string) *flag.FlagSet { return newDedupeFlags().fs }},
+		{name: "profile", summary: "Report data quality of a raw dataset before tokenization", run: runProfileCommand, help: showProfileHelp,
+			flags: func(string) *flag.FlagSet { return newProfileFlags().fs }},
+		{name: "preview", summary: "Show the first records of a raw or tokenized file with PHI masked", run: runPreviewCommand, help: showPreviewHelp,
+			flags: func(string) *flag.FlagSet { return newPreviewFlags().fs }},
+		{name: "validate", summary: "Test results against ground truth", run: runValidateCommand, help: showValidateHelp,
+			flags: func(string) *flag.FlagSet { return newValidateFlags().fs },
+			menu:  "Validate - Test results against ground truth", porcelain: true},
+		{name: "pprl", summary: "Peer-to-peer privacy-preserving record linkage", run: runPPRLCommand, help: showPPRLHelp,
+			flags: func(string) *flag.FlagSet { return newPPRLFlags().fs },
+			menu:  "PPRL - Peer-to-peer privacy-preserving record linkage"},
+		{name: "ping", summary: "Check connectivity, auth and recipe compatibility with the peer", run: runPingCommand, help: showPingHelp,
+			flags: func(string) *flag.FlagSet { return newPingFlags().fs }},
+		{name: "batch", summary: "Run the PPRL workflow for every run of a manifest", run: runBatchCommand, help: showBatchHelp,
+			flags: func(string) *flag.FlagSet { return newBatchFlags().fs }},
+		{name: "selftest", summary: "Run an end-to-end two-party check on synthetic data", run: runSelftestCommand, help: showSelftestHelp,
+			flags: func(string) *flag.FlagSet { return newSelftestFlags().fs }},
+		{name: "simulate", summary: "Run two parties' pprl workflow in one process over loopback", run: runSimulateCommand, help: showSimulateHelp,
+			flags: func(string) *flag.FlagSet { return newSimulateFlags().fs }},
+		{name: "synth", summary: "Generate paired synthetic datasets with ground truth", run: runSynthCommand, help: showSynthHelp,
+			flags: func(string) *flag.FlagSet { return newSynthFlags().fs }},
+		{name: "bench", summary: "Benchmark tokenization and matching parameters against ground truth", run: runBenchCommand, help: showBenchHelp,
+			flags: func(string) *flag.FlagSet { return newBenchFlags().fs }},
+		{name: "audit-transcript", summary: "Validate a recorded peer message transcript", run: runAuditTranscriptCommand, help: showAuditTranscriptHelp,
+			flags: func(string) *flag.FlagSet { return newAuditTranscriptFlags().fs }},
+		{name: "serve", summary: "Run a long-lived receiver daemon with a REST API", run: runServeCommand, help: showServeHelp,
+			flags: func(string) *flag.FlagSet { return newServeFlags().fs }},
+		{name: "intersect-api", summary: "Serve intersect over an HTTP API for two submitted token files", run: runIntersectAPICommand, help: showIntersectAPIHelp,
+			flags: func(string) *flag.FlagSet { return newIntersectAPIFlags().fs }},
+		{name: "relay", summary: "Broker pprl sessions between parties that cannot accept connections", run: runRelayCommand, help: showRelayHelp,
+			flags: func(string) *flag.FlagSet { return newRelayFlags().fs }},
+		{name: "runs", summary: "List and inspect past tokenize/intersect/pprl runs", run: runRunsCommand, help: showRunsHelp,
+			flags:   func(action string) *flag.FlagSet { return newRunsFlags(action).fs },
+			actions: []string{"list", "show"}},
+		{name: "export", summary: "Write a linkage-ID crosswalk from match results", run: runExportCommand, help: showExportHelp,
+			flags: func(string) *flag.FlagSet { return newExportFlags().fs }},
+		{name: "clean", summary: "Securely delete stale temporary workspaces", run: runCleanCommand, help: showCleanHelp,
+			flags: func(string) *flag.FlagSet { return newCleanFlags().fs }},
+		{name: "completion", summary: "Print a bash, zsh or fish completion script", run: runCompletionCommand, help: showCompletionHelp,
+			flags:   func(action string) *flag.FlagSet { return newCompletionFlags(action).fs },
+			actions: []string{"bash", "zsh", "fish"}},
+		{name: "man", summary: "Write man pages for cohort-bridge and its subcommands", run: runManCommand, help: showManHelp,
+			flags: func(string) *flag.FlagSet { return newManFlags().fs }},
+	}
+}
+
+// findCommand returns the subcommand called name, or nil
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// newFlagSet creates the flag set of a subcommand ("keys rotate" for an action of keys). A flag
+// error or -h prints the command's help rather than the bare flag list.
+func newFlagSet(name string) *flag.FlagSet {
+	handling := flag.ExitOnError
+	if porcelain != nil {
+		handling = flag.ContinueOnError // parseFlags reports a bad flag in the summary
+	}
+	fs := flag.NewFlagSet(name, handling)
+	if cmd := findCommand(strings.Fields(name)[0]); cmd != nil {
+		fs.Usage = cmd.help
+	}
+	return fs
+}
+
+// parseFlags parses args into fs; under -porcelain a bad flag fails with a summary, as any
+// other usage error does
+func parseFlags(fs *flag.FlagSet, args []string) {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		fatalf(UsageError, "Error: %v", err)
+	}
+}
+
+// thresholdFlags are the matching threshold flags of the commands that match records. Zero
+// leaves the threshold to the config, and then to config.DefaultHammingThreshold and
+// config.DefaultJaccardThreshold.
+type thresholdFlags struct {
+	hamming uint
+	jaccard float64
+}
+
+// addThresholdFlags defines -hamming-threshold and -jaccard-threshold on fs
+func addThresholdFlags(fs *flag.FlagSet) *thresholdFlags {
+	t := &thresholdFlags{}
+	fs.UintVar(&t.hamming, "hamming-threshold", 0,
+		fmt.Sprintf("Maximum Hamming distance of a match (default: matching.hamming_threshold or %d)", config.DefaultHammingThreshold))
+	fs.Float64Var(&t.jaccard, "jaccard-threshold", 0,
+		fmt.Sprintf("Minimum Jaccard similarity of a match (default: matching.jaccard_threshold or %g)", config.DefaultJaccardThreshold))
+	return t
+}
+
+// resolve resolves the thresholds from the flags and the loaded configs (flag > config > default)
+func (t *thresholdFlags) resolve(configs ...*config.Config) config.Thresholds {
+	return config.ResolveThresholds(uint32(t.hamming), t.jaccard, configs...)
+}
+
+// retentionFlags are the result retention flags of the commands that write match results. Unset
+// flags leave matching.max_matches_per_record and matching.min_score to the config.
+type retentionFlags struct {
+	maxMatches int
+	minScore   float64
+}
+
+// addRetentionFlags defines -max-matches-per-record and -min-score on fs
+func addRetentionFlags(fs *flag.FlagSet) *retentionFlags {
+	r := &retentionFlags{}
+	fs.IntVar(&r.maxMatches, "max-matches-per-record", 0,
+		fmt.Sprintf("1:many matching: most matches kept per record (default: matching.max_matches_per_record or %d, -1 = no limit)", config.DefaultMaxMatchesPerRecord))
+	fs.Float64Var(&r.minScore, "min-score", -1, "Leave out matches below this Jaccard similarity (default: matching.min_score)")
+	return r
+}
+
+// apply overrides the config's retention settings with the flags that were set
+func (r *retentionFlags) apply(cfg *config.Config) {
+	if r.maxMatches != 0 {
+		cfg.Matching.MaxMatchesPerRecord = r.maxMatches
+	}
+	if r.minScore >= 0 {
+		cfg.Matching.MinScore = r.minScore
+	}
+}
+
+// histogramFlags are the score histogram flags of the commands that compare records
+type histogramFlags struct {
+	file       string
+	hammingBin uint
+	jaccardBin float64
+}
+
+// addHistogramFlags defines -histogram, -histogram-hamming-bin and -histogram-jaccard-bin on fs
+func addHistogramFlags(fs *flag.FlagSet) *histogramFlags {
+	h := &histogramFlags{}
+	fs.StringVar(&h.file, "histogram", "", "Write a histogram of the Hamming distances and Jaccard similarities of every comparison (.csv or .json)")
+	fs.UintVar(&h.hammingBin, "histogram-hamming-bin", match.DefaultHammingBinWidth, "Hamming distance bin width of -histogram")
+	fs.Float64Var(&h.jaccardBin, "histogram-jaccard-bin", match.DefaultJaccardBinWidth, "Jaccard similarity bin width of -histogram")
+	return h
+}
+
+// histogram returns an empty histogram with the flags' bin widths, or nil without -histogram
+func (h *histogramFlags) histogram() (*match.ScoreHistogram, error) {
+	if h.file == "" {
+		return nil, nil
+	}
+	if h.hammingBin == 0 || h.hammingBin > math.MaxUint32 {
+		return nil, fmt.Errorf("-histogram-hamming-bin must be between 1 and %d", uint32(math.MaxUint32))
+	}
+	if h.jaccardBin <= 0 || h.jaccardBin > 1 {
+		return nil, fmt.Errorf("-histogram-jaccard-bin must be above 0 and at most 1")
+	}
+	return match.NewScoreHistogram(uint32(h.hammingBin), h.jaccardBin)
+}
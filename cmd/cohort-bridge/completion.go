@@ -0,0 +1,536 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// cliFlag is a flag as the shell completions and man pages show it
+type cliFlag struct {
+	Name    string
+	Value   string   // Name of the flag's value ("string", "int", ...); "" for a boolean flag
+	Usage   string   // One-line description
+	Default string   // Default value, "" when there is none worth showing
+	Choices []string // Values to complete, when the flag takes one of a few
+}
+
+// cliCommand is a subcommand with the flags it accepts: flags, or for a command taking an
+// action, actionFlags in the order of its actions
+type cliCommand struct {
+	*command
+	flags       []cliFlag
+	actionFlags [][]cliFlag
+}
+
+// globalFlags are the flags parseGlobalFlags accepts before or after any subcommand
+var globalFlags = []cliFlag{
+	{Name: "yes", Usage: "Answer confirmation prompts with yes and fail instead of prompting for anything else"},
+	{Name: "non-interactive", Usage: "Alias of -yes"},
+	{Name: "log-format", Value: "fmt", Usage: "Error output: text or json, one object on stderr", Default: "text", Choices: []string{"text", "json"}},
+	{Name: "porcelain", Usage: "tokenize, intersect and validate: print only a stable summary to stdout (-porcelain=kv or -porcelain=json)"},
+	{Name: "help", Usage: "Show help"},
+	{Name: "version", Usage: "Show version information"},
+}
+
+// commandFlags returns the flags of a subcommand, or of one of its actions. They are read off
+// the flag set the command itself parses, so the completions and man pages cannot drift from
+// what the command accepts.
+func commandFlags(cmd *command, action string) []cliFlag {
+	var flags []cliFlag
+	cmd.flags(action).VisitAll(func(f *flag.Flag) {
+		value, usage := flag.UnquoteUsage(f)
+		described := cliFlag{Name: f.Name, Value: value, Usage: strings.Join(strings.Fields(usage), " ")}
+		switch f.DefValue {
+		case "", "false", "0", "0s":
+		default:
+			described.Default = f.DefValue
+		}
+		flags = append(flags, described)
+	})
+	return flags
+}
+
+// cliCommands lists the subcommands with their flags, in the order of the main help
+func cliCommands() []cliCommand {
+	list := make([]cliCommand, len(commands))
+	for i := range commands {
+		cmd := &commands[i]
+		list[i].command = cmd
+		if len(cmd.actions) == 0 {
+			list[i].flags = commandFlags(cmd, "")
+		}
+		for _, action := range cmd.actions {
+			list[i].actionFlags = append(list[i].actionFlags, commandFlags(cmd, action))
+		}
+	}
+	return list
+}
+
+// completionFlags are the flags of an action of completion
+type completionFlags struct {
+	fs   *flag.FlagSet
+	help *bool
+}
+
+// newCompletionFlags defines the flags of an action of completion
+func newCompletionFlags(shell string) *completionFlags {
+	fs := newFlagSet("completion " + shell)
+	return &completionFlags{
+		fs:   fs,
+		help: fs.Bool("help", false, "Show help message"),
+	}
+}
+
+func runCompletionCommand(args []string) {
+	if len(args) == 0 || args[0] == "-help" || args[0] == "--help" || args[0] == "help" {
+		showCompletionHelp()
+		return
+	}
+
+	shell := args[0]
+	f := newCompletionFlags(shell)
+	parseFlags(f.fs, args[1:])
+
+	if *f.help {
+		showCompletionHelp()
+		return
+	}
+
+	var script string
+	switch shell {
+	case "bash":
+		script = bashCompletion(cliCommands())
+	case "zsh":
+		script = zshCompletion(cliCommands())
+	case "fish":
+		script = fishCompletion(cliCommands())
+	default:
+		showCompletionHelp()
+		fatalf(UsageError, "Unknown shell: %s (use bash, zsh or fish)", shell)
+	}
+	fmt.Print(script)
+}
+
+// flagWords returns the flags as the shell types them
+func flagWords(flags []cliFlag) []string {
+	words := make([]string, len(flags))
+	for i, f := range flags {
+		words[i] = "-" + f.Name
+	}
+	return words
+}
+
+// commandNames returns the names of the subcommands
+func commandNames() []string {
+	names := make([]string, len(commands))
+	for i, cmd := range commands {
+		names[i] = cmd.name
+	}
+	return names
+}
+
+func bashCompletion(list []cliCommand) string {
+	var b strings.Builder
+	b.WriteString("# bash completion for cohort-bridge, generated by 'cohort-bridge completion bash'\n\n")
+	b.WriteString("_cohort_bridge() {\n")
+	b.WriteString("    local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	b.WriteString("    local command=\"\" action=\"\" names=\"\" words=\"\" i\n")
+	b.WriteString("    for ((i = 1; i < COMP_CWORD; i++)); do\n")
+	b.WriteString("        if [[ -z $command ]]; then\n")
+	b.WriteString("            case \"${COMP_WORDS[i]}\" in\n")
+	fmt.Fprintf(&b, "                %s) command=\"${COMP_WORDS[i]}\" ;;\n", strings.Join(commandNames(), "|"))
+	b.WriteString("            esac\n")
+	b.WriteString("        elif [[ -z $action ]]; then\n")
+	b.WriteString("            action=\"${COMP_WORDS[i]}\"\n")
+	b.WriteString("        fi\n")
+	b.WriteString("    done\n\n")
+
+	b.WriteString("    case \"$prev\" in\n")
+	for _, f := range globalFlags {
+		if len(f.Choices) > 0 {
+			fmt.Fprintf(&b, "        -%s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")); return ;;\n", f.Name, strings.Join(f.Choices, " "))
+		}
+	}
+	b.WriteString("    esac\n\n")
+
+	b.WriteString("    case \"$command\" in\n")
+	fmt.Fprintf(&b, "        \"\") names=1; words=\"%s\" ;;\n", strings.Join(append(commandNames(), flagWords(globalFlags)...), " "))
+	for _, cmd := range list {
+		if len(cmd.actions) == 0 {
+			fmt.Fprintf(&b, "        %s) words=\"%s\" ;;\n", cmd.name, strings.Join(flagWords(cmd.flags), " "))
+			continue
+		}
+		fmt.Fprintf(&b, "        %s)\n", cmd.name)
+		b.WriteString("            case \"$action\" in\n")
+		fmt.Fprintf(&b, "                \"\") names=1; words=\"%s\" ;;\n", strings.Join(cmd.actions, " "))
+		for i, action := range cmd.actions {
+			fmt.Fprintf(&b, "                %s) words=\"%s\" ;;\n", action, strings.Join(flagWords(cmd.actionFlags[i]), " "))
+		}
+		b.WriteString("            esac ;;\n")
+	}
+	b.WriteString("    esac\n\n")
+
+	b.WriteString("    # Anything else, such as the value of a flag, completes as a file name\n")
+	b.WriteString("    if [[ -n $names || $cur == -* ]]; then\n")
+	b.WriteString("        COMPREPLY=($(compgen -W \"$words\" -- \"$cur\"))\n")
+	b.WriteString("    fi\n")
+	b.WriteString("}\n\n")
+	b.WriteString("complete -o default -F _cohort_bridge cohort-bridge\n")
+	return b.String()
+}
+
+// zshQuote quotes s as one single-quoted zsh word
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// zshSpecs returns the _arguments specs of flags; a flag taking a string completes file names
+func zshSpecs(flags []cliFlag) string {
+	specs := make([]string, len(flags))
+	for i, f := range flags {
+		spec := "-" + f.Name + "[" + strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`).Replace(f.Usage) + "]"
+		switch {
+		case len(f.Choices) > 0:
+			spec += ":" + f.Value + ":(" + strings.Join(f.Choices, " ") + ")"
+		case f.Value == "string":
+			spec += ":" + f.Value + ":_files"
+		case f.Value != "":
+			spec += ":" + f.Value + ": "
+		}
+		specs[i] = zshQuote(spec)
+	}
+	return strings.Join(specs, " \\\n")
+}
+
+func zshCompletion(list []cliCommand) string {
+	var b strings.Builder
+	b.WriteString("#compdef cohort-bridge\n")
+	b.WriteString("# zsh completion for cohort-bridge, generated by 'cohort-bridge completion zsh'\n\n")
+	b.WriteString("_cohort_bridge() {\n")
+	b.WriteString("  local curcontext=\"$curcontext\" state line\n")
+	b.WriteString("  _arguments -C " + strings.ReplaceAll(zshSpecs(globalFlags), "\n", "\n    ") + " \\\n")
+	b.WriteString("    '1:command:->command' '*::argument:->argument'\n\n")
+	b.WriteString("  case $state in\n")
+	b.WriteString("    command)\n")
+	b.WriteString("      local -a subcommands\n")
+	b.WriteString("      subcommands=(\n")
+	for _, cmd := range list {
+		fmt.Fprintf(&b, "        %s\n", zshQuote(cmd.name+":"+cmd.summary))
+	}
+	b.WriteString("      )\n")
+	b.WriteString("      _describe -t commands 'cohort-bridge command' subcommands\n")
+	b.WriteString("      ;;\n")
+	b.WriteString("    argument)\n")
+	b.WriteString("      case $words[1] in\n")
+	for _, cmd := range list {
+		if len(cmd.actions) == 0 {
+			fmt.Fprintf(&b, "        %s)\n          _arguments %s\n          ;;\n", cmd.name, strings.ReplaceAll(zshSpecs(cmd.flags), "\n", "\n            "))
+			continue
+		}
+		fmt.Fprintf(&b, "        %s)\n", cmd.name)
+		fmt.Fprintf(&b, "          if (( CURRENT == 2 )); then\n            compadd -- %s\n            return\n          fi\n", strings.Join(cmd.actions, " "))
+		b.WriteString("          case $words[2] in\n")
+		for i, action := range cmd.actions {
+			fmt.Fprintf(&b, "            %s) _arguments %s ;;\n", action, strings.ReplaceAll(zshSpecs(cmd.actionFlags[i]), "\n", "\n                "))
+		}
+		b.WriteString("          esac\n          ;;\n")
+	}
+	b.WriteString("      esac\n")
+	b.WriteString("      ;;\n")
+	b.WriteString("  esac\n")
+	b.WriteString("}\n\n")
+	b.WriteString("_cohort_bridge \"$@\"\n")
+	return b.String()
+}
+
+// fishQuote quotes s as one single-quoted fish word
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// fishFlags writes a complete line for each flag, offered when condition holds
+func fishFlags(b *strings.Builder, condition string, flags []cliFlag) {
+	for _, f := range flags {
+		fmt.Fprintf(b, "complete -c cohort-bridge -n %s -o %s", fishQuote(condition), f.Name)
+		switch {
+		case len(f.Choices) > 0:
+			fmt.Fprintf(b, " -x -a %s", fishQuote(strings.Join(f.Choices, " ")))
+		case f.Value == "string":
+			b.WriteString(" -r -F")
+		case f.Value != "":
+			b.WriteString(" -x")
+		}
+		fmt.Fprintf(b, " -d %s\n", fishQuote(f.Usage))
+	}
+}
+
+func fishCompletion(list []cliCommand) string {
+	var b strings.Builder
+	b.WriteString("# fish completion for cohort-bridge, generated by 'cohort-bridge completion fish'\n\n")
+	b.WriteString("complete -c cohort-bridge -n __fish_use_subcommand -f\n")
+	fishFlags(&b, "__fish_use_subcommand", globalFlags)
+	for _, cmd := range list {
+		fmt.Fprintf(&b, "complete -c cohort-bridge -n __fish_use_subcommand -a %s -d %s\n", cmd.name, fishQuote(cmd.summary))
+	}
+	for _, cmd := range list {
+		seen := "__fish_seen_subcommand_from " + cmd.name
+		if len(cmd.actions) == 0 {
+			b.WriteString("\n")
+			fishFlags(&b, seen, cmd.flags)
+			continue
+		}
+		actions := strings.Join(cmd.actions, " ")
+		fmt.Fprintf(&b, "\ncomplete -c cohort-bridge -n %s -f -a %s\n", fishQuote(seen+"; and not __fish_seen_subcommand_from "+actions), fishQuote(actions))
+		for i, action := range cmd.actions {
+			fishFlags(&b, seen+"; and __fish_seen_subcommand_from "+action, cmd.actionFlags[i])
+		}
+	}
+	return b.String()
+}
+
+func showCompletionHelp() {
+	fmt.Println("CohortBridge Shell Completion")
+	fmt.Println("=============================")
+	fmt.Println()
+	fmt.Println("Prints a completion script for bash, zsh or fish, covering the subcommands, their")
+	fmt.Println("actions and every flag each one accepts. Regenerate it after upgrading.")
+	fmt.Println()
+	fmt.Println("USAGE:")
+	fmt.Println("  cohort-bridge completion <bash|zsh|fish>")
+	fmt.Println()
+	fmt.Println("EXAMPLES:")
+	fmt.Println("  # bash: load in the current shell, or install for every session")
+	fmt.Println("  source <(cohort-bridge completion bash)")
+	fmt.Println("  cohort-bridge completion bash > /etc/bash_completion.d/cohort-bridge")
+	fmt.Println()
+	fmt.Println("  # zsh: any directory on $fpath, as a file named _cohort-bridge")
+	fmt.Println("  cohort-bridge completion zsh > \"${fpath[1]}/_cohort-bridge\"")
+	fmt.Println()
+	fmt.Println("  # fish")
+	fmt.Println("  cohort-bridge completion fish > ~/.config/fish/completions/cohort-bridge.fish")
+	fmt.Println()
+	fmt.Println("See 'cohort-bridge man' for man pages.")
+}
+
+// manFlags are the flags of the man command
+type manFlags struct {
+	fs        *flag.FlagSet
+	outputDir *string
+	help      *bool
+}
+
+// newManFlags defines the flags of the man command
+func newManFlags() *manFlags {
+	fs := newFlagSet("man")
+	return &manFlags{
+		fs:        fs,
+		outputDir: fs.String("output", "man", "Directory to write the man pages to"),
+		help:      fs.Bool("help", false, "Show help message"),
+	}
+}
+
+func runManCommand(args []string) {
+	f := newManFlags()
+	parseFlags(f.fs, args)
+
+	if *f.help {
+		showManHelp()
+		return
+	}
+
+	if err := os.MkdirAll(*f.outputDir, 0755); err != nil {
+		fatalf(DataError, "ERROR: %v", err)
+	}
+	list := cliCommands()
+	pages := map[string]string{"cohort-bridge.1": mainManPage(list)}
+	for _, cmd := range list {
+		pages["cohort-bridge-"+cmd.name+".1"] = commandManPage(cmd)
+	}
+	names := make([]string, 0, len(pages))
+	for name := range pages {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(*f.outputDir, name), []byte(pages[name]), 0644); err != nil {
+			fatalf(DataError, "ERROR: %v", err)
+		}
+	}
+	fmt.Printf("Wrote %d man pages to %s\n", len(pages), *f.outputDir)
+	fmt.Printf("Read one with 'man %s', or copy them to a man1 directory such as /usr/local/share/man/man1\n",
+		filepath.Join(*f.outputDir, "cohort-bridge.1"))
+}
+
+// roffEscape escapes text for a roff line; hyphens are escaped so they print as minus signs
+// and copy as typed
+func roffEscape(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+// roffFlags writes flags as a roff option list
+func roffFlags(b *strings.Builder, flags []cliFlag) {
+	for _, f := range flags {
+		b.WriteString(".TP\n")
+		if f.Value != "" {
+			fmt.Fprintf(b, ".BI \\-%s \" %s\"\n", roffEscape(f.Name), roffEscape(f.Value))
+		} else {
+			fmt.Fprintf(b, ".B \\-%s\n", roffEscape(f.Name))
+		}
+		usage := f.Usage
+		if f.Default != "" {
+			usage += " (default: " + f.Default + ")"
+		}
+		b.WriteString(roffEscape(usage) + "\n")
+	}
+}
+
+// roffHeader writes the title and NAME section of the page of name
+func roffHeader(b *strings.Builder, name, summary string) {
+	fmt.Fprintf(b, ".TH %s 1 \"\" \"cohort-bridge %s\" \"CohortBridge Manual\"\n", strings.ToUpper(roffEscape(name)), softwareVersion)
+	fmt.Fprintf(b, ".SH NAME\n%s \\- %s\n", roffEscape(name), roffEscape(summary))
+}
+
+func mainManPage(list []cliCommand) string {
+	var b strings.Builder
+	roffHeader(&b, "cohort-bridge", "Privacy-preserving record linkage")
+	b.WriteString(".SH SYNOPSIS\n.B cohort\\-bridge\n[\\fIGLOBAL OPTIONS\\fR] \\fIsubcommand\\fR [\\fIOPTIONS\\fR]\n")
+	b.WriteString(".SH DESCRIPTION\n")
+	b.WriteString("CohortBridge links patient records held by two parties without either revealing its data: ")
+	b.WriteString("each party tokenizes its records into Bloom filters locally, and the parties match the tokens. ")
+	b.WriteString("Without a subcommand it runs interactively.\n")
+	b.WriteString(".SH SUBCOMMANDS\n")
+	for _, cmd := range list {
+		fmt.Fprintf(&b, ".TP\n.B %s\n%s. See\n.BR cohort\\-bridge\\-%s (1).\n", roffEscape(cmd.name), roffEscape(cmd.summary), roffEscape(cmd.name))
+	}
+	b.WriteString(".SH GLOBAL OPTIONS\n")
+	roffFlags(&b, globalFlags)
+	b.WriteString(".SH EXIT STATUS\n")
+	for _, category := range []struct {
+		code        int
+		description string
+	}{
+		{0, "Success"},
+		{1, "Other failure"},
+		{2, "Usage error: bad or missing flags"},
+		{3, "Configuration error"},
+		{4, "Data error: a missing, unreadable or malformed input or output file"},
+		{5, "Peer or network error"},
+		{6, "Key or crypto error"},
+	} {
+		fmt.Fprintf(&b, ".TP\n.B %d\n%s\n", category.code, roffEscape(category.description))
+	}
+	b.WriteString(".SH SEE ALSO\n")
+	for i, cmd := range list {
+		separator := ","
+		if i == len(list)-1 {
+			separator = ""
+		}
+		fmt.Fprintf(&b, ".BR cohort\\-bridge\\-%s (1)%s\n", roffEscape(cmd.name), separator)
+	}
+	return b.String()
+}
+
+func commandManPage(cmd cliCommand) string {
+	var b strings.Builder
+	roffHeader(&b, "cohort-bridge-"+cmd.name, cmd.summary)
+	b.WriteString(".SH SYNOPSIS\n")
+	if len(cmd.actions) == 0 {
+		fmt.Fprintf(&b, ".B cohort\\-bridge %s\n[\\fIOPTIONS\\fR]\n", roffEscape(cmd.name))
+	}
+	for i, action := range cmd.actions {
+		if i > 0 {
+			b.WriteString(".br\n")
+		}
+		fmt.Fprintf(&b, ".B cohort\\-bridge %s %s\n[\\fIOPTIONS\\fR]\n", roffEscape(cmd.name), roffEscape(action))
+	}
+	fmt.Fprintf(&b, ".SH DESCRIPTION\n%s.\n", roffEscape(cmd.summary))
+
+	b.WriteString(".SH OPTIONS\n")
+	if len(cmd.actions) == 0 {
+		roffFlags(&b, cmd.flags)
+	} else if sameFlags(cmd.actionFlags) {
+		roffFlags(&b, cmd.actionFlags[0])
+	} else {
+		for i, action := range cmd.actions {
+			fmt.Fprintf(&b, ".SS %s\n", roffEscape(action))
+			roffFlags(&b, cmd.actionFlags[i])
+		}
+	}
+
+	// The command's own help, with its examples and configuration notes
+	help := strings.Split(strings.TrimRight(captureStdout(cmd.help), "\n"), "\n")
+	if len(help) > 1 && strings.Trim(help[1], "=") == "" {
+		help = help[2:]
+	}
+	for len(help) > 0 && strings.TrimSpace(help[0]) == "" {
+		help = help[1:]
+	}
+	if len(help) > 0 {
+		b.WriteString(".SH DETAILS\n.nf\n")
+		for _, line := range help {
+			b.WriteString(roffEscape(line) + "\n")
+		}
+		b.WriteString(".fi\n")
+	}
+	b.WriteString(".SH SEE ALSO\n.BR cohort\\-bridge (1)\n")
+	return b.String()
+}
+
+// sameFlags reports whether every action of a command takes the same flags
+func sameFlags(actionFlags [][]cliFlag) bool {
+	for _, flags := range actionFlags[1:] {
+		if !slices.EqualFunc(flags, actionFlags[0], func(a, b cliFlag) bool { return a.Name == b.Name }) {
+			return false
+		}
+	}
+	return true
+}
+
+// captureStdout runs fn and returns what it printed to standard output
+func captureStdout(fn func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return ""
+	}
+	captured := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		r.Close()
+		captured <- string(data)
+	}()
+	stdout := os.Stdout
+	os.Stdout = w
+	fn()
+	os.Stdout = stdout
+	w.Close()
+	return <-captured
+}
+
+func showManHelp() {
+	fmt.Println("CohortBridge Man Pages")
+	fmt.Println("======================")
+	fmt.Println()
+	fmt.Println("Writes a man page for cohort-bridge and one for each subcommand (cohort-bridge-<name>.1),")
+	fmt.Println("listing every flag the subcommand accepts with its default, followed by its help.")
+	fmt.Println()
+	fmt.Println("USAGE:")
+	fmt.Println("  cohort-bridge man [OPTIONS]")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Println("  -output string        Directory to write the man pages to (default: man)")
+	fmt.Println("  -help                 Show this help message")
+	fmt.Println()
+	fmt.Println("EXAMPLES:")
+	fmt.Println("  cohort-bridge man -output man")
+	fmt.Println("  man ./man/cohort-bridge-tokenize.1")
+	fmt.Println("  sudo cp man/*.1 /usr/local/share/man/man1/")
+}
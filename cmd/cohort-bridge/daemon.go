@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sync/atomic"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/server"
+)
+
+// daemonService is the API of a long-running server command (serve, intersect-api)
+type daemonService interface {
+	Handler() http.Handler
+	Shutdown(ctx context.Context) error
+	SetAPIKeys(apiKeys []string)
+	MarkReloaded()
+}
+
+// runDaemon serves service until the service manager stops it, then drains it. It writes the
+// PID file, tells systemd when it is ready, reloading or stopping, and reloads the configuration
+// on SIGHUP (a paramchange request for Windows services): API keys, the security section, the
+// log level and files, and the TLS certificate. Other settings take effect on restart.
+func runDaemon(name, configFile string, cfg *config.Config, security *server.SecurityManager, service daemonService) {
+	control, err := server.NewServiceControl("cohort-bridge")
+	if err != nil {
+		fatalf(InternalError, "Failed to start service control: %v", err)
+	}
+	defer control.Close()
+
+	removePID := func() {}
+	if cfg.Serve.PIDFile != "" {
+		if removePID, err = server.WritePIDFile(cfg.Serve.PIDFile); err != nil {
+			fatalf(DataError, "Failed to write PID file: %v", err)
+		}
+	}
+	defer removePID()
+
+	httpServer := &http.Server{
+		Addr:              cfg.Serve.Listen,
+		Handler:           service.Handler(),
+		ReadHeaderTimeout: cfg.Timeouts.HandshakeTimeout, // Slow clients cannot hold connections open
+		ReadTimeout:       cfg.Timeouts.ReadTimeout,
+		WriteTimeout:      cfg.Timeouts.WriteTimeout,
+		IdleTimeout:       cfg.Timeouts.IdleTimeout,
+		MaxHeaderBytes:    serveMaxHeaderBytes,
+	}
+	var certificate *reloadableCertificate
+	if cfg.Serve.TLSCertFile != "" && cfg.Serve.TLSKeyFile != "" {
+		certificate = &reloadableCertificate{certFile: cfg.Serve.TLSCertFile, keyFile: cfg.Serve.TLSKeyFile}
+		if err := certificate.load(); err != nil {
+			fatalf(CryptoError, "Failed to load TLS certificate: %v", err)
+		}
+		httpServer.TLSConfig = &tls.Config{GetCertificate: certificate.get, MinVersion: tls.VersionTLS12}
+	}
+
+	startMetricsListener(cfg)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if certificate != nil {
+			fmt.Printf("Serving HTTPS on %s\n", cfg.Serve.Listen)
+			serveErr <- httpServer.ListenAndServeTLS("", "")
+		} else {
+			fmt.Printf("Serving HTTP on %s (set serve.tls_cert_file and serve.tls_key_file for HTTPS)\n", cfg.Serve.Listen)
+			serveErr <- httpServer.ListenAndServe()
+		}
+	}()
+	server.Notify("READY=1")
+	go server.RunWatchdog(control.Stop, func() bool { return true })
+
+	for stopped := false; !stopped; {
+		select {
+		case err := <-serveErr:
+			if !errors.Is(err, http.ErrServerClosed) {
+				server.Notify("STOPPING=1")
+				fatalf(InternalError, "Server failed: %v", err)
+			}
+			stopped = true
+		case <-control.Reload:
+			server.Notify("RELOADING=1")
+			if err := reloadDaemon(configFile, cfg, security, certificate, service); err != nil {
+				fmt.Printf("Reload failed, keeping the running configuration: %v\n", err)
+				server.Warn("Configuration reload failed: %v", err)
+			} else {
+				fmt.Println("Configuration reloaded")
+			}
+			server.Notify("READY=1")
+		case <-control.Stop:
+			stopped = true
+		}
+	}
+
+	server.Notify("STOPPING=1")
+	fmt.Printf("\nShutting down (waiting up to %s for running jobs)...\n", cfg.Serve.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Serve.ShutdownTimeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		fmt.Printf("Warning: HTTP shutdown: %v\n", err)
+	}
+	if err := service.Shutdown(shutdownCtx); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		removePID()
+		control.Close()
+		os.Exit(1)
+	}
+	fmt.Printf("%s stopped\n", name)
+}
+
+// reloadDaemon loads configFile again and applies the settings a running daemon can change.
+// Nothing is applied unless the whole configuration is valid.
+func reloadDaemon(configFile string, running *config.Config, security *server.SecurityManager, certificate *reloadableCertificate, service daemonService) error {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return err
+	}
+	apiKeys, err := loadAPIKeys(cfg)
+	if err != nil {
+		return fmt.Errorf("API keys: %w", err)
+	}
+	if _, err := server.ParseAllowedIPs(cfg.Security.AllowedIPs); err != nil {
+		return err
+	}
+	if certificate != nil {
+		if err := certificate.load(); err != nil {
+			return fmt.Errorf("TLS certificate: %w", err)
+		}
+	}
+
+	service.SetAPIKeys(apiKeys)
+	security.Reload(cfg)
+	if err := server.ReloadLogger(cfg); err != nil {
+		fmt.Printf("Warning: reopening log files: %v\n", err)
+	}
+	service.MarkReloaded()
+
+	// Settings bound at startup are reported rather than silently ignored
+	restart := map[string]bool{
+		"serve.listen":              cfg.Serve.Listen != running.Serve.Listen,
+		"serve.jobs_dir":            cfg.Serve.JobsDir != running.Serve.JobsDir,
+		"serve.max_concurrent_jobs": cfg.Serve.MaxConcurrentJobs != running.Serve.MaxConcurrentJobs,
+		"serve.tls_cert_file":       cfg.Serve.TLSCertFile != running.Serve.TLSCertFile || cfg.Serve.TLSKeyFile != running.Serve.TLSKeyFile,
+		"serve.reference_roots":     !slices.Equal(cfg.Serve.ReferenceRoots, running.Serve.ReferenceRoots),
+		"database.filename":         cfg.Database.Filename != running.Database.Filename,
+		"logging.file":              cfg.Logging.File != running.Logging.File || cfg.Logging.AuditFile != running.Logging.AuditFile,
+	}
+	for _, setting := range sortedKeys(restart) {
+		if restart[setting] {
+			fmt.Printf("Warning: %s changed; restart to apply it\n", setting)
+		}
+	}
+	server.Audit("config_reloaded", map[string]interface{}{"api_keys": len(apiKeys)})
+	return nil
+}
+
+// reloadableCertificate is the TLS certificate of a daemon, read again on reload so a renewed
+// certificate is served without a restart
+type reloadableCertificate struct {
+	certFile, keyFile string
+	current           atomic.Pointer[tls.Certificate]
+}
+
+func (c *reloadableCertificate) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.current.Store(&cert)
+	return nil
+}
+
+func (c *reloadableCertificate) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.current.Load(), nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+	"github.com/auroradata-ai/cohort-bridge/internal/server"
+	"github.com/auroradata-ai/cohort-bridge/internal/store"
+)
+
+// dedupeFlags are the flags of the dedupe command
+type dedupeFlags struct {
+	fs          *flag.FlagSet
+	inputFile   *string
+	configFile  *string
+	outputFile  *string
+	dedupedFile *string
+	help        *bool
+	thresholds  *thresholdFlags
+}
+
+// newDedupeFlags defines the flags of the dedupe command
+func newDedupeFlags() *dedupeFlags {
+	fs := newFlagSet("dedupe")
+	return &dedupeFlags{
+		fs:          fs,
+		inputFile:   fs.String("input", "", "Tokenized dataset to deduplicate"),
+		configFile:  fs.String("config", "", "Config for matching thresholds (optional)"),
+		outputFile:  fs.String("output", "", "Output CSV of duplicate clusters (default: <input>_duplicates.csv)"),
+		dedupedFile: fs.String("deduped", "", "Also write the tokenized dataset keeping one record per cluster"),
+		help:        fs.Bool("help", false, "Show help message"),
+		thresholds:  addThresholdFlags(fs),
+	}
+}
+
+func runDedupeCommand(args []string) {
+	f := newDedupeFlags()
+	f.fs.Parse(args)
+
+	if *f.help {
+		showDedupeHelp()
+		return
+	}
+
+	if *f.inputFile == "" {
+		showDedupeHelp()
+		fatalf(UsageError, "Error: -input is required")
+	}
+	if *f.dedupedFile != "" && strings.HasSuffix(*f.inputFile, ".enc") {
+		fatalf(UsageError, "Error: -deduped needs a plaintext tokenized CSV; decrypt the input first")
+	}
+
+	cfg := &config.Config{}
+	if *f.configFile != "" {
+		loaded, err := config.Load(*f.configFile)
+		if err != nil {
+			fatalf(ConfigError, "ERROR: Failed to load config: %v", err)
+		}
+		cfg = loaded
+	} else {
+		cfg.SetDefaults()
+	}
+	resolved := f.thresholds.resolve(cfg)
+	resolved.Apply(cfg)
+	if *f.outputFile == "" {
+		*f.outputFile = strings.TrimSuffix(*f.inputFile, filepath.Ext(*f.inputFile)) + "_duplicates.csv"
+	}
+
+	fmt.Println("CohortBridge Deduplication")
+	fmt.Println("==========================")
+	fmt.Printf("Input: %s\n", *f.inputFile)
+	fmt.Printf("Thresholds: %s\n", resolved)
+	fmt.Println()
+
+	run := startRun("dedupe", cfg)
+	run.Parameters["hamming_threshold"] = strconv.FormatUint(uint64(cfg.Matching.HammingThreshold), 10)
+	run.Parameters["jaccard_threshold"] = strconv.FormatFloat(cfg.Matching.JaccardThreshold, 'f', -1, 64)
+	run.AddInput(*f.inputFile)
+
+	if err := performDeduplication(*f.inputFile, *f.outputFile, *f.dedupedFile, cfg, run); err != nil {
+		recordRun(run, err)
+		fatalf(DataError, "ERROR: Deduplication failed: %v", err)
+	}
+	recordRun(run, nil)
+}
+
+// performDeduplication clusters duplicates within inputFile and writes the cluster report
+// and, if dedupedFile is set, the dataset reduced to one record per cluster
+func performDeduplication(inputFile, outputFile, dedupedFile string, cfg *config.Config, run *store.Run) error {
+	records, err := server.LoadTokenizedRecords(inputFile, false, defaultKeySources(cfg))
+	if err != nil {
+		return fmt.Errorf("failed to load tokenized dataset: %w", err)
+	}
+	fmt.Printf("Loaded %d records\n", len(records))
+	run.Counts["records"] = len(records)
+
+	fuzzyConfig := &match.FuzzyMatchConfig{
+		HammingThreshold:   cfg.Matching.HammingThreshold,
+		JaccardThreshold:   cfg.Matching.JaccardThreshold,
+		CandidateThreshold: cfg.Matching.CandidateThreshold,
+		DistanceScale:      hardeningScale(cfg.Tokenization),
+	}
+	if err := applyCalibration(fuzzyConfig, cfg); err != nil {
+		return err
+	}
+
+	fmt.Println("Matching the dataset against itself...")
+	clusters, pairs, err := match.NewFuzzyMatcher(fuzzyConfig).FindDuplicates(records)
+	if err != nil {
+		return err
+	}
+
+	duplicates := 0
+	for _, cluster := range clusters {
+		duplicates += len(cluster.RecordIDs) - 1
+	}
+	run.Counts["duplicate_pairs"] = pairs
+	run.Counts["clusters"] = len(clusters)
+	run.Counts["duplicates"] = duplicates
+
+	fmt.Println()
+	fmt.Printf("Duplicate pairs: %d\n", pairs)
+	fmt.Printf("Clusters: %d (%d records would be removed)\n", len(clusters), duplicates)
+
+	if err := writeDuplicateClusters(clusters, outputFile); err != nil {
+		return fmt.Errorf("failed to write clusters: %w", err)
+	}
+	run.AddOutput(outputFile)
+	fmt.Printf("Clusters saved to: %s\n", outputFile)
+
+	if dedupedFile != "" {
+		removed := make(map[string]bool)
+		for _, cluster := range clusters {
+			for _, id := range cluster.RecordIDs[1:] {
+				removed[id] = true
+			}
+		}
+		kept, err := writeDedupedTokens(inputFile, dedupedFile, removed)
+		if err != nil {
+			return fmt.Errorf("failed to write deduplicated dataset: %w", err)
+		}
+		run.AddOutput(dedupedFile)
+		fmt.Printf("Deduplicated dataset (%d records) saved to: %s\n", kept, dedupedFile)
+	}
+	return nil
+}
+
+// writeDuplicateClusters writes one row per clustered record
+func writeDuplicateClusters(clusters []match.DuplicateCluster, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"cluster_id", "record_id", "cluster_size", "representative"})
+	for _, cluster := range clusters {
+		for _, id := range cluster.RecordIDs {
+			writer.Write([]string{
+				strconv.Itoa(cluster.ID),
+				id,
+				strconv.Itoa(len(cluster.RecordIDs)),
+				strconv.FormatBool(id == cluster.Representative()),
+			})
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeDedupedTokens copies a tokenized CSV without the removed records and returns how many were kept
+func writeDedupedTokens(inputFile, outputFile string, removed map[string]bool) (int, error) {
+	in, err := os.Open(inputFile)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	rows, err := csv.NewReader(in).ReadAll()
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, fmt.Errorf("empty tokenized file")
+	}
+
+	out, err := os.Create(outputFile)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	writer := csv.NewWriter(out)
+	writer.Write(rows[0])
+	kept := 0
+	for _, row := range rows[1:] {
+		if len(row) > 0 && removed[row[0]] {
+			continue
+		}
+		writer.Write(row)
+		kept++
+	}
+	writer.Flush()
+	return kept, writer.Error()
+}
+
+func showDedupeHelp() {
+	fmt.Println("CohortBridge Deduplication")
+	fmt.Println("==========================")
+	fmt.Println()
+	fmt.Println("Find duplicate records within one tokenized dataset before cross-party")
+	fmt.Println("linkage. The dataset is matched against itself (excluding each record's")
+	fmt.Println("match with itself) and linked records are grouped into clusters, so")
+	fmt.Println("A~B and B~C put A, B and C in one cluster.")
+	fmt.Println()
+	fmt.Println("USAGE:")
+	fmt.Println("  cohort-bridge dedupe -input tokens.csv [OPTIONS]")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Println("  -input string              Tokenized dataset (.csv or encrypted .enc)")
+	fmt.Println("  -config string             Config for matching thresholds and calibration")
+	fmt.Printf("  -hamming-threshold uint    Hamming distance threshold (default: config or %d)\n", config.DefaultHammingThreshold)
+	fmt.Printf("  -jaccard-threshold float   Minimum Jaccard similarity (default: config or %g)\n", config.DefaultJaccardThreshold)
+	fmt.Println("  -output string             Cluster report (default: <input>_duplicates.csv)")
+	fmt.Println("  -deduped string            Write the dataset keeping one record per cluster")
+	fmt.Println("                             (the lowest record ID; plaintext input only)")
+	fmt.Println("  -help                      Show this help message")
+	fmt.Println()
+	fmt.Println("OUTPUT:")
+	fmt.Println("  cluster_id,record_id,cluster_size,representative")
+	fmt.Println()
+	fmt.Println("EXAMPLES:")
+	fmt.Println("  cohort-bridge dedupe -input tokens.csv")
+	fmt.Println("  cohort-bridge dedupe -input tokens.csv -config config.yaml -deduped tokens_clean.csv")
+}
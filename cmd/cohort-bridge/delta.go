@@ -0,0 +1,568 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/crypto"
+	"github.com/auroradata-ai/cohort-bridge/internal/db"
+	"github.com/auroradata-ai/cohort-bridge/internal/keys"
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+	"github.com/auroradata-ai/cohort-bridge/internal/server"
+	"github.com/auroradata-ai/cohort-bridge/internal/store"
+)
+
+// deltaOptions are the settings of an incremental linkage
+type deltaOptions struct {
+	dataset1, dataset2 string // Token files of the previous run
+	delta1, delta2     string // Tokens of the records added or modified since (either may be empty)
+	crosswalk          string // Crosswalk of the previous run (empty on the first run)
+	output, format     string
+	merged1, merged2   string // Where to save the merged tokens for the next run (optional)
+
+	thresholds      config.Thresholds
+	allowDuplicates bool
+	blocking        bool
+	retention       crypto.Retention
+	secret          []byte
+	encrypt         bool
+	keySource       string
+	tokenKeys       keys.Source
+}
+
+// deltaFlags are the flags of the delta command
+type deltaFlags struct {
+	fs             *flag.FlagSet
+	dataset1       *string
+	dataset2       *string
+	delta1         *string
+	delta2         *string
+	crosswalk      *string
+	outputFile     *string
+	format         *string
+	merged1        *string
+	merged2        *string
+	configFile     *string
+	secretFile     *string
+	allowDups      *bool
+	noBlocking     *bool
+	encrypt        *bool
+	keySource      *string
+	keyFile        *string
+	help           *bool
+	thresholdFlags *thresholdFlags
+	retention      *retentionFlags
+}
+
+// newDeltaFlags defines the flags of the delta command
+func newDeltaFlags() *deltaFlags {
+	fs := newFlagSet("delta")
+	return &deltaFlags{
+		fs:             fs,
+		dataset1:       fs.String("dataset1", "", "First party's token file from the previous run"),
+		dataset2:       fs.String("dataset2", "", "Second party's token file from the previous run"),
+		delta1:         fs.String("delta1", "", "First party's tokens of records added or modified since (tokenize -since)"),
+		delta2:         fs.String("delta2", "", "Second party's tokens of records added or modified since"),
+		crosswalk:      fs.String("crosswalk", "", "Crosswalk of the previous run (from export or delta)"),
+		outputFile:     fs.String("output", "delta_crosswalk.csv", "Updated crosswalk"),
+		format:         fs.String("format", "", "Crosswalk format: csv or json (default: from -output, else csv)"),
+		merged1:        fs.String("merged1", "", "Save dataset1 with the delta applied, as the next run's -dataset1 (.csv or .json, .enc to encrypt)"),
+		merged2:        fs.String("merged2", "", "Save dataset2 with the delta applied, as the next run's -dataset2"),
+		configFile:     fs.String("config", "", "Config with the matching, linkage secret and key settings (optional)"),
+		secretFile:     fs.String("secret", "", "Linkage secret keying the linkage IDs (default: tokenization.linkage_secret_file)"),
+		allowDups:      fs.Bool("allow-duplicates", false, "Keep every matching pair (1:many) instead of at most one per record"),
+		noBlocking:     fs.Bool("no-blocking", false, "Compare every pair even if both datasets carry blocking keys"),
+		encrypt:        fs.Bool("encrypt", false, "Encrypt the crosswalk"),
+		keySource:      fs.String("key-source", "", "Encryption key source: file, env, keyring, keychain, kms, pkcs11 (default: keys.source)"),
+		keyFile:        fs.String("key", "", "Key file for encrypted (.enc) token files"),
+		help:           fs.Bool("help", false, "Show help message"),
+		thresholdFlags: addThresholdFlags(fs),
+		retention:      addRetentionFlags(fs),
+	}
+}
+
+func runDeltaCommand(args []string) {
+	f := newDeltaFlags()
+	f.fs.Parse(args)
+
+	if *f.help {
+		showDeltaHelp()
+		return
+	}
+	if *f.dataset1 == "" || *f.dataset2 == "" || (*f.delta1 == "" && *f.delta2 == "") {
+		showDeltaHelp()
+		fatalf(UsageError, "Error: -dataset1, -dataset2 and at least one of -delta1 and -delta2 are required")
+	}
+
+	cfg := loadOptionalConfig(*f.configFile)
+	f.retention.apply(cfg)
+	if *f.secretFile == "" {
+		*f.secretFile = cfg.Tokenization.LinkageSecretFile
+	}
+	if *f.keySource == "" {
+		*f.keySource = cfg.Keys.Source
+	}
+	if *f.format == "" {
+		*f.format = "csv"
+		if strings.EqualFold(filepath.Ext(*f.outputFile), ".json") {
+			*f.format = "json"
+		}
+	}
+	*f.format = strings.ToLower(*f.format)
+	if *f.format != "csv" && *f.format != "json" {
+		fatalf(UsageError, "Error: unknown format %q (expected csv or json)", *f.format)
+	}
+	for _, merged := range []string{*f.merged1, *f.merged2} {
+		if merged != "" {
+			if _, err := mergedTokenEncoding(merged); err != nil {
+				fatalf(UsageError, "Error: %v", err)
+			}
+		}
+	}
+
+	opts := deltaOptions{
+		dataset1: *f.dataset1, dataset2: *f.dataset2,
+		delta1: *f.delta1, delta2: *f.delta2,
+		crosswalk: *f.crosswalk,
+		output:    *f.outputFile, format: *f.format,
+		merged1: *f.merged1, merged2: *f.merged2,
+		allowDuplicates: *f.allowDups,
+		blocking:        !*f.noBlocking,
+		retention:       matchRetention(cfg),
+		encrypt:         *f.encrypt,
+		keySource:       *f.keySource,
+	}
+	if *f.configFile != "" {
+		opts.thresholds = f.thresholdFlags.resolve(cfg)
+	} else {
+		opts.thresholds = f.thresholdFlags.resolve()
+	}
+	var err error
+	if opts.tokenKeys, err = indexKeySource(cfg, *f.keyFile); err != nil {
+		fatalf(CryptoError, "ERROR: %v", err)
+	}
+	if *f.secretFile != "" {
+		if opts.secret, err = pprl.LoadLinkageSecret(*f.secretFile); err != nil {
+			fatalf(CryptoError, "ERROR: %v", err)
+		}
+	}
+
+	fmt.Println("CohortBridge Delta Linkage")
+	fmt.Println("==========================")
+	fmt.Printf("Dataset 1: %s + %s\n", *f.dataset1, orNone(*f.delta1))
+	fmt.Printf("Dataset 2: %s + %s\n", *f.dataset2, orNone(*f.delta2))
+	fmt.Printf("Previous crosswalk: %s\n", orNone(*f.crosswalk))
+	fmt.Printf("Output: %s\n", *f.outputFile)
+	fmt.Printf("Thresholds: %s\n", opts.thresholds)
+	fmt.Println()
+
+	run := startRun("delta", cfg)
+	run.Parameters["format"] = *f.format
+	run.Parameters["hamming_threshold"] = strconv.FormatUint(uint64(opts.thresholds.Hamming), 10)
+	run.Parameters["jaccard_threshold"] = strconv.FormatFloat(opts.thresholds.Jaccard, 'g', -1, 64)
+	run.Parameters["blocking"] = strconv.FormatBool(opts.blocking)
+	run.Parameters["keyed"] = strconv.FormatBool(opts.secret != nil)
+	if *f.allowDups {
+		run.Parameters["allow_duplicates"] = "true"
+		run.Parameters["max_matches_per_record"] = strconv.Itoa(opts.retention.MaxPerRecord)
+	}
+	for _, input := range []string{*f.dataset1, *f.delta1, *f.dataset2, *f.delta2, *f.crosswalk} {
+		if input != "" {
+			run.AddInput(input)
+		}
+	}
+
+	if err := performDelta(opts, cfg, run); err != nil {
+		recordRun(run, err)
+		fatalf(DataError, "ERROR: Delta linkage failed: %v", err)
+	}
+	recordRun(run, nil)
+}
+
+// deltaSide is one dataset of a delta run: the previous run's tokens with the changed records
+// replaced or added, in the previous order followed by the new records
+type deltaSide struct {
+	rows      []db.TokenizedRecord
+	records   []*pprl.Record
+	ids       map[string]bool // Every record ID
+	changed   map[string]bool // IDs of the records in the delta
+	format    *db.TokenFormat // Manifest of the previous tokens, else of the delta
+	watermark time.Time       // Latest watermark of the previous tokens and the delta
+	added     int
+}
+
+// loadDeltaSide merges the delta tokens of one dataset into its previous tokens
+func loadDeltaSide(base, delta string, keySource keys.Source) (*deltaSide, error) {
+	baseFormat, err := checkTokenFormat(base, "", "")
+	if err != nil {
+		return nil, err
+	}
+	side := &deltaSide{ids: make(map[string]bool), changed: make(map[string]bool), format: baseFormat}
+	baseRows, err := readTokenRows(base, keySource)
+	if err != nil {
+		return nil, err
+	}
+	side.rows = baseRows
+	if err := side.noteWatermark(base, baseFormat); err != nil {
+		return nil, err
+	}
+
+	if delta != "" {
+		deltaFormat, err := checkTokenFormat(delta, "", "")
+		if err != nil {
+			return nil, err
+		}
+		if err := db.CheckSameRecipe(base, baseFormat, delta, deltaFormat); err != nil {
+			return nil, err
+		}
+		if side.format == nil {
+			side.format = deltaFormat
+		}
+		if err := side.noteWatermark(delta, deltaFormat); err != nil {
+			return nil, err
+		}
+		deltaRows, err := readTokenRows(delta, keySource)
+		if err != nil {
+			return nil, err
+		}
+
+		position := make(map[string]int, len(side.rows))
+		for i, row := range side.rows {
+			position[row.ID] = i
+		}
+		for _, row := range deltaRows {
+			side.changed[row.ID] = true
+			if i, ok := position[row.ID]; ok {
+				side.rows[i] = row
+				continue
+			}
+			position[row.ID] = len(side.rows)
+			side.rows = append(side.rows, row)
+			side.added++
+		}
+	}
+
+	side.records = make([]*pprl.Record, len(side.rows))
+	for i := range side.rows {
+		record, err := server.TokenizedToRecord(&side.rows[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode tokens of %s: %w", side.rows[i].ID, err)
+		}
+		side.records[i] = record
+		side.ids[record.ID] = true
+	}
+	return side, nil
+}
+
+// readTokenRows reads every row of a token file as it was written
+func readTokenRows(path string, keySource keys.Source) ([]db.TokenizedRecord, error) {
+	if pprl.IsBloomStore(path) {
+		return nil, fmt.Errorf("%s: delta reads token files, not binary token stores (.cbbf)", path)
+	}
+	reader, err := server.OpenTokenizedReader(path, false, keySource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer reader.Close()
+
+	var rows []db.TokenizedRecord
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		rows = append(rows, *row)
+	}
+}
+
+// noteWatermark keeps the later of the side's watermark and the one in a token file's manifest
+func (s *deltaSide) noteWatermark(path string, format *db.TokenFormat) error {
+	if format == nil || format.Watermark == "" {
+		return nil
+	}
+	t, err := pprl.ParseWatermark(format.Watermark)
+	if err != nil {
+		return fmt.Errorf("%s: manifest watermark: %w", path, err)
+	}
+	if t.After(s.watermark) {
+		s.watermark = t
+	}
+	return nil
+}
+
+// split returns the changed and unchanged records
+func (s *deltaSide) split() (changed, unchanged []*pprl.Record) {
+	for _, record := range s.records {
+		if s.changed[record.ID] {
+			changed = append(changed, record)
+		} else {
+			unchanged = append(unchanged, record)
+		}
+	}
+	return changed, unchanged
+}
+
+// performDelta matches the changed records of both datasets, merges the new matches into the
+// previous crosswalk and writes the updated crosswalk. Pairs of unchanged records are carried
+// over without comparing them again; pairs involving a changed record are dropped and found
+// afresh among the candidates.
+func performDelta(opts deltaOptions, cfg *config.Config, run *store.Run) error {
+	side1, err := loadDeltaSide(opts.dataset1, opts.delta1, opts.tokenKeys)
+	if err != nil {
+		return err
+	}
+	side2, err := loadDeltaSide(opts.dataset2, opts.delta2, opts.tokenKeys)
+	if err != nil {
+		return err
+	}
+	if err := db.CheckSameRecipe(opts.dataset1, side1.format, opts.dataset2, side2.format); err != nil {
+		return err
+	}
+	fmt.Printf("Dataset 1: %d records, %d changed (%d new)\n", len(side1.records), len(side1.changed), side1.added)
+	fmt.Printf("Dataset 2: %d records, %d changed (%d new)\n", len(side2.records), len(side2.changed), side2.added)
+	run.Counts["dataset1_records"] = len(side1.records)
+	run.Counts["dataset2_records"] = len(side2.records)
+	run.Counts["dataset1_changed"] = len(side1.changed)
+	run.Counts["dataset2_changed"] = len(side2.changed)
+
+	var previous []*match.PrivateMatchResult
+	if opts.crosswalk != "" {
+		if previous, err = loadMatchPairs(opts.crosswalk); err != nil {
+			return fmt.Errorf("failed to load previous crosswalk: %w", err)
+		}
+	}
+	kept := keptDeltaPairs(previous, side1, side2)
+	fmt.Printf("Previous crosswalk: %d pairs, %d kept unchanged, %d re-evaluated\n", len(previous), len(kept), len(previous)-len(kept))
+	run.Counts["previous_pairs"] = len(previous)
+	run.Counts["kept_pairs"] = len(kept)
+
+	candidates, err := deltaCandidates(opts, side1, side2)
+	if err != nil {
+		return err
+	}
+	added := selectDeltaPairs(candidates, kept, opts, cfg.Matching.Assignment)
+	fmt.Printf("New pairs: %d (of %d candidates)\n", len(added), len(candidates))
+	run.Counts["candidate_pairs"] = len(candidates)
+	run.Counts["new_pairs"] = len(added)
+
+	matches := kept
+	for _, pair := range added {
+		matches = append(matches, &match.PrivateMatchResult{LocalID: pair.LocalID, PeerID: pair.PeerID})
+	}
+	clusters := match.BuildCrosswalk(matches, opts.secret)
+	run.Counts["matches"] = len(matches)
+	run.Counts["clusters"] = len(clusters)
+
+	ids, err := crosswalkLocalIDs(cfg)
+	if err != nil {
+		return err
+	}
+	output := crosswalkOutput{opts.output, match.CrosswalkRows(clusters), []string{"linkage_id", "local_id", "peer_id"}, ids}
+	written, err := writeCrosswalkFile(output, opts.format, opts.encrypt, opts.keySource, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", opts.output, err)
+	}
+	run.AddOutput(written)
+	fmt.Printf("Crosswalk saved to: %s (%d rows, %d linkage IDs)\n", written, len(output.rows), len(clusters))
+
+	for _, merged := range []struct {
+		path string
+		side *deltaSide
+	}{{opts.merged1, side1}, {opts.merged2, side2}} {
+		if merged.path == "" {
+			continue
+		}
+		written, err := writeMergedTokens(merged.path, merged.side, opts.keySource, cfg)
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", merged.path, err)
+		}
+		run.AddOutput(written)
+		fmt.Printf("Merged tokens saved to: %s (%d records)\n", written, len(merged.side.rows))
+	}
+	return nil
+}
+
+// keptDeltaPairs returns the previous pairs whose records are both unchanged and still present
+func keptDeltaPairs(previous []*match.PrivateMatchResult, side1, side2 *deltaSide) []*match.PrivateMatchResult {
+	var kept []*match.PrivateMatchResult
+	seen := make(map[[2]string]bool)
+	for _, pair := range previous {
+		key := [2]string{pair.LocalID, pair.PeerID}
+		if seen[key] || side1.changed[pair.LocalID] || side2.changed[pair.PeerID] {
+			continue
+		}
+		if !side1.ids[pair.LocalID] || !side2.ids[pair.PeerID] {
+			continue
+		}
+		seen[key] = true
+		kept = append(kept, pair)
+	}
+	return kept
+}
+
+// deltaCandidates compares the changed records of each dataset with every record of the other,
+// returning all pairs within the thresholds; pairs of two unchanged records are not compared
+func deltaCandidates(opts deltaOptions, side1, side2 *deltaSide) ([]crypto.PrivateMatchPair, error) {
+	matchConfig := intersectMatchConfig(0, opts.thresholds, manyToMany, crypto.Retention{}, tokenDistanceScale(opts.dataset1))
+	matchConfig.Blocking = opts.blocking
+	matcher := match.NewFuzzyMatcher(matchConfig)
+
+	changed1, unchanged1 := side1.split()
+	changed2, _ := side2.split()
+	var candidates []crypto.PrivateMatchPair
+	for _, comparison := range []struct {
+		local, peer []*pprl.Record
+	}{{changed1, side2.records}, {unchanged1, changed2}} {
+		if len(comparison.local) == 0 || len(comparison.peer) == 0 {
+			continue
+		}
+		result, err := matcher.ComputePrivateIntersection(comparison.local, comparison.peer)
+		if err != nil {
+			return nil, fmt.Errorf("intersection failed: %w", err)
+		}
+		candidates = append(candidates, result.MatchPairs...)
+	}
+	return candidates, nil
+}
+
+// selectDeltaPairs reduces the candidates as an intersection would. With 1:1 matching, records
+// held by a kept pair are already assigned, so candidates involving them are left out.
+func selectDeltaPairs(candidates []crypto.PrivateMatchPair, kept []*match.PrivateMatchResult, opts deltaOptions, assignment string) []crypto.PrivateMatchPair {
+	if opts.allowDuplicates {
+		return opts.retention.Apply(candidates, 0)
+	}
+	held1, held2 := make(map[string]bool), make(map[string]bool)
+	for _, pair := range kept {
+		held1[pair.LocalID] = true
+		held2[pair.PeerID] = true
+	}
+	var free []crypto.PrivateMatchPair
+	for _, pair := range candidates {
+		if !held1[pair.LocalID] && !held2[pair.PeerID] {
+			free = append(free, pair)
+		}
+	}
+	return crypto.AssignOneToOne(free, 0, assignment)
+}
+
+// mergedTokenEncoding returns the token encoding of a merged token file from its name
+func mergedTokenEncoding(path string) (string, error) {
+	switch ext := strings.ToLower(filepath.Ext(strings.TrimSuffix(path, ".enc"))); ext {
+	case ".csv", ".json":
+		return ext[1:], nil
+	}
+	return "", fmt.Errorf("merged tokens %s must be a .csv or .json file (optionally .enc)", path)
+}
+
+// writeMergedTokens saves the merged tokens of a dataset, with a format manifest carrying the
+// recipe and the latest watermark, so the next delta run can start from them. A path ending in
+// .enc is encrypted. It returns the path written.
+func writeMergedTokens(path string, side *deltaSide, keySource string, cfg *config.Config) (string, error) {
+	encoding, err := mergedTokenEncoding(path)
+	if err != nil {
+		return "", err
+	}
+	encrypted := strings.HasSuffix(path, ".enc")
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+
+	plain := path
+	if encrypted {
+		base := strings.TrimSuffix(path, ".enc")
+		plain = strings.TrimSuffix(base, filepath.Ext(base)) + ".tmp." + encoding
+	}
+	if err := db.NewTokenizedFile(plain, side.rows).Save(); err != nil {
+		os.Remove(plain)
+		return "", err
+	}
+	if encrypted {
+		if err := encryptOutputFile(plain, path, keySource, cfg); err != nil {
+			return "", err
+		}
+	}
+
+	if side.format != nil {
+		build := buildInfo()
+		format := db.NewTokenFormat(encoding, encrypted, build.Version, build.GitCommit,
+			side.format.Recipe, side.format.RecipeFingerprint, len(side.rows))
+		if !side.watermark.IsZero() {
+			format.Watermark = side.watermark.Format(time.RFC3339Nano)
+		}
+		if err := db.WriteTokenFormat(path, format); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+	return path, nil
+}
+
+// orNone names an optional file, or says none was given
+func orNone(path string) string {
+	if path == "" {
+		return "(none)"
+	}
+	return path
+}
+
+func showDeltaHelp() {
+	fmt.Println("CohortBridge Delta Linkage")
+	fmt.Println("==========================")
+	fmt.Println()
+	fmt.Println("Incremental linkage for nightly runs: only the records added or modified since")
+	fmt.Println("the previous run are tokenized ('tokenize -since') and compared, and their")
+	fmt.Println("matches are merged into the previous run's crosswalk. Pairs of two unchanged")
+	fmt.Println("records are carried over as they were; pairs involving a changed record are")
+	fmt.Println("dropped and found afresh, comparing each changed record with every record of")
+	fmt.Println("the other dataset.")
+	fmt.Println()
+	fmt.Println("USAGE:")
+	fmt.Println("  cohort-bridge delta -dataset1 a.csv -dataset2 b.csv -delta1 a_delta.csv \\")
+	fmt.Println("    -delta2 b_delta.csv -crosswalk crosswalk.csv -output crosswalk_new.csv [OPTIONS]")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Println("  -dataset1, -dataset2   Token files of the previous run")
+	fmt.Println("  -delta1, -delta2       Tokens of the records added or modified since; at least one")
+	fmt.Println("                         is required. A changed record replaces the one with its ID")
+	fmt.Println("  -crosswalk string      Previous crosswalk (export or delta, CSV or JSON); without it")
+	fmt.Println("                         only the changed records' matches are written")
+	fmt.Println("  -output string         Updated crosswalk (default: delta_crosswalk.csv)")
+	fmt.Println("  -format string         csv or json (default: from -output, else csv)")
+	fmt.Println("  -merged1, -merged2     Save each dataset with its delta applied (.csv or .json,")
+	fmt.Println("                         .enc to encrypt) as the next run's -dataset1/-dataset2; the")
+	fmt.Println("                         manifest keeps the latest watermark for 'tokenize -since'")
+	fmt.Println("  -config string         Config with the matching, linkage secret and keys sections")
+	fmt.Println("  -secret string         Linkage secret keying the linkage IDs (default:")
+	fmt.Println("                         tokenization.linkage_secret_file); use the one of the")
+	fmt.Println("                         previous crosswalk so unchanged clusters keep their IDs")
+	fmt.Println("  -allow-duplicates      Keep every pair within the thresholds (1:many); otherwise")
+	fmt.Println("                         changed records are assigned 1:1 among the records no kept")
+	fmt.Println("                         pair holds")
+	fmt.Println("  -max-matches-per-record <n>, -min-score <f>")
+	fmt.Println("                         Retention of the new pairs, as for intersect")
+	fmt.Printf("  -hamming-threshold <n> Maximum Hamming distance of a match (default: config or %d)\n", config.DefaultHammingThreshold)
+	fmt.Printf("  -jaccard-threshold <f> Minimum Jaccard similarity of a match (default: config or %g)\n", config.DefaultJaccardThreshold)
+	fmt.Println("  -no-blocking           Compare every pair even when both datasets carry blocking keys")
+	fmt.Println("  -encrypt               Encrypt the crosswalk (.enc)")
+	fmt.Println("  -key-source string     file, env, keyring, keychain, kms or pkcs11 (default: keys.source)")
+	fmt.Println("  -key string            Key file for encrypted (.enc) token files")
+	fmt.Println("  -help                  Show this help message")
+	fmt.Println()
+	fmt.Println("EXAMPLE (nightly):")
+	fmt.Println("  cohort-bridge tokenize -input data/a.csv -output out/a_delta.csv -since out/a.csv \\")
+	fmt.Println("    -no-encryption -force")
+	fmt.Println("  cohort-bridge delta -dataset1 out/a.csv -delta1 out/a_delta.csv -dataset2 out/b.csv \\")
+	fmt.Println("    -crosswalk out/crosswalk.csv -output out/crosswalk.csv -merged1 out/a.csv")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/status"
+)
+
+// errorCategory tells scripted orchestration why a command failed: each category exits with its
+// own code, and -log-format=json reports it with the error
+type errorCategory string
+
+// Error categories
+const (
+	InternalError errorCategory = "internal" // Anything not classified below
+	UsageError    errorCategory = "usage"    // Bad or missing flags, or input that cannot be prompted for
+	ConfigError   errorCategory = "config"   // Invalid configuration, recipe mismatches between parties
+	DataError     errorCategory = "data"     // Unreadable, missing or malformed input and output files
+	PeerError     errorCategory = "peer"     // Connecting to, authenticating or exchanging with the peer
+	CryptoError   errorCategory = "crypto"   // Keys, encryption, decryption and signatures
+)
+
+// exitCodes are the process exit codes of the categories; 2 is also what flag parsing exits with
+var exitCodes = map[errorCategory]int{
+	InternalError: 1,
+	UsageError:    2,
+	ConfigError:   3,
+	DataError:     4,
+	PeerError:     5,
+	CryptoError:   6,
+}
+
+// ExitCode is the process exit code of the category
+func (c errorCategory) ExitCode() int {
+	if code, ok := exitCodes[c]; ok {
+		return code
+	}
+	return 1
+}
+
+// categorizedError is an error carrying its category through the wrapping of callers
+type categorizedError struct {
+	category errorCategory
+	err      error
+}
+
+func (e *categorizedError) Error() string { return e.err.Error() }
+func (e *categorizedError) Unwrap() error { return e.err }
+
+// Wrap marks err as belonging to the category; nil stays nil
+func (c errorCategory) Wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &categorizedError{category: c, err: err}
+}
+
+// Errorf formats an error of the category, like fmt.Errorf
+func (c errorCategory) Errorf(format string, args ...interface{}) error {
+	return c.Wrap(fmt.Errorf(format, args...))
+}
+
+// categoryOf finds the category of err: the one it was marked with, or PeerError for unmarked
+// network failures, gRPC errors and rejected authentication
+func categoryOf(err error) (errorCategory, bool) {
+	var categorized *categorizedError
+	if errors.As(err, &categorized) {
+		return categorized.category, true
+	}
+	var authErr *peerAuthError
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	if errors.As(err, &authErr) || errors.As(err, &opErr) || errors.As(err, &dnsErr) {
+		return PeerError, true
+	}
+	if _, ok := status.FromError(err); ok {
+		return PeerError, true
+	}
+	return "", false
+}
+
+// logFormat is set by the global -log-format flag: text (default) or json
+var logFormat = "text"
+
+// currentCommand is the subcommand being run, reported with JSON errors
+var currentCommand string
+
+// fatalf reports a failure of the category and exits with its code. An error among args that
+// carries a category of its own overrides category, since it knows better what went wrong.
+func fatalf(category errorCategory, format string, args ...interface{}) {
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			if inner, ok := categoryOf(err); ok {
+				category = inner
+			}
+		}
+	}
+	exitWithError(category, fmt.Sprintf(format, args...))
+}
+
+// exitWithError writes message to stderr, as text or as one JSON object, and exits with the
+// category's code; with -porcelain, the failed command's summary goes to stdout first
+func exitWithError(category errorCategory, message string) {
+	porcelain.fail(category, message)
+	if logFormat == "json" {
+		entry := struct {
+			Time     string `json:"time"`
+			Level    string `json:"level"`
+			Command  string `json:"command,omitempty"`
+			Category string `json:"category"`
+			ExitCode int    `json:"exit_code"`
+			Error    string `json:"error"`
+		}{
+			Time:     time.Now().UTC().Format(time.RFC3339),
+			Level:    "error",
+			Command:  currentCommand,
+			Category: string(category),
+			ExitCode: category.ExitCode(),
+			Error:    strings.TrimSpace(trimErrorPrefix(message)),
+		}
+		data, _ := json.Marshal(entry)
+		fmt.Fprintln(os.Stderr, string(data))
+	} else {
+		fmt.Fprintln(os.Stderr, strings.TrimRight(message, "\n"))
+	}
+	os.Exit(category.ExitCode())
+}
+
+// trimErrorPrefix drops the "ERROR: " or "Error: " a text message starts with
+func trimErrorPrefix(message string) string {
+	for _, prefix := range []string{"ERROR: ", "Error: "} {
+		if rest, ok := strings.CutPrefix(message, prefix); ok {
+			return rest
+		}
+	}
+	return message
+}
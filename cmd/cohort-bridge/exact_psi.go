@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/crypto"
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+)
+
+// handshakeProtocol is the matching protocol announced in the recipe handshake. The standard
+// protocol is announced as no protocol, as by peers predating secure comparison, and an exact
+// identifier first pass is announced ahead of the fuzzy protocol, as in "psi+standard".
+func handshakeProtocol(cfg *config.Config) string {
+	protocol := cfg.Matching.Protocol
+	if usesExactFirstPass(cfg) {
+		return crypto.ProtocolPSI + "+" + protocol
+	}
+	if protocol == crypto.ProtocolStandard {
+		return ""
+	}
+	return protocol
+}
+
+// usesExactFirstPass reports whether exact identifiers are linked by PSI before fuzzy matching;
+// under the psi protocol they are the only matching
+func usesExactFirstPass(cfg *config.Config) bool {
+	return cfg.Matching.ExactFirstPass && cfg.Matching.Protocol != crypto.ProtocolPSI
+}
+
+// checkExactPSI fails unless the local tokens carry exact identifiers, and under the psi protocol
+// warns about the fuzzy matching settings it ignores
+func checkExactPSI(cfg *config.Config, localTokens *TokenData) error {
+	withID := 0
+	for _, record := range localTokens.Records {
+		if record.ExactID != "" {
+			withID++
+		}
+	}
+	if withID == 0 {
+		return fmt.Errorf("no record carries an exact identifier: set tokenization.exact_id to the column holding it (and re-tokenize pre-tokenized data)")
+	}
+	fmt.Printf("   Exact identifiers: %d of %d records\n", withID, len(localTokens.Records))
+	if cfg.Matching.Protocol == crypto.ProtocolPSI && (cfg.Matching.CalibrationFile != "" || cfg.Matching.MinScore > 0) {
+		fmt.Printf("   Warning: matching.calibration_file and matching.min_score are ignored with matching.protocol psi (pairs carry no scores)\n")
+	}
+	return nil
+}
+
+// withoutExactIDs returns the tokens sent to the peer by a party linking exact identifiers: its
+// tokens without them, since the identifiers are only compared blinded, by PSI
+func withoutExactIDs(localTokens *TokenData) *TokenData {
+	sent := &TokenData{Records: make(map[string]TokenRecord, len(localTokens.Records))}
+	for id, record := range localTokens.Records {
+		record.ExactID = ""
+		sent.Records[id] = record
+	}
+	return sent
+}
+
+// computeExactIntersection links the local tokens to the peer's sharing their exact identifier by
+// PSI, running the protocol's rounds over the transport
+func computeExactIntersection(transport peerTransport, localTokens *TokenData, cfg *config.Config, party int, allowDuplicates bool) (*IntersectionResult, error) {
+	fmt.Printf("   Using exact identifier PSI (Party %d)\n", party)
+	fmt.Printf("   Identifiers are compared blinded; both parties learn only which pairs share one\n")
+
+	localRecords, err := tokenDataToPPRLRecords(localTokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert local tokens: %v", err)
+	}
+
+	// Pairs carry no scores, so retention keeps no score floor and ranks pairs by ID alone
+	retention := matchRetention(cfg)
+	retention.MinJaccard = 0
+	fuzzyMatcher := match.NewFuzzyMatcher(&match.FuzzyMatchConfig{
+		Party:           party,
+		AllowDuplicates: allowDuplicates,
+		Assignment:      cfg.Matching.Assignment,
+		Retention:       retention,
+	})
+	secureResult, err := fuzzyMatcher.ComputeExactIntersection(transport.ExchangeSecureMessage, localRecords)
+	if err != nil {
+		return nil, fmt.Errorf("exact identifier PSI failed: %v", err)
+	}
+	return &IntersectionResult{Matches: fuzzyMatcher.MatchResults(secureResult)}, nil
+}
+
+// withoutLinked returns both parties' tokens less the records an exact first pass linked, which
+// are left to fuzzy matching
+func withoutLinked(localTokens, peerTokens *TokenData, linked *IntersectionResult) (*TokenData, *TokenData) {
+	localLinked := make(map[string]bool, len(linked.Matches))
+	peerLinked := make(map[string]bool, len(linked.Matches))
+	for _, m := range linked.Matches {
+		localLinked[m.LocalID] = true
+		peerLinked[m.PeerID] = true
+	}
+	remaining := func(tokens *TokenData, linked map[string]bool) *TokenData {
+		rest := &TokenData{Records: make(map[string]TokenRecord, len(tokens.Records))}
+		for id, record := range tokens.Records {
+			if !linked[id] {
+				rest.Records[id] = record
+			}
+		}
+		return rest
+	}
+	return remaining(localTokens, localLinked), remaining(peerTokens, peerLinked)
+}
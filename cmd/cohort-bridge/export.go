@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/keys"
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+	"github.com/auroradata-ai/cohort-bridge/internal/store"
+)
+
+// exportFlags are the flags of the export command
+type exportFlags struct {
+	fs         *flag.FlagSet
+	inputFile  *string
+	outputFile *string
+	format     *string
+	split      *bool
+	configFile *string
+	secretFile *string
+	encrypt    *bool
+	keySource  *string
+	help       *bool
+}
+
+// newExportFlags defines the flags of the export command
+func newExportFlags() *exportFlags {
+	fs := newFlagSet("export")
+	return &exportFlags{
+		fs:         fs,
+		inputFile:  fs.String("input", "", "Match results (pprl intersection JSON or intersect CSV)"),
+		outputFile: fs.String("output", "", "Crosswalk file (default: <input>_crosswalk.<format>)"),
+		format:     fs.String("format", "", "Crosswalk format: csv or json (default: from -output, else csv)"),
+		split:      fs.Bool("split", false, "Write one crosswalk per party, each holding only that party's IDs"),
+		configFile: fs.String("config", "", "Config with the linkage secret and key settings (optional)"),
+		secretFile: fs.String("secret", "", "Linkage secret keying the linkage IDs (default: tokenization.linkage_secret_file)"),
+		encrypt:    fs.Bool("encrypt", false, "Encrypt each crosswalk file"),
+		keySource:  fs.String("key-source", "", "Encryption key source: file, env, keyring, keychain, kms, pkcs11 (default: keys.source)"),
+		help:       fs.Bool("help", false, "Show help message"),
+	}
+}
+
+func runExportCommand(args []string) {
+	f := newExportFlags()
+	f.fs.Parse(args)
+
+	if *f.help {
+		showExportHelp()
+		return
+	}
+
+	if *f.inputFile == "" {
+		showExportHelp()
+		fatalf(UsageError, "Error: -input is required")
+	}
+
+	cfg := &config.Config{}
+	if *f.configFile != "" {
+		loaded, err := config.Load(*f.configFile)
+		if err != nil {
+			fatalf(ConfigError, "ERROR: Failed to load config: %v", err)
+		}
+		cfg = loaded
+	} else {
+		cfg.SetDefaults()
+	}
+	if *f.secretFile == "" {
+		*f.secretFile = cfg.Tokenization.LinkageSecretFile
+	}
+	if *f.keySource == "" {
+		*f.keySource = cfg.Keys.Source
+	}
+
+	if *f.format == "" {
+		*f.format = "csv"
+		if strings.EqualFold(filepath.Ext(*f.outputFile), ".json") {
+			*f.format = "json"
+		}
+	}
+	*f.format = strings.ToLower(*f.format)
+	if *f.format != "csv" && *f.format != "json" {
+		fatalf(UsageError, "Error: unknown format %q (expected csv or json)", *f.format)
+	}
+	if *f.outputFile == "" {
+		*f.outputFile = strings.TrimSuffix(*f.inputFile, filepath.Ext(*f.inputFile)) + "_crosswalk." + *f.format
+	}
+
+	fmt.Println("CohortBridge Crosswalk Export")
+	fmt.Println("=============================")
+	fmt.Printf("Input: %s\n", *f.inputFile)
+	fmt.Printf("Format: %s\n", *f.format)
+
+	var secret []byte
+	if *f.secretFile != "" {
+		loaded, err := pprl.LoadLinkageSecret(*f.secretFile)
+		if err != nil {
+			fatalf(CryptoError, "ERROR: %v", err)
+		}
+		secret = loaded
+		fmt.Printf("Linkage IDs: HMAC-SHA256 keyed (secret: %s)\n", *f.secretFile)
+	} else {
+		fmt.Println("Linkage IDs: unkeyed SHA-256 (set -secret so record IDs cannot be confirmed from linkage IDs)")
+	}
+	fmt.Println()
+
+	run := startRun("export", cfg)
+	run.Parameters["format"] = *f.format
+	run.Parameters["split"] = strconv.FormatBool(*f.split)
+	run.Parameters["keyed"] = strconv.FormatBool(secret != nil)
+	run.Parameters["encrypted"] = strconv.FormatBool(*f.encrypt)
+	run.AddInput(*f.inputFile)
+
+	if err := performCrosswalkExport(*f.inputFile, *f.outputFile, *f.format, *f.split, secret, *f.encrypt, *f.keySource, cfg, run); err != nil {
+		recordRun(run, err)
+		fatalf(DataError, "ERROR: Export failed: %v", err)
+	}
+	recordRun(run, nil)
+}
+
+// performCrosswalkExport builds the crosswalk of inputFile and writes it, or one file per party
+// when split is set
+func performCrosswalkExport(inputFile, outputFile, format string, split bool, secret []byte, encrypt bool, keySource string, cfg *config.Config, run *store.Run) error {
+	matches, err := loadMatchPairs(inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to load match results: %w", err)
+	}
+	clusters := match.BuildCrosswalk(matches, secret)
+
+	multi := 0
+	for _, cluster := range clusters {
+		if len(cluster.LocalIDs) > 1 || len(cluster.PeerIDs) > 1 {
+			multi++
+		}
+	}
+	run.Counts["matches"] = len(matches)
+	run.Counts["clusters"] = len(clusters)
+	fmt.Printf("Loaded %d matches\n", len(matches))
+	fmt.Printf("Linkage IDs: %d (%d clusters link more than one record per party)\n", len(clusters), multi)
+
+	ids, err := crosswalkLocalIDs(cfg)
+	if err != nil {
+		return err
+	}
+	outputs := []crosswalkOutput{{outputFile, match.CrosswalkRows(clusters), []string{"linkage_id", "local_id", "peer_id"}, ids}}
+	if split {
+		base := strings.TrimSuffix(outputFile, filepath.Ext(outputFile))
+		outputs = []crosswalkOutput{
+			{base + "_local." + format, match.PartyCrosswalkRows(clusters, true), []string{"linkage_id", "local_id"}, ids},
+			{base + "_peer." + format, match.PartyCrosswalkRows(clusters, false), []string{"linkage_id", "peer_id"}, nil},
+		}
+	}
+
+	for _, output := range outputs {
+		written, err := writeCrosswalkFile(output, format, encrypt, keySource, cfg)
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", output.path, err)
+		}
+		run.AddOutput(written)
+		fmt.Printf("Crosswalk saved to: %s (%d rows)\n", written, len(output.rows))
+	}
+	return nil
+}
+
+// crosswalkOutput is one crosswalk file and the CSV columns of its view
+type crosswalkOutput struct {
+	path     string
+	rows     []match.CrosswalkRow
+	columns  []string
+	localIDs *pprl.IDColumns // Composite local IDs split into their columns (nil writes local_id only)
+}
+
+// crosswalkLocalIDs returns the composite local IDs of cfg that crosswalks also write as their
+// columns, so rows join back to the source; nil when IDs are not composite or are hashed
+func crosswalkLocalIDs(cfg *config.Config) (*pprl.IDColumns, error) {
+	ids, err := newIDColumns(cfg)
+	if err != nil || !ids.Composite() || ids.Hashed() {
+		return nil, err
+	}
+	fmt.Printf("Local IDs: composite %s, also written as local_<column>\n", ids.Describe())
+	return ids, nil
+}
+
+// crosswalkJSONRow is a crosswalk row with the columns of its composite local ID
+type crosswalkJSONRow struct {
+	match.CrosswalkRow
+	LocalKey map[string]string `json:"local_key,omitempty"`
+}
+
+// localKey returns the columns of a composite local ID by name, or nil when it cannot be split
+func (o crosswalkOutput) localKey(id string) map[string]string {
+	values, ok := o.localIDs.Split(id)
+	if !ok {
+		return nil
+	}
+	key := make(map[string]string, len(values))
+	for i, column := range o.localIDs.Columns() {
+		key[column] = values[i]
+	}
+	return key
+}
+
+// writeCrosswalkFile writes a crosswalk file, encrypting it with a key of its own when the key
+// source is file, and returns the path written
+func writeCrosswalkFile(output crosswalkOutput, format string, encrypt bool, keySource string, cfg *config.Config) (string, error) {
+	if !encrypt {
+		return output.path, writeCrosswalkRows(output, output.path, format)
+	}
+
+	tempFile := output.path + ".tmp"
+	if err := writeCrosswalkRows(output, tempFile, format); err != nil {
+		os.Remove(tempFile)
+		return "", err
+	}
+	encryptedPath := output.path + ".enc"
+	if err := encryptOutputFile(tempFile, encryptedPath, keySource, cfg); err != nil {
+		return "", err
+	}
+	return encryptedPath, nil
+}
+
+// encryptOutputFile encrypts tempFile to encryptedPath with a key from keySource, saving a new
+// key beside it under the file source, and securely deletes tempFile
+func encryptOutputFile(tempFile, encryptedPath, keySource string, cfg *config.Config) error {
+	defer func() {
+		if err := secureDeleteFile(tempFile); err != nil {
+			fmt.Printf("Warning: failed to securely delete temporary file: %v\n", err)
+		}
+	}()
+	encryption, keyFile, err := resolveEncryptionKey(cfg, keySource, "", encryptedPath)
+	if err != nil {
+		return err
+	}
+
+	if keyFile != "" {
+		if err := keys.WriteKeyFile(keyFile, encryption.Key); err != nil {
+			return fmt.Errorf("failed to save encryption key: %w", err)
+		}
+		fmt.Printf("   Encryption key saved to: %s\n", keyFile)
+	}
+	header, err := keys.EncryptFile(tempFile, encryptedPath, encryption)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("   Encrypted with AES-256-GCM (key %s)\n", header.KeyID)
+	return nil
+}
+
+// writeCrosswalkRows writes the rows of a crosswalk file to path as CSV or as a JSON array
+func writeCrosswalkRows(output crosswalkOutput, path, format string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if format == "json" {
+		rows := make([]crosswalkJSONRow, 0, len(output.rows))
+		for _, row := range output.rows {
+			rows = append(rows, crosswalkJSONRow{CrosswalkRow: row, LocalKey: output.localKey(row.LocalID)})
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(rows)
+	}
+
+	header := output.columns
+	var keyColumns []string
+	if output.localIDs != nil && slices.Contains(output.columns, "local_id") {
+		keyColumns = output.localIDs.Columns()
+		header = append([]string(nil), output.columns...)
+		for _, column := range keyColumns {
+			header = append(header, "local_"+column)
+		}
+	}
+	writer := csv.NewWriter(file)
+	writer.Write(header)
+	for _, row := range output.rows {
+		record := make([]string, 0, len(header))
+		for _, column := range output.columns {
+			switch column {
+			case "linkage_id":
+				record = append(record, row.LinkageID)
+			case "local_id":
+				record = append(record, row.LocalID)
+			case "peer_id":
+				record = append(record, row.PeerID)
+			}
+		}
+		if keyColumns != nil {
+			key := output.localKey(row.LocalID)
+			for _, column := range keyColumns {
+				record = append(record, key[column])
+			}
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// loadMatchPairs reads match pairs from a pprl intersection JSON file ({"matches": [...]}), a JSON
+// crosswalk, or a CSV with local_id,peer_id columns (intersect results or a crosswalk) and optional
+// # comment lines
+func loadMatchPairs(path string) ([]*match.PrivateMatchResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") {
+		var intersection IntersectionResult
+		if err := json.Unmarshal(data, &intersection); err != nil {
+			return nil, fmt.Errorf("invalid intersection JSON: %w", err)
+		}
+		return intersection.Matches, nil
+	}
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		var rows []match.CrosswalkRow
+		if err := json.Unmarshal(data, &rows); err != nil {
+			return nil, fmt.Errorf("invalid crosswalk JSON: %w", err)
+		}
+		matches := make([]*match.PrivateMatchResult, 0, len(rows))
+		for _, row := range rows {
+			if row.LocalID == "" || row.PeerID == "" {
+				return nil, fmt.Errorf("crosswalk row %s lacks local_id or peer_id (a -split crosswalk holds one party's IDs only)", row.LinkageID)
+			}
+			matches = append(matches, &match.PrivateMatchResult{LocalID: row.LocalID, PeerID: row.PeerID})
+		}
+		return matches, nil
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		if line := scanner.Text(); !strings.HasPrefix(line, "#") && strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	rows, err := csv.NewReader(strings.NewReader(strings.Join(lines, "\n"))).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	localCol, peerCol := -1, -1
+	for i, name := range rows[0] {
+		switch strings.TrimSpace(strings.ToLower(name)) {
+		case "local_id":
+			localCol = i
+		case "peer_id":
+			peerCol = i
+		}
+	}
+	if localCol < 0 || peerCol < 0 {
+		return nil, fmt.Errorf("CSV must have local_id and peer_id columns")
+	}
+
+	matches := make([]*match.PrivateMatchResult, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		if localCol >= len(row) || peerCol >= len(row) {
+			return nil, fmt.Errorf("short CSV row: %v", row)
+		}
+		matches = append(matches, &match.PrivateMatchResult{LocalID: row[localCol], PeerID: row[peerCol]})
+	}
+	return matches, nil
+}
+
+func showExportHelp() {
+	fmt.Println("CohortBridge Crosswalk Export")
+	fmt.Println("=============================")
+	fmt.Println()
+	fmt.Println("Turn match results into a crosswalk for downstream research teams: a")
+	fmt.Println("stable, anonymous linkage ID per matched cluster plus each party's local")
+	fmt.Println("record ID. Linked records are clustered transitively, and the linkage ID")
+	fmt.Println("is a hash of the cluster's sorted record IDs, so both parties derive the")
+	fmt.Println("same IDs from their own results. With the shared linkage secret the hash")
+	fmt.Println("is keyed, so nobody without it can tell which records an ID stands for.")
+	fmt.Println()
+	fmt.Println("USAGE:")
+	fmt.Println("  cohort-bridge export -input out/intersection_results_data.json [OPTIONS]")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Println("  -input string        pprl intersection JSON or intersect CSV (local_id,peer_id)")
+	fmt.Println("  -output string       Crosswalk file (default: <input>_crosswalk.<format>)")
+	fmt.Println("  -format string       csv or json (default: from -output, else csv)")
+	fmt.Println("  -split               Write <output>_local and <output>_peer, each with only one")
+	fmt.Println("                       party's IDs, so each can be handed over separately")
+	fmt.Println("  -config string       Config with tokenization.linkage_secret_file and keys settings")
+	fmt.Println("  -secret string       Linkage secret file keying the IDs (overrides the config)")
+	fmt.Println("  -encrypt             Encrypt each file (.enc); the file key source writes a")
+	fmt.Println("                       separate .key per file, so each party gets its own key")
+	fmt.Println("  -key-source string   file, env, keyring, keychain, kms or pkcs11 (default: keys.source)")
+	fmt.Println("  -help                Show this help message")
+	fmt.Println()
+	fmt.Println("OUTPUT:")
+	fmt.Println("  linkage_id,local_id,peer_id      (full crosswalk)")
+	fmt.Println("  linkage_id,local_id | peer_id    (-split)")
+	fmt.Println("  With a composite database.id_column in -config, local IDs are also split into")
+	fmt.Println("  local_<column> columns (local_key in JSON), unless database.id_hash is set.")
+	fmt.Println()
+	fmt.Println("EXAMPLES:")
+	fmt.Println("  cohort-bridge export -input out/intersection_results_data.json -config config.yaml")
+	fmt.Println("  cohort-bridge export -input zk_intersection_results.csv -format json -split -encrypt")
+}
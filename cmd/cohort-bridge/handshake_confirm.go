@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/transfer"
+)
+
+// HandshakeConfirm is a MAC over the version hellos and recipe handshakes as one party saw them,
+// keyed with the recipe secrets both parties hold. The parties swap confirmations before any
+// tokens, so a relay or man-in-the-middle that altered what either party offered (stripping its
+// payload key, say, to make both fall back to plaintext) is caught rather than obeyed.
+type HandshakeConfirm struct {
+	MAC []byte `json:"mac"`
+}
+
+// confirmsHandshake reports whether the peer's hello listed handshake confirmation
+func (r *peerRelease) confirmsHandshake() bool {
+	return r != nil && r.features[featureHandshakeConfirm]
+}
+
+// confirmHandshake swaps confirmations through swap when the peer's hello listed them, and fails
+// if the peer's does not match this party's view of the session. It reports whether the
+// handshake was confirmed.
+func confirmHandshake(localRecipe, peerRecipe *RecipeHandshake, isServer bool,
+	swap func(local *HandshakeConfirm) (*HandshakeConfirm, error)) (bool, error) {
+	if !localRecipe.peer.confirmsHandshake() {
+		return false, nil
+	}
+	transcript := handshakeTranscript(localRecipe, peerRecipe, isServer)
+	peer, err := swap(&HandshakeConfirm{MAC: handshakeMAC(localRecipe.secret, transcript, isServer)})
+	if err != nil {
+		return false, err
+	}
+	if !hmac.Equal(peer.MAC, handshakeMAC(localRecipe.secret, transcript, !isServer)) {
+		return false, PeerError.Errorf("handshake confirmation failed: the peer saw different version hellos or recipe handshakes " +
+			"than this party, so they were altered in transit (by a relay or man-in-the-middle)")
+	}
+	fmt.Printf("   Handshake confirmed by the peer\n")
+	return true, nil
+}
+
+// handshakeTranscript encodes the hellos and recipe handshakes of the session, the client's
+// first, as this party sent and received them. It covers the handshake fields both transports
+// carry.
+func handshakeTranscript(localRecipe, peerRecipe *RecipeHandshake, isServer bool) []byte {
+	hellos := []*peerHello{newPeerHello(), localRecipe.peer.hello}
+	recipes := []*RecipeHandshake{localRecipe, peerRecipe}
+	if isServer {
+		hellos[0], hellos[1] = hellos[1], hellos[0]
+		recipes[0], recipes[1] = recipes[1], recipes[0]
+	}
+
+	var transcript []byte
+	field := func(value []byte) {
+		transcript = binary.BigEndian.AppendUint64(transcript, uint64(len(value)))
+		transcript = append(transcript, value...)
+	}
+	for _, hello := range hellos {
+		field([]byte(hello.SoftwareVersion))
+		field([]byte(hello.MinPeerVersion))
+		field([]byte(strings.Join(hello.Features, ",")))
+	}
+	for _, recipe := range recipes {
+		reconcile, _ := json.Marshal(recipe.Reconcile)
+		field([]byte(recipe.Fingerprint))
+		field([]byte(recipe.Summary))
+		field(recipe.PayloadKey)
+		field([]byte(strconv.FormatBool(recipe.IntersectionDigest)))
+		field(reconcile)
+		field([]byte(recipe.Protocol))
+	}
+	return transcript
+}
+
+// handshakeMAC is the confirmation the client, or with isServer the server, sends for transcript
+func handshakeMAC(secret, transcript []byte, isServer bool) []byte {
+	role := "client"
+	if isServer {
+		role = "server"
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("cohort-bridge handshake confirm v1 " + role + "\x00"))
+	mac.Write(transcript)
+	return mac.Sum(nil)
+}
+
+// exchangeHandshakeConfirm swaps handshake confirmations over the transfer channel
+func exchangeHandshakeConfirm(channel *transfer.Channel, local *HandshakeConfirm, isServer bool) (*HandshakeConfirm, error) {
+	send := func() error {
+		if err := sendPeerMessage(channel, PeerMessage{Type: "handshake_confirm", Payload: local}); err != nil {
+			return fmt.Errorf("failed to send handshake confirmation: %v", err)
+		}
+		return nil
+	}
+
+	peer := &HandshakeConfirm{}
+	receive := func() error {
+		var peerMessage PeerMessage
+		if err := receivePeerMessage(channel, &peerMessage); err != nil {
+			return fmt.Errorf("failed to receive handshake confirmation: %v", err)
+		}
+		if peerMessage.Type != "handshake_confirm" {
+			return PeerError.Errorf("peer sent %q instead of a handshake confirmation, although its hello offered one", peerMessage.Type)
+		}
+		if err := mapToStruct(peerMessage.Payload, peer); err != nil {
+			return fmt.Errorf("failed to parse handshake confirmation: %v", err)
+		}
+		return nil
+	}
+
+	// Server receives first, client sends first
+	steps := []func() error{send, receive}
+	if isServer {
+		steps = []func() error{receive, send}
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return nil, err
+		}
+	}
+	return peer, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/crypto"
+	"github.com/auroradata-ai/cohort-bridge/internal/db"
+	"github.com/auroradata-ai/cohort-bridge/internal/hl7"
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+	"github.com/auroradata-ai/cohort-bridge/internal/store"
+)
+
+// loadHL7Records reads the demographics of every ADT^A01/A08 message in an HL7v2 file.
+// Later messages for the same patient (e.g. A08 updates) replace earlier ones.
+func loadHL7Records(inputFile string) ([]map[string]string, error) {
+	file, err := db.OpenInput(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []map[string]string
+	index := make(map[string]int)
+	skipped := 0
+	for i, raw := range hl7.SplitMessages(data) {
+		message, err := hl7.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", i+1, err)
+		}
+		record, err := hl7.Demographics(message)
+		if errors.Is(err, hl7.ErrUnsupportedMessage) {
+			skipped++
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("message %d (%s): %w", i+1, message.ControlID(), err)
+		}
+
+		if existing, ok := index[record[hl7.FieldID]]; ok {
+			records[existing] = record
+			continue
+		}
+		index[record[hl7.FieldID]] = len(records)
+		records = append(records, record)
+	}
+
+	if skipped > 0 {
+		fmt.Printf("   Skipped %d messages that are not ADT^A01/A08\n", skipped)
+	}
+	return records, nil
+}
+
+// runMLLPTokenizeMode runs `tokenize -mllp` and records it as a tokenize run when the listener stops
+func runMLLPTokenizeMode(address, outputFile string, recipe config.TokenizationConfig, mainCfg *config.Config, fields []string, normalizationConfig map[string]crypto.NormalizationMethod) {
+	recordConfig, err := newRecordConfig(recipe, mainCfg.Keys)
+	if err != nil {
+		fatalf(ConfigError, "ERROR: Invalid tokenization recipe: %v", err)
+	}
+	if recordConfig.Columns, err = newColumnMapping(mainCfg); err != nil {
+		fatalf(ConfigError, "ERROR: %v", err)
+	}
+	if recordConfig.IDColumns, err = newIDColumns(mainCfg); err != nil {
+		fatalf(ConfigError, "ERROR: %v", err)
+	}
+
+	recipeCfg := *mainCfg
+	recipeCfg.Tokenization = recipe
+	run := startRun("tokenize", &recipeCfg)
+	run.Parameters["fields"] = strings.Join(fields, ",")
+	run.Parameters["recipe"] = recipeCfg.RecipeSummary()
+	run.Parameters["encrypted"] = "false"
+	run.Parameters["mllp"] = address
+	run.Parameters["id_mode"] = recordConfig.IDs.Mode()
+	if recordConfig.Columns != nil {
+		run.Parameters["column_mapping"] = recordConfig.Columns.String()
+	}
+
+	// Tokens are appended to earlier ones, which must have been written with the same recipe
+	if _, err := os.Stat(outputFile); err == nil {
+		if _, err := checkTokenFormat(outputFile, recipeCfg.RecipeFingerprint(recordConfig.LinkageSecret), recipeCfg.RecipeSummary()); err != nil {
+			fatalf(ConfigError, "ERROR: %v", err)
+		}
+	}
+
+	tokenized, err := runMLLPTokenization(address, outputFile, fields, recordConfig, normalizationConfig, run)
+	run.Counts["records"] = tokenized
+	if err != nil {
+		recordRun(run, err)
+		fatalf(DataError, "ERROR: MLLP tokenization failed: %v", err)
+	}
+	run.AddOutput(outputFile)
+	writeTokenFormat(outputFile, "csv", false, &recipeCfg, recordConfig, 0)
+	recordRun(run, nil)
+	fmt.Printf("Tokenized %d messages into %s\n", tokenized, outputFile)
+}
+
+// runMLLPTokenization listens for HL7v2 messages over MLLP and appends a tokenized row for each
+// ADT^A01/A08 message to outputFile until interrupted. It returns the number of rows written.
+func runMLLPTokenization(address, outputFile string, fields []string, recordConfig *pprl.RecordConfig, normalizationConfig map[string]crypto.NormalizationMethod, run *store.Run) (int, error) {
+	tokenizer, err := newRecordTokenizer(fields, recordConfig, normalizationConfig)
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.OpenFile(outputFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if info, err := file.Stat(); err == nil && info.Size() == 0 {
+		writer.Write(tokenizer.header())
+		writer.Flush()
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return 0, fmt.Errorf("failed to listen for MLLP connections: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Listening for HL7v2 messages over MLLP on %s (Ctrl+C to stop)\n", listener.Addr())
+	fmt.Printf("Appending tokens to %s\n", outputFile)
+
+	var mu sync.Mutex
+	written := 0
+	handle := func(message *hl7.Message) error {
+		record, err := hl7.Demographics(message)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		row, err := tokenizer.row(record, record[hl7.FieldID])
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			fmt.Printf("   Message %s: not tokenized (no data, or skipped for missing fields)\n", message.ControlID())
+			return nil
+		}
+		// Each row is flushed before the message is acknowledged
+		writer.Write(row)
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+		written++
+		fmt.Printf("   Message %s: tokenized (%d total)\n", message.ControlID(), written)
+		return nil
+	}
+
+	if err := hl7.Serve(ctx, listener, handle); err != nil {
+		return written, err
+	}
+	fmt.Println("\nMLLP listener stopped")
+	tokenizer.reportMissingData(run)
+	if err := tokenizer.reportSanitization(run); err != nil {
+		return written, err
+	}
+	if err := tokenizer.reportDensity(run); err != nil {
+		return written, err
+	}
+	return written, nil
+}
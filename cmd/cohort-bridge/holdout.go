@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+	"github.com/auroradata-ai/cohort-bridge/internal/store"
+)
+
+// loadHoldout loads the known pairs of matching.holdout_file, with local IDs in id1 and the peer's
+// in id2, keyed like the local record IDs. The holdout never leaves this party: it only scores the
+// intersection once both peers agreed on it.
+func loadHoldout(path string, ids *pprl.IDColumns) (groundTruth, error) {
+	if path == "" {
+		return nil, nil
+	}
+	truth, err := loadGroundTruth(path)
+	if err != nil {
+		return nil, err
+	}
+	return truth.keyed(ids, nil), nil
+}
+
+// scoreHoldout estimates the run's quality from the holdout. Like a per-file validation, only the
+// matches involving a record the holdout lists are judged, so the records nobody reviewed do not
+// count as false positives. Pseudonymized local IDs are mapped back to the IDs the holdout lists.
+func scoreHoldout(matches []*match.PrivateMatchResult, holdout groundTruth, path string, ids *pprl.IDMapper) (*store.Quality, error) {
+	listedLocal, listedPeer := make(map[string]bool), make(map[string]bool)
+	for pair := range holdout {
+		listedLocal[pair.ID1] = true
+		listedPeer[pair.ID2] = true
+	}
+
+	var judged []*match.PrivateMatchResult
+	for _, m := range matches {
+		localID, _, err := ids.Original(m.LocalID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map pseudonym %s: %w", m.LocalID, err)
+		}
+		if listedLocal[localID] || listedPeer[m.PeerID] {
+			judged = append(judged, &match.PrivateMatchResult{LocalID: localID, PeerID: m.PeerID})
+		}
+	}
+
+	result := validateResults(judged, nil, holdout)
+	return &store.Quality{
+		Holdout:        path,
+		KnownPairs:     len(holdout),
+		TruePositives:  result.TruePositives,
+		FalsePositives: result.FalsePositives,
+		FalseNegatives: result.FalseNegatives,
+		Precision:      result.Precision,
+		Recall:         result.Recall,
+		F1Score:        result.F1Score,
+	}, nil
+}
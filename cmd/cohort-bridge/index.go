@@ -0,0 +1,655 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/crypto"
+	"github.com/auroradata-ai/cohort-bridge/internal/db"
+	"github.com/auroradata-ai/cohort-bridge/internal/keys"
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+	"github.com/auroradata-ai/cohort-bridge/internal/server"
+	"github.com/auroradata-ai/cohort-bridge/internal/store"
+)
+
+// A saved index is a directory holding the indexed tokens as a binary token store, the LSH
+// buckets of their MinHash signatures and a manifest describing both
+const (
+	indexManifestFile = "index.json"
+	indexTokensFile   = "tokens" + pprl.BloomStoreExt
+	indexBucketsFile  = "buckets.lsh"
+
+	indexFormatName    = "cohort-bridge-index"
+	indexFormatVersion = 1
+)
+
+// indexManifest describes a saved index
+type indexManifest struct {
+	Format            string           `json:"format"`
+	Version           int              `json:"format_version"`
+	Producer          string           `json:"producer"`
+	Created           time.Time        `json:"created"`
+	Source            store.FileDigest `json:"source"` // Token file the index was built from
+	Records           int              `json:"records"`
+	BandSize          int              `json:"band_size"`
+	Buckets           int              `json:"buckets"`
+	Recipe            string           `json:"recipe,omitempty"`
+	RecipeFingerprint string           `json:"recipe_fingerprint,omitempty"`
+}
+
+func runIndexCommand(args []string) {
+	if len(args) == 0 || args[0] == "-help" || args[0] == "--help" || args[0] == "help" {
+		showIndexHelp()
+		return
+	}
+
+	switch args[0] {
+	case "build":
+		runIndexBuild(args[1:])
+	case "query":
+		runIndexQuery(args[1:])
+	default:
+		showIndexHelp()
+		fatalf(UsageError, "Unknown index action: %s", args[0])
+	}
+}
+
+// indexFlags defines the flags of an index action for the command table
+func indexFlags(action string) *flag.FlagSet {
+	if action == "query" {
+		return newIndexQueryFlags().fs
+	}
+	return newIndexBuildFlags().fs
+}
+
+// indexBuildFlags are the flags of the index build command
+type indexBuildFlags struct {
+	fs         *flag.FlagSet
+	inputFile  *string
+	outputDir  *string
+	bandSize   *int
+	configFile *string
+	keyFile    *string
+	force      *bool
+	help       *bool
+}
+
+// newIndexBuildFlags defines the flags of the index build command
+func newIndexBuildFlags() *indexBuildFlags {
+	fs := newFlagSet("index build")
+	return &indexBuildFlags{
+		fs:         fs,
+		inputFile:  fs.String("input", "", "Tokenized dataset to index"),
+		outputDir:  fs.String("output", "", "Index directory to create (default: <input>.cbidx)"),
+		bandSize:   fs.Int("band-size", crypto.DefaultStreamBandSize, "MinHash values per LSH band"),
+		configFile: fs.String("config", "", "Config with the storage section (optional)"),
+		keyFile:    fs.String("key", "", "Key file for an encrypted (.enc) dataset"),
+		force:      fs.Bool("force", false, "Replace an existing index"),
+		help:       fs.Bool("help", false, "Show help message"),
+	}
+}
+
+func runIndexBuild(args []string) {
+	f := newIndexBuildFlags()
+	f.fs.Parse(args)
+
+	if *f.help {
+		showIndexHelp()
+		return
+	}
+	if *f.inputFile == "" {
+		showIndexHelp()
+		fatalf(UsageError, "Error: -input is required")
+	}
+	if *f.bandSize <= 0 {
+		fatalf(UsageError, "Error: -band-size must be positive")
+	}
+	if *f.outputDir == "" {
+		*f.outputDir = strings.TrimSuffix(filepath.Base(*f.inputFile), filepath.Ext(*f.inputFile)) + ".cbidx"
+	}
+	if _, err := os.Stat(*f.outputDir); err == nil && !*f.force {
+		fatalf(UsageError, "ERROR: %s already exists (use -force to replace it)", *f.outputDir)
+	}
+
+	cfg := loadOptionalConfig(*f.configFile)
+	keySource, err := indexKeySource(cfg, *f.keyFile)
+	if err != nil {
+		fatalf(CryptoError, "ERROR: %v", err)
+	}
+	local, cleanup, err := stageInput(cfg, *f.inputFile)
+	if err != nil {
+		fatalf(DataError, "ERROR: %v", err)
+	}
+	defer cleanup()
+
+	fmt.Println("CohortBridge Index Build")
+	fmt.Println("========================")
+	fmt.Printf("Input: %s\n", *f.inputFile)
+	fmt.Printf("Index: %s\n", *f.outputDir)
+	fmt.Printf("Bands: %d MinHash values per band\n", *f.bandSize)
+	fmt.Println()
+
+	run := startRun("index", cfg)
+	run.Parameters["action"] = "build"
+	run.Parameters["band_size"] = strconv.Itoa(*f.bandSize)
+	addStagedInput(run, local, *f.inputFile)
+
+	manifest, err := buildIndex(local, *f.inputFile, *f.outputDir, *f.bandSize, keySource)
+	if err != nil {
+		recordRun(run, err)
+		cleanup()
+		fatalf(DataError, "ERROR: Index build failed: %v", err)
+	}
+	run.AddOutput(*f.outputDir)
+	run.Counts["records"] = manifest.Records
+	run.Counts["buckets"] = manifest.Buckets
+	recordRun(run, nil)
+
+	fmt.Printf("Indexed %d records in %d LSH buckets\n", manifest.Records, manifest.Buckets)
+	fmt.Printf("Index saved to: %s\n", *f.outputDir)
+	fmt.Println("Note: the index holds the tokens unencrypted, as a memory-mapped token store;")
+	fmt.Println("      protect it as you would the decrypted token file")
+}
+
+// buildIndex writes the index of a token file, staged from source, into outputDir. It is built
+// in a temporary directory beside outputDir and renamed into place, so a query never sees a
+// partial index.
+func buildIndex(inputFile, source, outputDir string, bandSize int, keySource keys.Source) (*indexManifest, error) {
+	format, err := checkTokenFormat(inputFile, "", "")
+	if err != nil {
+		return nil, err
+	}
+	digest, err := store.HashFile(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	digest.Path = source
+
+	parent := filepath.Dir(filepath.Clean(outputDir))
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", parent, err)
+	}
+	staging, err := os.MkdirTemp(parent, "."+filepath.Base(outputDir)+".tmp-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create index directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+	tokensPath := filepath.Join(staging, indexTokensFile)
+
+	fmt.Println("Writing token store...")
+	records, err := writeIndexTokens(inputFile, tokensPath, keySource)
+	if err != nil {
+		return nil, err
+	}
+	if records == 0 {
+		return nil, fmt.Errorf("%s holds no records", inputFile)
+	}
+
+	tokens, err := pprl.OpenBloomStore(tokensPath)
+	if err != nil {
+		return nil, err
+	}
+	defer tokens.Close()
+	fmt.Println("Hashing LSH buckets...")
+	index, err := match.NewFuzzyMatcher(&match.FuzzyMatchConfig{}).NewStoreStreamIndex(tokens, true, bandSize)
+	if err != nil {
+		return nil, err
+	}
+	buckets, err := os.Create(filepath.Join(staging, indexBucketsFile))
+	if err != nil {
+		return nil, err
+	}
+	if err := index.WriteBuckets(buckets); err != nil {
+		buckets.Close()
+		return nil, fmt.Errorf("failed to write LSH buckets: %w", err)
+	}
+	if err := buckets.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write LSH buckets: %w", err)
+	}
+
+	manifest := &indexManifest{
+		Format:   indexFormatName,
+		Version:  indexFormatVersion,
+		Producer: softwareVersion,
+		Created:  time.Now().UTC(),
+		Source:   digest,
+		Records:  tokens.Len(),
+		BandSize: bandSize,
+		Buckets:  index.Buckets(),
+	}
+	if format != nil {
+		// The token store keeps the source's recipe, so queries are checked against it
+		manifest.Recipe, manifest.RecipeFingerprint = format.Recipe, format.RecipeFingerprint
+		storeFormat := db.NewTokenFormat("cbbf", false, format.Producer, format.ProducerCommit, format.Recipe, format.RecipeFingerprint, tokens.Len())
+		if err := db.WriteTokenFormat(tokensPath, storeFormat); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeIndexManifest(staging, manifest); err != nil {
+		return nil, err
+	}
+
+	if err := os.RemoveAll(outputDir); err != nil {
+		return nil, fmt.Errorf("failed to replace %s: %w", outputDir, err)
+	}
+	if err := os.Rename(staging, outputDir); err != nil {
+		return nil, fmt.Errorf("failed to save index: %w", err)
+	}
+	return manifest, nil
+}
+
+// writeIndexTokens copies the records of a token file into a binary token store, returning how
+// many were written. A token store is copied as it is.
+func writeIndexTokens(inputFile, tokensPath string, keySource keys.Source) (int, error) {
+	if pprl.IsBloomStore(inputFile) {
+		source, err := pprl.OpenBloomStore(inputFile)
+		if err != nil {
+			return 0, err
+		}
+		defer source.Close()
+		m, k, s := source.Params()
+		writer, err := pprl.NewBloomStoreWriter(tokensPath, m, k, s)
+		if err != nil {
+			return 0, err
+		}
+		for i := 0; i < source.Len(); i++ {
+			if err := writer.Append(source.ID(i), source.Filter(i), source.Signature(i)); err != nil {
+				writer.Close()
+				return 0, err
+			}
+		}
+		return source.Len(), writer.Close()
+	}
+
+	records, err := server.LoadTokenizedRecords(inputFile, false, keySource)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load %s: %w", inputFile, err)
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+	var writer *pprl.BloomStoreWriter
+	for _, record := range records {
+		bf, err := pprl.BloomFromBase64(record.BloomData)
+		if err != nil {
+			if writer != nil {
+				writer.Close()
+			}
+			return 0, fmt.Errorf("failed to decode Bloom filter for %s: %w", record.ID, err)
+		}
+		if len(record.MinHash) == 0 {
+			if writer != nil {
+				writer.Close()
+			}
+			return 0, fmt.Errorf("record %s has no MinHash signature", record.ID)
+		}
+		if writer == nil {
+			// Every record of a token file shares the first record's filter and signature sizes
+			if writer, err = pprl.NewBloomStoreWriter(tokensPath, bf.GetSize(), bf.HashCount(), uint32(len(record.MinHash))); err != nil {
+				return 0, err
+			}
+		}
+		if err := writer.Append(record.ID, bf, record.MinHash); err != nil {
+			writer.Close()
+			return 0, err
+		}
+	}
+	return len(records), writer.Close()
+}
+
+// writeIndexManifest saves the manifest of the index in dir
+func writeIndexManifest(dir string, manifest *indexManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, indexManifestFile), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write index manifest: %w", err)
+	}
+	return nil
+}
+
+// readIndexManifest reads and checks the manifest of the index in dir
+func readIndexManifest(dir string) (*indexManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, indexManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not an index (run 'cohort-bridge index build'): %w", dir, err)
+	}
+	var manifest indexManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid index manifest in %s: %w", dir, err)
+	}
+	if manifest.Format != indexFormatName {
+		return nil, fmt.Errorf("%s: manifest is not a %s manifest (format %q)", dir, indexFormatName, manifest.Format)
+	}
+	if manifest.Version > indexFormatVersion {
+		return nil, fmt.Errorf("%s was built by cohort-bridge %s in index format version %d, newer than this release reads (up to %d): upgrade cohort-bridge or build the index again",
+			dir, manifest.Producer, manifest.Version, indexFormatVersion)
+	}
+	return &manifest, nil
+}
+
+// indexQueryFlags are the flags of the index query command
+type indexQueryFlags struct {
+	fs             *flag.FlagSet
+	indexDir       *string
+	dataset        *string
+	outputFile     *string
+	configFile     *string
+	columns        *string
+	format         *string
+	metadata       *string
+	allowDups      *bool
+	keyFile        *string
+	help           *bool
+	thresholdFlags *thresholdFlags
+	retention      *retentionFlags
+}
+
+// newIndexQueryFlags defines the flags of the index query command
+func newIndexQueryFlags() *indexQueryFlags {
+	fs := newFlagSet("index query")
+	return &indexQueryFlags{
+		fs:             fs,
+		indexDir:       fs.String("index", "", "Index directory from 'index build'"),
+		dataset:        fs.String("dataset", "", "Tokenized batch to match against the index"),
+		outputFile:     fs.String("output", "index_query_results.csv", "Output file for the matches"),
+		configFile:     fs.String("config", "", "Config with the matching and output sections (optional)"),
+		columns:        fs.String("output-columns", "", "Comma-separated result columns (default: output.columns or local_id,peer_id)"),
+		format:         fs.String("output-format", "", "Result format: csv, jsonl or postgres (default: output.format or csv)"),
+		metadata:       fs.String("output-meta", "", "Static columns added to every row, as name=value,..."),
+		allowDups:      fs.Bool("allow-duplicates", false, "Keep every matching pair (1:many) instead of at most one per record"),
+		keyFile:        fs.String("key", "", "Key file for an encrypted (.enc) batch"),
+		help:           fs.Bool("help", false, "Show help message"),
+		thresholdFlags: addThresholdFlags(fs),
+		retention:      addRetentionFlags(fs),
+	}
+}
+
+func runIndexQuery(args []string) {
+	f := newIndexQueryFlags()
+	f.fs.Parse(args)
+
+	if *f.help {
+		showIndexHelp()
+		return
+	}
+	if *f.indexDir == "" || *f.dataset == "" {
+		showIndexHelp()
+		fatalf(UsageError, "Error: -index and -dataset are required")
+	}
+
+	cfg := loadOptionalConfig(*f.configFile)
+	f.retention.apply(cfg)
+	schema, err := newResultSchema(cfg, *f.columns, *f.format, *f.metadata)
+	if err != nil {
+		fatalf(ConfigError, "ERROR: %v", err)
+	}
+	var thresholds config.Thresholds
+	if *f.configFile != "" {
+		thresholds = f.thresholdFlags.resolve(cfg)
+	} else {
+		thresholds = f.thresholdFlags.resolve()
+	}
+	if schema.Format == "jsonl" && *f.outputFile == "index_query_results.csv" {
+		*f.outputFile = "index_query_results.jsonl"
+	}
+	keySource, err := indexKeySource(cfg, *f.keyFile)
+	if err != nil {
+		fatalf(CryptoError, "ERROR: %v", err)
+	}
+
+	manifest, err := readIndexManifest(*f.indexDir)
+	if err != nil {
+		fatalf(DataError, "ERROR: %v", err)
+	}
+	local, cleanup, err := stageInput(cfg, *f.dataset)
+	if err != nil {
+		fatalf(DataError, "ERROR: %v", err)
+	}
+	defer cleanup()
+	localOutput, uploadOutput, err := stageOutput(cfg, *f.outputFile)
+	if err != nil {
+		cleanup()
+		fatalf(DataError, "ERROR: %v", err)
+	}
+
+	fmt.Println("CohortBridge Index Query")
+	fmt.Println("========================")
+	fmt.Printf("Index: %s (%d records from %s, built %s)\n", *f.indexDir, manifest.Records, manifest.Source.Path, manifest.Created.Local().Format("2006-01-02 15:04"))
+	fmt.Printf("Batch: %s\n", *f.dataset)
+	fmt.Printf("Output: %s\n", schema.destination(*f.outputFile))
+	fmt.Printf("Thresholds: %s\n", thresholds)
+	fmt.Println()
+
+	run := startRun("index", cfg)
+	run.Parameters["action"] = "query"
+	run.Parameters["index"] = *f.indexDir
+	run.Parameters["index_source_sha256"] = manifest.Source.SHA256
+	run.Parameters["hamming_threshold"] = strconv.FormatUint(uint64(thresholds.Hamming), 10)
+	run.Parameters["jaccard_threshold"] = strconv.FormatFloat(thresholds.Jaccard, 'g', -1, 64)
+	if *f.allowDups {
+		run.Parameters["allow_duplicates"] = "true"
+		run.Parameters["max_matches_per_record"] = strconv.Itoa(schema.Retention.MaxPerRecord)
+	}
+	addStagedInput(run, local, *f.dataset)
+
+	err = queryIndex(*f.indexDir, local, localOutput, thresholds, *f.allowDups, keySource, schema, run)
+	if err == nil && schema.Postgres == nil {
+		if err = uploadOutput(); err != nil {
+			err = fmt.Errorf("%w (results kept in %s)", err, localOutput)
+		}
+	}
+	if err != nil {
+		recordRun(run, err)
+		cleanup()
+		fatalf(DataError, "ERROR: Index query failed: %v", err)
+	}
+	if schema.Postgres != nil {
+		run.Outputs = append(run.Outputs, schema.destination(*f.outputFile))
+	} else {
+		addStagedOutput(run, *f.outputFile)
+	}
+	recordRun(run, nil)
+	fmt.Printf("Results saved to: %s\n", schema.destination(*f.outputFile))
+}
+
+// queryIndex matches a token batch against a saved index, writing each match as it is found.
+// Index records are dataset1 (local_id) and batch records dataset2 (peer_id); with 1:1 matching,
+// a record of the index is given to the first batch record that matches it.
+func queryIndex(indexDir, dataset, outputFile string, thresholds config.Thresholds, allowDuplicates bool, keySource keys.Source, schema *resultSchema, run *store.Run) error {
+	tokensPath := filepath.Join(indexDir, indexTokensFile)
+	indexFormat, err := db.ReadTokenFormat(tokensPath)
+	if err != nil {
+		return err
+	}
+	batchFormat, err := checkTokenFormat(dataset, "", "")
+	if err != nil {
+		return err
+	}
+	if err := db.CheckSameRecipe(indexDir, indexFormat, dataset, batchFormat); err != nil {
+		return err
+	}
+
+	tokens, err := pprl.OpenBloomStore(tokensPath)
+	if err != nil {
+		return fmt.Errorf("failed to open index tokens: %w", err)
+	}
+	defer tokens.Close()
+	buckets, err := os.Open(filepath.Join(indexDir, indexBucketsFile))
+	if err != nil {
+		return fmt.Errorf("failed to open index buckets: %w", err)
+	}
+	defer buckets.Close()
+	fuzzyMatcher := match.NewFuzzyMatcher(intersectMatchConfig(0, thresholds, cardinalityOf(allowDuplicates), schema.Retention, indexFormat.DistanceScale()))
+	index, err := fuzzyMatcher.OpenStoreStreamIndex(tokens, true, buckets)
+	if err != nil {
+		return fmt.Errorf("%s: %w", indexDir, err)
+	}
+	fmt.Printf("Opened index of %d records in %d LSH buckets (%d MinHash values per band)\n", tokens.Len(), index.Buckets(), index.BandSize())
+	run.Counts["dataset1_records"] = tokens.Len()
+
+	next, closeBatch, err := openIndexBatch(dataset, keySource)
+	if err != nil {
+		return err
+	}
+	defer closeBatch()
+
+	if dir := filepath.Dir(outputFile); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+	writer, err := newResultWriter(outputFile, schema, run.ID, -1)
+	if err != nil {
+		return fmt.Errorf("failed to create results: %w", err)
+	}
+
+	fmt.Printf("Matching %s...\n", dataset)
+	matches := 0
+	for {
+		record, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writer.Abort()
+			return fmt.Errorf("failed to read %s: %w", dataset, err)
+		}
+		for _, pair := range index.Match(record) {
+			if err := writer.Write(pair); err != nil {
+				writer.Abort()
+				return fmt.Errorf("failed to save results: %w", err)
+			}
+			matches++
+		}
+		if index.Streamed()%100000 == 0 {
+			fmt.Printf("   Matched %d records, %d matches so far\n", index.Streamed(), matches)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to save results: %w", err)
+	}
+	run.Counts["dataset2_records"] = index.Streamed()
+	run.Counts["compared_pairs"] = index.Compared()
+	run.Counts["matches"] = matches
+	fmt.Printf("Results: %d matches among %d batch records (%d pairs compared)\n", matches, index.Streamed(), index.Compared())
+	return nil
+}
+
+// openIndexBatch reads the records of a token batch one at a time; token stores are read from
+// the mapped file and other token files streamed
+func openIndexBatch(dataset string, keySource keys.Source) (next func() (*pprl.Record, error), closeBatch func(), err error) {
+	if !pprl.IsBloomStore(dataset) {
+		stream, err := server.OpenTokenizedRecordStream(dataset, false, keySource)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open %s: %w", dataset, err)
+		}
+		return stream.Next, func() { stream.Close() }, nil
+	}
+
+	batch, err := pprl.OpenBloomStore(dataset)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %w", dataset, err)
+	}
+	i := 0
+	next = func() (*pprl.Record, error) {
+		if i == batch.Len() {
+			return nil, io.EOF
+		}
+		bloomData, err := batch.Filter(i).ToBase64()
+		if err != nil {
+			return nil, err
+		}
+		record := &pprl.Record{ID: batch.ID(i), BloomData: bloomData, MinHash: batch.Signature(i)}
+		i++
+		return record, nil
+	}
+	return next, func() { batch.Close() }, nil
+}
+
+// loadOptionalConfig loads configFile, or returns the defaults if none is given
+func loadOptionalConfig(configFile string) *config.Config {
+	cfg := &config.Config{}
+	if configFile == "" {
+		cfg.SetDefaults()
+		return cfg
+	}
+	loaded, err := config.Load(configFile)
+	if err != nil {
+		fatalf(ConfigError, "ERROR: Failed to load config: %v", err)
+	}
+	return loaded
+}
+
+// indexKeySource gives the key sources for encrypted token files, trying keyFile first if set
+func indexKeySource(cfg *config.Config, keyFile string) (keys.Source, error) {
+	var explicit []*keys.Key
+	if keyFile != "" {
+		key, err := keys.ReadKeyFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key from file: %w", err)
+		}
+		explicit = append(explicit, key)
+	}
+	return defaultKeySources(cfg, explicit...), nil
+}
+
+func showIndexHelp() {
+	fmt.Println("CohortBridge Reusable Index")
+	fmt.Println("===========================")
+	fmt.Println()
+	fmt.Println("For recurring linkage against a stable dataset: 'index build' saves the dataset's")
+	fmt.Println("tokens as a memory-mapped token store together with the LSH buckets of their")
+	fmt.Println("MinHash signatures, and 'index query' matches each new batch against the saved")
+	fmt.Println("index without loading or hashing the indexed dataset again. Only pairs sharing an")
+	fmt.Println("LSH band are compared, as with 'intersect -streaming'.")
+	fmt.Println()
+	fmt.Println("USAGE:")
+	fmt.Println("  cohort-bridge index build -input tokens_a.csv [-output a.cbidx] [OPTIONS]")
+	fmt.Println("  cohort-bridge index query -index a.cbidx -dataset batch.csv [OPTIONS]")
+	fmt.Println()
+	fmt.Println("BUILD OPTIONS:")
+	fmt.Println("  -input string          Tokenized dataset: CSV, JSON Lines, encrypted or .cbbf")
+	fmt.Println("  -output string         Index directory (default: <input name>.cbidx)")
+	fmt.Printf("  -band-size <n>         MinHash values per LSH band (default: %d); smaller bands\n", crypto.DefaultStreamBandSize)
+	fmt.Println("                         compare more pairs and miss fewer matches")
+	fmt.Println("  -key string            Key file for an encrypted (.enc) dataset")
+	fmt.Println("  -force                 Replace an existing index")
+	fmt.Println()
+	fmt.Println("QUERY OPTIONS:")
+	fmt.Println("  -index string          Index directory from 'index build'")
+	fmt.Println("  -dataset string        Tokenized batch, in any token file format")
+	fmt.Println("  -output string         Results (default: index_query_results.csv); index records")
+	fmt.Println("                         are local_id, batch records peer_id")
+	fmt.Println("  -config string         Config with the matching and output sections")
+	fmt.Println("  -output-columns, -output-format, -output-meta")
+	fmt.Println("                         Result schema, as for intersect")
+	fmt.Println("  -allow-duplicates      Keep every pair within the thresholds (1:many); otherwise an")
+	fmt.Println("                         index record goes to the first batch record matching it")
+	fmt.Println("  -max-matches-per-record <n>")
+	fmt.Printf("                         With -allow-duplicates, best pairs kept per record (default:\n")
+	fmt.Printf("                         matching.max_matches_per_record or %d, -1 = all)\n", config.DefaultMaxMatchesPerRecord)
+	fmt.Println("  -min-score <f>         Leave out matches below this Jaccard similarity")
+	fmt.Printf("  -hamming-threshold <n> Maximum Hamming distance of a match (default: config or %d)\n", config.DefaultHammingThreshold)
+	fmt.Printf("  -jaccard-threshold <f> Minimum Jaccard similarity of a match (default: config or %g)\n", config.DefaultJaccardThreshold)
+	fmt.Println("  -key string            Key file for an encrypted (.enc) batch")
+	fmt.Println()
+	fmt.Println("Both actions take -config for the storage section of s3://, gs:// or az:// inputs.")
+	fmt.Println("The batch must be tokenized with the indexed dataset's recipe; the index keeps the")
+	fmt.Println("source's format manifest and checks it. The index holds unencrypted tokens.")
+	fmt.Println()
+	fmt.Println("EXAMPLES:")
+	fmt.Println("  cohort-bridge index build -input out/registry_tokens.csv -output registry.cbidx")
+	fmt.Println("  cohort-bridge index query -index registry.cbidx -dataset out/batch_2026_10.csv \\")
+	fmt.Println("    -output out/batch_2026_10_matches.csv")
+}
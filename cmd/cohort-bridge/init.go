@@ -0,0 +1,728 @@
+package main
+
+import (
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/crypto"
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+	"github.com/auroradata-ai/cohort-bridge/internal/relay"
+	"gopkg.in/yaml.v3"
+)
+
+// initOptions are the answers a generated config is written from
+type initOptions struct {
+	role      string // a or b
+	source    string // csv, json or postgres
+	input     string // database.filename for file sources
+	tokenized bool   // The input holds tokens rather than PHI
+	fields    []string
+
+	dbHost, dbUser, dbName, dbTable string
+	dbPort                          int
+
+	peerHost   string
+	peerPort   int
+	listenPort int
+	relay      string
+	transport  string
+
+	seed              string
+	recipe            map[string]interface{} // Tokenization section of the other party's config (-recipe-from)
+	recipeFrom        string
+	linkageSecretFile string
+	apiKeyFile        string
+	tlsCert, tlsKey   string
+	tlsCA             string
+	payloadEncryption string
+}
+
+// Listen ports of the two roles, different so both parties can also run on one machine
+const (
+	initPortA = 8080
+	initPortB = 8081
+)
+
+// defaultInitFields are the fields of a generated config when the input's columns are not known
+var defaultInitFields = []string{"name:first_name", "name:last_name", "date:date_of_birth", "gender:gender", "zip:zip_code"}
+
+// initFlags are the flags of the init command
+type initFlags struct {
+	fs          *flag.FlagSet
+	outputFile  *string
+	role        *string
+	source      *string
+	input       *string
+	tokenized   *bool
+	fields      *string
+	dbHost      *string
+	dbPort      *int
+	dbUser      *string
+	dbName      *string
+	dbTable     *string
+	peer        *string
+	listenPort  *int
+	relayURL    *string
+	transport   *string
+	seed        *string
+	recipeFrom  *string
+	secure      *bool
+	secretFile  *string
+	apiKeyFile  *string
+	tlsCert     *string
+	tlsKey      *string
+	tlsCA       *string
+	interactive *bool
+	force       *bool
+	help        *bool
+}
+
+// newInitFlags defines the flags of the init command
+func newInitFlags() *initFlags {
+	fs := newFlagSet("init")
+	return &initFlags{
+		fs:          fs,
+		outputFile:  fs.String("output", "config.yaml", "Config file to write"),
+		role:        fs.String("role", "", "Party role: a or b (sets complementary ports; b takes a's recipe)"),
+		source:      fs.String("source", "", "Data source: csv, json or postgres (default: from -input, else csv)"),
+		input:       fs.String("input", "", "Data file (database.filename)"),
+		tokenized:   fs.Bool("tokenized", false, "The input holds tokens from 'tokenize' rather than PHI"),
+		fields:      fs.String("fields", "", "Comma-separated fields as method:column (default: guessed from a CSV input's header)"),
+		dbHost:      fs.String("db-host", "localhost", "PostgreSQL host"),
+		dbPort:      fs.Int("db-port", 5432, "PostgreSQL port"),
+		dbUser:      fs.String("db-user", "", "PostgreSQL user"),
+		dbName:      fs.String("db-name", "", "PostgreSQL database"),
+		dbTable:     fs.String("db-table", "", "PostgreSQL table holding the records"),
+		peer:        fs.String("peer", "", "Peer address host:port (default: localhost and the other role's port)"),
+		listenPort:  fs.Int("listen-port", 0, "Local listen port (default: 8080 for a, 8081 for b)"),
+		relayURL:    fs.String("relay", "", "Meet the peer through a relay: relay://host[:port]/session"),
+		transport:   fs.String("transport", "", "Peer transport: grpc or tcp (default: grpc)"),
+		seed:        fs.String("seed", "", "MinHash seed shared by both parties (default: generated for a)"),
+		recipeFrom:  fs.String("recipe-from", "", "Copy the tokenization section from the other party's config"),
+		secure:      fs.Bool("secure", false, "Enable keyed hashing, peer authentication, TLS and required payload encryption with default file paths"),
+		secretFile:  fs.String("linkage-secret-file", "", "Shared linkage secret (tokenization.linkage_secret_file)"),
+		apiKeyFile:  fs.String("api-key-file", "", "Pre-shared peer key file (peer.api_key_file)"),
+		tlsCert:     fs.String("tls-cert", "", "TLS certificate (peer.tls_cert_file)"),
+		tlsKey:      fs.String("tls-key", "", "TLS private key (peer.tls_key_file)"),
+		tlsCA:       fs.String("tls-ca", "", "CA of the peer's certificate (peer.tls_ca_file)"),
+		interactive: fs.Bool("interactive", false, "Ask for every setting"),
+		force:       fs.Bool("force", false, "Overwrite an existing config"),
+		help:        fs.Bool("help", false, "Show help message"),
+	}
+}
+
+func runInitCommand(args []string) {
+	f := newInitFlags()
+	f.fs.Parse(args)
+
+	if *f.help {
+		showInitHelp()
+		return
+	}
+
+	opts := initOptions{
+		role: strings.ToLower(*f.role), source: strings.ToLower(*f.source), input: *f.input, tokenized: *f.tokenized,
+		dbHost: *f.dbHost, dbPort: *f.dbPort, dbUser: *f.dbUser, dbName: *f.dbName, dbTable: *f.dbTable,
+		listenPort: *f.listenPort, relay: *f.relayURL, transport: *f.transport,
+		seed: *f.seed, recipeFrom: *f.recipeFrom,
+		linkageSecretFile: *f.secretFile, apiKeyFile: *f.apiKeyFile,
+		tlsCert: *f.tlsCert, tlsKey: *f.tlsKey, tlsCA: *f.tlsCA,
+	}
+	if *f.fields != "" {
+		opts.fields = splitList(*f.fields)
+	}
+	if *f.peer != "" {
+		host, port, err := net.SplitHostPort(*f.peer)
+		if err != nil {
+			fatalf(UsageError, "Error: invalid -peer %q: %v", *f.peer, err)
+		}
+		opts.peerHost = host
+		if opts.peerPort, err = strconv.Atoi(port); err != nil {
+			fatalf(UsageError, "Error: invalid -peer port %q", port)
+		}
+	}
+	if *f.secure {
+		opts.applySecureDefaults()
+	}
+
+	if opts.role == "" || *f.interactive {
+		if opts.role == "" {
+			requirePrompt("init", "-role")
+		}
+		promptInitOptions(&opts, *f.outputFile)
+	}
+
+	if _, err := os.Stat(*f.outputFile); err == nil && !*f.force {
+		if !canPrompt() || promptForChoice(fmt.Sprintf("%s exists. Overwrite it?", *f.outputFile), []string{"No, keep it", "Yes, overwrite it"}) == 0 {
+			fatalf(UsageError, "ERROR: %s already exists (use -force to overwrite it)", *f.outputFile)
+		}
+	}
+
+	if err := opts.resolve(); err != nil {
+		fatalf(ConfigError, "ERROR: %v", err)
+	}
+	text := opts.render()
+	cfg, warnings, err := checkInitConfig(text)
+	if err != nil {
+		fatalf(ConfigError, "ERROR: generated config is invalid: %v", err)
+	}
+	if dir := filepath.Dir(*f.outputFile); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			fatalf(DataError, "ERROR: failed to create %s: %v", dir, err)
+		}
+	}
+	// The config names secrets and may hold a database password, so only the owner reads it
+	if err := os.WriteFile(*f.outputFile, []byte(text), 0600); err != nil {
+		fatalf(DataError, "ERROR: failed to write %s: %v", *f.outputFile, err)
+	}
+
+	fmt.Printf("Config for party %s written to: %s\n", strings.ToUpper(opts.role), *f.outputFile)
+	fmt.Printf("  Source: %s\n", opts.sourceSummary())
+	if cfg.Peer.Relay != "" {
+		fmt.Printf("  Peer: through %s\n", cfg.Peer.Relay)
+	} else {
+		fmt.Printf("  Peer: %s:%d (listening on %d)\n", cfg.Peer.Host, cfg.Peer.Port, cfg.ListenPort)
+	}
+	fmt.Printf("  Recipe: %s\n", cfg.RecipeSummary())
+	for _, warning := range warnings {
+		fmt.Printf("  Warning: %s\n", warning)
+	}
+	fmt.Println()
+	if opts.role == "a" {
+		fmt.Println("Next: give the other party the tokenization section (or this file, for")
+		fmt.Printf("'cohort-bridge init -role b -recipe-from %s'); both must tokenize with the same recipe.\n", *f.outputFile)
+	}
+	if !opts.tokenized && opts.source != "postgres" {
+		fmt.Printf("Check the fields first: cohort-bridge preview -input %s -config %s\n", opts.input, *f.outputFile)
+	}
+	fmt.Printf("Run: cohort-bridge pprl -config %s\n", *f.outputFile)
+}
+
+// applySecureDefaults fills the secure options not given with their conventional paths
+func (o *initOptions) applySecureDefaults() {
+	defaults := []struct {
+		value *string
+		path  string
+	}{
+		{&o.linkageSecretFile, "secrets/linkage.secret"},
+		{&o.apiKeyFile, "secrets/peer.key"},
+		{&o.tlsCert, "certs/peer.crt"},
+		{&o.tlsKey, "certs/peer.key"},
+		{&o.tlsCA, "certs/ca.crt"},
+	}
+	for _, d := range defaults {
+		if *d.value == "" {
+			*d.value = d.path
+		}
+	}
+	o.payloadEncryption = "required"
+}
+
+// promptInitOptions asks for the settings not given as flags
+func promptInitOptions(o *initOptions, outputFile string) {
+	fmt.Println("CohortBridge Config Setup")
+	fmt.Println("=========================")
+	fmt.Printf("Answers are written to %s; press Enter to keep a default.\n\n", outputFile)
+
+	if o.role == "" {
+		choice := promptForChoice("Which party is this?", []string{
+			"Party A - starts the project and generates the shared recipe",
+			"Party B - joins with party A's recipe",
+		})
+		o.role = []string{"a", "b"}[choice]
+	}
+
+	if o.source == "" {
+		choice := promptForChoice("Where are this party's records?", []string{
+			"CSV file",
+			"JSON or JSON Lines file",
+			"PostgreSQL table",
+		})
+		o.source = []string{"csv", "json", "postgres"}[choice]
+	}
+	if o.source == "postgres" {
+		o.dbHost = promptForInput("PostgreSQL host", o.dbHost)
+		o.dbPort = promptForInt("PostgreSQL port", o.dbPort)
+		o.dbUser = promptForInput("PostgreSQL user", o.dbUser)
+		o.dbName = promptForInput("PostgreSQL database", o.dbName)
+		o.dbTable = promptForInput("Table holding the records", o.dbTable)
+	} else {
+		if !o.tokenized {
+			o.tokenized = promptForChoice("What does the file hold?", []string{
+				"Raw records (PHI), tokenized by each run",
+				"Tokens from 'cohort-bridge tokenize'",
+			}) == 1
+		}
+		o.input = promptForInput("Data file", o.input)
+	}
+	if !o.tokenized && len(o.fields) == 0 {
+		guessed := guessInitFields(o.source, o.input)
+		answer := promptForInput("Fields to link on, as method:column", strings.Join(guessed, ","))
+		o.fields = splitList(answer)
+	}
+
+	if o.relay == "" && o.peerHost == "" {
+		if promptForChoice("How do the parties connect?", []string{
+			"Directly: one party reaches the other's listen port",
+			"Through a relay both parties dial out to",
+		}) == 1 {
+			o.relay = promptForInput("Relay URL (relay://host[:port]/session)", "")
+		} else {
+			o.peerHost = promptForInput("Peer host", "localhost")
+			o.peerPort = promptForInt("Peer port", o.defaultPeerPort())
+			o.listenPort = promptForInt("Local listen port", o.defaultListenPort())
+		}
+	}
+
+	if o.role == "b" && o.seed == "" && o.recipeFrom == "" {
+		o.recipeFrom = promptForInput("Party A's config (to copy its tokenization section), or leave empty to enter the seed", "")
+		if o.recipeFrom == "" {
+			o.seed = promptForInput("MinHash seed from party A's tokenization.seed", "")
+		}
+	}
+
+	if o.linkageSecretFile == "" && o.apiKeyFile == "" && o.tlsCert == "" {
+		if promptForChoice("Security options:", []string{
+			"Recommended: keyed hashing, peer key, TLS and required payload encryption",
+			"None for now (local testing only)",
+		}) == 0 {
+			o.applySecureDefaults()
+			o.linkageSecretFile = promptForInput("Linkage secret file (shared with the peer)", o.linkageSecretFile)
+			o.apiKeyFile = promptForInput("Pre-shared peer key file", o.apiKeyFile)
+			o.tlsCert = promptForInput("TLS certificate", o.tlsCert)
+			o.tlsKey = promptForInput("TLS private key", o.tlsKey)
+			o.tlsCA = promptForInput("CA of the peer's certificate", o.tlsCA)
+		}
+	}
+	fmt.Println()
+}
+
+// promptForInt asks for a number, keeping the default when the answer is not one
+func promptForInt(message string, defaultValue int) int {
+	answer := promptForInput(message, strconv.Itoa(defaultValue))
+	n, err := strconv.Atoi(answer)
+	if err != nil {
+		fmt.Printf("Invalid number %q, using %d\n", answer, defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+// resolve checks the options and fills in what follows from them
+func (o *initOptions) resolve() error {
+	if o.role != "a" && o.role != "b" {
+		return fmt.Errorf("-role must be a or b, not %q", o.role)
+	}
+	if o.source == "" {
+		o.source = "csv"
+		if detectInputFormat(o.input) == "json" {
+			o.source = "json"
+		}
+	}
+	switch o.source {
+	case "csv", "json":
+		if o.input == "" {
+			return fmt.Errorf("-input is required for a %s source", o.source)
+		}
+	case "postgres", "postgresql":
+		o.source = "postgres"
+		if o.tokenized {
+			return fmt.Errorf("-tokenized takes a token file, not a postgres source")
+		}
+		if o.dbName == "" || o.dbTable == "" {
+			return fmt.Errorf("-db-name and -db-table are required for a postgres source")
+		}
+	default:
+		return fmt.Errorf("unknown -source %q (expected csv, json or postgres)", o.source)
+	}
+	if !o.tokenized && len(o.fields) == 0 {
+		o.fields = guessInitFields(o.source, o.input)
+	}
+
+	if o.relay == "" {
+		if o.peerHost == "" {
+			o.peerHost = "localhost"
+		}
+		if o.peerPort == 0 {
+			o.peerPort = o.defaultPeerPort()
+		}
+		if o.listenPort == 0 {
+			o.listenPort = o.defaultListenPort()
+		}
+	}
+
+	if o.recipeFrom != "" {
+		other, err := config.Load(o.recipeFrom)
+		if err != nil {
+			return fmt.Errorf("failed to load -recipe-from %s: %w", o.recipeFrom, err)
+		}
+		if o.seed != "" && o.seed != other.Tokenization.Seed {
+			return fmt.Errorf("-seed differs from the seed in %s; give one or the other", o.recipeFrom)
+		}
+		// The section is copied as written, so settings left to their defaults stay that way
+		data, err := os.ReadFile(o.recipeFrom)
+		if err != nil {
+			return err
+		}
+		var sections struct {
+			Tokenization map[string]interface{} `yaml:"tokenization"`
+		}
+		if err := yaml.Unmarshal(data, &sections); err != nil {
+			return fmt.Errorf("failed to read -recipe-from %s: %w", o.recipeFrom, err)
+		}
+		o.recipe = sections.Tokenization
+		if o.recipe == nil {
+			o.recipe = map[string]interface{}{}
+		}
+		// The secret is the same, but each party keeps its copy where it likes
+		if o.linkageSecretFile != "" {
+			o.recipe["linkage_secret_file"] = o.linkageSecretFile
+		}
+		if o.transport == "" {
+			o.transport = other.Peer.Transport
+		}
+	}
+	if o.seed == "" && o.recipe == nil {
+		if o.role == "b" {
+			return fmt.Errorf("party B needs party A's recipe: pass -recipe-from <party A's config> or -seed <its tokenization.seed>")
+		}
+		seed, err := generateSeed()
+		if err != nil {
+			return err
+		}
+		o.seed = seed
+	}
+	return nil
+}
+
+// defaultListenPort is the listen port of the role
+func (o *initOptions) defaultListenPort() int {
+	if o.role == "b" {
+		return initPortB
+	}
+	return initPortA
+}
+
+// defaultPeerPort is the listen port of the other role
+func (o *initOptions) defaultPeerPort() int {
+	if o.role == "b" {
+		return initPortA
+	}
+	return initPortB
+}
+
+// sourceSummary describes the data source
+func (o *initOptions) sourceSummary() string {
+	switch {
+	case o.source == "postgres":
+		return fmt.Sprintf("postgres %s@%s:%d/%s table %s", o.dbUser, o.dbHost, o.dbPort, o.dbName, o.dbTable)
+	case o.tokenized:
+		return fmt.Sprintf("tokens in %s", o.input)
+	}
+	return fmt.Sprintf("%s file %s, fields %s", o.source, o.input, strings.Join(o.fields, ", "))
+}
+
+// initFieldGuesses map lowercase column names to the normalization method of their field
+var initFieldGuesses = map[string]string{
+	"first": "name", "first_name": "name", "firstname": "name", "given_name": "name", "given": "name",
+	"last": "name", "last_name": "name", "lastname": "name", "surname": "name", "family_name": "name",
+	"middle": "name", "middle_name": "name",
+	"birthdate": "date", "birth_date": "date", "date_of_birth": "date", "dob": "date",
+	"gender": "gender", "sex": "gender",
+	"zip": "zip", "zip_code": "zip", "zipcode": "zip", "postal_code": "zip",
+}
+
+// guessInitFields names the fields of a CSV input from its header, in column order, falling
+// back to the conventional fields
+func guessInitFields(source, input string) []string {
+	if source != "csv" || input == "" {
+		return defaultInitFields
+	}
+	columns, err := readCSVColumns(input, config.CSVDialect{})
+	if err != nil {
+		return defaultInitFields
+	}
+	var fields []string
+	for _, column := range columns {
+		if method, ok := initFieldGuesses[strings.ToLower(strings.TrimSpace(column))]; ok {
+			fields = append(fields, method+":"+column)
+		}
+	}
+	if len(fields) == 0 {
+		return defaultInitFields
+	}
+	return fields
+}
+
+// generateSeed returns a random MinHash seed of 32 letters and digits
+func generateSeed() (string, error) {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	seed := make([]byte, 32)
+	for i := range seed {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", fmt.Errorf("failed to generate MinHash seed: %w", err)
+		}
+		seed[i] = alphabet[n.Int64()]
+	}
+	return string(seed), nil
+}
+
+// render writes the config as YAML, with the settings left at their defaults as comments
+func (o *initOptions) render() string {
+	var b strings.Builder
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format+"\n", args...)
+	}
+
+	line("# CohortBridge config for party %s, generated by 'cohort-bridge init'", strings.ToUpper(o.role))
+	line("# Commented settings show their defaults; see config_basic.example.yaml for more")
+	if o.relay == "" {
+		line("listen_port: %d", o.listenPort)
+	}
+	line("database:")
+	switch {
+	case o.source == "postgres":
+		line("  type: postgres")
+		line("  host: %s", yamlString(o.dbHost))
+		line("  port: %d", o.dbPort)
+		line("  user: %s", yamlString(o.dbUser))
+		line("  # password: \"\"          # Better kept out of this file")
+		line("  dbname: %s", yamlString(o.dbName))
+		line("  table: %s", yamlString(o.dbTable))
+	default:
+		line("  type: csv")
+		line("  filename: %s", yamlString(o.input))
+	}
+	if o.tokenized {
+		line("  is_tokenized: true")
+	} else {
+		line("  fields:")
+		for _, field := range o.fields {
+			line("    - %s", yamlString(field))
+		}
+		line("  # column_mapping:         # Read fields from differently named columns of this site's data")
+		line("  #   first_name: {column: GIVEN_NAME, transforms: [trim]}")
+		line("  # id_column: [mrn, facility]  # Record ID column (default id); several make a composite ID")
+		line("  # multi_value_columns: []  # Cells holding several values, e.g. prior surnames as Smith|Jones")
+		line("  # watermark_column: updated_at  # Last-modified column for 'tokenize -since'")
+		line("  # sanitize: {max_length: 256, on_too_long: truncate}  # Truncate or reject oversized values")
+	}
+	line("  random_bits_percent: 0")
+
+	line("peer:")
+	if o.relay != "" {
+		line("  relay: %s", yamlString(o.relay))
+	} else {
+		line("  host: %s", yamlString(o.peerHost))
+		line("  port: %d", o.peerPort)
+	}
+	if o.transport != "" {
+		line("  transport: %s", yamlString(o.transport))
+	} else {
+		line("  # transport: grpc        # grpc or tcp (legacy); both peers must match")
+	}
+	optional := func(key, value, comment string) {
+		if value != "" {
+			line("  %s: %s", key, yamlString(value))
+		} else {
+			line("  # %s: %s", key, comment)
+		}
+	}
+	optional("tls_cert_file", o.tlsCert, "certs/peer.crt  # Enables TLS")
+	optional("tls_key_file", o.tlsKey, "certs/peer.key")
+	optional("tls_ca_file", o.tlsCA, "certs/ca.crt      # Verifies the peer's certificate (default: system roots)")
+	optional("api_key_file", o.apiKeyFile, "secrets/peer.key  # Pre-shared key both peers hold; never sent")
+	if o.payloadEncryption != "" {
+		line("  payload_encryption: %s", o.payloadEncryption)
+	} else {
+		line("  # payload_encryption: auto  # Seal tokens end to end: auto, required or off")
+	}
+	line("  # padding_records: 0      # Decoy records sent with the tokens to hide the dataset size")
+
+	line("# matching:")
+	line("#   hamming_threshold: %d", config.DefaultHammingThreshold)
+	line("#   jaccard_threshold: %g", config.DefaultJaccardThreshold)
+	line("#   assignment: %s          # 1:1 assignment: greedy or hungarian", crypto.DefaultAssignment)
+	line("#   protocol: %s          # smc: compare under secure computation (slower); psi: exact_id only (both parties)", crypto.ProtocolStandard)
+	line("#   max_matches_per_record: %d  # 1:many matching: best pairs kept per record", config.DefaultMaxMatchesPerRecord)
+
+	line("tokenization:              # Must be identical for both parties")
+	if o.recipe != nil {
+		line("  # Copied from %s", o.recipeFrom)
+		if len(o.recipe) > 0 {
+			data, err := yaml.Marshal(o.recipe)
+			if err == nil {
+				for _, l := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+					line("  %s", l)
+				}
+			}
+		}
+	} else {
+		line("  bloom_size: 1000")
+		line("  bloom_hashes: 5")
+		line("  qgram_length: 2")
+		line("  padding: \"$\"")
+		line("  noise: 0")
+		line("  minhash_size: 100")
+		line("  seed: %s", yamlString(o.seed))
+		if o.linkageSecretFile != "" {
+			line("  linkage_secret_file: %s  # Shared with the peer out of band (openssl rand -hex 32)", yamlString(o.linkageSecretFile))
+		} else {
+			line("  # linkage_secret_file: secrets/linkage.secret  # Keyed Bloom hashing (openssl rand -hex 32)")
+		}
+		line("  # unicode: fold           # Fold accents and case so García matches Garcia")
+		line("  # blocking:               # Only compare pairs sharing one of these keys")
+		line("  #   - zip3+birth_year")
+		line("  # hardening: [balance]    # Against frequency attacks: balance, xor_fold, rule90")
+		line("  # exact_id: ssn           # Column of an identifier both parties share exactly (PSI)")
+	}
+	line("# output:")
+	line("#   columns: [local_id, peer_id]")
+	line("#   format: csv")
+	return b.String()
+}
+
+// yamlString quotes a scalar when YAML would otherwise read it as something else
+func yamlString(value string) string {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return strconv.Quote(value)
+	}
+	return strings.TrimSuffix(string(data), "\n")
+}
+
+// checkInitConfig loads a generated config as the commands would and checks the settings they
+// require, returning warnings for files that do not exist yet
+func checkInitConfig(text string) (*config.Config, []string, error) {
+	var cfg config.Config
+	if err := yaml.Unmarshal([]byte(text), &cfg); err != nil {
+		return nil, nil, err
+	}
+	cfg.SetDefaults()
+
+	if cfg.Peer.Relay != "" {
+		if _, err := relay.ParseURL(cfg.Peer.Relay); err != nil {
+			return nil, nil, fmt.Errorf("peer.relay: %w", err)
+		}
+	} else {
+		if cfg.Peer.Host == "" || cfg.Peer.Port <= 0 || cfg.Peer.Port > 65535 {
+			return nil, nil, fmt.Errorf("peer.host and a peer.port between 1 and 65535 are required")
+		}
+		if cfg.ListenPort <= 0 || cfg.ListenPort > 65535 {
+			return nil, nil, fmt.Errorf("listen_port must be between 1 and 65535")
+		}
+	}
+	if t := cfg.Peer.Transport; t != "grpc" && t != "tcp" {
+		return nil, nil, fmt.Errorf("peer.transport must be grpc or tcp, not %q", t)
+	}
+	if (cfg.Peer.TLSCertFile == "") != (cfg.Peer.TLSKeyFile == "") {
+		return nil, nil, fmt.Errorf("peer.tls_cert_file and peer.tls_key_file must be set together")
+	}
+	if cfg.Peer.TLSCertFile != "" && cfg.Peer.Transport != "grpc" {
+		return nil, nil, fmt.Errorf("TLS needs the grpc transport")
+	}
+	if err := crypto.ValidateAssignment(cfg.Matching.Assignment); err != nil {
+		return nil, nil, err
+	}
+	if err := crypto.ValidateProtocol(cfg.Matching.Protocol); err != nil {
+		return nil, nil, err
+	}
+	if _, err := match.NewComparator(cfg.Matching.Comparator); err != nil {
+		return nil, nil, err
+	}
+	if cfg.Tokenization.Seed == "" {
+		return nil, nil, fmt.Errorf("tokenization.seed is required")
+	}
+	if !cfg.Database.IsTokenized {
+		if len(cfg.Database.Fields) == 0 {
+			return nil, nil, fmt.Errorf("database.fields is required for raw records")
+		}
+		for _, field := range cfg.Database.Fields {
+			method, column, ok := strings.Cut(field, ":")
+			if !ok || strings.TrimSpace(column) == "" {
+				return nil, nil, fmt.Errorf("field %q is not method:column", field)
+			}
+			if _, ok := crypto.ParseNormalizationMethod(method); !ok {
+				return nil, nil, fmt.Errorf("field %q: unknown normalization method %q", field, method)
+			}
+		}
+	}
+
+	var warnings []string
+	files := []struct{ key, path string }{
+		{"database.filename", cfg.Database.Filename},
+		{"tokenization.linkage_secret_file", cfg.Tokenization.LinkageSecretFile},
+		{"peer.api_key_file", cfg.Peer.APIKeyFile},
+		{"peer.tls_cert_file", cfg.Peer.TLSCertFile},
+		{"peer.tls_key_file", cfg.Peer.TLSKeyFile},
+		{"peer.tls_ca_file", cfg.Peer.TLSCAFile},
+	}
+	for _, f := range files {
+		if f.path == "" {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s %s does not exist yet", f.key, f.path))
+		}
+	}
+	if cfg.Peer.APIKeyFile == "" && len(cfg.Peer.AllowedPeers) == 0 {
+		warnings = append(warnings, "no peer authentication (use -secure or -api-key-file before linking real data)")
+	}
+	return &cfg, warnings, nil
+}
+
+func showInitHelp() {
+	fmt.Println("CohortBridge Config Generator")
+	fmt.Println("=============================")
+	fmt.Println()
+	fmt.Println("Write a complete, validated config for one party of a linkage, asking for each")
+	fmt.Println("setting or taking them as flags. Settings left at their defaults are written as")
+	fmt.Println("comments, so the file shows what can be changed.")
+	fmt.Println()
+	fmt.Println("USAGE:")
+	fmt.Println("  cohort-bridge init                                 # Interactive")
+	fmt.Println("  cohort-bridge init -role a -input data/patients.csv [OPTIONS]")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Println("  -output string         Config file to write (default: config.yaml)")
+	fmt.Println("  -role a|b              Party A generates the shared MinHash seed; party B takes A's")
+	fmt.Printf("                         recipe. A listens on %d and B on %d unless given\n", initPortA, initPortB)
+	fmt.Println("  -source string         csv, json or postgres (default: from -input, else csv)")
+	fmt.Println("  -input string          Data file (database.filename)")
+	fmt.Println("  -tokenized             The file holds tokens from 'tokenize' rather than PHI")
+	fmt.Println("  -fields string         Fields as method:column,... (methods: name, date, birthdate,")
+	fmt.Println("                         gender, zip, soundex, metaphone, nysiis); default: guessed")
+	fmt.Println("                         from a CSV input's header")
+	fmt.Println("  -db-host, -db-port, -db-user, -db-name, -db-table")
+	fmt.Println("                         PostgreSQL source (the password is not written)")
+	fmt.Println("  -peer host:port        Peer address (default: localhost and the other role's port)")
+	fmt.Println("  -listen-port <n>       Local listen port")
+	fmt.Println("  -relay string          Meet the peer through relay://host[:port]/session instead")
+	fmt.Println("  -transport string      grpc (default) or tcp")
+	fmt.Println("  -seed string           MinHash seed; generated for party A")
+	fmt.Println("  -recipe-from string    Copy the tokenization section from party A's config (party B)")
+	fmt.Println("  -secure                Keyed hashing, a pre-shared peer key, TLS and required payload")
+	fmt.Println("                         encryption, with files under secrets/ and certs/")
+	fmt.Println("  -linkage-secret-file, -api-key-file, -tls-cert, -tls-key, -tls-ca")
+	fmt.Println("                         Individual secure settings (override -secure's paths)")
+	fmt.Println("  -interactive           Ask for every setting not given as a flag")
+	fmt.Println("  -force                 Overwrite an existing config")
+	fmt.Println()
+	fmt.Println("The config is loaded back and checked before it is written: ports, transport, TLS")
+	fmt.Println("pairing, fields and their normalization methods. Files it names that do not exist")
+	fmt.Println("yet are listed as warnings. It is written readable by its owner only.")
+	fmt.Println()
+	fmt.Println("EXAMPLES:")
+	fmt.Println("  cohort-bridge init -role a -input data/patients.csv -peer site-b.example.org:8081 -secure")
+	fmt.Println("  cohort-bridge init -role b -input data/patients.csv -recipe-from config_a.yaml \\")
+	fmt.Println("    -peer site-a.example.org:8080 -secure -output config_b.yaml")
+}
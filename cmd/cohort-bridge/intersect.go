@@ -3,15 +3,93 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
 	"github.com/auroradata-ai/cohort-bridge/internal/crypto"
+	"github.com/auroradata-ai/cohort-bridge/internal/db"
+	"github.com/auroradata-ai/cohort-bridge/internal/keys"
 	"github.com/auroradata-ai/cohort-bridge/internal/match"
+	"github.com/auroradata-ai/cohort-bridge/internal/memlimit"
+	"github.com/auroradata-ai/cohort-bridge/internal/objstore"
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
 	"github.com/auroradata-ai/cohort-bridge/internal/server"
+	"github.com/auroradata-ai/cohort-bridge/internal/store"
 )
 
+// intersectFlags are the flags of the intersect command
+type intersectFlags struct {
+	fs             *flag.FlagSet
+	dataset1       *string
+	dataset2       *string
+	outputFile     *string
+	party          *int
+	configFile     *string
+	columns        *string
+	format         *string
+	metadata       *string
+	resume         *bool
+	streaming      *bool
+	bandSize       *int
+	maxMemory      *string
+	noBlocking     *bool
+	allowDups      *bool
+	cardMode       *string
+	assignment     *string
+	comparator     *string
+	provenance     *bool
+	clusters       *string
+	maxSize        *int
+	maxPerParty    *int
+	keyFile        *string
+	keyHex         *string
+	interactive    *bool
+	help           *bool
+	thresholdFlags *thresholdFlags
+	retention      *retentionFlags
+	histogramFlags *histogramFlags
+}
+
+// newIntersectFlags defines the flags of the intersect command
+func newIntersectFlags() *intersectFlags {
+	fs := newFlagSet("intersect")
+	return &intersectFlags{
+		fs:             fs,
+		dataset1:       fs.String("dataset1", "", "Path to first tokenized dataset file"),
+		dataset2:       fs.String("dataset2", "", "Path to second tokenized dataset file"),
+		outputFile:     fs.String("output", "zk_intersection_results.csv", "Output file for intersection results"),
+		party:          fs.Int("party", 0, "Party number (0 or 1) for two-party protocol"),
+		configFile:     fs.String("config", "", "Config with the output section (optional)"),
+		columns:        fs.String("output-columns", "", "Comma-separated result columns (default: output.columns or local_id,peer_id)"),
+		format:         fs.String("output-format", "", "Result format: csv, jsonl or postgres (default: output.format or csv)"),
+		metadata:       fs.String("output-meta", "", "Static columns added to every row, as name=value,..."),
+		resume:         fs.Bool("resume", false, "Continue an interrupted intersection from its checkpoint"),
+		streaming:      fs.Bool("streaming", false, "Index the smaller dataset and stream the larger one from disk"),
+		bandSize:       fs.Int("band-size", crypto.DefaultStreamBandSize, "MinHash values per LSH band in streaming mode"),
+		maxMemory:      fs.String("max-memory", "", "Memory limit, e.g. 4G: datasets too large for it are streamed, and the run stops cleanly instead of exceeding it"),
+		noBlocking:     fs.Bool("no-blocking", false, "Compare every pair even if both datasets carry blocking keys"),
+		allowDups:      fs.Bool("allow-duplicates", false, "Keep every matching pair, the same as -cardinality many:many"),
+		cardMode:       fs.String("cardinality", "", "Matches per record: 1:1 (default), 1:many or many:many"),
+		assignment:     fs.String("assignment", "", "1:1 assignment algorithm: greedy or hungarian (default: matching.assignment or greedy)"),
+		comparator:     fs.String("comparator", "", "Registered pair comparator (default: matching.comparator or bloom)"),
+		provenance:     fs.Bool("provenance", false, "Add the source file, row and batch of both records of each match, from token files written with tokenize -provenance"),
+		clusters:       fs.String("clusters", "", "Resolve matches into entity clusters and write the assignment here (default with matching.clustering.enabled: <output>_clusters.csv)"),
+		maxSize:        fs.Int("cluster-max-size", -1, "Most records in one cluster (default: matching.clustering.max_size, 0 = no limit)"),
+		maxPerParty:    fs.Int("cluster-max-per-party", -1, "Most records of one dataset in one cluster (default: matching.clustering.max_per_party, 0 = no limit)"),
+		keyFile:        fs.String("key", "", "Key file for encrypted (.enc) datasets"),
+		keyHex:         fs.String("key-hex", "", "Key for encrypted (.enc) datasets as a hex string"),
+		interactive:    fs.Bool("interactive", false, "Force interactive mode"),
+		help:           fs.Bool("help", false, "Show help message"),
+		thresholdFlags: addThresholdFlags(fs),
+		retention:      addRetentionFlags(fs),
+		histogramFlags: addHistogramFlags(fs),
+	}
+}
+
 func runIntersectCommand(args []string) {
 	fmt.Println("CohortBridge Zero-Knowledge Intersection")
 	fmt.Println("========================================")
@@ -19,108 +97,369 @@ func runIntersectCommand(args []string) {
 	fmt.Println("No information leaked beyond intersection results")
 	fmt.Println()
 
-	fs := flag.NewFlagSet("intersect", flag.ExitOnError)
-	var (
-		dataset1    = fs.String("dataset1", "", "Path to first tokenized dataset file")
-		dataset2    = fs.String("dataset2", "", "Path to second tokenized dataset file")
-		outputFile  = fs.String("output", "zk_intersection_results.csv", "Output file for intersection results")
-		party       = fs.Int("party", 0, "Party number (0 or 1) for two-party protocol")
-		interactive = fs.Bool("interactive", false, "Force interactive mode")
-		help        = fs.Bool("help", false, "Show help message")
-	)
-	fs.Parse(args)
+	f := newIntersectFlags()
+	parseFlags(f.fs, args)
 
-	if *help {
+	if *f.help {
 		showZKIntersectHelp()
 		return
 	}
 
+	cfg := &config.Config{}
+	if *f.configFile != "" {
+		loaded, err := config.Load(*f.configFile)
+		if err != nil {
+			fatalf(ConfigError, "ERROR: Failed to load config: %v", err)
+		}
+		cfg = loaded
+	} else {
+		cfg.SetDefaults()
+	}
+	f.retention.apply(cfg)
+	schema, err := newResultSchema(cfg, *f.columns, *f.format, *f.metadata)
+	if err != nil {
+		fatalf(ConfigError, "ERROR: %v", err)
+	}
+	histogram, err := f.histogramFlags.histogram()
+	if err != nil {
+		fatalf(UsageError, "ERROR: %v", err)
+	}
+	var thresholds config.Thresholds
+	if *f.configFile != "" {
+		thresholds = f.thresholdFlags.resolve(cfg)
+	} else {
+		thresholds = f.thresholdFlags.resolve()
+	}
+	card, err := parseCardinality(*f.cardMode, *f.allowDups, *f.assignment, cfg.Matching.Assignment)
+	if err != nil {
+		fatalf(UsageError, "ERROR: %v", err)
+	}
+	comparatorName, err := resolveComparator(*f.comparator, cfg)
+	if err != nil {
+		fatalf(UsageError, "ERROR: %v", err)
+	}
+	if schema.Format == "jsonl" && *f.outputFile == "zk_intersection_results.csv" {
+		*f.outputFile = "zk_intersection_results.jsonl"
+	}
+
+	// Encrypted datasets are decrypted in memory; an explicit key is tried before the key sources
+	var explicit []*keys.Key
+	if *f.keyFile != "" {
+		key, err := keys.ReadKeyFile(*f.keyFile)
+		if err != nil {
+			fatalf(CryptoError, "ERROR: Failed to load key from file: %v", err)
+		}
+		explicit = append(explicit, key)
+	} else if *f.keyHex != "" {
+		key, err := keys.FromHex(*f.keyHex)
+		if err != nil {
+			fatalf(CryptoError, "ERROR: Invalid key format: %v", err)
+		}
+		explicit = append(explicit, key)
+	}
+	keySource := defaultKeySources(cfg, explicit...)
+
 	// Interactive mode if missing required parameters
-	if *dataset1 == "" || *dataset2 == "" || *interactive {
+	if *f.dataset1 == "" || *f.dataset2 == "" || *f.interactive {
+		var missing []string
+		if *f.dataset1 == "" {
+			missing = append(missing, "-dataset1")
+		}
+		if *f.dataset2 == "" {
+			missing = append(missing, "-dataset2")
+		}
+		requirePrompt("intersect", missing...)
+
 		fmt.Println("Interactive Zero-Knowledge Intersection Setup")
-		fmt.Println("Configure your secure intersection parameters:\n")
+		fmt.Print("Configure your secure intersection parameters:\n\n")
 
-		if *dataset1 == "" {
+		if *f.dataset1 == "" {
 			var err error
-			*dataset1, err = selectDataFile("Select First Tokenized Dataset", "tokenized", []string{".csv", ".json"})
+			*f.dataset1, err = selectDataFile("Select First Tokenized Dataset", "tokenized", []string{".csv", ".json", ".jsonl", ".ndjson", ".gz", ".enc"})
 			if err != nil {
-				fmt.Printf("Error selecting first dataset: %v\n", err)
-				os.Exit(1)
+				fatalf(UsageError, "Error selecting first dataset: %v", err)
 			}
 		}
 
-		if *dataset2 == "" {
+		if *f.dataset2 == "" {
 			var err error
-			*dataset2, err = selectDataFile("Select Second Tokenized Dataset", "tokenized", []string{".csv", ".json"})
+			*f.dataset2, err = selectDataFile("Select Second Tokenized Dataset", "tokenized", []string{".csv", ".json", ".jsonl", ".ndjson", ".gz", ".enc"})
 			if err != nil {
-				fmt.Printf("Error selecting second dataset: %v\n", err)
-				os.Exit(1)
+				fatalf(UsageError, "Error selecting second dataset: %v", err)
 			}
 		}
 
-		if *outputFile == "zk_intersection_results.csv" {
-			defaultOutput := generateOutputName("zk_intersection", *dataset1, *dataset2)
-			*outputFile = promptForInput("Output file for intersection results", defaultOutput)
+		if *f.outputFile == "zk_intersection_results.csv" {
+			defaultOutput := generateOutputName("zk_intersection", *f.dataset1, *f.dataset2)
+			*f.outputFile = promptForInput("Output file for intersection results", defaultOutput)
 		}
 
 		// Only party number is configurable for security
 		fmt.Println("\nZero-Knowledge Protocol Configuration")
-		partyResult := promptForInput("Party number (0 or 1) for two-party protocol", strconv.Itoa(*party))
+		partyResult := promptForInput("Party number (0 or 1) for two-party protocol", strconv.Itoa(*f.party))
 		if val, err := strconv.Atoi(partyResult); err == nil && (val == 0 || val == 1) {
-			*party = val
+			*f.party = val
 		} else {
-			fmt.Println("Invalid party number, using default:", *party)
+			fmt.Println("Invalid party number, using default:", *f.party)
 		}
 		fmt.Println()
 	}
 
 	// Show configuration summary
 	fmt.Println("Zero-Knowledge Intersection Configuration:")
-	fmt.Printf("  Dataset 1: %s\n", *dataset1)
-	fmt.Printf("  Dataset 2: %s\n", *dataset2)
-	fmt.Printf("  Output: %s\n", *outputFile)
-	fmt.Printf("  Party: %d\n", *party)
-	fmt.Printf("  Security: Zero-knowledge protocols (hardcoded thresholds)\n")
+	fmt.Printf("  Dataset 1: %s\n", *f.dataset1)
+	fmt.Printf("  Dataset 2: %s\n", *f.dataset2)
+	fmt.Printf("  Output: %s\n", schema.destination(*f.outputFile))
+	fmt.Printf("  Party: %d\n", *f.party)
+	if *f.keyFile != "" {
+		fmt.Printf("  Key: %s (encrypted datasets are decrypted in memory)\n", *f.keyFile)
+	} else if *f.keyHex != "" {
+		fmt.Printf("  Key: provided as hex (encrypted datasets are decrypted in memory)\n")
+	}
+	fmt.Printf("  Thresholds: %s\n", thresholds)
+	if *f.resume {
+		checkpointBase := *f.outputFile
+		if loc, err := objstore.Parse(*f.outputFile); err == nil {
+			checkpointBase = filepath.Join("out", loc.Name())
+		}
+		fmt.Printf("  Resume: from %s.checkpoint if it matches these datasets\n", checkpointBase)
+	}
+	fmt.Printf("  Output Columns: %s (%s)\n", strings.Join(schema.header(), ","), schema.Format)
+	if *f.provenance {
+		fmt.Printf("  Provenance: %s added after the match columns\n", strings.Join(provenanceResultColumns, ","))
+	}
+	if *f.maxMemory != "" {
+		fmt.Printf("  Max Memory: %s\n", *f.maxMemory)
+	}
+	if histogram != nil {
+		fmt.Printf("  Score Histogram: %s (every comparison, MinHash pre-filter off)\n", f.histogramFlags.file)
+		if *f.resume {
+			fmt.Printf("    Only records compared after the checkpoint are counted\n")
+		}
+	}
+	if *f.streaming {
+		fmt.Printf("  Streaming: LSH index of the smaller dataset, %d MinHash values per band\n", *f.bandSize)
+	} else if *f.noBlocking {
+		fmt.Printf("  Blocking: off, every pair is compared\n")
+	}
+	switch {
+	case card.Mode == crypto.CardinalityOneToOne:
+		fmt.Printf("  Matching: 1:1 (%s assignment), each record matches at most one record\n", card.Assignment)
+	case card.Mode == crypto.CardinalityOneToMany:
+		fmt.Printf("  Matching: 1:many, each dataset2 record keeps its best dataset1 match\n")
+	case schema.Retention.MaxPerRecord > 0:
+		fmt.Printf("  Matching: many:many, up to %d best pairs per record within the thresholds\n", schema.Retention.MaxPerRecord)
+	default:
+		fmt.Printf("  Matching: many:many, every pair within the thresholds is kept\n")
+	}
+	if schema.Retention.MinJaccard > 0 {
+		fmt.Printf("  Score Floor: matches below Jaccard %.3f are left out\n", schema.Retention.MinJaccard)
+	}
+	if comparatorName != "" {
+		fmt.Printf("  Comparator: %s\n", comparatorName)
+	}
+	fmt.Printf("  Security: Zero-knowledge protocols\n")
+	if schema.includesScores() {
+		fmt.Printf("  WARNING: Score columns reveal how similar each pair is; keep the results local\n")
+	}
 	fmt.Println()
 
-	// Confirm before proceeding
-	confirmChoice := promptForChoice("Ready to start zero-knowledge intersection?", []string{
-		"Yes, find intersections",
-		"Change configuration",
-		"Cancel",
-	})
+	// Confirm before proceeding (unless -yes is set)
+	if !skipConfirmation("intersect", false) {
+		confirmChoice := promptForChoice("Ready to start zero-knowledge intersection?", []string{
+			"Yes, find intersections",
+			"Change configuration",
+			"Cancel",
+		})
+
+		if confirmChoice == 2 {
+			fmt.Println("\nIntersection cancelled. Goodbye!")
+			os.Exit(0)
+		}
 
-	if confirmChoice == 2 {
-		fmt.Println("\nIntersection cancelled. Goodbye!")
-		os.Exit(0)
+		if confirmChoice == 1 {
+			fmt.Print("\nRestarting configuration...\n\n")
+			newArgs := append([]string{"-interactive"}, args...)
+			runIntersectCommand(newArgs)
+			return
+		}
 	}
 
-	if confirmChoice == 1 {
-		fmt.Println("\nRestarting configuration...\n")
-		newArgs := append([]string{"-interactive"}, args...)
-		runIntersectCommand(newArgs)
-		return
+	// Validate inputs
+	if *f.resume && *f.streaming {
+		fatalf(UsageError, "ERROR: -resume is not supported with -streaming")
+	}
+	if comparatorName != "" && *f.streaming {
+		fatalf(UsageError, "ERROR: comparator %s is not supported with -streaming, which compares Bloom filters", comparatorName)
+	}
+	// Datasets in cloud storage are downloaded, and an output bound for it is written to out/
+	// and uploaded once complete
+	remote1, remote2 := *f.dataset1, *f.dataset2
+	local1, cleanup1, err := stageInput(cfg, *f.dataset1)
+	if err != nil {
+		fatalf(DataError, "ERROR: %v", err)
+	}
+	defer cleanup1()
+	local2, cleanup2, err := stageInput(cfg, *f.dataset2)
+	if err != nil {
+		cleanup1()
+		fatalf(DataError, "ERROR: %v", err)
+	}
+	defer cleanup2()
+	cleanupInputs := func() { cleanup1(); cleanup2() }
+	localOutput, uploadOutput, err := stageOutput(cfg, *f.outputFile)
+	if err != nil {
+		cleanupInputs()
+		fatalf(DataError, "ERROR: %v", err)
+	}
+	if *f.clusters == "" && cfg.Matching.Clustering.Enabled {
+		*f.clusters = clusterOutputPath(localOutput, schema.Format)
+	}
+	if *f.clusters != "" {
+		schema.Clusters = &clusterOutput{Path: *f.clusters, Constraints: match.ClusterConstraints{
+			MaxSize:     cfg.Matching.Clustering.MaxSize,
+			MaxPerParty: cfg.Matching.Clustering.MaxPerParty,
+		}}
+		if *f.maxSize >= 0 {
+			schema.Clusters.Constraints.MaxSize = *f.maxSize
+		}
+		if *f.maxPerParty >= 0 {
+			schema.Clusters.Constraints.MaxPerParty = *f.maxPerParty
+		}
 	}
 
-	// Validate inputs
-	if err := validateIntersectInputs(*dataset1, *dataset2); err != nil {
-		fmt.Printf("Validation error: %v\n", err)
-		os.Exit(1)
+	if err := validateIntersectInputs(local1, local2); err != nil {
+		cleanupInputs()
+		fatalf(DataError, "Validation error: %v", err)
+	}
+	if err := checkIntersectFormats(local1, local2); err != nil {
+		cleanupInputs()
+		fatalf(ConfigError, "Incompatible token files: %v", err)
+	}
+	if comparatorName != "" && pprl.IsBloomStore(local1) && pprl.IsBloomStore(local2) {
+		cleanupInputs()
+		fatalf(UsageError, "ERROR: comparator %s needs tokenized CSV or JSON Lines datasets; .cbbf token stores are compared in place", comparatorName)
+	}
+	if *f.provenance {
+		if schema.Provenance, err = loadResultProvenance(local1, local2, keySource); err != nil {
+			cleanupInputs()
+			fatalf(DataError, "ERROR: %v", err)
+		}
+	}
+	if schema.Postgres != nil {
+		// Check the database before a long intersection rather than after it
+		sink, err := db.NewPostgresSink(*schema.Postgres)
+		if err != nil {
+			cleanupInputs()
+			fatalf(DataError, "ERROR: Output database: %v", err)
+		}
+		sink.Close()
 	}
 
 	// Run zero-knowledge intersection
-	fmt.Println("Starting zero-knowledge intersection process...\n")
+	fmt.Print("Starting zero-knowledge intersection process...\n\n")
+
+	run := startRun("intersect", cfg)
+	run.Parameters["party"] = strconv.Itoa(*f.party)
+	run.Parameters["output_columns"] = strings.Join(schema.header(), ",")
+	run.Parameters["output_format"] = schema.Format
+	run.Parameters["hamming_threshold"] = strconv.FormatUint(uint64(thresholds.Hamming), 10)
+	run.Parameters["jaccard_threshold"] = strconv.FormatFloat(thresholds.Jaccard, 'g', -1, 64)
+	run.Parameters["cardinality"] = card.Mode
+	if card.allowDuplicates() {
+		run.Parameters["allow_duplicates"] = "true"
+		run.Parameters["max_matches_per_record"] = strconv.Itoa(schema.Retention.MaxPerRecord)
+	} else {
+		run.Parameters["assignment"] = card.Assignment
+	}
+	if schema.Retention.MinJaccard > 0 {
+		run.Parameters["min_score"] = strconv.FormatFloat(schema.Retention.MinJaccard, 'g', -1, 64)
+	}
+	if schema.Clusters != nil {
+		run.Parameters["cluster_max_size"] = strconv.Itoa(schema.Clusters.Constraints.MaxSize)
+		run.Parameters["cluster_max_per_party"] = strconv.Itoa(schema.Clusters.Constraints.MaxPerParty)
+	}
+	if schema.Provenance != nil {
+		run.Parameters["provenance"] = "true"
+	}
+	if comparatorName != "" {
+		run.Parameters["comparator"] = comparatorName
+	}
+	addStagedInput(run, local1, remote1)
+	addStagedInput(run, local2, remote2)
+
+	memory := startMemoryWatchdog(*f.maxMemory)
+	defer memory.Stop()
+	if memory != nil && !*f.streaming && !*f.resume && comparatorName == "" && !(pprl.IsBloomStore(local1) && pprl.IsBloomStore(local2)) {
+		// Datasets that would not fit are streamed instead: only the smaller one is held in memory
+		if estimate := estimateTokenMemory(local1) + estimateTokenMemory(local2); !memory.Fits(estimate) {
+			fmt.Printf("Both datasets need about %s in memory, more than -max-memory allows; streaming the larger one from disk\n\n", memlimit.FormatSize(estimate))
+			*f.streaming = true
+		}
+	}
 
-	if err := performZeroKnowledgeIntersection(*dataset1, *dataset2, *outputFile, *party); err != nil {
-		fmt.Printf("Zero-knowledge intersection failed: %v\n", err)
-		os.Exit(1)
+	if *f.streaming {
+		run.Parameters["streaming"] = "true"
+		run.Parameters["band_size"] = strconv.Itoa(*f.bandSize)
+		err = performStreamingIntersection(local1, local2, localOutput, *f.party, thresholds, card, *f.bandSize, keySource, schema, histogram, memory, run)
+	} else {
+		run.Parameters["resume"] = strconv.FormatBool(*f.resume)
+		run.Parameters["blocking"] = strconv.FormatBool(!*f.noBlocking)
+		err = performZeroKnowledgeIntersection(local1, local2, localOutput, *f.party, thresholds, card, comparatorName, !*f.noBlocking, *f.resume, keySource, schema, histogram, memory, run)
+	}
+	if err == nil && histogram != nil {
+		if err = saveScoreHistogram(histogram, f.histogramFlags.file); err == nil {
+			run.AddOutput(f.histogramFlags.file)
+			run.Counts["histogram_comparisons"] = histogram.Comparisons
+		}
+	}
+	recordMemory(run, memory)
+	if err == nil && schema.Postgres == nil {
+		if err = uploadOutput(); err != nil {
+			err = fmt.Errorf("%w (results kept in %s)", err, localOutput)
+		}
+	}
+	if err != nil {
+		recordRun(run, err)
+		cleanupInputs()
+		if *f.streaming {
+			printMemoryGuidance(err, "tokenize both datasets with -output-format cbbf: token stores are memory-mapped, not loaded")
+		} else {
+			printMemoryGuidance(err,
+				"rerun with -resume to continue from the last checkpoint (saved every 1000 dataset1 records)",
+				"use -streaming, which holds only the smaller dataset in memory",
+				"tokenize both datasets with -output-format cbbf: token stores are memory-mapped, not loaded")
+		}
+		fatalf(DataError, "Zero-knowledge intersection failed: %v", err)
+	}
+	if schema.Postgres != nil {
+		run.Outputs = append(run.Outputs, schema.destination(*f.outputFile))
+	} else {
+		addStagedOutput(run, *f.outputFile)
 	}
+	if schema.Clusters != nil {
+		printClusterSummary(schema.Clusters)
+		run.AddOutput(schema.Clusters.Path)
+		run.Counts["clusters"] = len(schema.Clusters.Resolution.Clusters)
+		run.Counts["rejected_pairs"] = len(schema.Clusters.Resolution.Rejected)
+	}
+	recordRun(run, nil)
 
 	fmt.Printf("\nZero-knowledge intersection completed successfully!\n")
-	fmt.Printf("Results saved to: %s\n", *outputFile)
+	fmt.Printf("Results saved to: %s\n", schema.destination(*f.outputFile))
 	fmt.Printf("GUARANTEE: Zero information leaked beyond intersection\n")
 }
 
+// printClusterSummary reports the entity clusters resolved from the matches
+func printClusterSummary(c *clusterOutput) {
+	fmt.Printf("Clusters: %d entities from %d merged pairs\n", len(c.Resolution.Clusters), c.Resolution.Merged)
+	if len(c.Resolution.Rejected) > 0 {
+		fmt.Printf("   %d pairs left unmerged by the cluster limits (max size %d, max per dataset %d)\n",
+			len(c.Resolution.Rejected), c.Constraints.MaxSize, c.Constraints.MaxPerParty)
+	}
+	fmt.Printf("   Cluster assignment saved to: %s\n", c.Path)
+}
+
 // generateZKIntersectOutputName function replaced with shared generateOutputName in utils.go
 
 func validateIntersectInputs(dataset1, dataset2 string) error {
@@ -133,51 +472,382 @@ func validateIntersectInputs(dataset1, dataset2 string) error {
 	return nil
 }
 
-func performZeroKnowledgeIntersection(dataset1, dataset2, outputFile string, party int) error {
+// checkIntersectFormats verifies that this release can read both token files and, when both have
+// format manifests, that they were tokenized with the same recipe
+func checkIntersectFormats(dataset1, dataset2 string) error {
+	format1, err := checkTokenFormat(dataset1, "", "")
+	if err != nil {
+		return err
+	}
+	format2, err := checkTokenFormat(dataset2, "", "")
+	if err != nil {
+		return err
+	}
+	return db.CheckSameRecipe(dataset1, format1, dataset2, format2)
+}
+
+// performZeroKnowledgeIntersection intersects two tokenized files, noting record and match counts on run.
+// Progress is checkpointed next to outputFile and, with resume, continued from an earlier checkpoint.
+// With blocking, only pairs sharing a blocking key are compared when both datasets carry keys.
+// Pairs are scored by the comparator registered as comparator, or by their Bloom filters if empty.
+// Encrypted datasets are decrypted in memory with keys from keySource.
+func performZeroKnowledgeIntersection(dataset1, dataset2, outputFile string, party int, thresholds config.Thresholds, card matchCardinality, comparator string, blocking, resume bool, keySource keys.Source, schema *resultSchema, histogram *match.ScoreHistogram, memory *memlimit.Watchdog, run *store.Run) error {
 	// Ensure output directory exists
 	if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
+	// Binary token stores are matched in place, without loading every record into memory
+	if pprl.IsBloomStore(dataset1) && pprl.IsBloomStore(dataset2) {
+		return performStoreIntersection(dataset1, dataset2, outputFile, party, thresholds, card, resume, schema, histogram, run)
+	}
+
+	checkpoint, err := openIntersectCheckpoint(dataset1, dataset2, outputFile, party, thresholds, card, comparator, blocking, resume)
+	if err != nil {
+		return err
+	}
+	defer checkpoint.Close()
+
 	fmt.Println("Loading tokenized datasets...")
 
 	// Load tokenized datasets using server's secure loading (handles encrypted CSV files)
-	records1, err := server.LoadTokenizedRecords(dataset1, false, "", "")
+	records1, err := server.LoadTokenizedRecords(dataset1, false, keySource)
 	if err != nil {
 		return fmt.Errorf("failed to load dataset1: %w", err)
 	}
 	fmt.Printf("   Loaded %d records from dataset1\n", len(records1))
+	if err := memory.Check("loading dataset1"); err != nil {
+		return err
+	}
 
-	records2, err := server.LoadTokenizedRecords(dataset2, false, "", "")
+	records2, err := server.LoadTokenizedRecords(dataset2, false, keySource)
 	if err != nil {
 		return fmt.Errorf("failed to load dataset2: %w", err)
 	}
 	fmt.Printf("   Loaded %d records from dataset2\n", len(records2))
-
-	// Configure zero-knowledge fuzzy matcher (only party is configurable)
-	fuzzyConfig := &match.FuzzyMatchConfig{
-		Party: party,
+	if err := memory.Check("loading dataset2"); err != nil {
+		return err
 	}
+	run.Counts["dataset1_records"] = len(records1)
+	run.Counts["dataset2_records"] = len(records2)
 
 	// Create zero-knowledge fuzzy matcher
-	fuzzyMatcher := match.NewFuzzyMatcher(fuzzyConfig)
+	matchConfig := intersectMatchConfig(party, thresholds, card, schema.Retention, tokenDistanceScale(dataset1))
+	matchConfig.Blocking = blocking
+	matchConfig.Histogram = histogram
+	if comparator != "" {
+		if matchConfig.Comparator, err = match.NewComparator(comparator); err != nil {
+			return err
+		}
+	}
+	fuzzyMatcher := match.NewFuzzyMatcher(matchConfig)
 
 	fmt.Println("Computing zero-knowledge intersection...")
-	fmt.Printf("   Using hardcoded secure thresholds for maximum privacy\n")
-
-	// Perform zero-knowledge intersection
-	zkResult, err := fuzzyMatcher.ComputePrivateIntersection(records1, records2)
+	fmt.Printf("   Using thresholds: %s\n", thresholds)
+
+	// Perform zero-knowledge intersection; near the memory limit, blocks shrink so less work
+	// is lost if the run has to stop
+	progress := checkpoint.progress()
+	if memory != nil {
+		progress.Adapt = func(blockSize int) (int, error) {
+			if err := memory.Check("comparing records"); err != nil {
+				return 0, err
+			}
+			return memory.Batch(blockSize, checkpointBlockSize), nil
+		}
+	}
+	zkResult, err := fuzzyMatcher.ComputeResumableIntersection(records1, records2, progress)
 	if err != nil {
 		return fmt.Errorf("zero-knowledge intersection failed: %w", err)
 	}
 
 	// Save results with ZERO information leakage
 	fmt.Println("Saving zero-knowledge intersection results...")
-	if err := saveZeroKnowledgeResults(zkResult.MatchPairs, outputFile); err != nil {
+	if err := saveIntersectResults(zkResult.MatchPairs, outputFile, schema, run.ID); err != nil {
 		return fmt.Errorf("failed to save results: %w", err)
 	}
+	checkpoint.Remove()
 
 	fmt.Printf("Results: %d matches found (ONLY information revealed)\n", len(zkResult.MatchPairs))
+	run.Counts["matches"] = len(zkResult.MatchPairs)
+	return nil
+}
+
+// matchCardinality is how many dataset1 and dataset2 records one record of the other may match,
+// and for 1:1, the algorithm that assigns them
+type matchCardinality struct {
+	Mode       string // crypto.Cardinality*
+	Assignment string // 1:1 assignment algorithm
+}
+
+// manyToMany keeps every pair within the thresholds
+var manyToMany = matchCardinality{Mode: crypto.CardinalityManyToMany}
+
+// parseCardinality resolves -cardinality, -allow-duplicates (many:many) and -assignment, which
+// defaults to the configured matching.assignment
+func parseCardinality(mode string, allowDuplicates bool, assignment, configured string) (matchCardinality, error) {
+	if allowDuplicates {
+		if mode != "" && mode != crypto.CardinalityManyToMany {
+			return matchCardinality{}, fmt.Errorf("-allow-duplicates keeps every pair (many:many) and cannot be combined with -cardinality %s", mode)
+		}
+		mode = crypto.CardinalityManyToMany
+	}
+	if mode == "" {
+		mode = crypto.CardinalityOneToOne
+	}
+	if err := crypto.ValidateCardinality(mode); err != nil {
+		return matchCardinality{}, err
+	}
+	if assignment != "" && mode != crypto.CardinalityOneToOne {
+		return matchCardinality{}, fmt.Errorf("-assignment applies to 1:1 matching, not %s", mode)
+	}
+	if assignment == "" {
+		assignment = configured
+	}
+	if assignment == "" {
+		assignment = crypto.DefaultAssignment
+	}
+	if err := crypto.ValidateAssignment(assignment); err != nil {
+		return matchCardinality{}, err
+	}
+	return matchCardinality{Mode: mode, Assignment: assignment}, nil
+}
+
+// cardinalityOf is many:many when duplicates are allowed and a greedy 1:1 assignment otherwise
+func cardinalityOf(allowDuplicates bool) matchCardinality {
+	if allowDuplicates {
+		return manyToMany
+	}
+	return matchCardinality{Mode: crypto.CardinalityOneToOne, Assignment: crypto.DefaultAssignment}
+}
+
+// allowDuplicates reports whether a record may match more than one record
+func (c matchCardinality) allowDuplicates() bool {
+	return c.Mode != crypto.CardinalityOneToOne
+}
+
+// resolveComparator returns the pair comparator named by -comparator, or else by
+// matching.comparator, once it is known to be registered; the default Bloom filter comparison is
+// returned as ""
+func resolveComparator(name string, cfg *config.Config) (string, error) {
+	if name == "" {
+		name = cfg.Matching.Comparator
+	}
+	name = strings.ToLower(strings.TrimSpace(name))
+	if _, err := match.NewComparator(name); err != nil {
+		return "", err
+	}
+	if name == match.DefaultComparator {
+		return "", nil
+	}
+	return name, nil
+}
+
+// intersectMatchConfig configures the matcher of a local intersection of tokens whose hardening
+// multiplies Hamming distances by distanceScale
+func intersectMatchConfig(party int, thresholds config.Thresholds, card matchCardinality, retention crypto.Retention, distanceScale uint32) *match.FuzzyMatchConfig {
+	return &match.FuzzyMatchConfig{
+		Party:            party,
+		AllowDuplicates:  card.allowDuplicates(),
+		OneToMany:        card.Mode == crypto.CardinalityOneToMany,
+		Assignment:       card.Assignment,
+		HammingThreshold: thresholds.Hamming,
+		JaccardThreshold: thresholds.Jaccard,
+		Retention:        retention,
+		DistanceScale:    distanceScale,
+	}
+}
+
+// tokenDistanceScale returns the factor the hardening of a token file's recipe multiplies Hamming
+// distances by, from its format manifest; files without one were written before hardening existed
+func tokenDistanceScale(dataset string) uint32 {
+	format, err := db.ReadTokenFormat(dataset)
+	if err != nil {
+		return 1
+	}
+	return format.DistanceScale()
+}
+
+// openIntersectCheckpoint opens the checkpoint of intersecting dataset1 and dataset2 into outputFile
+func openIntersectCheckpoint(dataset1, dataset2, outputFile string, party int, thresholds config.Thresholds, card matchCardinality, comparator string, blocking, resume bool) (*intersectionCheckpoint, error) {
+	digest1, err := store.HashFile(dataset1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dataset1: %w", err)
+	}
+	digest2, err := store.HashFile(dataset2)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dataset2: %w", err)
+	}
+	parts := []string{"intersect", digest1.SHA256, digest2.SHA256, strconv.Itoa(party),
+		fmt.Sprintf("%d/%g", thresholds.Hamming, thresholds.Jaccard)}
+	if !blocking {
+		// Blocking changes which pairs are compared, so progress of one run doesn't carry to the other
+		parts = append(parts, "no-blocking")
+	}
+	if comparator != "" {
+		parts = append(parts, "comparator="+comparator)
+	}
+	switch card.Mode {
+	case crypto.CardinalityManyToMany:
+		parts = append(parts, "allow-duplicates")
+	case crypto.CardinalityOneToMany:
+		parts = append(parts, "allow-duplicates", card.Mode)
+	}
+	inputs := inputsDigest(parts...)
+	return openCheckpoint(outputFile, inputs, resume)
+}
+
+// performStoreIntersection intersects two memory-mapped binary token stores
+func performStoreIntersection(dataset1, dataset2, outputFile string, party int, thresholds config.Thresholds, card matchCardinality, resume bool, schema *resultSchema, histogram *match.ScoreHistogram, run *store.Run) error {
+	checkpoint, err := openIntersectCheckpoint(dataset1, dataset2, outputFile, party, thresholds, card, "", true, resume)
+	if err != nil {
+		return err
+	}
+	defer checkpoint.Close()
+
+	fmt.Println("Mapping binary token stores...")
+	store1, err := pprl.OpenBloomStore(dataset1)
+	if err != nil {
+		return fmt.Errorf("failed to open dataset1: %w", err)
+	}
+	defer store1.Close()
+	fmt.Printf("   Mapped %d records from dataset1\n", store1.Len())
+
+	store2, err := pprl.OpenBloomStore(dataset2)
+	if err != nil {
+		return fmt.Errorf("failed to open dataset2: %w", err)
+	}
+	defer store2.Close()
+	fmt.Printf("   Mapped %d records from dataset2\n", store2.Len())
+	run.Counts["dataset1_records"] = store1.Len()
+	run.Counts["dataset2_records"] = store2.Len()
+	run.Parameters["input_format"] = "cbbf"
+
+	matchConfig := intersectMatchConfig(party, thresholds, card, schema.Retention, tokenDistanceScale(dataset1))
+	matchConfig.Histogram = histogram
+	fuzzyMatcher := match.NewFuzzyMatcher(matchConfig)
+
+	fmt.Println("Computing zero-knowledge intersection...")
+	fmt.Printf("   Using thresholds: %s\n", thresholds)
+	zkResult, err := fuzzyMatcher.ComputeResumableStoreIntersection(store1, store2, checkpoint.progress())
+	if err != nil {
+		return fmt.Errorf("zero-knowledge intersection failed: %w", err)
+	}
+
+	fmt.Println("Saving zero-knowledge intersection results...")
+	if err := saveIntersectResults(zkResult.MatchPairs, outputFile, schema, run.ID); err != nil {
+		return fmt.Errorf("failed to save results: %w", err)
+	}
+	checkpoint.Remove()
+
+	fmt.Printf("Results: %d matches found (ONLY information revealed)\n", len(zkResult.MatchPairs))
+	run.Counts["matches"] = len(zkResult.MatchPairs)
+	return nil
+}
+
+// streamMemoryCheckInterval is the number of records streamed between memory checks
+const streamMemoryCheckInterval = 10000
+
+// performStreamingIntersection loads only the smaller dataset, into an LSH index, and matches the
+// larger one record by record as it is read, writing each match as it is found
+func performStreamingIntersection(dataset1, dataset2, outputFile string, party int, thresholds config.Thresholds, card matchCardinality, bandSize int, keySource keys.Source, schema *resultSchema, histogram *match.ScoreHistogram, memory *memlimit.Watchdog, run *store.Run) error {
+	// Memory-mapped token stores are already compared in place
+	if pprl.IsBloomStore(dataset1) && pprl.IsBloomStore(dataset2) {
+		return performZeroKnowledgeIntersection(dataset1, dataset2, outputFile, party, thresholds, card, "", false, false, keySource, schema, histogram, memory, run)
+	}
+	for _, dataset := range []string{dataset1, dataset2} {
+		if strings.HasSuffix(strings.ToLower(dataset), ".json") {
+			return fmt.Errorf("streaming mode reads tokenized CSV files; %s is JSON", dataset)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	// Index the smaller file; the larger one is never held in memory
+	indexed, streamed, indexedLocal := dataset1, dataset2, true
+	info1, err1 := os.Stat(dataset1)
+	info2, err2 := os.Stat(dataset2)
+	if err1 == nil && err2 == nil && info2.Size() < info1.Size() && card.Mode != crypto.CardinalityOneToMany {
+		// 1:many keeps each dataset2 record's best match, so dataset2 is always the one streamed
+		indexed, streamed, indexedLocal = dataset2, dataset1, false
+	}
+	indexedKey, streamedKey := "dataset1_records", "dataset2_records"
+	if !indexedLocal {
+		indexedKey, streamedKey = streamedKey, indexedKey
+	}
+
+	fmt.Printf("Indexing %s...\n", indexed)
+	if estimate := estimateTokenMemory(indexed); !memory.Fits(estimate) {
+		return &memlimit.LimitError{Stage: fmt.Sprintf("indexing %s (about %s needed)", indexed, memlimit.FormatSize(estimate)), Used: memory.Peak(), Limit: memory.Limit()}
+	}
+	records, err := server.LoadTokenizedRecords(indexed, false, keySource)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", indexed, err)
+	}
+	matchConfig := intersectMatchConfig(party, thresholds, card, schema.Retention, tokenDistanceScale(dataset1))
+	matchConfig.Histogram = histogram
+	fuzzyMatcher := match.NewFuzzyMatcher(matchConfig)
+	index, err := fuzzyMatcher.NewStreamIndex(records, indexedLocal, bandSize)
+	if err != nil {
+		return fmt.Errorf("failed to index %s: %w", indexed, err)
+	}
+	fmt.Printf("   Indexed %d records in LSH buckets\n", len(records))
+	if err := memory.Check("indexing " + indexed); err != nil {
+		return err
+	}
+	run.Counts[indexedKey] = len(records)
+
+	stream, err := server.OpenTokenizedRecordStream(streamed, false, keySource)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", streamed, err)
+	}
+	defer stream.Close()
+
+	writer, err := newResultWriter(outputFile, schema, run.ID, -1)
+	if err != nil {
+		return fmt.Errorf("failed to create results: %w", err)
+	}
+
+	fmt.Printf("Streaming %s...\n", streamed)
+	fmt.Printf("   Using thresholds: %s\n", thresholds)
+	matches := 0
+	for {
+		record, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writer.Abort()
+			return fmt.Errorf("failed to read %s: %w", streamed, err)
+		}
+		for _, pair := range index.Match(record) {
+			if err := writer.Write(pair); err != nil {
+				writer.Abort()
+				return fmt.Errorf("failed to save results: %w", err)
+			}
+			matches++
+		}
+		if index.Streamed()%100000 == 0 {
+			fmt.Printf("   Streamed %d records, %d matches so far\n", index.Streamed(), matches)
+		}
+		if index.Streamed()%streamMemoryCheckInterval == 0 {
+			if err := memory.Check(fmt.Sprintf("streaming %s (%d records done)", streamed, index.Streamed())); err != nil {
+				writer.Abort()
+				return err
+			}
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to save results: %w", err)
+	}
+	fmt.Printf("   Streamed %d records\n", index.Streamed())
+	run.Counts[streamedKey] = index.Streamed()
+	run.Counts["compared_pairs"] = index.Compared()
+
+	fmt.Printf("Results: %d matches found (ONLY information revealed)\n", matches)
+	run.Counts["matches"] = matches
 	return nil
 }
 
@@ -194,15 +864,87 @@ func showZKIntersectHelp() {
 	fmt.Println("OPTIONS:")
 	fmt.Println("  -dataset1 <path>       Path to first tokenized dataset file")
 	fmt.Println("  -dataset2 <path>       Path to second tokenized dataset file")
-	fmt.Println("  -output <path>         Output file for intersection results")
+	fmt.Println("                         Tokenized CSV or JSON Lines (.jsonl, .ndjson), optionally")
+	fmt.Println("                         gzipped or encrypted; two .cbbf token stores are")
+	fmt.Println("                         memory-mapped, not loaded")
+	fmt.Println("  -key <path>            Key file for encrypted (.enc) datasets; they are decrypted")
+	fmt.Println("                         in memory and never written to disk as plaintext")
+	fmt.Println("  -key-hex <hex>         Key as a 64-character hex string instead of -key")
+	fmt.Println("                         (default: <dataset>.key next to the file, then the env,")
+	fmt.Println("                         keyring and OS keychain key sources)")
+	fmt.Println("  -output <path>         Output file for intersection results, gzipped if it ends in .gz")
+	fmt.Println("                         Datasets and output may be s3://, gs:// or az:// objects,")
+	fmt.Println("                         using the storage section of -config")
 	fmt.Println("  -party <n>             Party number (0 or 1) for two-party protocol")
+	fmt.Println("  -config <path>         Config with the output section (columns, format, metadata)")
+	fmt.Println("                         and matching thresholds")
+	fmt.Println("  -output-columns <list> Result columns, in order (default: local_id,peer_id); one of")
+	fmt.Println("                         local_id, peer_id, hamming_distance, jaccard_similarity, run_id")
+	fmt.Println("  -output-format <fmt>   csv (default), jsonl, or postgres to load the matches into")
+	fmt.Println("                         output.postgres.matches_table of -config with COPY")
+	fmt.Println("  -output-meta <list>    Static columns, e.g. site_a=north,site_b=south")
+	fmt.Println("  -provenance            Add local_ and peer_source_file, source_row and batch_id")
+	fmt.Println("                         columns after the match columns, tracing both records to")
+	fmt.Println("                         their source rows (token files from tokenize -provenance)")
+	fmt.Println("  -resume                Continue an interrupted run from <output>.checkpoint")
+	fmt.Println("                         (progress is saved every 1000 dataset1 records)")
+	fmt.Println("  -streaming             Index the smaller dataset and stream the larger one from")
+	fmt.Println("                         disk, writing matches as they are found")
+	fmt.Println("  -band-size <n>         MinHash values per LSH band when streaming (default: 4);")
+	fmt.Println("                         smaller bands compare more pairs and miss fewer matches")
+	fmt.Println("  -max-memory <size>     Memory limit, e.g. 4G: datasets estimated not to fit are")
+	fmt.Println("                         streamed, comparison blocks shrink as the heap nears it, and")
+	fmt.Println("                         the run stops cleanly, with advice, instead of exceeding it")
+	fmt.Println("  -no-blocking           Compare every pair even when both datasets carry blocking")
+	fmt.Println("                         keys (tokenization.blocking); -streaming and .cbbf stores")
+	fmt.Println("                         never use them")
+	fmt.Println("  -cardinality <mode>    How many matches a record may have:")
+	fmt.Println("                           1:1        each record at most one (default)")
+	fmt.Println("                           1:many     a dataset1 record many, a dataset2 record only")
+	fmt.Println("                                      its best; dataset2 is streamed with -streaming")
+	fmt.Println("                           many:many  every pair within the thresholds")
+	fmt.Println("  -assignment <name>     1:1 assignment: greedy (best pairs first) or hungarian")
+	fmt.Println("                         (most matches, then lowest total distance)")
+	fmt.Println("                         (default: matching.assignment or greedy)")
+	fmt.Println("  -comparator <name>     Score pairs with a registered comparator instead of the")
+	fmt.Println("                         Bloom filter and MinHash one (default: matching.comparator")
+	fmt.Println("                         or bloom); not with -streaming or .cbbf stores")
+	fmt.Println("  -allow-duplicates      Keep every pair within the thresholds, as -cardinality")
+	fmt.Println("                         many:many")
+	fmt.Println("  -max-matches-per-record <n>")
+	fmt.Println("                         With 1:many or many:many, keep only each record's n best pairs")
+	fmt.Println("                         (default: matching.max_matches_per_record or 10, -1 = all)")
+	fmt.Println("  -min-score <f>         Leave out matches below this Jaccard similarity, bounding")
+	fmt.Println("                         the results under loose thresholds (default: matching.min_score)")
+	fmt.Println("  -clusters <path>       Resolve the matches into entity clusters (transitive closure)")
+	fmt.Println("                         and write linkage_id,local_id,peer_id rows here; .jsonl")
+	fmt.Println("                         writes JSON Lines (default with matching.clustering.enabled:")
+	fmt.Println("                         <output>_clusters.csv)")
+	fmt.Println("  -cluster-max-size <n>  Most records in one cluster; pairs that would merge larger")
+	fmt.Println("                         clusters are left out, weakest first (default: config, 0 = none)")
+	fmt.Println("  -cluster-max-per-party <n>")
+	fmt.Println("                         Most records of one dataset in one cluster (default: config,")
+	fmt.Println("                         0 = none)")
+	fmt.Printf("  -hamming-threshold <n> Maximum Hamming distance of a match (default: config or %d)\n", config.DefaultHammingThreshold)
+	fmt.Printf("  -jaccard-threshold <f> Minimum Jaccard similarity of a match (default: config or %g)\n", config.DefaultJaccardThreshold)
+	fmt.Println("  -histogram <path>      Write binned counts of the Hamming distance and Jaccard")
+	fmt.Println("                         similarity of every comparison (.csv or .json); they hold")
+	fmt.Println("                         no record IDs, so both parties can compare them to agree")
+	fmt.Println("                         on thresholds. Turns the MinHash pre-filter off")
+	fmt.Printf("  -histogram-hamming-bin <n>\n")
+	fmt.Printf("                         Hamming distance bin width (default: %d)\n", match.DefaultHammingBinWidth)
+	fmt.Printf("  -histogram-jaccard-bin <f>\n")
+	fmt.Printf("                         Jaccard similarity bin width (default: %g)\n", match.DefaultJaccardBinWidth)
 	fmt.Println("  -interactive           Force interactive mode")
+	fmt.Println("  -yes                   Start without the confirmation prompt (global flag)")
 	fmt.Println("  -help                  Show this help message")
 	fmt.Println()
 	fmt.Println("SECURITY GUARANTEES:")
 	fmt.Println("  - Zero-knowledge protocols: No information leaked beyond matches")
-	fmt.Println("  - Hardcoded thresholds: No configurable values that could leak data")
-	fmt.Println("  - No similarity scores: Only intersection pairs revealed")
+	fmt.Println("  - Thresholds stay local: both parties should use the same values, which are")
+	fmt.Println("    printed with their source (flag, config or default) at the start of a run")
+	fmt.Println("  - No similarity scores: Only intersection pairs revealed, unless score")
+	fmt.Println("    columns are explicitly selected for local review")
 	fmt.Println("  - Constant-time operations: Prevents timing attacks")
 	fmt.Println()
 	fmt.Println("EXAMPLES:")
@@ -212,27 +954,36 @@ func showZKIntersectHelp() {
 	fmt.Println("  # Specify party for two-party protocol")
 	fmt.Println("  cohort-bridge intersect -dataset1 tokens1.csv -dataset2 tokens2.csv -party 1")
 	fmt.Println()
+	fmt.Println("  # JSON Lines with a run ID and site columns")
+	fmt.Println("  cohort-bridge intersect -dataset1 tokens1.csv -dataset2 tokens2.csv \\")
+	fmt.Println("    -output-format jsonl -output-columns local_id,peer_id,run_id -output-meta site_a=north,site_b=south")
+	fmt.Println()
+	fmt.Println("  # Load the matches into the research warehouse (output.postgres in config.yaml)")
+	fmt.Println("  cohort-bridge intersect -dataset1 tokens1.csv -dataset2 tokens2.csv \\")
+	fmt.Println("    -config config.yaml -output-format postgres -output-columns local_id,peer_id,run_id")
+	fmt.Println()
+	fmt.Println("  # Trace every matched pair back to its source rows for an audit")
+	fmt.Println("  cohort-bridge intersect -dataset1 tokens1.csv -dataset2 tokens2.csv -provenance")
+	fmt.Println()
+	fmt.Println("  # Encrypted tokens from another site, decrypted in memory")
+	fmt.Println("  cohort-bridge intersect -dataset1 tokens1.csv -dataset2 site_b.csv.enc -key site_b.key")
+	fmt.Println()
+	fmt.Println("  # Large datasets: stream the larger file instead of loading it")
+	fmt.Println("  cohort-bridge intersect -dataset1 registry.csv -dataset2 cohort.csv -streaming")
+	fmt.Println()
+	fmt.Println("  # Large datasets: binary token stores from 'tokenize -output-format cbbf'")
+	fmt.Println("  cohort-bridge intersect -dataset1 tokens1.cbbf -dataset2 tokens2.cbbf")
+	fmt.Println()
+	fmt.Println("  # Score distributions to share with the other party when choosing thresholds")
+	fmt.Println("  cohort-bridge intersect -dataset1 tokens1.csv -dataset2 tokens2.csv -histogram scores.csv")
+	fmt.Println()
+	fmt.Println("  # Deduplicate a registry against a cohort: each cohort record keeps its best registry match")
+	fmt.Println("  cohort-bridge intersect -dataset1 registry.csv -dataset2 cohort.csv -cardinality 1:many")
+	fmt.Println()
+	fmt.Println("  # Keep every match and resolve them into entities of at most two records per dataset")
+	fmt.Println("  cohort-bridge intersect -dataset1 tokens1.csv -dataset2 tokens2.csv \\")
+	fmt.Println("    -allow-duplicates -clusters clusters.csv -cluster-max-per-party 2")
+	fmt.Println()
 	fmt.Println("  # Interactive mode")
 	fmt.Println("  cohort-bridge intersect -interactive")
 }
-
-func saveZeroKnowledgeResults(matches []crypto.PrivateMatchPair, outputFile string) error {
-	file, err := os.Create(outputFile)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	// Write header - ONLY the matches, no other information
-	fmt.Fprintf(file, "# CohortBridge Zero-Knowledge Intersection Results\n")
-	fmt.Fprintf(file, "# Security Guarantee: Zero information leaked beyond intersection\n")
-	fmt.Fprintf(file, "# Total matches found: %d\n", len(matches))
-	fmt.Fprintf(file, "local_id,peer_id\n")
-
-	// Write ONLY the matching pairs - no scores, distances, or metadata
-	for _, match := range matches {
-		fmt.Fprintf(file, "%s,%s\n", match.LocalID, match.PeerID)
-	}
-
-	return nil
-}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/keys"
+	"github.com/auroradata-ai/cohort-bridge/internal/server"
+	"github.com/auroradata-ai/cohort-bridge/internal/store"
+)
+
+// intersectAPIFlags are the flags of the intersect-api command
+type intersectAPIFlags struct {
+	fs              *flag.FlagSet
+	configFile      *string
+	listen          *string
+	pidFile         *string
+	allowDuplicates *bool
+	help            *bool
+}
+
+// newIntersectAPIFlags defines the flags of the intersect-api command
+func newIntersectAPIFlags() *intersectAPIFlags {
+	fs := newFlagSet("intersect-api")
+	return &intersectAPIFlags{
+		fs:              fs,
+		configFile:      fs.String("config", "", "Configuration file"),
+		listen:          fs.String("listen", "", "Address to listen on (overrides serve.listen)"),
+		pidFile:         fs.String("pid-file", "", "Write the process ID here (overrides serve.pid_file)"),
+		allowDuplicates: fs.Bool("allow-duplicates", false, "Allow 1:many matching for every request (default: when the request asks for it)"),
+		help:            fs.Bool("help", false, "Show help message"),
+	}
+}
+
+func runIntersectAPICommand(args []string) {
+	f := newIntersectAPIFlags()
+	f.fs.Parse(args)
+
+	if *f.help {
+		showIntersectAPIHelp()
+		return
+	}
+
+	if *f.configFile == "" {
+		showIntersectAPIHelp()
+		fatalf(UsageError, "Error: -config is required")
+	}
+
+	cfg, err := config.Load(*f.configFile)
+	if err != nil {
+		fatalf(ConfigError, "Failed to load configuration: %v", err)
+	}
+	if *f.listen != "" {
+		cfg.Serve.Listen = *f.listen
+	}
+	if *f.pidFile != "" {
+		cfg.Serve.PIDFile = *f.pidFile
+	}
+	schema, err := newResultSchema(cfg, "", "", "")
+	if err != nil {
+		fatalf(ConfigError, "Invalid output configuration: %v", err)
+	}
+	if schema.Postgres != nil {
+		fatalf(ConfigError, "intersect-api serves results as files; set output.format to csv or jsonl")
+	}
+	resultName, contentType := "results.csv", "text/csv"
+	if schema.Format == "jsonl" {
+		resultName, contentType = "results.jsonl", "application/x-ndjson"
+	}
+
+	fmt.Println("CohortBridge Intersect API")
+	fmt.Println("==========================")
+	fmt.Printf("Listen Address: %s\n", cfg.Serve.Listen)
+	fmt.Printf("Concurrent Intersections: %d\n", cfg.Serve.MaxConcurrentJobs)
+	fmt.Printf("Result Retention: %s\n", cfg.Serve.ResultRetention)
+	if len(cfg.Serve.ReferenceRoots) > 0 {
+		fmt.Printf("Reference Roots: %s\n", strings.Join(cfg.Serve.ReferenceRoots, ", "))
+	} else {
+		fmt.Printf("Reference Roots: none (token files must be uploaded)\n")
+	}
+	fmt.Println()
+
+	if cfg.Logging.EnableAudit || cfg.Logging.File != "" {
+		if err := server.InitLogger(cfg, "intersect-api"); err != nil {
+			fatalf(DataError, "Failed to open log files: %v", err)
+		}
+	}
+
+	apiKeys, err := loadAPIKeys(cfg)
+	if err != nil {
+		fatalf(CryptoError, "Failed to load API keys: %v", err)
+	}
+
+	// Encrypted uploads are decrypted in memory with the server's key sources
+	keySource := defaultKeySources(cfg)
+
+	runner := func(id string, request server.IntersectionRequest, resultFile string) (int, error) {
+		run := startRun("intersect", cfg)
+		matches, err := runIntersectAPIJob(id, request, resultFile, cfg, *f.allowDuplicates, keySource, schema, run)
+		recordRun(run, err)
+		return matches, err
+	}
+
+	security, err := server.NewSecurityManager(cfg)
+	if err != nil {
+		fatalf(ConfigError, "Invalid security configuration: %v", err)
+	}
+	if len(cfg.Security.AllowedIPs) > 0 {
+		fmt.Printf("Allowed Clients: %s\n", strings.Join(cfg.Security.AllowedIPs, ", "))
+	}
+
+	api, err := server.NewIntersectionAPI(server.IntersectionAPIConfig{
+		APIKeys:           apiKeys,
+		JobsDir:           filepath.Join(cfg.Serve.JobsDir, "intersections"),
+		MaxConcurrentJobs: cfg.Serve.MaxConcurrentJobs,
+		MaxUploadBytes:    cfg.Serve.MaxUploadMB << 20,
+		MaxQueuedJobs:     cfg.Serve.MaxQueuedJobs,
+		RequestTimeout:    cfg.Security.RequestTimeout,
+		ResultRetention:   cfg.Serve.ResultRetention,
+		DeleteOnDownload:  cfg.Serve.DeleteOnDownload,
+		ReferenceRoots:    cfg.Serve.ReferenceRoots,
+		ResultName:        resultName,
+		ResultContentType: contentType,
+	}, runner, security)
+	if err != nil {
+		fatalf(InternalError, "Failed to start intersect API: %v", err)
+	}
+
+	runDaemon("Intersect API", *f.configFile, cfg, security, api)
+}
+
+// runIntersectAPIJob intersects the token files of an API request into resultFile, noting the
+// parameters and counts on run, and returns the number of matches
+func runIntersectAPIJob(id string, request server.IntersectionRequest, resultFile string, cfg *config.Config, allowDuplicates bool, keySource keys.Source, schema *resultSchema, run *store.Run) (int, error) {
+	thresholds := config.ResolveThresholds(request.HammingThreshold, request.JaccardThreshold, cfg)
+	allowDuplicates = allowDuplicates || request.AllowDuplicates
+
+	run.Parameters["api_job"] = id
+	run.Parameters["output_format"] = schema.Format
+	run.Parameters["hamming_threshold"] = strconv.FormatUint(uint64(thresholds.Hamming), 10)
+	run.Parameters["jaccard_threshold"] = strconv.FormatFloat(thresholds.Jaccard, 'g', -1, 64)
+	if allowDuplicates {
+		run.Parameters["allow_duplicates"] = "true"
+	}
+
+	// Referenced objects are downloaded for the intersection only
+	local1, cleanup1, err := stageInput(cfg, request.Dataset1)
+	if err != nil {
+		return 0, err
+	}
+	defer cleanup1()
+	local2, cleanup2, err := stageInput(cfg, request.Dataset2)
+	if err != nil {
+		return 0, err
+	}
+	defer cleanup2()
+	if err := checkIntersectFormats(local1, local2); err != nil {
+		return 0, fmt.Errorf("incompatible token files: %w", err)
+	}
+	addStagedInput(run, local1, request.Dataset1)
+	addStagedInput(run, local2, request.Dataset2)
+
+	if err := performZeroKnowledgeIntersection(local1, local2, resultFile, 0, thresholds, cardinalityOf(allowDuplicates), "", true, false, keySource, schema, nil, nil, run); err != nil {
+		return 0, err
+	}
+	run.AddOutput(resultFile)
+	return run.Counts["matches"], nil
+}
+
+func showIntersectAPIHelp() {
+	fmt.Println("CohortBridge Intersect API")
+	fmt.Println("==========================")
+	fmt.Println()
+	fmt.Println("HTTP server variant of intersect: clients submit two tokenized files, uploaded")
+	fmt.Println("or referenced, poll the intersection and download its results")
+	fmt.Println()
+	fmt.Println("USAGE:")
+	fmt.Println("  cohort-bridge intersect-api -config config.yaml [OPTIONS]")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Println("  -config string        Configuration file (required)")
+	fmt.Println("  -listen string        Address to listen on (default: serve.listen or :8090)")
+	fmt.Println("  -pid-file string      Write the process ID here (default: serve.pid_file)")
+	fmt.Println("  -allow-duplicates     Allow 1:many matching for every request (default: only")
+	fmt.Println("                        when the request sets allow_duplicates)")
+	fmt.Println("  -help                 Show this help message")
+	fmt.Println()
+	fmt.Println("ENDPOINTS (all but /healthz need 'Authorization: Bearer <api key>'):")
+	fmt.Println("  GET    /healthz                         Liveness; 503 while draining")
+	fmt.Println("  POST   /v1/intersections                Submit an intersection (see below)")
+	fmt.Println("  GET    /v1/intersections                List intersections")
+	fmt.Println("  GET    /v1/intersections/{id}           Status, match count and expiry")
+	fmt.Println("  GET    /v1/intersections/{id}/results   Results of a succeeded intersection")
+	fmt.Println("  DELETE /v1/intersections/{id}           Withdraw a queued intersection, or delete")
+	fmt.Println("                                          a finished one and its results")
+	fmt.Println("  GET    /metrics                         Prometheus metrics")
+	fmt.Println()
+	fmt.Println("SUBMISSIONS:")
+	fmt.Println("  multipart/form-data   dataset1 and dataset2 as file uploads (CSV, JSON Lines,")
+	fmt.Println("                        gzipped or encrypted, recognized by file name) or as")
+	fmt.Println("                        references; optional hamming_threshold, jaccard_threshold")
+	fmt.Println("                        and allow_duplicates fields")
+	fmt.Println("  application/json      {\"dataset1\": ..., \"dataset2\": ...} with references only")
+	fmt.Println("  References are paths or s3://, gs:// and az:// objects under serve.reference_roots;")
+	fmt.Println("  without roots, token files must be uploaded.")
+	fmt.Println()
+	fmt.Println("Results are written in output.format (csv or jsonl) with output.columns, and")
+	fmt.Println("compressed when the client sends a matching Accept-Encoding.")
+	fmt.Println()
+	fmt.Println("CONFIGURATION (serve section):")
+	fmt.Println("  listen, api_keys, api_keys_file, tls_cert_file, tls_key_file, jobs_dir,")
+	fmt.Println("  max_concurrent_jobs, max_upload_mb (per file), max_queued_jobs, shutdown_timeout")
+	fmt.Println("  result_retention              finished intersections are deleted after this (default: 24h)")
+	fmt.Println("  delete_results_on_download    delete results once downloaded")
+	fmt.Println("  reference_roots               directories and object prefixes files may be referenced from")
+	fmt.Printf("  API keys may also be given comma-separated in %s\n", apiKeysEnvVar)
+	fmt.Println("  The security section (allowed_ips, rate limits, request_timeout) applies as for serve.")
+	fmt.Println("  Signals, reloads, the PID file and systemd or Windows service support work as")
+	fmt.Println("  for serve (see 'cohort-bridge serve -help').")
+	fmt.Println()
+	fmt.Println("EXAMPLES:")
+	fmt.Println("  cohort-bridge intersect-api -config config_serve.example.yaml")
+	fmt.Println()
+	fmt.Println("  curl -H \"Authorization: Bearer $KEY\" -F dataset1=@tokens1.csv -F dataset2=@tokens2.csv \\")
+	fmt.Println("       http://localhost:8090/v1/intersections")
+	fmt.Println()
+	fmt.Println("  curl -H \"Authorization: Bearer $KEY\" -H \"Content-Type: application/json\" \\")
+	fmt.Println("       -d '{\"dataset1\": \"site_a.csv\", \"dataset2\": \"s3://cohort-tokens/incoming/site_b.csv\"}' \\")
+	fmt.Println("       http://localhost:8090/v1/intersections")
+	fmt.Println()
+	fmt.Println("  curl -H \"Authorization: Bearer $KEY\" http://localhost:8090/v1/intersections/$ID/results")
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/crypto"
+	"github.com/auroradata-ai/cohort-bridge/internal/db"
+	"github.com/auroradata-ai/cohort-bridge/internal/keys"
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+	"github.com/auroradata-ai/cohort-bridge/internal/server"
+)
+
+// resultColumns are the columns intersect can write for each match, in their default order
+var resultColumns = []string{"local_id", "peer_id", "hamming_distance", "jaccard_similarity", "run_id"}
+
+// scoreColumns reveal how close a pair is, not just that it matched
+var scoreColumns = map[string]bool{"hamming_distance": true, "jaccard_similarity": true}
+
+// resultColumnTypes are the PostgreSQL types of the match columns; metadata columns are text
+var resultColumnTypes = map[string]string{
+	"local_id":           "text",
+	"peer_id":            "text",
+	"hamming_distance":   "integer",
+	"jaccard_similarity": "double precision",
+	"run_id":             "text",
+}
+
+// provenanceResultColumns are added after the match columns with intersect -provenance: the
+// source file, row and batch of the dataset1 (local) and dataset2 (peer) record of each match
+var provenanceResultColumns = []string{
+	"local_source_file", "local_source_row", "local_batch_id",
+	"peer_source_file", "peer_source_row", "peer_batch_id",
+}
+
+// resultSchema selects the columns and format of intersect results
+type resultSchema struct {
+	Columns  []string    // Match columns from resultColumns, in output order
+	Metadata [][2]string // Static name/value columns appended to every row
+	Format   string      // csv, jsonl or postgres
+
+	Postgres   *config.PostgresSinkConfig // Database receiving the rows when Format is postgres
+	Clusters   *clusterOutput             // Entity clusters written beside the results, if enabled
+	Provenance *resultProvenance          // Source rows of the matched records, if enabled
+	Retention  crypto.Retention           // Bounds on the matches written
+}
+
+// resultProvenance holds the provenance of the records of both token files by ID
+type resultProvenance struct {
+	local, peer map[string]db.Provenance
+}
+
+// loadResultProvenance reads the provenance columns written by tokenize -provenance from both
+// token files; the columns of a file without them are left empty
+func loadResultProvenance(dataset1, dataset2 string, keySource keys.Source) (*resultProvenance, error) {
+	local, err := readTokenProvenance(dataset1, keySource)
+	if err != nil {
+		return nil, err
+	}
+	peer, err := readTokenProvenance(dataset2, keySource)
+	if err != nil {
+		return nil, err
+	}
+	return &resultProvenance{local: local, peer: peer}, nil
+}
+
+// readTokenProvenance returns the provenance of the records of a CSV or JSON Lines token file
+func readTokenProvenance(dataset string, keySource keys.Source) (map[string]db.Provenance, error) {
+	if pprl.IsBloomStore(dataset) {
+		return nil, fmt.Errorf("%s is a binary token store, which has no provenance columns", dataset)
+	}
+	reader, err := server.OpenTokenizedReader(dataset, false, keySource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read provenance of %s: %w", dataset, err)
+	}
+	defer reader.Close()
+
+	provenance := make(map[string]db.Provenance)
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read provenance of %s: %w", dataset, err)
+		}
+		if record.Provenance != (db.Provenance{}) {
+			provenance[record.ID] = record.Provenance
+		}
+	}
+	if len(provenance) == 0 {
+		fmt.Printf("   Warning: %s has no provenance columns (tokenize it with -provenance); its provenance is left empty\n", dataset)
+	}
+	return provenance, nil
+}
+
+// values returns the provenance columns of a match
+func (p *resultProvenance) values(pair crypto.PrivateMatchPair) []interface{} {
+	local, peer := p.local[pair.LocalID], p.peer[pair.PeerID]
+	return []interface{}{local.SourceFile, local.SourceRow, local.BatchID, peer.SourceFile, peer.SourceRow, peer.BatchID}
+}
+
+// matchRetention reads the bounds on the matches kept from the matching section: at most
+// matching.max_matches_per_record matches per record, and none below matching.min_score
+func matchRetention(cfg *config.Config) crypto.Retention {
+	retention := crypto.Retention{MaxPerRecord: cfg.Matching.MaxMatchesPerRecord, MinJaccard: cfg.Matching.MinScore}
+	switch {
+	case retention.MaxPerRecord == 0:
+		retention.MaxPerRecord = config.DefaultMaxMatchesPerRecord
+	case retention.MaxPerRecord < 0:
+		retention.MaxPerRecord = 0
+	}
+	return retention
+}
+
+// clusterOutput resolves the matches written into entity clusters and saves the assignment of
+// every matched record to a cluster in Path, in the results format (CSV for postgres)
+type clusterOutput struct {
+	Path        string
+	Constraints match.ClusterConstraints
+
+	edges      []match.ClusterEdge
+	Resolution *match.ClusterResolution // Set when the results are closed
+}
+
+// clusterOutputPath names the cluster assignment written beside outputFile, gzipped like it
+func clusterOutputPath(outputFile, format string) string {
+	extension := ".csv"
+	if format == "jsonl" {
+		extension = ".jsonl"
+	}
+	if db.IsGzip(outputFile) {
+		outputFile = outputFile[:len(outputFile)-len(".gz")]
+		extension += ".gz"
+	}
+	return strings.TrimSuffix(outputFile, filepath.Ext(outputFile)) + "_clusters" + extension
+}
+
+// add records a written match; pairs rank by Hamming distance, then by Jaccard similarity
+func (c *clusterOutput) add(pair crypto.PrivateMatchPair) {
+	hamming, jaccard := pair.Scores()
+	c.edges = append(c.edges, match.ClusterEdge{LocalID: pair.LocalID, PeerID: pair.PeerID, Rank: jaccard - float64(hamming)})
+}
+
+// write resolves the recorded matches and writes one row per record: its cluster's linkage ID and
+// its local or peer ID
+func (c *clusterOutput) write() error {
+	c.Resolution = match.ResolveClusters(c.edges, c.Constraints, nil)
+
+	file, err := db.CreateOutput(c.Path)
+	if err != nil {
+		return fmt.Errorf("failed to write clusters: %w", err)
+	}
+	defer file.Close()
+	rows := append(match.PartyCrosswalkRows(c.Resolution.Clusters, true), match.PartyCrosswalkRows(c.Resolution.Clusters, false)...)
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].LinkageID < rows[j].LinkageID })
+
+	buf := bufio.NewWriter(file)
+	if db.IsJSONLines(c.Path) {
+		encoder := json.NewEncoder(buf)
+		for _, row := range rows {
+			if err := encoder.Encode(row); err != nil {
+				return err
+			}
+		}
+	} else {
+		w := csv.NewWriter(buf)
+		w.Write([]string{"linkage_id", "local_id", "peer_id"})
+		for _, row := range rows {
+			w.Write([]string{row.LinkageID, row.LocalID, row.PeerID})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// newResultSchema builds the schema from the output config section, with flag values (if set)
+// taking precedence: columns and format replace the config, metadata entries are added to it
+func newResultSchema(cfg *config.Config, columns, format, metadata string) (*resultSchema, error) {
+	schema := &resultSchema{Columns: cfg.Output.Columns, Format: strings.ToLower(cfg.Output.Format), Retention: matchRetention(cfg)}
+	if columns != "" {
+		schema.Columns = splitList(columns)
+	}
+	if format != "" {
+		schema.Format = strings.ToLower(format)
+	}
+	switch schema.Format {
+	case "csv", "jsonl":
+	case "postgres":
+		schema.Postgres = &cfg.Output.Postgres
+	default:
+		return nil, fmt.Errorf("unknown output format %q (expected csv, jsonl or postgres)", schema.Format)
+	}
+
+	if score := schema.Retention.MinJaccard; score < 0 || score > 1 {
+		return nil, fmt.Errorf("matching.min_score must be between 0 and 1, got %g", score)
+	}
+
+	known := make(map[string]bool, len(resultColumns))
+	for _, column := range resultColumns {
+		known[column] = true
+	}
+	seen := make(map[string]bool)
+	for _, column := range schema.Columns {
+		if !known[column] {
+			return nil, fmt.Errorf("unknown output column %q (expected %s)", column, strings.Join(resultColumns, ", "))
+		}
+		if seen[column] {
+			return nil, fmt.Errorf("output column %q listed twice", column)
+		}
+		seen[column] = true
+	}
+	if len(schema.Columns) == 0 {
+		return nil, fmt.Errorf("no output columns selected")
+	}
+
+	values := make(map[string]string)
+	for name, value := range cfg.Output.Metadata {
+		values[name] = value
+	}
+	var order []string
+	for name := range values {
+		order = append(order, name)
+	}
+	sort.Strings(order)
+	for _, entry := range splitList(metadata) {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid metadata column %q (expected name=value)", entry)
+		}
+		name = strings.TrimSpace(name)
+		if _, exists := values[name]; !exists {
+			order = append(order, name)
+		}
+		values[name] = value
+	}
+	for _, name := range order {
+		if known[name] || slices.Contains(provenanceResultColumns, name) {
+			return nil, fmt.Errorf("metadata column %q clashes with a match column", name)
+		}
+		schema.Metadata = append(schema.Metadata, [2]string{name, values[name]})
+	}
+	return schema, nil
+}
+
+// includesScores reports whether the schema writes similarity scores
+func (s *resultSchema) includesScores() bool {
+	for _, column := range s.Columns {
+		if scoreColumns[column] {
+			return true
+		}
+	}
+	return false
+}
+
+// header returns the column names of a result row
+func (s *resultSchema) header() []string {
+	header := append([]string{}, s.Columns...)
+	if s.Provenance != nil {
+		header = append(header, provenanceResultColumns...)
+	}
+	for _, meta := range s.Metadata {
+		header = append(header, meta[0])
+	}
+	return header
+}
+
+// destination describes where results are written: outputFile, or the Postgres matches table
+func (s *resultSchema) destination(outputFile string) string {
+	if s.Postgres != nil {
+		return fmt.Sprintf("postgres table %s.%s", s.Postgres.Schema, s.Postgres.MatchesTable)
+	}
+	return outputFile
+}
+
+// sinkColumns returns the columns of the Postgres matches table
+func (s *resultSchema) sinkColumns() []db.SinkColumn {
+	columns := make([]db.SinkColumn, 0, len(s.Columns)+len(provenanceResultColumns)+len(s.Metadata))
+	for _, column := range s.Columns {
+		columns = append(columns, db.SinkColumn{Name: column, Type: resultColumnTypes[column]})
+	}
+	if s.Provenance != nil {
+		for _, column := range provenanceResultColumns {
+			columns = append(columns, db.SinkColumn{Name: column, Type: "text"})
+		}
+	}
+	for _, meta := range s.Metadata {
+		columns = append(columns, db.SinkColumn{Name: meta[0], Type: "text"})
+	}
+	return columns
+}
+
+// row returns the values of a result row; scores keep their numeric types for JSON output
+func (s *resultSchema) row(pair crypto.PrivateMatchPair, runID string) []interface{} {
+	hamming, jaccard := pair.Scores()
+	row := make([]interface{}, 0, len(s.Columns)+len(provenanceResultColumns)+len(s.Metadata))
+	for _, column := range s.Columns {
+		switch column {
+		case "local_id":
+			row = append(row, pair.LocalID)
+		case "peer_id":
+			row = append(row, pair.PeerID)
+		case "hamming_distance":
+			row = append(row, hamming)
+		case "jaccard_similarity":
+			row = append(row, jaccard)
+		case "run_id":
+			row = append(row, runID)
+		}
+	}
+	if s.Provenance != nil {
+		row = append(row, s.Provenance.values(pair)...)
+	}
+	for _, meta := range s.Metadata {
+		row = append(row, meta[1])
+	}
+	return row
+}
+
+// saveIntersectResults writes matches in the schema's format: CSV with a comment preamble, one
+// JSON object per line with keys in column order, or rows of the Postgres matches table
+func saveIntersectResults(matches []crypto.PrivateMatchPair, outputFile string, schema *resultSchema, runID string) error {
+	writer, err := newResultWriter(outputFile, schema, runID, len(matches))
+	if err != nil {
+		return err
+	}
+	for _, pair := range matches {
+		if err := writer.Write(pair); err != nil {
+			writer.Abort()
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// resultWriter writes intersect results one match at a time
+type resultWriter struct {
+	file   io.WriteCloser // Gzip-compressed when the name ends in .gz
+	buf    *bufio.Writer
+	csv    *csv.Writer // nil for jsonl
+	sink   *db.PostgresSink
+	table  *db.SinkTable // Set instead of file for postgres
+	schema *resultSchema
+	header []string
+	runID  string
+}
+
+// newResultWriter creates outputFile and writes the CSV preamble; total is the number of matches,
+// or negative when they are streamed and not known up front. For postgres, rows go to the
+// matches table instead and are committed together on Close.
+func newResultWriter(outputFile string, schema *resultSchema, runID string, total int) (*resultWriter, error) {
+	if schema.Postgres != nil {
+		sink, err := db.NewPostgresSink(*schema.Postgres)
+		if err != nil {
+			return nil, err
+		}
+		table, err := sink.Table(schema.Postgres.MatchesTable, schema.sinkColumns())
+		if err != nil {
+			sink.Close()
+			return nil, err
+		}
+		return &resultWriter{sink: sink, table: table, schema: schema, header: schema.header(), runID: runID}, nil
+	}
+
+	file, err := db.CreateOutput(outputFile)
+	if err != nil {
+		return nil, err
+	}
+	w := &resultWriter{file: file, buf: bufio.NewWriter(file), schema: schema, header: schema.header(), runID: runID}
+	if schema.Format == "jsonl" {
+		return w, nil
+	}
+
+	// Write header - ONLY the matches, no other information unless score columns were requested
+	fmt.Fprintf(w.buf, "# CohortBridge Zero-Knowledge Intersection Results\n")
+	if schema.includesScores() {
+		fmt.Fprintf(w.buf, "# Includes local similarity scores: do not share beyond the local site\n")
+	} else {
+		fmt.Fprintf(w.buf, "# Security Guarantee: Zero information leaked beyond intersection\n")
+	}
+	if total >= 0 {
+		fmt.Fprintf(w.buf, "# Total matches found: %d\n", total)
+	} else {
+		fmt.Fprintf(w.buf, "# Matches written as found (streaming mode)\n")
+	}
+
+	w.csv = csv.NewWriter(w.buf)
+	w.csv.Write(w.header)
+	return w, nil
+}
+
+// Write writes one match
+func (w *resultWriter) Write(pair crypto.PrivateMatchPair) error {
+	if w.schema.Clusters != nil {
+		w.schema.Clusters.add(pair)
+	}
+	values := w.schema.row(pair, w.runID)
+	if w.table != nil {
+		return w.table.Append(values...)
+	}
+	if w.csv == nil {
+		w.buf.WriteByte('{')
+		for i, value := range values {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			key, _ := json.Marshal(w.header[i])
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			w.buf.Write(key)
+			w.buf.WriteByte(':')
+			w.buf.Write(encoded)
+		}
+		_, err := w.buf.WriteString("}\n")
+		return err
+	}
+
+	record := make([]string, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case string:
+			record[i] = v
+		case uint32:
+			record[i] = strconv.FormatUint(uint64(v), 10)
+		case float64:
+			record[i] = strconv.FormatFloat(v, 'f', 4, 64)
+		}
+	}
+	return w.csv.Write(record)
+}
+
+// Close flushes the results and closes the file, or commits the rows to the matches table, then
+// writes the cluster assignment if clustering is enabled
+func (w *resultWriter) Close() error {
+	if err := w.close(); err != nil {
+		return err
+	}
+	if w.schema.Clusters != nil {
+		return w.schema.Clusters.write()
+	}
+	return nil
+}
+
+// close finishes writing the results
+func (w *resultWriter) close() error {
+	if w.table != nil {
+		_, err := w.table.Commit()
+		if closeErr := w.sink.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+
+	var err error
+	if w.csv != nil {
+		w.csv.Flush()
+		err = w.csv.Error()
+	}
+	if flushErr := w.buf.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Abort stops writing after an error; rows written to the matches table are discarded, while a
+// partial results file is left as it is
+func (w *resultWriter) Abort() {
+	if w.table != nil {
+		w.table.Abort()
+		w.sink.Close()
+		return
+	}
+	w.close()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+	"github.com/auroradata-ai/cohort-bridge/internal/transfer"
+)
+
+// intersectionDigestSaltSize is the size of each party's random digest salt
+const intersectionDigestSaltSize = 32
+
+// IntersectionDigest is a salted digest of one party's match pairs. The parties compare digests
+// before their intersections, and exchange the intersections only when the digests differ.
+type IntersectionDigest struct {
+	Salt   []byte `json:"salt"`
+	Digest []byte `json:"digest"`
+}
+
+// newIntersectionDigest digests intersection under a fresh salt
+func newIntersectionDigest(intersection *IntersectionResult) (*IntersectionDigest, error) {
+	salt := make([]byte, intersectionDigestSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return &IntersectionDigest{Salt: salt, Digest: digestMatchPairs(salt, intersection.Matches)}, nil
+}
+
+// covers reports whether the digest was computed over the same match pairs as intersection
+func (d *IntersectionDigest) covers(intersection *IntersectionResult) bool {
+	return len(d.Salt) == intersectionDigestSaltSize && hmac.Equal(d.Digest, digestMatchPairs(d.Salt, intersection.Matches))
+}
+
+// digestMatchPairs returns the HMAC-SHA256 under salt of the match count and the sorted match
+// pairs, keyed as compareIntersectionResults keys them so that both parties' views agree
+func digestMatchPairs(salt []byte, matches []*match.PrivateMatchResult) []byte {
+	pairs := make([]string, 0, len(matches))
+	for key := range createPrivateMatchSet(matches) {
+		pairs = append(pairs, key)
+	}
+	sort.Strings(pairs)
+
+	mac := hmac.New(sha256.New, salt)
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(len(matches)))
+	mac.Write(length[:])
+	for _, pair := range pairs {
+		binary.BigEndian.PutUint64(length[:], uint64(len(pair)))
+		mac.Write(length[:])
+		mac.Write([]byte(pair))
+	}
+	return mac.Sum(nil)
+}
+
+// agreeIntersectionDigest records whether both handshakes offered digest comparison
+func agreeIntersectionDigest(localRecipe, peerRecipe *RecipeHandshake) {
+	localRecipe.digestAgreed = localRecipe.IntersectionDigest && peerRecipe.IntersectionDigest
+}
+
+// verifyIntersectionDigest compares salted digests of both parties' intersections when both
+// offered it. It reports whether they agree, in which case neither party discloses its full results.
+func verifyIntersectionDigest(transport peerTransport, intersection *IntersectionResult) (bool, error) {
+	local, err := newIntersectionDigest(intersection)
+	if err != nil {
+		return false, err
+	}
+	peer, err := transport.CompareIntersectionDigest(local)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case peer == nil:
+		fmt.Printf("   Intersection digests: not offered by both parties\n")
+		return false, nil
+	case peer.covers(intersection):
+		fmt.Printf("   Intersection digests match; full results are not exchanged\n")
+		return true, nil
+	default:
+		fmt.Printf("   Intersection digests differ; exchanging full results to find the differences\n")
+		return false, nil
+	}
+}
+
+// exchangeIntersectionDigest swaps intersection digests over the transfer channel
+func exchangeIntersectionDigest(channel *transfer.Channel, local *IntersectionDigest, isServer bool) (*IntersectionDigest, error) {
+	return swapDigests(channel, "intersection_digest", "intersection digest", local, isServer)
+}
+
+// swapDigests sends local and receives the peer's digest as PeerMessages of messageType; what
+// names the digest in errors
+func swapDigests(channel *transfer.Channel, messageType, what string, local *IntersectionDigest, isServer bool) (*IntersectionDigest, error) {
+	send := func() error {
+		if err := sendPeerMessage(channel, PeerMessage{Type: messageType, Payload: local}); err != nil {
+			return fmt.Errorf("failed to send %s: %v", what, err)
+		}
+		return nil
+	}
+
+	peer := &IntersectionDigest{}
+	receive := func() error {
+		var peerMessage PeerMessage
+		if err := receivePeerMessage(channel, &peerMessage); err != nil {
+			return fmt.Errorf("failed to receive peer %s: %v", what, err)
+		}
+		if peerMessage.Type != messageType {
+			return fmt.Errorf("unexpected message type: %s", peerMessage.Type)
+		}
+		if err := mapToStruct(peerMessage.Payload, peer); err != nil {
+			return fmt.Errorf("failed to parse peer %s: %v", what, err)
+		}
+		return nil
+	}
+
+	// Server receives first, client sends first
+	steps := []func() error{send, receive}
+	if isServer {
+		steps = []func() error{receive, send}
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return nil, err
+		}
+	}
+	return peer, nil
+}
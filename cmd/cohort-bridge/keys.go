@@ -0,0 +1,372 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/keys"
+)
+
+// keysFlags are the flags of an action of keys
+type keysFlags struct {
+	fs         *flag.FlagSet
+	configFile *string
+	file       *string
+	keyFile    *string
+	olderThan  *time.Duration
+	force      *bool
+	outFile    *string
+}
+
+// newKeysFlags defines the flags of an action of keys
+func newKeysFlags(action string) *keysFlags {
+	fs := newFlagSet("keys " + action)
+	return &keysFlags{
+		fs:         fs,
+		configFile: fs.String("config", "config.yaml", "Configuration file with the keys section"),
+		file:       fs.String("file", "", "Encrypted file to inspect"),
+		keyFile:    fs.String("key", "", "Key file to store in the OS keychain"),
+		olderThan:  fs.Duration("older-than", 0, "Prune retired keys older than this age"),
+		force:      fs.Bool("force", false, "Skip confirmation prompts"),
+		outFile:    fs.String("out", "signing.pem", "Signing key file to create"),
+	}
+}
+
+func runKeysCommand(args []string) {
+	if len(args) == 0 || args[0] == "-help" || args[0] == "--help" || args[0] == "help" {
+		showKeysHelp()
+		return
+	}
+
+	action := args[0]
+	f := newKeysFlags(action)
+	f.fs.Parse(args[1:])
+
+	// Signing keys are not kept in the keyring, so they need no configuration
+	switch action {
+	case "signing-keygen":
+		public, err := keys.GenerateSigningKey(*f.outFile)
+		if err != nil {
+			fatalf(CryptoError, "ERROR: %v", err)
+		}
+		fmt.Printf("Signing key written to %s (key %s)\n", *f.outFile, keys.SigningKeyID(public))
+		fmt.Println("Set peer.signing_key_file to it, and give the peer this public key to pin as peer.peer_public_key:")
+		fmt.Printf("  %s\n", keys.EncodePublicKey(public))
+		return
+
+	case "signing-pubkey":
+		if *f.keyFile == "" {
+			fatalf(UsageError, "ERROR: -key is required for signing-pubkey")
+		}
+		private, err := keys.LoadSigningKey(*f.keyFile)
+		if err != nil {
+			fatalf(CryptoError, "ERROR: %v", err)
+		}
+		public := private.Public().(ed25519.PublicKey)
+		fmt.Printf("Key ID: %s\n", keys.SigningKeyID(public))
+		fmt.Printf("Public Key: %s\n", keys.EncodePublicKey(public))
+		return
+	}
+
+	cfg := loadMainConfig(*f.configFile)
+	keyring := &keys.Keyring{Dir: cfg.Keys.KeyringDir}
+
+	switch action {
+	case "list":
+		list, activeID, err := keyring.List()
+		if err != nil {
+			fatalf(CryptoError, "ERROR: %v", err)
+		}
+		if len(list) == 0 {
+			fmt.Printf("No keys in keyring %s (run 'cohort-bridge keys rotate' to create one)\n", cfg.Keys.KeyringDir)
+			return
+		}
+		fmt.Printf("Keyring: %s (max age %s)\n", cfg.Keys.KeyringDir, cfg.Keys.MaxAge)
+		for _, key := range list {
+			status := "retired"
+			if key.ID == activeID {
+				status = "active"
+				if key.Age() >= cfg.Keys.MaxAge {
+					status = "active, EXPIRED - rotate"
+				}
+			}
+			fmt.Printf("  %s  created %s  age %s  [%s]\n", key.ID, key.Created.Format(time.RFC3339),
+				key.Age().Round(time.Hour), status)
+		}
+
+	case "rotate":
+		key, err := keyring.Rotate()
+		if err != nil {
+			fatalf(CryptoError, "ERROR: Key rotation failed: %v", err)
+		}
+		fmt.Printf("New active key: %s\n", key.ID)
+		fmt.Println("Previous keys are retained so existing files remain decryptable.")
+
+	case "prune":
+		if *f.olderThan == 0 {
+			fatalf(UsageError, "ERROR: -older-than is required for prune")
+		}
+		if !confirmStep(fmt.Sprintf("Delete retired keys older than %s? Files encrypted with them can no longer be decrypted.", *f.olderThan), *f.force) {
+			fmt.Println("Prune cancelled")
+			return
+		}
+		pruned, err := keyring.Prune(*f.olderThan)
+		if err != nil {
+			fatalf(CryptoError, "ERROR: %v", err)
+		}
+		fmt.Printf("Pruned %d key(s)\n", len(pruned))
+		for _, id := range pruned {
+			fmt.Printf("  %s\n", id)
+		}
+
+	case "inspect":
+		if *f.file == "" {
+			fatalf(UsageError, "ERROR: -file is required for inspect")
+		}
+		header, err := keys.ReadHeader(*f.file)
+		if err != nil {
+			fatalf(CryptoError, "ERROR: %v", err)
+		}
+		if header == nil {
+			fmt.Println("Legacy encrypted file (no key header) - key must be supplied explicitly")
+			return
+		}
+		fmt.Printf("Format Version: %d\n", header.Version)
+		fmt.Printf("Algorithm: %s\n", header.Algorithm)
+		if header.ChunkSize > 0 {
+			fmt.Printf("Chunk Size: %d bytes\n", header.ChunkSize)
+		}
+		fmt.Printf("Key ID: %s\n", header.KeyID)
+		fmt.Printf("Encrypted: %s\n", header.Created.Format(time.RFC3339))
+		if header.PKCS11Key != "" {
+			fmt.Printf("Envelope: PKCS#11 token %q (wrapping key %s, module %s)\n", header.PKCS11Token, header.PKCS11Key, header.PKCS11Module)
+		} else if header.WrappedKey != "" {
+			fmt.Printf("Envelope: KMS %s (master key %s)\n", header.KMSEndpoint, header.KMSKeyID)
+		}
+
+	case "store-keychain":
+		if *f.keyFile == "" {
+			fatalf(UsageError, "ERROR: -key is required for store-keychain")
+		}
+		key, err := keys.ReadKeyFile(*f.keyFile)
+		if err != nil {
+			fatalf(CryptoError, "ERROR: %v", err)
+		}
+		keychain := &keys.KeychainSource{Service: cfg.Keys.KeychainService}
+		if err := keychain.Store(key); err != nil {
+			fatalf(CryptoError, "ERROR: %v", err)
+		}
+		fmt.Printf("Stored key %s in OS keychain (service %s)\n", key.ID, cfg.Keys.KeychainService)
+		fmt.Println("You may now securely delete the key file.")
+
+	default:
+		showKeysHelp()
+		fatalf(UsageError, "Unknown keys action: %s", action)
+	}
+}
+
+// keySourceFromConfig builds the key lookup chain for a configuration: the configured
+// explicit key or key file first, then the env var, keyring and OS keychain, and the
+// configured providers for envelope-encrypted files
+func keySourceFromConfig(cfg *config.Config) (keys.Source, error) {
+	var explicit []*keys.Key
+	if cfg.Database.EncryptionKey != "" {
+		key, err := keys.FromHex(cfg.Database.EncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption_key: %w", err)
+		}
+		explicit = append(explicit, key)
+	}
+	if cfg.Database.EncryptionKeyFile != "" {
+		key, err := keys.ReadKeyFile(cfg.Database.EncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		explicit = append(explicit, key)
+	}
+	return defaultKeySources(cfg, explicit...), nil
+}
+
+// defaultKeySources is the lookup chain for decrypting files: the explicit keys first, then the
+// env var, keyring and OS keychain named in cfg's keys section, and the providers configured
+// there. A config without a keys section (none was given) uses the standard names.
+func defaultKeySources(cfg *config.Config, explicit ...*keys.Key) keys.Chain {
+	envVar, keyringDir, keychainService := cfg.Keys.EnvVar, cfg.Keys.KeyringDir, cfg.Keys.KeychainService
+	if envVar == "" {
+		envVar = keys.DefaultEnvVar
+	}
+	if keyringDir == "" {
+		keyringDir = keys.DefaultKeyringDir
+	}
+	if keychainService == "" {
+		keychainService = keys.DefaultKeychainService
+	}
+
+	var chain keys.Chain
+	if len(explicit) > 0 {
+		chain = append(chain, &keys.StaticSource{Keys: explicit})
+	}
+	chain = append(chain,
+		&keys.EnvSource{Var: envVar},
+		&keys.Keyring{Dir: keyringDir},
+		&keys.KeychainSource{Service: keychainService},
+	)
+	return withKeyProviders(chain, cfg)
+}
+
+// withKeyProviders adds the kms and pkcs11 providers configured in the keys section to chain.
+// They alone unwrap the data keys of envelope-encrypted files: a file header only picks one.
+func withKeyProviders(chain keys.Chain, cfg *config.Config) keys.Chain {
+	var providers []keys.Provider
+	if cfg.Keys.PKCS11Module != "" {
+		provider, _ := newKeyProvider(cfg.Keys, "pkcs11")
+		providers = append(providers, provider)
+	}
+	if cfg.Keys.KMSEndpoint != "" {
+		provider, _ := newKeyProvider(cfg.Keys, "kms")
+		providers = append(providers, provider)
+	}
+	if len(providers) == 0 {
+		return chain
+	}
+	return append(chain, &keys.ProviderSource{Providers: providers})
+}
+
+// resolveEncryptionKey selects how a new file is encrypted for the given key source.
+// It returns the key file that must be written next to the output, if any.
+func resolveEncryptionKey(cfg *config.Config, source, keyHex, outputFile string) (keys.EncryptOptions, string, error) {
+	switch source {
+	case "", "file":
+		if keyHex != "" {
+			key, err := keys.FromHex(keyHex)
+			if err != nil {
+				return keys.EncryptOptions{}, "", err
+			}
+			return keys.EncryptOptions{Key: key}, "", nil
+		}
+		key, err := keys.Generate()
+		if err != nil {
+			return keys.EncryptOptions{}, "", err
+		}
+		return keys.EncryptOptions{Key: key}, generateKeyFileName(outputFile), nil
+
+	case "env":
+		key, err := (&keys.EnvSource{Var: cfg.Keys.EnvVar}).Lookup("")
+		if err != nil {
+			return keys.EncryptOptions{}, "", fmt.Errorf("no key in environment variable %s: %w", cfg.Keys.EnvVar, err)
+		}
+		return keys.EncryptOptions{Key: key}, "", nil
+
+	case "keyring":
+		keyring := &keys.Keyring{Dir: cfg.Keys.KeyringDir}
+		key, rotated, err := keyring.ActiveOrRotate(cfg.Keys.MaxAge)
+		if err != nil {
+			return keys.EncryptOptions{}, "", err
+		}
+		if rotated {
+			fmt.Printf("   Active key missing or older than %s - rotated to %s\n", cfg.Keys.MaxAge, key.ID)
+		}
+		return keys.EncryptOptions{Key: key}, "", nil
+
+	case "keychain":
+		key, err := keys.Generate()
+		if err != nil {
+			return keys.EncryptOptions{}, "", err
+		}
+		if err := (&keys.KeychainSource{Service: cfg.Keys.KeychainService}).Store(key); err != nil {
+			return keys.EncryptOptions{}, "", err
+		}
+		return keys.EncryptOptions{Key: key}, "", nil
+
+	case "kms", "pkcs11":
+		provider, err := newKeyProvider(cfg.Keys, source)
+		if err != nil {
+			return keys.EncryptOptions{}, "", err
+		}
+		if source == "pkcs11" && cfg.Keys.PKCS11Key == "" {
+			return keys.EncryptOptions{}, "", fmt.Errorf("keys.pkcs11_key must name the wrapping key for the pkcs11 key source")
+		}
+		return keys.EncryptOptions{Provider: provider}, "", nil
+
+	default:
+		return keys.EncryptOptions{}, "", fmt.Errorf("unknown key source %q (expected file, env, keyring, keychain, kms or pkcs11)", source)
+	}
+}
+
+// newKeyProvider creates the kms or pkcs11 provider configured in the keys section
+func newKeyProvider(keyConfig config.KeysConfig, name string) (keys.Provider, error) {
+	switch name {
+	case "kms":
+		if keyConfig.KMSEndpoint == "" {
+			return nil, fmt.Errorf("keys.kms_endpoint must be set for the kms provider")
+		}
+		client := keys.NewKMSClient(keyConfig.KMSEndpoint, keyConfig.KMSKeyID)
+		client.MACKeyID = keyConfig.KMSHMACKeyID
+		return client, nil
+	case "pkcs11":
+		if keyConfig.PKCS11Module == "" {
+			return nil, fmt.Errorf("keys.pkcs11_module must be set for the pkcs11 provider")
+		}
+		return &keys.PKCS11Provider{
+			Module:  keyConfig.PKCS11Module,
+			Token:   keyConfig.PKCS11Token,
+			Key:     keyConfig.PKCS11Key,
+			HMACKey: keyConfig.PKCS11HMACKey,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown key provider %q (expected kms or pkcs11)", name)
+}
+
+func showKeysHelp() {
+	fmt.Println("CohortBridge Key Management")
+	fmt.Println("===========================")
+	fmt.Println()
+	fmt.Println("Manage the AES-256 keys used to encrypt tokenized files.")
+	fmt.Println("Encrypted files record the ID of their key in a header, so the")
+	fmt.Println("right key is found automatically in the env var, keyring or keychain.")
+	fmt.Println()
+	fmt.Println("USAGE:")
+	fmt.Println("  cohort-bridge keys <action> [OPTIONS]")
+	fmt.Println()
+	fmt.Println("ACTIONS:")
+	fmt.Println("  list             List keys in the keyring and their age")
+	fmt.Println("  rotate           Generate a new active key (old keys are kept)")
+	fmt.Println("  prune            Delete retired keys older than -older-than")
+	fmt.Println("  inspect          Show the key header of an encrypted file (-file)")
+	fmt.Println("  store-keychain   Move a .key file into the OS keychain (-key)")
+	fmt.Println("  signing-keygen   Create an Ed25519 key signing intersection results (-out)")
+	fmt.Println("  signing-pubkey   Print the public key of a signing key, to pin at the peer (-key)")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Println("  -config string        Configuration file (default: config.yaml)")
+	fmt.Println("  -file string          Encrypted file to inspect")
+	fmt.Println("  -key string           Key file to store in the OS keychain, or signing key for signing-pubkey")
+	fmt.Println("  -out string           Signing key file to create (default: signing.pem)")
+	fmt.Println("  -older-than duration  Age threshold for prune (e.g. 8760h)")
+	fmt.Println("  -force                Skip confirmation prompts")
+	fmt.Println()
+	fmt.Println("CONFIGURATION:")
+	fmt.Println("  keys:")
+	fmt.Println("    source: keyring          # file, env, keyring, keychain, kms, pkcs11")
+	fmt.Println("    keyring_dir: keys")
+	fmt.Println("    max_age: 2160h           # rotate the active key after 90 days")
+	fmt.Println("    kms_endpoint: https://kms.example.org/v1")
+	fmt.Println("    kms_key_id: cohort-master")
+	fmt.Println()
+	fmt.Println("HARDWARE SECURITY MODULES:")
+	fmt.Println("  With source: pkcs11, each file's data key is wrapped by an AES key that")
+	fmt.Println("  never leaves the token, and decryption unwraps it there again. Setting")
+	fmt.Println("  tokenization.linkage_secret_provider to pkcs11 (or kms) derives the linkage")
+	fmt.Println("  secret from an HMAC key in the token instead of reading a secret file.")
+	fmt.Println("  Operations run through OpenSC's pkcs11-tool; the token PIN is read from")
+	fmt.Println("  COHORT_PKCS11_PIN.")
+	fmt.Println("  keys:")
+	fmt.Println("    source: pkcs11")
+	fmt.Println("    pkcs11_module: /usr/lib/softhsm/libsofthsm2.so")
+	fmt.Println("    pkcs11_token: cohort-bridge")
+	fmt.Println("    pkcs11_key: cohort-wrap       # AES key wrapping data keys")
+	fmt.Println("    pkcs11_hmac_key: cohort-link  # HMAC key deriving the linkage secret")
+}
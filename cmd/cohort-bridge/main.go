@@ -3,35 +3,51 @@ package main
 import (
 	"fmt"
 	"os"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/store"
 )
 
 func main() {
+	// -yes, -non-interactive, -log-format and -porcelain apply to every subcommand, before or after its name
+	argv := parseGlobalFlags(os.Args[1:])
+
 	// Handle command line arguments
-	if len(os.Args) > 1 {
+	if len(argv) > 0 {
 		// Handle subcommands
-		subcommand := os.Args[1]
-		args := os.Args[2:]
+		subcommand := argv[0]
+		args := argv[1:]
 
+		if cmd := findCommand(subcommand); cmd != nil {
+			currentCommand = cmd.name
+			if porcelainFormat != "" {
+				if !cmd.porcelain {
+					fatalf(UsageError, "Error: -porcelain is supported by tokenize, intersect and validate, not %s", cmd.name)
+				}
+				startPorcelain(cmd.name)
+			}
+			cmd.run(args)
+			porcelain.finish()
+			return
+		}
 		switch subcommand {
-		case "tokenize":
-			runTokenizeCommand(args)
-		case "decrypt":
-			runDecryptCommand(args)
-		case "intersect":
-			runIntersectCommand(args)
-		case "validate":
-			runValidateCommand(args)
-		case "pprl":
-			runPPRLCommand(args)
-
 		case "-help", "--help", "help", "-h":
+			// help <subcommand> shows the subcommand's help
+			if len(args) > 0 {
+				if cmd := findCommand(args[0]); cmd != nil {
+					cmd.help()
+					return
+				}
+				showMainHelp()
+				fatalf(UsageError, "Unknown subcommand: %s", args[0])
+			}
 			showMainHelp()
 		case "-version", "--version", "version", "-v":
 			showVersion()
 		default:
-			fmt.Printf("Unknown subcommand: %s\n\n", subcommand)
 			showMainHelp()
-			os.Exit(1)
+			fatalf(UsageError, "Unknown subcommand: %s", subcommand)
 		}
 		return
 	}
@@ -41,6 +57,11 @@ func main() {
 }
 
 func runInteractiveMode() {
+	if !canPrompt() {
+		showMainHelp()
+		fatalf(UsageError, "ERROR: no subcommand given, and interactive mode needs a terminal")
+	}
+
 	// Print banner
 	fmt.Println("CohortBridge - PPRL Orchestrator")
 	fmt.Println("=================================")
@@ -48,32 +69,24 @@ func runInteractiveMode() {
 	fmt.Println()
 	fmt.Println("Interactive Mode")
 
-	options := []string{
-		"Tokenize - Convert PHI data to privacy-preserving tokens",
-		"Decrypt - Decrypt encrypted tokenized files",
-		"Intersect - Find matches between tokenized datasets",
-		"Validate - Test results against ground truth",
-		"PPRL - Peer-to-peer privacy-preserving record linkage",
-		"Help - Show detailed help information",
-		"Exit",
+	var menu []*command
+	var options []string
+	for i := range commands {
+		if commands[i].menu != "" {
+			menu = append(menu, &commands[i])
+			options = append(options, commands[i].menu)
+		}
 	}
+	options = append(options, "Help - Show detailed help information", "Exit")
 
 	choice := promptForChoice("Choose what you'd like to do:", options)
 
-	switch choice {
-	case 0: // Tokenize
-		runTokenizeCommand([]string{"-interactive"})
-	case 1: // Decrypt
-		runDecryptCommand([]string{"-interactive"})
-	case 2: // Intersect
-		runIntersectCommand([]string{"-interactive"})
-	case 3: // Validate
-		runValidateCommand([]string{"-interactive"})
-	case 4: // PPRL
-		runPPRLCommand([]string{"-interactive"})
-	case 5: // Help
+	switch {
+	case choice < len(menu):
+		menu[choice].run([]string{"-interactive"})
+	case choice == len(menu):
 		showMainHelp()
-	case 6: // Exit
+	default:
 		fmt.Println("Goodbye!")
 		os.Exit(0)
 	}
@@ -88,21 +101,35 @@ func showMainHelp() {
 	fmt.Println("USAGE:")
 	fmt.Println("  cohort-bridge                     # Interactive mode")
 	fmt.Println("  cohort-bridge <subcommand>        # Direct subcommand")
-	fmt.Println("  cohort-bridge -mode=<mode>        # Legacy mode")
+	fmt.Println("  cohort-bridge help <subcommand>   # Subcommand help")
 	fmt.Println()
 	fmt.Println("SUBCOMMANDS:")
-	fmt.Println("  tokenize    Convert PHI data to privacy-preserving tokens")
-	fmt.Println("  decrypt     Decrypt encrypted tokenized files")
-	fmt.Println("  intersect   Find matches between tokenized datasets")
-	fmt.Println("  send        Network operations for secure communication")
-	fmt.Println("  validate    Test results against ground truth")
-	fmt.Println("  pprl        Peer-to-peer privacy-preserving record linkage")
-	fmt.Println("  workflows   Orchestrate complex PPRL operations")
-	fmt.Println()
+	width := 0
+	for _, cmd := range commands {
+		width = max(width, len(cmd.name))
+	}
+	for _, cmd := range commands {
+		fmt.Printf("  %-*s  %s\n", width, cmd.name, cmd.summary)
+	}
 	fmt.Println()
 	fmt.Println("GLOBAL OPTIONS:")
 	fmt.Println("  -help, --help    Show this help message")
 	fmt.Println("  -version         Show version information")
+	fmt.Println("  -yes             Answer confirmation prompts with yes and fail instead of")
+	fmt.Println("                   prompting for anything else (alias -non-interactive)")
+	fmt.Println("  -log-format fmt  Error output: text (default) or json, one object on stderr")
+	fmt.Println("                   with the error's category and exit code")
+	fmt.Println("  -porcelain[=fmt] tokenize, intersect and validate: print only a stable summary")
+	fmt.Println("                   to stdout, as key=value lines (kv, default) or one JSON object")
+	fmt.Println("                   (json); the usual output goes to stderr")
+	fmt.Println()
+	fmt.Println("  Without a terminal on stdin (cron, CI, pipes) nothing is prompted for:")
+	fmt.Println("  commands missing a required flag exit and name it.")
+	fmt.Println()
+	fmt.Println("EXIT CODES:")
+	fmt.Println("  0  success          3  configuration error   5  peer or network error")
+	fmt.Println("  1  other failure    4  data error            6  key or crypto error")
+	fmt.Println("  2  usage error (bad or missing flags)")
 	fmt.Println()
 	fmt.Println("EXAMPLES:")
 	fmt.Println("  # Interactive mode")
@@ -114,13 +141,64 @@ func showMainHelp() {
 	fmt.Println("  cohort-bridge intersect -dataset1 tokens1.csv -dataset2 tokens2.csv")
 	fmt.Println("  cohort-bridge validate -config1 config_a.yaml -config2 config_b.yaml")
 	fmt.Println()
-	fmt.Println("  # Legacy mode")
-	fmt.Println("  cohort-bridge -mode=sender -config=config.yaml")
+	fmt.Println("  # Scripted runs")
+	fmt.Println("  cohort-bridge -yes intersect -dataset1 tokens1.csv -dataset2 tokens2.csv")
+	fmt.Println("  cohort-bridge -yes -porcelain=json intersect -dataset1 tokens1.csv -dataset2 tokens2.csv")
 	fmt.Println()
 	fmt.Println("For detailed help on any subcommand, use:")
-	fmt.Println("  cohort-bridge <subcommand> -help")
+	fmt.Println("  cohort-bridge help <subcommand>   (or cohort-bridge <subcommand> -help)")
+}
+
+// softwareVersion is the release reported by -version and to peers
+const softwareVersion = "v0.1.0"
+
+// Set at build time with -ldflags "-X main.gitCommit=... -X main.buildTime=..." (see the Makefile);
+// without them the commit is read from the VCS information the Go toolchain embeds
+var (
+	gitCommit string
+	buildTime string
+)
+
+// buildInfo identifies this build in run records and manifests
+func buildInfo() *store.BuildInfo {
+	info := &store.BuildInfo{
+		Version:   softwareVersion,
+		GitCommit: gitCommit,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if info.GitCommit == "" {
+		if build, ok := debug.ReadBuildInfo(); ok {
+			var modified bool
+			for _, setting := range build.Settings {
+				switch setting.Key {
+				case "vcs.revision":
+					info.GitCommit = setting.Value
+				case "vcs.modified":
+					modified = setting.Value == "true"
+				case "vcs.time":
+					if info.BuildTime == "" {
+						info.BuildTime = setting.Value
+					}
+				}
+			}
+			if modified && info.GitCommit != "" {
+				info.GitCommit += "-dirty"
+			}
+		}
+	}
+	return info
 }
 
 func showVersion() {
-	fmt.Println("CohortBridge v0.1.0")
+	fmt.Println("CohortBridge " + softwareVersion)
+	info := buildInfo()
+	if info.GitCommit != "" {
+		fmt.Println("Commit: " + info.GitCommit)
+	}
+	if info.BuildTime != "" {
+		fmt.Println("Built: " + info.BuildTime)
+	}
+	fmt.Printf("Go: %s %s\n", info.GoVersion, info.Platform)
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/memlimit"
+	"github.com/auroradata-ai/cohort-bridge/internal/store"
+)
+
+// startMemoryWatchdog parses a -max-memory value and starts its watchdog, exiting on an invalid
+// size; an empty value has no limit and returns nil
+func startMemoryWatchdog(value string) *memlimit.Watchdog {
+	if value == "" {
+		return nil
+	}
+	limit, err := memlimit.ParseSize(value)
+	if err != nil {
+		fatalf(UsageError, "ERROR: -max-memory: %v", err)
+	}
+	return memlimit.Start(limit)
+}
+
+// recordMemory adds the memory limit and the peak heap of a run to its record
+func recordMemory(run *store.Run, memory *memlimit.Watchdog) {
+	if memory == nil {
+		return
+	}
+	run.Parameters["max_memory"] = memlimit.FormatSize(memory.Limit())
+	run.Counts["peak_memory_mib"] = int(memory.Peak() >> 20)
+}
+
+// estimateTokenMemory estimates the heap a tokenized dataset takes once loaded: decoded filters,
+// signatures and IDs come to about twice the file, and gzip saves about three quarters of it
+func estimateTokenMemory(path string) uint64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	estimate := 2 * uint64(info.Size())
+	if strings.HasSuffix(strings.ToLower(strings.TrimSuffix(path, ".enc")), ".gz") {
+		estimate *= 4
+	}
+	return estimate
+}
+
+// printMemoryGuidance explains how to get a run that stopped at -max-memory through; other
+// errors print nothing
+func printMemoryGuidance(err error, hints ...string) {
+	var limitErr *memlimit.LimitError
+	if !errors.As(err, &limitErr) {
+		return
+	}
+	fmt.Println()
+	fmt.Printf("The run stopped before exceeding -max-memory %s rather than risk being killed.\n", memlimit.FormatSize(limitErr.Limit))
+	fmt.Println("To get it through:")
+	for _, hint := range hints {
+		fmt.Printf("  - %s\n", hint)
+	}
+	fmt.Println("  - raise -max-memory, if the machine has the memory to spare")
+}
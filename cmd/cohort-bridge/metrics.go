@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/stats"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/metrics"
+	"github.com/auroradata-ai/cohort-bridge/internal/store"
+)
+
+// startMetricsListener serves /metrics on metrics.listen, if set, for the life of the process
+func startMetricsListener(cfg *config.Config) {
+	if cfg.Metrics.Listen == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Default.Handler())
+	metricsServer := &http.Server{
+		Addr:              cfg.Metrics.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("Warning: metrics listener on %s failed: %v\n", cfg.Metrics.Listen, err)
+		}
+	}()
+	fmt.Printf("Serving Prometheus metrics on %s/metrics\n", cfg.Metrics.Listen)
+}
+
+// observeRun adds a finished run to the metrics
+func observeRun(run *store.Run) {
+	seconds := run.Duration().Seconds()
+	metrics.RunDuration.Observe(seconds, run.Command, run.Status)
+	if run.Status == store.StatusFailed {
+		metrics.Errors.Inc(run.Command, "run_failed")
+		return
+	}
+
+	switch run.Command {
+	case "tokenize", "pprl":
+		metrics.RecordsTokenized.Add(float64(run.Counts["records"]), run.Command)
+	}
+	switch run.Command {
+	case "intersect", "pprl", "serve":
+		pairs := comparedPairs(run)
+		metrics.Comparisons.Add(pairs, run.Command)
+		if seconds > 0 {
+			metrics.ComparisonRate.Set(pairs/seconds, run.Command)
+		}
+		metrics.MatchesFound.Add(float64(run.Counts["matches"]), run.Command)
+	}
+}
+
+// comparedPairs is the number of record pairs an intersection considered: those sharing an LSH
+// band when streaming, otherwise every local (and decoy) record against every peer record
+func comparedPairs(run *store.Run) float64 {
+	if compared, ok := run.Counts["compared_pairs"]; ok {
+		return float64(compared)
+	}
+	if _, ok := run.Counts["dataset1_records"]; ok {
+		return float64(run.Counts["dataset1_records"]) * float64(run.Counts["dataset2_records"])
+	}
+	return float64(run.Counts["local_records"]+run.Counts["decoy_records"]) * float64(run.Counts["peer_records"])
+}
+
+// countExchangeFrame counts the bytes of each frame of the tcp peer transport
+func countExchangeFrame(sent bool, size int) {
+	metrics.ExchangeBytes.Add(float64(size), "tcp", exchangeDirection(sent))
+}
+
+func exchangeDirection(sent bool) string {
+	if sent {
+		return "sent"
+	}
+	return "received"
+}
+
+// exchangeStats counts the wire bytes of gRPC peer messages
+type exchangeStats struct{}
+
+func (exchangeStats) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (exchangeStats) HandleRPC(_ context.Context, s stats.RPCStats) {
+	switch payload := s.(type) {
+	case *stats.InPayload:
+		metrics.ExchangeBytes.Add(float64(payload.WireLength), "grpc", "received")
+	case *stats.OutPayload:
+		metrics.ExchangeBytes.Add(float64(payload.WireLength), "grpc", "sent")
+	}
+}
+
+func (exchangeStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (exchangeStats) HandleConn(context.Context, stats.ConnStats) {}
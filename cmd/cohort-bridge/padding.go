@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+)
+
+// paddingCount returns how many decoys to add: peer.padding_records plus a random
+// number up to peer.padding_jitter, so the padded size does not reveal the real one
+func paddingCount(cfg *config.Config, rng *rand.Rand) int {
+	count := cfg.Peer.PaddingRecords
+	if cfg.Peer.PaddingJitter > 0 {
+		count += rng.IntN(cfg.Peer.PaddingJitter + 1)
+	}
+	return count
+}
+
+// padTokenData adds decoy records to tokens before they are sent to the peer. Each decoy copies
+// the number of set bits, the MinHash parameters and the blocking keys of a random real record and
+// gets an ID shaped like a real one. The returned decoy IDs stay local; use removeDecoyMatches before saving results.
+func padTokenData(tokens *TokenData, count int, rng *rand.Rand) (map[string]bool, error) {
+	decoys := make(map[string]bool, count)
+	if count <= 0 || len(tokens.Records) == 0 {
+		return decoys, nil
+	}
+
+	// Sort the real IDs so template selection depends only on rng
+	ids := make([]string, 0, len(tokens.Records))
+	for id := range tokens.Records {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for i := 0; i < count; i++ {
+		template := tokens.Records[ids[rng.IntN(len(ids))]]
+
+		bf, err := pprl.BloomFromBase64(template.BloomFilter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode Bloom filter for %s: %v", template.ID, err)
+		}
+		decoyFilter, err := pprl.NewDecoyBloomFilter(bf, rng)
+		if err != nil {
+			return nil, err
+		}
+		bloomEncoded, err := pprl.BloomToBase64(decoyFilter)
+		if err != nil {
+			return nil, err
+		}
+
+		// The signature must be consistent with the decoy filter under the shared MinHash parameters
+		mh, err := pprl.MinHashFromBase64(template.MinHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode MinHash for %s: %v", template.ID, err)
+		}
+		if _, err := mh.ComputeSignature(decoyFilter); err != nil {
+			return nil, err
+		}
+		minHashEncoded, err := mh.ToBase64()
+		if err != nil {
+			return nil, err
+		}
+
+		// Lengthen the ID on collision, in case the real IDs leave little room
+		id := decoyID(template.ID, rng)
+		for _, exists := tokens.Records[id]; exists; _, exists = tokens.Records[id] {
+			id = decoyID(id+"0", rng)
+		}
+		tokens.Records[id] = TokenRecord{ID: id, BloomFilter: bloomEncoded, MinHash: minHashEncoded, Blocking: template.Blocking}
+		decoys[id] = true
+	}
+	return decoys, nil
+}
+
+// decoyID returns an ID shaped like template: digits and letters are replaced by random ones of
+// the same kind and case, other characters are kept
+func decoyID(template string, rng *rand.Rand) string {
+	id := []byte(template)
+	changed := false
+	for i, c := range id {
+		switch {
+		case c >= '0' && c <= '9':
+			id[i] = '0' + byte(rng.IntN(10))
+		case c >= 'a' && c <= 'z':
+			id[i] = 'a' + byte(rng.IntN(26))
+		case c >= 'A' && c <= 'Z':
+			id[i] = 'A' + byte(rng.IntN(26))
+		default:
+			continue
+		}
+		changed = true
+	}
+	if !changed {
+		return fmt.Sprintf("%s%08x", template, rng.Uint32())
+	}
+	return string(id)
+}
+
+// removeDecoyMatches drops matches involving local decoys and returns how many were removed
+func removeDecoyMatches(intersection *IntersectionResult, decoys map[string]bool) int {
+	if len(decoys) == 0 {
+		return 0
+	}
+	kept := intersection.Matches[:0]
+	for _, m := range intersection.Matches {
+		if !decoys[m.LocalID] {
+			kept = append(kept, m)
+		}
+	}
+	removed := len(intersection.Matches) - len(kept)
+	intersection.Matches = kept
+	return removed
+}
+
+// padLocalTokens pads tokens with the decoys configured under peer.padding_records/padding_jitter
+func padLocalTokens(tokens *TokenData, cfg *config.Config) (map[string]bool, error) {
+	if cfg.Peer.PaddingRecords < 0 || cfg.Peer.PaddingJitter < 0 {
+		return nil, fmt.Errorf("peer.padding_records and peer.padding_jitter must not be negative")
+	}
+	if cfg.Peer.PaddingRecords == 0 && cfg.Peer.PaddingJitter == 0 {
+		return nil, nil
+	}
+	rng, err := pprl.NewDecoyRNG()
+	if err != nil {
+		return nil, err
+	}
+	return padTokenData(tokens, paddingCount(cfg, rng), rng)
+}
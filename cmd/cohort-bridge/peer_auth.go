@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/relay"
+	"github.com/auroradata-ai/cohort-bridge/internal/server"
+)
+
+const (
+	// peerAPIKeyEnvVar supplies the pre-shared peer API key when the config does not
+	peerAPIKeyEnvVar = "COHORT_PEER_API_KEY"
+
+	// minPeerAPIKeyLen is the shortest accepted pre-shared key, in bytes
+	minPeerAPIKeyLen = 16
+
+	// peerAuthKey is the gRPC metadata key carrying a request proof, and the server's answer
+	peerAuthKey = "cohort-bridge-peer-auth"
+
+	peerAuthNonceSize = 32
+	peerAuthMaxSkew   = 5 * time.Minute // Oldest accepted request proof; also how long nonces are remembered
+)
+
+// peerAuth authenticates the peer with a pre-shared API key, an mTLS identity allowlist, or both.
+// The key is never sent: each side proves it holds the key with an HMAC over fresh nonces.
+type peerAuth struct {
+	apiKey  []byte
+	allowed []string     // Accepted certificate identities: CN, DNS/URI SAN or sha256:<fingerprint>
+	ips     []*net.IPNet // security.allowed_ips: networks a listening peer accepts connections from
+
+	mu     sync.Mutex
+	nonces map[string]time.Time // Request nonces seen within peerAuthMaxSkew, to reject replays
+}
+
+// newPeerAuth loads peer.api_key, peer.api_key_file or COHORT_PEER_API_KEY and peer.allowed_peers.
+// It returns nil when neither is configured.
+func newPeerAuth(cfg *config.Config) (*peerAuth, error) {
+	apiKey := cfg.Peer.APIKey
+	if cfg.Peer.APIKeyFile != "" {
+		data, err := os.ReadFile(cfg.Peer.APIKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read peer.api_key_file: %v", err)
+		}
+		apiKey = strings.TrimSpace(string(data))
+	}
+	if apiKey == "" {
+		apiKey = strings.TrimSpace(os.Getenv(peerAPIKeyEnvVar))
+	}
+	if apiKey != "" && len(apiKey) < minPeerAPIKeyLen {
+		return nil, fmt.Errorf("peer API key must be at least %d bytes, got %d", minPeerAPIKeyLen, len(apiKey))
+	}
+
+	if len(cfg.Peer.AllowedPeers) > 0 {
+		if strings.ToLower(cfg.Peer.Transport) == "tcp" {
+			return nil, fmt.Errorf("peer.allowed_peers needs mTLS, which the tcp transport does not support")
+		}
+		if cfg.Peer.TLSCertFile == "" || cfg.Peer.TLSKeyFile == "" || cfg.Peer.TLSCAFile == "" {
+			return nil, fmt.Errorf("peer.allowed_peers needs peer.tls_cert_file, tls_key_file and tls_ca_file")
+		}
+	}
+
+	ips, err := server.ParseAllowedIPs(cfg.Security.AllowedIPs)
+	if err != nil {
+		return nil, err
+	}
+
+	if apiKey == "" && len(cfg.Peer.AllowedPeers) == 0 && len(ips) == 0 {
+		return nil, nil
+	}
+	return &peerAuth{
+		apiKey:  []byte(apiKey),
+		allowed: cfg.Peer.AllowedPeers,
+		ips:     ips,
+		nonces:  make(map[string]time.Time),
+	}, nil
+}
+
+// methods describes the configured authentication for logs and the run registry
+func (a *peerAuth) methods() string {
+	if a == nil {
+		return "none"
+	}
+	var methods []string
+	if len(a.apiKey) > 0 {
+		methods = append(methods, "api-key")
+	}
+	if len(a.allowed) > 0 {
+		methods = append(methods, "mtls")
+	}
+	if len(a.ips) > 0 {
+		methods = append(methods, "ip-allowlist")
+	}
+	return strings.Join(methods, "+")
+}
+
+// auditPeerAuthFailure records a rejected peer in the audit log
+func auditPeerAuthFailure(remote net.Addr, transport, reason string) {
+	address := "unknown"
+	if remote != nil {
+		address = remote.String()
+	}
+	server.Audit("peer_auth_failed", map[string]interface{}{"remote": address, "transport": transport, "reason": reason})
+}
+
+// allowedAddr reports whether a connecting address is in security.allowed_ips
+func (a *peerAuth) allowedAddr(remote net.Addr) bool {
+	if a == nil || len(a.ips) == 0 {
+		return true
+	}
+	if _, ok := remote.(relay.Addr); ok {
+		return true // A relayed peer connected to the relay, not to this party
+	}
+	tcpAddr, ok := remote.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range a.ips {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// proof is the HMAC a party computes to show it holds the API key
+func (a *peerAuth) proof(role string, parts ...[]byte) []byte {
+	mac := hmac.New(sha256.New, a.apiKey)
+	mac.Write([]byte("cohort-bridge-peer-auth\x00" + role + "\x00"))
+	for _, part := range parts {
+		mac.Write(part)
+	}
+	return mac.Sum(nil)
+}
+
+// authenticateConn runs the API key challenge on a fresh tcp transport connection: both sides
+// send a nonce, then an HMAC over both nonces bound to their role.
+func (a *peerAuth) authenticateConn(conn net.Conn, isServer bool, timeout time.Duration) error {
+	if a == nil || len(a.apiKey) == 0 {
+		return nil
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	localRole, peerRole := "client", "server"
+	if isServer {
+		localRole, peerRole = "server", "client"
+	}
+
+	localNonce := make([]byte, peerAuthNonceSize)
+	if _, err := rand.Read(localNonce); err != nil {
+		return err
+	}
+	if _, err := conn.Write(localNonce); err != nil {
+		return fmt.Errorf("failed to send auth challenge: %v", err)
+	}
+	peerNonce := make([]byte, peerAuthNonceSize)
+	if _, err := io.ReadFull(conn, peerNonce); err != nil {
+		return fmt.Errorf("failed to read auth challenge: %v", err)
+	}
+
+	if _, err := conn.Write(a.proof(localRole, localNonce, peerNonce)); err != nil {
+		return fmt.Errorf("failed to send auth response: %v", err)
+	}
+	peerProof := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(conn, peerProof); err != nil {
+		return fmt.Errorf("failed to read auth response: %v", err)
+	}
+	if !hmac.Equal(peerProof, a.proof(peerRole, peerNonce, localNonce)) {
+		return fmt.Errorf("peer does not hold the API key")
+	}
+	return nil
+}
+
+// requestProof returns the metadata value proving the API key for one gRPC call: a timestamp,
+// a nonce and their HMAC bound to the method. The nonce is returned to check the server's answer.
+func (a *peerAuth) requestProof(method string) (string, []byte, error) {
+	nonce := make([]byte, 8+peerAuthNonceSize)
+	binary.BigEndian.PutUint64(nonce, uint64(time.Now().Unix()))
+	if _, err := rand.Read(nonce[8:]); err != nil {
+		return "", nil, err
+	}
+	value := append(nonce, a.proof("client", nonce, []byte(method))...)
+	return base64.RawStdEncoding.EncodeToString(value), nonce, nil
+}
+
+// verifyRequestProof checks a call's proof and returns the server's answer for the response header
+func (a *peerAuth) verifyRequestProof(value, method string) (string, error) {
+	data, err := base64.RawStdEncoding.DecodeString(value)
+	if err != nil || len(data) != 8+peerAuthNonceSize+sha256.Size {
+		return "", fmt.Errorf("malformed API key proof")
+	}
+	nonce, mac := data[:8+peerAuthNonceSize], data[8+peerAuthNonceSize:]
+	if !hmac.Equal(mac, a.proof("client", nonce, []byte(method))) {
+		return "", fmt.Errorf("peer does not hold the API key")
+	}
+
+	issued := time.Unix(int64(binary.BigEndian.Uint64(nonce)), 0)
+	if skew := time.Since(issued); skew > peerAuthMaxSkew || skew < -peerAuthMaxSkew {
+		return "", fmt.Errorf("API key proof is outside the %s clock window", peerAuthMaxSkew)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for seen, at := range a.nonces {
+		if time.Since(at) > 2*peerAuthMaxSkew {
+			delete(a.nonces, seen)
+		}
+	}
+	if _, replayed := a.nonces[string(nonce)]; replayed {
+		return "", fmt.Errorf("replayed API key proof")
+	}
+	a.nonces[string(nonce)] = time.Now()
+
+	return base64.RawStdEncoding.EncodeToString(a.proof("server", nonce)), nil
+}
+
+// verifyResponseProof checks the server's answer to a request proof
+func (a *peerAuth) verifyResponseProof(header metadata.MD, nonce []byte) error {
+	values := header.Get(peerAuthKey)
+	if len(values) == 0 {
+		return fmt.Errorf("peer did not prove it holds the API key")
+	}
+	answer, err := base64.RawStdEncoding.DecodeString(values[0])
+	if err != nil || !hmac.Equal(answer, a.proof("server", nonce)) {
+		return fmt.Errorf("peer does not hold the API key")
+	}
+	return nil
+}
+
+// checkUnary rejects calls from peers outside the allowlist or without a valid API key proof,
+// and answers valid proofs
+func (a *peerAuth) checkUnary(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	answer, err := a.checkIncoming(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	if answer != "" {
+		grpc.SetHeader(ctx, metadata.Pairs(peerAuthKey, answer))
+	}
+	return handler(ctx, request)
+}
+
+func (a *peerAuth) checkStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	answer, err := a.checkIncoming(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	if answer != "" {
+		stream.SetHeader(metadata.Pairs(peerAuthKey, answer))
+	}
+	return handler(srv, stream)
+}
+
+// checkIncoming checks the caller's certificate against the allowlist, then its API key proof.
+// Certificates are checked here rather than in the TLS handshake so the caller gets a clear error.
+func (a *peerAuth) checkIncoming(ctx context.Context, method string) (string, error) {
+	var remote net.Addr
+	var info credentials.AuthInfo
+	if p, ok := peer.FromContext(ctx); ok {
+		remote, info = p.Addr, p.AuthInfo
+	}
+	if err := a.checkIdentity(info); err != nil {
+		auditPeerAuthFailure(remote, "grpc", err.Error())
+		return "", status.Error(codes.PermissionDenied, err.Error())
+	}
+	if len(a.apiKey) == 0 {
+		return "", nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(peerAuthKey)
+	if len(values) == 0 {
+		auditPeerAuthFailure(remote, "grpc", "missing API key proof on "+method)
+		return "", status.Error(codes.Unauthenticated, "missing peer API key proof")
+	}
+	answer, err := a.verifyRequestProof(values[0], method)
+	if err != nil {
+		auditPeerAuthFailure(remote, "grpc", err.Error()+" on "+method)
+		return "", status.Error(codes.Unauthenticated, err.Error())
+	}
+	return answer, nil
+}
+
+// proveUnary attaches an API key proof to each call and checks the server's answer
+func (a *peerAuth) proveUnary(ctx context.Context, method string, request, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	value, nonce, err := a.requestProof(method)
+	if err != nil {
+		return err
+	}
+	var header metadata.MD
+	ctx = metadata.AppendToOutgoingContext(ctx, peerAuthKey, value)
+	if err := invoker(ctx, method, request, reply, cc, append(opts, grpc.Header(&header))...); err != nil {
+		return err
+	}
+	if err := a.verifyResponseProof(header, nonce); err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	return nil
+}
+
+func (a *peerAuth) proveStream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	value, nonce, err := a.requestProof(method)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := streamer(metadata.AppendToOutgoingContext(ctx, peerAuthKey, value), desc, cc, method, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &provenStream{ClientStream: stream, auth: a, nonce: nonce}, nil
+}
+
+// provenStream checks the server's answer when the first message arrives; the server only sends
+// its header once the client has sent its own first message
+type provenStream struct {
+	grpc.ClientStream
+	auth     *peerAuth
+	nonce    []byte
+	verified bool
+}
+
+func (s *provenStream) RecvMsg(m interface{}) error {
+	if !s.verified {
+		header, err := s.ClientStream.Header()
+		if err != nil {
+			return err
+		}
+		if err := s.auth.verifyResponseProof(header, s.nonce); err != nil {
+			// A call rejected before any message carries no answer; report the rejection instead
+			if recvErr := s.ClientStream.RecvMsg(m); recvErr != nil {
+				return recvErr
+			}
+			return status.Error(codes.Unauthenticated, err.Error())
+		}
+		s.verified = true
+	}
+	return s.ClientStream.RecvMsg(m)
+}
+
+// peerCredentials audits failed TLS handshakes and, when dialing, checks the peer's certificate
+// against peer.allowed_peers before any call is made
+type peerCredentials struct {
+	credentials.TransportCredentials
+	auth     *peerAuth
+	failures *handshakeFailures // Shared with clones
+}
+
+// handshakeFailures keeps the last failed client handshake, reported instead of falling back to serving
+type handshakeFailures struct {
+	mu   sync.Mutex
+	last error
+}
+
+// newPeerCredentials wraps creds with the peer.allowed_peers check
+func newPeerCredentials(creds credentials.TransportCredentials, auth *peerAuth) *peerCredentials {
+	return &peerCredentials{TransportCredentials: creds, auth: auth, failures: &handshakeFailures{}}
+}
+
+func (c *peerCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, info, err := c.TransportCredentials.ClientHandshake(ctx, authority, rawConn)
+	if err == nil {
+		if err = c.auth.checkIdentity(info); err != nil {
+			conn.Close()
+		}
+	}
+	if err != nil {
+		auditPeerAuthFailure(rawConn.RemoteAddr(), "grpc", err.Error())
+		c.failures.mu.Lock()
+		c.failures.last = err
+		c.failures.mu.Unlock()
+		return nil, nil, err
+	}
+	return conn, info, nil
+}
+
+func (c *peerCredentials) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	if !c.auth.allowedAddr(rawConn.RemoteAddr()) {
+		auditPeerAuthFailure(rawConn.RemoteAddr(), "grpc", "IP is not in security.allowed_ips")
+		return nil, nil, fmt.Errorf("connection from %s is not allowed", rawConn.RemoteAddr())
+	}
+	conn, info, err := c.TransportCredentials.ServerHandshake(rawConn)
+	if err != nil {
+		auditPeerAuthFailure(rawConn.RemoteAddr(), "grpc", "TLS handshake failed: "+err.Error())
+	}
+	return conn, info, err
+}
+
+func (c *peerCredentials) Clone() credentials.TransportCredentials {
+	return &peerCredentials{TransportCredentials: c.TransportCredentials.Clone(), auth: c.auth, failures: c.failures}
+}
+
+// rejected returns the error of the last failed client handshake, if any
+func (c *peerCredentials) rejected() error {
+	c.failures.mu.Lock()
+	defer c.failures.mu.Unlock()
+	return c.failures.last
+}
+
+// checkIdentity accepts a verified peer certificate that matches an allowlist entry
+func (a *peerAuth) checkIdentity(info credentials.AuthInfo) error {
+	if a == nil || len(a.allowed) == 0 {
+		return nil
+	}
+	tlsInfo, ok := info.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return fmt.Errorf("peer presented no certificate")
+	}
+	cert := tlsInfo.State.PeerCertificates[0]
+	for _, entry := range a.allowed {
+		if certificateMatches(cert, entry) {
+			return nil
+		}
+	}
+	return fmt.Errorf("peer certificate %q is not in peer.allowed_peers", cert.Subject.CommonName)
+}
+
+// certificateMatches reports whether entry names cert by common name, DNS or URI SAN, or
+// sha256:<hex> fingerprint of the DER encoding
+func certificateMatches(cert *x509.Certificate, entry string) bool {
+	if fingerprint, ok := strings.CutPrefix(entry, "sha256:"); ok {
+		sum := sha256.Sum256(cert.Raw)
+		return strings.EqualFold(strings.ReplaceAll(fingerprint, ":", ""), hex.EncodeToString(sum[:]))
+	}
+	if cert.Subject.CommonName == entry {
+		return true
+	}
+	for _, name := range cert.DNSNames {
+		if strings.EqualFold(name, entry) {
+			return true
+		}
+	}
+	for _, uri := range cert.URIs {
+		if uri.String() == entry {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,1105 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+	"github.com/auroradata-ai/cohort-bridge/internal/peerpb"
+	"github.com/auroradata-ai/cohort-bridge/internal/retry"
+	"github.com/auroradata-ai/cohort-bridge/internal/transfer"
+)
+
+const (
+	// peerProtocolVersion is the newest peer protocol this build speaks (proto/cohortbridge/peer/v1)
+	peerProtocolVersion = 1
+
+	// protocolVersionKey is the metadata key carrying the negotiated version on every exchange call
+	protocolVersionKey = "cohort-bridge-protocol-version"
+
+	grpcTokenBatchSize  = 1000     // Token records per ExchangeTokens stream message
+	grpcTokenStreamSeq  = 1        // Sequence of the token stream in the payload nonces; each direction seals one
+	grpcSecureSeq       = 2        // Sequence of secure comparison messages in the payload nonces, indexed by round
+	grpcMaxMessageSize  = 64 << 20 // Largest accepted gRPC message
+	grpcHealthcheckWait = 10 * time.Second
+	grpcMinPingInterval = 5 * time.Second // Keepalive pings accepted from the peer; gRPC clients ping at most every 10s
+)
+
+// supportedProtocolVersions lists the peer protocol versions this build speaks
+var supportedProtocolVersions = []uint32{peerProtocolVersion}
+
+// negotiateProtocolVersion returns the newest version both parties speak, or 0 if there is none
+func negotiateProtocolVersion(offered []uint32) uint32 {
+	var selected uint32
+	for _, version := range offered {
+		for _, supported := range supportedProtocolVersions {
+			if version == supported && version > selected {
+				selected = version
+			}
+		}
+	}
+	return selected
+}
+
+// connectGRPCPeer dials the peer's PeerService, or serves one on listen_port if the peer is not up yet
+func connectGRPCPeer(cfg *config.Config, auth *peerAuth, onMessage func(sent bool, message []byte)) (peerTransport, error) {
+	if cfg.Peer.Relay != "" {
+		return connectGRPCRelay(cfg, auth, onMessage)
+	}
+
+	address := net.JoinHostPort(cfg.Peer.Host, strconv.Itoa(cfg.Peer.Port))
+	fmt.Printf("   Attempting to connect to peer at %s (gRPC)...\n", address)
+	return dialGRPCPeer(cfg, auth, onMessage, address, func() (peerTransport, error) {
+		return serveGRPCPeer(cfg, auth, onMessage)
+	})
+}
+
+// dialGRPCPeer connects to the PeerService at target and negotiates the protocol version. If the
+// peer is not up, it falls back to serve; a nil serve makes that an error.
+func dialGRPCPeer(cfg *config.Config, auth *peerAuth, onMessage func(sent bool, message []byte), target string,
+	serve func() (peerTransport, error), extra ...grpc.DialOption) (peerTransport, error) {
+	clientCreds, err := peerClientCredentials(cfg, auth)
+	if err != nil {
+		return nil, err
+	}
+	dialOptions := []grpc.DialOption{
+		grpc.WithTransportCredentials(clientCreds),
+		grpc.WithStatsHandler(exchangeStats{}),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(grpcMaxMessageSize), grpc.MaxCallSendMsgSize(grpcMaxMessageSize)),
+	}
+	if auth != nil && len(auth.apiKey) > 0 {
+		dialOptions = append(dialOptions, grpc.WithUnaryInterceptor(auth.proveUnary), grpc.WithStreamInterceptor(auth.proveStream))
+	}
+	if interval := cfg.Timeouts.HeartbeatInterval; interval > 0 {
+		// Keepalive pings are gRPC's heartbeats: a peer that stops answering fails the open calls
+		dialOptions = append(dialOptions, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                interval,
+			Timeout:             heartbeatMisses * interval,
+			PermitWithoutStream: true,
+		}))
+	}
+	if cfg.Peer.Compression != "none" {
+		// gRPC compresses with gzip; zstd is only available on the tcp transport
+		dialOptions = append(dialOptions, grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name)))
+	}
+	conn, err := grpc.NewClient(target, append(dialOptions, extra...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC client: %v", err)
+	}
+
+	client := peerpb.NewPeerServiceClient(conn)
+	var health *peerpb.HealthcheckResponse
+	err = networkRetryPolicy(cfg).Do("Peer healthcheck", func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), grpcHealthcheckWait)
+		defer cancel()
+		var err error
+		health, err = client.Healthcheck(ctx, &peerpb.HealthcheckRequest{
+			ProtocolVersions: supportedProtocolVersions,
+			SoftwareVersion:  softwareVersion,
+			MinPeerVersion:   minPeerVersion,
+			Features:         peerFeatures,
+		})
+		// Only a transient failure to reach the peer is retried: a refused connection means it is
+		// not listening yet and a peer that does not answer in time is not up either, so this
+		// party serves instead. A relayed connection cannot be dialed again.
+		message := status.Convert(err).Message()
+		if err != nil && (status.Code(err) != codes.Unavailable || serve == nil || clientCreds.rejected() != nil ||
+			strings.Contains(message, "tls:") || strings.Contains(message, "connection refused")) {
+			return retry.Permanent(err)
+		}
+		return err
+	})
+	if err == nil {
+		if health.ProtocolVersion == 0 {
+			conn.Close()
+			return nil, fmt.Errorf("no common peer protocol version (local %v, peer %v running %s)",
+				supportedProtocolVersions, health.ProtocolVersions, health.SoftwareVersion)
+		}
+		recordMessage(onMessage, true, "hello", newPeerHello())
+		hello := &peerHello{SoftwareVersion: health.SoftwareVersion, MinPeerVersion: health.MinPeerVersion, Features: health.Features}
+		recordMessage(onMessage, false, "hello", hello)
+		release, err := checkPeerHello(cfg, hello)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		fmt.Printf("   Negotiated peer protocol v%d (peer %s)\n", health.ProtocolVersion, health.SoftwareVersion)
+		return &grpcClientTransport{
+			conn:                conn,
+			client:              client,
+			version:             health.ProtocolVersion,
+			peer:                release,
+			tokenTimeout:        cfg.Timeouts.TokenExchange,
+			intersectionTimeout: cfg.Timeouts.IntersectionExchange,
+			onMessage:           onMessage,
+		}, nil
+	}
+	conn.Close()
+	if rejected := clientCreds.rejected(); rejected != nil {
+		// The peer is up but failed TLS or identity checks; serving would only wait for it forever
+		return nil, fmt.Errorf("peer authentication failed: %v", rejected)
+	}
+	if strings.Contains(status.Convert(err).Message(), "tls:") {
+		// The peer is up and refused this party's certificate after the handshake (TLS 1.3)
+		return nil, fmt.Errorf("peer authentication failed: %v", err)
+	}
+	if status.Code(err) == codes.FailedPrecondition {
+		// The peer cannot link with this party's release, or lacks a feature its configuration needs
+		return nil, ConfigError.Errorf("peer refused the connection; its reason: %s", status.Convert(err).Message())
+	}
+	if code := status.Code(err); (code != codes.Unavailable && code != codes.DeadlineExceeded) || serve == nil {
+		return nil, fmt.Errorf("peer healthcheck failed: %v", err)
+	}
+
+	fmt.Printf("   Client connection failed, starting server mode...\n")
+	return serve()
+}
+
+// serveGRPCPeer serves PeerService on listen_port and waits for the peer's healthcheck
+func serveGRPCPeer(cfg *config.Config, auth *peerAuth, onMessage func(sent bool, message []byte)) (peerTransport, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.ListenPort))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start server: %v", err)
+	}
+	fmt.Printf("   Listening for peer connection on port %d (gRPC)...\n", cfg.ListenPort)
+	return serveGRPCListener(cfg, auth, onMessage, listener)
+}
+
+// serveGRPCListener serves PeerService on listener and waits for the peer's healthcheck
+func serveGRPCListener(cfg *config.Config, auth *peerAuth, onMessage func(sent bool, message []byte), listener net.Listener) (peerTransport, error) {
+	serverCreds, err := peerServerCredentials(cfg, auth)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	// Authenticate before anything else, so unauthenticated callers learn nothing about versions
+	service := newGRPCPeerServer(cfg, onMessage)
+	unary := []grpc.UnaryServerInterceptor{service.checkUnaryVersion}
+	stream := []grpc.StreamServerInterceptor{service.checkStreamVersion}
+	if auth != nil {
+		unary = append([]grpc.UnaryServerInterceptor{auth.checkUnary}, unary...)
+		stream = append([]grpc.StreamServerInterceptor{auth.checkStream}, stream...)
+	}
+	serverOptions := []grpc.ServerOption{
+		grpc.Creds(serverCreds),
+		grpc.StatsHandler(exchangeStats{}),
+		grpc.MaxRecvMsgSize(grpcMaxMessageSize),
+		grpc.MaxSendMsgSize(grpcMaxMessageSize),
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+		// Accept the peer's keepalive pings whatever interval it is configured with
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{MinTime: grpcMinPingInterval, PermitWithoutStream: true}),
+	}
+	if interval := cfg.Timeouts.HeartbeatInterval; interval > 0 {
+		serverOptions = append(serverOptions, grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    interval,
+			Timeout: heartbeatMisses * interval,
+		}))
+	}
+	grpcServer := grpc.NewServer(serverOptions...)
+	peerpb.RegisterPeerServiceServer(grpcServer, service)
+	go grpcServer.Serve(listener)
+	<-service.connected
+
+	return &grpcServerTransport{
+		server:              grpcServer,
+		service:             service,
+		tokenTimeout:        cfg.Timeouts.TokenExchange,
+		intersectionTimeout: cfg.Timeouts.IntersectionExchange,
+	}, nil
+}
+
+// plaintextWarning makes a side that dials, fails over to serving and serves again warn only once
+var plaintextWarning sync.Once
+
+// warnPlaintextPeer warns that peer traffic is not encrypted, on the dialing and serving side alike
+func warnPlaintextPeer() {
+	plaintextWarning.Do(func() {
+		fmt.Printf("   Warning: peer.tls_cert_file is not set; gRPC traffic is not encrypted\n")
+	})
+}
+
+// peerClientCredentials returns TLS credentials verifying the peer when peer.tls_cert_file is set,
+// and plaintext otherwise. With peer.allowed_peers the certificate is also presented to the peer.
+func peerClientCredentials(cfg *config.Config, auth *peerAuth) (*peerCredentials, error) {
+	if cfg.Peer.TLSCertFile == "" {
+		warnPlaintextPeer()
+		return newPeerCredentials(insecure.NewCredentials(), auth), nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.Peer.TLSServerName,
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = cfg.Peer.Host
+	}
+	if cfg.Peer.TLSCAFile != "" {
+		pem, err := os.ReadFile(cfg.Peer.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read peer.tls_ca_file: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("peer.tls_ca_file %s holds no PEM certificates", cfg.Peer.TLSCAFile)
+		}
+	}
+	if len(cfg.Peer.AllowedPeers) > 0 {
+		certificate, err := tls.LoadX509KeyPair(cfg.Peer.TLSCertFile, cfg.Peer.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load peer TLS certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return newPeerCredentials(credentials.NewTLS(tlsConfig), auth), nil
+}
+
+// peerServerCredentials returns TLS credentials from peer.tls_cert_file/tls_key_file, or plaintext.
+// With peer.allowed_peers, callers must present a certificate signed by peer.tls_ca_file.
+func peerServerCredentials(cfg *config.Config, auth *peerAuth) (*peerCredentials, error) {
+	if cfg.Peer.TLSCertFile == "" {
+		warnPlaintextPeer()
+		return newPeerCredentials(insecure.NewCredentials(), auth), nil
+	}
+	certificate, err := tls.LoadX509KeyPair(cfg.Peer.TLSCertFile, cfg.Peer.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load peer TLS certificate: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+	}
+	if len(cfg.Peer.AllowedPeers) > 0 {
+		pem, err := os.ReadFile(cfg.Peer.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read peer.tls_ca_file: %v", err)
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("peer.tls_ca_file %s holds no PEM certificates", cfg.Peer.TLSCAFile)
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return newPeerCredentials(credentials.NewTLS(tlsConfig), auth), nil
+}
+
+// grpcClientTransport is the dialing side of the gRPC transport
+type grpcClientTransport struct {
+	conn                *grpc.ClientConn
+	client              peerpb.PeerServiceClient
+	version             uint32
+	peer                *peerRelease     // From the peer's version hello
+	recipe              *RecipeHandshake // Set by ExchangeTokens
+	sealer              *transfer.Sealer // Payload keys agreed by ExchangeTokens, if any
+	secureRounds        int              // Secure comparison rounds exchanged so far
+	tokenTimeout        time.Duration    // Longest the token exchange call may take
+	intersectionTimeout time.Duration    // Longest wait for the peer's intersection
+	onMessage           func(sent bool, message []byte)
+}
+
+func (t *grpcClientTransport) IsServer() bool {
+	return false
+}
+
+// callContext returns a context carrying the negotiated protocol version
+func (t *grpcClientTransport) callContext(parent context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(parent, protocolVersionKey, strconv.Itoa(int(t.version)))
+}
+
+func (t *grpcClientTransport) ExchangeTokens(localRecipe *RecipeHandshake, localTokens *TokenData) (*TokenData, error) {
+	t.peer.downgrade(localRecipe)
+	ctx, cancel := context.WithTimeout(t.callContext(context.Background()), t.tokenTimeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	peerTokens, err := t.exchangeTokens(ctx, localRecipe, localTokens)
+	return peerTokens, peerStepError("token exchange", t.tokenTimeout, deadline, err)
+}
+
+func (t *grpcClientTransport) exchangeTokens(ctx context.Context, localRecipe *RecipeHandshake, localTokens *TokenData) (*TokenData, error) {
+	stream, err := t.client.ExchangeTokens(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open token exchange: %v", err)
+	}
+
+	// Handshakes first, so no tokens are sent to a peer with a different recipe
+	if err := stream.Send(&peerpb.TokenExchange{Message: &peerpb.TokenExchange_Handshake{Handshake: handshakeToProto(localRecipe)}}); err != nil {
+		return nil, fmt.Errorf("failed to send recipe handshake: %v", err)
+	}
+	recordMessage(t.onMessage, true, "handshake", localRecipe)
+	peerRecipe, err := receiveHandshake(stream)
+	if err != nil {
+		return nil, err
+	}
+	recordMessage(t.onMessage, false, "handshake", peerRecipe)
+	if err := verifyRecipe(localRecipe, peerRecipe); err != nil {
+		return nil, err
+	}
+	agreeIntersectionDigest(localRecipe, peerRecipe)
+	agreeReconciliation(localRecipe, peerRecipe)
+	t.recipe = localRecipe
+	confirmed, err := confirmHandshake(localRecipe, peerRecipe, false, func(local *HandshakeConfirm) (*HandshakeConfirm, error) {
+		return swapHandshakeConfirm(stream, t.onMessage, local, false)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sealer, err := agreePayloadEncryption(localRecipe, peerRecipe, false, confirmed)
+	if err != nil {
+		return nil, err
+	}
+	t.sealer = sealer
+
+	fmt.Printf("   Sending local tokens to peer...\n")
+	if err := sendTokenBatches(stream, localTokens, sealer); err != nil {
+		return nil, fmt.Errorf("failed to send local tokens: %v", err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, fmt.Errorf("failed to send local tokens: %v", err)
+	}
+	recordMessage(t.onMessage, true, "tokens", localTokens)
+
+	fmt.Printf("   Receiving tokens from peer...\n")
+	peerTokens, err := receiveTokenBatches(stream, sealer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive peer tokens: %v", err)
+	}
+	recordMessage(t.onMessage, false, "tokens", peerTokens)
+	return peerTokens, nil
+}
+
+func (t *grpcClientTransport) ExchangeIntersection(local *IntersectionResult) (*IntersectionResult, error) {
+	ctx, cancel := context.WithTimeout(t.callContext(context.Background()), t.intersectionTimeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+
+	fmt.Printf("   Sending local intersection to peer...\n")
+	recordMessage(t.onMessage, true, "intersection", local)
+	response, err := t.client.ExchangeIntersection(ctx, intersectionToProto(local))
+	if err != nil {
+		return nil, peerStepError("intersection exchange", t.intersectionTimeout, deadline, fmt.Errorf("failed to exchange intersection: %v", err))
+	}
+	peerIntersection := intersectionFromProto(response)
+	recordMessage(t.onMessage, false, "intersection", peerIntersection)
+	fmt.Printf("   Received intersection from peer\n")
+	return peerIntersection, nil
+}
+
+func (t *grpcClientTransport) CompareIntersectionDigest(local *IntersectionDigest) (*IntersectionDigest, error) {
+	if t.recipe == nil || !t.recipe.digestAgreed {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(t.callContext(context.Background()), t.intersectionTimeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+
+	recordMessage(t.onMessage, true, "intersection_digest", local)
+	response, err := t.client.CompareIntersectionDigest(ctx, &peerpb.IntersectionDigest{Salt: local.Salt, Digest: local.Digest})
+	if err != nil {
+		return nil, peerStepError("intersection exchange", t.intersectionTimeout, deadline, fmt.Errorf("failed to compare intersection digests: %v", err))
+	}
+	peerDigest := &IntersectionDigest{Salt: response.Salt, Digest: response.Digest}
+	recordMessage(t.onMessage, false, "intersection_digest", peerDigest)
+	return peerDigest, nil
+}
+
+func (t *grpcClientTransport) ConfirmReconciliation(local *IntersectionDigest) (*IntersectionDigest, error) {
+	if t.recipe == nil || t.recipe.agreedReconcile == nil {
+		return nil, fmt.Errorf("reconciliation was not offered in both handshakes")
+	}
+	ctx, cancel := context.WithTimeout(t.callContext(context.Background()), t.intersectionTimeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+
+	recordMessage(t.onMessage, true, "reconciled_digest", local)
+	response, err := t.client.ConfirmReconciliation(ctx, &peerpb.IntersectionDigest{Salt: local.Salt, Digest: local.Digest})
+	if err != nil {
+		return nil, peerStepError("intersection exchange", t.intersectionTimeout, deadline, fmt.Errorf("failed to confirm reconciliation: %v", err))
+	}
+	peerDigest := &IntersectionDigest{Salt: response.Salt, Digest: response.Digest}
+	recordMessage(t.onMessage, false, "reconciled_digest", peerDigest)
+	return peerDigest, nil
+}
+
+func (t *grpcClientTransport) ExchangeSecureMessage(local []byte) ([]byte, error) {
+	if t.recipe == nil || t.recipe.Protocol == "" {
+		return nil, fmt.Errorf("no secure matching protocol was selected in both handshakes")
+	}
+	ctx, cancel := context.WithTimeout(t.callContext(context.Background()), t.intersectionTimeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+
+	round := t.secureRounds
+	t.secureRounds++
+	payload := local
+	if t.sealer != nil {
+		payload = t.sealer.Seal(grpcSecureSeq, round, false, local)
+	}
+	recordMessage(t.onMessage, true, "secure_compare", SecureMessage{Payload: local})
+	response, err := t.client.SecureCompare(ctx, &peerpb.SecureMessage{Payload: payload})
+	if err != nil {
+		return nil, peerStepError("secure comparison", t.intersectionTimeout, deadline, fmt.Errorf("failed to exchange secure comparison message: %v", err))
+	}
+	peer := response.Payload
+	if t.sealer != nil {
+		if peer, err = t.sealer.Open(grpcSecureSeq, round, false, peer); err != nil {
+			return nil, fmt.Errorf("invalid secure comparison message: %v", err)
+		}
+	}
+	recordMessage(t.onMessage, false, "secure_compare", SecureMessage{Payload: peer})
+	return peer, nil
+}
+
+// RoundTrip times one healthcheck call; only the dialing side can time them on this transport
+func (t *grpcClientTransport) RoundTrip() (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), grpcHealthcheckWait)
+	defer cancel()
+	start := time.Now()
+	health, err := t.client.Healthcheck(ctx, &peerpb.HealthcheckRequest{
+		ProtocolVersions: supportedProtocolVersions,
+		SoftwareVersion:  softwareVersion,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("peer healthcheck failed: %v", err)
+	}
+	elapsed := time.Since(start)
+	if health.ProtocolVersion != t.version {
+		return 0, fmt.Errorf("peer changed its protocol version from v%d to v%d", t.version, health.ProtocolVersion)
+	}
+	return elapsed, nil
+}
+
+func (t *grpcClientTransport) Close() {
+	t.conn.Close()
+}
+
+// grpcServerTransport is the listening side of the gRPC transport; the workflow hands its messages
+// to the PeerService handlers and waits for the peer's
+type grpcServerTransport struct {
+	server  *grpc.Server
+	service *grpcPeerServer
+
+	tokenTimeout        time.Duration // Longest wait for the peer's tokens
+	intersectionTimeout time.Duration // Longest wait for the peer's intersection
+}
+
+func (t *grpcServerTransport) IsServer() bool {
+	return true
+}
+
+func (t *grpcServerTransport) ExchangeTokens(localRecipe *RecipeHandshake, localTokens *TokenData) (*TokenData, error) {
+	t.service.peer.downgrade(localRecipe)
+	t.service.localTokens <- tokenOffer{recipe: localRecipe, tokens: localTokens}
+	fmt.Printf("   Waiting for the peer's tokens...\n")
+	select {
+	case result := <-t.service.peerTokens:
+		return result.tokens, result.err
+	case <-time.After(t.tokenTimeout):
+		return nil, fmt.Errorf("peer timed out during token exchange after %s", t.tokenTimeout)
+	}
+}
+
+func (t *grpcServerTransport) ExchangeIntersection(local *IntersectionResult) (*IntersectionResult, error) {
+	t.service.localIntersection <- local
+	fmt.Printf("   Waiting for the peer's intersection...\n")
+	select {
+	case peerIntersection := <-t.service.peerIntersection:
+		return peerIntersection, nil
+	case <-time.After(t.intersectionTimeout):
+		return nil, fmt.Errorf("peer timed out during intersection exchange after %s", t.intersectionTimeout)
+	}
+}
+
+func (t *grpcServerTransport) CompareIntersectionDigest(local *IntersectionDigest) (*IntersectionDigest, error) {
+	if !t.service.digestAgreed.Load() {
+		return nil, nil
+	}
+	t.service.localDigest <- local
+	fmt.Printf("   Waiting for the peer's intersection digest...\n")
+	select {
+	case peerDigest := <-t.service.peerDigest:
+		return peerDigest, nil
+	case <-time.After(t.intersectionTimeout):
+		return nil, fmt.Errorf("peer timed out during intersection exchange after %s", t.intersectionTimeout)
+	}
+}
+
+func (t *grpcServerTransport) ConfirmReconciliation(local *IntersectionDigest) (*IntersectionDigest, error) {
+	if !t.service.reconcileAgreed.Load() {
+		return nil, fmt.Errorf("reconciliation was not offered in both handshakes")
+	}
+	t.service.localReconciled <- local
+	fmt.Printf("   Waiting for the peer's reconciled intersection digest...\n")
+	select {
+	case peerDigest := <-t.service.peerReconciled:
+		return peerDigest, nil
+	case <-time.After(t.intersectionTimeout):
+		return nil, fmt.Errorf("peer timed out during intersection exchange after %s", t.intersectionTimeout)
+	}
+}
+
+func (t *grpcServerTransport) ExchangeSecureMessage(local []byte) ([]byte, error) {
+	if !t.service.secureAgreed.Load() {
+		return nil, fmt.Errorf("no secure matching protocol was selected in both handshakes")
+	}
+	t.service.localSecure <- local
+	select {
+	case peer := <-t.service.peerSecure:
+		return peer, nil
+	case <-time.After(t.intersectionTimeout):
+		return nil, fmt.Errorf("peer timed out during secure comparison after %s", t.intersectionTimeout)
+	}
+}
+
+// Close lets in-flight calls finish (the peer's intersection response) before stopping
+func (t *grpcServerTransport) Close() {
+	stopped := make(chan struct{})
+	go func() {
+		t.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(grpcHealthcheckWait):
+		t.server.Stop()
+	}
+}
+
+// tokenOffer is the local side of a token exchange
+type tokenOffer struct {
+	recipe *RecipeHandshake
+	tokens *TokenData
+}
+
+// tokenResult is the outcome of a token exchange served to the peer
+type tokenResult struct {
+	tokens *TokenData
+	err    error
+}
+
+// grpcPeerServer implements PeerService for one pprl session
+type grpcPeerServer struct {
+	peerpb.UnimplementedPeerServiceServer
+
+	cfg           *config.Config
+	connected     chan struct{} // Closed on the first healthcheck with a common version and a compatible release
+	connectedOnce sync.Once
+	version       atomic.Uint32 // Protocol version negotiated on the first healthcheck
+	peer          *peerRelease  // From the version hello of the first healthcheck; set before connected is closed
+	exchanged     atomic.Bool   // Tokens are exchanged once per session
+	digestAgreed  atomic.Bool   // Both handshakes offered intersection digest comparison
+
+	reconcileAgreed atomic.Bool // Both handshakes offered reconciliation
+	secureAgreed    atomic.Bool // Both handshakes selected a protocol exchanging secure messages (smc or psi)
+	secureRounds    atomic.Int64
+	sealer          *transfer.Sealer // Payload keys agreed in the token exchange; set before secureAgreed
+
+	localTokens       chan tokenOffer
+	peerTokens        chan tokenResult
+	localIntersection chan *IntersectionResult
+	peerIntersection  chan *IntersectionResult
+	localDigest       chan *IntersectionDigest
+	peerDigest        chan *IntersectionDigest
+	localReconciled   chan *IntersectionDigest
+	peerReconciled    chan *IntersectionDigest
+	localSecure       chan []byte
+	peerSecure        chan []byte
+
+	onMessage func(sent bool, message []byte)
+}
+
+func newGRPCPeerServer(cfg *config.Config, onMessage func(sent bool, message []byte)) *grpcPeerServer {
+	return &grpcPeerServer{
+		cfg:               cfg,
+		connected:         make(chan struct{}),
+		localTokens:       make(chan tokenOffer, 1),
+		peerTokens:        make(chan tokenResult, 1),
+		localIntersection: make(chan *IntersectionResult, 1),
+		peerIntersection:  make(chan *IntersectionResult, 1),
+		localDigest:       make(chan *IntersectionDigest, 1),
+		peerDigest:        make(chan *IntersectionDigest, 1),
+		localReconciled:   make(chan *IntersectionDigest, 1),
+		peerReconciled:    make(chan *IntersectionDigest, 1),
+		localSecure:       make(chan []byte, 1),
+		peerSecure:        make(chan []byte, 1),
+		onMessage:         onMessage,
+	}
+}
+
+func (s *grpcPeerServer) Healthcheck(ctx context.Context, request *peerpb.HealthcheckRequest) (*peerpb.HealthcheckResponse, error) {
+	response := &peerpb.HealthcheckResponse{
+		ProtocolVersion:  negotiateProtocolVersion(request.ProtocolVersions),
+		ProtocolVersions: supportedProtocolVersions,
+		SoftwareVersion:  softwareVersion,
+		MinPeerVersion:   minPeerVersion,
+		Features:         peerFeatures,
+	}
+	hello := &peerHello{SoftwareVersion: request.SoftwareVersion, MinPeerVersion: request.MinPeerVersion, Features: request.Features}
+	release, err := checkPeerHello(s.cfg, hello)
+	if err != nil {
+		// Like a peer without a common version, it is turned away and this party waits on
+		fmt.Printf("   Rejected peer: %v\n", trimErrorPrefix(err.Error()))
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if response.ProtocolVersion != 0 {
+		s.connectedOnce.Do(func() {
+			recordMessage(s.onMessage, false, "hello", hello)
+			recordMessage(s.onMessage, true, "hello", newPeerHello())
+			s.peer = release
+			s.version.Store(response.ProtocolVersion)
+			if p, ok := peer.FromContext(ctx); ok {
+				fmt.Printf("   Peer connected from %s (protocol v%d, peer %s)\n", p.Addr, response.ProtocolVersion, request.SoftwareVersion)
+			}
+			close(s.connected)
+		})
+	} else {
+		fmt.Printf("   Rejected peer with no common protocol version (peer speaks %v)\n", request.ProtocolVersions)
+	}
+	return response, nil
+}
+
+func (s *grpcPeerServer) ExchangeTokens(stream peerpb.PeerService_ExchangeTokensServer) error {
+	if !s.exchanged.CompareAndSwap(false, true) {
+		return status.Error(codes.FailedPrecondition, "tokens were already exchanged in this session")
+	}
+	peerTokens, err := s.exchangeTokens(stream)
+	s.peerTokens <- tokenResult{tokens: peerTokens, err: err}
+	return err
+}
+
+func (s *grpcPeerServer) exchangeTokens(stream peerpb.PeerService_ExchangeTokensServer) (*TokenData, error) {
+	// The peer may call before this party has its tokens ready
+	var local tokenOffer
+	select {
+	case local = <-s.localTokens:
+	case <-stream.Context().Done():
+		return nil, stream.Context().Err()
+	}
+
+	peerRecipe, err := receiveHandshake(stream)
+	if err != nil {
+		return nil, err
+	}
+	recordMessage(s.onMessage, false, "handshake", peerRecipe)
+	if err := stream.Send(&peerpb.TokenExchange{Message: &peerpb.TokenExchange_Handshake{Handshake: handshakeToProto(local.recipe)}}); err != nil {
+		return nil, fmt.Errorf("failed to send recipe handshake: %v", err)
+	}
+	recordMessage(s.onMessage, true, "handshake", local.recipe)
+	if err := verifyRecipe(local.recipe, peerRecipe); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	agreeIntersectionDigest(local.recipe, peerRecipe)
+	s.digestAgreed.Store(local.recipe.digestAgreed)
+	agreeReconciliation(local.recipe, peerRecipe)
+	s.reconcileAgreed.Store(local.recipe.agreedReconcile != nil)
+	confirmed, err := confirmHandshake(local.recipe, peerRecipe, true, func(local *HandshakeConfirm) (*HandshakeConfirm, error) {
+		return swapHandshakeConfirm(stream, s.onMessage, local, true)
+	})
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	sealer, err := agreePayloadEncryption(local.recipe, peerRecipe, true, confirmed)
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	s.sealer = sealer
+	s.secureAgreed.Store(local.recipe.Protocol != "")
+
+	fmt.Printf("   Receiving tokens from peer...\n")
+	peerTokens, err := receiveTokenBatches(stream, sealer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive peer tokens: %v", err)
+	}
+	recordMessage(s.onMessage, false, "tokens", peerTokens)
+
+	fmt.Printf("   Sending local tokens to peer...\n")
+	if err := sendTokenBatches(stream, local.tokens, sealer); err != nil {
+		return nil, fmt.Errorf("failed to send local tokens: %v", err)
+	}
+	recordMessage(s.onMessage, true, "tokens", local.tokens)
+	return peerTokens, nil
+}
+
+func (s *grpcPeerServer) ExchangeIntersection(ctx context.Context, request *peerpb.Intersection) (*peerpb.Intersection, error) {
+	peerIntersection := intersectionFromProto(request)
+	recordMessage(s.onMessage, false, "intersection", peerIntersection)
+	select {
+	case s.peerIntersection <- peerIntersection:
+	default:
+		return nil, status.Error(codes.FailedPrecondition, "intersection was already exchanged in this session")
+	}
+
+	// Answer once this party's own intersection is ready
+	select {
+	case local := <-s.localIntersection:
+		recordMessage(s.onMessage, true, "intersection", local)
+		return intersectionToProto(local), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *grpcPeerServer) CompareIntersectionDigest(ctx context.Context, request *peerpb.IntersectionDigest) (*peerpb.IntersectionDigest, error) {
+	if !s.digestAgreed.Load() {
+		return nil, status.Error(codes.FailedPrecondition, "intersection digests were not offered in both handshakes")
+	}
+	peerDigest := &IntersectionDigest{Salt: request.Salt, Digest: request.Digest}
+	recordMessage(s.onMessage, false, "intersection_digest", peerDigest)
+	select {
+	case s.peerDigest <- peerDigest:
+	default:
+		return nil, status.Error(codes.FailedPrecondition, "intersection digests were already compared in this session")
+	}
+
+	// Answer once this party's own digest is ready
+	select {
+	case local := <-s.localDigest:
+		recordMessage(s.onMessage, true, "intersection_digest", local)
+		return &peerpb.IntersectionDigest{Salt: local.Salt, Digest: local.Digest}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *grpcPeerServer) ConfirmReconciliation(ctx context.Context, request *peerpb.IntersectionDigest) (*peerpb.IntersectionDigest, error) {
+	if !s.reconcileAgreed.Load() {
+		return nil, status.Error(codes.FailedPrecondition, "reconciliation was not offered in both handshakes")
+	}
+	peerDigest := &IntersectionDigest{Salt: request.Salt, Digest: request.Digest}
+	recordMessage(s.onMessage, false, "reconciled_digest", peerDigest)
+	select {
+	case s.peerReconciled <- peerDigest:
+	default:
+		return nil, status.Error(codes.FailedPrecondition, "reconciliation was already confirmed in this session")
+	}
+
+	// Answer once this party has reconciled too
+	select {
+	case local := <-s.localReconciled:
+		recordMessage(s.onMessage, true, "reconciled_digest", local)
+		return &peerpb.IntersectionDigest{Salt: local.Salt, Digest: local.Digest}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *grpcPeerServer) SecureCompare(ctx context.Context, request *peerpb.SecureMessage) (*peerpb.SecureMessage, error) {
+	if !s.secureAgreed.Load() {
+		return nil, status.Error(codes.FailedPrecondition, "no secure matching protocol was selected in both handshakes")
+	}
+	round := int(s.secureRounds.Add(1) - 1)
+	peerMessage := request.Payload
+	if s.sealer != nil {
+		var err error
+		if peerMessage, err = s.sealer.Open(grpcSecureSeq, round, false, peerMessage); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	recordMessage(s.onMessage, false, "secure_compare", SecureMessage{Payload: peerMessage})
+	select {
+	case s.peerSecure <- peerMessage:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	// Answer once this party's own message of the round is ready
+	select {
+	case local := <-s.localSecure:
+		recordMessage(s.onMessage, true, "secure_compare", SecureMessage{Payload: local})
+		if s.sealer != nil {
+			local = s.sealer.Seal(grpcSecureSeq, round, false, local)
+		}
+		return &peerpb.SecureMessage{Payload: local}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// checkUnaryVersion rejects exchange calls that do not carry a supported protocol version
+func (s *grpcPeerServer) checkUnaryVersion(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if info.FullMethod != peerpb.PeerService_Healthcheck_FullMethodName {
+		if err := checkProtocolVersion(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return handler(ctx, request)
+}
+
+func (s *grpcPeerServer) checkStreamVersion(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := checkProtocolVersion(stream.Context()); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+func checkProtocolVersion(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(protocolVersionKey)
+	if len(values) == 0 {
+		return status.Error(codes.FailedPrecondition, "missing "+protocolVersionKey+"; call Healthcheck to negotiate a version")
+	}
+	version, err := strconv.ParseUint(values[0], 10, 32)
+	if err != nil || negotiateProtocolVersion([]uint32{uint32(version)}) == 0 {
+		return status.Errorf(codes.FailedPrecondition, "unsupported peer protocol version %q (supported: %v)", values[0], supportedProtocolVersions)
+	}
+	return nil
+}
+
+// tokenStream is either end of the ExchangeTokens stream
+type tokenStream interface {
+	Send(*peerpb.TokenExchange) error
+	Recv() (*peerpb.TokenExchange, error)
+}
+
+// receiveHandshake reads the recipe handshake that opens each direction of a token exchange
+func receiveHandshake(stream tokenStream) (*RecipeHandshake, error) {
+	message, err := stream.Recv()
+	if status.Code(err) == codes.FailedPrecondition {
+		// The peer refused this party's recipe or payload encryption policy
+		return nil, ConfigError.Errorf("failed to receive recipe handshake: %v", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive recipe handshake: %v", err)
+	}
+	handshake := message.GetHandshake()
+	if handshake == nil {
+		return nil, status.Error(codes.InvalidArgument, "token exchange must start with a recipe handshake")
+	}
+	return &RecipeHandshake{
+		Fingerprint:        handshake.Fingerprint,
+		Summary:            handshake.Summary,
+		PayloadKey:         handshake.PayloadKey,
+		IntersectionDigest: handshake.IntersectionDigest,
+		Reconcile:          reconcileOfferFromProto(handshake.Reconcile),
+		Protocol:           handshake.Protocol,
+	}, nil
+}
+
+// swapHandshakeConfirm sends local and receives the peer's handshake confirmation on the token
+// exchange stream, the server receiving first
+func swapHandshakeConfirm(stream tokenStream, onMessage func(sent bool, message []byte), local *HandshakeConfirm, isServer bool) (*HandshakeConfirm, error) {
+	send := func() error {
+		if err := stream.Send(&peerpb.TokenExchange{Message: &peerpb.TokenExchange_Confirm{Confirm: &peerpb.HandshakeConfirm{Mac: local.MAC}}}); err != nil {
+			return fmt.Errorf("failed to send handshake confirmation: %v", err)
+		}
+		recordMessage(onMessage, true, "handshake_confirm", local)
+		return nil
+	}
+
+	peer := &HandshakeConfirm{}
+	receive := func() error {
+		message, err := stream.Recv()
+		if status.Code(err) == codes.FailedPrecondition {
+			return PeerError.Errorf("failed to receive handshake confirmation: %v", err)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to receive handshake confirmation: %v", err)
+		}
+		confirm := message.GetConfirm()
+		if confirm == nil {
+			return status.Error(codes.InvalidArgument, "expected a handshake confirmation, as the peer's hello offered one")
+		}
+		peer.MAC = confirm.Mac
+		recordMessage(onMessage, false, "handshake_confirm", peer)
+		return nil
+	}
+
+	steps := []func() error{send, receive}
+	if isServer {
+		steps = []func() error{receive, send}
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return nil, err
+		}
+	}
+	return peer, nil
+}
+
+// sendTokenBatches streams tokens in batches, ordered by ID. With a sealer, each batch travels
+// sealed as the batch's sealed bytes, and at least one batch is sent so the end is authenticated.
+func sendTokenBatches(stream tokenStream, tokens *TokenData, sealer *transfer.Sealer) error {
+	ids := make([]string, 0, len(tokens.Records))
+	for id := range tokens.Records {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	// At least one batch is sent, as the last one carries the digest of them all
+	for start, index := 0, 0; start < len(ids) || index == 0; start, index = start+grpcTokenBatchSize, index+1 {
+		end := start + grpcTokenBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch := &peerpb.TokenBatch{Records: make([]*peerpb.TokenRecord, 0, end-start)}
+		for _, id := range ids[start:end] {
+			record := tokens.Records[id]
+			batch.Records = append(batch.Records, &peerpb.TokenRecord{Id: record.ID, BloomFilter: record.BloomFilter, Minhash: record.MinHash, Blocking: record.Blocking})
+		}
+		if end == len(ids) {
+			batch.Digest = tokenDigestToProto(newTokenDigest(tokens))
+		}
+		if sealer != nil {
+			plaintext, err := proto.Marshal(batch)
+			if err != nil {
+				return err
+			}
+			batch = &peerpb.TokenBatch{Sealed: sealer.Seal(grpcTokenStreamSeq, index, end == len(ids), plaintext)}
+		}
+		if err := stream.Send(&peerpb.TokenExchange{Message: &peerpb.TokenExchange_Batch{Batch: batch}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receiveTokenBatches collects token batches until the sender closes its side of the stream,
+// opening them with sealer when payload encryption was agreed, and checks them against the
+// digest on the last batch
+func receiveTokenBatches(stream tokenStream, sealer *transfer.Sealer) (*TokenData, error) {
+	tokens := &TokenData{Records: make(map[string]TokenRecord)}
+	last := false
+	var digest *TokenDigest
+	for index := 0; ; index++ {
+		message, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			if sealer != nil && !last {
+				return nil, status.Error(codes.DataLoss, "sealed token stream ended before its last batch")
+			}
+			if err := digest.verify(tokens); err != nil {
+				return nil, status.Error(codes.DataLoss, err.Error())
+			}
+			return tokens, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		batch := message.GetBatch()
+		if batch == nil {
+			return nil, status.Error(codes.InvalidArgument, "expected a token batch")
+		}
+		switch {
+		case sealer == nil && len(batch.Sealed) > 0:
+			return nil, status.Error(codes.InvalidArgument, "token batch is sealed, but no payload keys were agreed")
+		case sealer != nil && (len(batch.Sealed) == 0 || len(batch.Records) > 0):
+			return nil, status.Error(codes.InvalidArgument, "token batch is not sealed, but payload encryption was agreed")
+		case sealer != nil:
+			if last {
+				return nil, status.Error(codes.InvalidArgument, "token batch after the last sealed batch")
+			}
+			// The sender marks its last batch; try both so a cut-off stream is detected at the end
+			plaintext, err := sealer.Open(grpcTokenStreamSeq, index, false, batch.Sealed)
+			if err != nil {
+				if plaintext, err = sealer.Open(grpcTokenStreamSeq, index, true, batch.Sealed); err != nil {
+					return nil, status.Error(codes.InvalidArgument, err.Error())
+				}
+				last = true
+			}
+			batch = &peerpb.TokenBatch{}
+			if err := proto.Unmarshal(plaintext, batch); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "malformed sealed token batch: %v", err)
+			}
+		}
+		if digest != nil {
+			return nil, status.Error(codes.InvalidArgument, "token batch after the batch carrying the digest")
+		}
+		for _, record := range batch.Records {
+			tokens.Records[record.Id] = TokenRecord{ID: record.Id, BloomFilter: record.BloomFilter, MinHash: record.Minhash, Blocking: record.Blocking}
+		}
+		digest = tokenDigestFromProto(batch.Digest)
+	}
+}
+
+func handshakeToProto(recipe *RecipeHandshake) *peerpb.RecipeHandshake {
+	return &peerpb.RecipeHandshake{
+		Fingerprint:        recipe.Fingerprint,
+		Summary:            recipe.Summary,
+		PayloadKey:         recipe.PayloadKey,
+		IntersectionDigest: recipe.IntersectionDigest,
+		Reconcile:          reconcileOfferToProto(recipe.Reconcile),
+		Protocol:           recipe.Protocol,
+	}
+}
+
+func reconcileOfferToProto(offer *ReconcileOffer) *peerpb.ReconcileOffer {
+	if offer == nil {
+		return nil
+	}
+	return &peerpb.ReconcileOffer{
+		HammingThreshold: offer.HammingThreshold,
+		JaccardThreshold: offer.JaccardThreshold,
+		Assignment:       offer.Assignment,
+		AllowDuplicates:  offer.AllowDuplicates,
+	}
+}
+
+func reconcileOfferFromProto(offer *peerpb.ReconcileOffer) *ReconcileOffer {
+	if offer == nil {
+		return nil
+	}
+	return &ReconcileOffer{
+		HammingThreshold: offer.HammingThreshold,
+		JaccardThreshold: offer.JaccardThreshold,
+		Assignment:       offer.Assignment,
+		AllowDuplicates:  offer.AllowDuplicates,
+	}
+}
+
+func intersectionToProto(intersection *IntersectionResult) *peerpb.Intersection {
+	result := &peerpb.Intersection{Matches: make([]*peerpb.Match, 0, len(intersection.Matches))}
+	for _, m := range intersection.Matches {
+		result.Matches = append(result.Matches, &peerpb.Match{LocalId: m.LocalID, PeerId: m.PeerID, Probability: m.Probability})
+	}
+	if intersection.Signature != nil {
+		result.Signature = &peerpb.IntersectionSignature{KeyId: intersection.Signature.KeyID, Value: intersection.Signature.Value}
+	}
+	return result
+}
+
+func intersectionFromProto(intersection *peerpb.Intersection) *IntersectionResult {
+	result := &IntersectionResult{Matches: make([]*match.PrivateMatchResult, 0, len(intersection.Matches))}
+	for _, m := range intersection.Matches {
+		result.Matches = append(result.Matches, &match.PrivateMatchResult{LocalID: m.LocalId, PeerID: m.PeerId, Probability: m.Probability})
+	}
+	if intersection.Signature != nil {
+		result.Signature = &IntersectionSignature{KeyID: intersection.Signature.KeyId, Value: intersection.Signature.Value}
+	}
+	return result
+}
+
+// recordMessage passes a logical message to the transcript hook in the PeerMessage JSON form the
+// tcp transport sends, so transcripts of both transports are audited the same way
+func recordMessage(onMessage func(sent bool, message []byte), sent bool, messageType string, payload interface{}) {
+	if onMessage == nil {
+		return
+	}
+	data, err := json.Marshal(PeerMessage{Type: messageType, Payload: payload})
+	if err != nil {
+		return
+	}
+	onMessage(sent, data)
+}
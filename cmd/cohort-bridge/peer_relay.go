@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/relay"
+	"github.com/auroradata-ai/cohort-bridge/internal/transfer"
+)
+
+// joinRelay dials the relay named by peer.relay and waits for the peer to join the session. The
+// relay decides which party serves: the first to arrive.
+func joinRelay(cfg *config.Config) (net.Conn, *relay.URL, bool, error) {
+	target, err := relay.ParseURL(cfg.Peer.Relay)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	warnRelayExposure(cfg)
+	fmt.Printf("   Joining session %s on relay %s...\n", target.Session, target.Address)
+	var conn net.Conn
+	var role string
+	err = networkRetryPolicy(cfg).Do("Joining the relay", func() error {
+		conn, role, err = relay.Dial(target, relay.RoleAny, cfg.Timeouts.ConnectionTimeout, 0)
+		return err
+	})
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("failed to join relay: %v", err)
+	}
+	fmt.Printf("   Paired with peer through the relay (acting as %s)\n", role)
+	return conn, target, role == relay.RoleServer, nil
+}
+
+// warnRelayExposure warns when the relay could read the tokens it forwards: with payload
+// encryption off, or under auto, which goes on in plaintext with a peer that has it off
+func warnRelayExposure(cfg *config.Config) {
+	mode, _ := transfer.ParsePayloadEncryption(cfg.Peer.PayloadEncryption)
+	tls := cfg.Peer.TLSCertFile != "" && !strings.EqualFold(cfg.Peer.Transport, "tcp")
+	switch {
+	case tls || mode == transfer.PayloadEncryptionRequired:
+	case mode == transfer.PayloadEncryptionOff:
+		fmt.Printf("   Warning: peer.payload_encryption is off and TLS is not used; the relay can read the tokens\n")
+	default:
+		fmt.Printf("   Warning: peer.payload_encryption is auto and TLS is not used; the relay can read the tokens " +
+			"if the peer has payload encryption off (set it to required to refuse such a peer)\n")
+	}
+}
+
+// establishRelayConnection meets the peer through a relay on the tcp transport. After a network
+// failure each party rejoins the session in the role it had.
+func establishRelayConnection(cfg *config.Config, auth *peerAuth) (*peerLink, error) {
+	conn, target, isServer, err := joinRelay(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := auth.authenticateConn(conn, isServer, cfg.Timeouts.HandshakeTimeout); err != nil {
+		auditPeerAuthFailure(conn.RemoteAddr(), "relay", err.Error())
+		conn.Close()
+		return nil, fmt.Errorf("peer authentication failed: %v", err)
+	}
+
+	role := relay.RoleClient
+	if isServer {
+		role = relay.RoleServer
+	}
+	redial := func() (net.Conn, error) {
+		conn, _, err := relay.Dial(target, role, cfg.Timeouts.ConnectionTimeout, cfg.Timeouts.ConnectionTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if err := auth.authenticateConn(conn, isServer, cfg.Timeouts.HandshakeTimeout); err != nil {
+			auditPeerAuthFailure(conn.RemoteAddr(), "relay", err.Error())
+			conn.Close()
+			return nil, &peerAuthError{err}
+		}
+		return conn, nil
+	}
+	return &peerLink{conn: conn, isServer: isServer, redial: redial}, nil
+}
+
+// connectGRPCRelay meets the peer through a relay on the grpc transport. The party the relay makes
+// the server serves PeerService over the relayed connection; the other dials it. The connection
+// is not re-established if it drops.
+func connectGRPCRelay(cfg *config.Config, auth *peerAuth, onMessage func(sent bool, message []byte)) (peerTransport, error) {
+	if cfg.Peer.TLSCertFile != "" && cfg.Peer.TLSServerName == "" && cfg.Peer.Host == "" {
+		return nil, fmt.Errorf("peer.tls_server_name is needed to verify the peer's certificate through a relay")
+	}
+	conn, target, isServer, err := joinRelay(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if isServer {
+		return serveGRPCListener(cfg, auth, onMessage, newRelayListener(conn))
+	}
+
+	var once sync.Once
+	dialer := func(context.Context, string) (net.Conn, error) {
+		var handed net.Conn
+		once.Do(func() { handed = conn })
+		if handed == nil {
+			return nil, fmt.Errorf("relayed connection to the peer was lost")
+		}
+		return handed, nil
+	}
+	return dialGRPCPeer(cfg, auth, onMessage, "passthrough:///"+target.Session, nil, grpc.WithContextDialer(dialer))
+}
+
+// relayListener hands the gRPC server the one connection paired by the relay, then blocks until
+// the server stops
+type relayListener struct {
+	conns  chan net.Conn
+	addr   net.Addr
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newRelayListener(conn net.Conn) *relayListener {
+	l := &relayListener{conns: make(chan net.Conn, 1), addr: conn.RemoteAddr(), closed: make(chan struct{})}
+	l.conns <- conn
+	return l
+}
+
+func (l *relayListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *relayListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *relayListener) Addr() net.Addr {
+	return l.addr
+}
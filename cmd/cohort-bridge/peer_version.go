@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/crypto"
+	"github.com/auroradata-ai/cohort-bridge/internal/transfer"
+)
+
+// minPeerVersion is the oldest release this build links with
+const minPeerVersion = "v0.1.0"
+
+// Protocol features named in the version hello. A name is never reused for something else; a
+// release leaves out the features it does not support, and the peer turns them off or, when
+// its configuration depends on one, refuses to go on.
+const (
+	featurePayloadEncryption  = "payload-encryption"  // X25519 payload keys in the recipe handshake
+	featureIntersectionDigest = "intersection-digest" // Salted intersection digests before the intersections
+	featureReconcile          = "reconcile"           // Reconciliation of differing intersections
+	featureSignedIntersection = "signed-intersection" // Ed25519 signatures on exchanged intersections
+	featureTokenDigest        = "token-digest"        // Record count and SHA-256 sent with the tokens
+	featureHeartbeat          = "heartbeat"           // Heartbeats on the tcp transport
+	featureSMC                = "smc"                 // matching.protocol: smc
+	featurePSI                = "psi"                 // matching.protocol: psi and matching.exact_first_pass
+	featureHandshakeConfirm   = "handshake-confirm"   // MACs of the hellos and recipe handshakes, swapped before the tokens
+)
+
+// peerFeatures are the protocol features this build supports
+var peerFeatures = []string{
+	featurePayloadEncryption, featureIntersectionDigest, featureReconcile, featureSignedIntersection,
+	featureTokenDigest, featureHeartbeat, featureSMC, featurePSI, featureHandshakeConfirm,
+}
+
+// peerHello is the version hello, the first message each party sends once connected: small and
+// stable, so that a peer on an incompatible release is refused before anything whose encoding
+// may have changed is exchanged
+type peerHello struct {
+	SoftwareVersion string   `json:"software_version"`
+	MinPeerVersion  string   `json:"min_peer_version"`
+	Features        []string `json:"features"`
+}
+
+// newPeerHello describes this build
+func newPeerHello() *peerHello {
+	return &peerHello{SoftwareVersion: softwareVersion, MinPeerVersion: minPeerVersion, Features: peerFeatures}
+}
+
+// peerRelease is what the peer's hello told about it
+type peerRelease struct {
+	version  string
+	features map[string]bool // nil for a release from before the hello, whose features are offered in the recipe handshake alone
+	hello    *peerHello      // The hello as received, nil for a release from before the hello
+}
+
+// String names the peer's release for messages
+func (r *peerRelease) String() string {
+	if r == nil || r.version == "" {
+		return "a release without a version hello"
+	}
+	return "cohort-bridge " + r.version
+}
+
+// supports reports whether the peer supports feature; a release from before the hello is taken to
+func (r *peerRelease) supports(feature string) bool {
+	return r == nil || r.features == nil || r.features[feature]
+}
+
+// downgrade withdraws the offers of localRecipe that the peer does not support, so both parties
+// go on without them rather than fail on a message the peer cannot read. It also records the
+// release in localRecipe for the handshake confirmation.
+func (r *peerRelease) downgrade(localRecipe *RecipeHandshake) {
+	localRecipe.peer = r
+	var off []string
+	if localRecipe.keys != nil && !r.supports(featurePayloadEncryption) {
+		localRecipe.keys, localRecipe.PayloadKey = nil, nil
+		off = append(off, "payload encryption")
+	}
+	if localRecipe.IntersectionDigest && !r.supports(featureIntersectionDigest) {
+		localRecipe.IntersectionDigest = false
+		off = append(off, "intersection digests")
+	}
+	if localRecipe.Reconcile != nil && !r.supports(featureReconcile) {
+		localRecipe.Reconcile = nil
+		off = append(off, "reconciliation")
+	}
+	if localRecipe.Heartbeat != "" && !r.supports(featureHeartbeat) {
+		localRecipe.Heartbeat = ""
+		off = append(off, "heartbeats")
+	}
+	if len(off) > 0 {
+		fmt.Printf("   Downgraded for %s: %s off\n", r, strings.Join(off, ", "))
+	}
+}
+
+// requiredPeerFeatures returns the features the configuration cannot do without, with the
+// setting that needs each
+func requiredPeerFeatures(cfg *config.Config) map[string]string {
+	required := make(map[string]string)
+	if mode, err := transfer.ParsePayloadEncryption(cfg.Peer.PayloadEncryption); err == nil && mode == transfer.PayloadEncryptionRequired {
+		required[featurePayloadEncryption] = "peer.payload_encryption: required"
+	}
+	if cfg.Peer.PeerPublicKey != "" {
+		required[featureSignedIntersection] = "peer.peer_public_key"
+	}
+	protocol := handshakeProtocol(cfg)
+	if strings.Contains(protocol, crypto.ProtocolSMC) {
+		required[featureSMC] = "matching.protocol: smc"
+	}
+	if usesExactFirstPass(cfg) {
+		required[featurePSI] = "matching.exact_first_pass"
+	} else if strings.Contains(protocol, crypto.ProtocolPSI) {
+		required[featurePSI] = "matching.protocol: psi"
+	}
+	return required
+}
+
+// checkPeerHello refuses a peer whose release this party cannot link with, or that lacks a
+// feature the configuration needs; otherwise it returns the peer's release. A hello without a
+// version comes from a release before the hello, which is let through: its recipe handshake
+// still offers what it supports.
+func checkPeerHello(cfg *config.Config, hello *peerHello) (*peerRelease, error) {
+	if hello.SoftwareVersion == "" {
+		return &peerRelease{}, nil
+	}
+	release := &peerRelease{version: hello.SoftwareVersion, features: make(map[string]bool), hello: hello}
+	for _, feature := range hello.Features {
+		release.features[feature] = true
+	}
+
+	peer, ok := parseSemver(hello.SoftwareVersion)
+	if !ok {
+		return nil, ConfigError.Errorf("peer reported release %q, which is not a semantic version", hello.SoftwareVersion)
+	}
+	local, _ := parseSemver(softwareVersion)
+	oldest, _ := parseSemver(minPeerVersion)
+	switch {
+	case peer[0] != local[0]:
+		return nil, ConfigError.Errorf("peer runs %s and this party %s: releases of different major versions cannot link; "+
+			"run the same major release at both sites", release, softwareVersion)
+	case compareSemver(peer, oldest) < 0:
+		return nil, ConfigError.Errorf("peer runs %s, older than %s, the oldest release this party (%s) links with; upgrade the peer",
+			release, minPeerVersion, softwareVersion)
+	}
+	if peerOldest, ok := parseSemver(hello.MinPeerVersion); ok && compareSemver(local, peerOldest) < 0 {
+		return nil, ConfigError.Errorf("peer runs %s, which links only with %s or newer, and this party runs %s; upgrade this party",
+			release, hello.MinPeerVersion, softwareVersion)
+	}
+
+	required := requiredPeerFeatures(cfg)
+	features := make([]string, 0, len(required))
+	for feature := range required {
+		features = append(features, feature)
+	}
+	slices.Sort(features)
+	for _, feature := range features {
+		if !release.supports(feature) {
+			return nil, ConfigError.Errorf("%s needs the %s feature, which the peer (%s) does not support; upgrade the peer or change the setting",
+				required[feature], feature, release)
+		}
+	}
+	return release, nil
+}
+
+// parseSemver parses vMAJOR.MINOR.PATCH, ignoring any pre-release or build suffix
+func parseSemver(version string) ([3]int, bool) {
+	var parsed [3]int
+	core, _, _ := strings.Cut(strings.TrimPrefix(version, "v"), "+")
+	core, _, _ = strings.Cut(core, "-")
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return parsed, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return parsed, false
+		}
+		parsed[i] = n
+	}
+	return parsed, true
+}
+
+// compareSemver returns -1, 0 or 1 as a is older than, the same as or newer than b
+func compareSemver(a, b [3]int) int {
+	return slices.Compare(a[:], b[:])
+}
+
+// exchangePeerHello swaps version hellos on the tcp transport, the server receiving first. A peer
+// from before the hello sends its recipe handshake instead, or drops the connection on receiving a
+// hello, and is refused with a message saying so rather than with whatever that would break.
+func exchangePeerHello(channel *transfer.Channel, cfg *config.Config, isServer bool) (*peerRelease, error) {
+	send := func() error {
+		if err := sendPeerMessage(channel, PeerMessage{Type: "hello", Payload: newPeerHello()}); err != nil {
+			return fmt.Errorf("failed to send version hello: %v", err)
+		}
+		return nil
+	}
+	var hello peerHello
+	receive := func() error {
+		var peerMessage PeerMessage
+		if err := receivePeerMessage(channel, &peerMessage); err != nil {
+			if !isServer {
+				return ConfigError.Errorf("peer closed the connection instead of answering the version hello (%v): "+
+					"it runs a release from before version negotiation; upgrade it to %s or newer", err, softwareVersion)
+			}
+			return fmt.Errorf("failed to receive version hello: %v", err)
+		}
+		if peerMessage.Type != "hello" {
+			return ConfigError.Errorf("peer sent %q instead of a version hello: it runs a release from before version negotiation; "+
+				"upgrade it to %s or newer", peerMessage.Type, softwareVersion)
+		}
+		if err := mapToStruct(peerMessage.Payload, &hello); err != nil {
+			return ConfigError.Errorf("failed to parse the peer's version hello: %v", err)
+		}
+		return nil
+	}
+
+	steps := []func() error{send, receive}
+	if isServer {
+		steps = []func() error{receive, send}
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return nil, err
+		}
+	}
+	release, err := checkPeerHello(cfg, &hello)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("   Peer release: %s\n", release)
+	return release, nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/transfer"
+)
+
+// roundTripper is a peer transport that can time round trips once the handshake is done
+type roundTripper interface {
+	RoundTrip() (time.Duration, error)
+}
+
+// pingCheck is one line of the ping report
+type pingCheck struct {
+	name   string
+	detail string
+	err    error
+}
+
+// pingFlags are the flags of the ping command
+type pingFlags struct {
+	fs         *flag.FlagSet
+	configFile *string
+	count      *int
+	transport  *string
+	peer       *string
+	listenPort *int
+	relayURL   *string
+	help       *bool
+}
+
+// newPingFlags defines the flags of the ping command
+func newPingFlags() *pingFlags {
+	fs := newFlagSet("ping")
+	return &pingFlags{
+		fs:         fs,
+		configFile: fs.String("config", "", "Configuration file"),
+		count:      fs.Int("count", 5, "Round trips to time"),
+		transport:  fs.String("transport", "", "Peer transport: grpc or tcp (overrides peer.transport)"),
+		peer:       fs.String("peer", "", "Peer address host:port (overrides peer.host and peer.port)"),
+		listenPort: fs.Int("listen-port", 0, "Local listen port (overrides listen_port)"),
+		relayURL:   fs.String("relay", "", "Meet the peer through a relay: relay://host[:port]/session (overrides peer.relay)"),
+		help:       fs.Bool("help", false, "Show help message"),
+	}
+}
+
+func runPingCommand(args []string) {
+	f := newPingFlags()
+	f.fs.Parse(args)
+
+	if *f.help {
+		showPingHelp()
+		return
+	}
+	if *f.configFile == "" {
+		showPingHelp()
+		fatalf(UsageError, "Error: -config is required")
+	}
+	if *f.count < 1 {
+		fatalf(UsageError, "-count must be at least 1")
+	}
+
+	cfg, err := config.Load(*f.configFile)
+	if err != nil {
+		fatalf(ConfigError, "Failed to load configuration: %v", err)
+	}
+	if err := applyPeerFlags(cfg, *f.peer, *f.listenPort, *f.relayURL); err != nil {
+		fatalf(UsageError, "%v", err)
+	}
+	if *f.transport != "" {
+		cfg.Peer.Transport = *f.transport
+	}
+	if err := checkPeerConnection(cfg); err != nil {
+		fatalf(ConfigError, "%v", err)
+	}
+
+	fmt.Println("CohortBridge Ping")
+	fmt.Println("=================")
+	if cfg.Peer.Relay != "" {
+		fmt.Printf("Peer Relay: %s\n", cfg.Peer.Relay)
+	} else {
+		fmt.Printf("Peer Address: %s:%d\n", cfg.Peer.Host, cfg.Peer.Port)
+		fmt.Printf("Listen Port: %d\n", cfg.ListenPort)
+	}
+	fmt.Println()
+
+	checks := runPingChecks(cfg, *f.count)
+
+	fmt.Println()
+	fmt.Println("Ping Report:")
+	var failed *pingCheck
+	for i, check := range checks {
+		if check.err != nil {
+			failed = &checks[i]
+			fmt.Printf("  FAIL  %-12s %v\n", check.name, check.err)
+		} else {
+			fmt.Printf("  OK    %-12s %s\n", check.name, check.detail)
+		}
+	}
+	if failed != nil {
+		fatalf(PeerError, "ERROR: %s check failed: %v", failed.name, failed.err)
+	}
+	fmt.Println()
+	fmt.Println("Peer is reachable and ready for 'cohort-bridge pprl'")
+}
+
+// runPingChecks connects to the peer, exchanges recipe handshakes without any tokens and times count
+// round trips. It stops at the first failed check, which is the last one returned.
+func runPingChecks(cfg *config.Config, count int) []pingCheck {
+	var checks []pingCheck
+	fail := func(name string, err error) []pingCheck {
+		return append(checks, pingCheck{name: name, err: err})
+	}
+
+	recordConfig, err := newRecordConfig(cfg.Tokenization, cfg.Keys)
+	if err != nil {
+		return fail("recipe", fmt.Errorf("invalid tokenization recipe: %v", err))
+	}
+	localRecipe, err := newRecipeHandshake(cfg, recordConfig)
+	if err != nil {
+		return fail("recipe", fmt.Errorf("invalid peer configuration: %v", err))
+	}
+	auth, err := newPeerAuth(cfg)
+	if err != nil {
+		return fail("auth", fmt.Errorf("invalid peer authentication: %v", err))
+	}
+	if auth == nil {
+		fmt.Printf("   Warning: no peer authentication configured (peer.api_key or peer.allowed_peers)\n")
+	} else {
+		fmt.Printf("   Peer authentication: %s\n", auth.methods())
+	}
+
+	transport, err := connectPeer(cfg, auth, nil)
+	if err != nil {
+		return fail("connect", err)
+	}
+	defer transport.Close()
+	role := "client"
+	if transport.IsServer() {
+		role = "server"
+	}
+	checks = append(checks, pingCheck{name: "connect", detail: fmt.Sprintf("connected as %s over %s", role, cfg.Peer.Transport)})
+	checks = append(checks, pingCheck{name: "protocol", detail: peerProtocol(transport)})
+	if auth == nil {
+		checks = append(checks, pingCheck{name: "auth", detail: "none configured (peer.api_key or peer.allowed_peers recommended)"})
+	} else {
+		checks = append(checks, pingCheck{name: "auth", detail: auth.methods() + " accepted"})
+	}
+
+	// An empty token exchange carries the recipe handshakes, verifying the fingerprints match
+	peerTokens, err := transport.ExchangeTokens(localRecipe, &TokenData{Records: make(map[string]TokenRecord)})
+	if err != nil {
+		return fail("recipe", err)
+	}
+	if len(peerTokens.Records) > 0 {
+		return fail("recipe", fmt.Errorf("peer sent %d token records: it is running a linkage, not ping", len(peerTokens.Records)))
+	}
+	checks = append(checks, pingCheck{name: "recipe", detail: "fingerprint " + shortFingerprint(localRecipe.Fingerprint) + " matches"})
+
+	if rt, ok := transport.(roundTripper); ok {
+		var total, fastest, slowest time.Duration
+		for i := 0; i < count; i++ {
+			elapsed, err := rt.RoundTrip()
+			if err != nil {
+				return fail("latency", err)
+			}
+			fmt.Printf("   Round trip %d: %s\n", i+1, elapsed.Round(time.Microsecond))
+			total += elapsed
+			if i == 0 || elapsed < fastest {
+				fastest = elapsed
+			}
+			if elapsed > slowest {
+				slowest = elapsed
+			}
+		}
+		average := total / time.Duration(count)
+		checks = append(checks, pingCheck{name: "latency", detail: fmt.Sprintf("min/avg/max %s/%s/%s over %d round trips",
+			fastest.Round(time.Microsecond), average.Round(time.Microsecond), slowest.Round(time.Microsecond), count)})
+	} else {
+		checks = append(checks, pingCheck{name: "latency", detail: "timed by the dialing party on this transport"})
+	}
+
+	// Both parties finish together, so neither closes the connection while the other is still timing
+	if _, err := transport.ExchangeIntersection(&IntersectionResult{}); err != nil {
+		return fail("close", err)
+	}
+	return checks
+}
+
+// peerProtocol describes the wire protocol agreed with the peer and the peer's release
+func peerProtocol(transport peerTransport) string {
+	switch t := transport.(type) {
+	case *grpcClientTransport:
+		return fmt.Sprintf("peer protocol v%d, peer %s", t.version, t.peer)
+	case *grpcServerTransport:
+		return fmt.Sprintf("peer protocol v%d, peer %s", t.service.version.Load(), t.service.peer)
+	case *tcpPeerTransport:
+		return fmt.Sprintf("JSON protocol, peer %s", t.peer)
+	}
+	return "JSON protocol"
+}
+
+// pingPeer times one round trip on the tcp transport. The client pings and the server answers
+// with a ping of its own, which the client answers in turn, so both parties time a round trip.
+func pingPeer(channel *transfer.Channel, isServer bool) (time.Duration, error) {
+	send := func() error {
+		if err := sendPeerMessage(channel, PeerMessage{Type: "ping"}); err != nil {
+			return fmt.Errorf("failed to send ping: %v", err)
+		}
+		return nil
+	}
+	receive := func() error {
+		var peerMessage PeerMessage
+		if err := receivePeerMessage(channel, &peerMessage); err != nil {
+			return fmt.Errorf("failed to receive ping: %v", err)
+		}
+		if peerMessage.Type != "ping" {
+			return fmt.Errorf("unexpected message type: %s (peer may not be running ping)", peerMessage.Type)
+		}
+		return nil
+	}
+
+	if isServer {
+		if err := receive(); err != nil {
+			return 0, err
+		}
+	}
+	start := time.Now()
+	if err := send(); err != nil {
+		return 0, err
+	}
+	if err := receive(); err != nil {
+		return 0, err
+	}
+	elapsed := time.Since(start)
+	if !isServer {
+		if err := send(); err != nil {
+			return 0, err
+		}
+	}
+	return elapsed, nil
+}
+
+func showPingHelp() {
+	fmt.Println("CohortBridge Ping")
+	fmt.Println("=================")
+	fmt.Println()
+	fmt.Println("Check the connection to the peer before a linkage: connects as pprl would, negotiates")
+	fmt.Println("the protocol version, authenticates, compares recipe fingerprints and times round")
+	fmt.Println("trips. No tokens are sent. Both parties run ping at the same time.")
+	fmt.Println()
+	fmt.Println("USAGE:")
+	fmt.Println("  cohort-bridge ping -config config.yaml [OPTIONS]")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Println("  -config string        Configuration file (required)")
+	fmt.Println("  -count n              Round trips to time (default: 5)")
+	fmt.Println("  -transport string     Peer transport: grpc or tcp (overrides peer.transport)")
+	fmt.Println("  -peer host:port       Peer address (overrides peer.host and peer.port)")
+	fmt.Println("  -listen-port n        Local listen port (overrides listen_port)")
+	fmt.Println("  -relay url            Meet the peer through a relay: relay://host[:port]/session")
+	fmt.Println("  -help                 Show this help message")
+	fmt.Println()
+	fmt.Println("CHECKS:")
+	fmt.Println("  connect   the peer is reachable (or connects to this party) over peer.transport")
+	fmt.Println("  protocol  a common peer protocol version (gRPC transport)")
+	fmt.Println("  auth      peer.api_key and peer.allowed_peers are accepted by both parties")
+	fmt.Println("  recipe    both parties tokenize with the same recipe, seed and linkage secret")
+	fmt.Println("  latency   round-trip time; on gRPC only the dialing party times round trips")
+	fmt.Println()
+	fmt.Println("Ping exits with status 1 when a check fails. On the tcp transport both parties time")
+	fmt.Println("round trips in step, so pass both the same -count.")
+	fmt.Println()
+	fmt.Println("EXAMPLES:")
+	fmt.Println("  # On both parties' machines")
+	fmt.Println("  cohort-bridge ping -config config.yaml")
+	fmt.Println()
+	fmt.Println("  # Time 20 round trips over the legacy tcp transport")
+	fmt.Println("  cohort-bridge ping -config config.yaml -transport tcp -count 20")
+}
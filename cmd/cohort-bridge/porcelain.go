@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/store"
+)
+
+// Formats of the global -porcelain flag
+const (
+	porcelainKV   = "kv"   // One key=value line per field (the default)
+	porcelainJSON = "json" // One JSON object on one line
+)
+
+// porcelainFormat is set by the global -porcelain flag ("" when off)
+var porcelainFormat string
+
+// porcelain collects the summary printed to stdout with -porcelain; nil when off
+var porcelain *porcelainSummary
+
+// porcelainSummary is the stable, machine-parseable result of a command. The decorative output
+// goes to stderr while it is collected, so stdout holds the summary alone.
+type porcelainSummary struct {
+	stdout *os.File // The real stdout
+
+	Command  string             `json:"command"`
+	Status   string             `json:"status"` // ok or failed
+	RunID    string             `json:"run_id,omitempty"`
+	ExitCode int                `json:"exit_code"`
+	Error    string             `json:"error,omitempty"`
+	Counts   map[string]int     `json:"counts,omitempty"`
+	Metrics  map[string]float64 `json:"metrics,omitempty"`
+	Outputs  []string           `json:"outputs,omitempty"`
+}
+
+// startPorcelain moves the decorative output of command to stderr, keeping stdout for the summary
+func startPorcelain(command string) {
+	porcelain = &porcelainSummary{stdout: os.Stdout, Command: command}
+	os.Stdout = os.Stderr
+}
+
+// setRun takes the run ID, counts and outputs of a recorded run
+func (p *porcelainSummary) setRun(run *store.Run) {
+	if p == nil {
+		return
+	}
+	p.RunID = run.ID
+	for name, count := range run.Counts {
+		p.count(name, count)
+	}
+	for _, output := range run.Outputs {
+		p.output(output)
+	}
+}
+
+func (p *porcelainSummary) count(name string, value int) {
+	if p == nil {
+		return
+	}
+	if p.Counts == nil {
+		p.Counts = make(map[string]int)
+	}
+	p.Counts[name] = value
+}
+
+func (p *porcelainSummary) metric(name string, value float64) {
+	if p == nil {
+		return
+	}
+	if p.Metrics == nil {
+		p.Metrics = make(map[string]float64)
+	}
+	p.Metrics[name] = value
+}
+
+// output adds a file the command wrote, once
+func (p *porcelainSummary) output(path string) {
+	if p == nil {
+		return
+	}
+	for _, existing := range p.Outputs {
+		if existing == path {
+			return
+		}
+	}
+	p.Outputs = append(p.Outputs, path)
+}
+
+// finish prints the summary of a command that completed
+func (p *porcelainSummary) finish() {
+	if p == nil {
+		return
+	}
+	p.Status = "ok"
+	p.print()
+}
+
+// fail prints the summary of a command that is exiting with an error
+func (p *porcelainSummary) fail(category errorCategory, message string) {
+	if p == nil {
+		return
+	}
+	p.Status, p.ExitCode, p.Error = "failed", category.ExitCode(), strings.TrimSpace(trimErrorPrefix(message))
+	p.print()
+}
+
+// print writes the summary to the real stdout. Key=value lines come in a fixed order: command,
+// status, run_id, exit_code and error, then the counts and metrics by name, then one output
+// line per file; values with spaces, quotes or line breaks are quoted.
+func (p *porcelainSummary) print() {
+	if porcelainFormat == porcelainJSON {
+		data, _ := json.Marshal(p)
+		fmt.Fprintln(p.stdout, string(data))
+		return
+	}
+	line := func(key, value string) {
+		if value == "" || strings.ContainsAny(value, " \t\r\n\"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(p.stdout, "%s=%s\n", key, value)
+	}
+	line("command", p.Command)
+	line("status", p.Status)
+	if p.RunID != "" {
+		line("run_id", p.RunID)
+	}
+	line("exit_code", strconv.Itoa(p.ExitCode))
+	if p.Error != "" {
+		line("error", p.Error)
+	}
+	for _, name := range sortedKeys(p.Counts) {
+		line(name, strconv.Itoa(p.Counts[name]))
+	}
+	for _, name := range sortedKeys(p.Metrics) {
+		line(name, strconv.FormatFloat(p.Metrics[name], 'f', 6, 64))
+	}
+	for _, output := range p.Outputs {
+		line("output", output)
+	}
+}
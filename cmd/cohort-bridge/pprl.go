@@ -3,25 +3,38 @@ package main
 import (
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/crypto"
 	"github.com/auroradata-ai/cohort-bridge/internal/db"
+	"github.com/auroradata-ai/cohort-bridge/internal/keys"
 	"github.com/auroradata-ai/cohort-bridge/internal/match"
 	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+	"github.com/auroradata-ai/cohort-bridge/internal/relay"
+	"github.com/auroradata-ai/cohort-bridge/internal/retry"
+	"github.com/auroradata-ai/cohort-bridge/internal/server"
+	"github.com/auroradata-ai/cohort-bridge/internal/store"
+	"github.com/auroradata-ai/cohort-bridge/internal/transcript"
+	"github.com/auroradata-ai/cohort-bridge/internal/transfer"
 )
 
 // IntersectionResult represents a zero-knowledge computed intersection
 // ONLY contains matches - no other information that could leak data
 type IntersectionResult struct {
-	Matches []*match.PrivateMatchResult `json:"matches"` // ONLY the matches
+	Matches   []*match.PrivateMatchResult `json:"matches"`             // ONLY the matches
+	Signature *IntersectionSignature      `json:"signature,omitempty"` // Set when the sender has a signing key
 	// NO statistics, metadata, or any other information that could leak data
 }
 
@@ -29,8 +42,30 @@ type IntersectionResult struct {
 
 // PeerMessage represents messages exchanged between peers
 type PeerMessage struct {
-	Type    string      `json:"type"`
-	Payload interface{} `json:"payload"`
+	Type    string       `json:"type"`
+	Payload interface{}  `json:"payload"`
+	Digest  *TokenDigest `json:"digest,omitempty"` // Count and SHA-256 of the records of a tokens payload
+}
+
+// RecipeHandshake is sent before tokens so both parties can verify they tokenized identically
+type RecipeHandshake struct {
+	Fingerprint string `json:"fingerprint"` // Hash of recipe, seed and normalization methods
+	Summary     string `json:"summary"`     // Human-readable recipe (no seed)
+
+	Encodings  []string `json:"encodings,omitempty"`   // Compression encodings this party accepts, preferred first
+	PayloadKey []byte   `json:"payload_key,omitempty"` // Ephemeral X25519 public key for payload encryption
+	Heartbeat  string   `json:"heartbeat,omitempty"`   // Interval of this party's heartbeats on the tcp transport
+
+	IntersectionDigest bool            `json:"intersection_digest,omitempty"` // Offers to compare intersection digests before the intersections
+	Reconcile          *ReconcileOffer `json:"reconcile,omitempty"`           // Offers to reconcile differing intersections (matching.reconcile)
+	Protocol           string          `json:"protocol,omitempty"`            // Matching protocol: smc, psi, psi+standard, psi+smc, or empty for the standard one
+
+	payloadMode     string                // peer.payload_encryption
+	keys            *transfer.KeyExchange // Private half of PayloadKey
+	secret          []byte                // Recipe secrets both parties hold, binding the payload keys and handshake confirmation
+	peer            *peerRelease          // The peer's release, recorded by downgrade
+	digestAgreed    bool                  // Both handshakes offered IntersectionDigest
+	agreedReconcile *ReconcileOffer       // Parameters for reconciliation, if both handshakes offered it
 }
 
 // TokenData represents the tokenized data to be exchanged
@@ -41,8 +76,10 @@ type TokenData struct {
 // TokenRecord represents a single tokenized record
 type TokenRecord struct {
 	ID          string `json:"id"`
-	BloomFilter string `json:"bloom_filter"` // base64 encoded
-	MinHash     string `json:"minhash"`      // base64 encoded
+	BloomFilter string `json:"bloom_filter"`       // base64 encoded
+	MinHash     string `json:"minhash"`            // base64 encoded
+	Blocking    string `json:"blocking,omitempty"` // space-separated hashed blocking keys
+	ExactID     string `json:"exact_id,omitempty"` // keyed hash of the exact identifier; never sent to the peer
 }
 
 // SecureWorkflowConfig holds secure computation configuration
@@ -50,47 +87,206 @@ type SecureWorkflowConfig struct {
 	Party int `json:"party"` // 0 or 1 for two-party protocol
 }
 
-// runUnifiedWorkflow implements the new unified peer-to-peer workflow
-func runUnifiedWorkflow(cfg *config.Config, force, allowDuplicates bool) {
+// runUnifiedWorkflow implements the new unified peer-to-peer workflow, writing results to outputDir
+func runUnifiedWorkflow(cfg *config.Config, thresholds config.Thresholds, outputDir string, force, allowDuplicates, resume bool) {
 	fmt.Println("Starting Unified PPRL Peer-to-Peer Workflow")
 	fmt.Println("============================================")
 	fmt.Printf("Local Dataset: %s\n", cfg.Database.Filename)
-	fmt.Printf("Peer Address: %s:%d\n", cfg.Peer.Host, cfg.Peer.Port)
-	fmt.Printf("Listen Port: %d\n", cfg.ListenPort)
+	if cfg.Peer.Relay != "" {
+		fmt.Printf("Peer Relay: %s\n", cfg.Peer.Relay)
+	} else {
+		fmt.Printf("Peer Address: %s:%d\n", cfg.Peer.Host, cfg.Peer.Port)
+		fmt.Printf("Listen Port: %d\n", cfg.ListenPort)
+	}
 
 	// Zero-knowledge protocols are ALWAYS enabled - no toggleable options
 	fmt.Printf("Zero-Knowledge Protocol: ALWAYS ENABLED\n")
 	fmt.Printf("Absolute zero information leakage guaranteed\n")
 	fmt.Println()
 
-	// Create temp directory for this session
-	tempDir := fmt.Sprintf("temp-workflow-%d", time.Now().Unix())
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		log.Fatalf("Failed to create temp directory: %v", err)
+	run := startRun("pprl", cfg)
+	if cfg.Peer.Relay != "" {
+		run.Parameters["relay"] = cfg.Peer.Relay
+	} else {
+		run.Parameters["peer"] = net.JoinHostPort(cfg.Peer.Host, strconv.Itoa(cfg.Peer.Port))
+		run.Parameters["listen_port"] = strconv.Itoa(cfg.ListenPort)
+	}
+	run.Parameters["hamming_threshold"] = strconv.FormatUint(uint64(cfg.Matching.HammingThreshold), 10)
+	run.Parameters["jaccard_threshold"] = strconv.FormatFloat(cfg.Matching.JaccardThreshold, 'g', -1, 64)
+	run.Parameters["assignment"] = cfg.Matching.Assignment
+	if comparator, _ := resolveComparator("", cfg); comparator != "" {
+		run.Parameters["comparator"] = comparator
+	}
+	run.Parameters["allow_duplicates"] = strconv.FormatBool(allowDuplicates)
+	run.Parameters["resume"] = strconv.FormatBool(resume)
+	if batchRun := os.Getenv(batchRunEnvVar); batchRun != "" {
+		run.Parameters["batch_run"] = batchRun
+	}
+	run.AddInput(cfg.Database.Filename)
+	startMetricsListener(cfg)
+
+	// fail records the failed run before exiting; os.Exit skips deferred calls, so it removes the
+	// workspace itself
+	closeWorkspace := func() {}
+	fail := func(category errorCategory, format string, args ...interface{}) {
+		recordRun(run, fmt.Errorf(format, args...))
+		closeWorkspace()
+		fatalf(category, format, args...)
+	}
+
+	// Resolve the tokenization recipe before leaving the working directory
+	recordConfig, err := newRecordConfig(cfg.Tokenization, cfg.Keys)
+	if err != nil {
+		fail(ConfigError, "Invalid tokenization recipe: %v", err)
+	}
+	if recordConfig.Columns, err = newColumnMapping(cfg); err != nil {
+		fail(ConfigError, "Invalid column mapping: %v", err)
+	}
+	if recordConfig.IDColumns, err = newIDColumns(cfg); err != nil {
+		fail(ConfigError, "Invalid ID columns: %v", err)
+	}
+	if recordConfig.MultiValue, err = newMultiValueColumns(cfg); err != nil {
+		fail(ConfigError, "Invalid multi-valued columns: %v", err)
+	}
+	if recordConfig.Sanitizer, err = newSanitizer(cfg); err != nil {
+		fail(ConfigError, "Invalid input sanitization: %v", err)
+	}
+	run.Parameters["id_mode"] = recordConfig.IDs.Mode()
+
+	// The holdout is read before leaving the working directory and stays local
+	holdout, err := loadHoldout(cfg.Matching.HoldoutFile, recordConfig.IDColumns)
+	if err != nil {
+		fail(DataError, "Invalid holdout: %v", err)
+	}
+	if holdout != nil {
+		fmt.Printf("Holdout: %d known pairs in %s (scored locally, never sent)\n", len(holdout), cfg.Matching.HoldoutFile)
+		run.AddInput(cfg.Matching.HoldoutFile)
+	}
+	if recordConfig.Columns != nil {
+		run.Parameters["column_mapping"] = recordConfig.Columns.String()
+	}
+	if recordConfig.MultiValue != nil {
+		run.Parameters["multi_value_columns"] = recordConfig.MultiValue.String()
+	}
+	localRecipe, err := newRecipeHandshake(cfg, recordConfig)
+	if err != nil {
+		fail(ConfigError, "Invalid peer configuration: %v", err)
+	}
+	secureComparison := cfg.Matching.Protocol == crypto.ProtocolSMC
+	if secureComparison {
+		if err := checkSecureComparison(cfg); err != nil {
+			fail(ConfigError, "Invalid matching configuration: %v", err)
+		}
+	}
+	exactOnly, exactFirstPass := cfg.Matching.Protocol == crypto.ProtocolPSI, usesExactFirstPass(cfg)
+	comparator, _ := resolveComparator("", cfg)
+	if comparator != "" && (secureComparison || exactOnly) {
+		fmt.Printf("Warning: matching.comparator is ignored with matching.protocol %s\n", cfg.Matching.Protocol)
+	}
+	if cfg.Matching.Reconcile {
+		// Reconciliation re-compares pairs by distance, so it cannot stand in for a calibrated
+		// threshold, and needs the peer's tokens, which secure comparison never sends
+		switch {
+		case cfg.Matching.ProbabilityThreshold > 0:
+			fmt.Printf("Warning: matching.reconcile is ignored with matching.probability_threshold\n")
+		case secureComparison, exactOnly:
+			fmt.Printf("Warning: matching.reconcile is ignored with matching.protocol %s\n", cfg.Matching.Protocol)
+		case exactFirstPass:
+			fmt.Printf("Warning: matching.reconcile is ignored with matching.exact_first_pass\n")
+		case comparator != "":
+			// Disputed pairs are re-compared by their Bloom filters
+			fmt.Printf("Warning: matching.reconcile is ignored with matching.comparator %s\n", comparator)
+		default:
+			localRecipe.Reconcile = newReconcileOffer(cfg, allowDuplicates)
+		}
 	}
-	defer func() {
-		if !isDebugMode() {
-			os.RemoveAll(tempDir)
+	run.Parameters["reconcile"] = strconv.FormatBool(localRecipe.Reconcile != nil)
+	run.Parameters["protocol"] = cfg.Matching.Protocol
+	run.Parameters["exact_first_pass"] = strconv.FormatBool(exactFirstPass)
+
+	// Resolve the transcript and calibration paths before leaving the working directory
+	transcriptFile := cfg.Logging.TranscriptFile
+	if transcriptFile != "" {
+		if abs, err := filepath.Abs(transcriptFile); err == nil {
+			transcriptFile = abs
 		}
-	}()
+	}
+	if cfg.Matching.CalibrationFile != "" {
+		if abs, err := filepath.Abs(cfg.Matching.CalibrationFile); err == nil {
+			cfg.Matching.CalibrationFile = abs
+		}
+	}
+	for _, path := range []*string{&cfg.Database.Filename, &cfg.Peer.TLSCertFile, &cfg.Peer.TLSKeyFile, &cfg.Peer.TLSCAFile, &cfg.Peer.SigningKeyFile, &cfg.Logging.AuditFile} {
+		if *path != "" {
+			if abs, err := filepath.Abs(*path); err == nil {
+				*path = abs
+			}
+		}
+	}
+
+	// Rejected peers are recorded by the audit subsystem (logging.enable_audit)
+	if cfg.Logging.EnableAudit {
+		if cfg.Logging.AuditFile == "" {
+			if abs, err := filepath.Abs("audit.log"); err == nil {
+				cfg.Logging.AuditFile = abs
+			}
+		}
+		if err := server.InitLogger(cfg, run.ID); err != nil {
+			fail(DataError, "Failed to open audit log: %v", err)
+		}
+	}
+	auth, err := newPeerAuth(cfg)
+	if err != nil {
+		fail(ConfigError, "Invalid peer authentication: %v", err)
+	}
+	run.Parameters["peer_auth"] = auth.methods()
+	signingKeys, err := loadIntersectionKeys(cfg)
+	if err != nil {
+		fail(CryptoError, "Invalid intersection signing keys: %v", err)
+	}
+
+	// Generate dynamic output file names based on input file
+	inputFileName := strings.TrimSuffix(filepath.Base(cfg.Database.Filename), filepath.Ext(cfg.Database.Filename))
+	inputFileName = strings.ReplaceAll(inputFileName, "-", "_")
+	inputFileName = strings.ReplaceAll(inputFileName, " ", "_")
+
+	// Intersection progress is checkpointed in the output directory so an interrupted run can be resumed
+	outputDir, err = filepath.Abs(outputDir)
+	if err != nil {
+		fail(DataError, "Failed to resolve output directory: %v", err)
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		fail(DataError, "Failed to create output directory: %v", err)
+	}
+	checkpointBase := filepath.Join(outputDir, fmt.Sprintf("intersection_results_%s", inputFileName))
+	resumeTokensFile := checkpointBase + ".tokens"
 
-	// Change to temp directory
+	// Intermediate files go to a workspace of this session (under workspace.dir), securely
+	// removed when the workflow ends unless it is kept for debugging
+	ws, err := newWorkspace(cfg, "pprl")
+	if err != nil {
+		fail(DataError, "%v", err)
+	}
 	originalDir, _ := os.Getwd()
-	defer os.Chdir(originalDir)
-	os.Chdir(tempDir)
+	closeWorkspace = func() {
+		os.Chdir(originalDir)
+		ws.Close()
+	}
+	defer closeWorkspace()
+	os.Chdir(ws.Dir)
 
 	// STEP 1: Read the config file (already done)
 	fmt.Println("STEP 1: Configuration Loaded")
 	fmt.Printf("   Config file processed successfully\n")
-	fmt.Printf("   Hamming threshold: %d\n", cfg.Matching.HammingThreshold)
-	fmt.Printf("   Jaccard threshold: %.3f\n", cfg.Matching.JaccardThreshold)
+	fmt.Printf("   Hamming threshold: %d (%s)\n", thresholds.Hamming, thresholds.HammingSource)
+	fmt.Printf("   Jaccard threshold: %.3f (%s)\n", thresholds.Jaccard, thresholds.JaccardSource)
 	fmt.Println()
 
 	// STEP 2: Tokenize the dataset if not already tokenized
 	fmt.Println("STEP 2: Dataset Tokenization")
-	tokenizedFile, err := performTokenizationStep(cfg)
+	tokenizedFile, err := performTokenizationStep(cfg, recordConfig, "tokenized_data.csv", run)
 	if err != nil {
-		log.Fatalf("Tokenization failed: %v", err)
+		fail(DataError, "Tokenization failed: %v", err)
 	}
 	fmt.Printf("   Tokenized data ready: %s\n", tokenizedFile)
 	fmt.Println()
@@ -103,27 +299,105 @@ func runUnifiedWorkflow(cfg *config.Config, force, allowDuplicates bool) {
 
 	// STEP 3: Establish connection with peer
 	fmt.Println("STEP 3: Establishing Peer Connection")
-	conn, isServer, err := establishPeerConnection(cfg)
+
+	// Each logical message is recorded to the transcript, whatever the transport
+	var onMessage func(sent bool, message []byte)
+	if transcriptFile != "" {
+		recorder, err := transcript.NewRecorder(transcriptFile)
+		if err != nil {
+			fail(DataError, "Failed to start transcript: %v", err)
+		}
+		defer recorder.Close()
+		onMessage = func(sent bool, message []byte) {
+			direction := transcript.DirectionReceived
+			if sent {
+				direction = transcript.DirectionSent
+			}
+			if err := recorder.Record(direction, message); err != nil {
+				fmt.Printf("   Warning: failed to record transcript entry: %v\n", err)
+			}
+		}
+		fmt.Printf("   Recording message transcript: %s\n", transcriptFile)
+	}
+
+	if auth == nil {
+		fmt.Printf("   Warning: no peer authentication configured (peer.api_key or peer.allowed_peers)\n")
+	} else {
+		fmt.Printf("   Peer authentication: %s\n", auth.methods())
+	}
+	transport, err := connectPeer(cfg, auth, onMessage)
 	if err != nil {
-		log.Fatalf("Failed to establish peer connection: %v", err)
+		fail(PeerError, "Failed to establish peer connection: %v", err)
 	}
-	defer conn.Close()
+	defer transport.Close()
+	isServer := transport.IsServer()
+	run.Parameters["transport"] = cfg.Peer.Transport
 
 	if isServer {
-		fmt.Printf("   Connected as server (listening on port %d)\n", cfg.ListenPort)
+		fmt.Printf("   Connected as server (listening on port %d, %s transport)\n", cfg.ListenPort, cfg.Peer.Transport)
 	} else {
-		fmt.Printf("   Connected as client to %s:%d\n", cfg.Peer.Host, cfg.Peer.Port)
+		fmt.Printf("   Connected as client to %s:%d (%s transport)\n", cfg.Peer.Host, cfg.Peer.Port, cfg.Peer.Transport)
 	}
 	fmt.Println()
 
 	// STEP 4: Exchange tokens with peer
 	fmt.Println("STEP 4: Token Exchange")
-	localTokens, peerTokens, err := exchangeTokens(conn, tokenizedFile, isServer)
+	localTokens, err := loadTokenizedData(tokenizedFile)
+	if err != nil {
+		fail(DataError, "Failed to load local tokens: %v", err)
+	}
+	realRecords := len(localTokens.Records)
+	if exactOnly || exactFirstPass {
+		if err := checkExactPSI(cfg, localTokens); err != nil {
+			fail(ConfigError, "Invalid matching configuration: %v", err)
+		}
+	}
+	tokensSource := inputsDigest(tokenDigest(localTokens), localRecipe.Fingerprint)
+
+	// A resumed run resends the tokens of the interrupted one, so the peer's checkpoint still applies
+	var decoys map[string]bool
+	resumed := false
+	if resume {
+		if saved, savedDecoys, err := loadResumeTokens(resumeTokensFile, tokensSource); err == nil {
+			localTokens, decoys, resumed = saved, savedDecoys, true
+			fmt.Printf("   Resending the tokens of the interrupted run\n")
+		} else if !os.IsNotExist(err) {
+			fmt.Printf("   Not reusing saved tokens: %v\n", err)
+		}
+	}
+	if !resumed {
+		decoys, err = padLocalTokens(localTokens, cfg)
+		if err != nil {
+			fail(InternalError, "Failed to pad local tokens: %v", err)
+		}
+		if err := saveResumeTokens(resumeTokensFile, tokensSource, localTokens, decoys); err != nil {
+			fail(DataError, "Failed to save tokens for resuming: %v", err)
+		}
+	}
+	// Under secure comparison and exact identifier PSI only record IDs are sent; the filters or
+	// identifiers are compared in STEP 5. Exact identifiers never leave this party.
+	sentTokens := withoutExactIDs(localTokens)
+	switch {
+	case secureComparison:
+		sentTokens = secureComparisonTokens(localTokens)
+		fmt.Printf("   Secure comparison: sending record IDs only, Bloom filters stay local\n")
+	case exactOnly:
+		sentTokens = secureComparisonTokens(localTokens)
+		fmt.Printf("   Exact identifier PSI: sending record IDs only, Bloom filters stay local\n")
+	}
+	peerTokens, err := transport.ExchangeTokens(localRecipe, sentTokens)
 	if err != nil {
-		log.Fatalf("Token exchange failed: %v", err)
+		fail(PeerError, "Token exchange failed: %v", err)
+	}
+	if len(decoys) > 0 {
+		fmt.Printf("   Local tokens: %d records (%d real, %d decoys)\n", len(localTokens.Records), realRecords, len(decoys))
+	} else {
+		fmt.Printf("   Local tokens: %d records\n", len(localTokens.Records))
 	}
-	fmt.Printf("   Local tokens: %d records\n", len(localTokens.Records))
 	fmt.Printf("   Peer tokens: %d records\n", len(peerTokens.Records))
+	run.Counts["local_records"] = realRecords
+	run.Counts["decoy_records"] = len(decoys)
+	run.Counts["peer_records"] = len(peerTokens.Records)
 	fmt.Println()
 
 	// STEP 5: Compute intersection using thresholds from config
@@ -135,130 +409,469 @@ func runUnifiedWorkflow(cfg *config.Config, force, allowDuplicates bool) {
 		party = 1
 	}
 
-	intersection, err := computeZeroKnowledgeIntersection(localTokens, peerTokens, cfg, party, allowDuplicates)
-	if err != nil {
-		log.Fatalf("Intersection computation failed: %v", err)
+	// Records sharing an exact identifier are linked first, and only the rest are fuzzy-matched
+	var exactMatches *IntersectionResult
+	fuzzyLocal, fuzzyPeer := localTokens, peerTokens
+	if exactOnly || exactFirstPass {
+		exactMatches, err = computeExactIntersection(transport, localTokens, cfg, party, allowDuplicates)
+		if err != nil {
+			fail(InternalError, "Intersection computation failed: %v", err)
+		}
+		run.Counts["exact_matches"] = len(exactMatches.Matches)
+		if exactFirstPass {
+			fuzzyLocal, fuzzyPeer = withoutLinked(localTokens, peerTokens, exactMatches)
+			fmt.Printf("   Fuzzy matching the remaining %d local and %d peer records\n", len(fuzzyLocal.Records), len(fuzzyPeer.Records))
+		}
 	}
 
+	var intersection *IntersectionResult
+	switch {
+	case exactOnly:
+		intersection = exactMatches
+	case secureComparison:
+		// Both parties run the protocol's rounds together, so there is no local progress to checkpoint
+		intersection, err = computeSMCIntersection(transport, fuzzyLocal, cfg, party, allowDuplicates)
+		if err != nil {
+			fail(InternalError, "Intersection computation failed: %v", err)
+		}
+	default:
+		checkpoint, err := openCheckpoint(checkpointBase, workflowInputsDigest(fuzzyLocal, fuzzyPeer, localRecipe, cfg, party), resume)
+		if err != nil {
+			fail(DataError, "Failed to open checkpoint: %v", err)
+		}
+		defer checkpoint.Close()
+
+		intersection, err = computeZeroKnowledgeIntersection(fuzzyLocal, fuzzyPeer, cfg, party, allowDuplicates, checkpoint)
+		if err != nil {
+			fail(InternalError, "Intersection computation failed: %v", err)
+		}
+		checkpoint.Remove()
+	}
+	if exactFirstPass {
+		intersection.Matches = append(exactMatches.Matches, intersection.Matches...)
+	}
+	os.Remove(resumeTokensFile)
+
 	fmt.Printf("   Found %d matches using zero-knowledge protocols\n", len(intersection.Matches))
+	run.Counts["matches"] = len(intersection.Matches)
 	fmt.Printf("   Zero information leaked beyond intersection result\n")
 
 	// Save local intersection
 	localIntersectionFile := "local_intersection.json"
 	if err := saveWorkflowIntersectionResults(intersection, localIntersectionFile); err != nil {
-		log.Fatalf("Failed to save local intersection: %v", err)
+		fail(DataError, "Failed to save local intersection: %v", err)
 	}
 	fmt.Printf("   Local intersection saved: %s\n", localIntersectionFile)
 	fmt.Println()
 
 	// STEP 6: Exchange intersection results for comparison
 	fmt.Println("STEP 6: Exchanging Intersection Results")
-	peerIntersection, err := exchangeIntersectionResults(conn, intersection, isServer)
+
+	// Salted digests first: when they agree, neither party discloses its full results
+	digestsMatch, err := verifyIntersectionDigest(transport, intersection)
 	if err != nil {
-		log.Fatalf("Intersection exchange failed: %v", err)
+		fail(PeerError, "Intersection exchange failed: %v", err)
+	}
+	var peerIntersection *IntersectionResult
+	if digestsMatch {
+		run.Parameters["intersection_verification"] = "digest"
+	} else {
+		run.Parameters["intersection_verification"] = "full"
+		if signingKeys.signing != nil {
+			if err := signingKeys.signIntersection(intersection, localRecipe.Fingerprint, peerTokens); err != nil {
+				fail(CryptoError, "Failed to sign local intersection: %v", err)
+			}
+			fmt.Printf("   Signed local intersection (key %s)\n", intersection.Signature.KeyID)
+		}
+		peerIntersection, err = transport.ExchangeIntersection(intersection)
+		if err != nil {
+			fail(PeerError, "Intersection exchange failed: %v", err)
+		}
+		fmt.Printf("   Received peer intersection (%d matches)\n", len(peerIntersection.Matches))
+
+		// With a pinned peer key, results that are unsigned or fail verification are not accepted
+		switch {
+		case signingKeys.peerPublic != nil:
+			if err := signingKeys.verifyIntersection(peerIntersection, localRecipe.Fingerprint, sentTokens); err != nil {
+				server.Audit("intersection_rejected", map[string]interface{}{"run_id": run.ID, "reason": err.Error()})
+				fail(CryptoError, "Rejected peer intersection: %v", err)
+			}
+			fmt.Printf("   Peer intersection signature verified (key %s)\n", peerIntersection.Signature.KeyID)
+			run.Parameters["peer_signature"] = "verified"
+		case peerIntersection.Signature != nil:
+			fmt.Printf("   Peer intersection is signed (key %s) but not verified: no peer.peer_public_key is pinned\n", peerIntersection.Signature.KeyID)
+			run.Parameters["peer_signature"] = "unverified"
+		default:
+			run.Parameters["peer_signature"] = "none"
+		}
 	}
-	fmt.Printf("   Received peer intersection (%d matches)\n", len(peerIntersection.Matches))
 	fmt.Println()
 
 	// STEP 7: Compare results and create diff if needed
 	fmt.Println("STEP 7: Comparing Intersection Results")
-	resultsMatch, diffFile, err := compareIntersectionResults(intersection, peerIntersection)
-	if err != nil {
-		log.Fatalf("Result comparison failed: %v", err)
+	resultsMatch, diffFile := true, ""
+	if !digestsMatch {
+		resultsMatch, diffFile, err = compareIntersectionResults(intersection, peerIntersection)
+		if err != nil {
+			fail(InternalError, "Result comparison failed: %v", err)
+		}
 	}
 
-	// Generate dynamic output file names based on input file
-	inputFileName := strings.TrimSuffix(filepath.Base(cfg.Database.Filename), filepath.Ext(cfg.Database.Filename))
-	inputFileName = strings.ReplaceAll(inputFileName, "-", "_")
-	inputFileName = strings.ReplaceAll(inputFileName, " ", "_")
-
 	resultsFileName := fmt.Sprintf("intersection_results_%s.json", inputFileName)
 	diffFileName := fmt.Sprintf("intersection_diff_%s.json", inputFileName)
+	reconcileFileName := fmt.Sprintf("intersection_reconciliation_%s.json", inputFileName)
+
+	// saveDiff copies the diff to the output directory (use original directory path)
+	saveDiff := func() {
+		fmt.Printf("   Diff file created: %s\n", diffFile)
+		diffOutputPath := filepath.Join(outputDir, diffFileName)
+		if err := copyToAbsolutePath(diffFile, diffOutputPath); err != nil {
+			fmt.Printf("   Warning: Failed to copy diff to output: %v\n", err)
+		} else {
+			fmt.Printf("   Diff saved to: %s\n", diffOutputPath)
+			run.AddOutput(diffOutputPath)
+		}
+	}
+
+	// With reconciliation agreed, the differing pairs are re-compared rather than failing the run
+	reconciled := false
+	if !resultsMatch && localRecipe.agreedReconcile != nil {
+		fmt.Println("   Intersection results differ; reconciling the differing pairs with the peer")
+		saveDiff()
+		reconciledIntersection, report, err := reconcileWithPeer(transport, intersection, peerIntersection, localTokens, peerTokens, localRecipe.agreedReconcile, party)
+		if report != nil {
+			reportPath := filepath.Join(outputDir, reconcileFileName)
+			if err := saveJSONFile(report, reportPath); err != nil {
+				fmt.Printf("   Warning: Failed to save reconciliation report: %v\n", err)
+			} else {
+				fmt.Printf("   Reconciliation report saved to: %s\n", reportPath)
+				run.AddOutput(reportPath)
+			}
+			run.Counts["reconciled_accepted"] = len(report.Accepted)
+			run.Counts["reconciled_rejected"] = len(report.Rejected)
+		}
+		if err != nil {
+			fail(PeerError, "Reconciliation failed: %v", err)
+		}
+		intersection = reconciledIntersection
+		if err := saveWorkflowIntersectionResults(intersection, localIntersectionFile); err != nil {
+			fail(DataError, "Failed to save reconciled intersection: %v", err)
+		}
+		run.Parameters["intersection_verification"] = "reconciled"
+		resultsMatch, reconciled = true, true
+	}
 
 	if resultsMatch {
-		fmt.Println("   SUCCESS: Intersection results match between peers!")
-		fmt.Println("   Both peers computed identical intersections")
+		if reconciled {
+			fmt.Println("   SUCCESS: Both peers signed off on the reconciled intersection")
+		} else {
+			fmt.Println("   SUCCESS: Intersection results match between peers!")
+			fmt.Println("   Both peers computed identical intersections")
+		}
+
+		// Both peers compare the padded intersections; decoys are dropped only from the local results
+		if removed := removeDecoyMatches(intersection, decoys); removed > 0 {
+			intersection.Signature = nil // The signature covered the padded intersection
+			fmt.Printf("   Removed %d matches involving local decoys\n", removed)
+			if err := saveWorkflowIntersectionResults(intersection, localIntersectionFile); err != nil {
+				fail(DataError, "Failed to save local intersection: %v", err)
+			}
+		}
+		run.Counts["matches"] = len(intersection.Matches)
+
+		if holdout != nil {
+			quality, err := scoreHoldout(intersection.Matches, holdout, cfg.Matching.HoldoutFile, recordConfig.IDs)
+			if err != nil {
+				fmt.Printf("   Warning: failed to score the holdout: %v\n", err)
+			} else {
+				run.Quality = quality
+				fmt.Printf("   Holdout quality estimate: precision %.3f, recall %.3f, F1 %.3f (TP %d, FP %d, FN %d)\n",
+					quality.Precision, quality.Recall, quality.F1Score, quality.TruePositives, quality.FalsePositives, quality.FalseNegatives)
+			}
+		}
 
 		// Copy results to output directory (use original directory path)
-		outputPath := filepath.Join(originalDir, "out", resultsFileName)
+		outputPath := filepath.Join(outputDir, resultsFileName)
 		if err := copyToAbsolutePath(localIntersectionFile, outputPath); err != nil {
 			fmt.Printf("   Warning: Failed to copy results to output: %v\n", err)
 		} else {
-			fmt.Printf("   Results saved to: out/%s\n", resultsFileName)
+			fmt.Printf("   Results saved to: %s\n", outputPath)
+			run.AddOutput(outputPath)
+		}
+		if cfg.Output.Format == "postgres" {
+			table, err := saveWorkflowResultsToPostgres(intersection, cfg, run.ID)
+			if err != nil {
+				fail(DataError, "Failed to load results into Postgres: %v", err)
+			}
+			fmt.Printf("   Results copied into: %s\n", table)
+			run.Outputs = append(run.Outputs, table)
 		}
 	} else {
 		fmt.Println("   ERROR: Intersection results DO NOT match between peers!")
-		fmt.Printf("   Diff file created: %s\n", diffFile)
-
-		// Copy diff to output directory (use original directory path)
-		diffOutputPath := filepath.Join(originalDir, "out", diffFileName)
-		if err := copyToAbsolutePath(diffFile, diffOutputPath); err != nil {
-			fmt.Printf("   Warning: Failed to copy diff to output: %v\n", err)
-		} else {
-			fmt.Printf("   Diff saved to: out/%s\n", diffFileName)
-		}
+		saveDiff()
 
-		log.Fatalf("Workflow failed: Intersection results do not match")
+		fail(PeerError, "Workflow failed: Intersection results do not match")
 	}
 
+	recordRun(run, nil)
+
 	fmt.Println()
 	fmt.Println("UNIFIED PPRL WORKFLOW COMPLETED SUCCESSFULLY!")
 	fmt.Println("============================================")
-	fmt.Printf("Results available in: out/\n")
-	if isDebugMode() {
-		fmt.Printf("Debug files preserved in: %s/\n", tempDir)
-	}
+	fmt.Printf("Results available in: %s\n", outputDir)
 }
 
-// performTokenizationStep handles tokenization if needed
-func performTokenizationStep(cfg *config.Config) (string, error) {
+// performTokenizationStep tokenizes the dataset into tokenizedFile unless it is already tokenized,
+// and returns the tokens' file
+func performTokenizationStep(cfg *config.Config, recordConfig *pprl.RecordConfig, tokenizedFile string, run *store.Run) (string, error) {
 	if cfg.Database.IsTokenized {
 		fmt.Printf("   Using pre-tokenized data: %s\n", cfg.Database.Filename)
-		return filepath.Join("..", cfg.Database.Filename), nil
+		// The tokens are announced to the peer under this config's recipe, so they must match it
+		if _, err := checkTokenFormat(cfg.Database.Filename, cfg.RecipeFingerprint(recordConfig.LinkageSecret), cfg.RecipeSummary()); err != nil {
+			return "", err
+		}
+		return cfg.Database.Filename, nil
 	}
 
 	fmt.Printf("   Tokenizing dataset: %s\n", cfg.Database.Filename)
 	fmt.Printf("   Fields: %s\n", strings.Join(cfg.Database.Fields, ", "))
+	if recordConfig.Columns != nil {
+		fmt.Printf("   Column Mapping: %s\n", recordConfig.Columns)
+	}
+	if recordConfig.MultiValue != nil {
+		fmt.Printf("   Multi-valued Columns: %s\n", recordConfig.MultiValue)
+	}
+	if recordConfig.IDs != nil {
+		fmt.Printf("   Record IDs: %s (mapping to original IDs kept in %s)\n", recordConfig.IDs.Mode(), recordConfig.IDs.MapFile())
+	}
 
-	tokenizedFile := "tokenized_data.csv"
-	inputPath := filepath.Join("..", cfg.Database.Filename)
+	inputPath := cfg.Database.Filename
+	inputFormat := detectInputFormat(inputPath) // CSV, JSON (arrays are multi-valued fields) or HL7
 
 	// Parse fields with normalization configuration
 	fields, normalizationConfig := parseFieldsWithNormalization(cfg.Database.Fields)
 
 	// Use shared tokenization function from tokenize.go
-	err := performTokenization(
-		inputPath,                          // inputFile
-		tokenizedFile,                      // outputFile
-		"csv",                              // inputFormat
-		"csv",                              // outputFormat
-		1000,                               // batchSize
-		"0PsRm4KNmgRSY8ynApUtpXjeO19S7OUE", // minHashSeed
-		false,                              // useDatabase
-		fields,                             // fields
-		"",                                 // encryptionKey (empty = no encryption)
-		"",                                 // keyFile (empty)
-		true,                               // noEncryption (true for PPRL workflow)
-		normalizationConfig,                // normalizationConfig
+	tokenized, err := performTokenization(
+		inputPath,             // inputFile
+		tokenizedFile,         // outputFile
+		inputFormat,           // inputFormat
+		cfg.Database.CSV,      // dialect
+		"csv",                 // outputFormat
+		1000,                  // batchSize
+		recordConfig,          // recordConfig
+		false,                 // useDatabase
+		fields,                // fields
+		keys.EncryptOptions{}, // encryption (none)
+		"",                    // keyFile (empty)
+		true,                  // noEncryption (true for PPRL workflow)
+		normalizationConfig,   // normalizationConfig
+		nil,                   // memory (no limit)
+		run,                   // run (missing-data counts)
 	)
 
 	if err != nil {
 		return "", fmt.Errorf("tokenization failed: %v", err)
 	}
+	run.Counts["records"] = tokenized
 
 	return tokenizedFile, nil
 }
 
+// peerLink is an established peer connection and the means to re-establish it
+type peerLink struct {
+	conn     net.Conn
+	isServer bool
+	redial   transfer.Dialer
+	listener net.Listener // Kept open by the listening side so the peer can reconnect
+}
+
+// Close stops accepting reconnections; the connection itself belongs to the transfer channel
+func (l *peerLink) Close() {
+	if l.listener != nil {
+		l.listener.Close()
+	}
+}
+
+// peerTransport carries the workflow's messages to the peer
+type peerTransport interface {
+	// IsServer reports whether this party listened for the peer rather than dialing it
+	IsServer() bool
+	// ExchangeTokens verifies the peer's recipe handshake and swaps tokens with the peer
+	ExchangeTokens(localRecipe *RecipeHandshake, localTokens *TokenData) (*TokenData, error)
+	// ExchangeIntersection swaps the intersections both parties computed
+	ExchangeIntersection(local *IntersectionResult) (*IntersectionResult, error)
+	// CompareIntersectionDigest swaps intersection digests with the peer; it returns nil without
+	// contacting the peer unless both handshakes offered digest comparison
+	CompareIntersectionDigest(local *IntersectionDigest) (*IntersectionDigest, error)
+	// ConfirmReconciliation swaps digests of the reconciled intersections; it is called only when
+	// both handshakes offered reconciliation and the exchanged intersections differed
+	ConfirmReconciliation(local *IntersectionDigest) (*IntersectionDigest, error)
+	// ExchangeSecureMessage swaps one round's messages of the secure comparison protocol; it is
+	// called only when both handshakes selected it (matching.protocol: smc)
+	ExchangeSecureMessage(local []byte) ([]byte, error)
+	Close()
+}
+
+// connectPeer connects to the peer over the configured transport: grpc (default) or the
+// legacy JSON-over-TCP protocol (tcp)
+func connectPeer(cfg *config.Config, auth *peerAuth, onMessage func(sent bool, message []byte)) (peerTransport, error) {
+	switch strings.ToLower(cfg.Peer.Transport) {
+	case "", "grpc":
+		cfg.Peer.Transport = "grpc"
+		return connectGRPCPeer(cfg, auth, onMessage)
+	case "tcp":
+		link, err := establishPeerConnection(cfg, auth)
+		if err != nil {
+			return nil, err
+		}
+		options := peerTransferOptions(cfg)
+		options.OnMessage = onMessage
+		transport := &tcpPeerTransport{
+			link:                link,
+			channel:             transfer.NewChannel(link.conn, link.redial, options),
+			tokenTimeout:        cfg.Timeouts.TokenExchange,
+			intersectionTimeout: cfg.Timeouts.IntersectionExchange,
+		}
+		deadline := transport.startStep(cfg.Timeouts.HandshakeTimeout)
+		transport.peer, err = exchangePeerHello(transport.channel, cfg, link.isServer)
+		if err = peerStepError("version hello", cfg.Timeouts.HandshakeTimeout, deadline, err); err != nil {
+			transport.Close()
+			return nil, err
+		}
+		return transport, nil
+	}
+	return nil, fmt.Errorf("unknown peer.transport %q (use grpc or tcp)", cfg.Peer.Transport)
+}
+
+// tcpPeerTransport is the legacy transport: newline-delimited JSON PeerMessages over a chunked TCP channel
+type tcpPeerTransport struct {
+	link    *peerLink
+	channel *transfer.Channel
+	recipe  *RecipeHandshake // Set by ExchangeTokens
+	peer    *peerRelease     // From the peer's version hello
+
+	tokenTimeout        time.Duration
+	intersectionTimeout time.Duration
+}
+
+func (t *tcpPeerTransport) IsServer() bool {
+	return t.link.isServer
+}
+
+func (t *tcpPeerTransport) ExchangeTokens(localRecipe *RecipeHandshake, localTokens *TokenData) (*TokenData, error) {
+	t.peer.downgrade(localRecipe)
+	t.recipe = localRecipe
+	deadline := t.startStep(t.tokenTimeout)
+	_, peerTokens, err := exchangeTokens(t.channel, localTokens, localRecipe, t.link.isServer)
+	return peerTokens, peerStepError("token exchange", t.tokenTimeout, deadline, err)
+}
+
+func (t *tcpPeerTransport) ExchangeIntersection(local *IntersectionResult) (*IntersectionResult, error) {
+	deadline := t.startStep(t.intersectionTimeout)
+	peerIntersection, err := exchangeIntersectionResults(t.channel, local, t.link.isServer)
+	return peerIntersection, peerStepError("intersection exchange", t.intersectionTimeout, deadline, err)
+}
+
+func (t *tcpPeerTransport) CompareIntersectionDigest(local *IntersectionDigest) (*IntersectionDigest, error) {
+	if t.recipe == nil || !t.recipe.digestAgreed {
+		return nil, nil
+	}
+	deadline := t.startStep(t.intersectionTimeout)
+	peerDigest, err := exchangeIntersectionDigest(t.channel, local, t.link.isServer)
+	return peerDigest, peerStepError("intersection exchange", t.intersectionTimeout, deadline, err)
+}
+
+func (t *tcpPeerTransport) ConfirmReconciliation(local *IntersectionDigest) (*IntersectionDigest, error) {
+	if t.recipe == nil || t.recipe.agreedReconcile == nil {
+		return nil, fmt.Errorf("reconciliation was not offered in both handshakes")
+	}
+	deadline := t.startStep(t.intersectionTimeout)
+	peerDigest, err := exchangeReconciledDigest(t.channel, local, t.link.isServer)
+	return peerDigest, peerStepError("intersection exchange", t.intersectionTimeout, deadline, err)
+}
+
+func (t *tcpPeerTransport) ExchangeSecureMessage(local []byte) ([]byte, error) {
+	if t.recipe == nil || t.recipe.Protocol == "" {
+		return nil, fmt.Errorf("no secure matching protocol was selected in both handshakes")
+	}
+	deadline := t.startStep(t.intersectionTimeout)
+	peer, err := swapSecureMessage(t.channel, local, t.link.isServer)
+	return peer, peerStepError("secure comparison", t.intersectionTimeout, deadline, err)
+}
+
+// RoundTrip times one ping round trip; both parties call it in step and each times its own ping
+func (t *tcpPeerTransport) RoundTrip() (time.Duration, error) {
+	deadline := t.startStep(t.intersectionTimeout)
+	elapsed, err := pingPeer(t.channel, t.link.isServer)
+	return elapsed, peerStepError("ping", t.intersectionTimeout, deadline, err)
+}
+
+// startStep bounds the channel's next exchange step by timeout and returns its deadline
+func (t *tcpPeerTransport) startStep(timeout time.Duration) time.Time {
+	deadline := time.Now().Add(timeout)
+	t.channel.SetDeadline(deadline)
+	return deadline
+}
+
+// peerStepError reports a step that failed once its deadline passed as the peer timing out
+func peerStepError(step string, timeout time.Duration, deadline time.Time, err error) error {
+	if err != nil && !time.Now().Before(deadline) {
+		return fmt.Errorf("peer timed out during %s after %s", step, timeout)
+	}
+	return err
+}
+
+func (t *tcpPeerTransport) Close() {
+	t.channel.Close()
+	t.link.Close()
+}
+
 // establishPeerConnection creates a connection between peers
-func establishPeerConnection(cfg *config.Config) (net.Conn, bool, error) {
+func establishPeerConnection(cfg *config.Config, auth *peerAuth) (*peerLink, error) {
+	if cfg.Peer.Relay != "" {
+		return establishRelayConnection(cfg, auth)
+	}
+
 	// First try to connect as client
-	address := fmt.Sprintf("%s:%d", cfg.Peer.Host, cfg.Peer.Port)
+	address := net.JoinHostPort(cfg.Peer.Host, strconv.Itoa(cfg.Peer.Port))
 	fmt.Printf("   Attempting to connect to peer at %s...\n", address)
 
-	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	// dial connects and, with an API key configured, proves it before any data is exchanged
+	dial := func(timeout time.Duration) (net.Conn, error) {
+		conn, err := net.DialTimeout("tcp", address, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if err := auth.authenticateConn(conn, false, cfg.Timeouts.HandshakeTimeout); err != nil {
+			auditPeerAuthFailure(conn.RemoteAddr(), "tcp", err.Error())
+			conn.Close()
+			return nil, &peerAuthError{err}
+		}
+		return conn, nil
+	}
+
+	// Transient failures are retried; a refused connection means the peer is not listening yet,
+	// so this party serves instead
+	var conn net.Conn
+	err := networkRetryPolicy(cfg).Do("Connecting to peer", func() error {
+		var err error
+		conn, err = dial(10 * time.Second)
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return retry.Permanent(err)
+		}
+		return err
+	})
 	if err == nil {
 		fmt.Printf("   Connected as client to %s\n", address)
-		return conn, false, nil
+		redial := func() (net.Conn, error) {
+			return dial(cfg.Timeouts.ConnectionTimeout)
+		}
+		return &peerLink{conn: conn, redial: redial}, nil
+	}
+	var authErr *peerAuthError
+	if errors.As(err, &authErr) {
+		return nil, fmt.Errorf("peer authentication failed: %v", authErr.err)
 	}
 
 	fmt.Printf("   Client connection failed, starting server mode...\n")
@@ -266,38 +879,117 @@ func establishPeerConnection(cfg *config.Config) (net.Conn, bool, error) {
 	// If client connection fails, start as server
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.ListenPort))
 	if err != nil {
-		return nil, false, fmt.Errorf("failed to start server: %v", err)
+		return nil, fmt.Errorf("failed to start server: %v", err)
 	}
-	defer listener.Close()
 
 	fmt.Printf("   Listening for peer connection on port %d...\n", cfg.ListenPort)
 
+	// accept waits for a connection that passes authentication; rejected callers are
+	// audited and dropped, so they cannot take the peer's place
+	accept := func() (net.Conn, error) {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return nil, err
+			}
+			if !auth.allowedAddr(conn.RemoteAddr()) {
+				auditPeerAuthFailure(conn.RemoteAddr(), "tcp", "IP is not in security.allowed_ips")
+				conn.Close()
+				continue
+			}
+			if err := auth.authenticateConn(conn, true, cfg.Timeouts.HandshakeTimeout); err != nil {
+				auditPeerAuthFailure(conn.RemoteAddr(), "tcp", err.Error())
+				conn.Close()
+				continue
+			}
+			return conn, nil
+		}
+	}
+
 	// Accept one connection
-	conn, err = listener.Accept()
+	conn, err = accept()
 	if err != nil {
-		return nil, false, fmt.Errorf("failed to accept connection: %v", err)
+		listener.Close()
+		return nil, fmt.Errorf("failed to accept connection: %v", err)
 	}
 
 	fmt.Printf("   Peer connected from %s\n", conn.RemoteAddr())
-	return conn, true, nil
+
+	// After a network failure the client dials back in; wait a bounded time for it
+	redial := func() (net.Conn, error) {
+		if tcpListener, ok := listener.(*net.TCPListener); ok {
+			tcpListener.SetDeadline(time.Now().Add(cfg.Timeouts.ConnectionTimeout))
+		}
+		return accept()
+	}
+	return &peerLink{conn: conn, isServer: true, redial: redial, listener: listener}, nil
 }
 
-// exchangeTokens handles the bidirectional token exchange
-func exchangeTokens(conn net.Conn, tokenizedFile string, isServer bool) (*TokenData, *TokenData, error) {
-	encoder := json.NewEncoder(conn)
-	decoder := json.NewDecoder(conn)
+// peerAuthError marks a connection the peer answered but failed to authenticate
+type peerAuthError struct {
+	err error
+}
 
-	// Load local tokens
-	localTokens, err := loadTokenizedData(tokenizedFile)
+func (e *peerAuthError) Error() string {
+	return e.err.Error()
+}
+
+// networkRetryPolicy builds the retry policy of the network section
+func networkRetryPolicy(cfg *config.Config) retry.Policy {
+	n := cfg.Network
+	return retry.Policy{
+		Retries:    max(n.Retries, 0),
+		Backoff:    n.Backoff.Initial,
+		MaxBackoff: n.Backoff.Max,
+		Multiplier: n.Backoff.Multiplier,
+		Jitter:     n.Backoff.Jitter,
+	}
+}
+
+// peerTransferOptions builds the chunked transfer settings from the peer configuration. Resuming
+// an interrupted transfer backs off like any other retry, from peer.retry_delay.
+func peerTransferOptions(cfg *config.Config) transfer.Options {
+	resume := networkRetryPolicy(cfg)
+	resume.Retries = max(cfg.Peer.MaxRetries, 0)
+	resume.Backoff = cfg.Peer.RetryDelay
+	return transfer.Options{
+		ChunkSize: cfg.Peer.ChunkSizeKB << 10,
+		Retry:     resume,
+		Timeout:   cfg.Timeouts.ReadTimeout,
+		OnFrame:   countExchangeFrame,
+	}
+}
+
+// sendPeerMessage sends one peer message over the transfer channel
+func sendPeerMessage(channel *transfer.Channel, message PeerMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return channel.Send(data)
+}
+
+// receivePeerMessage receives one peer message from the transfer channel
+func receivePeerMessage(channel *transfer.Channel, message *PeerMessage) error {
+	data, err := channel.Receive()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to load local tokens: %v", err)
+		return err
+	}
+	return json.Unmarshal(data, message)
+}
+
+// exchangeTokens handles the bidirectional token exchange
+func exchangeTokens(channel *transfer.Channel, localTokens *TokenData, localRecipe *RecipeHandshake, isServer bool) (*TokenData, *TokenData, error) {
+	// Verify both parties use the same tokenization recipe before sending any tokens
+	if err := exchangeRecipeHandshake(channel, localRecipe, isServer); err != nil {
+		return nil, nil, err
 	}
 
 	if isServer {
 		// Server: first receive, then send
 		fmt.Printf("   Receiving tokens from peer...\n")
 		var peerMessage PeerMessage
-		if err := decoder.Decode(&peerMessage); err != nil {
+		if err := receivePeerMessage(channel, &peerMessage); err != nil {
 			return nil, nil, fmt.Errorf("failed to receive peer tokens: %v", err)
 		}
 
@@ -309,9 +1001,12 @@ func exchangeTokens(conn net.Conn, tokenizedFile string, isServer bool) (*TokenD
 		if err := mapToStruct(peerMessage.Payload, peerTokens); err != nil {
 			return nil, nil, fmt.Errorf("failed to parse peer tokens: %v", err)
 		}
+		if err := peerMessage.Digest.verify(peerTokens); err != nil {
+			return nil, nil, err
+		}
 
 		fmt.Printf("   Sending local tokens to peer...\n")
-		if err := encoder.Encode(PeerMessage{Type: "tokens", Payload: localTokens}); err != nil {
+		if err := sendPeerMessage(channel, PeerMessage{Type: "tokens", Payload: localTokens, Digest: newTokenDigest(localTokens)}); err != nil {
 			return nil, nil, fmt.Errorf("failed to send local tokens: %v", err)
 		}
 
@@ -319,13 +1014,13 @@ func exchangeTokens(conn net.Conn, tokenizedFile string, isServer bool) (*TokenD
 	} else {
 		// Client: first send, then receive
 		fmt.Printf("   Sending local tokens to peer...\n")
-		if err := encoder.Encode(PeerMessage{Type: "tokens", Payload: localTokens}); err != nil {
+		if err := sendPeerMessage(channel, PeerMessage{Type: "tokens", Payload: localTokens, Digest: newTokenDigest(localTokens)}); err != nil {
 			return nil, nil, fmt.Errorf("failed to send local tokens: %v", err)
 		}
 
 		fmt.Printf("   Receiving tokens from peer...\n")
 		var peerMessage PeerMessage
-		if err := decoder.Decode(&peerMessage); err != nil {
+		if err := receivePeerMessage(channel, &peerMessage); err != nil {
 			return nil, nil, fmt.Errorf("failed to receive peer tokens: %v", err)
 		}
 
@@ -337,11 +1032,202 @@ func exchangeTokens(conn net.Conn, tokenizedFile string, isServer bool) (*TokenD
 		if err := mapToStruct(peerMessage.Payload, peerTokens); err != nil {
 			return nil, nil, fmt.Errorf("failed to parse peer tokens: %v", err)
 		}
+		if err := peerMessage.Digest.verify(peerTokens); err != nil {
+			return nil, nil, err
+		}
 
 		return localTokens, peerTokens, nil
 	}
 }
 
+// newRecipeHandshake describes the local tokenization recipe, the compression this party accepts
+// and, unless peer.payload_encryption is off, its ephemeral payload key
+func newRecipeHandshake(cfg *config.Config, recordConfig *pprl.RecordConfig) (*RecipeHandshake, error) {
+	encodings, err := transfer.ParseCompression(cfg.Peer.Compression)
+	if err != nil {
+		return nil, err
+	}
+	payloadMode, err := transfer.ParsePayloadEncryption(cfg.Peer.PayloadEncryption)
+	if err != nil {
+		return nil, err
+	}
+	recipe := &RecipeHandshake{
+		Fingerprint: cfg.RecipeFingerprint(recordConfig.LinkageSecret),
+		Summary:     cfg.RecipeSummary(),
+		Encodings:   encodings,
+		payloadMode: payloadMode,
+		// A party pinning the peer's key needs its signed intersection, so it always exchanges them
+		IntersectionDigest: cfg.Peer.PeerPublicKey == "",
+	}
+	recipe.Protocol = handshakeProtocol(cfg)
+	if cfg.Timeouts.HeartbeatInterval > 0 {
+		recipe.Heartbeat = cfg.Timeouts.HeartbeatInterval.String()
+	}
+	// The seed and linkage secret are verified equal by the fingerprint and never sent
+	recipe.secret = append([]byte(cfg.Tokenization.Seed+"\x00"), recordConfig.LinkageSecret...)
+	if payloadMode != transfer.PayloadEncryptionOff {
+		if recipe.keys, err = transfer.NewKeyExchange(); err != nil {
+			return nil, err
+		}
+		recipe.PayloadKey = recipe.keys.PublicKey()
+	}
+	return recipe, nil
+}
+
+// exchangeRecipeHandshake swaps recipe fingerprints with the peer and fails if they differ
+func exchangeRecipeHandshake(channel *transfer.Channel, localRecipe *RecipeHandshake, isServer bool) error {
+	send := func() error {
+		if err := sendPeerMessage(channel, PeerMessage{Type: "handshake", Payload: localRecipe}); err != nil {
+			return fmt.Errorf("failed to send recipe handshake: %v", err)
+		}
+		return nil
+	}
+
+	var peerRecipe RecipeHandshake
+	receive := func() error {
+		var peerMessage PeerMessage
+		if err := receivePeerMessage(channel, &peerMessage); err != nil {
+			return fmt.Errorf("failed to receive recipe handshake: %v", err)
+		}
+		if peerMessage.Type != "handshake" {
+			return fmt.Errorf("unexpected message type: %s (peer may be running an older version)", peerMessage.Type)
+		}
+		if err := mapToStruct(peerMessage.Payload, &peerRecipe); err != nil {
+			return fmt.Errorf("failed to parse recipe handshake: %v", err)
+		}
+		return nil
+	}
+
+	// Server receives first, client sends first
+	steps := []func() error{send, receive}
+	if isServer {
+		steps = []func() error{receive, send}
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+
+	if err := verifyRecipe(localRecipe, &peerRecipe); err != nil {
+		return err
+	}
+	agreeIntersectionDigest(localRecipe, &peerRecipe)
+	agreeReconciliation(localRecipe, &peerRecipe)
+
+	// Each side compresses what it sends with the first of its encodings the peer accepts
+	if encoding := transfer.Negotiate(localRecipe.Encodings, peerRecipe.Encodings); encoding != "" {
+		channel.SetEncoding(encoding)
+		fmt.Printf("   Compression: %s\n", encoding)
+	} else {
+		fmt.Printf("   Compression: none\n")
+	}
+
+	confirmed, err := confirmHandshake(localRecipe, &peerRecipe, isServer, func(local *HandshakeConfirm) (*HandshakeConfirm, error) {
+		return exchangeHandshakeConfirm(channel, local, isServer)
+	})
+	if err != nil {
+		return err
+	}
+	sealer, err := agreePayloadEncryption(localRecipe, &peerRecipe, isServer, confirmed)
+	if err != nil {
+		return err
+	}
+	if sealer != nil {
+		channel.SetSealer(sealer)
+	}
+	return startHeartbeat(channel, localRecipe, &peerRecipe)
+}
+
+// heartbeatMisses is how many of the peer's heartbeats may go missing before it counts as lost
+const heartbeatMisses = 3
+
+// startHeartbeat starts heartbeats on the channel when both parties offered an interval; a peer
+// running an older version offers none and would reject them
+func startHeartbeat(channel *transfer.Channel, localRecipe, peerRecipe *RecipeHandshake) error {
+	if localRecipe.Heartbeat == "" || peerRecipe.Heartbeat == "" {
+		fmt.Printf("   Heartbeats: off\n")
+		return nil
+	}
+	interval, err := time.ParseDuration(localRecipe.Heartbeat)
+	if err != nil {
+		return err
+	}
+	peerInterval, err := time.ParseDuration(peerRecipe.Heartbeat)
+	if err != nil || peerInterval <= 0 {
+		return fmt.Errorf("invalid heartbeat interval %q in the peer's handshake", peerRecipe.Heartbeat)
+	}
+	silence := heartbeatMisses * peerInterval
+	channel.StartHeartbeat(interval, silence)
+	fmt.Printf("   Heartbeats: every %s (peer counts as lost after %s of silence)\n", interval, silence)
+	return nil
+}
+
+// agreePayloadEncryption derives the keys sealing the tokens from both parties' payload keys. It
+// returns nil, leaving the payloads to TLS, when this party has payload encryption off, or under
+// auto when the peer offered no key in a confirmed handshake. Without the confirmation, a missing
+// key may have been stripped in transit, so it fails as it does when this party requires payload
+// encryption.
+func agreePayloadEncryption(localRecipe, peerRecipe *RecipeHandshake, isServer, confirmed bool) (*transfer.Sealer, error) {
+	if localRecipe.payloadMode == transfer.PayloadEncryptionOff {
+		fmt.Printf("   Payload encryption: off\n")
+		return nil, nil
+	}
+	if localRecipe.keys == nil || len(peerRecipe.PayloadKey) == 0 {
+		switch {
+		case localRecipe.payloadMode == transfer.PayloadEncryptionRequired:
+			return nil, ConfigError.Errorf("peer offered no payload key, but peer.payload_encryption is required " +
+				"(the peer runs an older version or has payload_encryption off)")
+		case !confirmed:
+			return nil, ConfigError.Errorf("peer offered no payload key in a handshake it could not confirm, so the key may " +
+				"have been stripped in transit; upgrade the peer, or set peer.payload_encryption: off at both sites to link without it")
+		}
+		fmt.Printf("   Payload encryption: off (the peer offered no key)\n")
+		return nil, nil
+	}
+	sealer, err := localRecipe.keys.Agree(peerRecipe.PayloadKey, isServer, localRecipe.secret)
+	if err != nil {
+		return nil, CryptoError.Wrap(err)
+	}
+	fmt.Printf("   Payload encryption: X25519 + AES-256-GCM\n")
+	return sealer, nil
+}
+
+// verifyRecipe fails if the peer tokenized with a different recipe
+func verifyRecipe(localRecipe, peerRecipe *RecipeHandshake) error {
+	if peerRecipe.Fingerprint != localRecipe.Fingerprint {
+		return ConfigError.Errorf("tokenization recipe mismatch - tokens would not be comparable\n"+
+			"   local: %s (fingerprint %s)\n"+
+			"   peer:  %s (fingerprint %s)\n"+
+			"   both parties must use identical tokenization settings, normalization, seed and linkage secret",
+			localRecipe.Summary, shortFingerprint(localRecipe.Fingerprint),
+			peerRecipe.Summary, shortFingerprint(peerRecipe.Fingerprint))
+	}
+	if peerRecipe.Protocol != localRecipe.Protocol {
+		return ConfigError.Errorf("matching protocol mismatch: local %s, peer %s (both parties must set the same matching.protocol and matching.exact_first_pass)",
+			recipeProtocol(localRecipe), recipeProtocol(peerRecipe))
+	}
+
+	fmt.Printf("   Tokenization recipe verified (fingerprint %s)\n", shortFingerprint(localRecipe.Fingerprint))
+	return nil
+}
+
+// recipeProtocol names the matching protocol a handshake selected
+func recipeProtocol(recipe *RecipeHandshake) string {
+	if recipe.Protocol == "" {
+		return crypto.ProtocolStandard
+	}
+	return recipe.Protocol
+}
+
+// shortFingerprint truncates a fingerprint for display
+func shortFingerprint(fingerprint string) string {
+	if len(fingerprint) > 12 {
+		return fingerprint[:12]
+	}
+	return fingerprint
+}
+
 // loadTokenizedData loads tokenized data from a CSV file
 func loadTokenizedData(filename string) (*TokenData, error) {
 	file, err := os.Open(filename)
@@ -361,6 +1247,8 @@ func loadTokenizedData(filename string) (*TokenData, error) {
 	}
 
 	tokenData := &TokenData{Records: make(map[string]TokenRecord)}
+	blockingIndex := slices.Index(records[0], blockingColumn)
+	exactIDIndex := slices.Index(records[0], exactIDColumn)
 
 	// Skip header row
 	for i := 1; i < len(records); i++ {
@@ -374,6 +1262,12 @@ func loadTokenizedData(filename string) (*TokenData, error) {
 			BloomFilter: record[1],
 			MinHash:     record[2],
 		}
+		if blockingIndex >= 0 && blockingIndex < len(record) {
+			tokenRecord.Blocking = record[blockingIndex]
+		}
+		if exactIDIndex >= 0 && exactIDIndex < len(record) {
+			tokenRecord.ExactID = record[exactIDIndex]
+		}
 
 		tokenData.Records[tokenRecord.ID] = tokenRecord
 	}
@@ -381,22 +1275,42 @@ func loadTokenizedData(filename string) (*TokenData, error) {
 	return tokenData, nil
 }
 
-// computeZeroKnowledgeIntersection computes intersection using ONLY zero-knowledge protocols
-func computeZeroKnowledgeIntersection(localTokens, peerTokens *TokenData, cfg *config.Config, party int, allowDuplicates bool) (*IntersectionResult, error) {
+// computeZeroKnowledgeIntersection computes intersection using ONLY zero-knowledge protocols,
+// saving progress to checkpoint if one is given
+func computeZeroKnowledgeIntersection(localTokens, peerTokens *TokenData, cfg *config.Config, party int, allowDuplicates bool, checkpoint *intersectionCheckpoint) (*IntersectionResult, error) {
 	fmt.Printf("   Using zero-knowledge protocols (Party %d)\n", party)
 	fmt.Printf("   No information leaked beyond intersection\n")
 
 	if allowDuplicates {
 		fmt.Printf("   Matching mode: 1:many (duplicates allowed)\n")
 	} else {
-		fmt.Printf("   Matching mode: 1:1 (unique matches only)\n")
+		fmt.Printf("   Matching mode: 1:1 (unique matches only, %s assignment)\n", cfg.Matching.Assignment)
 	}
 
-	return computeSecureIntersection(localTokens, peerTokens, cfg, party, allowDuplicates)
+	return computeSecureIntersection(localTokens, peerTokens, cfg, party, allowDuplicates, checkpoint)
 }
 
-// computeSecureIntersection performs secure intersection computation
-func computeSecureIntersection(localTokens, peerTokens *TokenData, cfg *config.Config, party int, allowDuplicates bool) (*IntersectionResult, error) {
+// workflowInputsDigest identifies an intersection for checkpointing: both token sets, the recipe,
+// the party and the settings that decide which pairs are candidates
+func workflowInputsDigest(localTokens, peerTokens *TokenData, recipe *RecipeHandshake, cfg *config.Config, party int) string {
+	calibration := ""
+	if cfg.Matching.CalibrationFile != "" {
+		if digest, err := store.HashFile(cfg.Matching.CalibrationFile); err == nil {
+			calibration = digest.SHA256
+		}
+	}
+	parts := []string{"pprl", tokenDigest(localTokens), tokenDigest(peerTokens), recipe.Fingerprint, strconv.Itoa(party),
+		fmt.Sprintf("%d/%g/%g/%g", cfg.Matching.HammingThreshold, cfg.Matching.JaccardThreshold, cfg.Matching.CandidateThreshold, cfg.Matching.ProbabilityThreshold),
+		calibration}
+	if comparator, _ := resolveComparator("", cfg); comparator != "" {
+		// Another comparator scores every pair differently, so progress of one run doesn't carry over
+		parts = append(parts, "comparator="+comparator)
+	}
+	return inputsDigest(parts...)
+}
+
+// computeSecureIntersection performs secure intersection computation, checkpointed if checkpoint is set
+func computeSecureIntersection(localTokens, peerTokens *TokenData, cfg *config.Config, party int, allowDuplicates bool, checkpoint *intersectionCheckpoint) (*IntersectionResult, error) {
 	// Convert TokenData to PPRL Records for secure matching
 	localRecords, err := tokenDataToPPRLRecords(localTokens)
 	if err != nil {
@@ -414,26 +1328,44 @@ func computeSecureIntersection(localTokens, peerTokens *TokenData, cfg *config.C
 		AllowDuplicates:  allowDuplicates,
 		HammingThreshold: cfg.Matching.HammingThreshold,
 		JaccardThreshold: cfg.Matching.JaccardThreshold,
+		Assignment:       cfg.Matching.Assignment,
+
+		CandidateThreshold: cfg.Matching.CandidateThreshold,
+		Blocking:           len(cfg.Tokenization.Blocking) > 0,
+		Retention:          matchRetention(cfg),
+		DistanceScale:      hardeningScale(cfg.Tokenization),
+	}
+	comparator, err := resolveComparator("", cfg)
+	if err != nil {
+		return nil, err
+	}
+	if comparator != "" {
+		if fuzzyConfig.Comparator, err = match.NewComparator(comparator); err != nil {
+			return nil, err
+		}
+	}
+
+	// Attach the calibration model if one is configured
+	if err := applyCalibration(fuzzyConfig, cfg); err != nil {
+		return nil, err
 	}
 
 	// Create zero-knowledge fuzzy matcher
 	fuzzyMatcher := match.NewFuzzyMatcher(fuzzyConfig)
 
 	// Perform zero-knowledge intersection computation
-	secureResult, err := fuzzyMatcher.ComputePrivateIntersection(localRecords, peerRecords)
+	var secureResult *crypto.PrivateIntersectionResult
+	if checkpoint != nil {
+		secureResult, err = fuzzyMatcher.ComputeResumableIntersection(localRecords, peerRecords, checkpoint.progress())
+	} else {
+		secureResult, err = fuzzyMatcher.ComputePrivateIntersection(localRecords, peerRecords)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("secure intersection computation failed: %v", err)
 	}
 
 	// Convert zero-knowledge results - only matches, no other information
-	var matches []*match.PrivateMatchResult
-	for _, privateMatch := range secureResult.MatchPairs {
-		matchResult := &match.PrivateMatchResult{
-			LocalID: privateMatch.LocalID,
-			PeerID:  privateMatch.PeerID,
-		}
-		matches = append(matches, matchResult)
-	}
+	matches := fuzzyMatcher.MatchResults(secureResult)
 
 	// Create intersection result with ZERO information leakage
 	result := &IntersectionResult{
@@ -443,6 +1375,28 @@ func computeSecureIntersection(localTokens, peerTokens *TokenData, cfg *config.C
 	return result, nil
 }
 
+// applyCalibration loads the configured calibration model into a fuzzy match configuration
+func applyCalibration(fuzzyConfig *match.FuzzyMatchConfig, cfg *config.Config) error {
+	if cfg.Matching.CalibrationFile == "" {
+		if cfg.Matching.ProbabilityThreshold > 0 {
+			return fmt.Errorf("matching.probability_threshold requires matching.calibration_file")
+		}
+		return nil
+	}
+
+	calibration, err := match.LoadCalibration(cfg.Matching.CalibrationFile)
+	if err != nil {
+		return err
+	}
+	fuzzyConfig.Calibration = calibration
+	fuzzyConfig.ProbabilityThreshold = cfg.Matching.ProbabilityThreshold
+
+	if cfg.Matching.ProbabilityThreshold > 0 {
+		fmt.Printf("   Using calibrated probability threshold: %.3f\n", cfg.Matching.ProbabilityThreshold)
+	}
+	return nil
+}
+
 // REMOVED: computeStandardIntersection
 // Standard intersections are not supported in zero-knowledge protocols
 // All intersections now use zero-knowledge protocols to ensure no information leakage
@@ -455,29 +1409,41 @@ func tokenDataToPPRLRecords(tokenData *TokenData) ([]*pprl.Record, error) {
 	var records []*pprl.Record
 
 	for _, tokenRecord := range tokenData.Records {
-		// Decode MinHash from base64
-		mh, err := pprl.MinHashFromBase64(tokenRecord.MinHash)
+		record, err := tokenRecordToPPRL(tokenRecord)
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode minhash for %s: %v", tokenRecord.ID, err)
+			return nil, err
 		}
+		records = append(records, record)
+	}
 
-		// Get MinHash signature directly - this is the correct way
-		minHashSig := mh.GetSignature()
-		if minHashSig == nil {
-			return nil, fmt.Errorf("failed to get minhash signature for %s", tokenRecord.ID)
-		}
+	// A stable order lets a checkpoint refer to local records by index
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records, nil
+}
 
-		record := &pprl.Record{
-			ID:        tokenRecord.ID,
-			BloomData: tokenRecord.BloomFilter,
-			MinHash:   minHashSig,
-			QGramData: "", // Not used in workflow
-		}
+// tokenRecordToPPRL decodes one token record for secure matching
+func tokenRecordToPPRL(tokenRecord TokenRecord) (*pprl.Record, error) {
+	// Decode MinHash from base64
+	mh, err := pprl.MinHashFromBase64(tokenRecord.MinHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode minhash for %s: %v", tokenRecord.ID, err)
+	}
 
-		records = append(records, record)
+	// Get MinHash signature directly - this is the correct way
+	minHashSig := mh.GetSignature()
+	if minHashSig == nil {
+		return nil, fmt.Errorf("failed to get minhash signature for %s", tokenRecord.ID)
 	}
 
-	return records, nil
+	return &pprl.Record{
+		ID:        tokenRecord.ID,
+		BloomData: tokenRecord.BloomFilter,
+		MinHash:   minHashSig,
+		QGramData: "", // Not used in workflow
+
+		BlockingKeys: pprl.SplitBlockingKeys(tokenRecord.Blocking),
+		ExactID:      tokenRecord.ExactID,
+	}, nil
 }
 
 // REMOVED: tokenDataToPatientRecords
@@ -490,15 +1456,12 @@ func tokenDataToPPRLRecords(tokenData *TokenData) ([]*pprl.Record, error) {
 // that ensure ZERO information leakage beyond the final intersection pairs
 
 // exchangeIntersectionResults exchanges intersection results between peers
-func exchangeIntersectionResults(conn net.Conn, localIntersection *IntersectionResult, isServer bool) (*IntersectionResult, error) {
-	encoder := json.NewEncoder(conn)
-	decoder := json.NewDecoder(conn)
-
+func exchangeIntersectionResults(channel *transfer.Channel, localIntersection *IntersectionResult, isServer bool) (*IntersectionResult, error) {
 	if isServer {
 		// Server: first receive, then send
 		fmt.Printf("   Receiving intersection from peer...\n")
 		var peerMessage PeerMessage
-		if err := decoder.Decode(&peerMessage); err != nil {
+		if err := receivePeerMessage(channel, &peerMessage); err != nil {
 			return nil, fmt.Errorf("failed to receive peer intersection: %v", err)
 		}
 
@@ -512,7 +1475,7 @@ func exchangeIntersectionResults(conn net.Conn, localIntersection *IntersectionR
 		}
 
 		fmt.Printf("   Sending local intersection to peer...\n")
-		if err := encoder.Encode(PeerMessage{Type: "intersection", Payload: localIntersection}); err != nil {
+		if err := sendPeerMessage(channel, PeerMessage{Type: "intersection", Payload: localIntersection}); err != nil {
 			return nil, fmt.Errorf("failed to send local intersection: %v", err)
 		}
 
@@ -520,13 +1483,13 @@ func exchangeIntersectionResults(conn net.Conn, localIntersection *IntersectionR
 	} else {
 		// Client: first send, then receive
 		fmt.Printf("   Sending local intersection to peer...\n")
-		if err := encoder.Encode(PeerMessage{Type: "intersection", Payload: localIntersection}); err != nil {
+		if err := sendPeerMessage(channel, PeerMessage{Type: "intersection", Payload: localIntersection}); err != nil {
 			return nil, fmt.Errorf("failed to send local intersection: %v", err)
 		}
 
 		fmt.Printf("   Receiving intersection from peer...\n")
 		var peerMessage PeerMessage
-		if err := decoder.Decode(&peerMessage); err != nil {
+		if err := receivePeerMessage(channel, &peerMessage); err != nil {
 			return nil, fmt.Errorf("failed to receive peer intersection: %v", err)
 		}
 
@@ -623,6 +1586,33 @@ func saveWorkflowIntersectionResults(intersection *IntersectionResult, filename
 	return saveJSONFile(intersection, filename)
 }
 
+// saveWorkflowResultsToPostgres copies the matches into the output.postgres matches table, with the
+// output columns and metadata of the config; workflow results carry no similarity scores
+func saveWorkflowResultsToPostgres(intersection *IntersectionResult, cfg *config.Config, runID string) (string, error) {
+	schema, err := newResultSchema(cfg, "", "", "")
+	if err != nil {
+		return "", err
+	}
+	if schema.includesScores() {
+		return "", fmt.Errorf("pprl results have no similarity scores; remove them from output.columns")
+	}
+
+	writer, err := newResultWriter("", schema, runID, len(intersection.Matches))
+	if err != nil {
+		return "", err
+	}
+	for _, m := range intersection.Matches {
+		if err := writer.Write(crypto.PrivateMatchPair{LocalID: m.LocalID, PeerID: m.PeerID}); err != nil {
+			writer.Abort()
+			return "", err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return schema.destination(""), nil
+}
+
 // saveJSONFile saves any object to a JSON file
 func saveJSONFile(obj interface{}, filename string) error {
 	file, err := os.Create(filename)
@@ -647,7 +1637,7 @@ func mapToStruct(data interface{}, target interface{}) error {
 }
 
 // runTokenizeCommandInternal performs tokenization (simplified version of tokenize.go logic)
-func runTokenizeCommandInternal(args []string, fields []string) error {
+func runTokenizeCommandInternal(args []string, fields []string, recordConfig *pprl.RecordConfig) error {
 	var inputFile, outputFile string
 
 	// Parse args
@@ -671,11 +1661,12 @@ func runTokenizeCommandInternal(args []string, fields []string) error {
 	}
 
 	// Use the existing tokenization logic
-	return performRealTokenization(inputFile, outputFile, fields)
+	return performRealTokenization(inputFile, outputFile, fields, recordConfig)
 }
 
-// performRealTokenization (copied from existing workflows.go)
-func performRealTokenization(inputFile, outputFile string, fields []string) error {
+// performRealTokenization tokenizes a CSV file, applying the normalization methods given in
+// fields ("method:field") and the recipe's record ID mode
+func performRealTokenization(inputFile, outputFile string, fields []string, recordConfig *pprl.RecordConfig) error {
 	// Read input CSV file
 	csvDB, err := db.NewCSVDatabase(inputFile)
 	if err != nil {
@@ -688,6 +1679,12 @@ func performRealTokenization(inputFile, outputFile string, fields []string) erro
 		return fmt.Errorf("failed to read records: %w", err)
 	}
 
+	fieldNames, normalizationConfig := parseFieldsWithNormalization(fields)
+	tokenizer, err := newRecordTokenizer(fieldNames, recordConfig, normalizationConfig)
+	if err != nil {
+		return err
+	}
+
 	// Create CSV output file with proper headers
 	outputCSV, err := os.Create(outputFile)
 	if err != nil {
@@ -699,99 +1696,28 @@ func performRealTokenization(inputFile, outputFile string, fields []string) erro
 	defer writer.Flush()
 
 	// Write CSV header
-	header := []string{"id", "bloom_filter", "minhash", "timestamp"}
-	if err := writer.Write(header); err != nil {
+	if err := writer.Write(tokenizer.header()); err != nil {
 		return fmt.Errorf("failed to write CSV header: %w", err)
 	}
 
-	// PPRL configuration for tokenization
-	recordConfig := &pprl.RecordConfig{
-		BloomSize:    1000, // 1000 bits
-		BloomHashes:  5,    // 5 hash functions
-		MinHashSize:  100,  // 100-element signature
-		QGramLength:  2,    // 2-grams
-		QGramPadding: "$",  // Padding character
-		NoiseLevel:   0,    // No noise for deterministic matching
-	}
-
 	processedCount := 0
 	for _, record := range allRecords {
-		// Extract field values for this record
-		var fieldValues []string
-		for _, field := range fields {
-			// Extract actual field name (remove type prefix like "name:", "date:", etc.)
-			fieldName := field
-			if strings.Contains(field, ":") {
-				parts := strings.Split(field, ":")
-				if len(parts) == 2 {
-					fieldName = parts[1]
-				}
-			}
-
-			if value, exists := record[fieldName]; exists && value != "" {
-				fieldValues = append(fieldValues, value)
-			}
-		}
-
-		if len(fieldValues) == 0 {
-			continue // Skip records with no data in specified fields
-		}
-
-		// Get record ID
-		recordID := record["id"]
-		if recordID == "" {
-			// Generate ID if not present
-			recordID = fmt.Sprintf("record_%d", processedCount+1)
-		}
-
-		// Create PPRL record with real tokenization
-		pprlRecord, err := pprl.CreateRecord(recordID, fieldValues, recordConfig)
-		if err != nil {
-			return fmt.Errorf("failed to create PPRL record for %s: %w", recordID, err)
-		}
-
-		// Decode the Bloom filter to compute MinHash from it
-		bf, err := pprl.BloomFromBase64(pprlRecord.BloomData)
+		csvRow, err := tokenizer.row(record, fmt.Sprintf("record_%d", processedCount+1))
 		if err != nil {
-			return fmt.Errorf("failed to decode Bloom filter for %s: %w", recordID, err)
+			return err
 		}
-
-		// Create deterministic MinHash with shared seed for consistent signatures across parties
-		mh, err := pprl.NewMinHashSeeded(recordConfig.BloomSize, recordConfig.MinHashSize, "0PsRm4KNmgRSY8ynApUtpXjeO19S7OUE")
-		if err != nil {
-			return fmt.Errorf("failed to create MinHash for %s: %w", recordID, err)
-		}
-
-		// Compute the signature directly from the Bloom filter
-		_, err = mh.ComputeSignature(bf)
-		if err != nil {
-			return fmt.Errorf("failed to compute MinHash signature for %s: %w", recordID, err)
-		}
-
-		// Convert to CSV format
-		timestamp := time.Now().Format("2006-01-02T15:04:05Z")
-
-		// Encode the complete MinHash object to base64
-		minHashBase64, err := mh.ToBase64()
-		if err != nil {
-			return fmt.Errorf("failed to encode MinHash to base64 for %s: %w", recordID, err)
-		}
-
-		csvRow := []string{
-			fmt.Sprintf("anonymous_%d", processedCount+1), // Anonymous ID only
-			pprlRecord.BloomData,                          // Already base64 encoded
-			minHashBase64,                                 // Properly base64 encoded MinHash
-			timestamp,
+		if csvRow == nil {
+			continue // Skip records with no data in specified fields
 		}
 
 		if err := writer.Write(csvRow); err != nil {
-			return fmt.Errorf("failed to write CSV row for %s: %w", recordID, err)
+			return fmt.Errorf("failed to write CSV row for %s: %w", record["id"], err)
 		}
 
 		processedCount++
 	}
 
-	return nil
+	return tokenizer.reportDensity(nil)
 }
 
 func isDebugMode() bool {
@@ -808,6 +1734,51 @@ func isDebugMode() bool {
 	return false
 }
 
+// pprlFlags are the flags of the pprl command
+type pprlFlags struct {
+	fs              *flag.FlagSet
+	configFile      *string
+	interactive     *bool
+	force           *bool
+	allowDuplicates *bool
+	transcriptFile  *string
+	transport       *string
+	resume          *bool
+	reconcile       *bool
+	holdoutFile     *string
+	inputFile       *string
+	peer            *string
+	listenPort      *int
+	relayURL        *string
+	outputDir       *string
+	help            *bool
+	thresholdFlags  *thresholdFlags
+}
+
+// newPPRLFlags defines the flags of the pprl command
+func newPPRLFlags() *pprlFlags {
+	fs := newFlagSet("pprl")
+	return &pprlFlags{
+		fs:              fs,
+		configFile:      fs.String("config", "", "Configuration file"),
+		interactive:     fs.Bool("interactive", false, "Force interactive mode"),
+		force:           fs.Bool("force", false, "Skip confirmation prompts and run automatically"),
+		allowDuplicates: fs.Bool("allow-duplicates", false, "Allow 1:many matching (default: 1:1 matching only)"),
+		transcriptFile:  fs.String("transcript", "", "Record a digest-only transcript of peer messages to this file"),
+		transport:       fs.String("transport", "", "Peer transport: grpc or tcp (overrides peer.transport)"),
+		resume:          fs.Bool("resume", false, "Continue an interrupted intersection from its checkpoint"),
+		reconcile:       fs.Bool("reconcile", false, "Reconcile differing intersections with the peer instead of failing (sets matching.reconcile)"),
+		holdoutFile:     fs.String("holdout", "", "Local ground truth subset scored after the run into the manifest (overrides matching.holdout_file)"),
+		inputFile:       fs.String("input", "", "Local dataset (overrides database.filename)"),
+		peer:            fs.String("peer", "", "Peer address host:port (overrides peer.host and peer.port)"),
+		listenPort:      fs.Int("listen-port", 0, "Local listen port (overrides listen_port)"),
+		relayURL:        fs.String("relay", "", "Meet the peer through a relay: relay://host[:port]/session (overrides peer.relay)"),
+		outputDir:       fs.String("output-dir", "out", "Directory receiving the results and checkpoints"),
+		help:            fs.Bool("help", false, "Show help message"),
+		thresholdFlags:  addThresholdFlags(fs),
+	}
+}
+
 // runPPRLCommand is the entry point for the pprl command
 func runPPRLCommand(args []string) {
 	fmt.Println("CohortBridge PPRL")
@@ -815,32 +1786,30 @@ func runPPRLCommand(args []string) {
 	fmt.Println("Peer-to-peer privacy-preserving record linkage")
 	fmt.Println()
 
-	fs := flag.NewFlagSet("pprl", flag.ExitOnError)
-	var (
-		configFile      = fs.String("config", "", "Configuration file")
-		interactive     = fs.Bool("interactive", false, "Force interactive mode")
-		force           = fs.Bool("force", false, "Skip confirmation prompts and run automatically")
-		allowDuplicates = fs.Bool("allow-duplicates", false, "Allow 1:many matching (default: 1:1 matching only)")
-		help            = fs.Bool("help", false, "Show help message")
-	)
-	fs.Parse(args)
+	f := newPPRLFlags()
+	f.fs.Parse(args)
 
-	if *help {
+	if *f.help {
 		showPPRLHelp()
 		return
 	}
 
 	// Interactive mode if missing config or requested
-	if *configFile == "" || *interactive {
+	if *f.configFile == "" || *f.interactive {
+		var missing []string
+		if *f.configFile == "" {
+			missing = append(missing, "-config")
+		}
+		requirePrompt("pprl", missing...)
+
 		fmt.Println("Interactive PPRL Setup")
-		fmt.Println("Configure your peer-to-peer record linkage:\n")
+		fmt.Print("Configure your peer-to-peer record linkage:\n\n")
 
-		if *configFile == "" {
+		if *f.configFile == "" {
 			var err error
-			*configFile, err = selectDataFile("Select Configuration File", "config", []string{".yaml"})
+			*f.configFile, err = selectDataFile("Select Configuration File", "config", []string{".yaml"})
 			if err != nil {
-				fmt.Printf("Error selecting config file: %v\n", err)
-				os.Exit(1)
+				fatalf(UsageError, "Error selecting config file: %v", err)
 			}
 		}
 
@@ -849,8 +1818,8 @@ func runPPRLCommand(args []string) {
 
 	// Show configuration summary
 	fmt.Println("PPRL Configuration:")
-	fmt.Printf("  Config File: %s\n", *configFile)
-	if *allowDuplicates {
+	fmt.Printf("  Config File: %s\n", *f.configFile)
+	if *f.allowDuplicates {
 		fmt.Printf("  Matching Mode: 1:many (duplicates allowed)\n")
 	} else {
 		fmt.Printf("  Matching Mode: 1:1 (unique matches only)\n")
@@ -858,7 +1827,7 @@ func runPPRLCommand(args []string) {
 	fmt.Println()
 
 	// Confirm before proceeding
-	if !*force {
+	if !skipConfirmation("pprl", *f.force, "-force") {
 		confirmOptions := []string{
 			"Yes, start PPRL",
 			"Cancel",
@@ -875,34 +1844,98 @@ func runPPRLCommand(args []string) {
 	}
 
 	// Load configuration
-	cfg, err := config.Load(*configFile)
+	cfg, err := config.Load(*f.configFile)
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fatalf(ConfigError, "Failed to load configuration: %v", err)
+	}
+
+	if *f.inputFile != "" {
+		cfg.Database.Filename = *f.inputFile
+	}
+	if err := applyPeerFlags(cfg, *f.peer, *f.listenPort, *f.relayURL); err != nil {
+		fatalf(UsageError, "%v", err)
 	}
 
 	// Debug: Print loaded config details
 	fmt.Printf("Debug - Loaded config: Peer.Host='%s', Peer.Port=%d, ListenPort=%d\n", cfg.Peer.Host, cfg.Peer.Port, cfg.ListenPort)
 
 	// Validate config has required fields
-	if cfg.Peer.Host == "" || cfg.Peer.Port == 0 {
-		log.Fatalf("Configuration missing peer connection details (peer.host and peer.port)")
+	if err := checkPeerConnection(cfg); err != nil {
+		fatalf(ConfigError, "%v", err)
 	}
 
-	if cfg.ListenPort == 0 {
-		log.Fatalf("Configuration missing listen_port")
+	if *f.transcriptFile != "" {
+		cfg.Logging.TranscriptFile = *f.transcriptFile
+	}
+
+	if *f.transport != "" {
+		cfg.Peer.Transport = *f.transport
 	}
 
-	if cfg.Matching.HammingThreshold == 0 {
-		cfg.Matching.HammingThreshold = 20 // Default
+	if *f.reconcile {
+		cfg.Matching.Reconcile = true
 	}
 
-	if cfg.Matching.JaccardThreshold == 0 {
-		cfg.Matching.JaccardThreshold = 0.32 // Default
+	if *f.holdoutFile != "" {
+		cfg.Matching.HoldoutFile = *f.holdoutFile
 	}
 
+	if err := crypto.ValidateAssignment(cfg.Matching.Assignment); err != nil {
+		fatalf(ConfigError, "Invalid matching configuration: %v", err)
+	}
+	if err := crypto.ValidateProtocol(cfg.Matching.Protocol); err != nil {
+		fatalf(ConfigError, "Invalid matching configuration: %v", err)
+	}
+	if _, err := match.NewComparator(cfg.Matching.Comparator); err != nil {
+		fatalf(ConfigError, "Invalid matching configuration: %v", err)
+	}
+
+	// Flags override the config's thresholds, which override the defaults
+	thresholds := f.thresholdFlags.resolve(cfg)
+	thresholds.Apply(cfg)
+
 	// Run the PPRL workflow
-	fmt.Println("Starting PPRL workflow...\n")
-	runUnifiedWorkflow(cfg, *force, *allowDuplicates)
+	fmt.Print("Starting PPRL workflow...\n\n")
+	runUnifiedWorkflow(cfg, thresholds, *f.outputDir, *f.force, *f.allowDuplicates, *f.resume)
+}
+
+// applyPeerFlags overrides the config's peer connection with the -peer, -listen-port and -relay flags
+func applyPeerFlags(cfg *config.Config, peer string, listenPort int, relayURL string) error {
+	if peer != "" {
+		host, port, err := net.SplitHostPort(peer)
+		if err != nil {
+			return fmt.Errorf("invalid -peer %q: %v", peer, err)
+		}
+		if cfg.Peer.Port, err = strconv.Atoi(port); err != nil {
+			return fmt.Errorf("invalid -peer port %q", port)
+		}
+		cfg.Peer.Host = host
+	}
+	if listenPort != 0 {
+		cfg.ListenPort = listenPort
+	}
+	if relayURL != "" {
+		cfg.Peer.Relay = relayURL
+	}
+	return nil
+}
+
+// checkPeerConnection checks the config says how to reach the peer; with a relay both parties
+// only dial out
+func checkPeerConnection(cfg *config.Config) error {
+	if cfg.Peer.Relay != "" {
+		if _, err := relay.ParseURL(cfg.Peer.Relay); err != nil {
+			return fmt.Errorf("invalid peer.relay: %v", err)
+		}
+		return nil
+	}
+	if cfg.Peer.Host == "" || cfg.Peer.Port == 0 {
+		return fmt.Errorf("configuration missing peer connection details (peer.host and peer.port, or peer.relay)")
+	}
+	if cfg.ListenPort == 0 {
+		return fmt.Errorf("configuration missing listen_port")
+	}
+	return nil
 }
 
 func showPPRLHelp() {
@@ -915,8 +1948,9 @@ func showPPRLHelp() {
 	fmt.Println("  3. Establish peer connection")
 	fmt.Println("  4. Exchange tokens with peer")
 	fmt.Println("  5. Compute intersection using thresholds")
-	fmt.Println("  6. Exchange intersection results")
-	fmt.Println("  7. Compare results and create diff if needed")
+	fmt.Println("  6. Compare salted intersection digests; exchange the intersections only if they differ")
+	fmt.Println("  7. Compare results and create diff if needed; with matching.reconcile, re-compare the")
+	fmt.Println("     differing pairs and sign off on a reconciled intersection")
 	fmt.Println()
 	fmt.Println("USAGE:")
 	fmt.Println("  cohort-bridge pprl [OPTIONS]")
@@ -927,8 +1961,28 @@ func showPPRLHelp() {
 	fmt.Println("  -interactive          Force interactive mode")
 	fmt.Println("  -force                Skip confirmation prompts")
 	fmt.Println("  -allow-duplicates     Allow 1:many matching (default: 1:1 matching only)")
+	fmt.Println("  -transcript string    Record a digest-only transcript of peer messages")
+	fmt.Println("                        (verify with 'cohort-bridge audit-transcript')")
+	fmt.Println("  -transport string     Peer transport: grpc or tcp (overrides peer.transport)")
+	fmt.Println("  -resume               Continue an interrupted intersection from its checkpoint")
+	fmt.Println("  -reconcile            Reconcile differing intersections instead of failing")
+	fmt.Println("                        (sets matching.reconcile)")
+	fmt.Println("  -holdout string       Local ground truth subset scored after the run (overrides")
+	fmt.Println("                        matching.holdout_file)")
+	fmt.Println("  -input string         Local dataset (overrides database.filename)")
+	fmt.Println("  -peer host:port       Peer address (overrides peer.host and peer.port)")
+	fmt.Println("  -listen-port n        Local listen port (overrides listen_port)")
+	fmt.Println("  -relay url            Meet the peer through a relay: relay://host[:port]/session")
+	fmt.Println("                        (overrides peer.relay; see 'cohort-bridge relay -help')")
+	fmt.Println("  -output-dir string    Directory receiving the results and checkpoints (default: out)")
+	fmt.Println("  -hamming-threshold n  Maximum Hamming distance of a match (overrides the config)")
+	fmt.Println("  -jaccard-threshold f  Minimum Jaccard similarity of a match (overrides the config)")
 	fmt.Println("  -help                 Show this help message")
 	fmt.Println()
+	fmt.Println("Intermediate files are kept in a workspace under workspace.dir (default: the")
+	fmt.Println("system temp directory) and securely deleted when the run ends; -debug or")
+	fmt.Println("workspace.keep keeps it, and 'cohort-bridge clean' purges kept workspaces.")
+	fmt.Println()
 	fmt.Println("EXAMPLES:")
 	fmt.Println("  # Interactive mode")
 	fmt.Println("  cohort-bridge pprl")
@@ -939,12 +1993,131 @@ func showPPRLHelp() {
 	fmt.Println("  # Automatic mode (skip confirmations)")
 	fmt.Println("  cohort-bridge pprl -config config.yaml -force")
 	fmt.Println()
+	fmt.Println("  # Continue after a crash or lost connection (both peers pass -resume)")
+	fmt.Println("  cohort-bridge pprl -config config.yaml -force -resume")
+	fmt.Println()
 	fmt.Println("  # Allow 1:many matching (multiple matches per record)")
 	fmt.Println("  cohort-bridge pprl -config config.yaml -allow-duplicates")
 	fmt.Println()
+	fmt.Println("  # Carry a quality estimate from known pairs in every run's manifest")
+	fmt.Println("  cohort-bridge pprl -config config.yaml -holdout reviewed_pairs.csv -force")
+	fmt.Println()
+	fmt.Println("  # Neither party accepts inbound connections: both dial out to a relay")
+	fmt.Println("  cohort-bridge pprl -config config.yaml -relay relay://relay.example.org/study-42 -force")
+	fmt.Println()
 	fmt.Println("CONFIGURATION REQUIREMENTS:")
 	fmt.Println("  - peer.host and peer.port (peer connection)")
 	fmt.Println("  - listen_port (local server port)")
-	fmt.Println("  - matching.hamming_threshold (default: 20)")
-	fmt.Println("  - matching.jaccard_threshold (default: 0.32)")
+	fmt.Println("  - or instead of both, peer.relay (relay://host[:port]/session)")
+	fmt.Printf("  - matching.hamming_threshold (default: %d)\n", config.DefaultHammingThreshold)
+	fmt.Printf("  - matching.jaccard_threshold (default: %g)\n", config.DefaultJaccardThreshold)
+	fmt.Println()
+	fmt.Println("PEER TRANSPORT (optional):")
+	fmt.Println("  - peer.transport       grpc (default) or tcp, the legacy JSON protocol; both peers must match")
+	fmt.Println("  - peer.tls_cert_file   certificate served when listening; enables TLS")
+	fmt.Println("  - peer.tls_key_file    private key for tls_cert_file")
+	fmt.Println("  - peer.tls_ca_file     CA that signed the peer's certificate (default: system roots)")
+	fmt.Println("  - peer.tls_server_name name expected in the peer's certificate (default: peer.host)")
+	fmt.Println("  Peers negotiate the protocol version (proto/cohortbridge/peer/v1) before exchanging data.")
+	fmt.Println()
+	fmt.Println("PEER AUTHENTICATION (optional, recommended):")
+	fmt.Println("  - peer.api_key         pre-shared key both peers hold (or peer.api_key_file, COHORT_PEER_API_KEY);")
+	fmt.Println("                         proven with HMAC challenges, never sent")
+	fmt.Println("  - peer.allowed_peers   grpc mTLS allowlist: certificate CN, DNS/URI SAN or sha256:<fingerprint>;")
+	fmt.Println("                         needs tls_cert_file, tls_key_file and tls_ca_file")
+	fmt.Println("  - security.allowed_ips IPs or CIDR ranges a listening peer accepts connections from")
+	fmt.Println("  Rejected peers are logged to the audit log (logging.enable_audit, logging.audit_file).")
+	fmt.Println()
+	fmt.Println("RESULT SIGNING (optional):")
+	fmt.Println("  - peer.signing_key_file Ed25519 key signing the intersection sent to the peer")
+	fmt.Println("                          (create with 'cohort-bridge keys signing-keygen')")
+	fmt.Println("  - peer.peer_public_key  the peer's public key (base64); its intersection is rejected")
+	fmt.Println("                          unless signed with this key over this exchange")
+	fmt.Println()
+	fmt.Println("PEER TRANSFER (optional, tcp transport):")
+	fmt.Println("  - peer.chunk_size_kb (default: 1024)")
+	fmt.Println("  - peer.max_retries   reconnection attempts after a network failure (default: 5)")
+	fmt.Println("  - peer.retry_delay   first reconnection delay, then backing off like network retries")
+	fmt.Println("                       (default: 2s)")
+	fmt.Println("  - peer.compression   auto, zstd, gzip or none; negotiated in the handshake (default: auto)")
+	fmt.Println("                       (the grpc transport uses gzip unless this is none)")
+	fmt.Println("  Interrupted transfers resume from the last acknowledged chunk.")
+	fmt.Println()
+	fmt.Println("PAYLOAD ENCRYPTION (optional):")
+	fmt.Println("  - peer.payload_encryption auto, required or off (default: auto)")
+	fmt.Println("  Each party offers an ephemeral X25519 key in the recipe handshake; the tokens are then")
+	fmt.Println("  sealed with AES-256-GCM under keys bound to the tokenization seed and linkage secret, so")
+	fmt.Println("  they stay unreadable without TLS or through an untrusted relay. With auto, a peer that")
+	fmt.Println("  offers no key gets plaintext payloads; required refuses it. The parties then confirm the")
+	fmt.Println("  hellos and handshakes with a MAC under the same secrets, so a relay that strips a key")
+	fmt.Println("  fails the run rather than turning encryption off. Over tcp every message after the")
+	fmt.Println("  handshake is sealed, over grpc the token batches.")
+	fmt.Println()
+	fmt.Println("PEER TIMEOUTS (optional):")
+	fmt.Println("  - timeouts.token_exchange        handshake and token exchange (default: 30m)")
+	fmt.Println("  - timeouts.intersection_exchange intersection exchange, including the wait while the peer")
+	fmt.Println("                                   matches (default: timeouts.idle_timeout, 5m)")
+	fmt.Println("  - timeouts.heartbeat_interval    how often an idle connection proves it is alive; a peer")
+	fmt.Println("                                   silent for three intervals counts as lost (default: 15s,")
+	fmt.Println("                                   negative disables)")
+	fmt.Println("  A step that runs out fails with 'peer timed out during <step> after <timeout>'. Over tcp")
+	fmt.Println("  both peers must support heartbeats for them to run; over grpc they are keepalive pings.")
+	fmt.Println()
+	fmt.Println("NETWORK RETRIES (optional):")
+	fmt.Println("  - network.retries            further attempts after a transient failure (default: 3,")
+	fmt.Println("                               negative disables)")
+	fmt.Println("  - network.backoff.initial    delay before the first retry (default: 1s)")
+	fmt.Println("  - network.backoff.max        longest delay between retries (default: 30s)")
+	fmt.Println("  - network.backoff.multiplier growth of the delay after each retry (default: 2)")
+	fmt.Println("  - network.backoff.jitter     share of each delay randomized (default: 0.2)")
+	fmt.Println("  Connecting to the peer, joining a relay and object storage transfers are retried when")
+	fmt.Println("  they time out, are reset or unreachable, or get a server error; a refused connection")
+	fmt.Println("  to the peer starts server mode instead, and authentication failures are never retried.")
+	fmt.Println()
+	fmt.Println("RECONCILIATION (optional):")
+	fmt.Println("  - matching.reconcile   when the peers' intersections differ, re-compare just the differing")
+	fmt.Println("                         pairs instead of failing (default: false)")
+	fmt.Println("  Both peers must offer it in the handshake. They re-compare with the stricter of their")
+	fmt.Println("  thresholds, 1:1 unless both allow duplicates; pairs both peers found are kept. Each peer")
+	fmt.Println("  then signs off by sending a salted digest of its reconciled intersection, and the run")
+	fmt.Println("  fails unless the digests agree. The diff and intersection_reconciliation_<input>.json,")
+	fmt.Println("  listing the accepted and rejected pairs, are saved beside the results.")
+	fmt.Println()
+	fmt.Println("SECURE COMPARISON (optional):")
+	fmt.Println("  - matching.protocol    standard, smc or psi; both peers must match (default: standard)")
+	fmt.Println("  With smc, step 4 sends record IDs only and step 5 compares every pair's Hamming distance")
+	fmt.Println("  to matching.hamming_threshold under a two-party computation based on oblivious transfer:")
+	fmt.Println("  neither peer sees the other's Bloom filters or any distance, only which pairs match")
+	fmt.Println("  (secure against semi-honest peers). It is far slower and applies the Hamming threshold")
+	fmt.Println("  alone, which both peers must set alike; results carry no scores, and calibration,")
+	fmt.Println("  min_score, blocking, reconciliation and checkpoints do not apply.")
+	fmt.Println()
+	fmt.Println("EXACT IDENTIFIERS (optional):")
+	fmt.Println("  - tokenization.exact_id      source column of an identifier both peers share exactly,")
+	fmt.Println("                               such as a hashed SSN; kept as a keyed hash, never sent")
+	fmt.Println("  - matching.protocol psi      link only records sharing the identifier")
+	fmt.Println("  - matching.exact_first_pass  link them first, then fuzzy-match the remaining records")
+	fmt.Println("  Identifiers are intersected by ECDH private set intersection in step 5: both peers")
+	fmt.Println("  learn which pairs share one and nothing about the others. Linked pairs carry no scores.")
+	fmt.Println()
+	fmt.Println("CONTINUOUS VALIDATION (optional):")
+	fmt.Println("  - matching.holdout_file  CSV of known pairs, local IDs in id1 and the peer's in id2, such as")
+	fmt.Println("                           a clerically reviewed sample")
+	fmt.Println("  Once the peers agree on the intersection, the matches involving a listed record are scored")
+	fmt.Println("  against it, and the precision, recall and F1 go into the run manifest (quality) and")
+	fmt.Println("  'cohort-bridge runs show'. The holdout is never sent and is not part of the recipe.")
+	fmt.Println()
+	fmt.Println("DATASET SIZE HIDING (optional):")
+	fmt.Println("  - peer.padding_records  decoy records added to the tokens sent to the peer (default: 0)")
+	fmt.Println("  - peer.padding_jitter   up to this many more decoys, chosen at random each run (default: 0)")
+	fmt.Println("  Decoys are removed from the local results before they are saved.")
+	fmt.Println()
+	fmt.Println("CHECKPOINTS:")
+	fmt.Println("  Step 5 saves its progress every 1000 local records to <output-dir>/intersection_results_<dataset>.checkpoint")
+	fmt.Println("  and .partial, and the tokens sent in step 4 to .tokens (removed once step 5 completes).")
+	fmt.Println("  With -resume, the saved tokens are sent again and, if the peer also resumed with the same")
+	fmt.Println("  tokens, step 5 continues where it stopped; otherwise it starts over.")
+	fmt.Println()
+	fmt.Println("MONITORING (optional):")
+	fmt.Println("  - metrics.listen  address serving Prometheus /metrics while the workflow runs (e.g. :9464)")
 }
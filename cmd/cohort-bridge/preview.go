@@ -0,0 +1,322 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/crypto"
+	"github.com/auroradata-ai/cohort-bridge/internal/db"
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+	"github.com/auroradata-ai/cohort-bridge/internal/profile"
+	"github.com/auroradata-ai/cohort-bridge/internal/server"
+)
+
+// tokenPreview is what preview shows of a tokenized record
+type tokenPreview struct {
+	ID        string
+	Filter    *pprl.BloomFilter
+	Signature int // MinHash signature length
+	Blocking  int // Blocking keys
+}
+
+// previewFlags are the flags of the preview command
+type previewFlags struct {
+	fs          *flag.FlagSet
+	inputFile   *string
+	configFile  *string
+	inputFormat *string
+	numRecords  *int
+	help        *bool
+}
+
+// newPreviewFlags defines the flags of the preview command
+func newPreviewFlags() *previewFlags {
+	fs := newFlagSet("preview")
+	return &previewFlags{
+		fs:          fs,
+		inputFile:   fs.String("input", "", "Raw or tokenized file to preview"),
+		configFile:  fs.String("config", "", "Config with database.fields and column_mapping (optional)"),
+		inputFormat: fs.String("input-format", "", "Raw input format: csv, json or hl7 (default: from the file extension)"),
+		numRecords:  fs.Int("n", 10, "Number of records to show"),
+		help:        fs.Bool("help", false, "Show help message"),
+	}
+}
+
+func runPreviewCommand(args []string) {
+	f := newPreviewFlags()
+	f.fs.Parse(args)
+
+	if *f.help {
+		showPreviewHelp()
+		return
+	}
+
+	if *f.inputFile == "" {
+		showPreviewHelp()
+		fatalf(UsageError, "Error: -input is required")
+	}
+	if *f.numRecords <= 0 {
+		fatalf(UsageError, "Error: -n must be positive")
+	}
+
+	cfg := &config.Config{}
+	if *f.configFile != "" {
+		loaded, err := config.Load(*f.configFile)
+		if err != nil {
+			fatalf(ConfigError, "ERROR: Failed to load config: %v", err)
+		}
+		cfg = loaded
+	} else {
+		cfg.SetDefaults()
+	}
+
+	local, cleanup, err := stageInput(cfg, *f.inputFile)
+	if err != nil {
+		fatalf(DataError, "ERROR: %v", err)
+	}
+	defer cleanup()
+
+	fmt.Println("CohortBridge Record Preview")
+	fmt.Println("===========================")
+	fmt.Printf("Input: %s\n", *f.inputFile)
+	if isTokenizedFile(local) {
+		err = previewTokenized(cfg, local, *f.numRecords)
+	} else {
+		if *f.inputFormat == "" {
+			*f.inputFormat = detectInputFormat(local)
+		}
+		err = previewRaw(cfg, local, *f.inputFormat, *f.numRecords)
+	}
+	if err != nil {
+		cleanup()
+		fatalf(DataError, "ERROR: Preview failed: %v", err)
+	}
+}
+
+// isTokenizedFile reports whether path holds tokens rather than raw records: it has a token
+// manifest, is encrypted or a binary token store, or its first record decodes as tokens
+func isTokenizedFile(path string) bool {
+	if format, err := db.ReadTokenFormat(path); err == nil && format != nil {
+		return true
+	}
+	if pprl.IsBloomStore(path) || strings.HasSuffix(path, ".enc") {
+		return true
+	}
+	if columns, err := readCSVColumns(path, config.CSVDialect{}); err == nil && slices.Contains(columns, "bloom_filter") {
+		return true
+	}
+	reader, err := db.OpenTokenizedReader(path)
+	if err != nil {
+		return false
+	}
+	defer reader.Close()
+	record, err := reader.Next()
+	if err != nil {
+		return false
+	}
+	_, err = record.ToBloomFilterRecord()
+	return err == nil
+}
+
+// previewRaw prints the first records of a raw dataset, each field normalized as tokenize would
+// normalize it and then masked
+func previewRaw(cfg *config.Config, inputFile, inputFormat string, n int) error {
+	columns, err := newColumnMapping(cfg)
+	if err != nil {
+		return err
+	}
+	multiValue, err := newMultiValueColumns(cfg)
+	if err != nil {
+		return err
+	}
+	ids, err := newIDColumns(cfg)
+	if err != nil {
+		return err
+	}
+	folding, err := newUnicodeFolding(cfg.Tokenization)
+	if err != nil {
+		return err
+	}
+
+	records, err := loadTokenizeRecords(inputFile, inputFormat, cfg.Database.CSV, false)
+	if err != nil {
+		return err
+	}
+
+	// Fields as tokenize would choose them; JSON records name their own
+	specs := cfg.Database.Fields
+	var sourceColumns []string
+	if inputFormat == "csv" {
+		if sourceColumns, err = readCSVColumns(inputFile, cfg.Database.CSV); err != nil {
+			return fmt.Errorf("failed to read CSV header: %w", err)
+		}
+	}
+	if len(specs) == 0 {
+		specs = defaultFieldSpecs(columns, ids, inputFormat, sourceColumns)
+	}
+	if len(specs) == 0 && len(records) > 0 {
+		for field := range records[0] {
+			if !isIDColumn(field, ids) {
+				specs = append(specs, field)
+			}
+		}
+		sort.Strings(specs)
+	}
+	names, methods := parseFieldsWithNormalization(specs)
+	if len(names) == 0 {
+		return fmt.Errorf("no fields to preview")
+	}
+
+	width := len("id")
+	for _, name := range names {
+		width = max(width, len(previewFieldLabel(name, methods[name])))
+	}
+	fmt.Printf("Showing %d of %d records; values are normalized, then masked\n", min(n, len(records)), len(records))
+	for i, record := range records[:min(n, len(records))] {
+		record = columns.Apply(multiValue.Apply(record))
+		id := recordID(record, ids)
+		fmt.Println()
+		fmt.Printf("Record %d\n", i+1)
+		fmt.Printf("   %-*s  %s\n", width, "id", previewValue(id))
+		for _, name := range names {
+			var masked []string
+			for _, value := range pprl.SplitValues(record[name]) {
+				if folding != nil {
+					value = folding.Fold(value)
+				}
+				if normalized := crypto.NormalizeField(value, methods[name]); normalized != "" {
+					masked = append(masked, profile.Mask(normalized))
+				}
+			}
+			shown := strings.Join(masked, " | ")
+			if shown == "" {
+				shown = previewMissing
+			}
+			fmt.Printf("   %-*s  %s\n", width, previewFieldLabel(name, methods[name]), shown)
+		}
+	}
+	return nil
+}
+
+// previewTokenized prints the first records of a token file: masked IDs and what the tokens hold
+func previewTokenized(cfg *config.Config, inputFile string, n int) error {
+	var previews []tokenPreview
+	total := 0
+	if pprl.IsBloomStore(inputFile) {
+		store, err := pprl.OpenBloomStore(inputFile)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		_, _, s := store.Params()
+		total = store.Len()
+		for i := 0; i < min(n, total); i++ {
+			previews = append(previews, tokenPreview{ID: store.ID(i), Filter: store.Filter(i), Signature: int(s)})
+		}
+	} else {
+		// Encrypted files are decrypted in memory with the configured key sources
+		records, err := server.LoadTokenizedRecords(inputFile, false, defaultKeySources(cfg))
+		if err != nil {
+			return err
+		}
+		total = len(records)
+		for _, record := range records[:min(n, total)] {
+			filter, err := pprl.BloomFromBase64(record.BloomData)
+			if err != nil {
+				return fmt.Errorf("record %s: %w", profile.Mask(record.ID), err)
+			}
+			previews = append(previews, tokenPreview{ID: record.ID, Filter: filter, Signature: len(record.MinHash), Blocking: len(record.BlockingKeys)})
+		}
+	}
+
+	if format, err := db.ReadTokenFormat(inputFile); err == nil && format != nil {
+		fmt.Printf("Recipe: %s\n", format.Recipe)
+	}
+	fmt.Printf("Showing %d of %d tokenized records; IDs are masked\n", len(previews), total)
+	if len(previews) == 0 {
+		return nil
+	}
+	fmt.Println()
+	fmt.Printf("   %-16s %-24s %8s %9s\n", "ID", "BLOOM FILTER BITS SET", "MINHASH", "BLOCKING")
+	for _, preview := range previews {
+		size := preview.Filter.GetSize()
+		set := preview.Filter.SetBitCount()
+		fmt.Printf("   %-16s %-24s %8d %9d\n", previewValue(preview.ID),
+			fmt.Sprintf("%d/%d (%.1f%%)", set, size, 100*float64(set)/float64(size)), preview.Signature, preview.Blocking)
+	}
+	return nil
+}
+
+// recordID returns the ID of a raw record: its database.id_column ID, else its id column whatever
+// the case. An unusable composite ID is shown as the error.
+func recordID(record map[string]string, ids *pprl.IDColumns) string {
+	if ids != nil {
+		id, err := ids.ID(record)
+		if err != nil {
+			return "(" + err.Error() + ")"
+		}
+		return id
+	}
+	for column, value := range record {
+		if strings.EqualFold(strings.TrimPrefix(column, "\ufeff"), "id") {
+			return value
+		}
+	}
+	return ""
+}
+
+// previewFieldLabel labels a field with its normalization method
+func previewFieldLabel(name string, method crypto.NormalizationMethod) string {
+	if method == "" {
+		return name
+	}
+	return fmt.Sprintf("%s (%s)", name, method)
+}
+
+// previewMissing stands in for a missing value
+const previewMissing = "(missing)"
+
+// previewValue masks a value for display, marking missing ones
+func previewValue(value string) string {
+	if strings.TrimSpace(value) == "" {
+		return previewMissing
+	}
+	return profile.Mask(value)
+}
+
+func showPreviewHelp() {
+	fmt.Println("CohortBridge Record Preview")
+	fmt.Println("===========================")
+	fmt.Println()
+	fmt.Println("Shows the first records of a raw or tokenized file with every value masked, so")
+	fmt.Println("the field mapping can be checked without putting PHI on screen or in logs.")
+	fmt.Println()
+	fmt.Println("Raw records are read with the fields, column mapping, multi-valued columns and")
+	fmt.Println("Unicode folding of -config, normalized as tokenize would normalize them, and")
+	fmt.Println("shown by shape only: the first letter of each word and the first two digits of")
+	fmt.Println("the first long number, so 'John Doe' shows as j*** d** and 1980-03-05 as")
+	fmt.Println("19**-**-**. Tokenized files (CSV, JSON Lines, encrypted or binary) show the")
+	fmt.Println("masked ID and the Bloom filter fill, MinHash length and blocking keys of each")
+	fmt.Println("record.")
+	fmt.Println()
+	fmt.Println("USAGE:")
+	fmt.Println("  cohort-bridge preview -input data.csv [OPTIONS]")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Println("  -input string          Raw or tokenized file (s3://, gs:// or az:// objects use")
+	fmt.Println("                         the storage section of -config)")
+	fmt.Println("  -config string         Config with database.fields and column_mapping; without")
+	fmt.Println("                         it every column but id is shown with basic normalization")
+	fmt.Println("  -input-format string   Raw input format: csv, json or hl7 (default: from the")
+	fmt.Println("                         file extension)")
+	fmt.Println("  -n int                 Number of records to show (default: 10)")
+	fmt.Println("  -help                  Show this help message")
+	fmt.Println()
+	fmt.Println("EXAMPLES:")
+	fmt.Println("  cohort-bridge preview -input data/patients.csv -config config.yaml")
+	fmt.Println("  cohort-bridge preview -input out/tokens.csv -n 5")
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/hl7"
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+	"github.com/auroradata-ai/cohort-bridge/internal/profile"
+	"github.com/auroradata-ai/cohort-bridge/internal/store"
+)
+
+// hl7ProfileFields are profiled for HL7 input when database.fields is not set
+var hl7ProfileFields = []string{"name:" + hl7.FieldFirstName, "name:" + hl7.FieldLastName,
+	"date:" + hl7.FieldDateOfBirth, "gender:" + hl7.FieldGender, "zip:" + hl7.FieldZipCode}
+
+// profileFlags are the flags of the profile command
+type profileFlags struct {
+	fs          *flag.FlagSet
+	inputFile   *string
+	configFile  *string
+	inputFormat *string
+	outputFile  *string
+	help        *bool
+}
+
+// newProfileFlags defines the flags of the profile command
+func newProfileFlags() *profileFlags {
+	fs := newFlagSet("profile")
+	return &profileFlags{
+		fs:          fs,
+		inputFile:   fs.String("input", "", "Raw dataset to profile (CSV or HL7)"),
+		configFile:  fs.String("config", "", "Config with database.fields and column_mapping (optional)"),
+		inputFormat: fs.String("input-format", "", "Input format: csv or hl7 (default: from the file extension)"),
+		outputFile:  fs.String("output", "", "JSON report (default: out/<input>_profile.json)"),
+		help:        fs.Bool("help", false, "Show help message"),
+	}
+}
+
+func runProfileCommand(args []string) {
+	f := newProfileFlags()
+	f.fs.Parse(args)
+
+	if *f.help {
+		showProfileHelp()
+		return
+	}
+
+	if *f.inputFile == "" {
+		showProfileHelp()
+		fatalf(UsageError, "Error: -input is required")
+	}
+
+	cfg := &config.Config{}
+	if *f.configFile != "" {
+		loaded, err := config.Load(*f.configFile)
+		if err != nil {
+			fatalf(ConfigError, "ERROR: Failed to load config: %v", err)
+		}
+		cfg = loaded
+	} else {
+		cfg.SetDefaults()
+	}
+	if *f.inputFormat == "" {
+		*f.inputFormat = detectInputFormat(*f.inputFile)
+	}
+	if *f.inputFormat != "csv" && *f.inputFormat != "hl7" {
+		fatalf(UsageError, "Error: cannot profile %s input; use csv or hl7", *f.inputFormat)
+	}
+	if *f.outputFile == "" {
+		name := strings.TrimSuffix(filepath.Base(*f.inputFile), filepath.Ext(*f.inputFile))
+		*f.outputFile = filepath.Join("out", name+"_profile.json")
+	}
+
+	fmt.Println("CohortBridge Data Quality Profile")
+	fmt.Println("=================================")
+	fmt.Printf("Input: %s\n", *f.inputFile)
+
+	run := startRun("profile", cfg)
+	report, err := performProfile(cfg, *f.inputFile, *f.inputFormat, *f.outputFile, run)
+	if err != nil {
+		recordRun(run, err)
+		fatalf(DataError, "ERROR: Profiling failed: %v", err)
+	}
+	run.Counts["records"] = report.Records
+	run.Counts["duplicate_ids"] = report.DuplicateIDs.Groups
+	run.Counts["exact_duplicates"] = report.ExactDuplicates.Records - report.ExactDuplicates.Groups
+	run.Counts["near_duplicate_pairs"] = report.NearDuplicates.Pairs
+	run.Parameters["predicted_quality"] = report.Quality
+	run.Parameters["completeness"] = strconv.FormatFloat(report.Completeness, 'f', 4, 64)
+	recordRun(run, nil)
+}
+
+// performProfile profiles the dataset with the fields and column mapping of cfg, prints the
+// report and writes it to outputFile
+func performProfile(cfg *config.Config, inputFile, inputFormat, outputFile string, run *store.Run) (*profile.Report, error) {
+	local, cleanup, err := stageInput(cfg, inputFile)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	addStagedInput(run, local, inputFile)
+
+	columns, err := newColumnMapping(cfg)
+	if err != nil {
+		return nil, err
+	}
+	multiValue, err := newMultiValueColumns(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := newIDColumns(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fields as tokenize would choose them
+	specs := cfg.Database.Fields
+	var sourceColumns []string
+	if inputFormat == "csv" {
+		if sourceColumns, err = readCSVColumns(local, cfg.Database.CSV); err != nil {
+			return nil, fmt.Errorf("failed to read CSV header: %w", err)
+		}
+	}
+	if len(specs) == 0 {
+		specs = defaultFieldSpecs(columns, ids, inputFormat, sourceColumns)
+	}
+	names, methods := parseFieldsWithNormalization(specs)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no fields to profile")
+	}
+	fields := make([]profile.Field, len(names))
+	for i, name := range names {
+		fields[i] = profile.Field{Name: name, Method: methods[name]}
+	}
+	run.Parameters["fields"] = strings.Join(names, ",")
+	if columns != nil {
+		run.Parameters["column_mapping"] = columns.String()
+	}
+
+	folding, err := newUnicodeFolding(cfg.Tokenization)
+	if err != nil {
+		return nil, err
+	}
+	var fold func(string) string
+	if folding != nil {
+		fold = folding.Fold
+	}
+
+	records, err := loadTokenizeRecords(local, inputFormat, cfg.Database.CSV, false)
+	if err != nil {
+		return nil, err
+	}
+	profiler := profile.New(fields, fold)
+	profiler.CheckColumns(sourceColumns)
+	if columns == nil && sourceColumns != nil {
+		present := make(map[string]bool, len(sourceColumns))
+		for _, column := range sourceColumns {
+			present[column] = true
+		}
+		for _, name := range names {
+			if !present[name] {
+				return nil, fmt.Errorf("field %s is not a column of %s (columns: %s)", name, inputFile, strings.Join(sourceColumns, ", "))
+			}
+		}
+	}
+	if columns != nil && len(records) > 0 {
+		present := sourceColumns
+		if present == nil {
+			for column := range records[0] {
+				present = append(present, column)
+			}
+		}
+		if err := columns.Check(present, names); err != nil {
+			return nil, fmt.Errorf("database.column_mapping: %w", err)
+		}
+	}
+	for _, record := range records {
+		if len(sourceColumns) > 0 && strings.HasPrefix(sourceColumns[0], "\ufeff") {
+			// Reported by CheckColumns; read the column under its real name for the rest of the report
+			record[strings.TrimPrefix(sourceColumns[0], "\ufeff")] = record[sourceColumns[0]]
+		}
+		record = columns.Apply(multiValue.Apply(record))
+		if ids != nil {
+			record["id"], _ = ids.ID(record) // Unusable composite IDs are profiled as missing
+		}
+		for field, value := range record {
+			record[field] = pprl.FirstValue(value) // Multi-valued fields are profiled by their current value
+		}
+		profiler.Add(record)
+	}
+
+	report := profiler.Report()
+	printProfileReport(report)
+
+	if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	// The report quotes values from the data, so it stays readable by the owner only
+	if err := os.WriteFile(outputFile, append(data, '\n'), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write report: %w", err)
+	}
+	run.AddOutput(outputFile)
+	fmt.Printf("\nReport saved to: %s\n", outputFile)
+	return report, nil
+}
+
+// defaultFieldSpecs returns the fields of a dataset without database.fields: the mapped fields, the
+// HL7 demographics, or every CSV column but the ID columns
+func defaultFieldSpecs(columns *pprl.ColumnMapping, ids *pprl.IDColumns, inputFormat string, sourceColumns []string) []string {
+	switch {
+	case columns != nil:
+		return columns.Fields()
+	case inputFormat == "hl7":
+		return hl7ProfileFields
+	}
+	var specs []string
+	for _, column := range sourceColumns {
+		if !isIDColumn(strings.TrimPrefix(column, "\ufeff"), ids) {
+			specs = append(specs, column)
+		}
+	}
+	return specs
+}
+
+// isIDColumn reports whether a source column holds record IDs: one of the database.id_column
+// columns, else id in any case
+func isIDColumn(column string, ids *pprl.IDColumns) bool {
+	if ids == nil {
+		return strings.EqualFold(column, "id")
+	}
+	return slices.Contains(ids.Columns(), column)
+}
+
+// printProfileReport prints the per-field table, duplicates and the linkage quality prediction
+func printProfileReport(report *profile.Report) {
+	fmt.Printf("Records: %d\n\n", report.Records)
+	if report.Records == 0 {
+		return
+	}
+
+	fmt.Printf("%-20s %-10s %8s %8s %9s %8s\n", "FIELD", "METHOD", "MISSING", "INVALID", "DISTINCT", "U-PROB")
+	for _, field := range report.Fields {
+		fmt.Printf("%-20s %-10s %7.1f%% %7.1f%% %9d %8.4f\n", field.Name, field.Method,
+			100*field.MissingRate, 100*field.InvalidRate, field.Distinct, field.ChanceAgreement)
+	}
+
+	var invalid, encoding []string
+	for _, field := range report.Fields {
+		if len(field.InvalidReasons) > 0 {
+			invalid = append(invalid, fmt.Sprintf("   %-20s %s", field.Name, profile.FormatCounts(field.InvalidReasons)))
+		}
+		if len(field.EncodingIssues) > 0 {
+			encoding = append(encoding, fmt.Sprintf("   %-20s %s", field.Name, profile.FormatCounts(field.EncodingIssues)))
+		}
+	}
+	if len(invalid) > 0 {
+		fmt.Println("\nInvalid values:")
+		fmt.Println(strings.Join(invalid, "\n"))
+	}
+	if len(encoding) > 0 {
+		fmt.Println("\nEncoding issues:")
+		fmt.Println(strings.Join(encoding, "\n"))
+	} else {
+		fmt.Println("\nEncoding issues: none")
+	}
+
+	fmt.Println("\nSuspected duplicates:")
+	fmt.Printf("   Shared record IDs:  %d%s\n", report.DuplicateIDs.Groups, profileExamples(report.DuplicateIDs.Examples))
+	fmt.Printf("   Exact duplicates:   %d groups of %d records%s\n", report.ExactDuplicates.Groups, report.ExactDuplicates.Records, profileExamples(report.ExactDuplicates.Examples))
+	near := fmt.Sprintf("   One field differs:  %d pairs", report.NearDuplicates.Pairs)
+	if len(report.NearDuplicates.ByField) > 0 {
+		near += " (" + profile.FormatCounts(report.NearDuplicates.ByField) + ")"
+	}
+	fmt.Println(near + profileExamples(report.NearDuplicates.Examples))
+
+	fmt.Printf("\nPredicted linkage quality: %s\n", strings.ToUpper(report.Quality))
+	fmt.Printf("   Completeness:       %.1f%% of values present and valid\n", 100*report.Completeness)
+	fmt.Printf("   Complete records:   %.1f%%\n", 100*report.CompleteRecords)
+	fmt.Printf("   Chance agreements:  %.3g pairs of different people expected to agree on every field\n", report.ChanceAgreements)
+	if len(report.Warnings) > 0 {
+		fmt.Println("\nWarnings:")
+		for _, warning := range report.Warnings {
+			fmt.Printf("   - %s\n", warning)
+		}
+	}
+}
+
+// profileExamples formats the first example groups as " e.g. a+b, c+d"
+func profileExamples(examples [][]string) string {
+	if len(examples) == 0 {
+		return ""
+	}
+	groups := make([]string, 0, 3)
+	for _, group := range examples {
+		if len(groups) == cap(groups) {
+			break
+		}
+		groups = append(groups, strings.Join(group, "+"))
+	}
+	return ", e.g. " + strings.Join(groups, ", ")
+}
+
+func showProfileHelp() {
+	fmt.Println("CohortBridge Data Quality Profile")
+	fmt.Println("=================================")
+	fmt.Println()
+	fmt.Println("Scan a raw dataset before tokenization and predict how well it will link.")
+	fmt.Println("Fields, normalization methods and column mapping are read from -config as")
+	fmt.Println("tokenize would use them; nothing leaves the site.")
+	fmt.Println()
+	fmt.Println("USAGE:")
+	fmt.Println("  cohort-bridge profile -input data.csv [OPTIONS]")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Println("  -input string          Raw dataset (CSV or HL7; s3://, gs:// or az:// objects")
+	fmt.Println("                         use the storage section of -config)")
+	fmt.Println("  -config string         Config with database.fields and column_mapping; without")
+	fmt.Println("                         it every CSV column but id is profiled as is")
+	fmt.Println("  -input-format string   csv or hl7 (default: from the file extension)")
+	fmt.Println("  -output string         JSON report (default: out/<input>_profile.json)")
+	fmt.Println("  -help                  Show this help message")
+	fmt.Println()
+	fmt.Println("REPORT:")
+	fmt.Println("  - Missing and invalid value rates per field: unparseable, future or")
+	fmt.Println("    placeholder dates, ZIPs without 5 digits, unrecognized genders, digits,")
+	fmt.Println("    initials and placeholders in names")
+	fmt.Println("  - Character encoding damage: invalid UTF-8, replacement characters,")
+	fmt.Println("    mojibake (Ã©), control characters and byte order marks")
+	fmt.Println("  - Suspected duplicates: shared record IDs, records equal after")
+	fmt.Println("    normalization, and pairs that differ in a single field")
+	fmt.Println("  - Chance agreement (u-probability) per field and the expected number of")
+	fmt.Println("    different people agreeing on every field")
+	fmt.Println("  - Predicted linkage quality (good, fair or poor) with the reasons")
+	fmt.Println()
+	fmt.Println("  The JSON report quotes the most common value of each field; keep it on site.")
+	fmt.Println()
+	fmt.Println("EXAMPLES:")
+	fmt.Println("  cohort-bridge profile -input data/patients.csv -config config.yaml")
+	fmt.Println("  cohort-bridge profile -input adt_feed.hl7 -output out/feed_profile.json")
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/crypto"
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+	"github.com/auroradata-ai/cohort-bridge/internal/transfer"
+)
+
+// ReconcileOffer is offered in the recipe handshake by a party willing to reconcile differing
+// intersections, with the parameters it would re-compare the disputed pairs with
+type ReconcileOffer struct {
+	HammingThreshold uint32  `json:"hamming_threshold"`
+	JaccardThreshold float64 `json:"jaccard_threshold"`
+	Assignment       string  `json:"assignment"`
+	AllowDuplicates  bool    `json:"allow_duplicates,omitempty"`
+
+	// DistanceScale is the local recipe's hardening scale; it is not offered, as the recipe
+	// handshake already ensures both parties harden their filters alike
+	DistanceScale uint32 `json:"-"`
+}
+
+// newReconcileOffer describes the local matching parameters for matching.reconcile
+func newReconcileOffer(cfg *config.Config, allowDuplicates bool) *ReconcileOffer {
+	return &ReconcileOffer{
+		HammingThreshold: cfg.Matching.HammingThreshold,
+		JaccardThreshold: cfg.Matching.JaccardThreshold,
+		Assignment:       cfg.Matching.Assignment,
+		AllowDuplicates:  allowDuplicates,
+		DistanceScale:    hardeningScale(cfg.Tokenization),
+	}
+}
+
+// agreeReconciliation records the parameters both parties re-compare disputed pairs with when
+// both handshakes offered reconciliation: the stricter of each threshold, 1:1 matching unless
+// both allow duplicates, and greedy assignment unless both chose the same algorithm
+func agreeReconciliation(localRecipe, peerRecipe *RecipeHandshake) {
+	local, peer := localRecipe.Reconcile, peerRecipe.Reconcile
+	if local == nil || peer == nil {
+		localRecipe.agreedReconcile = nil
+		return
+	}
+	agreed := &ReconcileOffer{
+		HammingThreshold: min(local.HammingThreshold, peer.HammingThreshold),
+		JaccardThreshold: max(local.JaccardThreshold, peer.JaccardThreshold),
+		Assignment:       local.Assignment,
+		AllowDuplicates:  local.AllowDuplicates && peer.AllowDuplicates,
+		DistanceScale:    local.DistanceScale,
+	}
+	if local.Assignment != peer.Assignment {
+		agreed.Assignment = crypto.DefaultAssignment
+	}
+	localRecipe.agreedReconcile = agreed
+}
+
+// ReconcileReport records how a reconciliation resolved the pairs on which the parties differed.
+// Pairs are given from the local party's point of view.
+type ReconcileReport struct {
+	Parameters *ReconcileOffer             `json:"parameters"`
+	Agreed     int                         `json:"agreed_count"` // Pairs both intersections held
+	Accepted   []*match.PrivateMatchResult `json:"accepted"`     // Disputed pairs in the reconciled intersection
+	Rejected   []*match.PrivateMatchResult `json:"rejected"`     // Disputed pairs left out of it
+	Matches    int                         `json:"reconciled_count"`
+}
+
+// reconcileIntersections re-compares the pairs that only one party's intersection holds under the
+// agreed parameters. The reconciled intersection keeps every pair both parties found and adds the
+// disputed pairs that match; in 1:1 mode these take only records the agreed pairs leave free and
+// are assigned as the intersection protocol would. Both parties derive the same pairs from their
+// mirrored inputs.
+func reconcileIntersections(local, peer *IntersectionResult, localTokens, peerTokens *TokenData, params *ReconcileOffer, party int) (*IntersectionResult, *ReconcileReport, error) {
+	type pairKey struct{ local, peer string }
+	localPairs := make(map[pairKey]*match.PrivateMatchResult, len(local.Matches))
+	for _, m := range local.Matches {
+		localPairs[pairKey{m.LocalID, m.PeerID}] = m
+	}
+	// The peer's matches name its own record first
+	peerPairs := make(map[pairKey]bool, len(peer.Matches))
+	for _, m := range peer.Matches {
+		peerPairs[pairKey{m.PeerID, m.LocalID}] = true
+	}
+
+	var agreed []*match.PrivateMatchResult
+	var disputed []pairKey
+	for key, m := range localPairs {
+		if peerPairs[key] {
+			agreed = append(agreed, m)
+		} else {
+			disputed = append(disputed, key)
+		}
+	}
+	for key := range peerPairs {
+		if _, ok := localPairs[key]; !ok {
+			disputed = append(disputed, key)
+		}
+	}
+	sort.Slice(disputed, func(i, j int) bool {
+		if disputed[i].local != disputed[j].local {
+			return disputed[i].local < disputed[j].local
+		}
+		return disputed[i].peer < disputed[j].peer
+	})
+
+	usedLocal := make(map[string]bool, len(agreed))
+	usedPeer := make(map[string]bool, len(agreed))
+	for _, m := range agreed {
+		usedLocal[m.LocalID] = true
+		usedPeer[m.PeerID] = true
+	}
+
+	psi := crypto.NewSecurePSIProtocolWithThresholds(party, params.HammingThreshold, params.JaccardThreshold)
+	psi.DistanceScale = params.DistanceScale
+	report := &ReconcileReport{
+		Parameters: params,
+		Agreed:     len(agreed),
+		Accepted:   []*match.PrivateMatchResult{},
+		Rejected:   []*match.PrivateMatchResult{},
+	}
+	var candidates []crypto.PrivateMatchPair
+	for _, key := range disputed {
+		localToken, ok := localTokens.Records[key.local]
+		if !ok {
+			return nil, nil, fmt.Errorf("disputed pair names unknown local record %s", key.local)
+		}
+		peerToken, ok := peerTokens.Records[key.peer]
+		if !ok {
+			return nil, nil, fmt.Errorf("disputed pair names unknown peer record %s", key.peer)
+		}
+		localRecord, err := tokenRecordToPPRL(localToken)
+		if err != nil {
+			return nil, nil, err
+		}
+		peerRecord, err := tokenRecordToPPRL(peerToken)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		pair, isMatch := psi.ComparePair(localRecord, peerRecord)
+		if isMatch && (params.AllowDuplicates || !usedLocal[key.local] && !usedPeer[key.peer]) {
+			candidates = append(candidates, pair)
+		} else {
+			report.Rejected = append(report.Rejected, &match.PrivateMatchResult{LocalID: key.local, PeerID: key.peer})
+		}
+	}
+
+	accepted := candidates
+	if !params.AllowDuplicates {
+		accepted = crypto.AssignOneToOne(candidates, party, params.Assignment)
+		kept := make(map[pairKey]bool, len(accepted))
+		for _, pair := range accepted {
+			kept[pairKey{pair.LocalID, pair.PeerID}] = true
+		}
+		for _, pair := range candidates {
+			if !kept[pairKey{pair.LocalID, pair.PeerID}] {
+				report.Rejected = append(report.Rejected, &match.PrivateMatchResult{LocalID: pair.LocalID, PeerID: pair.PeerID})
+			}
+		}
+	}
+
+	reconciled := &IntersectionResult{Matches: agreed}
+	for _, pair := range accepted {
+		m := &match.PrivateMatchResult{LocalID: pair.LocalID, PeerID: pair.PeerID}
+		reconciled.Matches = append(reconciled.Matches, m)
+		report.Accepted = append(report.Accepted, m)
+	}
+	sort.Slice(reconciled.Matches, func(i, j int) bool {
+		a, b := reconciled.Matches[i], reconciled.Matches[j]
+		if a.LocalID != b.LocalID {
+			return a.LocalID < b.LocalID
+		}
+		return a.PeerID < b.PeerID
+	})
+	report.Matches = len(reconciled.Matches)
+	return reconciled, report, nil
+}
+
+// reconcileWithPeer reconciles the two intersections, then swaps digests of the reconciled
+// intersection with the peer. Equal digests are both parties' sign-off on the result.
+func reconcileWithPeer(transport peerTransport, local, peer *IntersectionResult, localTokens, peerTokens *TokenData, params *ReconcileOffer, party int) (*IntersectionResult, *ReconcileReport, error) {
+	fmt.Printf("   Re-comparing the differing pairs (Hamming <= %d, Jaccard >= %.3f)\n", params.HammingThreshold, params.JaccardThreshold)
+	reconciled, report, err := reconcileIntersections(local, peer, localTokens, peerTokens, params, party)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reconciliation failed: %v", err)
+	}
+	fmt.Printf("   Disputed pairs: %d accepted, %d rejected; reconciled intersection has %d matches\n",
+		len(report.Accepted), len(report.Rejected), report.Matches)
+
+	digest, err := newIntersectionDigest(reconciled)
+	if err != nil {
+		return nil, nil, err
+	}
+	peerDigest, err := transport.ConfirmReconciliation(digest)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !peerDigest.covers(reconciled) {
+		return nil, report, fmt.Errorf("the peer reconciled to a different intersection")
+	}
+	fmt.Printf("   Peer signed off on the reconciled intersection\n")
+	return reconciled, report, nil
+}
+
+// exchangeReconciledDigest swaps digests of the reconciled intersections over the transfer channel
+func exchangeReconciledDigest(channel *transfer.Channel, local *IntersectionDigest, isServer bool) (*IntersectionDigest, error) {
+	return swapDigests(channel, "reconciled_digest", "reconciled intersection digest", local, isServer)
+}
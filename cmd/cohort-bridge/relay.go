@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/relay"
+	"github.com/auroradata-ai/cohort-bridge/internal/server"
+)
+
+// relayFlags are the flags of the relay command
+type relayFlags struct {
+	fs          *flag.FlagSet
+	listen      *string
+	wait        *time.Duration
+	maxSessions *int
+	allow       *string
+	help        *bool
+}
+
+// newRelayFlags defines the flags of the relay command
+func newRelayFlags() *relayFlags {
+	fs := newFlagSet("relay")
+	return &relayFlags{
+		fs:          fs,
+		listen:      fs.String("listen", ":"+relay.DefaultPort, "Address to listen on"),
+		wait:        fs.Duration("wait", 10*time.Minute, "Longest a party waits for its peer to join the session"),
+		maxSessions: fs.Int("max-sessions", 64, "Sessions waiting or paired at once"),
+		allow:       fs.String("allow", "", "Comma-separated IPs or CIDR ranges allowed to connect (default: all)"),
+		help:        fs.Bool("help", false, "Show help message"),
+	}
+}
+
+func runRelayCommand(args []string) {
+	f := newRelayFlags()
+	f.fs.Parse(args)
+
+	if *f.help {
+		showRelayHelp()
+		return
+	}
+
+	var allowed []*net.IPNet
+	if *f.allow != "" {
+		var err error
+		if allowed, err = server.ParseAllowedIPs(strings.Split(*f.allow, ",")); err != nil {
+			fatalf(UsageError, "Invalid -allow: %v", err)
+		}
+	}
+
+	listener, err := net.Listen("tcp", *f.listen)
+	if err != nil {
+		fatalf(PeerError, "Failed to listen on %s: %v", *f.listen, err)
+	}
+	broker := relay.NewServer(relay.Options{WaitTimeout: *f.wait, MaxSessions: *f.maxSessions, AllowedIPs: allowed})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Relay listening on %s (parties wait up to %s for their peer)\n", listener.Addr(), *f.wait)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- broker.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, net.ErrClosed) {
+			fatalf(PeerError, "Relay failed: %v", err)
+		}
+	case <-ctx.Done():
+		listener.Close()
+	}
+	fmt.Println("\nRelay stopped")
+}
+
+func showRelayHelp() {
+	fmt.Println("CohortBridge Relay")
+	fmt.Println("==================")
+	fmt.Println()
+	fmt.Println("Broker for parties that cannot accept inbound connections. Both parties")
+	fmt.Println("dial out to the relay and name the same session; the relay pairs them and")
+	fmt.Println("forwards their bytes unchanged. It holds no keys and never parses the peer")
+	fmt.Println("protocol, so peer authentication, TLS and payload encryption run end to end.")
+	fmt.Println()
+	fmt.Println("USAGE:")
+	fmt.Println("  cohort-bridge relay [OPTIONS]")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Printf("  -listen string        Address to listen on (default: :%s)\n", relay.DefaultPort)
+	fmt.Println("  -wait duration        Longest a party waits for its peer (default: 10m)")
+	fmt.Println("  -max-sessions int     Sessions waiting or paired at once (default: 64)")
+	fmt.Println("  -allow string         Comma-separated IPs or CIDR ranges allowed to connect")
+	fmt.Println("                        (default: all)")
+	fmt.Println("  -help                 Show this help message")
+	fmt.Println()
+	fmt.Println("PARTIES:")
+	fmt.Println("  Set peer.relay (or pprl -relay) to relay://host[:port]/session in both")
+	fmt.Println("  configs instead of peer.host, peer.port and listen_port. The first party to")
+	fmt.Println("  join serves, the second dials; on the tcp transport both rejoin the session")
+	fmt.Println("  after a network failure and the transfer resumes.")
+	fmt.Println()
+	fmt.Println("  Session names are not secret: configure peer.api_key (or mTLS) so that each")
+	fmt.Println("  party proves itself to the other, and keep peer.payload_encryption on (the")
+	fmt.Println("  default) so the relay only ever sees sealed tokens. The parties confirm their")
+	fmt.Println("  handshakes with the recipe secrets, so a relay that strips a payload key fails")
+	fmt.Println("  the run; auto still accepts a peer whose payload encryption is off, so set")
+	fmt.Println("  peer.payload_encryption: required in both configs to refuse one.")
+	fmt.Println()
+	fmt.Println("EXAMPLES:")
+	fmt.Println("  cohort-bridge relay -listen :7700 -allow 203.0.113.0/24,198.51.100.7")
+	fmt.Println("  cohort-bridge pprl -config config.yaml -relay relay://relay.example.org/study-42 -force")
+}
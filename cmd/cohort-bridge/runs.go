@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/store"
+)
+
+// runsFlags are the flags of an action of runs
+type runsFlags struct {
+	fs      *flag.FlagSet
+	dbPath  *string
+	command *string
+	limit   *int
+	asJSON  *bool
+}
+
+// newRunsFlags defines the flags of an action of runs
+func newRunsFlags(action string) *runsFlags {
+	fs := newFlagSet("runs " + action)
+	return &runsFlags{
+		fs:      fs,
+		dbPath:  fs.String("db", runRegistry, "Run registry file"),
+		command: fs.String("command", "", "Only list runs of this command (tokenize, profile, intersect, dedupe, export, pprl, batch, bench, serve)"),
+		limit:   fs.Int("limit", 20, "Maximum number of runs to list (0 for all)"),
+		asJSON:  fs.Bool("json", false, "Print runs as JSON"),
+	}
+}
+
+func runRunsCommand(args []string) {
+	if len(args) == 0 || args[0] == "-help" || args[0] == "--help" || args[0] == "help" {
+		showRunsHelp()
+		return
+	}
+
+	action := args[0]
+	f := newRunsFlags(action)
+	f.fs.Parse(args[1:])
+
+	if *f.dbPath == "" {
+		fmt.Printf("Run recording is disabled (%s=off)\n", store.PathEnvVar)
+		return
+	}
+	if _, err := os.Stat(*f.dbPath); os.IsNotExist(err) {
+		fmt.Printf("No runs recorded yet (%s does not exist)\n", *f.dbPath)
+		return
+	}
+
+	registry, err := store.Open(*f.dbPath)
+	if err != nil {
+		fatalf(DataError, "ERROR: %v", err)
+	}
+	defer registry.Close()
+
+	switch action {
+	case "list":
+		runs, err := registry.List(*f.command, *f.limit)
+		if err != nil {
+			fatalf(DataError, "ERROR: %v", err)
+		}
+		if *f.asJSON {
+			printJSON(runs)
+			return
+		}
+		if len(runs) == 0 {
+			fmt.Println("No matching runs")
+			return
+		}
+		fmt.Printf("%-24s  %-10s  %-9s  %-20s  %s\n", "ID", "COMMAND", "STATUS", "STARTED", "DURATION")
+		for _, run := range runs {
+			fmt.Printf("%-24s  %-10s  %-9s  %-20s  %s\n", run.ID, run.Command, run.Status,
+				run.StartedAt.Local().Format("2006-01-02 15:04:05"), run.Duration().Round(time.Millisecond))
+		}
+
+	case "show":
+		if f.fs.NArg() != 1 {
+			fatalf(UsageError, "ERROR: usage: cohort-bridge runs show [-json] <run-id>")
+		}
+		run, err := registry.Get(f.fs.Arg(0))
+		if errors.Is(err, store.ErrNotFound) {
+			fatalf(DataError, "ERROR: no run with ID %s", f.fs.Arg(0))
+		} else if err != nil {
+			fatalf(DataError, "ERROR: %v", err)
+		}
+		if *f.asJSON {
+			printJSON(run)
+			return
+		}
+		showRun(run)
+
+	default:
+		showRunsHelp()
+		fatalf(UsageError, "Unknown runs action: %s", action)
+	}
+}
+
+// showRun prints every recorded detail of a run
+func showRun(run *store.Run) {
+	fmt.Printf("Run:      %s\n", run.ID)
+	fmt.Printf("Command:  %s\n", run.Command)
+	fmt.Printf("Status:   %s\n", run.Status)
+	if run.Error != "" {
+		fmt.Printf("Error:    %s\n", run.Error)
+	}
+	fmt.Printf("Started:  %s\n", run.StartedAt.Local().Format(time.RFC3339))
+	fmt.Printf("Duration: %s\n", run.Duration().Round(time.Millisecond))
+	if run.Build != nil {
+		fmt.Printf("Build:    %s", run.Build.Version)
+		if run.Build.GitCommit != "" {
+			fmt.Printf(" (%s)", run.Build.GitCommit)
+		}
+		fmt.Printf(", %s %s\n", run.Build.GoVersion, run.Build.Platform)
+	}
+	if len(run.Arguments) > 0 {
+		fmt.Printf("Command line: cohort-bridge %s\n", strings.Join(run.Arguments, " "))
+	}
+	if run.ConfigSHA256 != "" {
+		fmt.Printf("Config:   sha256 %s (shown with -json)\n", run.ConfigSHA256)
+	}
+
+	if len(run.Parameters) > 0 {
+		fmt.Println("Parameters:")
+		for _, name := range sortedKeys(run.Parameters) {
+			fmt.Printf("  %s: %s\n", name, run.Parameters[name])
+		}
+	}
+	if len(run.Inputs) > 0 {
+		fmt.Println("Inputs:")
+		for _, input := range run.Inputs {
+			if input.SHA256 == "" {
+				fmt.Printf("  %s (not hashed)\n", input.Path)
+				continue
+			}
+			fmt.Printf("  %s\n    sha256 %s (%d bytes)\n", input.Path, input.SHA256, input.Size)
+		}
+	}
+	if len(run.Counts) > 0 {
+		fmt.Println("Counts:")
+		for _, name := range sortedKeys(run.Counts) {
+			fmt.Printf("  %s: %d\n", name, run.Counts[name])
+		}
+	}
+	if q := run.Quality; q != nil {
+		fmt.Printf("Quality (holdout %s, %d known pairs):\n", q.Holdout, q.KnownPairs)
+		fmt.Printf("  TP %d  FP %d  FN %d  precision %.3f  recall %.3f  F1 %.3f\n",
+			q.TruePositives, q.FalsePositives, q.FalseNegatives, q.Precision, q.Recall, q.F1Score)
+	}
+	if len(run.Outputs) > 0 {
+		fmt.Println("Outputs:")
+		for _, output := range run.Outputs {
+			fmt.Printf("  %s\n", output)
+		}
+	}
+}
+
+// runRegistry is resolved at startup because the pprl workflow changes the working directory
+var runRegistry = store.ResolvePath()
+
+// startRun starts the run record of command with the build, the command line and the resolved
+// cfg (nil when the command has no configuration)
+func startRun(command string, cfg *config.Config) *store.Run {
+	run := store.NewRun(command)
+	run.Build = buildInfo()
+	run.Arguments = os.Args[1:]
+	if cfg != nil {
+		resolved, err := cfg.Resolved()
+		if err == nil {
+			err = run.SetConfig(resolved)
+		}
+		if err != nil {
+			fmt.Printf("Warning: failed to record the resolved configuration: %v\n", err)
+		}
+	}
+	return run
+}
+
+// recordRun finishes run with err, writes its manifest beside each output file and saves it to
+// the run registry; failures only warn
+func recordRun(run *store.Run, err error) {
+	run.Finish(err)
+	observeRun(run)
+	porcelain.setRun(run)
+	if _, manifestErr := run.WriteManifests(); manifestErr != nil {
+		fmt.Printf("Warning: %v\n", manifestErr)
+	}
+	if saveErr := store.Record(runRegistry, run); saveErr != nil {
+		fmt.Printf("Warning: failed to record run in registry: %v\n", saveErr)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func printJSON(v interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+func showRunsHelp() {
+	fmt.Println("CohortBridge Run History")
+	fmt.Println("========================")
+	fmt.Println()
+	fmt.Println("Every tokenize, profile, intersect, dedupe, export, pprl, batch, bench and serve job run is recorded with its")
+	fmt.Println("parameters, input file hashes, record/match counts and output paths.")
+	fmt.Println()
+	fmt.Println("USAGE:")
+	fmt.Println("  cohort-bridge runs list [OPTIONS]")
+	fmt.Println("  cohort-bridge runs show [OPTIONS] <run-id>")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Printf("  -db string            Run registry file (default: %s)\n", store.DefaultPath)
+	fmt.Println("  -command string       Only list runs of this command")
+	fmt.Println("  -limit int            Maximum number of runs to list (default: 20, 0 for all)")
+	fmt.Println("  -json                 Print runs as JSON")
+	fmt.Println()
+	fmt.Println("A unique prefix of a run ID is enough for 'show'.")
+	fmt.Printf("Set %s to move the registry, or to 'off' to disable recording.\n", store.PathEnvVar)
+}
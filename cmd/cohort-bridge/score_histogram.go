@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+)
+
+// histogramBarWidth is the widest bar of a printed histogram
+const histogramBarWidth = 40
+
+// runHistogramAnalysis scores every record pair, counts the scores of matches and non-matches of
+// the ground truth in the histogram and saves it
+func runHistogramAnalysis(records1, records2 []*pprl.Record, truth groundTruth, histogram *match.ScoreHistogram, filename string, distanceScale uint32) error {
+	fmt.Println("Computing score histogram...")
+	pairs, _, err := scoreValidationPairs(records1, records2, truth, distanceScale)
+	if err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		histogram.AddLabelled(pair.Hamming, pair.Jaccard, pair.Match)
+	}
+	return saveScoreHistogram(histogram, filename)
+}
+
+// saveScoreHistogram prints a histogram and writes it to filename, as JSON for a .json name and
+// CSV otherwise. It holds binned counts only, so it can be shared with the other party.
+func saveScoreHistogram(histogram *match.ScoreHistogram, filename string) error {
+	printScoreHistogram("Hamming distance", histogram.HammingBins(), "%.0f")
+	printScoreHistogram("Jaccard similarity", histogram.JaccardBins(), "%.2f")
+
+	if dir := filepath.Dir(filename); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	var err error
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		err = writeHistogramJSON(histogram, filename)
+	} else {
+		err = writeHistogramCSV(histogram, filename)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write score histogram: %w", err)
+	}
+	fmt.Printf("   Score histogram of %d comparisons saved to: %s (binned counts only, no record IDs)\n", histogram.Comparisons, filename)
+	return nil
+}
+
+// printScoreHistogram draws the bins that hold comparisons as bars on a log scale, so the few
+// matches still show beside the many non-matches
+func printScoreHistogram(score string, bins []match.HistogramBin, bound string) {
+	first, last, peak := -1, -1, 0
+	for i, bin := range bins {
+		if bin.Count > 0 {
+			if first < 0 {
+				first = i
+			}
+			last = i
+			peak = max(peak, bin.Count)
+		}
+	}
+	if first < 0 {
+		return
+	}
+
+	fmt.Printf("   %s (log scale):\n", score)
+	scale := math.Log10(float64(peak) + 1)
+	for _, bin := range bins[first : last+1] {
+		width := 0
+		if bin.Count > 0 {
+			width = max(1, int(math.Round(math.Log10(float64(bin.Count)+1)/scale*histogramBarWidth)))
+		}
+		label := fmt.Sprintf(bound+"-"+bound, bin.Low, bin.High)
+		line := fmt.Sprintf("   %11s %-*s %d", label, histogramBarWidth, strings.Repeat("#", width), bin.Count)
+		if bin.Matches != nil {
+			line += fmt.Sprintf(" (%d matches)", *bin.Matches)
+		}
+		fmt.Println(line)
+	}
+}
+
+// writeHistogramJSON writes the bin widths, the comparison count and both histograms as JSON
+func writeHistogramJSON(histogram *match.ScoreHistogram, filename string) error {
+	data, err := json.MarshalIndent(struct {
+		*match.ScoreHistogram
+		Hamming []match.HistogramBin `json:"hamming"`
+		Jaccard []match.HistogramBin `json:"jaccard"`
+	}{histogram, histogram.HammingBins(), histogram.JaccardBins()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// writeHistogramCSV writes one row per bin of each score; labelled histograms add the ground
+// truth matches of each bin
+func writeHistogramCSV(histogram *match.ScoreHistogram, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	header := []string{"score", "low", "high", "count"}
+	if histogram.Labelled {
+		header = append(header, "matches")
+	}
+	writer.Write(header)
+	for _, scored := range []struct {
+		score string
+		bins  []match.HistogramBin
+	}{
+		{"hamming", histogram.HammingBins()},
+		{"jaccard", histogram.JaccardBins()},
+	} {
+		for _, bin := range scored.bins {
+			row := []string{
+				scored.score,
+				strconv.FormatFloat(bin.Low, 'f', -1, 64),
+				strconv.FormatFloat(bin.High, 'f', -1, 64),
+				strconv.Itoa(bin.Count),
+			}
+			if bin.Matches != nil {
+				row = append(row, strconv.Itoa(*bin.Matches))
+			}
+			writer.Write(row)
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+	"github.com/auroradata-ai/cohort-bridge/internal/transfer"
+)
+
+// SecureMessage is one party's message of a secure comparison round on the tcp transport
+type SecureMessage struct {
+	Payload []byte `json:"payload"`
+}
+
+// checkSecureComparison fails for settings that need scores, which secure comparison never
+// reveals, and warns about the ones it ignores
+func checkSecureComparison(cfg *config.Config) error {
+	if cfg.Matching.CalibrationFile != "" || cfg.Matching.ProbabilityThreshold > 0 {
+		return fmt.Errorf("matching.calibration_file and matching.probability_threshold need the standard protocol: secure comparison reveals no scores")
+	}
+	if cfg.Matching.MinScore > 0 {
+		fmt.Printf("Warning: matching.min_score is ignored with matching.protocol smc (pairs carry no scores)\n")
+	}
+	if len(cfg.Tokenization.Blocking) > 0 {
+		fmt.Printf("Warning: tokenization.blocking is ignored with matching.protocol smc (every pair is compared)\n")
+	}
+	return nil
+}
+
+// secureComparisonTokens returns the tokens sent to the peer under secure comparison: the record
+// IDs alone, since the filters are compared without leaving this party
+func secureComparisonTokens(localTokens *TokenData) *TokenData {
+	ids := &TokenData{Records: make(map[string]TokenRecord, len(localTokens.Records))}
+	for id := range localTokens.Records {
+		ids.Records[id] = TokenRecord{ID: id}
+	}
+	return ids
+}
+
+// computeSMCIntersection matches the local tokens against the peer's by secure comparison of
+// their Bloom filters, running the protocol's rounds over the transport
+func computeSMCIntersection(transport peerTransport, localTokens *TokenData, cfg *config.Config, party int, allowDuplicates bool) (*IntersectionResult, error) {
+	fmt.Printf("   Using secure comparison (Party %d): Hamming threshold %d under secure computation\n", party, cfg.Matching.HammingThreshold)
+	fmt.Printf("   Bloom filters never leave this party; both learn only which pairs match\n")
+	if allowDuplicates {
+		fmt.Printf("   Matching mode: 1:many (duplicates allowed)\n")
+	} else {
+		fmt.Printf("   Matching mode: 1:1 (unique matches only, %s assignment)\n", cfg.Matching.Assignment)
+	}
+
+	localRecords, err := tokenDataToPPRLRecords(localTokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert local tokens: %v", err)
+	}
+
+	// Pairs carry no scores, so retention keeps no score floor and ranks pairs by ID alone
+	retention := matchRetention(cfg)
+	retention.MinJaccard = 0
+	fuzzyMatcher := match.NewFuzzyMatcher(&match.FuzzyMatchConfig{
+		Party:            party,
+		AllowDuplicates:  allowDuplicates,
+		HammingThreshold: cfg.Matching.HammingThreshold,
+		JaccardThreshold: cfg.Matching.JaccardThreshold,
+		Assignment:       cfg.Matching.Assignment,
+		Retention:        retention,
+		DistanceScale:    hardeningScale(cfg.Tokenization),
+	})
+	secureResult, err := fuzzyMatcher.ComputeSMCIntersection(transport.ExchangeSecureMessage, localRecords)
+	if err != nil {
+		return nil, fmt.Errorf("secure comparison failed: %v", err)
+	}
+	return &IntersectionResult{Matches: fuzzyMatcher.MatchResults(secureResult)}, nil
+}
+
+// swapSecureMessage sends local and receives the peer's message of a secure comparison round
+// over the transfer channel
+func swapSecureMessage(channel *transfer.Channel, local []byte, isServer bool) ([]byte, error) {
+	send := func() error {
+		if err := sendPeerMessage(channel, PeerMessage{Type: "secure_compare", Payload: SecureMessage{Payload: local}}); err != nil {
+			return fmt.Errorf("failed to send secure comparison message: %v", err)
+		}
+		return nil
+	}
+
+	var peer SecureMessage
+	receive := func() error {
+		var peerMessage PeerMessage
+		if err := receivePeerMessage(channel, &peerMessage); err != nil {
+			return fmt.Errorf("failed to receive peer secure comparison message: %v", err)
+		}
+		if peerMessage.Type != "secure_compare" {
+			return fmt.Errorf("unexpected message type: %s", peerMessage.Type)
+		}
+		if err := mapToStruct(peerMessage.Payload, &peer); err != nil {
+			return fmt.Errorf("failed to parse peer secure comparison message: %v", err)
+		}
+		return nil
+	}
+
+	// Server receives first, client sends first
+	steps := []func() error{send, receive}
+	if isServer {
+		steps = []func() error{receive, send}
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return nil, err
+		}
+	}
+	return peer.Payload, nil
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/keys"
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+)
+
+// selftestFields are the synthetic columns tokenized by both selftest parties
+var selftestFields = []string{"name:first_name", "name:last_name", "date:dob", "gender:gender", "zip:zip"}
+
+// selftestFlags are the flags of the selftest command
+type selftestFlags struct {
+	fs           *flag.FlagSet
+	configFile   *string
+	numRecords   *int
+	overlap      *float64
+	noise        *float64
+	seed         *int64
+	minPrecision *float64
+	minRecall    *float64
+	keep         *bool
+	help         *bool
+}
+
+// newSelftestFlags defines the flags of the selftest command
+func newSelftestFlags() *selftestFlags {
+	fs := newFlagSet("selftest")
+	return &selftestFlags{
+		fs:           fs,
+		configFile:   fs.String("config", "", "Optional configuration file for matching thresholds and recipe"),
+		numRecords:   fs.Int("records", 200, "Number of records per party"),
+		overlap:      fs.Float64("overlap", 0.5, "Fraction of records present at both parties"),
+		noise:        fs.Float64("noise", 0.1, "Probability of a typo in a shared record's name"),
+		seed:         fs.Int64("seed", 42, "Random seed for synthetic data"),
+		minPrecision: fs.Float64("min-precision", 0.95, "Minimum precision for the selftest to pass"),
+		minRecall:    fs.Float64("min-recall", 0.90, "Minimum recall for the selftest to pass"),
+		keep:         fs.Bool("keep", false, "Keep the selftest working directory"),
+		help:         fs.Bool("help", false, "Show help message"),
+	}
+}
+
+func runSelftestCommand(args []string) {
+	f := newSelftestFlags()
+	f.fs.Parse(args)
+
+	if *f.help {
+		showSelftestHelp()
+		return
+	}
+
+	if *f.numRecords <= 0 || *f.overlap < 0 || *f.overlap > 1 || *f.noise < 0 || *f.noise > 1 {
+		fatalf(UsageError, "ERROR: -records must be positive and -overlap/-noise must be between 0 and 1")
+	}
+
+	cfg := &config.Config{}
+	if *f.configFile != "" {
+		loaded, err := config.Load(*f.configFile)
+		if err != nil {
+			fatalf(ConfigError, "ERROR: Failed to load config: %v", err)
+		}
+		cfg = loaded
+		if cfg.Matching.CalibrationFile != "" {
+			if abs, err := filepath.Abs(cfg.Matching.CalibrationFile); err == nil {
+				cfg.Matching.CalibrationFile = abs
+			}
+		}
+	} else {
+		cfg.SetDefaults()
+	}
+	cfg.Database.Fields = selftestFields
+
+	fmt.Println("CohortBridge Selftest")
+	fmt.Println("=====================")
+	fmt.Printf("Records per party: %d\n", *f.numRecords)
+	fmt.Printf("Overlap: %.0f%%  Noise: %.0f%%  Seed: %d\n", *f.overlap*100, *f.noise*100, *f.seed)
+	fmt.Printf("Recipe: %s\n", cfg.RecipeSummary())
+	fmt.Println()
+
+	passed, err := runSelftest(cfg, *f.numRecords, *f.overlap, *f.noise, *f.seed, *f.minPrecision, *f.minRecall, *f.keep)
+	if err != nil {
+		fatalf(InternalError, "ERROR: Selftest failed: %v", err)
+	}
+	if !passed {
+		fmt.Println("SELFTEST FAILED")
+		os.Exit(1)
+	}
+	fmt.Println("SELFTEST PASSED")
+}
+
+// runSelftest generates seeded synthetic data, runs both parties over loopback and
+// checks that the intersections agree and meet the precision/recall targets
+func runSelftest(cfg *config.Config, numRecords int, overlap, noise float64, seed int64, minPrecision, minRecall float64, keep bool) (bool, error) {
+	workDir, err := os.MkdirTemp("", "cohort-selftest-")
+	if err != nil {
+		return false, fmt.Errorf("failed to create working directory: %v", err)
+	}
+	if keep || isDebugMode() {
+		fmt.Printf("Working directory: %s\n\n", workDir)
+	} else {
+		defer os.RemoveAll(workDir)
+	}
+
+	// compareIntersectionResults writes its diff into the working directory
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(workDir); err != nil {
+		return false, err
+	}
+
+	fmt.Println("STEP 1: Generating Synthetic Data")
+	groundTruth, err := generateSelftestData("party_a.csv", "party_b.csv", numRecords, overlap, noise, seed)
+	if err != nil {
+		return false, err
+	}
+	fmt.Printf("   %d records per party, %d true matches\n", numRecords, len(groundTruth))
+	fmt.Println()
+
+	fmt.Println("STEP 2: Tokenizing Both Datasets")
+	recordConfig, err := newRecordConfig(cfg.Tokenization, cfg.Keys)
+	if err != nil {
+		return false, fmt.Errorf("invalid tokenization recipe: %v", err)
+	}
+	fields, normalizationConfig := parseFieldsWithNormalization(cfg.Database.Fields)
+	for _, name := range []string{"party_a", "party_b"} {
+		_, err := performTokenization(name+".csv", name+"_tokens.csv", "csv", config.CSVDialect{}, "csv", 1000, recordConfig,
+			false, fields, keys.EncryptOptions{}, "", true, normalizationConfig, nil, nil)
+		if err != nil {
+			return false, fmt.Errorf("tokenization of %s failed: %v", name, err)
+		}
+	}
+	fmt.Println()
+
+	fmt.Println("STEP 3: Running Both Parties on Loopback")
+	// Each party has a config, and so a recipe handshake and payload key pair, of its own
+	configA, configB := *cfg, *cfg
+	partyA := &simulateParty{Name: "A", Config: &configA, RecordConfig: recordConfig, TokenizedFile: "party_a_tokens.csv"}
+	partyB := &simulateParty{Name: "B", Config: &configB, RecordConfig: recordConfig, TokenizedFile: "party_b_tokens.csv"}
+	if err := assignLoopbackPorts(partyA, partyB); err != nil {
+		return false, err
+	}
+	transport := cfg.Peer.Transport
+	if transport == "" {
+		transport = "grpc"
+	}
+	fmt.Printf("   Party B listens on port %d, party A connects to it (%s transport)\n", partyB.Config.ListenPort, transport)
+
+	results := make(chan *simulateParty, 2)
+	start := func(party *simulateParty) {
+		go func() {
+			party.Err = runSelftestParty(party)
+			results <- party
+		}()
+	}
+	// As in simulate, A is started once B listens, so that A connects rather than listening as well
+	start(partyB)
+	if err := waitForListener(partyB.Config.ListenPort, results); err != nil {
+		return false, fmt.Errorf("party B: %v", err)
+	}
+	start(partyA)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case party := <-results:
+			if party.Err != nil {
+				return false, fmt.Errorf("party %s: %v", party.Name, party.Err)
+			}
+		case <-time.After(5 * time.Minute):
+			return false, fmt.Errorf("timed out waiting for parties")
+		}
+	}
+	fmt.Println()
+
+	fmt.Println("STEP 4: Comparing Intersections")
+	passed := true
+	identical, diffFile, err := compareIntersectionResults(partyA.Intersection, partyB.Intersection)
+	if err != nil {
+		return false, err
+	}
+	if identical {
+		fmt.Printf("   PASS: Both parties computed identical intersections (%d matches)\n", len(partyA.Intersection.Matches))
+	} else {
+		fmt.Printf("   FAIL: Intersections differ (diff: %s)\n", filepath.Join(workDir, diffFile))
+		passed = false
+	}
+	fmt.Println()
+
+	fmt.Println("STEP 5: Scoring Against Ground Truth")
+	precision, recall, truePositives := scoreSelftestMatches(partyA.Intersection.Matches, groundTruth)
+	fmt.Printf("   True positives: %d / %d matches (%d expected)\n", truePositives, len(partyA.Intersection.Matches), len(groundTruth))
+	fmt.Printf("   Precision: %.3f (minimum %.3f)\n", precision, minPrecision)
+	fmt.Printf("   Recall:    %.3f (minimum %.3f)\n", recall, minRecall)
+	if precision < minPrecision {
+		fmt.Println("   FAIL: Precision below minimum")
+		passed = false
+	}
+	if recall < minRecall {
+		fmt.Println("   FAIL: Recall below minimum")
+		passed = false
+	}
+	fmt.Println()
+
+	return passed, nil
+}
+
+// runSelftestParty runs the exchange and intersection steps of the pprl workflow for one party,
+// connecting to the other over the configured transport
+func runSelftestParty(party *simulateParty) error {
+	cfg := party.Config
+	localRecipe, err := newRecipeHandshake(cfg, party.RecordConfig)
+	if err != nil {
+		return fmt.Errorf("invalid peer configuration: %v", err)
+	}
+	auth, err := newPeerAuth(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid peer authentication: %v", err)
+	}
+	transport, err := connectPeer(cfg, auth, nil)
+	if err != nil {
+		return fmt.Errorf("failed to establish peer connection: %v", err)
+	}
+	defer transport.Close()
+
+	localTokens, err := loadTokenizedData(party.TokenizedFile)
+	if err != nil {
+		return fmt.Errorf("failed to load local tokens: %v", err)
+	}
+	peerTokens, err := transport.ExchangeTokens(localRecipe, localTokens)
+	if err != nil {
+		return fmt.Errorf("token exchange failed: %v", err)
+	}
+
+	partyNumber := 0
+	if transport.IsServer() {
+		partyNumber = 1
+	}
+	intersection, err := computeZeroKnowledgeIntersection(localTokens, peerTokens, cfg, partyNumber, false, nil)
+	if err != nil {
+		return fmt.Errorf("intersection computation failed: %v", err)
+	}
+	if _, err := transport.ExchangeIntersection(intersection); err != nil {
+		return fmt.Errorf("intersection exchange failed: %v", err)
+	}
+	party.Intersection = intersection
+	return nil
+}
+
+// scoreSelftestMatches computes precision and recall of matches against ground truth (party A ID -> party B ID)
+func scoreSelftestMatches(matches []*match.PrivateMatchResult, groundTruth map[string]string) (float64, float64, int) {
+	truePositives := 0
+	for _, m := range matches {
+		if groundTruth[m.LocalID] == m.PeerID || groundTruth[m.PeerID] == m.LocalID {
+			truePositives++
+		}
+	}
+
+	precision, recall := 1.0, 1.0
+	if len(matches) > 0 {
+		precision = float64(truePositives) / float64(len(matches))
+	}
+	if len(groundTruth) > 0 {
+		recall = float64(truePositives) / float64(len(groundTruth))
+	}
+	return precision, recall, truePositives
+}
+
+// generateSelftestData writes two synthetic patient CSVs sharing a seeded fraction of people
+// and returns the ground truth mapping party A IDs to party B IDs
+func generateSelftestData(fileA, fileB string, numRecords int, overlap, noise float64, seed int64) (map[string]string, error) {
+	rng := rand.New(rand.NewSource(seed))
+
+	firstNames := []string{"James", "Mary", "John", "Patricia", "Robert", "Jennifer", "Michael", "Linda",
+		"William", "Elizabeth", "David", "Barbara", "Richard", "Susan", "Joseph", "Jessica", "Thomas", "Sarah",
+		"Charles", "Karen", "Daniel", "Nancy", "Matthew", "Lisa", "Anthony", "Margaret", "Mark", "Sandra"}
+	lastNames := []string{"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis",
+		"Rodriguez", "Martinez", "Hernandez", "Lopez", "Gonzalez", "Wilson", "Anderson", "Thomas", "Taylor",
+		"Moore", "Jackson", "Martin", "Lee", "Perez", "Thompson", "White", "Harris", "Sanchez", "Clark"}
+
+	newPerson := func() []string {
+		dob := time.Date(1940, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, rng.Intn(365*65))
+		gender := "M"
+		if rng.Intn(2) == 0 {
+			gender = "F"
+		}
+		return []string{
+			firstNames[rng.Intn(len(firstNames))],
+			lastNames[rng.Intn(len(lastNames))],
+			dob.Format("2006-01-02"),
+			gender,
+			fmt.Sprintf("%05d", 10000+rng.Intn(89999)),
+		}
+	}
+
+	typo := func(s string) string {
+		if len(s) < 3 {
+			return s
+		}
+		pos := 1 + rng.Intn(len(s)-1)
+		return s[:pos] + string(rune('a'+rng.Intn(26))) + s[pos+1:]
+	}
+
+	shared := int(float64(numRecords) * overlap)
+	groundTruth := make(map[string]string)
+
+	// sources records which party A row each party B row was derived from (-1 for none)
+	var rowsA, rowsB [][]string
+	var sources []int
+	for i := 0; i < numRecords; i++ {
+		person := newPerson()
+		rowsA = append(rowsA, append([]string{fmt.Sprintf("a_%04d", i)}, person...))
+
+		if i < shared {
+			peer := append([]string{}, person...)
+			if rng.Float64() < noise {
+				field := rng.Intn(2)
+				peer[field] = typo(peer[field])
+			}
+			rowsB = append(rowsB, append([]string{""}, peer...))
+			sources = append(sources, i)
+		} else {
+			rowsB = append(rowsB, append([]string{""}, newPerson()...))
+			sources = append(sources, -1)
+		}
+	}
+
+	// Shuffle party B so matching cannot rely on row order
+	rng.Shuffle(len(rowsB), func(i, j int) {
+		rowsB[i], rowsB[j] = rowsB[j], rowsB[i]
+		sources[i], sources[j] = sources[j], sources[i]
+	})
+	for i, row := range rowsB {
+		row[0] = fmt.Sprintf("b_%04d", i)
+		if sources[i] >= 0 {
+			groundTruth[rowsA[sources[i]][0]] = row[0]
+		}
+	}
+
+	header := []string{"id", "first_name", "last_name", "dob", "gender", "zip"}
+	if err := writeSelftestCSV(fileA, header, rowsA); err != nil {
+		return nil, err
+	}
+	if err := writeSelftestCSV(fileB, header, rowsB); err != nil {
+		return nil, err
+	}
+	return groundTruth, nil
+}
+
+// writeSelftestCSV writes a header and rows to a CSV file
+func writeSelftestCSV(filename string, header []string, rows [][]string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", filename, err)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(rows); err != nil {
+		return fmt.Errorf("failed to write %s: %v", filename, err)
+	}
+	return nil
+}
+
+func showSelftestHelp() {
+	fmt.Println("CohortBridge Selftest")
+	fmt.Println("=====================")
+	fmt.Println()
+	fmt.Println("Run the full PPRL pipeline end to end on this machine: two in-process")
+	fmt.Println("parties tokenize seeded synthetic data, exchange tokens over loopback,")
+	fmt.Println("compute and compare their intersections, and score the result against")
+	fmt.Println("the known ground truth. Exits non-zero on any failure.")
+	fmt.Println()
+	fmt.Println("The parties connect with the configured peer.transport (grpc unless -config")
+	fmt.Println("selects tcp), each with its own payload keys, on free loopback ports.")
+	fmt.Println()
+	fmt.Println("USAGE:")
+	fmt.Println("  cohort-bridge selftest [OPTIONS]")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Println("  -config string         Config for matching thresholds and tokenization recipe")
+	fmt.Println("  -records int           Records per party (default: 200)")
+	fmt.Println("  -overlap float         Fraction of records at both parties (default: 0.5)")
+	fmt.Println("  -noise float           Probability of a name typo in shared records (default: 0.1)")
+	fmt.Println("  -seed int              Random seed for synthetic data (default: 42)")
+	fmt.Println("  -min-precision float   Minimum precision to pass (default: 0.95)")
+	fmt.Println("  -min-recall float      Minimum recall to pass (default: 0.90)")
+	fmt.Println("  -keep                  Keep the working directory with data and tokens")
+	fmt.Println("  -help                  Show this help message")
+	fmt.Println()
+	fmt.Println("EXAMPLES:")
+	fmt.Println("  cohort-bridge selftest")
+	fmt.Println("  cohort-bridge selftest -records 1000 -noise 0.2 -min-recall 0.8")
+	fmt.Println("  cohort-bridge selftest -config config.yaml -keep")
+}
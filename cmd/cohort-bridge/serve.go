@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/crypto"
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+	"github.com/auroradata-ai/cohort-bridge/internal/server"
+	"github.com/auroradata-ai/cohort-bridge/internal/store"
+)
+
+// apiKeysEnvVar lets container deployments pass API keys without writing them to the config
+const apiKeysEnvVar = "COHORT_API_KEYS"
+
+// serveMaxHeaderBytes bounds request headers; the API needs only a key and a few short headers
+const serveMaxHeaderBytes = 64 << 10
+
+// serveFlags are the flags of the serve command
+type serveFlags struct {
+	fs              *flag.FlagSet
+	configFile      *string
+	listen          *string
+	pidFile         *string
+	allowDuplicates *bool
+	help            *bool
+}
+
+// newServeFlags defines the flags of the serve command
+func newServeFlags() *serveFlags {
+	fs := newFlagSet("serve")
+	return &serveFlags{
+		fs:              fs,
+		configFile:      fs.String("config", "", "Configuration file"),
+		listen:          fs.String("listen", "", "Address to listen on (overrides serve.listen)"),
+		pidFile:         fs.String("pid-file", "", "Write the process ID here (overrides serve.pid_file)"),
+		allowDuplicates: fs.Bool("allow-duplicates", false, "Allow 1:many matching (default: 1:1 matching only)"),
+		help:            fs.Bool("help", false, "Show help message"),
+	}
+}
+
+func runServeCommand(args []string) {
+	f := newServeFlags()
+	f.fs.Parse(args)
+
+	if *f.help {
+		showServeHelp()
+		return
+	}
+
+	if *f.configFile == "" {
+		showServeHelp()
+		fatalf(UsageError, "Error: -config is required")
+	}
+
+	cfg, err := config.Load(*f.configFile)
+	if err != nil {
+		fatalf(ConfigError, "Failed to load configuration: %v", err)
+	}
+	if *f.listen != "" {
+		cfg.Serve.Listen = *f.listen
+	}
+	if *f.pidFile != "" {
+		cfg.Serve.PIDFile = *f.pidFile
+	}
+	if err := crypto.ValidateAssignment(cfg.Matching.Assignment); err != nil {
+		fatalf(ConfigError, "Invalid matching configuration: %v", err)
+	}
+
+	fmt.Println("CohortBridge Receiver Daemon")
+	fmt.Println("============================")
+	fmt.Printf("Local Dataset: %s\n", cfg.Database.Filename)
+	fmt.Printf("Listen Address: %s\n", cfg.Serve.Listen)
+	fmt.Printf("Concurrent Jobs: %d\n", cfg.Serve.MaxConcurrentJobs)
+	fmt.Println()
+
+	if cfg.Logging.EnableAudit || cfg.Logging.File != "" {
+		if err := server.InitLogger(cfg, "serve"); err != nil {
+			fatalf(DataError, "Failed to open log files: %v", err)
+		}
+	}
+
+	apiKeys, err := loadAPIKeys(cfg)
+	if err != nil {
+		fatalf(CryptoError, "Failed to load API keys: %v", err)
+	}
+
+	// The local dataset is loaded once and shared read-only by every job
+	if !cfg.Database.IsTokenized {
+		fatalf(ConfigError, "serve requires a pre-tokenized local dataset (database.is_tokenized: true); run 'cohort-bridge tokenize' first")
+	}
+	localTokens, err := loadTokenizedData(cfg.Database.Filename)
+	if err != nil {
+		fatalf(DataError, "Failed to load local tokens: %v", err)
+	}
+	fmt.Printf("Loaded %d local records\n", len(localTokens.Records))
+
+	recordConfig, err := newRecordConfig(cfg.Tokenization, cfg.Keys)
+	if err != nil {
+		fatalf(ConfigError, "Invalid tokenization recipe: %v", err)
+	}
+
+	runner := func(datasetFile string) ([]*match.PrivateMatchResult, error) {
+		run := startRun("serve", cfg)
+		run.Parameters["job"] = filepath.Base(filepath.Dir(datasetFile))
+		run.Parameters["allow_duplicates"] = strconv.FormatBool(*f.allowDuplicates)
+		run.AddInput(cfg.Database.Filename)
+		run.AddInput(datasetFile)
+		run.Counts["local_records"] = len(localTokens.Records)
+
+		matches, err := runServeJob(localTokens, datasetFile, cfg, *f.allowDuplicates, run)
+		recordRun(run, err)
+		return matches, err
+	}
+
+	security, err := server.NewSecurityManager(cfg)
+	if err != nil {
+		fatalf(ConfigError, "Invalid security configuration: %v", err)
+	}
+	if len(cfg.Security.AllowedIPs) > 0 {
+		fmt.Printf("Allowed Clients: %s\n", strings.Join(cfg.Security.AllowedIPs, ", "))
+	}
+
+	daemon, err := server.NewDaemon(server.DaemonConfig{
+		APIKeys:           apiKeys,
+		JobsDir:           cfg.Serve.JobsDir,
+		MaxConcurrentJobs: cfg.Serve.MaxConcurrentJobs,
+		MaxUploadBytes:    cfg.Serve.MaxUploadMB << 20,
+		MaxQueuedJobs:     cfg.Serve.MaxQueuedJobs,
+		RequestTimeout:    cfg.Security.RequestTimeout,
+		RecipeFingerprint: cfg.RecipeFingerprint(recordConfig.LinkageSecret),
+		RecipeSummary:     cfg.RecipeSummary(),
+	}, runner, security)
+	if err != nil {
+		fatalf(InternalError, "Failed to start daemon: %v", err)
+	}
+
+	runDaemon("Daemon", *f.configFile, cfg, security, daemon)
+}
+
+// runServeJob intersects a submitted dataset with the local tokens, noting counts on run
+func runServeJob(localTokens *TokenData, datasetFile string, cfg *config.Config, allowDuplicates bool, run *store.Run) ([]*match.PrivateMatchResult, error) {
+	peerTokens, err := loadTokenizedData(datasetFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load submitted tokens: %v", err)
+	}
+	run.Counts["peer_records"] = len(peerTokens.Records)
+
+	// The daemon plays the receiving party, as the listening side does in pprl
+	intersection, err := computeSecureIntersection(localTokens, peerTokens, cfg, 1, allowDuplicates, nil)
+	if err != nil {
+		return nil, err
+	}
+	run.Counts["matches"] = len(intersection.Matches)
+	return intersection.Matches, nil
+}
+
+// loadAPIKeys collects API keys from the config, the key file and the environment
+func loadAPIKeys(cfg *config.Config) ([]string, error) {
+	apiKeys := append([]string{}, cfg.Serve.APIKeys...)
+
+	if cfg.Serve.APIKeysFile != "" {
+		file, err := os.Open(cfg.Serve.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				apiKeys = append(apiKeys, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, key := range strings.Split(os.Getenv(apiKeysEnvVar), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			apiKeys = append(apiKeys, key)
+		}
+	}
+
+	if len(apiKeys) == 0 {
+		return nil, fmt.Errorf("no API keys configured (set serve.api_keys, serve.api_keys_file or %s)", apiKeysEnvVar)
+	}
+	return apiKeys, nil
+}
+
+func showServeHelp() {
+	fmt.Println("CohortBridge Receiver Daemon")
+	fmt.Println("============================")
+	fmt.Println()
+	fmt.Println("Long-running receiver that accepts tokenized datasets over an authenticated")
+	fmt.Println("REST API and intersects each one with the local tokenized dataset")
+	fmt.Println()
+	fmt.Println("USAGE:")
+	fmt.Println("  cohort-bridge serve -config config.yaml [OPTIONS]")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Println("  -config string        Configuration file (required)")
+	fmt.Println("  -listen string        Address to listen on (default: serve.listen or :8090)")
+	fmt.Println("  -pid-file string      Write the process ID here (default: serve.pid_file)")
+	fmt.Println("  -allow-duplicates     Allow 1:many matching (default: 1:1 matching only)")
+	fmt.Println("  -help                 Show this help message")
+	fmt.Println()
+	fmt.Println("ENDPOINTS (all but /healthz need 'Authorization: Bearer <api key>'):")
+	fmt.Println("  GET  /healthz                 Liveness; 503 while draining")
+	fmt.Println("  GET  /v1/recipe               Tokenization recipe fingerprint and summary")
+	fmt.Println("  POST /v1/jobs                 Submit a tokenized CSV as the request body")
+	fmt.Println("                                (send the recipe fingerprint in X-Recipe-Fingerprint)")
+	fmt.Println("  GET  /v1/jobs                 List jobs")
+	fmt.Println("  GET  /v1/jobs/{id}            Job status")
+	fmt.Println("  GET  /v1/jobs/{id}/result     Matches of a succeeded job")
+	fmt.Println("  GET  /metrics                 Prometheus metrics (also served without a key on")
+	fmt.Println("                                metrics.listen when set)")
+	fmt.Println()
+	fmt.Println("Uploads may be sent with 'Content-Encoding: zstd' or 'gzip'; results are")
+	fmt.Println("compressed when the client sends a matching Accept-Encoding.")
+	fmt.Println()
+	fmt.Println("CONFIGURATION (serve section):")
+	fmt.Println("  listen, api_keys, api_keys_file, tls_cert_file, tls_key_file, jobs_dir,")
+	fmt.Println("  max_concurrent_jobs, max_upload_mb, max_queued_jobs, shutdown_timeout")
+	fmt.Printf("  API keys may also be given comma-separated in %s\n", apiKeysEnvVar)
+	fmt.Println()
+	fmt.Println("HARDENING (security section):")
+	fmt.Println("  allowed_ips          IPs or CIDR ranges allowed to connect (default: all)")
+	fmt.Println("  requests_per_min     API requests per minute per IP (default: 300)")
+	fmt.Println("  rate_limit_per_min   dataset submissions per minute per IP (default: 5)")
+	fmt.Println("  max_connections      requests served at once; the rest get 503 (default: 100)")
+	fmt.Println("  request_timeout      deadline for requests other than uploads (default: 30s)")
+	fmt.Println("  Uploads are bounded by serve.max_upload_mb (after decompression) and")
+	fmt.Println("  timeouts.read_timeout; serve.max_queued_jobs bounds the datasets held on disk.")
+	fmt.Println("  Rejections are audited (logging.enable_audit, logging.audit_file).")
+	fmt.Println()
+	fmt.Println("RUNNING AS A SERVICE:")
+	fmt.Println("  SIGINT/SIGTERM stop accepting jobs, cancel queued ones and let running jobs")
+	fmt.Println("  finish within serve.shutdown_timeout; a second signal exits at once.")
+	fmt.Println("  SIGHUP reloads the config: API keys, the security section, the log level and")
+	fmt.Println("  TLS certificate, and reopens the log files; other settings need a restart.")
+	fmt.Println("  serve.pid_file is written at startup and removed on exit; a stale one left")
+	fmt.Println("  by a crash is replaced. Datasets of jobs interrupted by a crash are removed.")
+	fmt.Println("  systemd Type=notify units are told when the daemon is ready, reloading and")
+	fmt.Println("  stopping, and WatchdogSec= is honored. As a Windows service, sc.exe stop")
+	fmt.Println("  drains the daemon and paramchange reloads it.")
+	fmt.Println("  logging.file (and the audit log) rotate at logging.max_size MB, keeping")
+	fmt.Println("  logging.max_backups files for logging.max_age days.")
+	fmt.Println()
+	fmt.Println("EXAMPLES:")
+	fmt.Println("  cohort-bridge serve -config config_serve.example.yaml")
+	fmt.Println()
+	fmt.Println("  curl -H \"Authorization: Bearer $KEY\" -H \"X-Recipe-Fingerprint: $FP\" \\")
+	fmt.Println("       --data-binary @tokens.csv http://localhost:8090/v1/jobs")
+	fmt.Println()
+	fmt.Println("  zstd tokens.csv && curl -H \"Authorization: Bearer $KEY\" -H \"X-Recipe-Fingerprint: $FP\" \\")
+	fmt.Println("       -H \"Content-Encoding: zstd\" --data-binary @tokens.csv.zst http://localhost:8090/v1/jobs")
+}
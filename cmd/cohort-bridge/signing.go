@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/keys"
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+)
+
+// intersectionSigningContext is the Ed25519ctx context of intersection signatures
+const intersectionSigningContext = "cohort-bridge intersection v1"
+
+// IntersectionSignature is a detached signature over an intersection sent to the peer
+type IntersectionSignature struct {
+	KeyID string `json:"key_id"` // keys.SigningKeyID of the signer's public key
+	Value []byte `json:"value"`
+}
+
+// intersectionKeys holds this party's signing key and the peer's pinned public key, either may be nil
+type intersectionKeys struct {
+	signing    ed25519.PrivateKey
+	peerPublic ed25519.PublicKey
+}
+
+// loadIntersectionKeys loads peer.signing_key_file and peer.peer_public_key
+func loadIntersectionKeys(cfg *config.Config) (*intersectionKeys, error) {
+	k := &intersectionKeys{}
+	if cfg.Peer.SigningKeyFile != "" {
+		signing, err := keys.LoadSigningKey(cfg.Peer.SigningKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		k.signing = signing
+	}
+	if cfg.Peer.PeerPublicKey != "" {
+		public, err := keys.ParsePublicKey(cfg.Peer.PeerPublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("peer.peer_public_key: %v", err)
+		}
+		k.peerPublic = public
+	}
+	return k, nil
+}
+
+// intersectionSigningPayload is the byte string signed for an intersection: the signer's matches,
+// the recipe fingerprint and the digest of the tokens the signer matched against (the verifier's),
+// so a signature cannot be replayed for another recipe or another dataset
+func intersectionSigningPayload(matches []*match.PrivateMatchResult, fingerprint, tokensDigest string) ([]byte, error) {
+	if matches == nil {
+		matches = []*match.PrivateMatchResult{}
+	}
+	return json.Marshal(struct {
+		RecipeFingerprint string                      `json:"recipe_fingerprint"`
+		TokensDigest      string                      `json:"tokens_digest"`
+		Matches           []*match.PrivateMatchResult `json:"matches"`
+	}{fingerprint, tokensDigest, matches})
+}
+
+// tokenDigest returns a SHA-256 digest of a token set, independent of record order
+func tokenDigest(tokens *TokenData) string {
+	ids := make([]string, 0, len(tokens.Records))
+	for id := range tokens.Records {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	h := sha256.New()
+	for _, id := range ids {
+		record := tokens.Records[id]
+		fmt.Fprintf(h, "%s\x00%s\x00%s\n", record.ID, record.BloomFilter, record.MinHash)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// signIntersection attaches a signature to an intersection computed against peerTokens
+func (k *intersectionKeys) signIntersection(intersection *IntersectionResult, fingerprint string, peerTokens *TokenData) error {
+	payload, err := intersectionSigningPayload(intersection.Matches, fingerprint, tokenDigest(peerTokens))
+	if err != nil {
+		return err
+	}
+	value, err := keys.Sign(k.signing, intersectionSigningContext, payload)
+	if err != nil {
+		return err
+	}
+	intersection.Signature = &IntersectionSignature{
+		KeyID: keys.SigningKeyID(k.signing.Public().(ed25519.PublicKey)),
+		Value: value,
+	}
+	return nil
+}
+
+// verifyIntersection checks the peer's signature on an intersection it computed against localTokens
+func (k *intersectionKeys) verifyIntersection(intersection *IntersectionResult, fingerprint string, localTokens *TokenData) error {
+	if intersection.Signature == nil {
+		return fmt.Errorf("peer intersection is not signed, but peer.peer_public_key is pinned")
+	}
+	if expected := keys.SigningKeyID(k.peerPublic); intersection.Signature.KeyID != expected {
+		return fmt.Errorf("peer intersection is signed with key %s, but the pinned key is %s", intersection.Signature.KeyID, expected)
+	}
+	payload, err := intersectionSigningPayload(intersection.Matches, fingerprint, tokenDigest(localTokens))
+	if err != nil {
+		return err
+	}
+	if err := keys.Verify(k.peerPublic, intersectionSigningContext, payload, intersection.Signature.Value); err != nil {
+		return fmt.Errorf("peer intersection signature is invalid: it was altered or not computed over this exchange")
+	}
+	return nil
+}
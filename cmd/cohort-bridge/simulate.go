@@ -0,0 +1,504 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/crypto"
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+	"github.com/auroradata-ai/cohort-bridge/internal/store"
+)
+
+// simulateParty is one party of a simulated run: its config, and the outcome of its goroutine
+type simulateParty struct {
+	Name          string
+	Config        *config.Config
+	RecordConfig  *pprl.RecordConfig
+	TokenizedFile string
+
+	Intersection *IntersectionResult
+	Verification string // How the intersections were checked: digest, full or reconciled
+	Decoys       int
+	Err          error
+}
+
+// simulateFlags are the flags of the simulate command
+type simulateFlags struct {
+	fs              *flag.FlagSet
+	configA         *string
+	configB         *string
+	groundTruthFile *string
+	outputDir       *string
+	transport       *string
+	allowDuplicates *bool
+	help            *bool
+	thresholdFlags  *thresholdFlags
+}
+
+// newSimulateFlags defines the flags of the simulate command
+func newSimulateFlags() *simulateFlags {
+	fs := newFlagSet("simulate")
+	return &simulateFlags{
+		fs:              fs,
+		configA:         fs.String("config-a", "", "Configuration file of party A"),
+		configB:         fs.String("config-b", "", "Configuration file of party B"),
+		groundTruthFile: fs.String("ground-truth", "", "Ground truth CSV of (party A ID, party B ID) pairs to score the intersection against"),
+		outputDir:       fs.String("output-dir", "out", "Directory receiving both parties' results"),
+		transport:       fs.String("transport", "", "Peer transport: grpc or tcp (overrides peer.transport of both configs)"),
+		allowDuplicates: fs.Bool("allow-duplicates", false, "Allow 1:many matching (default: 1:1 matching only)"),
+		help:            fs.Bool("help", false, "Show help message"),
+		thresholdFlags:  addThresholdFlags(fs),
+	}
+}
+
+func runSimulateCommand(args []string) {
+	f := newSimulateFlags()
+	f.fs.Parse(args)
+
+	if *f.help {
+		showSimulateHelp()
+		return
+	}
+
+	if *f.configA == "" || *f.configB == "" {
+		showSimulateHelp()
+		fatalf(UsageError, "Error: -config-a and -config-b are required")
+	}
+
+	parties := make([]*simulateParty, 0, 2)
+	for _, party := range []struct{ name, file string }{{"A", *f.configA}, {"B", *f.configB}} {
+		cfg, err := config.Load(party.file)
+		if err != nil {
+			fatalf(ConfigError, "Failed to load configuration of party %s: %v", party.name, err)
+		}
+		if *f.transport != "" {
+			cfg.Peer.Transport = *f.transport
+		}
+		if cfg.Peer.Transport == "" {
+			cfg.Peer.Transport = "grpc"
+		}
+		if err := crypto.ValidateAssignment(cfg.Matching.Assignment); err != nil {
+			fatalf(ConfigError, "Invalid matching configuration of party %s: %v", party.name, err)
+		}
+		if err := crypto.ValidateProtocol(cfg.Matching.Protocol); err != nil {
+			fatalf(ConfigError, "Invalid matching configuration of party %s: %v", party.name, err)
+		}
+		parties = append(parties, &simulateParty{Name: party.name, Config: cfg})
+	}
+	partyA, partyB := parties[0], parties[1]
+	if !strings.EqualFold(partyA.Config.Peer.Transport, partyB.Config.Peer.Transport) {
+		fatalf(ConfigError, "The parties use different peer transports (%q and %q); set -transport", partyA.Config.Peer.Transport, partyB.Config.Peer.Transport)
+	}
+
+	// Flags override the configs' thresholds, which override the defaults; both parties match alike
+	thresholds := f.thresholdFlags.resolve(partyA.Config, partyB.Config)
+	thresholds.Apply(partyA.Config)
+	thresholds.Apply(partyB.Config)
+
+	fmt.Println("CohortBridge Two-Party Simulation")
+	fmt.Println("=================================")
+	fmt.Printf("Party A: %s (%s)\n", partyA.Config.Database.Filename, *f.configA)
+	fmt.Printf("Party B: %s (%s)\n", partyB.Config.Database.Filename, *f.configB)
+	fmt.Printf("Hamming threshold: %d (%s)\n", thresholds.Hamming, thresholds.HammingSource)
+	fmt.Printf("Jaccard threshold: %.3f (%s)\n", thresholds.Jaccard, thresholds.JaccardSource)
+	fmt.Println()
+
+	run := startRun("simulate", partyA.Config)
+	run.Parameters["config_a"] = *f.configA
+	run.Parameters["config_b"] = *f.configB
+	run.Parameters["hamming_threshold"] = strconv.FormatUint(uint64(thresholds.Hamming), 10)
+	run.Parameters["jaccard_threshold"] = strconv.FormatFloat(thresholds.Jaccard, 'g', -1, 64)
+	run.Parameters["allow_duplicates"] = strconv.FormatBool(*f.allowDuplicates)
+	run.AddInput(partyA.Config.Database.Filename)
+	run.AddInput(partyB.Config.Database.Filename)
+
+	err := runSimulation(partyA, partyB, *f.groundTruthFile, *f.outputDir, *f.allowDuplicates, run)
+	recordRun(run, err)
+	if err != nil {
+		fatalf(DataError, "ERROR: Simulation failed: %v", err)
+	}
+	fmt.Println()
+	fmt.Println("SIMULATION COMPLETED SUCCESSFULLY!")
+}
+
+// runSimulation tokenizes both parties' datasets, runs the pprl protocol between them over
+// loopback, compares their intersections and scores them against the ground truth, if any
+func runSimulation(partyA, partyB *simulateParty, groundTruthFile, outputDir string, allowDuplicates bool, run *store.Run) error {
+	// Resolve the paths of both configs before moving to the workspace
+	for _, party := range []*simulateParty{partyA, partyB} {
+		cfg := party.Config
+		for _, path := range []*string{&cfg.Database.Filename, &cfg.Peer.TLSCertFile, &cfg.Peer.TLSKeyFile, &cfg.Peer.TLSCAFile, &cfg.Matching.CalibrationFile} {
+			if *path != "" {
+				if abs, err := filepath.Abs(*path); err == nil {
+					*path = abs
+				}
+			}
+		}
+		recordConfig, err := newRecordConfig(cfg.Tokenization, cfg.Keys)
+		if err != nil {
+			return fmt.Errorf("party %s: invalid tokenization recipe: %v", party.Name, err)
+		}
+		if recordConfig.Columns, err = newColumnMapping(cfg); err != nil {
+			return fmt.Errorf("party %s: invalid column mapping: %v", party.Name, err)
+		}
+		if recordConfig.IDColumns, err = newIDColumns(cfg); err != nil {
+			return fmt.Errorf("party %s: invalid ID columns: %v", party.Name, err)
+		}
+		if recordConfig.MultiValue, err = newMultiValueColumns(cfg); err != nil {
+			return fmt.Errorf("party %s: invalid multi-valued columns: %v", party.Name, err)
+		}
+		if recordConfig.Sanitizer, err = newSanitizer(cfg); err != nil {
+			return fmt.Errorf("party %s: invalid input sanitization: %v", party.Name, err)
+		}
+		party.RecordConfig = recordConfig
+	}
+	if groundTruthFile != "" {
+		if abs, err := filepath.Abs(groundTruthFile); err == nil {
+			groundTruthFile = abs
+		}
+	}
+	outputDir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve output directory: %v", err)
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
+
+	// Both parties' tokens and intersections stay in one workspace; compareIntersectionResults
+	// writes its diff into the working directory
+	ws, err := newWorkspace(partyA.Config, "simulate")
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	if err := os.Chdir(ws.Dir); err != nil {
+		return err
+	}
+
+	fmt.Println("STEP 1: Tokenizing Both Datasets")
+	for _, party := range []*simulateParty{partyA, partyB} {
+		fmt.Printf("   Party %s:\n", party.Name)
+		tokenized, err := performTokenizationStep(party.Config, party.RecordConfig, ws.Path(fmt.Sprintf("party_%s_tokens.csv", strings.ToLower(party.Name))), run)
+		if err != nil {
+			return fmt.Errorf("party %s: tokenization failed: %v", party.Name, err)
+		}
+		party.TokenizedFile = tokenized
+	}
+	fmt.Println()
+
+	fmt.Println("STEP 2: Running Both Parties on Loopback")
+	if err := assignLoopbackPorts(partyA, partyB); err != nil {
+		return err
+	}
+	fmt.Printf("   Party B listens on port %d, party A connects to it (%s transport)\n", partyB.Config.ListenPort, partyB.Config.Peer.Transport)
+	run.Parameters["transport"] = partyA.Config.Peer.Transport
+
+	results := make(chan *simulateParty, 2)
+	start := func(party *simulateParty) {
+		go func() {
+			party.Err = runSimulatedParty(party, allowDuplicates)
+			results <- party
+		}()
+	}
+
+	// B dials A's port first and, finding nothing there, listens on its own; A is started once
+	// B's port is taken, so A connects rather than listening as well
+	start(partyB)
+	if err := waitForListener(partyB.Config.ListenPort, results); err != nil {
+		return fmt.Errorf("party B: %v", err)
+	}
+	start(partyA)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case party := <-results:
+			if party.Err != nil {
+				return fmt.Errorf("party %s: %v", party.Name, party.Err)
+			}
+		case <-time.After(partyA.Config.Timeouts.TokenExchange + partyA.Config.Timeouts.IntersectionExchange + 5*time.Minute):
+			return fmt.Errorf("timed out waiting for the parties")
+		}
+	}
+	run.Parameters["intersection_verification"] = partyA.Verification
+	run.Counts["decoy_records"] = partyA.Decoys + partyB.Decoys
+	fmt.Println()
+
+	fmt.Println("STEP 3: Comparing Intersections")
+	identical, diffFile, err := compareIntersectionResults(partyA.Intersection, partyB.Intersection)
+	if err != nil {
+		return err
+	}
+	if !identical {
+		diffOutputPath := filepath.Join(outputDir, "simulate_intersection_diff.json")
+		if err := copyToAbsolutePath(diffFile, diffOutputPath); err == nil {
+			run.AddOutput(diffOutputPath)
+			return fmt.Errorf("the parties' intersections differ (diff: %s)", diffOutputPath)
+		}
+		return fmt.Errorf("the parties' intersections differ")
+	}
+	fmt.Printf("   Both parties computed identical intersections (%d matches, verified by %s)\n", len(partyA.Intersection.Matches), partyA.Verification)
+	run.Counts["matches"] = len(partyA.Intersection.Matches)
+
+	for _, party := range []*simulateParty{partyA, partyB} {
+		outputPath := filepath.Join(outputDir, fmt.Sprintf("simulate_intersection_%s.json", strings.ToLower(party.Name)))
+		if err := saveWorkflowIntersectionResults(party.Intersection, outputPath); err != nil {
+			return fmt.Errorf("failed to save the intersection of party %s: %v", party.Name, err)
+		}
+		fmt.Printf("   Party %s results saved to: %s\n", party.Name, outputPath)
+		run.AddOutput(outputPath)
+	}
+
+	if groundTruthFile == "" {
+		return nil
+	}
+
+	fmt.Println()
+	fmt.Println("STEP 4: Scoring Against Ground Truth")
+	truth, err := loadGroundTruth(groundTruthFile)
+	if err != nil {
+		return err
+	}
+	idsA, err := newIDColumns(partyA.Config)
+	if err != nil {
+		return err
+	}
+	idsB, err := newIDColumns(partyB.Config)
+	if err != nil {
+		return err
+	}
+	truth = truth.keyed(idsA, idsB)
+	run.AddInput(groundTruthFile)
+
+	// Party A's matches pair its own IDs (ID1) with party B's (ID2), as the ground truth does
+	result := validateResults(partyA.Intersection.Matches, nil, truth)
+	fmt.Printf("   True positives: %d  False positives: %d  False negatives: %d\n", result.TruePositives, result.FalsePositives, result.FalseNegatives)
+	fmt.Printf("   Precision: %.3f  Recall: %.3f  F1: %.3f\n", result.Precision, result.Recall, result.F1Score)
+	run.Counts["true_positives"] = result.TruePositives
+	run.Counts["false_positives"] = result.FalsePositives
+	run.Counts["false_negatives"] = result.FalseNegatives
+
+	reportPath := filepath.Join(outputDir, "simulate_validation.csv")
+	if err := saveValidationReport(result, reportPath, len(truth), nil, false); err != nil {
+		return err
+	}
+	fmt.Printf("   Validation report saved to: %s\n", reportPath)
+	run.AddOutput(reportPath)
+	return nil
+}
+
+// assignLoopbackPorts points the parties at each other on free loopback ports
+func assignLoopbackPorts(partyA, partyB *simulateParty) error {
+	ports := make([]int, 0, 2)
+	for len(ports) < 2 {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return fmt.Errorf("failed to find a free loopback port: %v", err)
+		}
+		ports = append(ports, listener.Addr().(*net.TCPAddr).Port)
+		defer listener.Close()
+	}
+
+	for i, party := range []*simulateParty{partyA, partyB} {
+		if party.Config.Peer.Relay != "" {
+			fmt.Printf("   Party %s: ignoring peer.relay, the simulation runs on loopback\n", party.Name)
+			party.Config.Peer.Relay = ""
+		}
+		party.Config.ListenPort = ports[i]
+		party.Config.Peer.Host = "127.0.0.1"
+		party.Config.Peer.Port = ports[1-i]
+	}
+	return nil
+}
+
+// waitForListener waits until port is taken by the party listening on it, or the party ends
+func waitForListener(port int, results chan *simulateParty) error {
+	deadline := time.Now().Add(time.Minute)
+	for time.Now().Before(deadline) {
+		select {
+		case party := <-results:
+			results <- party
+			if party.Err != nil {
+				return party.Err
+			}
+			return errors.New("ended before its peer connected")
+		default:
+		}
+		// Binding the port fails once the party listens on it; a connection would be taken for the peer's
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			return nil
+		}
+		listener.Close()
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("did not listen on port %d", port)
+}
+
+// runSimulatedParty runs steps 3 to 7 of the pprl workflow for one party: connect, exchange tokens,
+// intersect, and verify (and, if agreed, reconcile) the intersection with the peer
+func runSimulatedParty(party *simulateParty, allowDuplicates bool) error {
+	cfg := party.Config
+	localRecipe, err := newRecipeHandshake(cfg, party.RecordConfig)
+	if err != nil {
+		return fmt.Errorf("invalid peer configuration: %v", err)
+	}
+	secureComparison := cfg.Matching.Protocol == crypto.ProtocolSMC
+	if secureComparison {
+		if err := checkSecureComparison(cfg); err != nil {
+			return fmt.Errorf("invalid matching configuration: %v", err)
+		}
+	}
+	exactOnly, exactFirstPass := cfg.Matching.Protocol == crypto.ProtocolPSI, usesExactFirstPass(cfg)
+	if cfg.Matching.Reconcile && cfg.Matching.ProbabilityThreshold == 0 && !secureComparison && !exactOnly && !exactFirstPass {
+		localRecipe.Reconcile = newReconcileOffer(cfg, allowDuplicates)
+	}
+	auth, err := newPeerAuth(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid peer authentication: %v", err)
+	}
+
+	transport, err := connectPeer(cfg, auth, nil)
+	if err != nil {
+		return fmt.Errorf("failed to establish peer connection: %v", err)
+	}
+	defer transport.Close()
+
+	localTokens, err := loadTokenizedData(party.TokenizedFile)
+	if err != nil {
+		return fmt.Errorf("failed to load local tokens: %v", err)
+	}
+	decoys, err := padLocalTokens(localTokens, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to pad local tokens: %v", err)
+	}
+	party.Decoys = len(decoys)
+	if exactOnly || exactFirstPass {
+		if err := checkExactPSI(cfg, localTokens); err != nil {
+			return fmt.Errorf("invalid matching configuration: %v", err)
+		}
+	}
+	sentTokens := withoutExactIDs(localTokens)
+	if secureComparison || exactOnly {
+		sentTokens = secureComparisonTokens(localTokens)
+	}
+	peerTokens, err := transport.ExchangeTokens(localRecipe, sentTokens)
+	if err != nil {
+		return fmt.Errorf("token exchange failed: %v", err)
+	}
+	fmt.Printf("   Party %s: exchanged %d local tokens for %d peer tokens\n", party.Name, len(localTokens.Records), len(peerTokens.Records))
+
+	partyNumber := 0
+	if transport.IsServer() {
+		partyNumber = 1
+	}
+	var exactMatches *IntersectionResult
+	fuzzyLocal, fuzzyPeer := localTokens, peerTokens
+	if exactOnly || exactFirstPass {
+		if exactMatches, err = computeExactIntersection(transport, localTokens, cfg, partyNumber, allowDuplicates); err != nil {
+			return fmt.Errorf("intersection computation failed: %v", err)
+		}
+		if exactFirstPass {
+			fuzzyLocal, fuzzyPeer = withoutLinked(localTokens, peerTokens, exactMatches)
+		}
+	}
+	var intersection *IntersectionResult
+	switch {
+	case exactOnly:
+		intersection = exactMatches
+	case secureComparison:
+		intersection, err = computeSMCIntersection(transport, fuzzyLocal, cfg, partyNumber, allowDuplicates)
+	default:
+		intersection, err = computeZeroKnowledgeIntersection(fuzzyLocal, fuzzyPeer, cfg, partyNumber, allowDuplicates, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("intersection computation failed: %v", err)
+	}
+	if exactFirstPass {
+		intersection.Matches = append(exactMatches.Matches, intersection.Matches...)
+	}
+
+	// As in pprl: digests first, then the full intersections, then reconciliation if both offered it
+	digestsMatch, err := verifyIntersectionDigest(transport, intersection)
+	if err != nil {
+		return fmt.Errorf("intersection exchange failed: %v", err)
+	}
+	party.Verification = "digest"
+	if !digestsMatch {
+		party.Verification = "full"
+		peerIntersection, err := transport.ExchangeIntersection(intersection)
+		if err != nil {
+			return fmt.Errorf("intersection exchange failed: %v", err)
+		}
+		digest, err := newIntersectionDigest(intersection)
+		if err != nil {
+			return err
+		}
+		if !digest.covers(peerIntersection) && localRecipe.agreedReconcile != nil {
+			intersection, _, err = reconcileWithPeer(transport, intersection, peerIntersection, localTokens, peerTokens, localRecipe.agreedReconcile, partyNumber)
+			if err != nil {
+				return fmt.Errorf("reconciliation failed: %v", err)
+			}
+			party.Verification = "reconciled"
+		}
+	}
+
+	// Decoys are dropped from each party's own results, as in pprl
+	removeDecoyMatches(intersection, decoys)
+	party.Intersection = intersection
+	return nil
+}
+
+func showSimulateHelp() {
+	fmt.Println("CohortBridge Two-Party Simulation")
+	fmt.Println("=================================")
+	fmt.Println()
+	fmt.Println("Runs the whole pprl protocol between two parties in one process: both datasets")
+	fmt.Println("are tokenized, then each party runs in its own goroutine and connects to the")
+	fmt.Println("other over loopback with the configured transport, authentication and padding.")
+	fmt.Println("The parties' intersections are compared and, with -ground-truth, scored.")
+	fmt.Println("Useful to try a pair of configs before deploying them at two sites.")
+	fmt.Println()
+	fmt.Println("The peer addresses, listen ports and relay of the configs are replaced by free")
+	fmt.Println("loopback ports. Checkpoints, signing and transcripts are not used. The parties'")
+	fmt.Println("progress messages are interleaved.")
+	fmt.Println()
+	fmt.Println("USAGE:")
+	fmt.Println("  cohort-bridge simulate -config-a a.yaml -config-b b.yaml [OPTIONS]")
+	fmt.Println()
+	fmt.Println("OPTIONS:")
+	fmt.Println("  -config-a string      Configuration file of party A (required)")
+	fmt.Println("  -config-b string      Configuration file of party B (required)")
+	fmt.Println("  -ground-truth string  Ground truth CSV of (party A ID, party B ID) pairs; reports")
+	fmt.Println("                        precision, recall and F1 of the intersection")
+	fmt.Println("  -output-dir string    Directory receiving the results (default: out)")
+	fmt.Println("  -transport string     Peer transport: grpc or tcp (default: peer.transport)")
+	fmt.Println("  -hamming-threshold    Maximum Hamming distance of a match (default: the")
+	fmt.Printf("                        configs' matching.hamming_threshold or %d)\n", config.DefaultHammingThreshold)
+	fmt.Println("  -jaccard-threshold    Minimum Jaccard similarity of a match (default: the")
+	fmt.Printf("                        configs' matching.jaccard_threshold or %g)\n", config.DefaultJaccardThreshold)
+	fmt.Println("  -allow-duplicates     Allow 1:many matching (default: 1:1 matching only)")
+	fmt.Println("  -help                 Show this help message")
+	fmt.Println()
+	fmt.Println("OUTPUT:")
+	fmt.Println("  simulate_intersection_a.json, simulate_intersection_b.json")
+	fmt.Println("                        Each party's intersection, as pprl would save it")
+	fmt.Println("  simulate_validation.csv")
+	fmt.Println("                        Validation report, with -ground-truth")
+	fmt.Println("  simulate_intersection_diff.json")
+	fmt.Println("                        The differing pairs, if the intersections disagree")
+	fmt.Println()
+	fmt.Println("EXAMPLES:")
+	fmt.Println("  cohort-bridge simulate -config-a config_a.yaml -config-b config_b.yaml")
+	fmt.Println("  cohort-bridge simulate -config-a config_a.yaml -config-b config_b.yaml -ground-truth data/expected_matches.csv")
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/objstore"
+	"github.com/auroradata-ai/cohort-bridge/internal/store"
+)
+
+// newObjectStore returns an object storage client configured by the storage section
+func newObjectStore(cfg *config.Config) (*objstore.Client, error) {
+	s := cfg.Storage
+	switch s.S3SSE {
+	case "", "AES256", "aws:kms":
+	default:
+		return nil, fmt.Errorf("unknown storage.s3_sse %q (expected AES256 or aws:kms)", s.S3SSE)
+	}
+	if s.S3KMSKeyID != "" && s.S3SSE != "aws:kms" {
+		return nil, fmt.Errorf("storage.s3_kms_key_id requires storage.s3_sse: aws:kms")
+	}
+	return objstore.NewClient(objstore.Options{
+		S3Region:             s.S3Region,
+		S3Endpoint:           s.S3Endpoint,
+		S3SSE:                s.S3SSE,
+		S3KMSKeyID:           s.S3KMSKeyID,
+		GCSKMSKeyName:        s.GCSKMSKeyName,
+		AzureEndpoint:        s.AzureEndpoint,
+		AzureEncryptionScope: s.AzureEncryptionScope,
+		PartSize:             int64(s.PartSizeMB) << 20,
+		Retry:                networkRetryPolicy(cfg),
+	}), nil
+}
+
+// stageInput downloads path into a workspace when it is an object URL, returning the local copy
+// and a cleanup that securely removes it; local paths are returned as they are. Keys never
+// live in object storage: for an encrypted object, a local key file named after it (in the
+// current directory or out/) is placed next to the copy, where the file key source looks.
+func stageInput(cfg *config.Config, path string) (string, func(), error) {
+	if !objstore.IsRemote(path) {
+		return path, func() {}, nil
+	}
+	loc, err := objstore.Parse(path)
+	if err != nil {
+		return "", nil, err
+	}
+	client, err := newObjectStore(cfg)
+	if err != nil {
+		return "", nil, err
+	}
+	ws, err := newWorkspace(cfg, "input")
+	if err != nil {
+		return "", nil, err
+	}
+	dir := ws.Dir
+	cleanup := func() {
+		if err := ws.Remove(); err != nil {
+			fmt.Printf("Warning: failed to remove downloaded input: %v\n", err)
+		}
+	}
+
+	local := filepath.Join(dir, loc.Name())
+	fmt.Printf("Downloading %s...\n", path)
+	if err := client.Download(path, local); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	if strings.HasSuffix(local, ".enc") {
+		keyName := strings.TrimSuffix(loc.Name(), ".enc") + ".key"
+		for _, candidate := range []string{keyName, filepath.Join("out", keyName)} {
+			if data, err := os.ReadFile(candidate); err == nil {
+				if err := os.WriteFile(filepath.Join(dir, keyName), data, 0600); err != nil {
+					cleanup()
+					return "", nil, err
+				}
+				break
+			}
+		}
+	}
+	return local, cleanup, nil
+}
+
+// stageOutput returns where to write path: for an object URL, a local file in out/ named after
+// the object, with an upload function that streams it to the object and removes the local file.
+// Other paths are written in place and upload does nothing.
+func stageOutput(cfg *config.Config, path string) (string, func() error, error) {
+	if !objstore.IsRemote(path) {
+		return path, func() error { return nil }, nil
+	}
+	loc, err := objstore.Parse(path)
+	if err != nil {
+		return "", nil, err
+	}
+	client, err := newObjectStore(cfg)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := os.MkdirAll("out", 0755); err != nil {
+		return "", nil, err
+	}
+
+	local := filepath.Join("out", loc.Name())
+	upload := func() error {
+		fmt.Printf("Uploading %s...\n", path)
+		if err := client.Upload(local, path); err != nil {
+			return err
+		}
+		return os.Remove(local)
+	}
+	return local, upload, nil
+}
+
+// addStagedInput records a staged input with the digest of its local copy under its original path
+func addStagedInput(run *store.Run, local, path string) {
+	run.AddInput(local)
+	if objstore.IsRemote(path) {
+		run.Inputs[len(run.Inputs)-1].Path = path
+	}
+}
+
+// addStagedOutput records an output path; object URLs are kept as they are
+func addStagedOutput(run *store.Run, path string) {
+	if objstore.IsRemote(path) {
+		run.Outputs = append(run.Outputs, path)
+		return
+	}
+	run.AddOutput(path)
+}
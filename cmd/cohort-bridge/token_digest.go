@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"sort"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/peerpb"
+)
+
+// TokenDigest travels with a token payload so the receiver can check it arrived whole: the
+// number of records sent and the SHA-256 of those records in ID order. A transfer cut short or
+// altered on the way fails the exchange instead of matching a partial dataset.
+type TokenDigest struct {
+	Records int    `json:"records"`
+	SHA256  string `json:"sha256"` // hex encoded
+}
+
+// newTokenDigest digests the records of tokens that are sent to the peer
+func newTokenDigest(tokens *TokenData) *TokenDigest {
+	return &TokenDigest{Records: len(tokens.Records), SHA256: digestTokenRecords(tokens)}
+}
+
+// digestTokenRecords returns the hex SHA-256 of the sent fields of every record, sorted by ID.
+// Each field is length-prefixed so that no two payloads digest alike.
+func digestTokenRecords(tokens *TokenData) string {
+	records := make([]TokenRecord, 0, len(tokens.Records))
+	for _, record := range tokens.Records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+
+	h := sha256.New()
+	for _, record := range records {
+		for _, field := range []string{record.ID, record.BloomFilter, record.MinHash, record.Blocking} {
+			writeDigestField(h, field)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func writeDigestField(h hash.Hash, field string) {
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(len(field)))
+	h.Write(length[:])
+	h.Write([]byte(field))
+}
+
+// verify checks the received tokens against the digest the peer sent with them. Peers older than
+// token digests send none; their payload is accepted with a warning.
+func (d *TokenDigest) verify(tokens *TokenData) error {
+	if d == nil {
+		fmt.Printf("   Warning: peer sent no token digest (older version); the token payload is not verified\n")
+		return nil
+	}
+	if len(tokens.Records) != d.Records {
+		return fmt.Errorf("token transfer is incomplete: received %d of the %d records the peer sent", len(tokens.Records), d.Records)
+	}
+	if received := digestTokenRecords(tokens); received != d.SHA256 {
+		return fmt.Errorf("token transfer is corrupted: SHA-256 of the received records is %.12s, the peer sent %.12s", received, d.SHA256)
+	}
+	return nil
+}
+
+func tokenDigestToProto(digest *TokenDigest) *peerpb.TokenDigest {
+	return &peerpb.TokenDigest{Records: uint64(digest.Records), Sha256: digest.SHA256}
+}
+
+func tokenDigestFromProto(digest *peerpb.TokenDigest) *TokenDigest {
+	if digest == nil {
+		return nil
+	}
+	return &TokenDigest{Records: int(digest.Records), SHA256: digest.Sha256}
+}
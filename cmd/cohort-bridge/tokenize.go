@@ -1,30 +1,104 @@
 package main
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
+	"compress/gzip"
+	"crypto/hmac"
 	"crypto/rand"
-	"encoding/base64"
-	"encoding/binary"
 	"encoding/csv"
-	"encoding/hex"
-
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/auroradata-ai/cohort-bridge/internal/config"
 	"github.com/auroradata-ai/cohort-bridge/internal/crypto"
 	"github.com/auroradata-ai/cohort-bridge/internal/db"
+	"github.com/auroradata-ai/cohort-bridge/internal/keys"
+	"github.com/auroradata-ai/cohort-bridge/internal/memlimit"
+	"github.com/auroradata-ai/cohort-bridge/internal/objstore"
 	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+	"github.com/auroradata-ai/cohort-bridge/internal/store"
 )
 
+// tokenizeFlags are the flags of the tokenize command
+type tokenizeFlags struct {
+	fs             *flag.FlagSet
+	mainConfigFile *string
+	inputFile      *string
+	outputFile     *string
+	inputFormat    *string
+	outputFormat   *string
+	batchSize      *int
+	maxMemory      *string
+	interactive    *bool
+	useDatabase    *bool
+	mllpAddress    *string
+	minHashSeed    *string
+	secretFile     *string
+	encryptionKey  *string
+	keySource      *string
+	noEncryption   *bool
+	strict         *bool
+	since          *string
+	watermarkCol   *string
+	csvDelimiter   *string
+	csvQuote       *string
+	csvEncoding    *string
+	csvNoHeader    *bool
+	maxFieldLength *int
+	onTooLong      *string
+	rejectsFile    *string
+	provenance     *bool
+	batchID        *string
+	force          *bool
+	help           *bool
+}
+
+// newTokenizeFlags defines the flags of the tokenize command
+func newTokenizeFlags() *tokenizeFlags {
+	fs := newFlagSet("tokenize")
+	return &tokenizeFlags{
+		fs:             fs,
+		mainConfigFile: fs.String("main-config", "config.yaml", "Main config file to read field names from"),
+		inputFile:      fs.String("input", "", "Input file with PHI data"),
+		outputFile:     fs.String("output", "", "Output file for tokenized data"),
+		inputFormat:    fs.String("input-format", "csv", "Input format: csv, json, postgres, hl7"),
+		outputFormat:   fs.String("output-format", "csv", "Output format: csv, jsonl (JSON Lines, gzipped if -output ends in .gz), cbbf (binary token store), postgres (output.postgres.tokens_table)"),
+		batchSize:      fs.Int("batch-size", 1000, "Number of records to process in each batch"),
+		maxMemory:      fs.String("max-memory", "", "Memory limit, e.g. 4G: batches shrink as it nears and the run stops cleanly instead of exceeding it"),
+		interactive:    fs.Bool("interactive", false, "Force interactive mode"),
+		useDatabase:    fs.Bool("database", false, "Use database from main config instead of file"),
+		mllpAddress:    fs.String("mllp", "", "Listen for HL7v2 ADT messages over MLLP on this address (e.g. :2575) and append tokens to -output"),
+		minHashSeed:    fs.String("minhash-seed", "", "Seed for deterministic MinHash generation (overrides tokenization.seed)"),
+		secretFile:     fs.String("linkage-secret-file", "", "File holding the shared linkage secret for keyed Bloom hashing (overrides tokenization.linkage_secret_file)"),
+		encryptionKey:  fs.String("encryption-key", "", "32-byte hex encryption key (auto-generated if empty)"),
+		keySource:      fs.String("key-source", "", "Encryption key source: file, env, keyring, keychain, kms, pkcs11 (overrides keys.source)"),
+		noEncryption:   fs.Bool("no-encryption", false, "Disable encryption (not recommended for production)"),
+		strict:         fs.Bool("strict", false, "Fail when the mean Bloom filter density exceeds tokenization.max_density"),
+		since:          fs.String("since", "", "Tokenize only records modified after this time, or after the watermark saved in this earlier token file's manifest"),
+		watermarkCol:   fs.String("watermark-column", "", "Column holding each record's last-modified time (overrides database.watermark_column)"),
+		csvDelimiter:   fs.String("csv-delimiter", "", "CSV input field separator, one character or tab (overrides database.csv.delimiter)"),
+		csvQuote:       fs.String("csv-quote", "", "CSV input quote character (overrides database.csv.quote)"),
+		csvEncoding:    fs.String("csv-encoding", "", "CSV input character encoding, e.g. latin1 or windows-1252 (overrides database.csv.encoding)"),
+		csvNoHeader:    fs.Bool("csv-no-header", false, "CSV input has no header row; columns are named by database.csv.columns or column_1, column_2, ..."),
+		maxFieldLength: fs.Int("max-field-length", 0, "Longest field value tokenized, in characters; -1 for no limit (overrides database.sanitize.max_length, default 256)"),
+		onTooLong:      fs.String("on-too-long", "", "Values over the length limit: truncate (default) or reject the record (overrides database.sanitize.on_too_long)"),
+		rejectsFile:    fs.String("rejects", "", "CSV report of truncated values, stripped control characters and rejected records (overrides database.sanitize.rejects_file)"),
+		provenance:     fs.Bool("provenance", false, "Add source_file, source_row and batch_id columns tracing each token to its source row (kept local, never sent to the peer)"),
+		batchID:        fs.String("batch-id", "", "Batch ID written with -provenance (default: the run ID)"),
+		force:          fs.Bool("force", false, "Skip confirmation prompts and run automatically"),
+		help:           fs.Bool("help", false, "Show help message"),
+	}
+}
+
 func runTokenizeCommand(args []string) {
 	fmt.Println("PPRL Tokenization Tool")
 	fmt.Println("======================")
@@ -32,42 +106,88 @@ func runTokenizeCommand(args []string) {
 	fmt.Println("Files are encrypted by default for maximum security")
 	fmt.Println()
 
-	fs := flag.NewFlagSet("tokenize", flag.ExitOnError)
-	var (
-		mainConfigFile = fs.String("main-config", "config.yaml", "Main config file to read field names from")
-		inputFile      = fs.String("input", "", "Input file with PHI data")
-		outputFile     = fs.String("output", "", "Output file for tokenized data")
-		inputFormat    = fs.String("input-format", "csv", "Input format: csv, json, postgres")
-		outputFormat   = fs.String("output-format", "csv", "Output format: csv, json")
-		batchSize      = fs.Int("batch-size", 1000, "Number of records to process in each batch")
-		interactive    = fs.Bool("interactive", false, "Force interactive mode")
-		useDatabase    = fs.Bool("database", false, "Use database from main config instead of file")
-		minHashSeed    = fs.String("minhash-seed", "0PsRm4KNmgRSY8ynApUtpXjeO19S7OUE", "Seed for deterministic MinHash generation")
-		encryptionKey  = fs.String("encryption-key", "", "32-byte hex encryption key (auto-generated if empty)")
-		noEncryption   = fs.Bool("no-encryption", false, "Disable encryption (not recommended for production)")
-		force          = fs.Bool("force", false, "Skip confirmation prompts and run automatically")
-		help           = fs.Bool("help", false, "Show help message")
-	)
-	fs.Parse(args)
-
-	if *help {
+	f := newTokenizeFlags()
+	parseFlags(f.fs, args)
+
+	if *f.help {
 		showTokenizeHelp()
 		return
 	}
 
 	// Ensure output directory exists
 	if err := os.MkdirAll("out", 0755); err != nil {
-		log.Fatalf("Failed to create output directory: %v", err)
+		fatalf(DataError, "Failed to create output directory: %v", err)
+	}
+
+	// Load the tokenization recipe so both parties produce comparable tokens
+	mainCfg := loadMainConfig(*f.mainConfigFile)
+	recipe := mainCfg.Tokenization
+	if *f.keySource == "" {
+		*f.keySource = mainCfg.Keys.Source
+	}
+	if *f.minHashSeed == "" {
+		*f.minHashSeed = recipe.Seed
+	}
+	dialect := &mainCfg.Database.CSV
+	if *f.csvDelimiter != "" {
+		dialect.Delimiter = *f.csvDelimiter
+	}
+	if *f.csvQuote != "" {
+		dialect.Quote = *f.csvQuote
+	}
+	if *f.csvEncoding != "" {
+		dialect.Encoding = *f.csvEncoding
+	}
+	if *f.csvNoHeader {
+		header := false
+		dialect.Header = &header
+	}
+	sanitize := &mainCfg.Database.Sanitize
+	if *f.maxFieldLength != 0 {
+		sanitize.MaxLength = *f.maxFieldLength
+	}
+	if *f.onTooLong != "" {
+		sanitize.OnTooLong = *f.onTooLong
 	}
+	if *f.rejectsFile != "" {
+		sanitize.RejectsFile = *f.rejectsFile
+	}
+	if *f.secretFile != "" {
+		recipe.LinkageSecretFile = *f.secretFile
+		recipe.LinkageSecretProvider = ""
+	}
+
+	if *f.mllpAddress != "" && *f.outputFile == "" {
+		fatalf(UsageError, "ERROR: -mllp requires -output")
+	}
+	if *f.batchID != "" && !*f.provenance {
+		fatalf(UsageError, "ERROR: -batch-id is written with -provenance")
+	}
+
+	if *f.outputFormat == "ndjson" {
+		*f.outputFormat = "jsonl" // Another name for the same format
+	}
+
+	// Postgres output goes to output.postgres.tokens_table of the main config, not to a file
+	toPostgres := *f.outputFormat == "postgres"
 
 	// If missing required parameters or interactive mode requested, go interactive
-	if (*inputFile == "" && !*useDatabase) || *outputFile == "" || *interactive {
+	if (*f.inputFile == "" && !*f.useDatabase && *f.mllpAddress == "") || (*f.outputFile == "" && !toPostgres) || *f.interactive {
+		var missing []string
+		if *f.inputFile == "" && !*f.useDatabase && *f.mllpAddress == "" {
+			missing = append(missing, "-input (or -database, -mllp)")
+		}
+		if *f.outputFile == "" && !toPostgres {
+			missing = append(missing, "-output")
+		}
+		requirePrompt("tokenize", missing...)
+
 		fmt.Println("Interactive Tokenization Setup")
 		fmt.Println("Configure your tokenization parameters...")
 
 		// Load config to get field information
 		var defaultFields []string
-		if cfg, err := config.Load(*mainConfigFile); err == nil {
+		if cfg, err := config.Load(*f.mainConfigFile); err == nil {
 			if len(cfg.Database.Fields) > 0 {
 				defaultFields = cfg.Database.Fields
 			}
@@ -77,32 +197,31 @@ func runTokenizeCommand(args []string) {
 		}
 
 		// Choose data source
-		if !*useDatabase {
+		if !*f.useDatabase {
 			sourceChoice := promptForChoice("Select data source:", []string{
 				"File - Process data from a file",
 				"Database - Use database connection from config",
 			})
-			*useDatabase = (sourceChoice == 1)
+			*f.useDatabase = (sourceChoice == 1)
 		}
 
 		// Get input file if using file mode
-		if !*useDatabase && *inputFile == "" {
+		if !*f.useDatabase && *f.inputFile == "" {
 			var err error
-			*inputFile, err = selectDataFile("Select Input Data File", "data", []string{".csv", ".json", ".txt"})
+			*f.inputFile, err = selectDataFile("Select Input Data File", "data", []string{".csv", ".json", ".txt"})
 			if err != nil {
-				fmt.Printf("Error selecting input file: %v\n", err)
-				os.Exit(1)
+				fatalf(UsageError, "Error selecting input file: %v", err)
 			}
 		}
 
 		// Get output file
-		if *outputFile == "" {
-			defaultOutput := generateOutputName("tokenized", *inputFile)
-			*outputFile = promptForInput("Output file for tokenized data", defaultOutput)
+		if *f.outputFile == "" && !toPostgres {
+			defaultOutput := generateOutputName("tokenized", *f.inputFile)
+			*f.outputFile = promptForInput("Output file for tokenized data", defaultOutput)
 		}
 
 		// Configure encryption settings
-		if !*noEncryption {
+		if !*f.noEncryption {
 			fmt.Println("\nEncryption Configuration:")
 			encryptChoice := promptForChoice("Encryption key source:", []string{
 				"Auto-generate new key (recommended)",
@@ -112,28 +231,28 @@ func runTokenizeCommand(args []string) {
 
 			switch encryptChoice {
 			case 0:
-				*encryptionKey = "" // Will be auto-generated
+				*f.encryptionKey = "" // Will be auto-generated
 			case 1:
 				customKey := promptForInput("Enter 32-byte hex encryption key", "")
 				if len(customKey) != 64 {
 					fmt.Println("Invalid key length, auto-generating instead...")
-					*encryptionKey = ""
+					*f.encryptionKey = ""
 				} else {
-					*encryptionKey = customKey
+					*f.encryptionKey = customKey
 				}
 			case 2:
-				*noEncryption = true
+				*f.noEncryption = true
 				fmt.Println("Encryption disabled - files will be stored in plaintext!")
 			}
 		}
 
 		// Output .enc file if it's encrypted
-		if !*noEncryption {
-			*outputFile = *outputFile + ".enc"
+		if !*f.noEncryption && !toPostgres {
+			*f.outputFile = *f.outputFile + ".enc"
 		}
 
 		// Select input format with Auto-detect as default
-		if !*useDatabase {
+		if !*f.useDatabase {
 			fmt.Println("\nSelect input format (default: Auto-detect):")
 			formatOptions := []string{
 				"Auto-detect from file extension",
@@ -144,58 +263,90 @@ func runTokenizeCommand(args []string) {
 			formatChoice := promptForChoice("", formatOptions)
 			switch formatChoice {
 			case 0:
-				*inputFormat = detectInputFormat(*inputFile)
+				*f.inputFormat = detectInputFormat(*f.inputFile)
 			case 1:
-				*inputFormat = "csv"
+				*f.inputFormat = "csv"
 			case 2:
-				*inputFormat = "json"
+				*f.inputFormat = "json"
 			}
 		} else {
-			*inputFormat = "database"
+			*f.inputFormat = "database"
 		}
 
 		// Select output format with input format as default
 		var defaultOutputFormat string
-		if *inputFormat == "csv" || *inputFormat == "database" {
+		if *f.inputFormat == "csv" || *f.inputFormat == "database" {
 			defaultOutputFormat = "csv"
 		} else {
-			defaultOutputFormat = "json"
+			defaultOutputFormat = "jsonl"
 		}
 
 		fmt.Printf("\nSelect output format (default: %s):\n", strings.ToUpper(defaultOutputFormat))
 		outFormatOptions := []string{
 			fmt.Sprintf("CSV - Comma-separated values %s", ifDefault(defaultOutputFormat == "csv")),
-			fmt.Sprintf("JSON - JavaScript Object Notation %s", ifDefault(defaultOutputFormat == "json")),
+			fmt.Sprintf("JSONL - JSON Lines, one record per line %s", ifDefault(defaultOutputFormat == "jsonl")),
 		}
 
-		outFormatChoice := promptForChoice("", outFormatOptions)
-		if outFormatChoice == 0 {
-			*outputFormat = "csv"
-		} else {
-			*outputFormat = "json"
+		if !toPostgres {
+			outFormatChoice := promptForChoice("", outFormatOptions)
+			if outFormatChoice == 0 {
+				*f.outputFormat = "csv"
+			} else {
+				*f.outputFormat = "jsonl"
+			}
 		}
 
 		// Configure batch size
-		batchSizeStr := promptForInput("Batch size (records to process at once)", strconv.Itoa(*batchSize))
+		batchSizeStr := promptForInput("Batch size (records to process at once)", strconv.Itoa(*f.batchSize))
 		if val, err := strconv.Atoi(batchSizeStr); err == nil && val > 0 && val <= 100000 {
-			*batchSize = val
+			*f.batchSize = val
 		} else {
-			fmt.Println("Invalid batch size, using default:", *batchSize)
+			fmt.Println("Invalid batch size, using default:", *f.batchSize)
 		}
 
 		// Configure MinHash seed
-		*minHashSeed = promptForInput("MinHash seed for deterministic hashing", *minHashSeed)
+		*f.minHashSeed = promptForInput("MinHash seed for deterministic hashing", *f.minHashSeed)
 
 		fmt.Println()
 	}
 
+	if *f.mllpAddress != "" {
+		*f.inputFormat = "hl7"
+	} else if *f.inputFormat == "csv" && detectInputFormat(*f.inputFile) != "csv" {
+		*f.inputFormat = detectInputFormat(*f.inputFile) // HL7 or JSON by extension
+	}
+
+	// Objects in cloud storage are downloaded first, so their headers can be read; every exit
+	// below removes the local copy
+	remoteInput := *f.inputFile
+	cleanupInput := func() {}
+	if !*f.useDatabase && *f.mllpAddress == "" && *f.inputFile != "" {
+		local, cleanup, err := stageInput(mainCfg, *f.inputFile)
+		if err != nil {
+			fatalf(DataError, "ERROR: %v", err)
+		}
+		*f.inputFile, cleanupInput = local, cleanup
+		defer cleanupInput()
+	}
+
 	// Try to load field names from main config file or CSV headers
 	var defaultFields []string
 	var normalizationConfig map[string]crypto.NormalizationMethod
 
-	// If using CSV file input, read headers from CSV first
-	if !*useDatabase && *inputFormat == "csv" && *inputFile != "" {
-		csvFields, err := readCSVHeaders(*inputFile)
+	columns, err := newColumnMapping(mainCfg)
+	if err != nil {
+		cleanupInput()
+		fatalf(ConfigError, "ERROR: %v", err)
+	}
+	ids, err := newIDColumns(mainCfg)
+	if err != nil {
+		cleanupInput()
+		fatalf(ConfigError, "ERROR: %v", err)
+	}
+
+	// If using CSV file input, read headers from CSV first; a column mapping names the fields instead
+	if columns == nil && !*f.useDatabase && *f.inputFormat == "csv" && *f.inputFile != "" {
+		csvFields, err := readCSVHeaders(*f.inputFile, *dialect)
 		if err == nil && len(csvFields) > 0 {
 			defaultFields = csvFields
 			fmt.Printf("Using field names from CSV headers: %v\n", defaultFields)
@@ -204,17 +355,34 @@ func runTokenizeCommand(args []string) {
 
 	// If no CSV headers found, try to load from config file
 	if len(defaultFields) == 0 {
-		if mainConfig, err := config.Load(*mainConfigFile); err == nil {
+		if mainConfig, err := config.Load(*f.mainConfigFile); err == nil {
 			if len(mainConfig.Database.Fields) > 0 {
 				// Parse fields to extract field names and normalization
 				defaultFields, normalizationConfig = parseFieldsWithNormalization(mainConfig.Database.Fields)
-				fmt.Printf("Using field names from %s: %v\n", *mainConfigFile, defaultFields)
+				fmt.Printf("Using field names from %s: %v\n", *f.mainConfigFile, defaultFields)
 				if len(normalizationConfig) > 0 {
 					fmt.Printf("Using normalization config: %v\n", normalizationConfig)
 				}
 			}
 		}
 	}
+	if len(defaultFields) == 0 && columns != nil {
+		defaultFields = columns.Fields()
+		fmt.Printf("Using field names from database.column_mapping: %v\n", defaultFields)
+	}
+	if (columns != nil || ids != nil) && !*f.useDatabase && *f.inputFormat == "csv" && *f.inputFile != "" {
+		// Report a schema mismatch before anything is written
+		if headers, err := readCSVColumns(*f.inputFile, *dialect); err == nil {
+			if err := columns.Check(headers, defaultFields); err != nil {
+				cleanupInput()
+				fatalf(ConfigError, "ERROR: database.column_mapping: %v", err)
+			}
+			if err := ids.Check(headers); err != nil {
+				cleanupInput()
+				fatalf(ConfigError, "ERROR: database.id_column: %v", err)
+			}
+		}
+	}
 
 	// Fallback to defaults if no fields found
 	if len(defaultFields) == 0 {
@@ -222,53 +390,134 @@ func runTokenizeCommand(args []string) {
 		fmt.Printf("Could not load field names from config or CSV, using defaults: %v\n", defaultFields)
 	}
 
-	// Generate encryption key if needed
-	var finalEncryptionKey string
-	var keyFile string
-	if !*noEncryption {
-		if *encryptionKey == "" {
-			// Auto-generate key
-			key := make([]byte, 32)
-			if _, err := rand.Read(key); err != nil {
-				fmt.Printf("ERROR: Failed to generate encryption key: %v\n", err)
-				os.Exit(1)
-			}
-			finalEncryptionKey = hex.EncodeToString(key)
-			keyFile = generateKeyFileName(*outputFile)
-		} else {
-			finalEncryptionKey = *encryptionKey
+	// MLLP mode runs until interrupted, appending each message's tokens as it arrives
+	if *f.mllpAddress != "" {
+		if !*f.noEncryption {
+			fatalf(UsageError, "ERROR: -mllp appends tokens as messages arrive and requires -no-encryption")
+		}
+		if objstore.IsRemote(*f.outputFile) {
+			fatalf(UsageError, "ERROR: -mllp appends tokens to a local -output, not an object in cloud storage")
 		}
+		if *f.provenance {
+			fatalf(UsageError, "ERROR: -provenance numbers the rows of a file or database table, not messages received over -mllp")
+		}
+		recipe.Seed = *f.minHashSeed
+		runMLLPTokenizeMode(*f.mllpAddress, *f.outputFile, recipe, mainCfg, defaultFields, normalizationConfig)
+		return
+	}
 
-		// Automatically add .enc extension if encryption is enabled and not already present
-		if !strings.HasSuffix(strings.ToLower(*outputFile), ".enc") {
-			*outputFile = *outputFile + ".enc"
-			// Update keyFile path if it was generated
-			if keyFile != "" {
-				keyFile = generateKeyFileName(*outputFile)
-			}
+	if *f.outputFormat == "cbbf" && !*f.noEncryption {
+		cleanupInput()
+		fatalf(UsageError, "ERROR: -output-format cbbf writes a memory-mapped token store and requires -no-encryption")
+	}
+	if toPostgres && !*f.noEncryption {
+		cleanupInput()
+		fatalf(UsageError, "ERROR: -output-format postgres writes tokens into a database table and requires -no-encryption")
+	}
+	if *f.provenance && *f.outputFormat != "csv" && *f.outputFormat != "jsonl" {
+		cleanupInput()
+		fatalf(UsageError, "ERROR: -provenance adds columns to CSV or JSON Lines token files, not -output-format %s", *f.outputFormat)
+	}
+	postgres := mainCfg.Output.Postgres
+	if toPostgres {
+		*f.outputFile = fmt.Sprintf("postgres table %s.%s", postgres.Schema, postgres.TokensTable)
+	}
+
+	// Automatically add .enc extension if encryption is enabled and not already present
+	if !*f.noEncryption && !strings.HasSuffix(strings.ToLower(*f.outputFile), ".enc") {
+		*f.outputFile = *f.outputFile + ".enc"
+	}
+
+	// Output bound for cloud storage is written to out/ and uploaded once complete
+	localOutput, uploadOutput := *f.outputFile, func() error { return nil }
+	if !toPostgres {
+		var err error
+		localOutput, uploadOutput, err = stageOutput(mainCfg, *f.outputFile)
+		if err != nil {
+			cleanupInput()
+			fatalf(DataError, "ERROR: %v", err)
+		}
+	}
+
+	// Select the encryption key from the configured key source
+	var encryption keys.EncryptOptions
+	var keyFile string
+	if !*f.noEncryption {
+		var err error
+		encryption, keyFile, err = resolveEncryptionKey(mainCfg, *f.keySource, *f.encryptionKey, localOutput)
+		if err != nil {
+			cleanupInput()
+			fatalf(CryptoError, "ERROR: Failed to obtain encryption key: %v", err)
 		}
 	}
 
 	// Show configuration summary
 	fmt.Println("Tokenization Configuration:")
-	if *useDatabase {
+	if *f.useDatabase {
 		fmt.Println("  Data Source: Database (from config)")
 	} else {
-		fmt.Printf("  Input File: %s\n", *inputFile)
-		fmt.Printf("  Input Format: %s\n", *inputFormat)
+		fmt.Printf("  Input File: %s\n", remoteInput)
+		fmt.Printf("  Input Format: %s\n", *f.inputFormat)
+	}
+	fmt.Printf("  Output File: %s\n", *f.outputFile)
+	if localOutput != *f.outputFile {
+		fmt.Printf("  Staged At: %s (uploaded once complete)\n", localOutput)
+	}
+	fmt.Printf("  Output Format: %s\n", *f.outputFormat)
+	fmt.Printf("  Batch Size: %d\n", *f.batchSize)
+	if *f.maxMemory != "" {
+		fmt.Printf("  Max Memory: %s\n", *f.maxMemory)
 	}
-	fmt.Printf("  Output File: %s\n", *outputFile)
-	fmt.Printf("  Output Format: %s\n", *outputFormat)
-	fmt.Printf("  Batch Size: %d\n", *batchSize)
 	fmt.Printf("  Fields: %v\n", defaultFields)
-	fmt.Printf("  MinHash Seed: %s\n", *minHashSeed)
+	if columns != nil {
+		fmt.Printf("  Column Mapping: %s\n", columns)
+	}
+	fmt.Printf("  MinHash Seed: %s\n", *f.minHashSeed)
+	if recipe.LinkageSecretProvider != "" {
+		fmt.Printf("  Bloom Hashing: HMAC-SHA256 keyed (secret derived by %s)\n", recipe.LinkageSecretProvider)
+	} else if recipe.LinkageSecretFile != "" {
+		fmt.Printf("  Bloom Hashing: HMAC-SHA256 keyed (secret: %s)\n", recipe.LinkageSecretFile)
+	} else {
+		fmt.Printf("  Bloom Hashing: unkeyed (set tokenization.linkage_secret_file to harden)\n")
+	}
+	if mode, err := pprl.ParseIDMode(recipe.IDMode); err == nil && mode != pprl.IDPreserve {
+		mapFile := recipe.IDMapFile
+		if mapFile == "" {
+			mapFile = defaultIDMapFile
+		}
+		fmt.Printf("  Record IDs: %s (mapping to original IDs kept in %s)\n", mode, mapFile)
+	}
+	fmt.Printf("  Recipe: bloom_size=%d bloom_hashes=%d qgram_length=%d padding=%q noise=%.2f minhash_size=%d\n",
+		recipe.BloomSize, recipe.BloomHashes, recipe.QGramLength, recipe.Padding, recipe.Noise, recipe.MinHashSize)
+	if recipe.Epsilon > 0 {
+		fmt.Printf("  Differential Privacy: BLIP epsilon=%g (each bit flipped with probability %.4f)\n",
+			recipe.Epsilon, pprl.BLIPFlipProbability(recipe.Epsilon))
+	}
+	if strings.EqualFold(recipe.Encoding, pprl.EncodingRBF) {
+		fmt.Printf("  Encoding: record-level Bloom filter (field weights: %v, others 1)\n", recipe.FieldWeights)
+	}
+	if steps := recipe.HardeningSteps(); len(steps) > 0 {
+		fmt.Printf("  Hardening: %s (distances scaled x%d)\n", strings.Join(steps, " -> "), pprl.HardeningDistanceScale(steps))
+	}
+	if recipe.Nicknames || recipe.NicknameFile != "" {
+		fmt.Printf("  Nicknames: built-in=%t custom=%q\n", recipe.Nicknames, recipe.NicknameFile)
+	}
+	if strings.EqualFold(strings.TrimSpace(recipe.Unicode), crypto.UnicodeFold) {
+		fmt.Printf("  Unicode: fold (locale=%q transliteration=%q)\n", recipe.Locale, recipe.TransliterationFile)
+	}
+	if len(recipe.Blocking) > 0 {
+		fmt.Printf("  Blocking: %s (hashed keys added to each record)\n", strings.Join(recipe.Blocking, ", "))
+	}
 
-	if !*noEncryption {
+	if !*f.noEncryption {
 		fmt.Printf("  Encryption: AES-256-GCM (enabled)\n")
-		if keyFile != "" {
-			fmt.Printf("  Key Storage: %s\n", keyFile)
-		} else {
-			fmt.Printf("  Key Source: Custom provided\n")
+		switch {
+		case encryption.Provider != nil:
+			fmt.Printf("  Key Source: envelope, data key wrapped by %s\n", encryption.Provider.Name())
+		case keyFile != "":
+			fmt.Printf("  Key Storage: %s (key %s)\n", keyFile, encryption.Key.ID)
+		default:
+			fmt.Printf("  Key Source: %s (key %s)\n", *f.keySource, encryption.Key.ID)
 		}
 	} else {
 		fmt.Printf("  Encryption: Disabled\n")
@@ -276,7 +525,7 @@ func runTokenizeCommand(args []string) {
 	fmt.Println()
 
 	// Confirm before proceeding (unless force flag is set)
-	if !*force {
+	if !skipConfirmation("tokenize", *f.force, "-force") {
 		confirmChoice := promptForChoice("Ready to start tokenization?", []string{
 			"Yes, start tokenization",
 			"Change configuration",
@@ -285,6 +534,7 @@ func runTokenizeCommand(args []string) {
 
 		if confirmChoice == 2 {
 			fmt.Println("\nTokenization cancelled. Goodbye!")
+			cleanupInput()
 			os.Exit(0)
 		}
 
@@ -300,29 +550,155 @@ func runTokenizeCommand(args []string) {
 	}
 
 	// Validate inputs before proceeding
-	if err := validateTokenizeInputs(*inputFile, *useDatabase, *mainConfigFile); err != nil {
-		fmt.Printf("ERROR: Validation error: %v\n", err)
-		os.Exit(1)
+	if err := validateTokenizeInputs(*f.inputFile, *f.useDatabase, *f.mainConfigFile); err != nil {
+		cleanupInput()
+		fatalf(ConfigError, "ERROR: Validation error: %v", err)
+	}
+
+	// In database mode the records are read from the database of the main config
+	if *f.useDatabase {
+		*f.inputFile = *f.mainConfigFile
 	}
 
 	// Run tokenization
 	fmt.Println("Starting tokenization process...")
 
-	if err := performTokenization(*inputFile, *outputFile, *inputFormat, *outputFormat, *batchSize, *minHashSeed, *useDatabase, defaultFields, finalEncryptionKey, keyFile, *noEncryption, normalizationConfig); err != nil {
-		fmt.Printf("ERROR: Tokenization failed: %v\n", err)
-		os.Exit(1)
+	recipe.Seed = *f.minHashSeed
+	recordConfig, err := newRecordConfig(recipe, mainCfg.Keys)
+	if err != nil {
+		cleanupInput()
+		fatalf(ConfigError, "ERROR: Invalid tokenization recipe: %v", err)
+	}
+	recordConfig.Columns = columns
+	recordConfig.IDColumns = ids
+	if recordConfig.MultiValue, err = newMultiValueColumns(mainCfg); err != nil {
+		cleanupInput()
+		fatalf(ConfigError, "ERROR: %v", err)
+	}
+	if recordConfig.Sanitizer, err = newSanitizer(mainCfg); err != nil {
+		cleanupInput()
+		fatalf(ConfigError, "ERROR: %v", err)
+	}
+	recordConfig.StrictDensity = *f.strict
+	if recordConfig.Watermark, err = newWatermark(mainCfg, *f.watermarkCol, *f.since); err != nil {
+		cleanupInput()
+		fatalf(ConfigError, "ERROR: %v", err)
+	}
+
+	recipeCfg := *mainCfg
+	recipeCfg.Tokenization = recipe
+	run := startRun("tokenize", &recipeCfg)
+	run.Parameters["fields"] = strings.Join(defaultFields, ",")
+	run.Parameters["recipe"] = recipeCfg.RecipeSummary()
+	run.Parameters["encrypted"] = strconv.FormatBool(!*f.noEncryption)
+	run.Parameters["id_mode"] = recordConfig.IDs.Mode()
+	if columns != nil {
+		run.Parameters["column_mapping"] = columns.String()
+	}
+	if recordConfig.MultiValue != nil {
+		run.Parameters["multi_value_columns"] = recordConfig.MultiValue.String()
+	}
+	run.Parameters["max_field_length"] = strconv.Itoa(recordConfig.Sanitizer.MaxLength)
+	run.Parameters["on_too_long"] = recordConfig.Sanitizer.OnTooLong
+	if watermark := recordConfig.Watermark; watermark != nil {
+		run.Parameters["watermark_column"] = watermark.Column
+		if !watermark.Since.IsZero() {
+			run.Parameters["since"] = watermark.Since.Format(time.RFC3339)
+		}
+	}
+	if !*f.useDatabase {
+		addStagedInput(run, *f.inputFile, remoteInput)
+	}
+	if *f.provenance {
+		if *f.batchID == "" {
+			*f.batchID = run.ID
+		}
+		recordConfig.Provenance = &pprl.Provenance{SourceFile: provenanceSource(mainCfg, remoteInput, *f.useDatabase), BatchID: *f.batchID}
+		run.Parameters["batch_id"] = *f.batchID
+	}
+
+	memory := startMemoryWatchdog(*f.maxMemory)
+	defer memory.Stop()
+	var tokenized int
+	if toPostgres {
+		tokenized, err = performPostgresTokenization(*f.inputFile, *f.inputFormat, *dialect, postgres, defaultFields, recordConfig, *f.useDatabase, normalizationConfig, run)
+	} else {
+		tokenized, err = performTokenization(*f.inputFile, localOutput, *f.inputFormat, *dialect, *f.outputFormat, *f.batchSize, recordConfig, *f.useDatabase, defaultFields, encryption, keyFile, *f.noEncryption, normalizationConfig, memory, run)
+	}
+	recordMemory(run, memory)
+	if err != nil {
+		recordRun(run, err)
+		printMemoryGuidance(err, "split the input and tokenize each part, or only records changed since a watermark with -since")
+		cleanupInput()
+		fatalf(DataError, "ERROR: Tokenization failed: %v", err)
+	}
+	if err := uploadOutput(); err != nil {
+		recordRun(run, err)
+		cleanupInput()
+		fatalf(DataError, "ERROR: Upload failed: %v (tokens kept in %s)", err, localOutput)
 	}
+	run.Counts["records"] = tokenized
+	if toPostgres {
+		run.Outputs = append(run.Outputs, *f.outputFile)
+	} else {
+		addStagedOutput(run, *f.outputFile)
+		if !objstore.IsRemote(*f.outputFile) {
+			writeTokenFormat(*f.outputFile, *f.outputFormat, !*f.noEncryption, &recipeCfg, recordConfig, tokenized)
+		}
+	}
+	recordRun(run, nil)
 
 	fmt.Printf("\nTokenization completed successfully!\n")
-	if !*noEncryption {
-		fmt.Printf("Encrypted data saved to: %s\n", *outputFile)
+	if !*f.noEncryption {
+		fmt.Printf("Encrypted data saved to: %s\n", *f.outputFile)
 		if keyFile != "" {
 			fmt.Printf("Encryption key saved to: %s\n", keyFile)
 			fmt.Printf("IMPORTANT: Save your encryption key securely! Without it, your data cannot be decrypted.\n")
+		} else if encryption.Key != nil {
+			fmt.Printf("Encrypted with key %s from %s\n", encryption.Key.ID, *f.keySource)
 		}
 	} else {
-		fmt.Printf("Tokenized data saved to: %s\n", *outputFile)
+		fmt.Printf("Tokenized data saved to: %s\n", *f.outputFile)
+	}
+}
+
+// writeTokenFormat writes the format manifest beside a token file, so intersect and pprl can check
+// they read it correctly; failing to write it only warns
+func writeTokenFormat(tokenFile, encoding string, encrypted bool, cfg *config.Config, recordConfig *pprl.RecordConfig, records int) {
+	build := buildInfo()
+	format := db.NewTokenFormat(encoding, encrypted, build.Version, build.GitCommit,
+		cfg.RecipeSummary(), cfg.RecipeFingerprint(recordConfig.LinkageSecret), records)
+	if watermark := recordConfig.Watermark; watermark != nil {
+		if latest := watermark.Latest(); !latest.IsZero() {
+			format.Watermark = latest.Format(time.RFC3339Nano)
+		}
+		if !watermark.Since.IsZero() {
+			format.Since = watermark.Since.Format(time.RFC3339Nano)
+		}
 	}
+	if err := db.WriteTokenFormat(tokenFile, format); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+}
+
+// checkTokenFormat verifies that this release can read a token file and, given a recipe
+// fingerprint, that the file was tokenized with that recipe. Files written before format manifests
+// are read as before, with a note.
+func checkTokenFormat(tokenFile, fingerprint, recipe string) (*db.TokenFormat, error) {
+	format, err := db.CheckTokenFormat(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	if format == nil {
+		fmt.Printf("   Note: %s has no format manifest (%s); its format version is not checked\n", tokenFile, db.FormatManifestSuffix)
+		return nil, nil
+	}
+	if fingerprint != "" {
+		if err := format.CheckRecipe(tokenFile, fingerprint, recipe); err != nil {
+			return nil, err
+		}
+	}
+	return format, nil
 }
 
 // generateTokenizeOutputName function replaced with shared generateOutputName in utils.go
@@ -333,23 +709,19 @@ func generateKeyFileName(outputFile string) string {
 }
 
 func detectInputFormat(inputFile string) string {
-	ext := strings.ToLower(filepath.Ext(inputFile))
-	if ext == ".json" {
+	ext := strings.ToLower(filepath.Ext(strings.TrimSuffix(strings.ToLower(inputFile), ".gz"))) // data.csv.gz is CSV
+	if ext == ".json" || ext == ".jsonl" || ext == ".ndjson" {
 		return "json"
 	}
+	if ext == ".hl7" {
+		return "hl7"
+	}
 	return "csv" // Default fallback
 }
 
 // readCSVHeaders reads the first line of a CSV file and returns the column headers
-func readCSVHeaders(csvFile string) ([]string, error) {
-	file, err := os.Open(csvFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open CSV file: %w", err)
-	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	headers, err := reader.Read()
+func readCSVHeaders(csvFile string, dialect config.CSVDialect) ([]string, error) {
+	headers, err := readCSVColumns(csvFile, dialect)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read CSV headers: %w", err)
 	}
@@ -366,6 +738,16 @@ func readCSVHeaders(csvFile string) ([]string, error) {
 	return cleanHeaders, nil
 }
 
+// readCSVColumns returns the header of a CSV file as written, which is how records are keyed
+func readCSVColumns(csvFile string, dialect config.CSVDialect) ([]string, error) {
+	reader, err := db.OpenCSV(csvFile, dialect)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CSV file: %w", err)
+	}
+	defer reader.Close()
+	return reader.Header(), nil
+}
+
 func validateTokenizeInputs(inputFile string, useDatabase bool, configFile string) error {
 	if !useDatabase {
 		if inputFile == "" {
@@ -382,273 +764,1255 @@ func validateTokenizeInputs(inputFile string, useDatabase bool, configFile strin
 	return nil
 }
 
-// performTokenization is now used by both tokenize and pprl commands
-func performTokenization(inputFile, outputFile, inputFormat, outputFormat string, batchSize int, minHashSeed string, useDatabase bool, fields []string, encryptionKey, keyFile string, noEncryption bool, normalizationConfig map[string]crypto.NormalizationMethod) error {
-	if useDatabase {
-		return fmt.Errorf("database mode not yet implemented - please use file mode")
+// loadMainConfig loads the main config, or returns a config with defaults if the file does not
+// exist. A config that exists but does not load is an error: falling back to the default recipe
+// would tokenize without its linkage secret.
+func loadMainConfig(configFile string) *config.Config {
+	cfg, err := config.Load(configFile)
+	if err == nil {
+		return cfg
 	}
+	if !errors.Is(err, os.ErrNotExist) {
+		fatalf(ConfigError, "ERROR: Failed to load config %s: %v", configFile, err)
+	}
+	cfg = &config.Config{}
+	cfg.SetDefaults()
+	return cfg
+}
 
-	// Load records from input file
-	fmt.Println("Loading records from input file...")
+// linkageSecretLabel is the message a key provider's HMAC key signs to derive the linkage secret
+const linkageSecretLabel = "cohort-bridge linkage secret v1"
 
-	var allRecords []map[string]string
+// newRecordConfig converts a tokenization recipe into a PPRL record configuration,
+// loading the linkage secret if one is configured, or deriving it with the key provider
+// named by tokenization.linkage_secret_provider
+func newRecordConfig(recipe config.TokenizationConfig, keyConfig config.KeysConfig) (*pprl.RecordConfig, error) {
+	if recipe.DateWeight < 0 {
+		return nil, fmt.Errorf("tokenization.date_weight must not be negative, got %g", recipe.DateWeight)
+	}
 
-	if inputFormat == "csv" {
-		// Use CSV database to load records
-		csvDB, err := db.NewCSVDatabase(inputFile)
+	var linkageSecret []byte
+	switch {
+	case recipe.LinkageSecretProvider != "" && recipe.LinkageSecretFile != "":
+		return nil, fmt.Errorf("set either tokenization.linkage_secret_file or tokenization.linkage_secret_provider, not both")
+	case recipe.LinkageSecretProvider != "":
+		provider, err := newKeyProvider(keyConfig, recipe.LinkageSecretProvider)
 		if err != nil {
-			return fmt.Errorf("failed to open CSV file: %w", err)
+			return nil, fmt.Errorf("tokenization.linkage_secret_provider: %w", err)
 		}
-
-		// Get all records from CSV
-		allRecords, err = csvDB.List(0, 100000) // Load all records (up to 100k)
+		secret, err := provider.DeriveSecret(linkageSecretLabel)
 		if err != nil {
-			return fmt.Errorf("failed to read records: %w", err)
+			return nil, fmt.Errorf("failed to derive the linkage secret: %w", err)
 		}
-	} else {
-		return fmt.Errorf("input format %s not yet implemented - please use CSV", inputFormat)
+		if len(secret) < pprl.MinLinkageSecretLen {
+			return nil, fmt.Errorf("%s derived a %d-byte linkage secret; at least %d bytes are needed", provider.Name(), len(secret), pprl.MinLinkageSecretLen)
+		}
+		linkageSecret = secret
+	case recipe.LinkageSecretFile != "":
+		secret, err := pprl.LoadLinkageSecret(recipe.LinkageSecretFile)
+		if err != nil {
+			return nil, err
+		}
+		linkageSecret = secret
 	}
 
-	fmt.Printf("   Loaded %d records\n", len(allRecords))
-
-	// Create output file
-	fmt.Println("Creating output file...")
-
-	if outputFormat == "csv" {
-		return performCSVTokenization(allRecords, outputFile, fields, batchSize, minHashSeed, encryptionKey, keyFile, noEncryption, normalizationConfig)
-	} else {
-		return fmt.Errorf("output format %s not yet implemented - please use CSV", outputFormat)
+	var nicknames crypto.NicknameDictionary
+	if recipe.Nicknames {
+		nicknames = crypto.DefaultNicknames()
 	}
-}
-
-// performCSVTokenization is now used by both tokenize and pprl commands
-func performCSVTokenization(allRecords []map[string]string, outputFile string, fields []string, batchSize int, minHashSeed string, encryptionKey, keyFile string, noEncryption bool, normalizationConfig map[string]crypto.NormalizationMethod) error {
-	// Determine if we need to encrypt
-	var tempFile string
-	var finalOutputFile string
-
-	if !noEncryption {
-		// Create temporary unencrypted file first
-		tempFile = outputFile + ".tmp"
-		finalOutputFile = outputFile
-		outputFile = tempFile // Write to temp file first
+	if recipe.NicknameFile != "" {
+		custom, err := crypto.LoadNicknames(recipe.NicknameFile)
+		if err != nil {
+			return nil, err
+		}
+		if nicknames == nil {
+			nicknames = custom
+		} else {
+			nicknames.Merge(custom)
+		}
 	}
 
-	// Create CSV output file with proper headers
-	outputCSV, err := os.Create(outputFile)
+	folding, err := newUnicodeFolding(recipe)
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return nil, err
 	}
-	defer outputCSV.Close()
-
-	writer := csv.NewWriter(outputCSV)
-	defer writer.Flush()
-
-	// Write CSV header
-	header := []string{"id", "bloom_filter", "minhash", "timestamp"}
-	if err := writer.Write(header); err != nil {
-		return fmt.Errorf("failed to write CSV header: %w", err)
+	var textFold func(string) string
+	if folding != nil {
+		textFold = folding.Fold
 	}
 
-	// PPRL configuration for tokenization
-	recordConfig := &pprl.RecordConfig{
-		BloomSize:    1000, // 1000 bits
-		BloomHashes:  5,    // 5 hash functions
-		MinHashSize:  100,  // 100-element signature
-		QGramLength:  2,    // 2-grams
-		QGramPadding: "$",  // Padding character
-		NoiseLevel:   0,    // No noise for deterministic matching
+	if recipe.Epsilon < 0 {
+		return nil, fmt.Errorf("tokenization.epsilon must not be negative")
 	}
-
-	// Create deterministic MinHash once and reuse for all records
-	// Use the minHashSeed parameter if provided, otherwise use default seed
-	seed := minHashSeed
-	if seed == "" {
-		seed = "0PsRm4KNmgRSY8ynApUtpXjeO19S7OUE"
+	if recipe.Epsilon > 0 && recipe.Noise > 0 {
+		return nil, fmt.Errorf("tokenization.epsilon and tokenization.noise are exclusive; BLIP replaces the fixed noise level")
 	}
 
-	fmt.Println("Processing records in batches...")
-	fmt.Printf("   Batch size: %d\n", batchSize)
-	fmt.Println("   Generating Bloom filters...")
-	fmt.Println("   Computing MinHash signatures...")
-
-	processedCount := 0
-	totalRecords := len(allRecords)
-
-	for i := 0; i < totalRecords; i += batchSize {
-		end := i + batchSize
-		if end > totalRecords {
-			end = totalRecords
+	encoding := strings.ToLower(strings.TrimSpace(recipe.Encoding))
+	switch encoding {
+	case "":
+		encoding = pprl.EncodingCLK
+	case pprl.EncodingCLK, pprl.EncodingRBF:
+	default:
+		return nil, fmt.Errorf("unknown tokenization.encoding %q (use clk or rbf)", recipe.Encoding)
+	}
+	fieldWeights := make(map[string]float64, len(recipe.FieldWeights))
+	for field, weight := range recipe.FieldWeights {
+		if encoding != pprl.EncodingRBF {
+			return nil, fmt.Errorf("tokenization.field_weights requires tokenization.encoding: rbf")
 		}
+		if weight <= 0 {
+			return nil, fmt.Errorf("tokenization.field_weights: weight of %s must be positive", field)
+		}
+		fieldWeights[strings.ToLower(field)] = weight
+	}
 
-		batch := allRecords[i:end]
-		fmt.Printf("   Processing batch %d/%d (%d records)\n",
-			(i/batchSize)+1,
-			(totalRecords+batchSize-1)/batchSize,
-			len(batch))
-
-		for _, record := range batch {
-			// Extract field values for this record
-			var fieldValues []string
-			for _, field := range fields {
-				if value, exists := record[field]; exists && value != "" {
-					// Apply normalization if configured
-					var normalizedValue string
-					if normalizationConfig != nil {
-						if method, hasNorm := normalizationConfig[field]; hasNorm {
-							normalizedValue = crypto.NormalizeField(value, method)
-						} else {
-							// No specific normalization configured, apply basic normalization
-							normalizedValue = crypto.NormalizeField(value, "")
-						}
-					} else {
-						// Basic normalization fallback
-						normalizedValue = crypto.NormalizeField(value, "")
-					}
-
-					if normalizedValue != "" {
-						fieldValues = append(fieldValues, normalizedValue)
-					}
-				}
-			}
-
-			if len(fieldValues) == 0 {
-				continue // Skip records with no data in specified fields
-			}
+	if recipe.MaxDensity < 0 || recipe.MaxDensity > 1 {
+		return nil, fmt.Errorf("tokenization.max_density must be between 0 and 1, got %g", recipe.MaxDensity)
+	}
 
-			// Get record ID
-			recordID := record["id"]
-			if recordID == "" {
-				// Generate ID if not present
-				recordID = fmt.Sprintf("record_%d", processedCount+1)
-			}
+	missingData := &pprl.MissingDataPolicy{Default: recipe.MissingData.Default, Fields: recipe.MissingData.Fields}
+	if err := missingData.Validate(); err != nil {
+		return nil, fmt.Errorf("tokenization.missing_data: %w", err)
+	}
 
-			// Create PPRL record with real tokenization
-			pprlRecord, err := pprl.CreateRecord(recordID, fieldValues, recordConfig)
-			if err != nil {
-				return fmt.Errorf("failed to create PPRL record for %s: %w", recordID, err)
-			}
+	hardening, err := pprl.NewHardening(recipe.HardeningSteps(), recipe.BloomSize, recipe.Seed, linkageSecret)
+	if err != nil {
+		return nil, fmt.Errorf("tokenization.hardening: %w", err)
+	}
 
-			// Convert to CSV format with actual record ID
-			timestamp := time.Now().Format("2006-01-02T15:04:05Z")
+	ids, err := newIDMapper(recipe, linkageSecret)
+	if err != nil {
+		return nil, err
+	}
 
-			// Convert []uint32 MinHash to []byte
-			minhashBytes := make([]byte, len(pprlRecord.MinHash)*4)
-			for i, val := range pprlRecord.MinHash {
-				binary.BigEndian.PutUint32(minhashBytes[i*4:], val)
-			}
-			minHashEncoded := base64.StdEncoding.EncodeToString(minhashBytes)
-
-			// Write the tokenized record to CSV with the actual record ID
-			row := []string{
-				recordID, // Include the actual record ID
-				pprlRecord.BloomData,
-				minHashEncoded,
-				timestamp,
-			}
+	return &pprl.RecordConfig{
+		BloomSize:     recipe.BloomSize,
+		BloomHashes:   recipe.BloomHashes,
+		MinHashSize:   recipe.MinHashSize,
+		QGramLength:   recipe.QGramLength,
+		QGramPadding:  recipe.Padding,
+		NoiseLevel:    recipe.Noise,
+		Epsilon:       recipe.Epsilon,
+		Salt:          recipe.Seed,
+		LinkageSecret: linkageSecret,
+		DateWeight:    recipe.DateWeight,
+		Nicknames:     nicknames,
+		TextFold:      textFold,
+		MissingData:   missingData,
+		Encoding:      encoding,
+		FieldWeights:  fieldWeights,
+		Hardening:     hardening,
+		Blocking:      recipe.Blocking,
+		ExactID:       strings.TrimSpace(recipe.ExactID),
+		MaxDensity:    recipe.MaxDensity,
+		IDs:           ids,
+	}, nil
+}
 
-			if err := writer.Write(row); err != nil {
-				return fmt.Errorf("failed to write record to CSV: %w", err)
-			}
+// hardeningScale returns the factor the recipe's Bloom filter hardening multiplies Hamming
+// distances by, which matchers divide them by (1 without hardening)
+func hardeningScale(recipe config.TokenizationConfig) uint32 {
+	return pprl.HardeningDistanceScale(recipe.HardeningSteps())
+}
 
-			processedCount++
+// newUnicodeFolding creates the Unicode folding of a recipe (nil in the default ascii mode)
+func newUnicodeFolding(recipe config.TokenizationConfig) (*crypto.UnicodeFolding, error) {
+	switch strings.ToLower(strings.TrimSpace(recipe.Unicode)) {
+	case "", crypto.UnicodeASCII:
+		if recipe.Locale != "" || recipe.TransliterationFile != "" {
+			return nil, fmt.Errorf("tokenization.locale and tokenization.transliteration_file require tokenization.unicode: fold")
 		}
+		return nil, nil
+	case crypto.UnicodeFold:
+	default:
+		return nil, fmt.Errorf("unknown tokenization.unicode %q (use ascii or fold)", recipe.Unicode)
 	}
 
-	// Close the file to ensure all data is written
-	writer.Flush()
-	outputCSV.Close()
-
-	fmt.Printf("Successfully tokenized %d records\n", processedCount)
+	var custom map[string]string
+	if recipe.TransliterationFile != "" {
+		rules, err := crypto.LoadTransliterations(recipe.TransliterationFile)
+		if err != nil {
+			return nil, err
+		}
+		custom = rules
+	}
+	folding, err := crypto.NewUnicodeFolding(strings.TrimSpace(recipe.Locale), custom)
+	if err != nil {
+		return nil, fmt.Errorf("tokenization.locale: %w", err)
+	}
+	return folding, nil
+}
 
-	// Handle encryption if enabled
-	if !noEncryption {
-		fmt.Println("Encrypting output file...")
+// defaultIDMapFile is where pseudonyms are mapped back to record IDs unless tokenization.id_map_file is set
+const defaultIDMapFile = "out/id_map.csv"
 
-		// Save encryption key to file if keyFile is specified
-		if keyFile != "" {
-			if err := saveKeyToFile(encryptionKey, keyFile); err != nil {
-				// Cleanup temp file before returning error
-				os.Remove(tempFile)
-				return fmt.Errorf("failed to save encryption key: %w", err)
-			}
-			fmt.Printf("   Encryption key saved to: %s\n", keyFile)
+// newIDMapper creates the record ID mapper of a recipe (nil when IDs are preserved). The hmac key
+// must differ from the linkage secret, which the peer holds and could use to test guessed IDs.
+func newIDMapper(recipe config.TokenizationConfig, linkageSecret []byte) (*pprl.IDMapper, error) {
+	mode, err := pprl.ParseIDMode(recipe.IDMode)
+	if err != nil {
+		return nil, fmt.Errorf("tokenization.id_mode: %w", err)
+	}
+	var key []byte
+	if mode == pprl.IDHMAC {
+		if recipe.IDSecretFile == "" {
+			return nil, fmt.Errorf("tokenization.id_mode hmac requires tokenization.id_secret_file")
 		}
-
-		// Encrypt the file
-		if err := encryptFile(tempFile, finalOutputFile, encryptionKey); err != nil {
-			// Cleanup temp file before returning error
-			os.Remove(tempFile)
-			return fmt.Errorf("failed to encrypt output file: %w", err)
+		key, err = pprl.LoadLinkageSecret(recipe.IDSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("tokenization.id_secret_file: %w", err)
 		}
-
-		// Secure cleanup of temporary file
-		if err := secureDeleteFile(tempFile); err != nil {
-			fmt.Printf("Warning: failed to securely delete temporary file: %v\n", err)
+		if len(linkageSecret) > 0 && hmac.Equal(key, linkageSecret) {
+			return nil, fmt.Errorf("tokenization.id_secret_file must not hold the linkage secret, which the peer shares")
 		}
-
-		fmt.Printf("   File encrypted successfully with AES-256-GCM\n")
 	}
-
-	return nil
+	mapFile := recipe.IDMapFile
+	if mapFile == "" {
+		mapFile = defaultIDMapFile
+	}
+	return pprl.NewIDMapper(mode, mapFile, key)
 }
 
-// encryptFile encrypts a file using AES-256-GCM
-func encryptFile(inputFile, outputFile, keyHex string) error {
-	// Decode hex key
-	key, err := hex.DecodeString(keyHex)
+// newColumnMapping creates the column mapping of database.column_mapping (nil when unset)
+func newColumnMapping(cfg *config.Config) (*pprl.ColumnMapping, error) {
+	columns := make(map[string]string, len(cfg.Database.ColumnMapping))
+	transforms := make(map[string][]string)
+	for field, mapping := range cfg.Database.ColumnMapping {
+		columns[field] = mapping.Column
+		if len(mapping.Transforms) > 0 {
+			transforms[field] = mapping.Transforms
+		}
+	}
+	mapping, err := pprl.NewColumnMapping(columns, transforms)
 	if err != nil {
-		return fmt.Errorf("invalid encryption key format: %w", err)
+		return nil, fmt.Errorf("database.column_mapping: %w", err)
 	}
+	return mapping, nil
+}
 
-	if len(key) != 32 {
-		return fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+// newIDColumns creates the record ID of database.id_column (nil for the plain id column)
+func newIDColumns(cfg *config.Config) (*pprl.IDColumns, error) {
+	if _, mapped := cfg.Database.ColumnMapping["id"]; mapped && len(cfg.Database.IDColumn) > 0 {
+		return nil, fmt.Errorf("set either database.id_column or database.column_mapping.id, not both")
 	}
-
-	// Read plaintext file
-	plaintext, err := os.ReadFile(inputFile)
+	if cfg.Database.IDSeparator != "" && len(cfg.Database.IDColumn) < 2 {
+		return nil, fmt.Errorf("database.id_separator needs several columns in database.id_column")
+	}
+	ids, err := pprl.NewIDColumns(cfg.Database.IDColumn, cfg.Database.IDSeparator, cfg.Database.IDHash)
 	if err != nil {
-		return fmt.Errorf("failed to read input file: %w", err)
+		return nil, fmt.Errorf("database.id_column: %w", err)
 	}
+	return ids, nil
+}
 
-	// Create AES cipher
-	block, err := aes.NewCipher(key)
+// newMultiValueColumns creates the cell splitting of database.multi_value_columns (nil when unset)
+func newMultiValueColumns(cfg *config.Config) (*pprl.MultiValueColumns, error) {
+	multiValue, err := pprl.NewMultiValueColumns(cfg.Database.MultiValueColumns, cfg.Database.MultiValueDelimiter)
 	if err != nil {
-		return fmt.Errorf("failed to create AES cipher: %w", err)
+		return nil, fmt.Errorf("database.multi_value_columns: %w", err)
 	}
+	return multiValue, nil
+}
 
-	// Create GCM mode
-	gcm, err := cipher.NewGCM(block)
+// newSanitizer creates the sanitization of field values from database.sanitize; control
+// characters are stripped unless strip_control is false. The rejects file is made absolute, as
+// tokenization may run in a workspace directory.
+func newSanitizer(cfg *config.Config) (*pprl.Sanitizer, error) {
+	sanitize := cfg.Database.Sanitize
+	stripControl := sanitize.StripControl == nil || *sanitize.StripControl
+	sanitizer, err := pprl.NewSanitizer(sanitize.MaxLength, stripControl, sanitize.OnTooLong, sanitize.RejectsFile)
 	if err != nil {
-		return fmt.Errorf("failed to create GCM: %w", err)
+		return nil, fmt.Errorf("database.sanitize: %w", err)
 	}
-
-	// Generate random nonce
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return fmt.Errorf("failed to generate nonce: %w", err)
+	if sanitizer.RejectsFile != "" {
+		if sanitizer.RejectsFile, err = filepath.Abs(sanitizer.RejectsFile); err != nil {
+			return nil, fmt.Errorf("database.sanitize.rejects_file: %w", err)
+		}
 	}
+	return sanitizer, nil
+}
+
+// newWatermark creates the record selection of an incremental run (nil without a watermark
+// column). since is a timestamp, or a token file whose manifest holds the watermark its run
+// reached; without it, every record is tokenized and the latest watermark saved.
+func newWatermark(cfg *config.Config, column, since string) (*pprl.Watermark, error) {
+	if column == "" {
+		column = cfg.Database.WatermarkColumn
+	}
+	if column == "" {
+		if since != "" {
+			return nil, fmt.Errorf("-since needs a watermark column (-watermark-column or database.watermark_column)")
+		}
+		return nil, nil
+	}
+
+	watermark := &pprl.Watermark{Column: column}
+	if since == "" {
+		return watermark, nil
+	}
+	if _, err := os.Stat(since); err == nil {
+		format, err := db.ReadTokenFormat(since)
+		if err != nil {
+			return nil, err
+		}
+		if format == nil || format.Watermark == "" {
+			return nil, fmt.Errorf("-since %s: the token file's manifest records no watermark (tokenize it with a watermark column)", since)
+		}
+		since = format.Watermark
+	}
+	t, err := pprl.ParseWatermark(since)
+	if err != nil {
+		return nil, fmt.Errorf("-since: %w", err)
+	}
+	watermark.Since = t
+	return watermark, nil
+}
+
+// selectWatermarkRecords keeps the records modified since the watermark, if one is set
+func selectWatermarkRecords(records []map[string]string, watermark *pprl.Watermark, run *store.Run) []map[string]string {
+	if watermark == nil {
+		return records
+	}
+	selected := watermark.Filter(records)
+	if !watermark.Since.IsZero() {
+		fmt.Printf("   Selected %d of %d records modified after %s (%s)\n", len(selected), len(records),
+			watermark.Since.Format(time.RFC3339), watermark.Column)
+		run.Counts["unchanged_records"] = len(records) - len(selected)
+	}
+	if n := watermark.Undated(); n > 0 {
+		fmt.Printf("   Warning: %d records have no readable %s and are tokenized every run\n", n, watermark.Column)
+		run.Counts["undated_records"] = n
+	}
+	if latest := watermark.Latest(); !latest.IsZero() {
+		fmt.Printf("   Watermark: %s (saved in the format manifest for the next run)\n", latest.Format(time.RFC3339))
+	}
+	return selected
+}
+
+// performTokenization is now used by both tokenize and pprl commands; it returns the number of records tokenized
+func performTokenization(inputFile, outputFile, inputFormat string, dialect config.CSVDialect, outputFormat string, batchSize int, recordConfig *pprl.RecordConfig, useDatabase bool, fields []string, encryption keys.EncryptOptions, keyFile string, noEncryption bool, normalizationConfig map[string]crypto.NormalizationMethod, memory *memlimit.Watchdog, run *store.Run) (int, error) {
+	allRecords, err := loadTokenizeRecords(inputFile, inputFormat, dialect, useDatabase)
+	if err != nil {
+		return 0, err
+	}
+	recordConfig.Provenance.Number(allRecords)
+	allRecords = selectWatermarkRecords(allRecords, recordConfig.Watermark, run)
+	if err := memory.Check(fmt.Sprintf("loading %d records", len(allRecords))); err != nil {
+		return 0, err
+	}
+
+	// Create output file
+	fmt.Println("Creating output file...")
+
+	if outputFormat == "csv" || outputFormat == "jsonl" {
+		return performCSVTokenization(allRecords, outputFile, outputFormat, fields, batchSize, recordConfig, encryption, keyFile, noEncryption, normalizationConfig, memory, run)
+	} else if outputFormat == "cbbf" {
+		return performStoreTokenization(allRecords, outputFile, fields, recordConfig, normalizationConfig, memory, run)
+	} else {
+		return 0, fmt.Errorf("output format %s not yet implemented - please use CSV or JSONL", outputFormat)
+	}
+}
+
+// provenanceSource names the source written as each token's source_file: the input as given, or
+// with useDatabase, the table or query of the database section (its file for file types)
+func provenanceSource(cfg *config.Config, inputFile string, useDatabase bool) string {
+	if !useDatabase {
+		return inputFile
+	}
+	database := cfg.Database
+	switch {
+	case database.Query != "":
+		return database.Type + ":query"
+	case database.Table != "":
+		return database.Type + ":" + database.Table
+	}
+	return database.Filename
+}
+
+// loadTokenizeRecords reads the raw records to tokenize, CSV in the given dialect. With
+// useDatabase, inputFile is the config whose database section names the source.
+func loadTokenizeRecords(inputFile, inputFormat string, dialect config.CSVDialect, useDatabase bool) ([]map[string]string, error) {
+	if useDatabase {
+		return loadDatabaseRecords(inputFile)
+	}
+
+	// Load records from input file
+	fmt.Println("Loading records from input file...")
+
+	var allRecords []map[string]string
+
+	if inputFormat == "csv" {
+		// Use CSV database to load records
+		csvDB, err := db.NewCSVDatabaseWithDialect(inputFile, dialect)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open CSV file: %w", err)
+		}
+
+		// Get all records from CSV
+		allRecords, err = csvDB.List(0, 100000) // Load all records (up to 100k)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read records: %w", err)
+		}
+	} else if inputFormat == "json" {
+		// A JSON array of objects or JSON Lines; array values are multi-valued fields
+		jsonDB, err := db.NewJSONDatabase(inputFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JSON file: %w", err)
+		}
+		allRecords, err = jsonDB.List(0, jsonDB.Len())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read records: %w", err)
+		}
+	} else if inputFormat == "hl7" {
+		// Demographics from the PID segment of ADT^A01/A08 messages
+		var err error
+		allRecords, err = loadHL7Records(inputFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HL7 messages: %w", err)
+		}
+	} else {
+		return nil, fmt.Errorf("input format %s not yet implemented - please use CSV", inputFormat)
+	}
+
+	fmt.Printf("   Loaded %d records\n", len(allRecords))
+	return allRecords, nil
+}
+
+// databaseStreamer is a Database that can read all its rows with one query
+type databaseStreamer interface {
+	Stream(fn func(row map[string]string) error) error
+}
+
+// loadDatabaseRecords reads every row of the database section of configFile: csv, postgres,
+// mysql or sqlserver
+func loadDatabaseRecords(configFile string) ([]map[string]string, error) {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	source, err := db.GetDatabaseFromConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s database: %w", cfg.Database.Type, err)
+	}
+	if closer, ok := source.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	fmt.Printf("Loading records from %s database...\n", cfg.Database.Type)
+	var allRecords []map[string]string
+	if streamer, ok := source.(databaseStreamer); ok {
+		err = streamer.Stream(func(row map[string]string) error {
+			allRecords = append(allRecords, row)
+			return nil
+		})
+	} else {
+		const pageSize = 10000
+		for start := 0; ; start += pageSize {
+			page, listErr := source.List(start, pageSize)
+			if listErr != nil {
+				err = listErr
+				break
+			}
+			allRecords = append(allRecords, page...)
+			if len(page) < pageSize {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read records: %w", err)
+	}
+
+	fmt.Printf("   Loaded %d records\n", len(allRecords))
+	return allRecords, nil
+}
 
-	// Encrypt and authenticate
-	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
+// performCSVTokenization is now used by both tokenize and pprl commands; missing-data counts are added to run if set.
+// With outputFormat jsonl, records are written as JSON Lines instead; either is gzipped if outputFile ends in .gz (or .gz.enc).
+func performCSVTokenization(allRecords []map[string]string, outputFile, outputFormat string, fields []string, batchSize int, recordConfig *pprl.RecordConfig, encryption keys.EncryptOptions, keyFile string, noEncryption bool, normalizationConfig map[string]crypto.NormalizationMethod, memory *memlimit.Watchdog, run *store.Run) (int, error) {
+	compress := db.IsGzip(strings.TrimSuffix(outputFile, ".enc"))
 
-	// Write to output file with restricted permissions
-	if err := os.WriteFile(outputFile, ciphertext, 0600); err != nil {
-		return fmt.Errorf("failed to write encrypted file: %w", err)
+	// Determine if we need to encrypt
+	var tempFile string
+	var finalOutputFile string
+
+	if !noEncryption {
+		// Create temporary unencrypted file first
+		tempFile = outputFile + ".tmp"
+		finalOutputFile = outputFile
+		outputFile = tempFile // Write to temp file first
 	}
 
+	// Create CSV output file with proper headers
+	outputCSV, err := os.Create(outputFile)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer outputCSV.Close()
+
+	// Create deterministic MinHash once and reuse for all records
+	tokenizer, err := newRecordTokenizer(fields, recordConfig, normalizationConfig)
+	if err != nil {
+		return 0, err
+	}
+
+	writer, err := newTokenRowWriter(outputCSV, outputFormat, tokenizer.header(), compress)
+	if err != nil {
+		return 0, err
+	}
+	defer writer.Close()
+
+	fmt.Println("Processing records in batches...")
+	fmt.Printf("   Batch size: %d\n", batchSize)
+	if memory != nil {
+		fmt.Printf("   Memory limit: %s (batches shrink above %.0f%% of it)\n", memlimit.FormatSize(memory.Limit()), memlimit.HighWater*100)
+	}
+	fmt.Println("   Generating Bloom filters...")
+	fmt.Println("   Computing MinHash signatures...")
+
+	processedCount := 0
+	totalRecords := len(allRecords)
+
+	size, batchNumber := batchSize, 0
+	for i := 0; i < totalRecords; i += size {
+		size = memory.Batch(size, batchSize)
+		end := i + size
+		if end > totalRecords {
+			end = totalRecords
+		}
+
+		batch := allRecords[i:end]
+		batchNumber++
+		if memory == nil {
+			fmt.Printf("   Processing batch %d/%d (%d records)\n",
+				batchNumber,
+				(totalRecords+batchSize-1)/batchSize,
+				len(batch))
+		} else {
+			fmt.Printf("   Processing batch %d (%d records, %d of %d done)\n", batchNumber, len(batch), i, totalRecords)
+		}
+
+		for _, record := range batch {
+			row, err := tokenizer.row(record, fmt.Sprintf("record_%d", processedCount+1))
+			if err != nil {
+				return 0, err
+			}
+			if row == nil {
+				continue // Skip records with no data in specified fields
+			}
+
+			if err := writer.Write(row); err != nil {
+				return 0, fmt.Errorf("failed to write record: %w", err)
+			}
+
+			processedCount++
+		}
+
+		if memory != nil {
+			// Tokenized records are released, so the raw input shrinks as the output grows
+			clear(batch)
+			if err := memory.Check(fmt.Sprintf("tokenizing (%d of %d records done)", end, totalRecords)); err != nil {
+				writer.Close()
+				outputCSV.Close()
+				os.Remove(outputFile)
+				return 0, err
+			}
+		}
+	}
+
+	// Close the file to ensure all data is written
+	if err := writer.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write output file: %w", err)
+	}
+	outputCSV.Close()
+
+	fmt.Printf("Successfully tokenized %d records\n", processedCount)
+	tokenizer.reportMissingData(run)
+	if err := tokenizer.reportSanitization(run); err != nil {
+		return 0, err
+	}
+	if err := tokenizer.reportDensity(run); err != nil {
+		os.Remove(outputFile)
+		return 0, err
+	}
+
+	// Handle encryption if enabled
+	if !noEncryption {
+		fmt.Println("Encrypting output file...")
+
+		// Save encryption key to file if keyFile is specified
+		if keyFile != "" {
+			if err := keys.WriteKeyFile(keyFile, encryption.Key); err != nil {
+				// Cleanup temp file before returning error
+				os.Remove(tempFile)
+				return 0, fmt.Errorf("failed to save encryption key: %w", err)
+			}
+			fmt.Printf("   Encryption key saved to: %s\n", keyFile)
+		}
+
+		// Encrypt the file
+		header, err := keys.EncryptFile(tempFile, finalOutputFile, encryption)
+		if err != nil {
+			// Cleanup temp file before returning error
+			os.Remove(tempFile)
+			return 0, fmt.Errorf("failed to encrypt output file: %w", err)
+		}
+
+		// Secure cleanup of temporary file
+		if err := secureDeleteFile(tempFile); err != nil {
+			fmt.Printf("Warning: failed to securely delete temporary file: %v\n", err)
+		}
+
+		fmt.Printf("   File encrypted successfully with AES-256-GCM (key %s)\n", header.KeyID)
+	}
+
+	return processedCount, nil
+}
+
+// storeMemoryCheckInterval is the number of records written to a token store between memory checks
+const storeMemoryCheckInterval = 1000
+
+// performStoreTokenization writes a binary token store for memory-mapped intersections of very
+// large datasets; stores are never encrypted, since they are mapped from disk as they are read
+func performStoreTokenization(allRecords []map[string]string, outputFile string, fields []string, recordConfig *pprl.RecordConfig, normalizationConfig map[string]crypto.NormalizationMethod, memory *memlimit.Watchdog, run *store.Run) (int, error) {
+	tokenizer, err := newRecordTokenizer(fields, recordConfig, normalizationConfig)
+	if err != nil {
+		return 0, err
+	}
+	writer, err := pprl.NewBloomStoreWriter(outputFile, recordConfig.FilterSize(), recordConfig.BloomHashes, recordConfig.MinHashSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create token store: %w", err)
+	}
+
+	fmt.Println("Writing binary token store...")
+	if tokenizer.blocker != nil {
+		fmt.Println("   Note: token stores hold no blocking keys; intersecting them compares every pair")
+	}
+	processedCount := 0
+	for i, record := range allRecords {
+		if memory != nil && i > 0 && i%storeMemoryCheckInterval == 0 {
+			clear(allRecords[i-storeMemoryCheckInterval : i])
+			if err := memory.Check(fmt.Sprintf("tokenizing (%d of %d records done)", i, len(allRecords))); err != nil {
+				writer.Close()
+				os.Remove(outputFile)
+				return 0, err
+			}
+		}
+		row, err := tokenizer.row(record, fmt.Sprintf("record_%d", processedCount+1))
+		if err != nil {
+			writer.Close()
+			return 0, err
+		}
+		if row == nil {
+			continue // Skip records with no data in specified fields
+		}
+
+		bf, err := pprl.BloomFromBase64(row[1])
+		if err != nil {
+			writer.Close()
+			return 0, fmt.Errorf("failed to decode Bloom filter for %s: %w", row[0], err)
+		}
+		mh, err := pprl.MinHashFromBase64(row[2])
+		if err != nil {
+			writer.Close()
+			return 0, fmt.Errorf("failed to decode MinHash for %s: %w", row[0], err)
+		}
+		if err := writer.Append(row[0], bf, mh.GetSignature()); err != nil {
+			writer.Close()
+			return 0, err
+		}
+		processedCount++
+	}
+	if err := writer.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write token store: %w", err)
+	}
+
+	fmt.Printf("Successfully tokenized %d records\n", processedCount)
+	tokenizer.reportMissingData(run)
+	if err := tokenizer.reportSanitization(run); err != nil {
+		return 0, err
+	}
+	if err := tokenizer.reportDensity(run); err != nil {
+		os.Remove(outputFile)
+		return 0, err
+	}
+	return processedCount, nil
+}
+
+// performPostgresTokenization loads the tokenized records into the output.postgres tokens table
+// with COPY, tagged with the run ID; the rows are committed together once all are written
+func performPostgresTokenization(inputFile, inputFormat string, dialect config.CSVDialect, postgres config.PostgresSinkConfig, fields []string, recordConfig *pprl.RecordConfig, useDatabase bool, normalizationConfig map[string]crypto.NormalizationMethod, run *store.Run) (int, error) {
+	sink, err := db.NewPostgresSink(postgres)
+	if err != nil {
+		return 0, err
+	}
+	defer sink.Close()
+
+	allRecords, err := loadTokenizeRecords(inputFile, inputFormat, dialect, useDatabase)
+	if err != nil {
+		return 0, err
+	}
+	allRecords = selectWatermarkRecords(allRecords, recordConfig.Watermark, run)
+	tokenizer, err := newRecordTokenizer(fields, recordConfig, normalizationConfig)
+	if err != nil {
+		return 0, err
+	}
+	table, err := sink.Table(postgres.TokensTable, db.TokenSinkColumns)
+	if err != nil {
+		return 0, err
+	}
+
+	fmt.Printf("Copying tokens into %s (%d rows per batch)...\n", table.Name(), postgres.BatchSize)
+	if tokenizer.blocker != nil {
+		fmt.Println("   Note: the tokens table has no blocking key column; blocking keys are not copied")
+	}
+	processedCount := 0
+	for _, record := range allRecords {
+		row, err := tokenizer.row(record, fmt.Sprintf("record_%d", processedCount+1))
+		if err != nil {
+			table.Abort()
+			return 0, err
+		}
+		if row == nil {
+			continue // Skip records with no data in specified fields
+		}
+		if err := table.Append(run.ID, row[0], row[1], row[2], row[3]); err != nil {
+			table.Abort()
+			return 0, err
+		}
+		processedCount++
+	}
+	// Saturated tokens are not committed with -strict
+	if err := tokenizer.reportDensity(run); err != nil {
+		table.Abort()
+		return 0, err
+	}
+	if _, err := table.Commit(); err != nil {
+		return 0, err
+	}
+
+	fmt.Printf("Successfully tokenized %d records\n", processedCount)
+	tokenizer.reportMissingData(run)
+	if err := tokenizer.reportSanitization(run); err != nil {
+		return 0, err
+	}
+	return processedCount, nil
+}
+
+// tokenizedCSVHeader is the header of every tokenized CSV file; files tokenized with blocking keys
+// add a blocking column, those with exact identifiers an exact_id column, and those with
+// provenance the pprl.ProvenanceColumns
+var tokenizedCSVHeader = []string{"id", "bloom_filter", "minhash", "timestamp"}
+
+// blockingColumn holds the space-separated hashed blocking keys of a record
+const blockingColumn = "blocking"
+
+// exactIDColumn holds the keyed hash of a record's exact identifier (tokenization.exact_id)
+const exactIDColumn = "exact_id"
+
+// tokenRowWriter writes the rows of a recordTokenizer in an output file format
+type tokenRowWriter interface {
+	Write(row []string) error
+	Close() error // Flushes the rows; the file itself is closed by the caller
+}
+
+// newTokenRowWriter writes rows with the given header as CSV or, with format jsonl, as one
+// JSON object per line, gzipped if compress is set
+func newTokenRowWriter(w io.Writer, format string, header []string, compress bool) (tokenRowWriter, error) {
+	if format == "jsonl" {
+		return &jsonlRowWriter{writer: db.NewTokenizedJSONLWriter(w, compress), blocking: slices.Index(header, blockingColumn), exactID: slices.Index(header, exactIDColumn), provenance: slices.Index(header, pprl.SourceFileColumn)}, nil
+	}
+	writer := &csvRowWriter{}
+	if compress {
+		writer.gz = gzip.NewWriter(w)
+		w = writer.gz
+	}
+	writer.Writer = csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write CSV header: %w", err)
+	}
+	return writer, nil
+}
+
+// csvRowWriter writes tokenized rows as CSV
+type csvRowWriter struct {
+	*csv.Writer
+	gz *gzip.Writer // Compresses the rows (nil writes them as they are)
+}
+
+// Close flushes the buffered rows and ends the gzip stream; it does not close the underlying
+// writer
+func (w *csvRowWriter) Close() error {
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+// jsonlRowWriter writes tokenized rows as JSON Lines records
+type jsonlRowWriter struct {
+	writer     *db.TokenizedJSONLWriter
+	blocking   int // Row index of the blocking keys, or -1
+	exactID    int // Row index of the exact identifier, or -1
+	provenance int // Row index of the source file, followed by the source row and batch ID, or -1
+}
+
+func (w *jsonlRowWriter) Write(row []string) error {
+	record := db.TokenizedRecord{ID: row[0], BloomFilter: row[1], MinHash: row[2], Timestamp: row[3]}
+	if w.blocking >= 0 && w.blocking < len(row) {
+		record.Blocking = row[w.blocking]
+	}
+	if w.exactID >= 0 && w.exactID < len(row) {
+		record.ExactID = row[w.exactID]
+	}
+	if w.provenance >= 0 && w.provenance+2 < len(row) {
+		record.Provenance = db.Provenance{SourceFile: row[w.provenance], SourceRow: row[w.provenance+1], BatchID: row[w.provenance+2]}
+	}
+	return w.writer.Write(record)
+}
+
+func (w *jsonlRowWriter) Close() error {
+	return w.writer.Close()
+}
+
+// recordTokenizer turns raw records into tokenized CSV rows and counts missing fields
+type recordTokenizer struct {
+	fields              []string
+	recordConfig        *pprl.RecordConfig
+	normalizationConfig map[string]crypto.NormalizationMethod
+	minHash             *pprl.MinHash
+	blocker             *crypto.Blocker // Derives blocking keys (nil without tokenization.blocking)
+
+	records int               // Records seen
+	missing map[string]int    // Records with each field empty
+	density pprl.DensityStats // Share of Bloom filter bits set per tokenized record
+	skipped int               // Records left out by the skip strategy
+	checked bool              // Source columns checked against the column mapping
+
+	sanitized map[string]int // Values with each sanitization problem
+	rejected  int            // Records left out for a value over the length limit
+	rejects   *rejectsReport // Report of sanitized values (nil until the first is written)
+}
+
+func newRecordTokenizer(fields []string, recordConfig *pprl.RecordConfig, normalizationConfig map[string]crypto.NormalizationMethod) (*recordTokenizer, error) {
+	mh, err := pprl.NewMinHashSeeded(recordConfig.FilterSize(), recordConfig.MinHashSize, recordConfig.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MinHash: %w", err)
+	}
+
+	if recordConfig.Encoding == pprl.EncodingRBF && recordConfig.RBF == nil {
+		// The layout depends on the field list, so it is built per tokenizer
+		weights := make([]float64, len(fields))
+		for i, field := range fields {
+			weights[i] = recordConfig.FieldWeight(field)
+		}
+		layout, err := pprl.NewRBFLayout(recordConfig.BloomSize, recordConfig.BloomSize, weights, recordConfig.Salt, recordConfig.LinkageSecret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build RBF layout: %w", err)
+		}
+		withLayout := *recordConfig
+		withLayout.RBF = layout
+		recordConfig = &withLayout
+	}
+
+	var blocker *crypto.Blocker
+	if len(recordConfig.Blocking) > 0 {
+		keys, err := crypto.ParseBlockingKeys(recordConfig.Blocking, fields, normalizationConfig)
+		if err != nil {
+			return nil, fmt.Errorf("tokenization.blocking: %w", err)
+		}
+		blocker = crypto.NewBlocker(keys, normalizationConfig, keyedHashSecret(recordConfig))
+	}
+
+	return &recordTokenizer{
+		fields:              fields,
+		recordConfig:        recordConfig,
+		normalizationConfig: normalizationConfig,
+		minHash:             mh,
+		blocker:             blocker,
+		missing:             make(map[string]int),
+		sanitized:           make(map[string]int),
+	}, nil
+}
+
+// keyedHashSecret keys the hashes of blocking keys and exact identifiers. Both parties hold the
+// linkage secret; without one the hashes are only as secret as the seed.
+func keyedHashSecret(recordConfig *pprl.RecordConfig) []byte {
+	if len(recordConfig.LinkageSecret) == 0 {
+		return []byte(recordConfig.Salt)
+	}
+	return recordConfig.LinkageSecret
+}
+
+// header returns the header of the tokenized CSV files this tokenizer writes
+func (t *recordTokenizer) header() []string {
+	header := append([]string{}, tokenizedCSVHeader...)
+	if t.blocker != nil {
+		header = append(header, blockingColumn)
+	}
+	if t.recordConfig.ExactID != "" {
+		header = append(header, exactIDColumn)
+	}
+	if t.recordConfig.Provenance != nil {
+		header = append(header, pprl.ProvenanceColumns...)
+	}
+	return header
+}
+
+// row tokenizes one record, using defaultID when it has no id; it returns nil for
+// records with no data in the configured fields
+func (t *recordTokenizer) row(record map[string]string, defaultID string) ([]string, error) {
+	record = t.recordConfig.MultiValue.Apply(record)
+	columns, ids := t.recordConfig.Columns, t.recordConfig.IDColumns
+	// The first record shows the source schema; stop before tokenizing anything if it lacks a column
+	if !t.checked && (columns != nil || ids != nil || t.recordConfig.ExactID != "") {
+		present := make([]string, 0, len(record))
+		for column := range record {
+			present = append(present, column)
+		}
+		if err := columns.Check(present, t.fields); err != nil {
+			return nil, fmt.Errorf("database.column_mapping: %w", err)
+		}
+		if err := ids.Check(present); err != nil {
+			return nil, fmt.Errorf("database.id_column: %w", err)
+		}
+		if exactID := t.recordConfig.ExactID; exactID != "" && !slices.Contains(present, exactID) {
+			return nil, fmt.Errorf("tokenization.exact_id: source has no column %q", exactID)
+		}
+		t.checked = true
+	}
+	record = columns.Apply(record)
+	t.records++
+
+	// Source columns are kept by the mapping, so the ID is read after it, which may map id itself
+	recordID, err := ids.ID(record)
+	if err != nil {
+		return nil, fmt.Errorf("record %d: %w", t.records, err)
+	}
+
+	// Bound the values before they are q-grammed: one oversized cell would set most filter bits
+	record, rejected, err := t.sanitize(record, recordID)
+	if err != nil {
+		return nil, err
+	}
+	if rejected {
+		t.rejected++
+		return nil, nil
+	}
+
+	// Extract field values for this record
+	var fieldValues []pprl.Field
+	var totalWeight, presentWeight float64
+	redistribute, skip := false, false
+	for position, field := range t.fields {
+		method := t.normalizationConfig[field]
+		weight := 1.0
+		if method == crypto.NormBirthdate && t.recordConfig.DateWeight > 0 {
+			weight = t.recordConfig.DateWeight
+		}
+		totalWeight += weight
+
+		// A multi-valued field (prior surnames, earlier addresses) adds the q-grams of every value
+		var normalizedValues []string
+		for _, value := range pprl.SplitValues(record[field]) {
+			if t.recordConfig.TextFold != nil {
+				value = t.recordConfig.TextFold(value)
+			}
+			if t.recordConfig.Nicknames != nil && crypto.IsNameMethod(method) {
+				// Feed both the given name and its canonical forms into the Bloom filter
+				value = crypto.NicknameDictionary(t.recordConfig.Nicknames).Expand(value)
+			}
+			// Apply the configured normalization, or basic normalization
+			if normalizedValue := crypto.NormalizeField(value, method); normalizedValue != "" && !slices.Contains(normalizedValues, normalizedValue) {
+				normalizedValues = append(normalizedValues, normalizedValue)
+			}
+		}
+
+		if len(normalizedValues) == 0 {
+			t.missing[field]++
+			strategy, imputed := t.recordConfig.MissingData.Strategy(field)
+			switch strategy {
+			case pprl.MissingSkip:
+				// Keep counting the other fields before leaving the record out
+				skip = true
+			case pprl.MissingRedistribute:
+				redistribute = true
+			case pprl.MissingImpute:
+				if t.recordConfig.TextFold != nil {
+					imputed = t.recordConfig.TextFold(imputed)
+				}
+				if normalizedValue := crypto.NormalizeField(imputed, method); normalizedValue != "" {
+					normalizedValues = append(normalizedValues, normalizedValue)
+				}
+			}
+			if len(normalizedValues) == 0 {
+				continue
+			}
+		}
+
+		presentWeight += weight
+		for _, normalizedValue := range normalizedValues {
+			fieldValues = append(fieldValues, pprl.Field{Value: normalizedValue, Weight: weight, Position: position})
+		}
+	}
+
+	if skip {
+		t.skipped++
+		return nil, nil
+	}
+	if len(fieldValues) == 0 {
+		return nil, nil
+	}
+
+	// Scale the present fields up so the record sets as many bits as a complete one
+	if redistribute && presentWeight < totalWeight {
+		for i := range fieldValues {
+			fieldValues[i].Weight *= totalWeight / presentWeight
+		}
+	}
+
+	if recordID == "" {
+		// Generate ID if not present
+		recordID = defaultID
+	}
+	recordID, err = t.recordConfig.IDs.Pseudonym(recordID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create PPRL record with real tokenization
+	pprlRecord, err := pprl.CreateWeightedRecord(recordID, fieldValues, t.recordConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PPRL record for %s: %w", recordID, err)
+	}
+
+	// Convert to CSV format with actual record ID
+	timestamp := time.Now().Format("2006-01-02T15:04:05Z")
+
+	// Encode the complete MinHash object so loaders can recover the signature
+	bf, err := pprl.BloomFromBase64(pprlRecord.BloomData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode Bloom filter for %s: %w", recordID, err)
+	}
+	t.density.Add(bf)
+	if _, err := t.minHash.ComputeSignature(bf); err != nil {
+		return nil, fmt.Errorf("failed to compute MinHash signature for %s: %w", recordID, err)
+	}
+	minHashEncoded, err := t.minHash.ToBase64()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode MinHash for %s: %w", recordID, err)
+	}
+
+	row := []string{
+		recordID, // Include the actual record ID
+		pprlRecord.BloomData,
+		minHashEncoded,
+		timestamp,
+	}
+	if t.blocker != nil {
+		// Blocking keys come from the current field values before nickname expansion
+		values := make(map[string]string, len(t.fields))
+		for _, field := range t.fields {
+			value := pprl.FirstValue(record[field])
+			if value != "" && t.recordConfig.TextFold != nil {
+				value = t.recordConfig.TextFold(value)
+			}
+			values[field] = value
+		}
+		row = append(row, pprl.JoinBlockingKeys(t.blocker.Keys(values)))
+	}
+	if t.recordConfig.ExactID != "" {
+		row = append(row, crypto.HashExactID(keyedHashSecret(t.recordConfig), record[t.recordConfig.ExactID]))
+	}
+	if provenance := t.recordConfig.Provenance; provenance != nil {
+		row = append(row, provenance.Values(record, t.records)...)
+	}
+	return row, nil
+}
+
+// sanitize cleans the values of the tokenized fields with the record config's sanitizer, reporting
+// every change to the rejects file. It returns a copy of record if a value changed, and whether
+// the record is rejected; all its fields are still checked, so the report lists every problem.
+func (t *recordTokenizer) sanitize(record map[string]string, recordID string) (map[string]string, bool, error) {
+	sanitizer := t.recordConfig.Sanitizer
+	if sanitizer == nil {
+		return record, false, nil
+	}
+	cleaned, copied, rejected := record, false, false
+	for _, field := range t.fields {
+		values := pprl.SplitValues(record[field])
+		changed := false
+		for i, value := range values {
+			clean, violations := sanitizer.Clean(value)
+			if len(violations) == 0 {
+				continue
+			}
+			for _, violation := range violations {
+				t.sanitized[violation]++
+				if err := t.reportRejected(recordID, field, violation, utf8.RuneCountInString(value)); err != nil {
+					return nil, false, err
+				}
+			}
+			rejected = rejected || sanitizer.Rejects(violations)
+			values[i], changed = clean, true
+		}
+		if !changed {
+			continue
+		}
+		if !copied {
+			// The record may be the reader's own map, so it is left as read
+			cleaned, copied = maps.Clone(record), true
+		}
+		cleaned[field] = pprl.JoinValues(values)
+	}
+	return cleaned, rejected, nil
+}
+
+// rejectsReport lists the sanitized values of a run: the record, field, problem, length in
+// characters and the action taken. Values are never written, so the report holds no PHI beyond the
+// record IDs already in the token file.
+type rejectsReport struct {
+	file   io.WriteCloser
+	writer *csv.Writer
+}
+
+// reportRejected adds a sanitized value to the rejects file, creating it on the first
+func (t *recordTokenizer) reportRejected(recordID, field, violation string, length int) error {
+	sanitizer := t.recordConfig.Sanitizer
+	if sanitizer.RejectsFile == "" {
+		return nil
+	}
+	if t.rejects == nil {
+		file, err := db.CreateOutput(sanitizer.RejectsFile)
+		if err != nil {
+			return fmt.Errorf("failed to create rejects file: %w", err)
+		}
+		t.rejects = &rejectsReport{file: file, writer: csv.NewWriter(file)}
+		t.rejects.writer.Write([]string{"record", "id", "field", "problem", "length", "action"})
+	}
+
+	action := "stripped"
+	if violation == pprl.ViolationTooLong {
+		action = "truncated"
+		if sanitizer.OnTooLong == pprl.SanitizeReject {
+			action = "rejected"
+		}
+	}
+	t.rejects.writer.Write([]string{strconv.Itoa(t.records), recordID, field, violation, strconv.Itoa(length), action})
+	return t.rejects.writer.Error()
+}
+
+// reportSanitization prints how many values were sanitized, adds the counts to run (if set) and
+// closes the rejects file. Nothing is printed when every value was clean.
+func (t *recordTokenizer) reportSanitization(run *store.Run) error {
+	if t.rejects != nil {
+		t.rejects.writer.Flush()
+		err := t.rejects.writer.Error()
+		if closeErr := t.rejects.file.Close(); err == nil {
+			err = closeErr
+		}
+		t.rejects = nil
+		if err != nil {
+			return fmt.Errorf("failed to write rejects file: %w", err)
+		}
+	}
+
+	stripped, tooLong := t.sanitized[pprl.ViolationControl], t.sanitized[pprl.ViolationTooLong]
+	if run != nil && t.recordConfig.Sanitizer != nil {
+		run.Counts["control_characters_stripped"] = stripped
+		run.Counts["values_too_long"] = tooLong
+		run.Counts["rejected_records"] = t.rejected
+	}
+	if stripped == 0 && tooLong == 0 {
+		return nil
+	}
+
+	sanitizer := t.recordConfig.Sanitizer
+	fmt.Println("Input sanitization:")
+	if stripped > 0 {
+		fmt.Printf("   %d values had control characters stripped\n", stripped)
+	}
+	if tooLong > 0 {
+		fmt.Printf("   %d values were longer than %d characters (%s)\n", tooLong, sanitizer.MaxLength, sanitizer.OnTooLong)
+	}
+	if t.rejected > 0 {
+		fmt.Printf("   %d records rejected\n", t.rejected)
+	}
+	if sanitizer.RejectsFile != "" {
+		fmt.Printf("   Rejects report: %s\n", sanitizer.RejectsFile)
+	}
 	return nil
 }
 
-// saveKeyToFile saves the encryption key to a file with restricted permissions
-func saveKeyToFile(keyHex, keyFile string) error {
-	keyData := fmt.Sprintf("# CohortBridge Encryption Key\n# Generated: %s\n# WARNING: Keep this key secure! Without it, your data cannot be decrypted.\n\n%s\n",
-		time.Now().Format("2006-01-02 15:04:05"), keyHex)
+// reportMissingData prints how many records had each field empty and adds the counts to run (if set)
+func (t *recordTokenizer) reportMissingData(run *store.Run) {
+	if t.records == 0 {
+		return
+	}
+	var lines []string
+	for _, field := range t.fields {
+		count := t.missing[field]
+		if run != nil {
+			run.Counts["missing_"+field] = count
+		}
+		if count > 0 {
+			strategy, _ := t.recordConfig.MissingData.Strategy(field)
+			lines = append(lines, fmt.Sprintf("   %-20s %6d (%.1f%%, %s)", field, count, 100*float64(count)/float64(t.records), strategy))
+		}
+	}
+	if run != nil && t.skipped > 0 {
+		run.Counts["skipped_missing"] = t.skipped
+	}
+
+	if len(lines) == 0 {
+		fmt.Println("Missing data: none")
+		return
+	}
+	fmt.Printf("Missing data (of %d records):\n", t.records)
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	if t.skipped > 0 {
+		fmt.Printf("   %d records skipped for missing fields\n", t.skipped)
+	}
+}
 
-	if err := os.WriteFile(keyFile, []byte(keyData), 0600); err != nil {
-		return fmt.Errorf("failed to write key file: %w", err)
+// reportDensity prints the distribution of Bloom filter densities and adds it to run (if set).
+// A mean density above the recipe's MaxDensity is warned about, or is an error with StrictDensity.
+func (t *recordTokenizer) reportDensity(run *store.Run) error {
+	if t.density.Count() == 0 {
+		return nil
+	}
+	mean := t.density.Mean()
+	fmt.Printf("Bloom filter density (share of %d bits set):\n", t.recordConfig.FilterSize())
+	fmt.Printf("   mean %.3f  p50 %.3f  p90 %.3f  p99 %.3f  max %.3f\n", mean,
+		t.density.Percentile(0.5), t.density.Percentile(0.9), t.density.Percentile(0.99), t.density.Max())
+	if run != nil {
+		run.Parameters["density_mean"] = strconv.FormatFloat(mean, 'f', 3, 64)
+		run.Parameters["density_p99"] = strconv.FormatFloat(t.density.Percentile(0.99), 'f', 3, 64)
 	}
 
+	limit := t.recordConfig.MaxDensity
+	if limit <= 0 || mean <= limit {
+		return nil
+	}
+	if t.recordConfig.StrictDensity {
+		return fmt.Errorf("mean Bloom filter density %.3f exceeds tokenization.max_density %.2f; "+
+			"increase bloom_size or lower bloom_hashes (-strict)", mean, limit)
+	}
+	fmt.Println()
+	fmt.Println("WARNING: ========================================================================")
+	fmt.Printf("WARNING: Mean Bloom filter density %.3f exceeds tokenization.max_density %.2f\n", mean, limit)
+	fmt.Println("WARNING: The filters are saturating, so unrelated records look similar and")
+	fmt.Println("WARNING: matching will report false positives. Increase bloom_size or lower")
+	fmt.Println("WARNING: bloom_hashes (both parties), or use 'cohort-bridge bench' to compare.")
+	fmt.Println("WARNING: ========================================================================")
+	fmt.Println()
 	return nil
 }
 
@@ -692,17 +2056,44 @@ func showTokenizeHelp() {
 	fmt.Println("  cohort-bridge tokenize                     # Interactive mode")
 	fmt.Println()
 	fmt.Println("OPTIONS:")
-	fmt.Println("  -input string          Input file with PHI data")
+	fmt.Println("  -input string          Input file with PHI data, optionally gzipped (data.csv.gz)")
 	fmt.Println("  -output string         Output file for tokenized data")
+	fmt.Println("                         Input and output may be s3://, gs:// or az:// objects,")
+	fmt.Println("                         using the storage section of -main-config")
 	fmt.Println("  -main-config string    Main config file to read field names from")
-	fmt.Println("  -input-format string   Input format: csv, json, postgres")
-	fmt.Println("  -output-format string  Output format: csv, json")
+	fmt.Println("  -input-format string   Input format: csv, json, postgres, hl7 (.json, .jsonl and")
+	fmt.Println("                         .hl7 files are recognized by extension)")
+	fmt.Println("  -output-format string  Output format: csv, jsonl (JSON Lines, one record per line;")
+	fmt.Println("                         either gzipped if -output ends in .gz or .gz.enc), cbbf (binary token store,")
+	fmt.Println("                         memory-mapped by intersect; requires -no-encryption)")
+	fmt.Println("                         or postgres (copied into output.postgres.tokens_table of")
+	fmt.Println("                         -main-config instead of -output; requires -no-encryption)")
 	fmt.Println("  -batch-size int        Number of records to process in each batch")
+	fmt.Println("  -max-memory size       Memory limit, e.g. 4G: batches shrink as the heap nears it and")
+	fmt.Println("                         the run stops cleanly, with advice, instead of exceeding it")
 	fmt.Println("  -interactive           Force interactive mode")
 	fmt.Println("  -database              Use database from main config instead of file")
+	fmt.Println("                         (database.type csv, postgres, mysql or sqlserver)")
+	fmt.Println("  -mllp string           Listen for HL7v2 messages over MLLP (e.g. :2575)")
 	fmt.Println("  -minhash-seed string   Seed for deterministic MinHash generation")
+	fmt.Println("  -linkage-secret-file string  Shared secret file for HMAC-keyed Bloom hashing")
 	fmt.Println("  -encryption-key string 32-byte hex encryption key (auto-generated if empty)")
 	fmt.Println("  -no-encryption         Disable encryption (not recommended for production)")
+	fmt.Println("  -strict                Fail, writing nothing, when the mean Bloom filter density")
+	fmt.Printf("                         exceeds tokenization.max_density (default: %g)\n", config.DefaultMaxDensity)
+	fmt.Println("  -since string          Tokenize only records modified after this time, or after the")
+	fmt.Println("                         watermark in an earlier token file's manifest (see INCREMENTAL)")
+	fmt.Println("  -watermark-column string  Last-modified column (default: database.watermark_column)")
+	fmt.Println("  -csv-delimiter string  CSV field separator, one character or tab (default: ,)")
+	fmt.Println("  -csv-quote string      CSV quote character (default: \")")
+	fmt.Println("  -csv-encoding string   CSV character encoding, e.g. latin1, windows-1252 or utf-16le")
+	fmt.Println("                         (default: utf-8)")
+	fmt.Println("  -csv-no-header         The CSV input has no header row (see CSV DIALECTS)")
+	fmt.Println("  -max-field-length N    Longest field value tokenized, in characters (default 256, -1 for none)")
+	fmt.Println("  -on-too-long POLICY    Longer values: truncate (default) or reject the record")
+	fmt.Println("  -rejects FILE          CSV report of sanitized values and rejected records")
+	fmt.Println("  -provenance            Add source_file, source_row and batch_id columns (see PROVENANCE)")
+	fmt.Println("  -batch-id ID           Batch ID written with -provenance (default: the run ID)")
 	fmt.Println("  -force                 Skip confirmation prompts and run automatically")
 	fmt.Println("  -help                  Show this help message")
 	fmt.Println()
@@ -712,6 +2103,80 @@ func showTokenizeHelp() {
 	fmt.Println("  - Keep your encryption key safe! Data cannot be recovered without it")
 	fmt.Println("  - Use -no-encryption to disable (not recommended for production)")
 	fmt.Println()
+	fmt.Println("KEYED HASHING:")
+	fmt.Println("  With a linkage secret, Bloom filter positions are derived with HMAC-SHA256")
+	fmt.Println("  so a leaked token file cannot be reversed by hashing common names.")
+	fmt.Println("  - Generate once per project: openssl rand -hex 32 > linkage.secret")
+	fmt.Println("  - Share it with the peer out of band; store it apart from token files")
+	fmt.Println("  - Or keep it in an HSM: tokenization.linkage_secret_provider: pkcs11 (or kms)")
+	fmt.Println("    derives it from an HMAC key both parties hold in their tokens, so it is")
+	fmt.Println("    never in a file (see 'cohort-bridge keys -help')")
+	fmt.Println()
+	fmt.Println("DENSITY:")
+	fmt.Println("  The share of Bloom filter bits set is reported after tokenization (mean and")
+	fmt.Println("  percentiles). Filters near saturation make unrelated records look similar;")
+	fmt.Println("  a mean above tokenization.max_density is warned about, or fails with -strict.")
+	fmt.Println()
+	fmt.Println("BLOCKING KEYS:")
+	fmt.Println("  tokenization.blocking in -main-config (e.g. zip3+birth_year, soundex(last_name))")
+	fmt.Println("  adds a blocking column of hashed keys; intersections then only compare records")
+	fmt.Println("  that share a key. embedding(first_name+last_name) adds hashed LSH bands of a")
+	fmt.Println("  character n-gram embedding of the names, computed locally, to block noisy names")
+	fmt.Println("  by similarity. Not written to cbbf or postgres output.")
+	fmt.Println()
+	fmt.Println("COLUMN MAPPING:")
+	fmt.Println("  database.column_mapping in -main-config reads each field from a column of the")
+	fmt.Println("  site's schema (FIRST: given_name), optionally with transforms; all mapped")
+	fmt.Println("  columns must exist in the input or tokenization stops before it starts.")
+	fmt.Println("  database.id_column names the record ID column (default id); several columns")
+	fmt.Println("  make a composite ID joined with database.id_separator (default |), hashed with")
+	fmt.Println("  SHA-256 when database.id_hash is set.")
+	fmt.Println()
+	fmt.Println("CSV DIALECTS:")
+	fmt.Println("  database.csv in -main-config describes CSV input that is not comma-separated")
+	fmt.Println("  UTF-8 with a header row: delimiter, quote, encoding (transcoded to UTF-8 as it")
+	fmt.Println("  is read) and header: false, with the columns named by database.csv.columns or")
+	fmt.Println("  column_1, column_2, ... The -csv-* flags override it. Byte order marks are")
+	fmt.Println("  dropped, and CRLF line endings are read like LF.")
+	fmt.Println()
+	fmt.Println("INPUT SANITIZATION:")
+	fmt.Println("  Field values are cleaned before they are q-grammed, so one malformed cell cannot")
+	fmt.Println("  fill a Bloom filter and match everything. Control and invisible formatting")
+	fmt.Println("  characters are stripped (tabs and line breaks become spaces; database.sanitize.")
+	fmt.Println("  strip_control: false keeps them), and values longer than -max-field-length are")
+	fmt.Println("  truncated or, with -on-too-long reject, leave their record out. -rejects lists")
+	fmt.Println("  each change by record, ID, field, problem and length, never the value itself.")
+	fmt.Println()
+	fmt.Println("PROVENANCE:")
+	fmt.Println("  -provenance writes each token's source_file (the input as given, or the database")
+	fmt.Println("  table or query), source_row (the record's 1-based position in it) and batch_id")
+	fmt.Println("  in CSV and JSON Lines token files. 'intersect -provenance' adds them for both")
+	fmt.Println("  records of every match, so a pair can be traced to its source rows in an audit.")
+	fmt.Println("  The columns stay local: pprl never sends them to the peer.")
+	fmt.Println()
+	fmt.Println("MULTI-VALUED FIELDS:")
+	fmt.Println("  Prior surnames, earlier addresses or several phone numbers can be given for one")
+	fmt.Println("  field: as a JSON array in JSON input (.json arrays of objects or .jsonl), or in")
+	fmt.Println("  CSV cells of database.multi_value_columns separated by database.multi_value_delimiter")
+	fmt.Println("  (default |). Every value is tokenized into the field, so a record matches on any")
+	fmt.Println("  of them; the first is the current value, used for blocking keys.")
+	fmt.Println()
+	fmt.Println("INCREMENTAL RUNS:")
+	fmt.Println("  With a watermark column (database.watermark_column, e.g. updated_at), the latest")
+	fmt.Println("  value seen is saved as the watermark in the format manifest. -since then tokenizes")
+	fmt.Println("  only records modified after a time (RFC 3339, YYYY-MM-DD[ HH:MM:SS] or Unix")
+	fmt.Println("  seconds) or after the watermark of last night's token file, and 'cohort-bridge")
+	fmt.Println("  delta' merges the matches of those records into the previous crosswalk. Records")
+	fmt.Println("  without a readable watermark are tokenized every run.")
+	fmt.Println()
+	fmt.Println("HL7v2 INPUT:")
+	fmt.Println("  ADT^A01 (admit) and ADT^A08 (update) messages are read from the PID segment:")
+	fmt.Println("  id (PID-3, MR preferred), first_name, middle_name, last_name, date_of_birth,")
+	fmt.Println("  gender, street, city, state, zip_code, phone and ssn. Fields are chosen by")
+	fmt.Println("  database.fields in the main config. Other message types are skipped (file)")
+	fmt.Println("  or rejected with an AR acknowledgment (MLLP). With -mllp, each message is")
+	fmt.Println("  acknowledged once its tokens are written to -output (requires -no-encryption).")
+	fmt.Println()
 	fmt.Println("EXAMPLES:")
 	fmt.Println("  # Interactive mode (prompts for all inputs)")
 	fmt.Println("  cohort-bridge tokenize")
@@ -726,9 +2191,28 @@ func showTokenizeHelp() {
 	fmt.Println("  cohort-bridge tokenize -input data.csv -output tokens.csv.enc -force")
 	fmt.Println("  cohort-bridge tokenize -database -main-config config.yaml -force")
 	fmt.Println()
+	fmt.Println("  # Pipe-delimited Latin-1 extract from a partner")
+	fmt.Println("  cohort-bridge tokenize -input extract.txt -output tokens.csv.enc -csv-delimiter '|' -csv-encoding latin1")
+	fmt.Println()
+	fmt.Println("  # Leave out records with a value over 100 characters and list them")
+	fmt.Println("  cohort-bridge tokenize -input data.csv -output tokens.csv.enc -max-field-length 100 -on-too-long reject -rejects rejects.csv")
+	fmt.Println()
+	fmt.Println("  # Load tokens straight into the research warehouse")
+	fmt.Println("  cohort-bridge tokenize -input data.csv -output-format postgres -no-encryption -main-config config.yaml")
+	fmt.Println()
 	fmt.Println("  # Database mode")
 	fmt.Println("  cohort-bridge tokenize -database -main-config config.yaml")
 	fmt.Println()
+	fmt.Println("  # HL7v2 ADT messages from a file or an interface engine")
+	fmt.Println("  cohort-bridge tokenize -input adt.hl7 -output tokens.csv.enc -main-config config.yaml")
+	fmt.Println("  cohort-bridge tokenize -mllp :2575 -output tokens.csv -no-encryption -main-config config.yaml")
+	fmt.Println()
+	fmt.Println("  # Gzipped JSON Lines for streaming consumers")
+	fmt.Println("  cohort-bridge tokenize -input data.csv -output tokens.jsonl.gz -output-format jsonl -no-encryption")
+	fmt.Println()
+	fmt.Println("  # Binary token store for very large intersections")
+	fmt.Println("  cohort-bridge tokenize -input data.csv -output tokens.cbbf -output-format cbbf -no-encryption")
+	fmt.Println()
 	fmt.Println("  # Disable encryption (not recommended)")
 	fmt.Println("  cohort-bridge tokenize -input data.csv -no-encryption")
 	fmt.Println()
@@ -741,80 +2225,33 @@ func showTokenizeHelp() {
 // Helper function for default indicators
 // ifDefault function moved to utils.go
 
-// DecryptFile decrypts a file encrypted with encryptFile
-func DecryptFile(inputFile, outputFile, keyHex string) error {
-	// Decode hex key
-	key, err := hex.DecodeString(keyHex)
-	if err != nil {
-		return fmt.Errorf("invalid encryption key format: %w", err)
-	}
-
-	if len(key) != 32 {
-		return fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
-	}
-
-	// Read encrypted file
-	ciphertext, err := os.ReadFile(inputFile)
-	if err != nil {
-		return fmt.Errorf("failed to read encrypted file: %w", err)
-	}
-
-	// Create AES cipher
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return fmt.Errorf("failed to create AES cipher: %w", err)
-	}
-
-	// Create GCM mode
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return fmt.Errorf("failed to create GCM: %w", err)
-	}
-
-	// Extract nonce and ciphertext
-	if len(ciphertext) < gcm.NonceSize() {
-		return fmt.Errorf("ciphertext too short")
-	}
-
-	nonce := ciphertext[:gcm.NonceSize()]
-	ciphertext = ciphertext[gcm.NonceSize():]
-
-	// Decrypt and verify
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
-	if err != nil {
-		return fmt.Errorf("failed to decrypt file (wrong key or corrupted data): %w", err)
-	}
-
-	// Write decrypted file
-	if err := os.WriteFile(outputFile, plaintext, 0600); err != nil {
-		return fmt.Errorf("failed to write decrypted file: %w", err)
-	}
-
-	return nil
+// decryptFlags are the flags of the decrypt command
+type decryptFlags struct {
+	fs          *flag.FlagSet
+	inputFile   *string
+	outputFile  *string
+	keyFile     *string
+	keyHex      *string
+	configFile  *string
+	interactive *bool
+	force       *bool
+	help        *bool
 }
 
-// LoadKeyFromFile loads an encryption key from a key file
-func LoadKeyFromFile(keyFile string) (string, error) {
-	data, err := os.ReadFile(keyFile)
-	if err != nil {
-		return "", fmt.Errorf("failed to read key file: %w", err)
+// newDecryptFlags defines the flags of the decrypt command
+func newDecryptFlags() *decryptFlags {
+	fs := newFlagSet("decrypt")
+	return &decryptFlags{
+		fs:          fs,
+		inputFile:   fs.String("input", "", "Encrypted input file"),
+		outputFile:  fs.String("output", "", "Decrypted output file"),
+		keyFile:     fs.String("key", "", "Path to encryption key file"),
+		keyHex:      fs.String("key-hex", "", "Encryption key as hex string"),
+		configFile:  fs.String("config", "config.yaml", "Configuration file with the keys section"),
+		interactive: fs.Bool("interactive", false, "Force interactive mode"),
+		force:       fs.Bool("force", false, "Skip confirmation prompts"),
+		help:        fs.Bool("help", false, "Show help message"),
 	}
-
-	// Extract hex key from file (skip comments)
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line != "" && !strings.HasPrefix(line, "#") {
-			// Validate hex format
-			if len(line) == 64 {
-				if _, err := hex.DecodeString(line); err == nil {
-					return line, nil
-				}
-			}
-		}
-	}
-
-	return "", fmt.Errorf("no valid encryption key found in file")
 }
 
 func runDecryptCommand(args []string) {
@@ -823,46 +2260,65 @@ func runDecryptCommand(args []string) {
 	fmt.Println("Decrypt encrypted tokenized files")
 	fmt.Println()
 
-	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
-	var (
-		inputFile   = fs.String("input", "", "Encrypted input file")
-		outputFile  = fs.String("output", "", "Decrypted output file")
-		keyFile     = fs.String("key", "", "Path to encryption key file")
-		keyHex      = fs.String("key-hex", "", "Encryption key as hex string")
-		interactive = fs.Bool("interactive", false, "Force interactive mode")
-		force       = fs.Bool("force", false, "Skip confirmation prompts")
-		help        = fs.Bool("help", false, "Show help message")
-	)
-	fs.Parse(args)
-
-	if *help {
+	f := newDecryptFlags()
+	parseFlags(f.fs, args)
+
+	if *f.help {
 		showDecryptHelp()
 		return
 	}
 
+	// Use the key file written next to the data by tokenize, if present
+	if *f.keyFile == "" && *f.keyHex == "" && *f.inputFile != "" {
+		if sibling := generateKeyFileName(*f.inputFile); sibling != *f.inputFile {
+			if _, err := os.Stat(sibling); err == nil {
+				*f.keyFile = sibling
+			}
+		}
+	}
+
+	// Keys named in the file header can be found without an explicit key
+	cfg := loadMainConfig(*f.configFile)
+	keySource, err := keySourceFromConfig(cfg)
+	if err != nil {
+		fatalf(CryptoError, "ERROR: %v", err)
+	}
+	needsKey := *f.keyFile == "" && *f.keyHex == "" && !canResolveKey(*f.inputFile, keySource)
+
 	// If missing required parameters or interactive mode requested, go interactive
-	if *inputFile == "" || (*keyFile == "" && *keyHex == "") || *outputFile == "" || *interactive {
+	if *f.inputFile == "" || needsKey || *f.outputFile == "" || *f.interactive {
+		var missing []string
+		if *f.inputFile == "" {
+			missing = append(missing, "-input")
+		}
+		if needsKey {
+			missing = append(missing, "-key (or -key-hex)")
+		}
+		if *f.outputFile == "" {
+			missing = append(missing, "-output")
+		}
+		requirePrompt("decrypt", missing...)
+
 		fmt.Println("Interactive Decryption Setup")
 		fmt.Println("Let's configure your decryption parameters...")
 
 		// Get input file
-		if *inputFile == "" {
+		if *f.inputFile == "" {
 			var err error
-			*inputFile, err = selectDataFile("Select Encrypted File", "out", []string{".enc", ".encrypted"})
+			*f.inputFile, err = selectDataFile("Select Encrypted File", "out", []string{".enc", ".encrypted"})
 			if err != nil {
-				fmt.Printf("ERROR: Error selecting input file: %v\n", err)
-				os.Exit(1)
+				fatalf(UsageError, "ERROR: Error selecting input file: %v", err)
 			}
 		}
 
 		// Get output file
-		if *outputFile == "" {
-			defaultOutput := generateDecryptOutputName(*inputFile)
-			*outputFile = promptForInput("Output file for decrypted data", defaultOutput)
+		if *f.outputFile == "" {
+			defaultOutput := generateDecryptOutputName(*f.inputFile)
+			*f.outputFile = promptForInput("Output file for decrypted data", defaultOutput)
 		}
 
 		// Get encryption key
-		if *keyFile == "" && *keyHex == "" {
+		if *f.keyFile == "" && *f.keyHex == "" && !canResolveKey(*f.inputFile, keySource) {
 			keyChoice := promptForChoice("How would you like to provide the encryption key?", []string{
 				"Key file - Load from .key file",
 				"Manual entry - Enter hex key directly",
@@ -871,54 +2327,54 @@ func runDecryptCommand(args []string) {
 			if keyChoice == 0 {
 				// Key file
 				var err error
-				*keyFile, err = selectDataFile("Select Key File", "out", []string{".key"})
+				*f.keyFile, err = selectDataFile("Select Key File", "out", []string{".key"})
 				if err != nil {
-					fmt.Printf("ERROR: Error selecting key file: %v\n", err)
-					os.Exit(1)
+					fatalf(UsageError, "ERROR: Error selecting key file: %v", err)
 				}
 			} else {
 				// Manual entry
-				*keyHex = promptForInput("Enter 64-character hex encryption key", "")
-				if len(*keyHex) != 64 {
-					fmt.Printf("ERROR: Invalid key length. Expected 64 characters, got %d\n", len(*keyHex))
-					os.Exit(1)
+				*f.keyHex = promptForInput("Enter 64-character hex encryption key", "")
+				if len(*f.keyHex) != 64 {
+					fatalf(CryptoError, "ERROR: Invalid key length. Expected 64 characters, got %d", len(*f.keyHex))
 				}
 			}
 		}
 	}
 
-	// Load key from file if specified
-	var finalKeyHex string
-	if *keyFile != "" {
-		var err error
-		finalKeyHex, err = LoadKeyFromFile(*keyFile)
+	// Explicit keys take precedence over the configured key sources
+	var explicit []*keys.Key
+	if *f.keyFile != "" {
+		key, err := keys.ReadKeyFile(*f.keyFile)
 		if err != nil {
-			fmt.Printf("ERROR: Failed to load key from file: %v\n", err)
-			os.Exit(1)
+			fatalf(CryptoError, "ERROR: Failed to load key from file: %v", err)
 		}
-	} else {
-		finalKeyHex = *keyHex
+		explicit = append(explicit, key)
+	} else if *f.keyHex != "" {
+		key, err := keys.FromHex(*f.keyHex)
+		if err != nil {
+			fatalf(CryptoError, "ERROR: Invalid key format: %v", err)
+		}
+		explicit = append(explicit, key)
 	}
-
-	// Validate key format
-	if len(finalKeyHex) != 64 {
-		fmt.Printf("ERROR: Invalid key format. Expected 64 hex characters, got %d\n", len(finalKeyHex))
-		os.Exit(1)
+	if len(explicit) > 0 {
+		keySource = keys.Chain{&keys.StaticSource{Keys: explicit}, keySource}
 	}
 
 	// Show configuration summary
 	fmt.Println("Decryption Configuration:")
-	fmt.Printf(" Input File: %s\n", *inputFile)
-	fmt.Printf(" Output File: %s\n", *outputFile)
-	if *keyFile != "" {
-		fmt.Printf("  Key Source: File (%s)\n", *keyFile)
-	} else {
+	fmt.Printf(" Input File: %s\n", *f.inputFile)
+	fmt.Printf(" Output File: %s\n", *f.outputFile)
+	if *f.keyFile != "" {
+		fmt.Printf("  Key Source: File (%s)\n", *f.keyFile)
+	} else if *f.keyHex != "" {
 		fmt.Printf("  Key Source: Manual entry\n")
+	} else {
+		fmt.Printf("  Key Source: Resolved from file header\n")
 	}
 	fmt.Println()
 
 	// Confirm before proceeding (unless force flag is set)
-	if !*force {
+	if !skipConfirmation("decrypt", *f.force, "-force") {
 		confirmChoice := promptForChoice("Ready to decrypt file?", []string{
 			"Yes, decrypt now",
 			"Change configuration",
@@ -932,7 +2388,7 @@ func runDecryptCommand(args []string) {
 
 		if confirmChoice == 1 {
 			// Restart configuration
-			fmt.Println("\nRestarting configuration...\n")
+			fmt.Print("\nRestarting configuration...\n\n")
 			newArgs := append([]string{"-interactive"}, args...)
 			runDecryptCommand(newArgs)
 			return
@@ -942,24 +2398,45 @@ func runDecryptCommand(args []string) {
 	}
 
 	// Validate input file exists
-	if _, err := os.Stat(*inputFile); os.IsNotExist(err) {
-		fmt.Printf("Input file not found: %s\n", *inputFile)
-		os.Exit(1)
+	if _, err := os.Stat(*f.inputFile); os.IsNotExist(err) {
+		fatalf(DataError, "Input file not found: %s", *f.inputFile)
 	}
 
 	// Run decryption
 	fmt.Println("Decrypting file...")
 
-	if err := DecryptFile(*inputFile, *outputFile, finalKeyHex); err != nil {
-		fmt.Printf("ERROR: Decryption failed: %v\n", err)
-		os.Exit(1)
+	if _, err := keys.DecryptFile(*f.inputFile, *f.outputFile, keySource); err != nil {
+		fatalf(CryptoError, "ERROR: Decryption failed: %v", err)
+	}
+	// The decrypted copy keeps the format manifest of the encrypted file
+	if format, err := db.ReadTokenFormat(*f.inputFile); err == nil && format != nil {
+		format.Encrypted = false
+		if err := db.WriteTokenFormat(*f.outputFile, format); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
 	}
 
 	fmt.Printf("\nDecryption completed successfully!\n")
-	fmt.Printf("Decrypted data saved to: %s\n", *outputFile)
+	fmt.Printf("Decrypted data saved to: %s\n", *f.outputFile)
 	fmt.Printf("You can now view the tokenized data in plaintext format\n")
 }
 
+// canResolveKey reports whether the key for an encrypted file can be found without asking the user
+func canResolveKey(inputFile string, keySource keys.Source) bool {
+	if inputFile == "" {
+		return false
+	}
+	header, err := keys.ReadHeader(inputFile)
+	if err != nil || header == nil {
+		return false
+	}
+	if header.WrappedKey != "" {
+		return true
+	}
+	_, err = keySource.Lookup(header.KeyID)
+	return err == nil
+}
+
 func generateDecryptOutputName(inputFile string) string {
 	// Remove .enc extension if present
 	if strings.HasSuffix(inputFile, ".enc") {
@@ -1032,15 +2509,8 @@ func parseFieldsWithNormalization(fields []string) ([]string, map[string]crypto.
 				fieldNames = append(fieldNames, fieldName)
 
 				// Add normalization method if supported
-				switch method {
-				case "name":
-					normalizationConfig[fieldName] = crypto.NormName
-				case "date":
-					normalizationConfig[fieldName] = crypto.NormDate
-				case "gender":
-					normalizationConfig[fieldName] = crypto.NormGender
-				case "zip":
-					normalizationConfig[fieldName] = crypto.NormZip
+				if normalization, ok := crypto.ParseNormalizationMethod(method); ok {
+					normalizationConfig[fieldName] = normalization
 				}
 			} else {
 				// Invalid format, just use as field name
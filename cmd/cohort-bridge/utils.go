@@ -5,14 +5,116 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/chzyer/readline"
 	"github.com/manifoldco/promptui"
 )
 
+// assumeYes is set by the global -yes (or -non-interactive) flag: confirmation prompts are
+// answered yes, and anything else that would be prompted for must be given as a flag
+var assumeYes bool
+
+// parseGlobalFlags removes the global -yes, -non-interactive, -log-format and -porcelain flags
+// from args, wherever they appear before a "--", and sets assumeYes, logFormat and porcelainFormat
+func parseGlobalFlags(args []string) []string {
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") {
+			rest = append(rest, arg)
+			continue
+		}
+		switch name {
+		case "yes", "non-interactive":
+			enabled := true
+			if hasValue {
+				parsed, err := strconv.ParseBool(value)
+				if err != nil {
+					fatalf(UsageError, "Error: invalid value %q for -%s", value, name)
+				}
+				enabled = parsed
+			}
+			assumeYes = enabled
+		case "log-format":
+			if !hasValue {
+				if i+1 == len(args) {
+					fatalf(UsageError, "Error: -log-format needs a value (text or json)")
+				}
+				i++
+				value = args[i]
+			}
+			if value != "text" && value != "json" {
+				fatalf(UsageError, "Error: invalid value %q for -log-format (use text or json)", value)
+			}
+			logFormat = value
+		case "porcelain":
+			switch value {
+			case "", porcelainKV:
+				porcelainFormat = porcelainKV
+			case porcelainJSON:
+				porcelainFormat = porcelainJSON
+			default:
+				fatalf(UsageError, "Error: invalid value %q for -porcelain (use kv or json)", value)
+			}
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return rest
+}
+
+// stdinIsTerminal reports whether stdin is a terminal; under cron, CI or a pipe it is not, and a
+// prompt would wait forever or read the wrong input
+func stdinIsTerminal() bool {
+	return readline.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// canPrompt reports whether the user can be asked for input
+func canPrompt() bool {
+	return !assumeYes && stdinIsTerminal()
+}
+
+// requirePrompt exits with the flags that would supply the missing input when command needs to
+// prompt but nobody can answer
+func requirePrompt(command string, missing ...string) {
+	if canPrompt() {
+		return
+	}
+	reason := "stdin is not a terminal"
+	if assumeYes {
+		reason = "running with -yes"
+	}
+	message := fmt.Sprintf("ERROR: %s needs input but cannot prompt (%s)", command, reason)
+	if len(missing) > 0 {
+		message += fmt.Sprintf("\nMissing flags: %s", strings.Join(missing, ", "))
+	}
+	message += fmt.Sprintf("\nRun 'cohort-bridge %s -help' for usage", command)
+	fatalf(UsageError, "%s", message)
+}
+
+// skipConfirmation reports whether command starts without asking for confirmation, which it does
+// with force or -yes. Otherwise a session that cannot prompt exits, naming the flags to pass.
+func skipConfirmation(command string, force bool, flags ...string) bool {
+	if force || assumeYes {
+		return true
+	}
+	requirePrompt(command, strings.Join(append(flags, "-yes"), " or "))
+	return false
+}
+
 // promptForInput reads text input from user with optional default value
 func promptForInput(message, defaultValue string) string {
+	if !canPrompt() {
+		exitCannotPrompt(message)
+	}
 	time.Sleep(time.Millisecond)
 	if defaultValue != "" {
 		fmt.Printf("%s (default: %s): ", message, defaultValue)
@@ -36,6 +138,9 @@ func promptForInput(message, defaultValue string) string {
 
 // promptForChoice uses promptui for menu selection with arrow keys
 func promptForChoice(message string, options []string) int {
+	if !canPrompt() {
+		exitCannotPrompt(message)
+	}
 	prompt := promptui.Select{
 		Label: message,
 		Items: options,
@@ -50,13 +155,18 @@ func promptForChoice(message string, options []string) int {
 
 	index, _, err := prompt.Run()
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+		fatalf(InternalError, "Error: %v", err)
 	}
 
 	return index
 }
 
+// exitCannotPrompt exits when a prompt is reached that nobody can answer; the subcommands check
+// their flags with requirePrompt first, so this only guards prompts they do not anticipate
+func exitCannotPrompt(message string) {
+	fatalf(UsageError, "ERROR: cannot ask %q: stdin is not a terminal or -yes was given; pass the value as a flag", strings.TrimSuffix(message, ":"))
+}
+
 // selectDataFile helps user select a data file from a directory with specific extensions
 func selectDataFile(label, context string, extensions []string) (string, error) {
 	// Look for files in data directory and current directory
@@ -237,9 +347,12 @@ func copyToAbsolutePath(srcFile, dstPath string) error {
 
 // confirmStep prompts user for confirmation unless force mode is enabled
 func confirmStep(message string, force bool) bool {
-	if force {
+	if force || assumeYes {
 		return true
 	}
+	if !canPrompt() {
+		fatalf(UsageError, "ERROR: cannot confirm %q: stdin is not a terminal; pass -force or -yes", message)
+	}
 
 	choice := promptForChoice(message, []string{
 		"Yes, continue",
@@ -271,6 +384,17 @@ func generateOutputName(prefix string, inputs ...string) string {
 	return filepath.Join("out", filename+".csv")
 }
 
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // isFileWithExtensions checks if file has one of the specified extensions
 func isFileWithExtensions(filename string, extensions []string) bool {
 	if len(extensions) == 0 {
@@ -4,13 +4,16 @@ import (
 	"encoding/csv"
 	"flag"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/crypto"
 	"github.com/auroradata-ai/cohort-bridge/internal/db"
 	"github.com/auroradata-ai/cohort-bridge/internal/match"
 	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
@@ -28,6 +31,7 @@ type ValidationResult struct {
 	MatchedPairs   []MatchPair
 	MissedMatches  []string
 	FalseMatches   []MatchPair
+	Clusters       match.ClusterMetrics // Entity-level comparison of the linked clusters
 }
 
 // MatchPair represents a matched pair (no scores in zero-knowledge validation)
@@ -36,11 +40,69 @@ type MatchPair struct {
 	ID2 string
 }
 
+// groundTruth is the set of true (dataset 1, dataset 2) pairs. A record may appear in several
+// pairs, on either side, when a dataset holds duplicates of one patient.
+type groundTruth map[MatchPair]bool
+
+// has reports whether id1 and id2 are a true match
+func (g groundTruth) has(id1, id2 string) bool {
+	return g[MatchPair{ID1: id1, ID2: id2}]
+}
+
+// sorted returns the true pairs ordered by ID1, then ID2
+func (g groundTruth) sorted() []MatchPair {
+	pairs := make([]MatchPair, 0, len(g))
+	for pair := range g {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].ID1 != pairs[j].ID1 {
+			return pairs[i].ID1 < pairs[j].ID1
+		}
+		return pairs[i].ID2 < pairs[j].ID2
+	})
+	return pairs
+}
+
+// multiplicity counts the records of each dataset with more than one true match
+func (g groundTruth) multiplicity() (many1, many2 int) {
+	count1, count2 := make(map[string]int), make(map[string]int)
+	for pair := range g {
+		count1[pair.ID1]++
+		count2[pair.ID2]++
+	}
+	for _, n := range count1 {
+		if n > 1 {
+			many1++
+		}
+	}
+	for _, n := range count2 {
+		if n > 1 {
+			many2++
+		}
+	}
+	return many1, many2
+}
+
+// keyed returns the ground truth with its IDs, written as the joined values of each dataset's
+// ID columns, turned into record IDs (hashed when database.id_hash is set)
+func (g groundTruth) keyed(ids1, ids2 *pprl.IDColumns) groundTruth {
+	if !ids1.Hashed() && !ids2.Hashed() {
+		return g
+	}
+	keyed := make(groundTruth, len(g))
+	for pair := range g {
+		keyed[MatchPair{ID1: ids1.Key(pair.ID1), ID2: ids2.Key(pair.ID2)}] = true
+	}
+	return keyed
+}
+
 // TokenRecord represents a single tokenized record (copied from pprl.go)
 type TokenRecordValidation struct {
 	ID          string `json:"id"`
-	BloomFilter string `json:"bloom_filter"` // base64 encoded
-	MinHash     string `json:"minhash"`      // base64 encoded
+	BloomFilter string `json:"bloom_filter"`       // base64 encoded
+	MinHash     string `json:"minhash"`            // base64 encoded
+	Blocking    string `json:"blocking,omitempty"` // space-separated hashed blocking keys
 }
 
 // TokenData represents the tokenized data to be exchanged (copied from pprl.go)
@@ -48,78 +110,137 @@ type TokenDataValidation struct {
 	Records map[string]TokenRecordValidation `json:"records"`
 }
 
+// validateFlags are the flags of the validate command
+type validateFlags struct {
+	fs              *flag.FlagSet
+	config1File     *string
+	config2File     *string
+	groundTruthFile *string
+	outputFile      *string
+	force           *bool
+	verbose         *bool
+	calibrateFile   *string
+	calibrateMethod *string
+	probThreshold   *float64
+	tune            *bool
+	hammingGrid     *string
+	jaccardGrid     *string
+	tuneCriterion   *string
+	minPrecision    *float64
+	tuneConfig      *string
+	curvesFile      *string
+	allowDuplicates *bool
+	interactive     *bool
+	help            *bool
+	thresholds      *thresholdFlags
+	histogramFlags  *histogramFlags
+}
+
+// newValidateFlags defines the flags of the validate command
+func newValidateFlags() *validateFlags {
+	fs := newFlagSet("validate")
+	f := &validateFlags{
+		fs:              fs,
+		config1File:     fs.String("config1", "", "Configuration file for dataset 1 (Party A)"),
+		config2File:     fs.String("config2", "", "Configuration file for dataset 2 (Party B)"),
+		groundTruthFile: fs.String("ground-truth", "", "Ground truth file with expected matches; several comma-separated files or globs are evaluated each and pooled"),
+		outputFile:      fs.String("output", "", "Output CSV file for validation report"),
+		force:           fs.Bool("force", false, "Skip confirmation prompts and run automatically"),
+		verbose:         fs.Bool("verbose", false, "Verbose output with detailed analysis"),
+		calibrateFile:   fs.String("calibrate", "", "Train a match probability calibration against the ground truth and save it here"),
+		calibrateMethod: fs.String("calibration-method", match.CalibrationMethodPlatt, "Model -calibrate trains: platt (fit to the ground truth) or fellegi-sunter (EM, no labels)"),
+		probThreshold:   fs.Float64("probability-threshold", 0, "Match on calibrated probability instead of distance thresholds"),
+		tune:            fs.Bool("tune", false, "Sweep threshold grids against ground truth and recommend thresholds"),
+		hammingGrid:     fs.String("hamming-grid", "0:200:10", "Hamming thresholds to sweep with -tune (start:end:step)"),
+		jaccardGrid:     fs.String("jaccard-grid", "0.1:0.9:0.05", "Jaccard thresholds to sweep with -tune (start:end:step)"),
+		tuneCriterion:   fs.String("tune-criterion", "f1", "Operating point criterion for -tune: f1 or precision"),
+		minPrecision:    fs.Float64("min-precision", 0.95, "Minimum precision for -tune-criterion precision"),
+		tuneConfig:      fs.String("tune-config", "", "Where -tune writes the recommended config snippet"),
+		curvesFile:      fs.String("curves", "", "Export ROC and precision-recall curve points (.csv or .json)"),
+		allowDuplicates: fs.Bool("allow-duplicates", false, "Allow 1:many matching, for ground truth with several matches per record"),
+		interactive:     fs.Bool("interactive", false, "Force interactive mode"),
+		help:            fs.Bool("help", false, "Show help message"),
+		thresholds:      addThresholdFlags(fs),
+		histogramFlags:  addHistogramFlags(fs),
+	}
+	fs.UintVar(&f.thresholds.hamming, "match-threshold", 0, "Alias of -hamming-threshold")
+	return f
+}
+
 func runValidateCommand(args []string) {
 	fmt.Println("CohortBridge Validation Tool")
 	fmt.Println("============================")
 	fmt.Println("End-to-end validation against ground truth")
 	fmt.Println()
-	fs := flag.NewFlagSet("validate", flag.ExitOnError)
-
-	var (
-		config1File      = fs.String("config1", "", "Configuration file for dataset 1 (Party A)")
-		config2File      = fs.String("config2", "", "Configuration file for dataset 2 (Party B)")
-		groundTruthFile  = fs.String("ground-truth", "", "Ground truth file with expected matches")
-		outputFile       = fs.String("output", "", "Output CSV file for validation report")
-		matchThreshold   = fs.Uint("match-threshold", 20, "Hamming distance threshold for matches (default: 20)")
-		jaccardThreshold = fs.Float64("jaccard-threshold", 0.32, "Minimum Jaccard similarity for matches (default: 0.32)")
-		force            = fs.Bool("force", false, "Skip confirmation prompts and run automatically")
-		verbose          = fs.Bool("verbose", false, "Verbose output with detailed analysis")
-		interactive      = fs.Bool("interactive", false, "Force interactive mode")
-		help             = fs.Bool("help", false, "Show help message")
-	)
-	fs.Parse(args)
-
-	if *help {
+	f := newValidateFlags()
+	parseFlags(f.fs, args)
+
+	if *f.help {
 		showValidateHelp()
 		return
 	}
+	histogram, err := f.histogramFlags.histogram()
+	if err != nil {
+		fatalf(UsageError, "Error: %v", err)
+	}
+	if *f.calibrateMethod != match.CalibrationMethodPlatt && *f.calibrateMethod != match.CalibrationMethodFellegiSunter {
+		fatalf(UsageError, "Error: -calibration-method must be %s or %s", match.CalibrationMethodPlatt, match.CalibrationMethodFellegiSunter)
+	}
 
 	// If missing required parameters or interactive mode requested, go interactive
-	if (*config1File == "" || *config2File == "" || *groundTruthFile == "" || *outputFile == "") || *interactive {
+	if (*f.config1File == "" || *f.config2File == "" || *f.groundTruthFile == "" || *f.outputFile == "") || *f.interactive {
+		var missing []string
+		for _, required := range []struct{ flag, value string }{
+			{"-config1", *f.config1File}, {"-config2", *f.config2File},
+			{"-ground-truth", *f.groundTruthFile}, {"-output", *f.outputFile},
+		} {
+			if required.value == "" {
+				missing = append(missing, required.flag)
+			}
+		}
+		requirePrompt("validate", missing...)
+
 		fmt.Println("Interactive Validation Setup")
 		fmt.Println("Configure your validation parameters...")
 
 		// Get first configuration file
-		if *config1File == "" {
+		if *f.config1File == "" {
 			var err error
-			*config1File, err = selectConfigFile("Select Configuration File for Dataset 1 (Party A)")
+			*f.config1File, err = selectConfigFile("Select Configuration File for Dataset 1 (Party A)")
 			if err != nil {
-				fmt.Printf("Error selecting config1 file: %v\n", err)
-				os.Exit(1)
+				fatalf(UsageError, "Error selecting config1 file: %v", err)
 			}
 		}
 
 		// Get second configuration file
-		if *config2File == "" {
+		if *f.config2File == "" {
 			var err error
-			*config2File, err = selectConfigFile("Select Configuration File for Dataset 2 (Party B)")
+			*f.config2File, err = selectConfigFile("Select Configuration File for Dataset 2 (Party B)")
 			if err != nil {
-				fmt.Printf("Error selecting config2 file: %v\n", err)
-				os.Exit(1)
+				fatalf(UsageError, "Error selecting config2 file: %v", err)
 			}
 		}
 
 		// Get ground truth file from data directory
-		if *groundTruthFile == "" {
+		if *f.groundTruthFile == "" {
 			var err error
-			*groundTruthFile, err = selectGroundTruthFile()
+			*f.groundTruthFile, err = selectGroundTruthFile()
 			if err != nil {
-				fmt.Printf("Error selecting ground truth file: %v\n", err)
-				os.Exit(1)
+				fatalf(UsageError, "Error selecting ground truth file: %v", err)
 			}
 		}
 
 		// Get output file with smart default
-		if *outputFile == "" {
-			defaultOutput := generateOutputName("validation", *config1File, *config2File)
-			*outputFile = promptForInput("Output CSV file for validation report", defaultOutput)
+		if *f.outputFile == "" {
+			defaultOutput := generateOutputName("validation", *f.config1File, *f.config2File)
+			*f.outputFile = promptForInput("Output CSV file for validation report", defaultOutput)
 		}
 
 		// Configure match threshold
 		fmt.Println("\nMatching Configuration")
 		fmt.Println("Configuring thresholds...")
 		thresholdChoice := promptForChoice("Select Hamming distance threshold:", []string{
-			"20 - Default (recommended for good matches)",
+			fmt.Sprintf("Config - matching.hamming_threshold of the configs (default %d)", config.DefaultHammingThreshold),
 			"10 - Very strict matching",
 			"30 - More lenient matching",
 			"Custom - Enter custom value",
@@ -127,23 +248,24 @@ func runValidateCommand(args []string) {
 
 		switch thresholdChoice {
 		case 0:
-			*matchThreshold = 20
+			f.thresholds.hamming = 0
 		case 1:
-			*matchThreshold = 10
+			f.thresholds.hamming = 10
 		case 2:
-			*matchThreshold = 30
+			f.thresholds.hamming = 30
 		case 3:
-			customResult := promptForInput("Enter custom Hamming distance threshold (0-100)", "20")
-			if val, err := strconv.ParseUint(customResult, 10, 32); err == nil && val <= 100 {
-				*matchThreshold = uint(val)
+			defaultHamming := strconv.FormatUint(uint64(config.DefaultHammingThreshold), 10)
+			customResult := promptForInput("Enter custom Hamming distance threshold (1-100)", defaultHamming)
+			if val, err := strconv.ParseUint(customResult, 10, 32); err == nil && val > 0 && val <= 100 {
+				f.thresholds.hamming = uint(val)
 			} else {
-				fmt.Println("Invalid threshold, using default: 20")
-				*matchThreshold = 20
+				fmt.Println("Invalid threshold, using the configs' threshold")
+				f.thresholds.hamming = 0
 			}
 		}
 		// Configure Jaccard threshold
 		jaccardChoice := promptForChoice("Select Jaccard similarity threshold:", []string{
-			"0.32 - Default (balanced matching)",
+			fmt.Sprintf("Config - matching.jaccard_threshold of the configs (default %g)", config.DefaultJaccardThreshold),
 			"0.8 - High similarity required",
 			"0.3 - More lenient similarity",
 			"Custom - Enter custom value",
@@ -151,18 +273,19 @@ func runValidateCommand(args []string) {
 
 		switch jaccardChoice {
 		case 0:
-			*jaccardThreshold = 0.32
+			f.thresholds.jaccard = 0
 		case 1:
-			*jaccardThreshold = 0.8
+			f.thresholds.jaccard = 0.8
 		case 2:
-			*jaccardThreshold = 0.3
+			f.thresholds.jaccard = 0.3
 		case 3:
-			customJaccardResult := promptForInput("Enter custom Jaccard similarity threshold (0.0-1.0)", "0.32")
-			if val, err := strconv.ParseFloat(customJaccardResult, 64); err == nil && val >= 0.0 && val <= 1.0 {
-				*jaccardThreshold = val
+			defaultJaccard := strconv.FormatFloat(config.DefaultJaccardThreshold, 'g', -1, 64)
+			customJaccardResult := promptForInput("Enter custom Jaccard similarity threshold (0.0-1.0)", defaultJaccard)
+			if val, err := strconv.ParseFloat(customJaccardResult, 64); err == nil && val > 0.0 && val <= 1.0 {
+				f.thresholds.jaccard = val
 			} else {
-				fmt.Println("Invalid Jaccard threshold, using default: 0.32")
-				*jaccardThreshold = 0.32
+				fmt.Println("Invalid Jaccard threshold, using the configs' threshold")
+				f.thresholds.jaccard = 0
 			}
 		}
 
@@ -171,25 +294,56 @@ func runValidateCommand(args []string) {
 			"Standard - Basic metrics and summary",
 			"Verbose - Detailed analysis and breakdown",
 		})
-		*verbose = (verboseChoice == 1)
+		*f.verbose = (verboseChoice == 1)
 
 		fmt.Println()
 	}
 
 	// Default output file if not specified
-	if *outputFile == "" {
-		*outputFile = generateOutputName("validation", *config1File, *config2File)
+	if *f.outputFile == "" {
+		*f.outputFile = generateOutputName("validation", *f.config1File, *f.config2File)
 	}
 
 	// Show configuration summary
 	fmt.Println("Validation Configuration:")
-	fmt.Printf("  Config 1 (Party A): %s\n", *config1File)
-	fmt.Printf("  Config 2 (Party B): %s\n", *config2File)
-	fmt.Printf("  Ground Truth: %s\n", *groundTruthFile)
-	fmt.Printf("  Output Report: %s\n", *outputFile)
-	fmt.Printf("  Hamming Threshold: %d\n", *matchThreshold)
-	fmt.Printf("  Jaccard Threshold: %.3f\n", *jaccardThreshold)
-	if *verbose {
+	fmt.Printf("  Config 1 (Party A): %s\n", *f.config1File)
+	fmt.Printf("  Config 2 (Party B): %s\n", *f.config2File)
+	fmt.Printf("  Ground Truth: %s\n", *f.groundTruthFile)
+	fmt.Printf("  Output Report: %s\n", *f.outputFile)
+	if f.thresholds.hamming > 0 {
+		fmt.Printf("  Hamming Threshold: %d\n", f.thresholds.hamming)
+	} else {
+		fmt.Printf("  Hamming Threshold: from the configs\n")
+	}
+	if f.thresholds.jaccard > 0 {
+		fmt.Printf("  Jaccard Threshold: %.3f\n", f.thresholds.jaccard)
+	} else {
+		fmt.Printf("  Jaccard Threshold: from the configs\n")
+	}
+	if *f.calibrateFile != "" {
+		fmt.Printf("  Calibration Output: %s (%s)\n", *f.calibrateFile, *f.calibrateMethod)
+	}
+	if *f.probThreshold > 0 {
+		fmt.Printf("  Probability Threshold: %.3f\n", *f.probThreshold)
+	}
+	if *f.curvesFile != "" {
+		fmt.Printf("  Curves Output: %s\n", *f.curvesFile)
+	}
+	if histogram != nil {
+		fmt.Printf("  Score Histogram: %s\n", f.histogramFlags.file)
+	}
+	var tuning *tuneOptions
+	if *f.tune {
+		tuning = &tuneOptions{
+			HammingGrid:  *f.hammingGrid,
+			JaccardGrid:  *f.jaccardGrid,
+			Criterion:    *f.tuneCriterion,
+			MinPrecision: *f.minPrecision,
+			ConfigOut:    *f.tuneConfig,
+		}
+		fmt.Printf("  Mode: Threshold tuning (criterion: %s)\n", tuneCriterionLabel(tuning))
+	}
+	if *f.verbose {
 		fmt.Println("  Mode: Verbose")
 	} else {
 		fmt.Println("  Mode: Standard")
@@ -197,9 +351,9 @@ func runValidateCommand(args []string) {
 	fmt.Println()
 
 	// Confirm before proceeding (unless force flag is set)
-	if !*force {
+	if !*f.force && !assumeYes {
 		// Only show confirmation prompt if in interactive mode or missing required params
-		if *interactive || (*config1File == "" || *config2File == "" || *groundTruthFile == "") {
+		if *f.interactive || (*f.config1File == "" || *f.config2File == "" || *f.groundTruthFile == "") {
 			confirmChoice := promptForChoice("Ready to start validation?", []string{
 				"Yes, start validation",
 				"Change configuration",
@@ -224,24 +378,26 @@ func runValidateCommand(args []string) {
 	}
 
 	// Validate inputs before proceeding
-	if err := validateValidationInputs(*config1File, *config2File, *groundTruthFile); err != nil {
-		fmt.Printf("Validation error: %v\n", err)
-		os.Exit(1)
+	if err := validateValidationInputs(*f.config1File, *f.config2File); err != nil {
+		fatalf(ConfigError, "Validation error: %v", err)
+	}
+	groundTruthFiles, err := expandGroundTruthFiles(*f.groundTruthFile)
+	if err != nil {
+		fatalf(DataError, "Validation error: %v", err)
 	}
 
 	// Run validation
 	fmt.Println("Starting validation process...")
 
-	if err := performValidation(*config1File, *config2File, *groundTruthFile, *outputFile, *matchThreshold, *jaccardThreshold, *verbose); err != nil {
-		fmt.Printf("Validation failed: %v\n", err)
-		os.Exit(1)
+	if err := performValidation(*f.config1File, *f.config2File, groundTruthFiles, *f.outputFile, f.thresholds, *f.allowDuplicates, *f.calibrateFile, *f.calibrateMethod, *f.probThreshold, *f.curvesFile, histogram, f.histogramFlags.file, tuning, *f.verbose); err != nil {
+		fatalf(DataError, "Validation failed: %v", err)
 	}
 
 	fmt.Printf("\nValidation completed successfully!\n")
-	fmt.Printf("Report saved to: %s\n", *outputFile)
+	fmt.Printf("Report saved to: %s\n", *f.outputFile)
 }
 
-func validateValidationInputs(config1, config2, groundTruth string) error {
+func validateValidationInputs(config1, config2 string) error {
 	if _, err := os.Stat(config1); os.IsNotExist(err) {
 		return fmt.Errorf("config1 file not found: %s", config1)
 	}
@@ -250,14 +406,10 @@ func validateValidationInputs(config1, config2, groundTruth string) error {
 		return fmt.Errorf("config2 file not found: %s", config2)
 	}
 
-	if _, err := os.Stat(groundTruth); os.IsNotExist(err) {
-		return fmt.Errorf("ground truth file not found: %s", groundTruth)
-	}
-
 	return nil
 }
 
-func performValidation(config1, config2, groundTruth, outputFile string, matchThreshold uint, jaccardThreshold float64, verbose bool) error {
+func performValidation(config1, config2 string, groundTruthFiles []string, outputFile string, thresholds *thresholdFlags, allowDuplicates bool, calibrateFile, calibrationMethod string, probabilityThreshold float64, curvesFile string, histogram *match.ScoreHistogram, histogramFile string, tuning *tuneOptions, verbose bool) error {
 	// Ensure output directory exists
 	if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
 		return fmt.Errorf("failed to create output directory: %w", err)
@@ -278,35 +430,54 @@ func performValidation(config1, config2, groundTruth, outputFile string, matchTh
 		return fmt.Errorf("failed to load config2: %w", err)
 	}
 
-	// Use command-line thresholds for validation testing, fall back to config thresholds if not specified
-	configHammingThreshold := uint32(matchThreshold)
-	configJaccardThreshold := jaccardThreshold
-	// If command-line thresholds are default values, use config file thresholds instead
-	if matchThreshold == 20 && jaccardThreshold == 0.32 {
-		configHammingThreshold = cfg1.Matching.HammingThreshold
-		configJaccardThreshold = cfg1.Matching.JaccardThreshold
+	// Thresholds not given on the command line come from the configs; where they differ, the more
+	// permissive one is used for validation
+	resolved := thresholds.resolve(cfg1, cfg2)
+	configHammingThreshold, configJaccardThreshold := resolved.Hamming, resolved.Jaccard
+	fmt.Printf("  Using thresholds: %s\n", resolved)
 
-		// If config2 has different thresholds, use the more permissive one for validation
-		if cfg2.Matching.HammingThreshold > configHammingThreshold {
-			configHammingThreshold = cfg2.Matching.HammingThreshold
-		}
-		if cfg2.Matching.JaccardThreshold < configJaccardThreshold {
-			configJaccardThreshold = cfg2.Matching.JaccardThreshold
+	assignment := cfg1.Matching.Assignment
+	if err := crypto.ValidateAssignment(assignment); err != nil {
+		return err
+	}
+	fmt.Printf("  Using 1:1 assignment: %s\n", assignment)
+	comparatorName, err := resolveComparator("", cfg1)
+	if err != nil {
+		return err
+	}
+	var comparator match.Comparator
+	if comparatorName != "" {
+		if comparator, err = match.NewComparator(comparatorName); err != nil {
+			return err
 		}
+		fmt.Printf("  Using comparator: %s\n", comparatorName)
 	}
 
-	fmt.Printf("  Using thresholds: Hamming=%d, Jaccard=%.3f\n", configHammingThreshold, configJaccardThreshold)
-
 	fmt.Println("Loading ground truth data...")
-	fmt.Printf("  Ground truth: %s\n", groundTruth)
+	fmt.Printf("  Ground truth: %s\n", strings.Join(groundTruthFiles, ", "))
+
+	ids1, err := newIDColumns(cfg1)
+	if err != nil {
+		return fmt.Errorf("config1: %w", err)
+	}
+	ids2, err := newIDColumns(cfg2)
+	if err != nil {
+		return fmt.Errorf("config2: %w", err)
+	}
 
-	// Load ground truth
-	groundTruthMap, err := loadGroundTruth(groundTruth)
+	// Load ground truth; several files are pooled for matching and scored each afterwards
+	truthFiles, groundTruthMap, err := loadGroundTruthFiles(groundTruthFiles, ids1, ids2)
 	if err != nil {
 		return fmt.Errorf("failed to load ground truth: %w", err)
 	}
 
 	fmt.Printf("Loaded %d ground truth matches\n", len(groundTruthMap))
+	if many1, many2 := groundTruthMap.multiplicity(); many1+many2 > 0 {
+		fmt.Printf("   %d dataset 1 and %d dataset 2 records have several true matches\n", many1, many2)
+		if !allowDuplicates {
+			fmt.Println("   Note: 1:1 matching finds at most one match per record; -allow-duplicates matches 1:many")
+		}
+	}
 
 	// Load datasets
 	fmt.Println("Loading datasets...")
@@ -322,41 +493,81 @@ func performValidation(config1, config2, groundTruth, outputFile string, matchTh
 
 	fmt.Printf("Dataset 1: %d records\n", len(records1))
 	fmt.Printf("Dataset 2: %d records\n", len(records2))
+	warnNoiseThresholds(cfg1, cfg2, records1, records2, configHammingThreshold, configJaccardThreshold)
+	distanceScale := hardeningScale(cfg1.Tokenization)
 
-	fmt.Println("Running PPRL matching pipeline...")
-	fmt.Printf("  Using Hamming threshold: %d (from config)\n", configHammingThreshold)
-	fmt.Printf("  Using Jaccard threshold: %.3f (from config)\n", configJaccardThreshold)
-
-	// Configure zero-knowledge matching pipeline
-	// All thresholds are now hardcoded for security - no configurable values
-	pipelineConfig := &match.PipelineConfig{
-		FuzzyMatchConfig: &match.FuzzyMatchConfig{
-			Party: 0, // Default to party 0 for validation
-		},
-		OutputPath:    outputFile + ".matches", // Temporary file for matches
-		EnableStats:   true,
-		MaxCandidates: 1000,
-	}
-
-	// Create matching pipeline
-	pipeline, err := match.NewPipeline(pipelineConfig)
-	if err != nil {
-		return fmt.Errorf("failed to create pipeline: %w", err)
+	// Tuning mode sweeps thresholds instead of validating one setting
+	if tuning != nil {
+		return runThresholdTuning(records1, records2, groundTruthMap, assignment, outputFile, tuning, distanceScale)
+	}
+
+	// Train a calibration model against the ground truth, or use the configured one
+	var calibration *match.Calibration
+	if calibrateFile != "" {
+		fmt.Println("Training match probability calibration...")
+		calibration, err = trainValidationCalibration(records1, records2, groundTruthMap, calibrationMethod, allowDuplicates, distanceScale)
+		if err != nil {
+			return fmt.Errorf("calibration failed: %w", err)
+		}
+		if err := calibration.Save(calibrateFile); err != nil {
+			return fmt.Errorf("failed to save calibration: %w", err)
+		}
+		if fs := calibration.FellegiSunter; fs != nil {
+			fmt.Printf("  Fellegi-Sunter model: %d pairs, %d estimated matches, %d EM iterations", calibration.Samples, calibration.Positives, fs.Iterations)
+			if !fs.Converged {
+				fmt.Print(" (did not converge)")
+			}
+			fmt.Println()
+			printFellegiSunterWeights(fs)
+			fmt.Printf("  Suggested threshold: match weight >= %.2f (probability_threshold: %.3f)\n", fs.SuggestedWeight, fs.SuggestedProbability)
+			fmt.Printf("  Model-estimated precision %.3f, recall %.3f; Brier score against the ground truth %.4f\n", fs.EstimatedPrecision, fs.EstimatedRecall, calibration.Brier)
+			if probabilityThreshold == 0 {
+				probabilityThreshold = fs.SuggestedProbability
+				fmt.Println("  Validating at the suggested threshold (set -probability-threshold to override)")
+			}
+		} else {
+			fmt.Printf("  Trained on %d pairs (%d matches), Brier score %.4f\n", calibration.Samples, calibration.Positives, calibration.Brier)
+		}
+		fmt.Printf("  Calibration saved to: %s\n", calibrateFile)
+		porcelain.output(calibrateFile)
+		fmt.Println("  Set matching.calibration_file in both configs to report probabilities")
+	} else if cfg1.Matching.CalibrationFile != "" {
+		calibration, err = match.LoadCalibration(cfg1.Matching.CalibrationFile)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  Using calibration: %s\n", cfg1.Matching.CalibrationFile)
 	}
 
+	if probabilityThreshold == 0 {
+		probabilityThreshold = cfg1.Matching.ProbabilityThreshold
+	}
+	if probabilityThreshold > 0 && calibration == nil {
+		return fmt.Errorf("a probability threshold requires a calibration (use -calibrate or matching.calibration_file)")
+	}
+
+	fmt.Println("Running PPRL matching pipeline...")
+
 	// Run matching with config thresholds
-	matches, allComparisons, err := runMatchingPipeline(records1, records2, pipeline, configHammingThreshold, configJaccardThreshold)
+	blocking := len(cfg1.Tokenization.Blocking) > 0
+	matches, allComparisons, err := runMatchingPipeline(records1, records2, configHammingThreshold, configJaccardThreshold, allowDuplicates, assignment, calibration, probabilityThreshold, blocking, distanceScale, comparator)
 	if err != nil {
 		return fmt.Errorf("failed to run matching pipeline: %w", err)
 	}
 
 	fmt.Printf("Found %d matches from %d comparisons\n", len(matches), len(allComparisons))
 
-	if verbose {
-		fmt.Println("Performing detailed analysis...")
-		fmt.Println("   Computing ROC curve...")
-		fmt.Println("   Calculating confusion matrix...")
-		fmt.Println("   Analyzing error patterns...")
+	// Score distributions over every comparison, independent of the chosen thresholds
+	if verbose || curvesFile != "" {
+		if err := runCurveAnalysis(records1, records2, groundTruthMap, calibration, curvesFile, distanceScale); err != nil {
+			return fmt.Errorf("curve analysis failed: %w", err)
+		}
+	}
+	if histogram != nil {
+		if err := runHistogramAnalysis(records1, records2, groundTruthMap, histogram, histogramFile, distanceScale); err != nil {
+			return fmt.Errorf("score histogram failed: %w", err)
+		}
+		porcelain.output(histogramFile)
 	}
 
 	fmt.Println("Computing validation metrics...")
@@ -373,6 +584,21 @@ func performValidation(config1, config2, groundTruth, outputFile string, matchTh
 	fmt.Printf("   Precision: %.3f\n", validationResult.Precision)
 	fmt.Printf("   Recall: %.3f\n", validationResult.Recall)
 	fmt.Printf("   F1-Score: %.3f\n", validationResult.F1Score)
+	clusters := validationResult.Clusters
+	fmt.Println("\nCluster-Level Results:")
+	fmt.Printf("   True Clusters: %d, Predicted Clusters: %d\n", clusters.TrueClusters, clusters.PredictedClusters)
+	fmt.Printf("   Exact Clusters: %d (split: %d, merged: %d)\n", clusters.ExactClusters, clusters.SplitClusters, clusters.MergedClusters)
+	fmt.Printf("   Cluster Precision: %.3f\n", clusters.Precision)
+	fmt.Printf("   Cluster Recall: %.3f\n", clusters.Recall)
+	fmt.Printf("   Cluster F1-Score: %.3f\n", clusters.F1)
+	reportValidationPorcelain(validationResult, len(groundTruthMap))
+
+	// With several files, each is scored on its own and the pool is listed beside them
+	var perFile []truthFileResult
+	if len(truthFiles) > 1 {
+		perFile = append(validateTruthFiles(matches, truthFiles), truthFileResult{path: "pooled", total: len(groundTruthMap), result: validationResult})
+		printTruthFileResults(perFile)
+	}
 	if verbose {
 		// Show some examples
 		if len(validationResult.MatchedPairs) > 0 {
@@ -409,14 +635,29 @@ func performValidation(config1, config2, groundTruth, outputFile string, matchTh
 	fmt.Println("\nSaving validation report to CSV...")
 
 	// Save detailed validation report
-	if err := saveValidationReport(validationResult, outputFile, len(groundTruthMap), verbose); err != nil {
+	if err := saveValidationReport(validationResult, outputFile, len(groundTruthMap), perFile, verbose); err != nil {
 		return fmt.Errorf("failed to save validation report: %w", err)
 	}
 
 	fmt.Printf("Validation report saved to: %s\n", outputFile)
+	porcelain.output(outputFile)
 	return nil
 }
 
+// reportValidationPorcelain adds the pair and cluster metrics to the -porcelain summary
+func reportValidationPorcelain(result *ValidationResult, groundTruth int) {
+	porcelain.count("ground_truth_matches", groundTruth)
+	porcelain.count("true_positives", result.TruePositives)
+	porcelain.count("false_positives", result.FalsePositives)
+	porcelain.count("false_negatives", result.FalseNegatives)
+	porcelain.metric("precision", result.Precision)
+	porcelain.metric("recall", result.Recall)
+	porcelain.metric("f1_score", result.F1Score)
+	porcelain.metric("cluster_precision", result.Clusters.Precision)
+	porcelain.metric("cluster_recall", result.Clusters.Recall)
+	porcelain.metric("cluster_f1_score", result.Clusters.F1)
+}
+
 func showValidateHelp() {
 	fmt.Println("CohortBridge Validation Tool")
 	fmt.Println("============================")
@@ -430,15 +671,50 @@ func showValidateHelp() {
 	fmt.Println("OPTIONS:")
 	fmt.Println("  -config1 string       Configuration file for dataset 1 (Party A)")
 	fmt.Println("  -config2 string       Configuration file for dataset 2 (Party B)")
-	fmt.Println("  -ground-truth string  Ground truth CSV file with expected matches")
+	fmt.Println("  -ground-truth string  Ground truth CSV file with expected matches; a comma-separated")
+	fmt.Println("                        list of files or globs is pooled, and each file is also")
+	fmt.Println("                        scored on its own")
 	fmt.Println("  -output string        Output CSV file for validation report")
-	fmt.Println("  -match-threshold      Hamming distance threshold for matches (default: 20)")
-	fmt.Println("  -jaccard-threshold    Jaccard similarity threshold for matches (default: 0.32)")
-	fmt.Println("  -verbose              Verbose output with detailed analysis")
+	fmt.Println("                        Ground truth and the configs' data files may be gzipped;")
+	fmt.Println("                        the report, -curves and -tune files are when named .gz")
+	fmt.Println("  -hamming-threshold    Hamming distance threshold for matches (default: the")
+	fmt.Printf("                        configs' matching.hamming_threshold or %d)\n", config.DefaultHammingThreshold)
+	fmt.Println("  -jaccard-threshold    Jaccard similarity threshold for matches (default: the")
+	fmt.Printf("                        configs' matching.jaccard_threshold or %g)\n", config.DefaultJaccardThreshold)
+	fmt.Println("  -match-threshold      Alias of -hamming-threshold")
+	fmt.Println("  -verbose              Verbose output with detailed analysis (includes ROC/PR AUC)")
+	fmt.Println("  -curves string        Export ROC and precision-recall curve points to this file")
+	fmt.Println("                        (.json for JSON, otherwise CSV)")
+	fmt.Println("  -histogram string     Write binned counts of the Hamming distance and Jaccard")
+	fmt.Println("                        similarity of every pair, with the ground truth matches of")
+	fmt.Println("                        each bin (.json for JSON, otherwise CSV); no record IDs")
+	fmt.Printf("  -histogram-hamming-bin uint\n")
+	fmt.Printf("                        Hamming distance bin width (default: %d)\n", match.DefaultHammingBinWidth)
+	fmt.Printf("  -histogram-jaccard-bin float\n")
+	fmt.Printf("                        Jaccard similarity bin width (default: %g)\n", match.DefaultJaccardBinWidth)
+	fmt.Println("  -allow-duplicates     Allow 1:many matching (default: 1:1), for ground truth listing")
+	fmt.Println("                        several matches of one record")
+	fmt.Println("  -calibrate string     Train a match probability calibration and save it to this file")
+	fmt.Println("  -calibration-method string")
+	fmt.Println("                        platt (logistic model fit to the ground truth, default) or")
+	fmt.Println("                        fellegi-sunter (m/u probabilities estimated by EM without")
+	fmt.Println("                        labels; validates at its suggested threshold by default)")
+	fmt.Println("  -probability-threshold float")
+	fmt.Println("                        Match on calibrated probability instead of distance thresholds")
 	fmt.Println("  -interactive          Force interactive mode")
 	fmt.Println("  -force                Skip confirmation prompts and run automatically")
 	fmt.Println("  -help                 Show this help message")
 	fmt.Println()
+	fmt.Println("TUNING OPTIONS:")
+	fmt.Println("  -tune                 Sweep threshold grids and recommend thresholds; -output")
+	fmt.Println("                        receives the precision/recall/F1 surface as CSV")
+	fmt.Println("  -hamming-grid string  Hamming thresholds to sweep (default: 0:200:10)")
+	fmt.Println("  -jaccard-grid string  Jaccard thresholds to sweep (default: 0.1:0.9:0.05)")
+	fmt.Println("  -tune-criterion string")
+	fmt.Println("                        f1 (max F1, default) or precision (max recall at -min-precision)")
+	fmt.Println("  -min-precision float  Minimum precision for the precision criterion (default: 0.95)")
+	fmt.Println("  -tune-config string   Recommended config snippet (default: <output>_recommended.yaml)")
+	fmt.Println()
 	fmt.Println("EXAMPLES:")
 	fmt.Println("  # Interactive mode (prompts for all inputs)")
 	fmt.Println("  cohort-bridge validate")
@@ -446,20 +722,39 @@ func showValidateHelp() {
 	fmt.Println("  # Command line mode")
 	fmt.Println("  cohort-bridge validate -config1 config_a.yaml -config2 config_b.yaml -ground-truth data/expected_matches.csv")
 	fmt.Println("  cohort-bridge validate -config1 config_a.yaml -config2 config_b.yaml -ground-truth data/expected_matches.csv -verbose")
-	fmt.Println("  cohort-bridge validate -config1 config_a.yaml -config2 config_b.yaml -ground-truth data/expected_matches.csv -match-threshold 15 -jaccard-threshold 0.8")
+	fmt.Println("  cohort-bridge validate -config1 config_a.yaml -config2 config_b.yaml -ground-truth data/expected_matches.csv -hamming-threshold 15 -jaccard-threshold 0.8")
 	fmt.Println()
 	fmt.Println("  # Automatic mode (skip confirmations)")
 	fmt.Println("  cohort-bridge validate -config1 config_a.yaml -config2 config_b.yaml -ground-truth data/expected_matches.csv -force")
 	fmt.Println("  cohort-bridge validate -config1 config_a.yaml -config2 config_b.yaml -ground-truth data/expected_matches.csv -verbose -force")
-	fmt.Println("  cohort-bridge validate -config1 config_a.yaml -config2 config_b.yaml -ground-truth data/expected_matches.csv -match-threshold 25 -jaccard-threshold 0.3 -force")
+	fmt.Println("  cohort-bridge validate -config1 config_a.yaml -config2 config_b.yaml -ground-truth data/expected_matches.csv -hamming-threshold 25 -jaccard-threshold 0.3 -force")
+	fmt.Println()
+	fmt.Println("  # Validate against one ground truth file per site, with per-file and pooled metrics")
+	fmt.Println("  cohort-bridge validate -config1 config_a.yaml -config2 config_b.yaml -ground-truth \"truth/site_*.csv\" -output report.csv -force")
+	fmt.Println()
+	fmt.Println("  # Find the best thresholds for precision >= 0.98")
+	fmt.Println("  cohort-bridge validate -config1 config_a.yaml -config2 config_b.yaml -ground-truth data/expected_matches.csv -output tuning.csv -tune -tune-criterion precision -min-precision 0.98 -force")
+	fmt.Println()
+	fmt.Println("  # Train a calibration and match on probability")
+	fmt.Println("  cohort-bridge validate -config1 config_a.yaml -config2 config_b.yaml -ground-truth data/expected_matches.csv -calibrate calibration.json -probability-threshold 0.9 -force")
+	fmt.Println()
+	fmt.Println("  # Train a Fellegi-Sunter model and check its suggested threshold against the ground truth")
+	fmt.Println("  cohort-bridge validate -config1 config_a.yaml -config2 config_b.yaml -ground-truth data/expected_matches.csv -calibrate fs.json -calibration-method fellegi-sunter -force")
+	fmt.Println()
+	fmt.Println("  # Compare score distributions: report AUC and export ROC/PR curves")
+	fmt.Println("  cohort-bridge validate -config1 config_a.yaml -config2 config_b.yaml -ground-truth data/expected_matches.csv -curves curves.json -force")
+	fmt.Println()
+	fmt.Println("  # Binned score counts, split by the ground truth, to place thresholds in the valley")
+	fmt.Println("  cohort-bridge validate -config1 config_a.yaml -config2 config_b.yaml -ground-truth data/expected_matches.csv -histogram scores.csv -force")
 	fmt.Println()
 	fmt.Println("  # Force interactive even with some parameters")
 	fmt.Println("  cohort-bridge validate -config1 config_a.yaml -interactive")
 }
 
-// loadGroundTruth loads the ground truth CSV file
-func loadGroundTruth(path string) (map[string]string, error) {
-	file, err := os.Open(path)
+// loadGroundTruth loads the ground truth CSV file of id1,id2 pairs, gzipped or not; a record may
+// be listed in several pairs
+func loadGroundTruth(path string) (groundTruth, error) {
+	file, err := db.OpenInput(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open ground truth file: %w", err)
 	}
@@ -479,7 +774,7 @@ func loadGroundTruth(path string) (map[string]string, error) {
 		return nil, fmt.Errorf("ground truth file must have at least 2 rows (header + data)")
 	}
 
-	groundTruth := make(map[string]string)
+	truth := make(groundTruth)
 
 	// Always treat the first row as header
 	startIdx := 1
@@ -510,12 +805,63 @@ func loadGroundTruth(path string) (map[string]string, error) {
 			id1 := strings.TrimSpace(record[0])
 			id2 := strings.TrimSpace(record[1])
 			if id1 != "" && id2 != "" {
-				groundTruth[id1] = id2
+				truth[MatchPair{ID1: id1, ID2: id2}] = true
 			}
 		}
 	}
 
-	return groundTruth, nil
+	return truth, nil
+}
+
+// flipProbability returns the chance that tokenization flips any given Bloom filter bit
+func flipProbability(recipe config.TokenizationConfig) float64 {
+	if recipe.Epsilon > 0 {
+		return pprl.BLIPFlipProbability(recipe.Epsilon)
+	}
+	// Fixed noise flips about this fraction of the bits
+	return recipe.Noise
+}
+
+// meanSetBits returns the average number of bits set in the records' Bloom filters
+func meanSetBits(records []*pprl.Record) float64 {
+	total, counted := 0, 0
+	for _, record := range records {
+		bf, err := pprl.BloomFromBase64(record.BloomData)
+		if err != nil {
+			continue
+		}
+		total += bf.SetBitCount()
+		counted++
+	}
+	if counted == 0 {
+		return 0
+	}
+	return float64(total) / float64(counted)
+}
+
+// warnNoiseThresholds warns when Bloom filter noise moves even identical records beyond the
+// matching thresholds, so validation would report missed matches the thresholds cannot reach
+func warnNoiseThresholds(cfg1, cfg2 *config.Config, records1, records2 []*pprl.Record, hammingThreshold uint32, jaccardThreshold float64) {
+	p1, p2 := flipProbability(cfg1.Tokenization), flipProbability(cfg2.Tokenization)
+	if p1 == 0 && p2 == 0 {
+		return
+	}
+
+	m := cfg1.Tokenization.BloomSize
+	mean, stddev := pprl.ExpectedBLIPHamming(m, p1, p2)
+	fmt.Printf("  Bloom filter noise: %.2f%% / %.2f%% of bits flipped; identical records are expected at Hamming distance %.1f (±%.1f)\n",
+		p1*100, p2*100, mean, stddev)
+	if mean >= float64(hammingThreshold) {
+		fmt.Printf("  WARNING: Hamming threshold %d is unreachable for most true matches at this noise level\n", hammingThreshold)
+		fmt.Printf("           Raise tokenization.epsilon or use a Hamming threshold of at least %.0f\n", math.Ceil(mean+2*stddev))
+	} else if mean+2*stddev > float64(hammingThreshold) {
+		fmt.Printf("  WARNING: noise will push many true matches past Hamming threshold %d (consider %.0f)\n", hammingThreshold, math.Ceil(mean+2*stddev))
+	}
+
+	setBits := (pprl.CleanSetBits(m, meanSetBits(records1), p1) + pprl.CleanSetBits(m, meanSetBits(records2), p2)) / 2
+	if jaccard := pprl.ExpectedBLIPJaccard(m, setBits, p1, p2); jaccard < jaccardThreshold {
+		fmt.Printf("  WARNING: identical records are expected at Jaccard similarity %.3f, below threshold %.3f\n", jaccard, jaccardThreshold)
+	}
 }
 
 // loadDataset loads a dataset from configuration for zero-knowledge validation
@@ -523,24 +869,47 @@ func loadDataset(cfg *config.Config, datasetName string) ([]*pprl.Record, error)
 	fmt.Printf("   Loading %s...\n", datasetName)
 
 	var records []*pprl.Record
-	var err error
+	recordConfig, err := newRecordConfig(cfg.Tokenization, cfg.Keys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tokenization recipe for %s: %w", datasetName, err)
+	}
+	if recordConfig.Columns, err = newColumnMapping(cfg); err != nil {
+		return nil, fmt.Errorf("invalid column mapping for %s: %w", datasetName, err)
+	}
+	if recordConfig.IDColumns, err = newIDColumns(cfg); err != nil {
+		return nil, fmt.Errorf("invalid ID columns for %s: %w", datasetName, err)
+	}
+	if recordConfig.MultiValue, err = newMultiValueColumns(cfg); err != nil {
+		return nil, fmt.Errorf("invalid multi-valued columns for %s: %w", datasetName, err)
+	}
+	if recordConfig.Sanitizer, err = newSanitizer(cfg); err != nil {
+		return nil, fmt.Errorf("invalid input sanitization for %s: %w", datasetName, err)
+	}
 
 	if cfg.Database.IsTokenized {
 		fmt.Printf("   Loading tokenized data from %s\n", cfg.Database.Filename)
-		records, err = server.LoadTokenizedRecords(cfg.Database.Filename, cfg.IsEncrypted(), cfg.Database.EncryptionKey, cfg.Database.EncryptionKeyFile)
+		keySource, err := keySourceFromConfig(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure encryption keys: %v", err)
+		}
+		records, err = server.LoadTokenizedRecords(cfg.Database.Filename, cfg.IsEncrypted(), keySource)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load tokenized records: %v", err)
 		}
 	} else {
 		fmt.Printf("   Loading raw data from %s\n", cfg.Database.Filename)
 
-		// Use the EXACT SAME tokenization process as the PPRL workflow
-		tempTokenFile := fmt.Sprintf("temp_validation_tokens_%s.csv", datasetName)
-		err := performValidationTokenization(cfg.Database.Filename, tempTokenFile, cfg.Database.Fields)
+		// Use the EXACT SAME tokenization process as the PPRL workflow, into a workspace removed afterwards
+		ws, err := newWorkspace(cfg, "validate")
+		if err != nil {
+			return nil, err
+		}
+		defer ws.Close()
+		tempTokenFile := ws.Path(fmt.Sprintf("validation_tokens_%s.csv", datasetName))
+		err = performValidationTokenization(cfg.Database.Filename, tempTokenFile, cfg.Database.Fields, cfg.Database.CSV, recordConfig)
 		if err != nil {
 			return nil, fmt.Errorf("failed to tokenize %s: %w", datasetName, err)
 		}
-		defer os.Remove(tempTokenFile) // Clean up temp file
 
 		// Load the tokenized data the same way PPRL workflow does
 		tokenData, err := loadTokenizedDataForValidation(tempTokenFile)
@@ -555,21 +924,60 @@ func loadDataset(cfg *config.Config, datasetName string) ([]*pprl.Record, error)
 		}
 	}
 
+	// The ground truth holds original IDs, so pseudonyms are mapped back
+	if err := restoreRecordIDs(records, recordConfig.IDs, datasetName); err != nil {
+		return nil, err
+	}
+
 	return records, nil
 }
 
+// restoreRecordIDs replaces pseudonymized record IDs with the original IDs in the local mapping file
+func restoreRecordIDs(records []*pprl.Record, ids *pprl.IDMapper, datasetName string) error {
+	if ids == nil {
+		return nil
+	}
+	unmapped := 0
+	for _, record := range records {
+		original, ok, err := ids.Original(record.ID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			unmapped++
+			continue
+		}
+		record.ID = original
+	}
+	if unmapped > 0 {
+		fmt.Printf("   Warning: %d %s IDs are not in %s and were left as they are\n", unmapped, datasetName, ids.MapFile())
+	}
+	return nil
+}
+
 // runMatchingPipeline performs validation using the SAME approach as the PPRL workflow
-// This ensures validation uses identical zero-knowledge protocols as production
-func runMatchingPipeline(records1, records2 []*pprl.Record, pipeline *match.Pipeline, hammingThreshold uint32, jaccardThreshold float64) ([]*match.PrivateMatchResult, []*match.PrivateMatchResult, error) {
+// This ensures validation uses identical zero-knowledge protocols as production, including the
+// blocking, so recall lost to blocking shows in the metrics
+func runMatchingPipeline(records1, records2 []*pprl.Record, hammingThreshold uint32, jaccardThreshold float64, allowDuplicates bool, assignment string, calibration *match.Calibration, probabilityThreshold float64, blocking bool, distanceScale uint32, comparator match.Comparator) ([]*match.PrivateMatchResult, []*match.PrivateMatchResult, error) {
 	fmt.Println("   Computing zero-knowledge matching for validation...")
-	fmt.Printf("   Using thresholds: Hamming=%d, Jaccard=%.3f\n", hammingThreshold, jaccardThreshold)
+	if probabilityThreshold > 0 {
+		fmt.Printf("   Using calibrated probability threshold: %.3f\n", probabilityThreshold)
+	} else {
+		fmt.Printf("   Using thresholds: Hamming=%d, Jaccard=%.3f\n", hammingThreshold, jaccardThreshold)
+	}
 
 	// Use the zero-knowledge fuzzy matcher for validation with proper thresholds
 	fuzzyMatcher := match.NewFuzzyMatcher(&match.FuzzyMatchConfig{
-		Party:            0,     // Validation uses party 0
-		AllowDuplicates:  false, // 1:1 matching for validation
-		HammingThreshold: hammingThreshold,
-		JaccardThreshold: jaccardThreshold,
+		Party:                0,               // Validation uses party 0
+		AllowDuplicates:      allowDuplicates, // 1:1 unless the ground truth calls for 1:many
+		HammingThreshold:     hammingThreshold,
+		JaccardThreshold:     jaccardThreshold,
+		Assignment:           assignment,
+		Calibration:          calibration,
+		ProbabilityThreshold: probabilityThreshold,
+		Blocking:             blocking,
+		DistanceScale:        distanceScale,
+		Comparator:           comparator,
 	})
 
 	// Perform zero-knowledge intersection computation
@@ -579,14 +987,7 @@ func runMatchingPipeline(records1, records2 []*pprl.Record, pipeline *match.Pipe
 	}
 
 	// Convert results to PrivateMatchResult
-	var matches []*match.PrivateMatchResult
-	for _, privateMatch := range secureResult.MatchPairs {
-		matchResult := &match.PrivateMatchResult{
-			LocalID: privateMatch.LocalID,
-			PeerID:  privateMatch.PeerID,
-		}
-		matches = append(matches, matchResult)
-	}
+	matches := fuzzyMatcher.MatchResults(secureResult)
 
 	fmt.Printf("   ✅ Found %d matches using zero-knowledge protocols\n", len(matches))
 	fmt.Printf("   Completed zero-knowledge intersection, found %d matches\n", len(matches))
@@ -598,15 +999,101 @@ func runMatchingPipeline(records1, records2 []*pprl.Record, pipeline *match.Pipe
 			if i >= 3 { // Show first 3 matches only
 				break
 			}
-			fmt.Printf("     %s->%s\n", match.LocalID, match.PeerID)
+			if calibration != nil {
+				fmt.Printf("     %s->%s (p=%.3f)\n", match.LocalID, match.PeerID, match.Probability)
+			} else {
+				fmt.Printf("     %s->%s\n", match.LocalID, match.PeerID)
+			}
 		}
 	}
 
 	return matches, matches, nil
 }
 
-// validateResults validates zero-knowledge predicted matches against ground truth
-func validateResults(matches []*match.PrivateMatchResult, allComparisons []*match.PrivateMatchResult, groundTruth map[string]string) *ValidationResult {
+// scoreValidationPairs scores every record pair and labels it against the ground truth, dividing
+// Hamming distances by the hardening's distanceScale. It also returns the Bloom filter size used
+// to scale Hamming distances, in the same units.
+func scoreValidationPairs(records1, records2 []*pprl.Record, truth groundTruth, distanceScale uint32) ([]match.ScoredPair, float64, error) {
+	type decoded struct {
+		id      string
+		bf      *pprl.BloomFilter
+		minHash []uint32
+	}
+	decode := func(records []*pprl.Record) ([]decoded, error) {
+		out := make([]decoded, 0, len(records))
+		for _, r := range records {
+			bf, err := pprl.BloomFromBase64(r.BloomData)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode Bloom filter for %s: %w", r.ID, err)
+			}
+			out = append(out, decoded{id: r.ID, bf: bf, minHash: r.MinHash})
+		}
+		return out, nil
+	}
+
+	left, err := decode(records1)
+	if err != nil {
+		return nil, 0, err
+	}
+	right, err := decode(records2)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(left) == 0 || len(right) == 0 {
+		return nil, 0, fmt.Errorf("both datasets must contain records")
+	}
+
+	pairs := make([]match.ScoredPair, 0, len(left)*len(right))
+	for _, a := range left {
+		for _, b := range right {
+			hamming, jaccard, err := match.ScoreRecords(a.bf, b.bf, a.minHash, b.minHash)
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to compare %s and %s: %w", a.id, b.id, err)
+			}
+			pairs = append(pairs, match.ScoredPair{
+				ID1: a.id, ID2: b.id,
+				Hamming: hamming / distanceScale, Jaccard: jaccard,
+				Match: truth.has(a.id, b.id),
+			})
+		}
+	}
+
+	return pairs, float64(left[0].bf.GetSize() / distanceScale), nil
+}
+
+// trainValidationCalibration fits a calibration model to every record pair scored against the
+// ground truth. The Fellegi-Sunter model is trained without the labels.
+func trainValidationCalibration(records1, records2 []*pprl.Record, truth groundTruth, method string, allowDuplicates bool, distanceScale uint32) (*match.Calibration, error) {
+	pairs, scale, err := scoreValidationPairs(records1, records2, truth, distanceScale)
+	if err != nil {
+		return nil, err
+	}
+	if method == match.CalibrationMethodFellegiSunter {
+		// Validation matches 1:1 unless asked otherwise, bounding the matches by the smaller dataset
+		maxMatches := min(len(records1), len(records2))
+		if allowDuplicates {
+			maxMatches = 0
+		}
+		return match.TrainFellegiSunter(pairs, scale, maxMatches)
+	}
+	return match.TrainCalibration(pairs, scale)
+}
+
+// printFellegiSunterWeights prints the match weight, log2(m/u), of every bin of each feature
+func printFellegiSunterWeights(model *match.FellegiSunterModel) {
+	for _, feature := range model.Features {
+		fmt.Printf("  %s weights:", feature.Name)
+		for b := range feature.M {
+			fmt.Printf(" %.1f", math.Log2(feature.M[b]/feature.U[b]))
+		}
+		fmt.Println()
+	}
+}
+
+// validateResults validates zero-knowledge predicted matches against ground truth. Each true
+// pair counts once, so a record with several true matches contributes a true positive for every
+// one found and a false negative for every one missed.
+func validateResults(matches []*match.PrivateMatchResult, allComparisons []*match.PrivateMatchResult, truth groundTruth) *ValidationResult {
 	result := &ValidationResult{
 		MatchedPairs:  make([]MatchPair, 0),
 		MissedMatches: make([]string, 0),
@@ -614,29 +1101,28 @@ func validateResults(matches []*match.PrivateMatchResult, allComparisons []*matc
 	}
 
 	// Create a set of predicted matches using ONLY IDs
-	predictedMatches := make(map[string]string)
+	predictedMatches := make(map[MatchPair]bool)
 	for _, match := range matches {
-		predictedMatches[match.LocalID] = match.PeerID
+		predictedMatches[MatchPair{ID1: match.LocalID, ID2: match.PeerID}] = true
 	}
 
 	// Calculate True Positives and False Negatives
-	for id1, expectedID2 := range groundTruth {
-		if predictedID2, found := predictedMatches[id1]; found && predictedID2 == expectedID2 {
+	truePairs := make([]*match.PrivateMatchResult, 0, len(truth))
+	for _, pair := range truth.sorted() {
+		truePairs = append(truePairs, &match.PrivateMatchResult{LocalID: pair.ID1, PeerID: pair.ID2})
+		if predictedMatches[pair] {
 			result.TruePositives++
 			// No scores in zero-knowledge validation - just store the match
-			result.MatchedPairs = append(result.MatchedPairs, MatchPair{
-				ID1: id1,
-				ID2: predictedID2,
-			})
+			result.MatchedPairs = append(result.MatchedPairs, pair)
 		} else {
 			result.FalseNegatives++
-			result.MissedMatches = append(result.MissedMatches, fmt.Sprintf("%s -> %s", id1, expectedID2))
+			result.MissedMatches = append(result.MissedMatches, fmt.Sprintf("%s -> %s", pair.ID1, pair.ID2))
 		}
 	}
 
 	// Calculate False Positives
 	for _, match := range matches {
-		if expectedID2, exists := groundTruth[match.LocalID]; !exists || expectedID2 != match.PeerID {
+		if !truth.has(match.LocalID, match.PeerID) {
 			result.FalsePositives++
 			result.FalseMatches = append(result.FalseMatches, MatchPair{
 				ID1: match.LocalID,
@@ -658,12 +1144,14 @@ func validateResults(matches []*match.PrivateMatchResult, allComparisons []*matc
 		result.F1Score = 2 * (result.Precision * result.Recall) / (result.Precision + result.Recall)
 	}
 
+	result.Clusters = match.EvaluateClusters(matches, truePairs)
 	return result
 }
 
-// saveValidationReport saves the validation results to a CSV file
-func saveValidationReport(result *ValidationResult, outputFile string, totalGroundTruth int, verbose bool) error {
-	file, err := os.Create(outputFile)
+// saveValidationReport saves the validation results to a CSV file, with the metrics of each
+// ground truth file when several were pooled
+func saveValidationReport(result *ValidationResult, outputFile string, totalGroundTruth int, perFile []truthFileResult, verbose bool) error {
+	file, err := db.CreateOutput(outputFile)
 	if err != nil {
 		return fmt.Errorf("failed to create output file: %w", err)
 	}
@@ -680,6 +1168,17 @@ func saveValidationReport(result *ValidationResult, outputFile string, totalGrou
 	writer.Write([]string{"precision", fmt.Sprintf("%.6f", result.Precision)})
 	writer.Write([]string{"recall", fmt.Sprintf("%.6f", result.Recall)})
 	writer.Write([]string{"f1_score", fmt.Sprintf("%.6f", result.F1Score)})
+	writer.Write([]string{"true_clusters", strconv.Itoa(result.Clusters.TrueClusters)})
+	writer.Write([]string{"predicted_clusters", strconv.Itoa(result.Clusters.PredictedClusters)})
+	writer.Write([]string{"exact_clusters", strconv.Itoa(result.Clusters.ExactClusters)})
+	writer.Write([]string{"split_clusters", strconv.Itoa(result.Clusters.SplitClusters)})
+	writer.Write([]string{"merged_clusters", strconv.Itoa(result.Clusters.MergedClusters)})
+	writer.Write([]string{"cluster_precision", fmt.Sprintf("%.6f", result.Clusters.Precision)})
+	writer.Write([]string{"cluster_recall", fmt.Sprintf("%.6f", result.Clusters.Recall)})
+	writer.Write([]string{"cluster_f1_score", fmt.Sprintf("%.6f", result.Clusters.F1)})
+	if len(perFile) > 0 {
+		writeTruthFileResults(writer, perFile)
+	}
 
 	// Add detailed results
 	writer.Write([]string{""}) // Empty row
@@ -718,10 +1217,11 @@ func saveValidationReport(result *ValidationResult, outputFile string, totalGrou
 	return nil
 }
 
-// performValidationTokenization - exact copy of performRealTokenization from pprl.go
-func performValidationTokenization(inputFile, outputFile string, fields []string) error {
+// performValidationTokenization tokenizes like performRealTokenization; pseudonymized IDs are
+// mapped back by restoreRecordIDs for ground-truth matching
+func performValidationTokenization(inputFile, outputFile string, fields []string, dialect config.CSVDialect, recordConfig *pprl.RecordConfig) error {
 	// Read input CSV file
-	csvDB, err := db.NewCSVDatabase(inputFile)
+	csvDB, err := db.NewCSVDatabaseWithDialect(inputFile, dialect)
 	if err != nil {
 		return fmt.Errorf("failed to open CSV file: %w", err)
 	}
@@ -732,6 +1232,12 @@ func performValidationTokenization(inputFile, outputFile string, fields []string
 		return fmt.Errorf("failed to read records: %w", err)
 	}
 
+	fieldNames, normalizationConfig := parseFieldsWithNormalization(fields)
+	tokenizer, err := newRecordTokenizer(fieldNames, recordConfig, normalizationConfig)
+	if err != nil {
+		return err
+	}
+
 	// Create CSV output file with proper headers
 	outputCSV, err := os.Create(outputFile)
 	if err != nil {
@@ -743,99 +1249,31 @@ func performValidationTokenization(inputFile, outputFile string, fields []string
 	defer writer.Flush()
 
 	// Write CSV header
-	header := []string{"id", "bloom_filter", "minhash", "timestamp"}
-	if err := writer.Write(header); err != nil {
+	if err := writer.Write(tokenizer.header()); err != nil {
 		return fmt.Errorf("failed to write CSV header: %w", err)
 	}
 
-	// PPRL configuration for tokenization - EXACT SAME as pprl.go
-	recordConfig := &pprl.RecordConfig{
-		BloomSize:    1000, // 1000 bits
-		BloomHashes:  5,    // 5 hash functions
-		MinHashSize:  100,  // 100-element signature
-		QGramLength:  2,    // 2-grams
-		QGramPadding: "$",  // Padding character
-		NoiseLevel:   0,    // No noise for deterministic matching
-	}
-
 	processedCount := 0
 	for _, record := range allRecords {
-		// Extract field values for this record
-		var fieldValues []string
-		for _, field := range fields {
-			// Extract actual field name (remove type prefix like "name:", "date:", etc.)
-			fieldName := field
-			if strings.Contains(field, ":") {
-				parts := strings.Split(field, ":")
-				if len(parts) == 2 {
-					fieldName = parts[1]
-				}
-			}
-
-			if value, exists := record[fieldName]; exists && value != "" {
-				fieldValues = append(fieldValues, value)
-			}
-		}
-
-		if len(fieldValues) == 0 {
-			continue // Skip records with no data in specified fields
-		}
-
-		// Get record ID
-		recordID := record["id"]
-		if recordID == "" {
-			// Generate ID if not present
-			recordID = fmt.Sprintf("record_%d", processedCount+1)
-		}
-
-		// Create PPRL record with real tokenization
-		pprlRecord, err := pprl.CreateRecord(recordID, fieldValues, recordConfig)
+		csvRow, err := tokenizer.row(record, fmt.Sprintf("record_%d", processedCount+1))
 		if err != nil {
-			return fmt.Errorf("failed to create PPRL record for %s: %w", recordID, err)
+			return err
 		}
-
-		// Decode the Bloom filter to compute MinHash from it
-		bf, err := pprl.BloomFromBase64(pprlRecord.BloomData)
-		if err != nil {
-			return fmt.Errorf("failed to decode Bloom filter for %s: %w", recordID, err)
-		}
-
-		// Create deterministic MinHash with shared seed for consistent signatures across parties
-		mh, err := pprl.NewMinHashSeeded(recordConfig.BloomSize, recordConfig.MinHashSize, "cohort-bridge-pprl-seed")
-		if err != nil {
-			return fmt.Errorf("failed to create MinHash for %s: %w", recordID, err)
-		}
-
-		// Compute the signature directly from the Bloom filter
-		_, err = mh.ComputeSignature(bf)
-		if err != nil {
-			return fmt.Errorf("failed to compute MinHash signature for %s: %w", recordID, err)
-		}
-
-		// Convert to CSV format
-		timestamp := time.Now().Format("2006-01-02T15:04:05Z")
-
-		// Encode the complete MinHash object to base64
-		minHashBase64, err := mh.ToBase64()
-		if err != nil {
-			return fmt.Errorf("failed to encode MinHash to base64 for %s: %w", recordID, err)
-		}
-
-		csvRow := []string{
-			recordID,             // KEEP ORIGINAL ID for validation matching
-			pprlRecord.BloomData, // Already base64 encoded
-			minHashBase64,        // Properly base64 encoded MinHash
-			timestamp,
+		if csvRow == nil {
+			continue // Skip records with no data in specified fields
 		}
 
 		if err := writer.Write(csvRow); err != nil {
-			return fmt.Errorf("failed to write CSV row for %s: %w", recordID, err)
+			return fmt.Errorf("failed to write CSV row for %s: %w", csvRow[0], err)
 		}
 
 		processedCount++
 	}
 
-	return nil
+	if err := tokenizer.reportSanitization(nil); err != nil {
+		return err
+	}
+	return tokenizer.reportDensity(nil)
 }
 
 // loadTokenizedDataForValidation - exact copy of loadTokenizedData from pprl.go
@@ -857,6 +1295,7 @@ func loadTokenizedDataForValidation(filename string) (*TokenDataValidation, erro
 	}
 
 	tokenData := &TokenDataValidation{Records: make(map[string]TokenRecordValidation)}
+	blockingIndex := slices.Index(records[0], blockingColumn)
 
 	// Skip header row
 	for i := 1; i < len(records); i++ {
@@ -870,6 +1309,9 @@ func loadTokenizedDataForValidation(filename string) (*TokenDataValidation, erro
 			BloomFilter: record[1],
 			MinHash:     record[2],
 		}
+		if blockingIndex >= 0 && blockingIndex < len(record) {
+			tokenRecord.Blocking = record[blockingIndex]
+		}
 
 		tokenData.Records[tokenRecord.ID] = tokenRecord
 	}
@@ -899,6 +1341,8 @@ func tokenDataToPPRLRecordsForValidation(tokenData *TokenDataValidation) ([]*ppr
 			BloomData: tokenRecord.BloomFilter,
 			MinHash:   minHashSig,
 			QGramData: "", // Not used in workflow
+
+			BlockingKeys: pprl.SplitBlockingKeys(tokenRecord.Blocking),
 		}
 
 		records = append(records, record)
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/db"
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+)
+
+// runCurveAnalysis scores every record pair against ground truth, reports ROC and
+// PR AUC for each score and optionally exports the curve points
+func runCurveAnalysis(records1, records2 []*pprl.Record, truth groundTruth, calibration *match.Calibration, curvesFile string, distanceScale uint32) error {
+	fmt.Println("Computing ROC and precision-recall curves...")
+	pairs, scale, err := scoreValidationPairs(records1, records2, truth, distanceScale)
+	if err != nil {
+		return err
+	}
+
+	curves := match.PairScoreCurves(pairs, scale, calibration)
+	fmt.Printf("   Scored %d comparisons (%d matches, %d non-matches)\n", len(pairs), curves[0].Positives, curves[0].Negatives)
+	fmt.Println("   Score                ROC AUC  PR AUC  Match mean  Non-match mean")
+	for _, c := range curves {
+		fmt.Printf("   %-19s  %7.4f  %6.4f  %10.4f  %14.4f\n", c.Score, c.ROCAUC, c.AveragePrecision, c.MatchMean, c.NonMatchMean)
+	}
+
+	if curvesFile == "" {
+		return nil
+	}
+	if dir := filepath.Dir(curvesFile); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	if strings.EqualFold(filepath.Ext(strings.TrimSuffix(curvesFile, ".gz")), ".json") {
+		err = writeCurvesJSON(curves, curvesFile)
+	} else {
+		err = writeCurvesCSV(curves, curvesFile)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write curves: %w", err)
+	}
+	fmt.Printf("   Curve points saved to: %s\n", curvesFile)
+	porcelain.output(curvesFile)
+	return nil
+}
+
+// writeCurvesJSON writes all curves with their summary statistics as JSON
+func writeCurvesJSON(curves []*match.Curves, filename string) error {
+	data, err := json.MarshalIndent(curves, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := db.CreateOutput(filename)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// writeCurvesCSV writes one row per curve point; recall equals tpr, so the same
+// rows plot both the ROC (fpr, tpr) and PR (recall, precision) curves
+func writeCurvesCSV(curves []*match.Curves, filename string) error {
+	file, err := db.CreateOutput(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"score", "threshold", "true_positives", "false_positives", "tpr", "fpr", "precision", "recall"})
+	for _, c := range curves {
+		for _, p := range c.Points {
+			writer.Write([]string{
+				c.Score,
+				strconv.FormatFloat(p.Threshold, 'f', 6, 64),
+				strconv.Itoa(p.TP),
+				strconv.Itoa(p.FP),
+				strconv.FormatFloat(p.TPR, 'f', 6, 64),
+				strconv.FormatFloat(p.FPR, 'f', 6, 64),
+				strconv.FormatFloat(p.Precision, 'f', 6, 64),
+				strconv.FormatFloat(p.TPR, 'f', 6, 64),
+			})
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
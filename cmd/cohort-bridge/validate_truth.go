@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+)
+
+// truthFile is one of several ground truth files, such as a site's or a year's gold standard
+type truthFile struct {
+	path  string
+	truth groundTruth
+}
+
+// truthFileResult holds the metrics of one ground truth file
+type truthFileResult struct {
+	path   string
+	total  int // True pairs the file lists
+	result *ValidationResult
+}
+
+// expandGroundTruthFiles turns a -ground-truth value into the files it names: a comma-separated
+// list of paths, each of which may be a glob such as "truth/site_*.csv". A file named twice is
+// read once.
+func expandGroundTruthFiles(spec string) ([]string, error) {
+	var files []string
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.ContainsAny(entry, "*?[") {
+			if _, err := os.Stat(entry); err != nil {
+				return nil, fmt.Errorf("ground truth file not found: %s", entry)
+			}
+			if !slices.Contains(files, entry) {
+				files = append(files, entry)
+			}
+			continue
+		}
+		matches, err := filepath.Glob(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ground truth pattern %q: %w", entry, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no ground truth file matches %s", entry)
+		}
+		for _, path := range matches {
+			if !slices.Contains(files, path) {
+				files = append(files, path)
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no ground truth file given")
+	}
+	return files, nil
+}
+
+// loadGroundTruthFiles loads every ground truth file with its IDs keyed like the datasets' record
+// IDs, and pools their pairs; a pair listed in several files counts once in the pool
+func loadGroundTruthFiles(paths []string, ids1, ids2 *pprl.IDColumns) ([]truthFile, groundTruth, error) {
+	files := make([]truthFile, 0, len(paths))
+	pooled := make(groundTruth)
+	for _, path := range paths {
+		if len(paths) > 1 {
+			fmt.Printf("  %s\n", path)
+		}
+		truth, err := loadGroundTruth(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+		truth = truth.keyed(ids1, ids2)
+		for pair := range truth {
+			pooled[pair] = true
+		}
+		files = append(files, truthFile{path: path, truth: truth})
+	}
+	if len(files) > 1 {
+		listed := 0
+		for _, file := range files {
+			listed += len(file.truth)
+			fmt.Printf("   %d ground truth matches in %s\n", len(file.truth), file.path)
+		}
+		if listed > len(pooled) {
+			fmt.Printf("   %d pairs are listed in several files and count once when pooled\n", listed-len(pooled))
+		}
+	}
+	return files, pooled, nil
+}
+
+// validateTruthFiles scores the matches against each ground truth file. A file judges the matches
+// involving a record it lists, on either side, so another site's matches are not counted as its
+// false positives; matches of records no file lists count in the pooled metrics only.
+func validateTruthFiles(matches []*match.PrivateMatchResult, files []truthFile) []truthFileResult {
+	results := make([]truthFileResult, 0, len(files))
+	for _, file := range files {
+		listed1, listed2 := make(map[string]bool), make(map[string]bool)
+		for pair := range file.truth {
+			listed1[pair.ID1] = true
+			listed2[pair.ID2] = true
+		}
+		var judged []*match.PrivateMatchResult
+		for _, m := range matches {
+			if listed1[m.LocalID] || listed2[m.PeerID] {
+				judged = append(judged, m)
+			}
+		}
+		results = append(results, truthFileResult{
+			path:   file.path,
+			total:  len(file.truth),
+			result: validateResults(judged, nil, file.truth),
+		})
+	}
+	return results
+}
+
+// printTruthFileResults prints the metrics of each ground truth file
+func printTruthFileResults(results []truthFileResult) {
+	fmt.Println("\nPer Ground Truth File:")
+	fmt.Println("   File                            TP     FP     FN  Precision  Recall     F1")
+	for _, r := range results {
+		fmt.Printf("   %-30s %5d  %5d  %5d  %9.3f  %6.3f  %5.3f\n", filepath.Base(r.path),
+			r.result.TruePositives, r.result.FalsePositives, r.result.FalseNegatives,
+			r.result.Precision, r.result.Recall, r.result.F1Score)
+	}
+}
+
+// writeTruthFileResults adds the metrics of each ground truth file to a validation report
+func writeTruthFileResults(writer *csv.Writer, results []truthFileResult) {
+	writer.Write([]string{""})
+	writer.Write([]string{"=== PER GROUND TRUTH FILE ==="})
+	writer.Write([]string{"ground_truth", "true_positives", "false_positives", "false_negatives", "total_ground_truth",
+		"precision", "recall", "f1_score", "cluster_precision", "cluster_recall", "cluster_f1_score"})
+	for _, r := range results {
+		writer.Write([]string{
+			r.path,
+			strconv.Itoa(r.result.TruePositives),
+			strconv.Itoa(r.result.FalsePositives),
+			strconv.Itoa(r.result.FalseNegatives),
+			strconv.Itoa(r.total),
+			fmt.Sprintf("%.6f", r.result.Precision),
+			fmt.Sprintf("%.6f", r.result.Recall),
+			fmt.Sprintf("%.6f", r.result.F1Score),
+			fmt.Sprintf("%.6f", r.result.Clusters.Precision),
+			fmt.Sprintf("%.6f", r.result.Clusters.Recall),
+			fmt.Sprintf("%.6f", r.result.Clusters.F1),
+		})
+	}
+}
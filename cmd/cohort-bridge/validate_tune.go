@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/db"
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+)
+
+// tuneOptions configures threshold auto-tuning in validate
+type tuneOptions struct {
+	HammingGrid  string  // start:end:step
+	JaccardGrid  string  // start:end:step
+	Criterion    string  // f1 or precision
+	MinPrecision float64 // Used by the precision criterion
+	ConfigOut    string  // Recommended config snippet path
+}
+
+// runThresholdTuning sweeps the threshold grids against ground truth, writes the
+// precision/recall/F1 surface to surfaceFile and a recommended config snippet
+func runThresholdTuning(records1, records2 []*pprl.Record, truth groundTruth, assignment, surfaceFile string, opts *tuneOptions, distanceScale uint32) error {
+	hammingGrid, err := parseHammingGrid(opts.HammingGrid)
+	if err != nil {
+		return err
+	}
+	jaccardGrid, err := parseJaccardGrid(opts.JaccardGrid)
+	if err != nil {
+		return err
+	}
+	if opts.Criterion != match.TuneMaxF1 && opts.Criterion != match.TuneMinPrecision {
+		return fmt.Errorf("unknown tuning criterion %q (expected %s or %s)", opts.Criterion, match.TuneMaxF1, match.TuneMinPrecision)
+	}
+
+	fmt.Println("Tuning thresholds against ground truth...")
+	fmt.Printf("  Hamming grid: %d values (%s)\n", len(hammingGrid), opts.HammingGrid)
+	fmt.Printf("  Jaccard grid: %d values (%s)\n", len(jaccardGrid), opts.JaccardGrid)
+
+	pairs, _, err := scoreValidationPairs(records1, records2, truth, distanceScale)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  Scored %d record pairs\n", len(pairs))
+
+	points := match.TuneThresholds(pairs, len(truth), hammingGrid, jaccardGrid, assignment)
+	if err := writeTuningSurface(points, surfaceFile); err != nil {
+		return fmt.Errorf("failed to write tuning surface: %w", err)
+	}
+	fmt.Printf("  Precision/recall/F1 surface (%d points) saved to: %s\n", len(points), surfaceFile)
+	porcelain.output(surfaceFile)
+	fmt.Println()
+
+	ranked := match.RankOperatingPoints(points, opts.Criterion, opts.MinPrecision)
+	if opts.Criterion == match.TuneMinPrecision {
+		fmt.Printf("Top operating points (max recall with precision >= %.3f):\n", opts.MinPrecision)
+	} else {
+		fmt.Println("Top operating points (max F1):")
+	}
+	fmt.Println("   Hamming  Jaccard  Precision  Recall     F1   TP   FP   FN")
+	for i, p := range ranked {
+		if i >= 10 {
+			break
+		}
+		fmt.Printf("   %7d  %7.3f  %9.3f  %6.3f  %5.3f  %3d  %3d  %3d\n", p.HammingThreshold, p.JaccardThreshold,
+			p.Precision, p.Recall, p.F1, p.TruePositives, p.FalsePositives, p.FalseNegatives)
+	}
+	fmt.Println()
+
+	best, err := match.BestOperatingPoint(points, opts.Criterion, opts.MinPrecision)
+	if err != nil {
+		return err
+	}
+
+	snippet := fmt.Sprintf(`# Recommended by 'cohort-bridge validate -tune' (criterion: %s)
+# Precision %.3f, recall %.3f, F1 %.3f on %d ground truth matches
+matching:
+  hamming_threshold: %d
+  jaccard_threshold: %s
+  assignment: %s
+`, tuneCriterionLabel(opts), best.Precision, best.Recall, best.F1, len(truth),
+		best.HammingThreshold, strconv.FormatFloat(best.JaccardThreshold, 'f', -1, 64), assignment)
+
+	configOut := opts.ConfigOut
+	if configOut == "" {
+		configOut = strings.TrimSuffix(surfaceFile, filepath.Ext(surfaceFile)) + "_recommended.yaml"
+	}
+	if err := os.WriteFile(configOut, []byte(snippet), 0644); err != nil {
+		return fmt.Errorf("failed to write recommended config: %w", err)
+	}
+
+	fmt.Println("Recommended configuration:")
+	fmt.Println()
+	for _, line := range strings.Split(strings.TrimRight(snippet, "\n"), "\n") {
+		fmt.Printf("   %s\n", line)
+	}
+	fmt.Println()
+	fmt.Printf("Recommended config snippet saved to: %s\n", configOut)
+	porcelain.output(configOut)
+	return nil
+}
+
+// tuneCriterionLabel describes the tuning criterion for the config snippet
+func tuneCriterionLabel(opts *tuneOptions) string {
+	if opts.Criterion == match.TuneMinPrecision {
+		return fmt.Sprintf("max recall at precision >= %.3f", opts.MinPrecision)
+	}
+	return "max F1"
+}
+
+// writeTuningSurface writes every evaluated operating point as CSV
+func writeTuningSurface(points []match.OperatingPoint, filename string) error {
+	if dir := filepath.Dir(filename); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	file, err := db.CreateOutput(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"hamming_threshold", "jaccard_threshold", "true_positives", "false_positives",
+		"false_negatives", "precision", "recall", "f1"})
+	for _, p := range points {
+		writer.Write([]string{
+			strconv.FormatUint(uint64(p.HammingThreshold), 10),
+			strconv.FormatFloat(p.JaccardThreshold, 'f', 3, 64),
+			strconv.Itoa(p.TruePositives),
+			strconv.Itoa(p.FalsePositives),
+			strconv.Itoa(p.FalseNegatives),
+			strconv.FormatFloat(p.Precision, 'f', 4, 64),
+			strconv.FormatFloat(p.Recall, 'f', 4, 64),
+			strconv.FormatFloat(p.F1, 'f', 4, 64),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// parseGridSpec parses a start:end:step range
+func parseGridSpec(spec string) (float64, float64, float64, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 3 {
+		return 0, 0, 0, fmt.Errorf("invalid grid %q (expected start:end:step)", spec)
+	}
+	var values [3]float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid grid %q: %v", spec, err)
+		}
+		values[i] = v
+	}
+	if values[2] <= 0 || values[1] < values[0] {
+		return 0, 0, 0, fmt.Errorf("invalid grid %q (step must be positive and end >= start)", spec)
+	}
+	return values[0], values[1], values[2], nil
+}
+
+// parseHammingGrid expands a start:end:step range of Hamming thresholds
+func parseHammingGrid(spec string) ([]uint32, error) {
+	start, end, step, err := parseGridSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("invalid Hamming grid %q (must be non-negative)", spec)
+	}
+	var grid []uint32
+	for i := 0; ; i++ {
+		v := start + float64(i)*step
+		if v > end+1e-9 {
+			break
+		}
+		grid = append(grid, uint32(v+0.5))
+	}
+	return grid, nil
+}
+
+// parseJaccardGrid expands a start:end:step range of Jaccard thresholds
+func parseJaccardGrid(spec string) ([]float64, error) {
+	start, end, step, err := parseGridSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 || end > 1 {
+		return nil, fmt.Errorf("invalid Jaccard grid %q (must be within 0-1)", spec)
+	}
+	var grid []float64
+	for i := 0; ; i++ {
+		v := start + float64(i)*step
+		if v > end+1e-9 {
+			break
+		}
+		grid = append(grid, float64(int(v*1000+0.5))/1000)
+	}
+	return grid, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/server"
+)
+
+// Workspaces are created as <workspace.dir>/cohort-bridge-<purpose>-<random>, with a marker file
+// naming the process that owns them
+const (
+	workspacePrefix = "cohort-bridge-"
+	workspaceMarker = ".cohort-bridge-workspace"
+)
+
+// legacyWorkspacePatterns match the temporary files earlier releases left in the working directory
+var legacyWorkspacePatterns = []string{"temp-workflow-*", "temp-sender", "temp_validation_tokens_*.csv"}
+
+// workspace is a per-run directory for intermediate files (tokens, intersections, downloaded
+// inputs). Its files may hold token data, so they are overwritten before they are removed.
+type workspace struct {
+	Dir  string
+	keep bool
+}
+
+// workspaceMarkerInfo is the content of a workspace's marker file
+type workspaceMarkerInfo struct {
+	PID     int       `json:"pid"`
+	Command string    `json:"command"`
+	Created time.Time `json:"created"`
+}
+
+// newWorkspace creates a workspace under workspace.dir for purpose (the command, or what the
+// files are for). It is kept after the run when workspace.keep is set or in debug mode.
+func newWorkspace(cfg *config.Config, purpose string) (*workspace, error) {
+	root, err := workspaceRoot(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create workspace root: %w", err)
+	}
+	dir, err := os.MkdirTemp(root, workspacePrefix+purpose+"-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+
+	marker, err := json.Marshal(workspaceMarkerInfo{PID: os.Getpid(), Command: purpose, Created: time.Now().UTC()})
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, workspaceMarker), append(marker, '\n'), 0600)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to mark workspace: %w", err)
+	}
+	return &workspace{Dir: dir, keep: (cfg != nil && cfg.Workspace.Keep) || isDebugMode()}, nil
+}
+
+// workspaceRoot returns the absolute directory workspaces are created in
+func workspaceRoot(cfg *config.Config) (string, error) {
+	root := os.TempDir()
+	if cfg != nil && cfg.Workspace.Dir != "" {
+		root = cfg.Workspace.Dir
+	}
+	return filepath.Abs(root)
+}
+
+// Path returns the path of a file in the workspace
+func (w *workspace) Path(name string) string {
+	return filepath.Join(w.Dir, name)
+}
+
+// Close removes the workspace unless it is to be kept, in which case it says where it is
+func (w *workspace) Close() {
+	if w.keep {
+		fmt.Printf("Workspace kept (it may hold token data; remove it with 'cohort-bridge clean'): %s\n", w.Dir)
+		return
+	}
+	if err := w.Remove(); err != nil {
+		fmt.Printf("Warning: failed to remove workspace %s: %v\n", w.Dir, err)
+	}
+}
+
+// Remove securely deletes the workspace, whether or not it is to be kept
+func (w *workspace) Remove() error {
+	return secureRemoveAll(w.Dir)
+}
+
+// secureRemoveAll overwrites every file under path with secureDeleteFile, then removes what is left
+func secureRemoveAll(path string) error {
+	var failed []string
+	err := filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.Type().IsRegular() {
+			if err := secureDeleteFile(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				failed = append(failed, file)
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("removed without overwriting: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// workspaceEntry is a workspace found by clean
+type workspaceEntry struct {
+	Path    string
+	Command string
+	PID     int // Owning process (0 for the files of earlier releases)
+	Created time.Time
+	Size    int64
+}
+
+// active reports whether the process that created the workspace is still running
+func (e workspaceEntry) active() bool {
+	return e.PID != 0 && e.PID != os.Getpid() && server.ProcessRunning(e.PID)
+}
+
+// findWorkspaces lists the workspaces under root and the leftovers of earlier releases in legacyDir
+func findWorkspaces(root, legacyDir string) ([]workspaceEntry, error) {
+	var found []workspaceEntry
+	matches, err := filepath.Glob(filepath.Join(root, workspacePrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range matches {
+		data, err := os.ReadFile(filepath.Join(dir, workspaceMarker))
+		if err != nil {
+			continue // Not a workspace, or not ours to read
+		}
+		var marker workspaceMarkerInfo
+		if err := json.Unmarshal(data, &marker); err != nil {
+			continue
+		}
+		found = append(found, workspaceEntry{Path: dir, Command: marker.Command, PID: marker.PID, Created: marker.Created, Size: diskUsage(dir)})
+	}
+
+	for _, pattern := range legacyWorkspacePatterns {
+		matches, err := filepath.Glob(filepath.Join(legacyDir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range matches {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			found = append(found, workspaceEntry{Path: path, Command: "legacy", Created: info.ModTime(), Size: diskUsage(path)})
+		}
+	}
+	return found, nil
+}
+
+// diskUsage returns the total size of the files under path
+func diskUsage(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,152 @@
+// manifest.go
+// Package batch reads manifests listing several PPRL runs, such as the same linkage over a series
+// of monthly extracts, that 'cohort-bridge batch' executes one after another or in parallel.
+package batch
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Run is one linkage of a manifest. Empty settings are taken from the manifest's defaults.
+type Run struct {
+	Name             string  `yaml:"name"`              // Identifies the run in the report (default run-N)
+	Config           string  `yaml:"config"`            // Configuration file the run starts from
+	Input            string  `yaml:"input"`             // Local dataset (overrides database.filename)
+	Peer             string  `yaml:"peer"`              // Peer address host:port (overrides peer.host and peer.port)
+	ListenPort       int     `yaml:"listen_port"`       // Local listen port (overrides listen_port)
+	Output           string  `yaml:"output"`            // Directory receiving the results (default batch/<name>)
+	HammingThreshold uint32  `yaml:"hamming_threshold"` // Overrides matching.hamming_threshold
+	JaccardThreshold float64 `yaml:"jaccard_threshold"` // Overrides matching.jaccard_threshold
+}
+
+// Manifest lists the runs of a batch
+type Manifest struct {
+	Concurrency     int    `yaml:"concurrency"`       // Runs executed at once (default 1)
+	ContinueOnError *bool  `yaml:"continue_on_error"` // Start the remaining runs after a failure (default true)
+	Report          string `yaml:"report"`            // Summary report written after the batch (JSON)
+	Defaults        Run    `yaml:"defaults"`          // Settings shared by every run
+	Runs            []Run  `yaml:"runs"`
+}
+
+// Load reads the manifest at path, fills each run from the defaults and checks it. Relative
+// paths in the manifest are relative to the manifest's directory.
+func Load(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var m Manifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+	if err := m.resolve(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// StopOnError reports whether a failed run cancels the runs not yet started
+func (m *Manifest) StopOnError() bool {
+	return m.ContinueOnError != nil && !*m.ContinueOnError
+}
+
+// resolve applies the defaults, makes paths absolute against dir and checks the runs
+func (m *Manifest) resolve(dir string) error {
+	if len(m.Runs) == 0 {
+		return fmt.Errorf("manifest lists no runs")
+	}
+	if m.Concurrency <= 0 {
+		m.Concurrency = 1
+	}
+	if m.Report != "" {
+		m.Report = absolute(dir, m.Report)
+	}
+
+	names := make(map[string]bool)
+	outputs := make(map[string]string)
+	ports := make(map[int]string)
+	for i := range m.Runs {
+		run := &m.Runs[i]
+		run.inherit(m.Defaults)
+		if run.Name == "" {
+			run.Name = fmt.Sprintf("run-%d", i+1)
+		}
+		if names[run.Name] {
+			return fmt.Errorf("duplicate run name %q", run.Name)
+		}
+		names[run.Name] = true
+
+		if run.Config == "" {
+			return fmt.Errorf("run %s: no config (set config on the run or in defaults)", run.Name)
+		}
+		if run.Peer != "" {
+			if _, port, err := net.SplitHostPort(run.Peer); err != nil {
+				return fmt.Errorf("run %s: invalid peer %q: %w", run.Name, run.Peer, err)
+			} else if _, err := strconv.Atoi(port); err != nil {
+				return fmt.Errorf("run %s: invalid peer port %q", run.Name, port)
+			}
+		}
+		if run.Output == "" {
+			run.Output = filepath.Join("batch", run.Name)
+		}
+		run.Config = absolute(dir, run.Config)
+		run.Output = absolute(dir, run.Output)
+		if run.Input != "" {
+			run.Input = absolute(dir, run.Input)
+		}
+
+		// Runs writing to one directory would overwrite each other's results and checkpoints
+		if other, ok := outputs[run.Output]; ok {
+			return fmt.Errorf("runs %s and %s write to the same output %s", other, run.Name, run.Output)
+		}
+		outputs[run.Output] = run.Name
+
+		// Parallel runs cannot listen on the same port
+		if m.Concurrency > 1 && run.ListenPort != 0 {
+			if other, ok := ports[run.ListenPort]; ok {
+				return fmt.Errorf("runs %s and %s listen on port %d, which needs concurrency 1", other, run.Name, run.ListenPort)
+			}
+			ports[run.ListenPort] = run.Name
+		}
+	}
+	return nil
+}
+
+// inherit fills the settings of r left empty from defaults; names and outputs are per run
+func (r *Run) inherit(defaults Run) {
+	if r.Config == "" {
+		r.Config = defaults.Config
+	}
+	if r.Input == "" {
+		r.Input = defaults.Input
+	}
+	if r.Peer == "" {
+		r.Peer = defaults.Peer
+	}
+	if r.ListenPort == 0 {
+		r.ListenPort = defaults.ListenPort
+	}
+	if r.HammingThreshold == 0 {
+		r.HammingThreshold = defaults.HammingThreshold
+	}
+	if r.JaccardThreshold == 0 {
+		r.JaccardThreshold = defaults.JaccardThreshold
+	}
+}
+
+// absolute resolves path against dir unless it is already absolute
+func absolute(dir, path string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
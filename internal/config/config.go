@@ -1,46 +1,371 @@
 package config
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+// TokenizationConfig pins the Bloom filter / MinHash recipe used to tokenize records.
+// Both parties must use identical values for their tokens to be comparable.
+type TokenizationConfig struct {
+	BloomSize   uint32  `yaml:"bloom_size"`   // Size of Bloom filter in bits
+	BloomHashes uint32  `yaml:"bloom_hashes"` // Number of hash functions for Bloom filter
+	QGramLength int     `yaml:"qgram_length"` // Length of q-grams
+	Padding     string  `yaml:"padding"`      // Padding character for q-grams
+	Noise       float64 `yaml:"noise"`        // Probability of noise in Bloom filter (0-1)
+	Epsilon     float64 `yaml:"epsilon"`      // BLIP differential-privacy budget per bit (0 disables; replaces noise)
+	MinHashSize uint32  `yaml:"minhash_size"` // Size of MinHash signature
+	Seed        string  `yaml:"seed"`         // Seed for deterministic MinHash generation
+
+	// DateWeight scales the hash functions used for "birthdate:" fields relative to the other fields,
+	// setting the birthdate's share of the record's similarity (default 1)
+	DateWeight float64 `yaml:"date_weight"`
+
+	// Encoding selects clk (default: all fields hashed into one filter) or rbf (record-level Bloom
+	// filter: one filter per field, sampled into the record filter in proportion to FieldWeights,
+	// keyed by field name; unlisted fields weigh 1)
+	Encoding     string             `yaml:"encoding"`
+	FieldWeights map[string]float64 `yaml:"field_weights"`
+
+	// Nickname expansion for name fields: the built-in dictionary (Bill/William, Peggy/Margaret, ...)
+	// and/or a file of "canonical,variant,..." lines. Both parties must use the same dictionaries.
+	Nicknames    bool   `yaml:"nicknames"`
+	NicknameFile string `yaml:"nickname_file"`
+
+	// Unicode sets how non-ASCII text is normalized: ascii (default: letters outside a-z are
+	// dropped) or fold (case folding, diacritic stripping and transliteration, so García matches
+	// Garcia). Locale adds a language's spelling of accented letters (de: ü as ue) and
+	// TransliterationFile a list of "from,to" rules; both need fold.
+	Unicode             string `yaml:"unicode"`
+	Locale              string `yaml:"locale"`
+	TransliterationFile string `yaml:"transliteration_file"`
+
+	// MissingData sets how records with an empty field are tokenized: ignore (default), skip,
+	// redistribute or impute:VALUE, with per-field overrides keyed by field name
+	MissingData struct {
+		Default string            `yaml:"default"`
+		Fields  map[string]string `yaml:"fields"`
+	} `yaml:"missing_data"`
+
+	// Blocking lists blocking key expressions such as "zip3+birth_year" or "soundex(last_name)+year(dob)".
+	// Tokenization adds a hashed key per expression, and only records sharing a key with a peer
+	// record are compared; each expression is a blocking pass, so a pair needs to share only one.
+	Blocking []string `yaml:"blocking"`
+
+	// Hardening lists post-processing steps applied, in order, to every finished filter against
+	// frequency attacks: balance (append the complement and permute, doubling the size), xor_fold
+	// (XOR the two halves, halving it) and rule90 (XOR of each bit's neighbours). Matchers scale
+	// the Hamming threshold by the factor the steps multiply distances by.
+	Hardening []string `yaml:"hardening"`
+
+	// ExactID names a source column holding an identifier both parties share exactly, such as a
+	// hashed SSN. Tokenization adds its letters and digits, keyed with the linkage secret, to each
+	// record for matching.protocol psi and matching.exact_first_pass; it is never sent to the peer.
+	ExactID string `yaml:"exact_id"`
+
+	// MaxDensity is the highest mean share of Bloom filter bits set before tokenization warns that
+	// the filters are saturating (default 0.6). It is checked locally and is not part of the recipe.
+	MaxDensity float64 `yaml:"max_density"`
+
+	// LinkageSecretFile points to the shared per-project secret that keys Bloom filter hashing.
+	// Keep it outside the data directory; it must never be stored alongside token files.
+	LinkageSecretFile string `yaml:"linkage_secret_file"`
+
+	// LinkageSecretProvider derives the linkage secret in a PKCS#11 token or KMS (pkcs11 or kms,
+	// configured in the keys section) instead of reading linkage_secret_file, so the secret is
+	// never stored in a file. Both parties' providers must hold the same HMAC key.
+	LinkageSecretProvider string `yaml:"linkage_secret_provider"`
+
+	// IDMode sets the record IDs written to tokens: preserve (default), pseudonymize (random) or
+	// hmac (keyed with IDSecretFile, which must not be the linkage secret). Pseudonyms are mapped
+	// back to the original IDs in IDMapFile, which stays local. IDs are not part of the recipe.
+	IDMode       string `yaml:"id_mode"`
+	IDMapFile    string `yaml:"id_map_file"`    // Reversible original_id,pseudonym mapping (default out/id_map.csv)
+	IDSecretFile string `yaml:"id_secret_file"` // Local key of hmac pseudonyms
+}
+
+// KeysConfig selects where encryption keys come from and configures the providers that hold
+// master keys outside the process (PKCS#11 tokens and KMSs)
+type KeysConfig struct {
+	Source          string        `yaml:"source"`           // Encryption key source: file (default), env, keyring, keychain, kms, pkcs11
+	KeyringDir      string        `yaml:"keyring_dir"`      // Directory keyring used when source is keyring
+	EnvVar          string        `yaml:"env_var"`          // Environment variable holding a hex key when source is env
+	KeychainService string        `yaml:"keychain_service"` // OS keychain service name
+	MaxAge          time.Duration `yaml:"max_age"`          // Rotate the active keyring key once it is older than this
+	KMSEndpoint     string        `yaml:"kms_endpoint"`     // KMS endpoint for envelope encryption when source is kms
+	KMSKeyID        string        `yaml:"kms_key_id"`       // Master key ID at the KMS
+	KMSHMACKeyID    string        `yaml:"kms_hmac_key_id"`  // HMAC key ID at the KMS, for secrets derived by the kms provider
+	PKCS11Module    string        `yaml:"pkcs11_module"`    // PKCS#11 module (shared library) of the HSM or token
+	PKCS11Token     string        `yaml:"pkcs11_token"`     // Token label; the PIN is read from COHORT_PKCS11_PIN
+	PKCS11Key       string        `yaml:"pkcs11_key"`       // Label of the AES key wrapping data keys when source is pkcs11
+	PKCS11HMACKey   string        `yaml:"pkcs11_hmac_key"`  // Label of the HMAC key for secrets derived by the pkcs11 provider
+}
+
+// PostgresSinkConfig is the PostgreSQL database that tokenized records and match results are
+// written to when the output format is postgres
+type PostgresSinkConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"` // Empty falls back to PGPASSWORD or ~/.pgpass
+	DBName   string `yaml:"dbname"`
+	SSLMode  string `yaml:"sslmode"` // libpq sslmode: require (default), verify-full, disable, ...
+
+	Schema       string `yaml:"schema"`        // Schema holding the tables
+	TokensTable  string `yaml:"tokens_table"`  // Table receiving tokenize output
+	MatchesTable string `yaml:"matches_table"` // Table receiving intersect and pprl results
+	BatchSize    int    `yaml:"batch_size"`    // Rows sent per COPY statement
+	CreateTables bool   `yaml:"create_tables"` // Create the tables if they do not exist
+}
+
+// ColumnMapping is the source column of a canonical field and the transforms applied to its
+// values before normalization. In YAML it is either a bare column name or a mapping with column
+// and transforms.
+type ColumnMapping struct {
+	Column     string   `yaml:"column"`
+	Transforms []string `yaml:"transforms"` // trim, upper, lower, squash, digits, letters, alnum, first:N, date:LAYOUT
+}
+
+// UnmarshalYAML accepts the bare column name shorthand
+func (m *ColumnMapping) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		m.Column = node.Value
+		return nil
+	}
+	type plain ColumnMapping
+	return node.Decode((*plain)(m))
+}
+
+// CSVDialect describes a delimited text file. The zero value is RFC 4180: comma-separated,
+// '"'-quoted UTF-8 with a header row.
+type CSVDialect struct {
+	Delimiter string   `yaml:"delimiter"` // Field separator, one character or "tab" (default ",")
+	Quote     string   `yaml:"quote"`     // Quote character, ASCII (default '"')
+	Encoding  string   `yaml:"encoding"`  // Character encoding transcoded to UTF-8: latin1, windows-1252, utf-16le, ... (default utf-8)
+	Header    *bool    `yaml:"header"`    // Whether the first row names the columns (default true)
+	Columns   []string `yaml:"columns"`   // Column names of a file without a header row (default column_1, column_2, ...)
+}
+
+// HasHeader reports whether the first row of the file names the columns
+func (d CSVDialect) HasHeader() bool {
+	return d.Header == nil || *d.Header
+}
+
+// IDColumns names the source columns of record IDs. In YAML it is either one column name or a
+// list of them.
+type IDColumns []string
+
+// UnmarshalYAML accepts a single column name
+func (c *IDColumns) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*c = IDColumns{node.Value}
+		return nil
+	}
+	var columns []string
+	if err := node.Decode(&columns); err != nil {
+		return err
+	}
+	*c = columns
+	return nil
+}
+
 type Config struct {
 	Database struct {
-		Type              string   `yaml:"type"`
-		Host              string   `yaml:"host"`
-		Port              int      `yaml:"port"`
-		User              string   `yaml:"user"`
-		Password          string   `yaml:"password"`
-		DBName            string   `yaml:"dbname"`
-		Table             string   `yaml:"table"`
-		Filename          string   `yaml:"filename"` // Path to data file (raw or tokenized)
-		Fields            []string `yaml:"fields"`   // Field definitions including normalization like "name:FIRST"
-		RandomBitsPercent float64  `yaml:"random_bits_percent"`
-		IsTokenized       bool     `yaml:"is_tokenized"`        // Whether the data is already tokenized
-		EncryptionKey     string   `yaml:"encryption_key"`      // Hex encryption key (optional)
-		EncryptionKeyFile string   `yaml:"encryption_key_file"` // Path to key file (optional)
+		Type     string   `yaml:"type"`
+		Host     string   `yaml:"host"`
+		Port     int      `yaml:"port"`
+		User     string   `yaml:"user"`
+		Password string   `yaml:"password"`
+		DBName   string   `yaml:"dbname"`
+		Table    string   `yaml:"table"`    // Table or view read by the postgres, mysql and sqlserver types
+		Filename string   `yaml:"filename"` // Path to data file (raw or tokenized)
+		Fields   []string `yaml:"fields"`   // Field definitions including normalization like "name:FIRST"
+
+		// CSV is how the csv type's file and raw CSV input to tokenize are written, for partner
+		// extracts that are not comma-separated UTF-8 with a header row
+		CSV CSVDialect `yaml:"csv"`
+
+		// ColumnMapping reads each canonical field from a column of the site's own schema, e.g.
+		// FIRST: given_name. It is local to the site and not part of the tokenization recipe.
+		ColumnMapping map[string]ColumnMapping `yaml:"column_mapping"`
+
+		// IDColumn is the column holding each record's ID (default id). Several columns, such
+		// as an MRN and a facility code, make a composite ID of their values joined with
+		// IDSeparator (default "|"); with IDHash the ID is the SHA-256 of the joined values.
+		IDColumn    IDColumns `yaml:"id_column"`
+		IDSeparator string    `yaml:"id_separator"`
+		IDHash      bool      `yaml:"id_hash"`
+
+		// MultiValueColumns lists source columns whose cells hold several values, such as prior
+		// surnames or earlier addresses, separated by MultiValueDelimiter (default "|"). Arrays in
+		// JSON input are always read this way. Every value is tokenized into the field, so records
+		// match on any of them; the first is the current value, used for blocking keys.
+		MultiValueColumns   []string `yaml:"multi_value_columns"`
+		MultiValueDelimiter string   `yaml:"multi_value_delimiter"`
+
+		// Sanitize bounds the field values that are tokenized. Values longer than MaxLength
+		// characters (default 256, negative for no limit) are truncated or, with OnTooLong reject,
+		// leave their record out; control and invisible formatting characters are stripped unless
+		// StripControl is false. RejectsFile lists what was changed or rejected, without values.
+		Sanitize struct {
+			MaxLength    int    `yaml:"max_length"`
+			StripControl *bool  `yaml:"strip_control"`
+			OnTooLong    string `yaml:"on_too_long"`
+			RejectsFile  string `yaml:"rejects_file"`
+		} `yaml:"sanitize"`
+
+		// WatermarkColumn holds each record's last-modified time. 'tokenize -since' tokenizes only
+		// records modified after a watermark, and the latest one is saved in the token manifest
+		// for the next incremental run.
+		WatermarkColumn string `yaml:"watermark_column"`
+
+		// Reading a clinical database: Query is a SELECT read instead of Table; with ReadOnly every
+		// read runs in a read-only transaction, verified when connecting; StatementTimeout and
+		// MaxRows bound the load a session puts on the server (0 = no limit).
+		Query            string        `yaml:"query"`
+		ReadOnly         bool          `yaml:"read_only"`
+		StatementTimeout time.Duration `yaml:"statement_timeout"`
+		MaxRows          int           `yaml:"max_rows"`
+
+		RandomBitsPercent float64 `yaml:"random_bits_percent"`
+		IsTokenized       bool    `yaml:"is_tokenized"`        // Whether the data is already tokenized
+		EncryptionKey     string  `yaml:"encryption_key"`      // Hex encryption key (optional)
+		EncryptionKeyFile string  `yaml:"encryption_key_file"` // Path to key file (optional)
 	} `yaml:"database"`
 	Matching struct {
 		HammingThreshold uint32  `yaml:"hamming_threshold"` // Hamming distance threshold for matches
 		JaccardThreshold float64 `yaml:"jaccard_threshold"` // Jaccard similarity threshold
+		Assignment       string  `yaml:"assignment"`        // 1:1 assignment algorithm: greedy (default) or hungarian
+		Comparator       string  `yaml:"comparator"`        // Registered pair comparator (default bloom: Bloom filter Hamming distance and MinHash Jaccard)
+		Protocol         string  `yaml:"protocol"`          // pprl: standard (default), smc (Hamming threshold under secure computation) or psi (exact identifiers only); both parties must match
+		ExactFirstPass   bool    `yaml:"exact_first_pass"`  // pprl: link records sharing tokenization.exact_id by PSI first and fuzzy-match only the rest
+
+		CandidateThreshold float64 `yaml:"candidate_threshold"` // MinHash Jaccard estimate required before comparing Bloom filters (0 = jaccard_threshold, negative disables)
+
+		CalibrationFile      string  `yaml:"calibration_file"`      // Calibration model from 'validate -calibrate' (adds match probabilities)
+		ProbabilityThreshold float64 `yaml:"probability_threshold"` // Minimum calibrated probability; replaces distance thresholds when set
+
+		HoldoutFile string `yaml:"holdout_file"` // pprl: local ground truth subset (id1 local, id2 peer) scored after every run, never sent
+
+		MaxMatchesPerRecord int     `yaml:"max_matches_per_record"` // 1:many matching: most matches kept per record (0 = 10, negative = no limit)
+		MinScore            float64 `yaml:"min_score"`              // Score floor: matches below this Jaccard similarity are left out of the results
+
+		Reconcile bool `yaml:"reconcile"` // pprl: re-compare the pairs on which the peers' intersections differ instead of failing
+
+		Clustering struct {
+			Enabled     bool `yaml:"enabled"`       // intersect: resolve matches into entity clusters written beside the results
+			MaxSize     int  `yaml:"max_size"`      // Most records, of both datasets, in one cluster (0 = no limit)
+			MaxPerParty int  `yaml:"max_per_party"` // Most records of one dataset in one cluster (0 = no limit)
+		} `yaml:"clustering"`
 	} `yaml:"matching"`
+	Tokenization TokenizationConfig `yaml:"tokenization"`
+	Output       struct {
+		Columns  []string          `yaml:"columns"`  // intersect result columns: local_id, peer_id, hamming_distance, jaccard_similarity, run_id
+		Format   string            `yaml:"format"`   // intersect result format: csv (default), jsonl or postgres
+		Metadata map[string]string `yaml:"metadata"` // Static columns added to every result row, e.g. site IDs
+
+		Postgres PostgresSinkConfig `yaml:"postgres"` // Database written to by the postgres output format
+	} `yaml:"output"`
 	Peer struct {
-		Host string `yaml:"host"`
-		Port int    `yaml:"port"`
+		Host  string `yaml:"host"`
+		Port  int    `yaml:"port"`
+		Relay string `yaml:"relay"` // relay://host[:port]/session: both parties dial out to a relay instead of each other
+
+		ChunkSizeKB int           `yaml:"chunk_size_kb"` // Size of each checksummed transfer chunk
+		MaxRetries  int           `yaml:"max_retries"`   // Reconnection attempts after a network failure (negative disables resume)
+		RetryDelay  time.Duration `yaml:"retry_delay"`   // Delay before the first reconnection attempt, doubled after each
+		Compression string        `yaml:"compression"`   // Message compression offered to the peer: auto (zstd, gzip), zstd, gzip or none
+
+		PayloadEncryption string `yaml:"payload_encryption"` // Token payloads sealed end to end with keys agreed in the handshake: auto, required or off
+
+		Transport     string `yaml:"transport"`       // Peer protocol: grpc (default) or tcp (legacy JSON over TCP); both parties must match
+		TLSCertFile   string `yaml:"tls_cert_file"`   // gRPC: certificate presented when listening; setting it enables TLS
+		TLSKeyFile    string `yaml:"tls_key_file"`    // gRPC: private key of tls_cert_file
+		TLSCAFile     string `yaml:"tls_ca_file"`     // gRPC: CA that signed the peer's certificate (system roots if empty)
+		TLSServerName string `yaml:"tls_server_name"` // gRPC: name expected in the peer's certificate (default: peer.host)
+
+		APIKey       string   `yaml:"api_key"`       // Pre-shared key both peers must hold; proven with HMAC challenges, never sent
+		APIKeyFile   string   `yaml:"api_key_file"`  // File holding the pre-shared key (overrides api_key)
+		AllowedPeers []string `yaml:"allowed_peers"` // gRPC mTLS: accepted peer certificate CNs, DNS/URI SANs or sha256:<fingerprint>
+
+		SigningKeyFile string `yaml:"signing_key_file"` // Ed25519 private key (PEM) signing the intersection sent to the peer
+		PeerPublicKey  string `yaml:"peer_public_key"`  // Pinned Ed25519 public key (base64); the peer's intersection must verify against it
+
+		PaddingRecords int `yaml:"padding_records"` // Decoy records added to the tokens sent to the peer, hiding the dataset size
+		PaddingJitter  int `yaml:"padding_jitter"`  // Up to this many more decoys, chosen at random per run
 	} `yaml:"peer"`
 	Security struct {
-		RateLimitPerMin int `yaml:"rate_limit_per_min"` // Max connections per minute per IP
+		RateLimitPerMin int           `yaml:"rate_limit_per_min"` // Max dataset submissions per minute per IP
+		RequestsPerMin  int           `yaml:"requests_per_min"`   // Max API requests of any kind per minute per IP
+		MaxConnections  int           `yaml:"max_connections"`    // Max requests served at once; the rest get 503
+		AllowedIPs      []string      `yaml:"allowed_ips"`        // IPs or CIDR ranges allowed to connect to receivers (empty allows all)
+		RequestTimeout  time.Duration `yaml:"request_timeout"`    // Deadline for API requests other than uploads
 	} `yaml:"security"`
+	Serve struct {
+		Listen            string        `yaml:"listen"`              // Address the serve daemon binds to
+		APIKeys           []string      `yaml:"api_keys"`            // Accepted API keys (Authorization: Bearer <key>)
+		APIKeysFile       string        `yaml:"api_keys_file"`       // File with one accepted API key per line
+		TLSCertFile       string        `yaml:"tls_cert_file"`       // Serve HTTPS when both cert and key are set
+		TLSKeyFile        string        `yaml:"tls_key_file"`        // TLS private key
+		JobsDir           string        `yaml:"jobs_dir"`            // Directory holding submitted datasets
+		MaxConcurrentJobs int           `yaml:"max_concurrent_jobs"` // Jobs computed in parallel; the rest wait in the queue
+		MaxUploadMB       int64         `yaml:"max_upload_mb"`       // Largest accepted dataset upload
+		MaxQueuedJobs     int           `yaml:"max_queued_jobs"`     // Jobs held (running or waiting) before new submissions are refused
+		ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`    // How long running jobs may finish after SIGTERM
+		PIDFile           string        `yaml:"pid_file"`            // Process ID file, removed on exit
+
+		// intersect-api only
+		ResultRetention  time.Duration `yaml:"result_retention"`           // How long finished intersections and their results are kept
+		DeleteOnDownload bool          `yaml:"delete_results_on_download"` // Delete results once downloaded
+		ReferenceRoots   []string      `yaml:"reference_roots"`            // Directories and s3://, gs:// or az:// prefixes token files may be referenced from
+	} `yaml:"serve"`
+	Metrics struct {
+		Listen string `yaml:"listen"` // Address of a separate Prometheus /metrics listener (serve also exposes /metrics on its API)
+	} `yaml:"metrics"`
+	Storage struct {
+		S3Region             string `yaml:"s3_region"`              // AWS region of s3:// buckets (default AWS_REGION, then us-east-1)
+		S3Endpoint           string `yaml:"s3_endpoint"`            // S3-compatible endpoint such as MinIO (path-style requests)
+		S3SSE                string `yaml:"s3_sse"`                 // Server-side encryption of uploads: AES256 or aws:kms
+		S3KMSKeyID           string `yaml:"s3_kms_key_id"`          // KMS key for aws:kms
+		GCSKMSKeyName        string `yaml:"gcs_kms_key_name"`       // Cloud KMS key encrypting gs:// uploads
+		AzureEndpoint        string `yaml:"azure_endpoint"`         // Blob endpoint for az:// URLs (default https://<account>.blob.core.windows.net)
+		AzureEncryptionScope string `yaml:"azure_encryption_scope"` // Encryption scope of az:// uploads
+		PartSizeMB           int    `yaml:"part_size_mb"`           // Size of each streamed upload part
+	} `yaml:"storage"`
+	Workspace struct {
+		Dir  string `yaml:"dir"`  // Root of the per-run temporary workspaces (default: the system temp directory)
+		Keep bool   `yaml:"keep"` // Keep workspaces after the run, as -debug does; they hold token data until 'cohort-bridge clean'
+	} `yaml:"workspace"`
+	Keys     KeysConfig `yaml:"keys"`
 	Timeouts struct {
 		ConnectionTimeout time.Duration `yaml:"connection_timeout"` // Connection establishment timeout
 		ReadTimeout       time.Duration `yaml:"read_timeout"`       // Read operation timeout
 		WriteTimeout      time.Duration `yaml:"write_timeout"`      // Write operation timeout
 		IdleTimeout       time.Duration `yaml:"idle_timeout"`       // Connection idle timeout
 		HandshakeTimeout  time.Duration `yaml:"handshake_timeout"`  // Protocol handshake timeout
+
+		// pprl exchange steps; each fails with a timeout naming the step once it runs this long
+		TokenExchange        time.Duration `yaml:"token_exchange"`        // Recipe handshake and token exchange
+		IntersectionExchange time.Duration `yaml:"intersection_exchange"` // Intersection exchange, including the wait while the peer matches
+		HeartbeatInterval    time.Duration `yaml:"heartbeat_interval"`    // How often an idle peer connection proves it is alive (negative disables)
 	} `yaml:"timeouts"`
+	Network struct {
+		Retries int `yaml:"retries"` // Further attempts of a failed peer connection, relay join or object storage request (negative disables)
+		Backoff struct {
+			Initial    time.Duration `yaml:"initial"`    // Delay before the first retry
+			Max        time.Duration `yaml:"max"`        // Longest delay between retries
+			Multiplier float64       `yaml:"multiplier"` // Growth of the delay after each retry
+			Jitter     float64       `yaml:"jitter"`     // Share of each delay randomized, 0 to 1 (negative for none)
+		} `yaml:"backoff"`
+	} `yaml:"network"`
 	Logging struct {
 		Level        string `yaml:"level"`         // Log level: debug, info, warn, error
 		File         string `yaml:"file"`          // Log file path (empty for stdout)
@@ -50,23 +375,200 @@ type Config struct {
 		EnableSyslog bool   `yaml:"enable_syslog"` // Enable syslog output
 		EnableAudit  bool   `yaml:"enable_audit"`  // Enable audit logging for security events
 		AuditFile    string `yaml:"audit_file"`    // Audit log file path
+
+		TranscriptFile string `yaml:"transcript_file"` // Record a digest-only transcript of peer messages (empty to disable)
 	} `yaml:"logging"`
 	ListenPort int `yaml:"listen_port"`
+
+	defaulted map[string]bool // Settings filled in by SetDefaults rather than the config file
 }
 
+// Keys of the settings whose source is reported
+const (
+	keyHammingThreshold = "matching.hamming_threshold"
+	keyJaccardThreshold = "matching.jaccard_threshold"
+)
+
+// DefaultMinHashSeed is the MinHash seed used when none is configured
+const DefaultMinHashSeed = "0PsRm4KNmgRSY8ynApUtpXjeO19S7OUE"
+
+// DefaultMaxDensity is the mean Bloom filter density above which tokenization warns of saturation
+const DefaultMaxDensity = 0.6
+
+// DefaultMaxMatchesPerRecord bounds the matches kept per record under 1:many matching, so results
+// over millions of records stay proportional to the records rather than to the pairs compared
+const DefaultMaxMatchesPerRecord = 10
+
+// Default matching thresholds, used by the config, the command line and the matching protocol alike
+const (
+	DefaultHammingThreshold uint32 = 20   // Maximum Hamming distance between the Bloom filters of a match
+	DefaultJaccardThreshold        = 0.32 // Minimum MinHash Jaccard similarity of a match
+)
+
 // SetDefaults sets reasonable default values for new configuration fields
-func (c *Config) SetDefaults() { // Matching defaults (IMPORTANT: These should match the CLI defaults)
+func (c *Config) SetDefaults() {
 	if c.Matching.HammingThreshold == 0 {
-		c.Matching.HammingThreshold = 20 // Default Hamming threshold
+		c.Matching.HammingThreshold = DefaultHammingThreshold
+		c.markDefaulted(keyHammingThreshold)
 	}
 	if c.Matching.JaccardThreshold == 0 {
-		c.Matching.JaccardThreshold = 0.32 // Default Jaccard threshold
+		c.Matching.JaccardThreshold = DefaultJaccardThreshold
+		c.markDefaulted(keyJaccardThreshold)
+	}
+	if c.Matching.Assignment == "" {
+		c.Matching.Assignment = "greedy" // Default 1:1 assignment algorithm
+	}
+	if c.Matching.Protocol == "" {
+		c.Matching.Protocol = "standard" // Default matching protocol
+	}
+
+	// Tokenization defaults (must be identical for both parties)
+	if c.Tokenization.BloomSize == 0 {
+		c.Tokenization.BloomSize = 1000
+	}
+	if c.Tokenization.BloomHashes == 0 {
+		c.Tokenization.BloomHashes = 5
+	}
+	if c.Tokenization.QGramLength == 0 {
+		c.Tokenization.QGramLength = 2
+	}
+	if c.Tokenization.Padding == "" {
+		c.Tokenization.Padding = "$"
+	}
+	if c.Tokenization.MinHashSize == 0 {
+		c.Tokenization.MinHashSize = 100
+	}
+	if c.Tokenization.Seed == "" {
+		c.Tokenization.Seed = DefaultMinHashSeed
+	}
+	if c.Tokenization.DateWeight == 0 {
+		c.Tokenization.DateWeight = 1
+	}
+	if c.Tokenization.MaxDensity == 0 {
+		c.Tokenization.MaxDensity = DefaultMaxDensity
+	}
+
+	// Result output defaults
+	if len(c.Output.Columns) == 0 {
+		c.Output.Columns = []string{"local_id", "peer_id"}
+	}
+	if c.Output.Format == "" {
+		c.Output.Format = "csv"
+	}
+	if c.Output.Postgres.Port == 0 {
+		c.Output.Postgres.Port = 5432
+	}
+	if c.Output.Postgres.SSLMode == "" {
+		c.Output.Postgres.SSLMode = "require"
+	}
+	if c.Output.Postgres.Schema == "" {
+		c.Output.Postgres.Schema = "public"
+	}
+	if c.Output.Postgres.TokensTable == "" {
+		c.Output.Postgres.TokensTable = "tokenized_records"
+	}
+	if c.Output.Postgres.MatchesTable == "" {
+		c.Output.Postgres.MatchesTable = "match_results"
+	}
+	if c.Output.Postgres.BatchSize == 0 {
+		c.Output.Postgres.BatchSize = 10000
+	}
+
+	// Peer transfer defaults
+	if c.Peer.ChunkSizeKB == 0 {
+		c.Peer.ChunkSizeKB = 1024
+	}
+	if c.Peer.MaxRetries == 0 {
+		c.Peer.MaxRetries = 5
+	}
+	if c.Peer.RetryDelay == 0 {
+		c.Peer.RetryDelay = 2 * time.Second
+	}
+	if c.Peer.Compression == "" {
+		c.Peer.Compression = "auto"
+	}
+	if c.Peer.PayloadEncryption == "" {
+		c.Peer.PayloadEncryption = "auto"
+	}
+	if c.Peer.Transport == "" {
+		c.Peer.Transport = "grpc"
+	}
+
+	// Network retry defaults
+	if c.Network.Retries == 0 {
+		c.Network.Retries = 3
+	}
+	if c.Network.Backoff.Initial == 0 {
+		c.Network.Backoff.Initial = time.Second
+	}
+	if c.Network.Backoff.Max == 0 {
+		c.Network.Backoff.Max = 30 * time.Second
+	}
+	if c.Network.Backoff.Multiplier == 0 {
+		c.Network.Backoff.Multiplier = 2
+	}
+	if c.Network.Backoff.Jitter == 0 {
+		c.Network.Backoff.Jitter = 0.2
 	}
 
 	// Security defaults
 	if c.Security.RateLimitPerMin == 0 {
 		c.Security.RateLimitPerMin = 5
 	}
+	if c.Security.RequestsPerMin == 0 {
+		c.Security.RequestsPerMin = 300
+	}
+	if c.Security.MaxConnections == 0 {
+		c.Security.MaxConnections = 100
+	}
+	if c.Security.RequestTimeout == 0 {
+		c.Security.RequestTimeout = 30 * time.Second
+	}
+
+	// Serve daemon defaults
+	if c.Serve.Listen == "" {
+		c.Serve.Listen = ":8090"
+	}
+	if c.Serve.JobsDir == "" {
+		c.Serve.JobsDir = "jobs"
+	}
+	if c.Serve.MaxConcurrentJobs == 0 {
+		c.Serve.MaxConcurrentJobs = 2
+	}
+	if c.Serve.MaxUploadMB == 0 {
+		c.Serve.MaxUploadMB = 512
+	}
+	if c.Serve.MaxQueuedJobs == 0 {
+		c.Serve.MaxQueuedJobs = 16
+	}
+	if c.Serve.ShutdownTimeout == 0 {
+		c.Serve.ShutdownTimeout = 5 * time.Minute
+	}
+	if c.Serve.ResultRetention == 0 {
+		c.Serve.ResultRetention = 24 * time.Hour
+	}
+
+	// Object storage defaults
+	if c.Storage.PartSizeMB == 0 {
+		c.Storage.PartSizeMB = 16
+	}
+
+	// Key management defaults
+	if c.Keys.Source == "" {
+		c.Keys.Source = "file"
+	}
+	if c.Keys.KeyringDir == "" {
+		c.Keys.KeyringDir = "keys"
+	}
+	if c.Keys.EnvVar == "" {
+		c.Keys.EnvVar = "COHORT_ENCRYPTION_KEY"
+	}
+	if c.Keys.KeychainService == "" {
+		c.Keys.KeychainService = "cohort-bridge"
+	}
+	if c.Keys.MaxAge == 0 {
+		c.Keys.MaxAge = 90 * 24 * time.Hour // 90 days
+	}
 
 	// Timeout defaults
 	if c.Timeouts.ConnectionTimeout == 0 {
@@ -84,6 +586,15 @@ func (c *Config) SetDefaults() { // Matching defaults (IMPORTANT: These should m
 	if c.Timeouts.HandshakeTimeout == 0 {
 		c.Timeouts.HandshakeTimeout = 30 * time.Second
 	}
+	if c.Timeouts.TokenExchange == 0 {
+		c.Timeouts.TokenExchange = 30 * time.Minute
+	}
+	if c.Timeouts.IntersectionExchange == 0 {
+		c.Timeouts.IntersectionExchange = c.Timeouts.IdleTimeout
+	}
+	if c.Timeouts.HeartbeatInterval == 0 {
+		c.Timeouts.HeartbeatInterval = 15 * time.Second
+	}
 
 	// Logging defaults
 	if c.Logging.Level == "" {
@@ -105,6 +616,170 @@ func (c *Config) IsEncrypted() bool {
 	return c.Database.EncryptionKey != "" || c.Database.EncryptionKeyFile != ""
 }
 
+// markDefaulted records that SetDefaults filled in the setting key
+func (c *Config) markDefaulted(key string) {
+	if c.defaulted == nil {
+		c.defaulted = make(map[string]bool)
+	}
+	c.defaulted[key] = true
+}
+
+// RecipeSummary describes the tokenization recipe and normalization methods in a canonical form.
+// The MinHash seed is deliberately left out so the summary can be shown to a peer.
+func (c *Config) RecipeSummary() string {
+	t := c.Tokenization
+	summary := fmt.Sprintf("bloom_size=%d bloom_hashes=%d qgram_length=%d padding=%q noise=%g minhash_size=%d keyed=%t normalization=%s",
+		t.BloomSize, t.BloomHashes, t.QGramLength, t.Padding, t.Noise, t.MinHashSize,
+		t.LinkageSecretFile != "" || t.LinkageSecretProvider != "", strings.Join(c.normalizationMethods(), ","))
+	// Only shown when changed, so recipes from before field weighting keep their fingerprint
+	if t.DateWeight != 0 && t.DateWeight != 1 {
+		summary += fmt.Sprintf(" date_weight=%g", t.DateWeight)
+	}
+	if t.Epsilon > 0 {
+		summary += fmt.Sprintf(" epsilon=%g", t.Epsilon)
+	}
+	if strings.EqualFold(t.Encoding, "rbf") {
+		summary += " encoding=rbf weights=" + strings.Join(c.rbfWeights(), ",")
+	}
+	if nicknames := t.nicknameSources(); len(nicknames) > 0 {
+		summary += " nicknames=" + strings.Join(nicknames, "+")
+	}
+	if missing := t.missingDataStrategies(); len(missing) > 0 {
+		summary += " missing=" + strings.Join(missing, ",")
+	}
+	if unicode := t.unicodeFolding(); unicode != "" {
+		summary += " unicode=" + unicode
+	}
+	if blocking := t.blockingKeys(); len(blocking) > 0 {
+		summary += " blocking=" + strings.Join(blocking, ",")
+	}
+	if hardening := t.HardeningSteps(); len(hardening) > 0 {
+		summary += " hardening=" + strings.Join(hardening, ",")
+	}
+	return summary
+}
+
+// RecipeFingerprint returns a hash of the tokenization recipe, seed and normalization methods.
+// If a linkage secret is in use, a MAC derived from it is mixed in so parties holding
+// different secrets are detected without revealing either secret.
+// Two parties produce comparable tokens only if their fingerprints are equal.
+func (c *Config) RecipeFingerprint(linkageSecret []byte) string {
+	h := sha256.New()
+	h.Write([]byte(c.RecipeSummary() + " seed=" + c.Tokenization.Seed))
+	if len(linkageSecret) > 0 {
+		mac := hmac.New(sha256.New, linkageSecret)
+		mac.Write([]byte("cohort-bridge-linkage-secret-check"))
+		h.Write(mac.Sum(nil))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// nicknameSources names the nickname dictionaries in use; a custom file is identified by a
+// digest of its contents, since its path may differ between parties
+func (t TokenizationConfig) nicknameSources() []string {
+	var sources []string
+	if t.Nicknames {
+		sources = append(sources, "builtin")
+	}
+	if t.NicknameFile != "" {
+		source := "custom"
+		if data, err := os.ReadFile(t.NicknameFile); err == nil {
+			sum := sha256.Sum256(data)
+			source += ":" + hex.EncodeToString(sum[:4])
+		}
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+// unicodeFolding describes the Unicode folding in use ("" for the default ascii mode) as
+// "fold[,locale=LANG][,translit=custom:DIGEST]"; like nickname files, a transliteration file is
+// identified by a digest of its contents
+func (t TokenizationConfig) unicodeFolding() string {
+	if !strings.EqualFold(strings.TrimSpace(t.Unicode), "fold") {
+		return ""
+	}
+	folding := "fold"
+	if locale := strings.ToLower(strings.TrimSpace(t.Locale)); locale != "" {
+		folding += ",locale=" + locale
+	}
+	if t.TransliterationFile != "" {
+		source := "custom"
+		if data, err := os.ReadFile(t.TransliterationFile); err == nil {
+			sum := sha256.Sum256(data)
+			source += ":" + hex.EncodeToString(sum[:4])
+		}
+		folding += ",translit=" + source
+	}
+	return folding
+}
+
+// blockingKeys returns the blocking key expressions, lowercased and without spaces, in order
+func (t TokenizationConfig) blockingKeys() []string {
+	keys := make([]string, 0, len(t.Blocking))
+	for _, expression := range t.Blocking {
+		keys = append(keys, strings.ToLower(strings.Join(strings.Fields(expression), "")))
+	}
+	return keys
+}
+
+// HardeningSteps returns the hardening steps, lowercased and trimmed, in the order they apply
+func (t TokenizationConfig) HardeningSteps() []string {
+	steps := make([]string, 0, len(t.Hardening))
+	for _, step := range t.Hardening {
+		steps = append(steps, strings.ToLower(strings.TrimSpace(step)))
+	}
+	return steps
+}
+
+// missingDataStrategies returns the configured missing-data strategies as sorted "field=strategy"
+// entries, with the default under "*"; ignore is left out as it is the default behavior
+func (t TokenizationConfig) missingDataStrategies() []string {
+	var strategies []string
+	if d := strings.ToLower(strings.TrimSpace(t.MissingData.Default)); d != "" && d != "ignore" {
+		strategies = append(strategies, "*="+d)
+	}
+	for field, strategy := range t.MissingData.Fields {
+		strategies = append(strategies, field+"="+strings.ToLower(strings.TrimSpace(strategy)))
+	}
+	sort.Strings(strategies)
+	return strategies
+}
+
+// rbfWeights returns "method:weight" for each configured field in order. Fields are described by
+// their normalization method rather than their name, which may differ between parties.
+func (c *Config) rbfWeights() []string {
+	weights := make([]string, 0, len(c.Database.Fields))
+	for _, field := range c.Database.Fields {
+		method, name := "basic", strings.TrimSpace(field)
+		if parts := strings.SplitN(field, ":", 2); len(parts) == 2 {
+			method, name = strings.ToLower(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1])
+		}
+		weight := 1.0
+		for key, w := range c.Tokenization.FieldWeights {
+			if strings.EqualFold(key, name) {
+				weight = w
+			}
+		}
+		weights = append(weights, fmt.Sprintf("%s:%g", method, weight))
+	}
+	return weights
+}
+
+// normalizationMethods returns the sorted normalization methods of the configured fields
+func (c *Config) normalizationMethods() []string {
+	methods := make([]string, 0, len(c.Database.Fields))
+	for _, field := range c.Database.Fields {
+		method := "basic"
+		if parts := strings.SplitN(field, ":", 2); len(parts) == 2 {
+			method = strings.ToLower(strings.TrimSpace(parts[0]))
+		}
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
 func Load(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -0,0 +1,47 @@
+// resolved.go
+// The resolved configuration is recorded with every run so its results can be reproduced. Secrets
+// are replaced by a marker: the record says that a password or key was set, not what it was.
+package config
+
+import "gopkg.in/yaml.v3"
+
+// RedactedValue replaces secrets in a resolved configuration
+const RedactedValue = "REDACTED"
+
+// Redacted returns a copy of the configuration with passwords, keys and API keys replaced by
+// RedactedValue, as is the MinHash seed, which the recipe summary leaves out too. Paths to key
+// files are kept.
+func (c *Config) Redacted() *Config {
+	r := *c
+	redact := func(s *string) {
+		if *s != "" {
+			*s = RedactedValue
+		}
+	}
+	redact(&r.Database.Password)
+	redact(&r.Database.EncryptionKey)
+	redact(&r.Output.Postgres.Password)
+	redact(&r.Peer.APIKey)
+	redact(&r.Tokenization.Seed)
+	if len(c.Serve.APIKeys) > 0 {
+		r.Serve.APIKeys = make([]string, len(c.Serve.APIKeys))
+		for i := range r.Serve.APIKeys {
+			r.Serve.APIKeys[i] = RedactedValue
+		}
+	}
+	return &r
+}
+
+// Resolved returns the configuration as loaded, with defaults applied and secrets redacted,
+// keyed by the YAML setting names
+func (c *Config) Resolved() (map[string]interface{}, error) {
+	data, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return nil, err
+	}
+	var resolved map[string]interface{}
+	if err := yaml.Unmarshal(data, &resolved); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
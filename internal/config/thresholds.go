@@ -0,0 +1,64 @@
+// thresholds.go
+// Matching thresholds are resolved the same way by every command that matches records: a
+// command-line flag wins over the config file, which wins over the documented default.
+package config
+
+import "fmt"
+
+// Where a resolved threshold came from
+const (
+	SourceFlag    = "flag"
+	SourceConfig  = "config"
+	SourceDefault = "default"
+)
+
+// Thresholds are resolved matching thresholds with the source of each
+type Thresholds struct {
+	Hamming       uint32
+	HammingSource string
+	Jaccard       float64
+	JaccardSource string
+}
+
+// ResolveThresholds resolves the matching thresholds from the flags (zero when not given) and
+// the loaded configs. With several configs, as in validate, the most permissive configured value
+// is used: the largest Hamming and the smallest Jaccard threshold.
+func ResolveThresholds(hammingFlag uint32, jaccardFlag float64, configs ...*Config) Thresholds {
+	t := Thresholds{
+		Hamming: DefaultHammingThreshold, HammingSource: SourceDefault,
+		Jaccard: DefaultJaccardThreshold, JaccardSource: SourceDefault,
+	}
+	for _, cfg := range configs {
+		if cfg == nil {
+			continue
+		}
+		if hamming := cfg.Matching.HammingThreshold; hamming > 0 && !cfg.defaulted[keyHammingThreshold] {
+			if t.HammingSource != SourceConfig || hamming > t.Hamming {
+				t.Hamming, t.HammingSource = hamming, SourceConfig
+			}
+		}
+		if jaccard := cfg.Matching.JaccardThreshold; jaccard > 0 && !cfg.defaulted[keyJaccardThreshold] {
+			if t.JaccardSource != SourceConfig || jaccard < t.Jaccard {
+				t.Jaccard, t.JaccardSource = jaccard, SourceConfig
+			}
+		}
+	}
+	if hammingFlag > 0 {
+		t.Hamming, t.HammingSource = hammingFlag, SourceFlag
+	}
+	if jaccardFlag > 0 {
+		t.Jaccard, t.JaccardSource = jaccardFlag, SourceFlag
+	}
+	return t
+}
+
+// Apply sets the matching thresholds of cfg
+func (t Thresholds) Apply(cfg *Config) {
+	cfg.Matching.HammingThreshold = t.Hamming
+	cfg.Matching.JaccardThreshold = t.Jaccard
+}
+
+// String describes the thresholds and their sources, as "Hamming=20 (config), Jaccard=0.32 (default)"
+func (t Thresholds) String() string {
+	return fmt.Sprintf("Hamming=%d (%s), Jaccard=%g (%s)", t.Hamming, t.HammingSource, t.Jaccard, t.JaccardSource)
+}
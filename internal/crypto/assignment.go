@@ -0,0 +1,378 @@
+// assignment.go
+// Package crypto provides 1:1 assignment of candidate match pairs.
+// Both parties must arrive at the same assignment from mirrored inputs, so every
+// ordering decision is made on pairs oriented by party role (party 0 ID, party 1 ID)
+// rather than on local/peer order.
+package crypto
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// 1:1 assignment algorithms
+const (
+	AssignmentGreedy    = "greedy"    // Best-scoring pairs first
+	AssignmentHungarian = "hungarian" // Globally optimal: most matches, then lowest total distance
+)
+
+// DefaultAssignment is used when no algorithm is configured
+const DefaultAssignment = AssignmentGreedy
+
+// hungarianMaxRecords caps the records on either side of a component that the Hungarian
+// algorithm solves: it builds a dense n×n cost matrix and takes O(n³) time, so a larger
+// component, such as thousands of records around a common name, is assigned greedily instead.
+// Both parties see the same components, so they fall back alike.
+var hungarianMaxRecords = 500
+
+// ValidateAssignment checks that an assignment algorithm name is supported
+func ValidateAssignment(name string) error {
+	switch name {
+	case "", AssignmentGreedy, AssignmentHungarian:
+		return nil
+	}
+	return fmt.Errorf("unknown assignment algorithm %q (expected %s or %s)", name, AssignmentGreedy, AssignmentHungarian)
+}
+
+// Match cardinalities: how many records of the other dataset one record may match
+const (
+	CardinalityOneToOne   = "1:1"       // Each record matches at most one record, by an assignment algorithm
+	CardinalityOneToMany  = "1:many"    // A local record may match many peer records; each peer record keeps its best match
+	CardinalityManyToMany = "many:many" // Every pair within the thresholds is kept
+)
+
+// ValidateCardinality checks that a match cardinality is supported
+func ValidateCardinality(name string) error {
+	switch name {
+	case CardinalityOneToOne, CardinalityOneToMany, CardinalityManyToMany:
+		return nil
+	}
+	return fmt.Errorf("unknown match cardinality %q (expected %s, %s or %s)", name, CardinalityOneToOne, CardinalityOneToMany, CardinalityManyToMany)
+}
+
+// orientedPair is a candidate match keyed by party role so both parties sort it identically
+type orientedPair struct {
+	pair PrivateMatchPair
+	id0  string // ID held by party 0
+	id1  string // ID held by party 1
+}
+
+// cost orders candidates: lower Hamming distance first, then higher Jaccard similarity.
+// Hamming distances are integral, so the Jaccard term only breaks ties between them.
+func (p PrivateMatchPair) cost() float64 {
+	return float64(p.hamming) + (1 - p.jaccard)
+}
+
+// NewScoredMatchPair creates a candidate pair with the scores used for assignment
+func NewScoredMatchPair(localID, peerID string, hamming uint32, jaccard float64) PrivateMatchPair {
+	return PrivateMatchPair{LocalID: localID, PeerID: peerID, hamming: hamming, jaccard: jaccard}
+}
+
+// AssignOneToOne reduces candidate matches to a 1:1 assignment, exactly as the
+// intersection protocol does for the given party
+func AssignOneToOne(matches []PrivateMatchPair, party int, algorithm string) []PrivateMatchPair {
+	return assignOneToOne(matches, party, algorithm)
+}
+
+// assignOneToOne reduces candidate matches to a 1:1 assignment using the given algorithm
+func assignOneToOne(matches []PrivateMatchPair, party int, algorithm string) []PrivateMatchPair {
+	if len(matches) <= 1 {
+		return matches
+	}
+
+	pairs := make([]orientedPair, len(matches))
+	for i, m := range matches {
+		pairs[i] = orientedPair{pair: m, id0: m.LocalID, id1: m.PeerID}
+		if party == 1 {
+			pairs[i].id0, pairs[i].id1 = m.PeerID, m.LocalID
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		ci, cj := pairs[i].pair.cost(), pairs[j].pair.cost()
+		if ci != cj {
+			return ci < cj
+		}
+		if pairs[i].id0 != pairs[j].id0 {
+			return pairs[i].id0 < pairs[j].id0
+		}
+		return pairs[i].id1 < pairs[j].id1
+	})
+
+	var assigned []orientedPair
+	if algorithm == AssignmentHungarian {
+		assigned = assignHungarian(pairs)
+	} else {
+		assigned = assignGreedy(pairs)
+	}
+
+	result := make([]PrivateMatchPair, len(assigned))
+	for i, p := range assigned {
+		result[i] = p.pair
+	}
+	return result
+}
+
+// AssignOneToMany keeps the lowest-cost match of every peer record, so that a local record may
+// match many peer records but a peer record only one. Ties go to the pair whose IDs, oriented by
+// party role, sort first.
+func AssignOneToMany(matches []PrivateMatchPair, party int) []PrivateMatchPair {
+	best := make(map[string]int) // Index in matches of the best pair of each peer record
+	for i, m := range matches {
+		j, seen := best[m.PeerID]
+		if !seen || m.cost() < matches[j].cost() || (m.cost() == matches[j].cost() && orientedLess(m, matches[j], party)) {
+			best[m.PeerID] = i
+		}
+	}
+	result := make([]PrivateMatchPair, 0, len(best))
+	for i, m := range matches {
+		if best[m.PeerID] == i {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// orientedLess orders two pairs by their IDs held by party 0, then party 1
+func orientedLess(a, b PrivateMatchPair, party int) bool {
+	a0, a1, b0, b1 := a.LocalID, a.PeerID, b.LocalID, b.PeerID
+	if party == 1 {
+		a0, a1, b0, b1 = a1, a0, b1, b0
+	}
+	if a0 != b0 {
+		return a0 < b0
+	}
+	return a1 < b1
+}
+
+// assignGreedy takes pairs in cost order, skipping any whose records are already assigned
+func assignGreedy(sorted []orientedPair) []orientedPair {
+	used0 := make(map[string]bool)
+	used1 := make(map[string]bool)
+
+	var assigned []orientedPair
+	for _, p := range sorted {
+		if used0[p.id0] || used1[p.id1] {
+			continue
+		}
+		used0[p.id0] = true
+		used1[p.id1] = true
+		assigned = append(assigned, p)
+	}
+	return assigned
+}
+
+// assignHungarian solves each connected component of the candidate graph optimally:
+// first maximizing the number of matches, then minimizing their total cost. Components
+// with more than hungarianMaxRecords records on a side are assigned greedily.
+func assignHungarian(sorted []orientedPair) []orientedPair {
+	var assigned []orientedPair
+	for _, component := range candidateComponents(sorted) {
+		switch {
+		case len(component) == 1:
+			assigned = append(assigned, component[0])
+		case componentRecords(component) > hungarianMaxRecords:
+			// Components keep the cost order of sorted, as greedy assignment needs
+			assigned = append(assigned, assignGreedy(component)...)
+		default:
+			assigned = append(assigned, solveComponent(component)...)
+		}
+	}
+
+	// Keep output order deterministic and independent of component discovery
+	sort.Slice(assigned, func(i, j int) bool {
+		if assigned[i].id0 != assigned[j].id0 {
+			return assigned[i].id0 < assigned[j].id0
+		}
+		return assigned[i].id1 < assigned[j].id1
+	})
+	return assigned
+}
+
+// candidateComponents groups pairs into connected components of the bipartite candidate graph
+func candidateComponents(pairs []orientedPair) [][]orientedPair {
+	parent := make(map[string]string)
+	var find func(string) string
+	find = func(x string) string {
+		if parent[x] != x {
+			parent[x] = find(parent[x])
+		}
+		return parent[x]
+	}
+
+	for _, p := range pairs {
+		a, b := "0:"+p.id0, "1:"+p.id1
+		if _, ok := parent[a]; !ok {
+			parent[a] = a
+		}
+		if _, ok := parent[b]; !ok {
+			parent[b] = b
+		}
+		ra, rb := find(a), find(b)
+		if ra != rb {
+			// Union toward the smaller root so the structure is order independent
+			if ra < rb {
+				parent[rb] = ra
+			} else {
+				parent[ra] = rb
+			}
+		}
+	}
+
+	groups := make(map[string][]orientedPair)
+	var roots []string
+	for _, p := range pairs {
+		root := find("0:" + p.id0)
+		if _, ok := groups[root]; !ok {
+			roots = append(roots, root)
+		}
+		groups[root] = append(groups[root], p)
+	}
+	sort.Strings(roots)
+
+	components := make([][]orientedPair, len(roots))
+	for i, root := range roots {
+		components[i] = groups[root]
+	}
+	return components
+}
+
+// componentRecords returns the number of records on the larger side of a component
+func componentRecords(pairs []orientedPair) int {
+	ids0 := make(map[string]bool)
+	ids1 := make(map[string]bool)
+	for _, p := range pairs {
+		ids0[p.id0] = true
+		ids1[p.id1] = true
+	}
+	return max(len(ids0), len(ids1))
+}
+
+// solveComponent runs the Hungarian algorithm on one component
+func solveComponent(pairs []orientedPair) []orientedPair {
+	index0 := make(map[string]int)
+	index1 := make(map[string]int)
+	var ids0, ids1 []string
+	for _, p := range pairs {
+		if _, ok := index0[p.id0]; !ok {
+			index0[p.id0] = 0
+			ids0 = append(ids0, p.id0)
+		}
+		if _, ok := index1[p.id1]; !ok {
+			index1[p.id1] = 0
+			ids1 = append(ids1, p.id1)
+		}
+	}
+	sort.Strings(ids0)
+	sort.Strings(ids1)
+	for i, id := range ids0 {
+		index0[id] = i
+	}
+	for i, id := range ids1 {
+		index1[id] = i
+	}
+
+	n := len(ids0)
+	if len(ids1) > n {
+		n = len(ids1)
+	}
+
+	// Missing edges cost more than any full set of real edges, so the
+	// solution always maximizes the number of real matches first
+	maxCost := 0.0
+	for _, p := range pairs {
+		maxCost = math.Max(maxCost, p.pair.cost())
+	}
+	forbidden := (maxCost + 1) * float64(n+1)
+
+	cost := make([][]float64, n)
+	edge := make([][]int, n)
+	for i := range cost {
+		cost[i] = make([]float64, n)
+		edge[i] = make([]int, n)
+		for j := range cost[i] {
+			cost[i][j] = forbidden
+			edge[i][j] = -1
+		}
+	}
+	for k, p := range pairs {
+		i, j := index0[p.id0], index1[p.id1]
+		if edge[i][j] == -1 {
+			cost[i][j] = p.pair.cost()
+			edge[i][j] = k
+		}
+	}
+
+	var assigned []orientedPair
+	for i, j := range hungarian(cost) {
+		if i < len(ids0) && j < len(ids1) && edge[i][j] >= 0 {
+			assigned = append(assigned, pairs[edge[i][j]])
+		}
+	}
+	return assigned
+}
+
+// hungarian solves the square assignment problem, returning the column assigned to each row
+func hungarian(cost [][]float64) []int {
+	n := len(cost)
+	u := make([]float64, n+1)
+	v := make([]float64, n+1)
+	p := make([]int, n+1) // p[j] = row assigned to column j (1-based, 0 = none)
+	way := make([]int, n+1)
+
+	for i := 1; i <= n; i++ {
+		p[0] = i
+		j0 := 0
+		minv := make([]float64, n+1)
+		used := make([]bool, n+1)
+		for j := range minv {
+			minv[j] = math.Inf(1)
+		}
+		for {
+			used[j0] = true
+			i0 := p[j0]
+			delta := math.Inf(1)
+			j1 := 0
+			for j := 1; j <= n; j++ {
+				if used[j] {
+					continue
+				}
+				cur := cost[i0-1][j-1] - u[i0] - v[j]
+				if cur < minv[j] {
+					minv[j] = cur
+					way[j] = j0
+				}
+				if minv[j] < delta {
+					delta = minv[j]
+					j1 = j
+				}
+			}
+			for j := 0; j <= n; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+			if p[j0] == 0 {
+				break
+			}
+		}
+		for j0 != 0 {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
+		}
+	}
+
+	assignment := make([]int, n)
+	for j := 1; j <= n; j++ {
+		if p[j] > 0 {
+			assignment[p[j]-1] = j - 1
+		}
+	}
+	return assignment
+}
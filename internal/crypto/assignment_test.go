@@ -0,0 +1,71 @@
+package crypto
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// contestedPairs are candidates where the best pair (a, x) blocks a second match: greedy
+// assignment keeps one pair, the Hungarian algorithm two
+func contestedPairs() []PrivateMatchPair {
+	return []PrivateMatchPair{
+		NewScoredMatchPair("a", "x", 1, 1),
+		NewScoredMatchPair("a", "y", 2, 1),
+		NewScoredMatchPair("b", "x", 2, 1),
+	}
+}
+
+// assignedPairs lists the assigned pairs in ID order, as the algorithms order them differently
+func assignedPairs(pairs []PrivateMatchPair) string {
+	ids := make([]string, len(pairs))
+	for i, p := range pairs {
+		ids[i] = p.LocalID + "-" + p.PeerID
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, " ")
+}
+
+func TestAssignHungarianMaximizesMatches(t *testing.T) {
+	greedy := AssignOneToOne(contestedPairs(), 0, AssignmentGreedy)
+	if len(greedy) != 1 {
+		t.Fatalf("greedy assigned %s, want only (a, x)", assignedPairs(greedy))
+	}
+	hungarian := AssignOneToOne(contestedPairs(), 0, AssignmentHungarian)
+	if len(hungarian) != 2 {
+		t.Fatalf("hungarian assigned %s, want (a, y) and (b, x)", assignedPairs(hungarian))
+	}
+}
+
+func TestAssignHungarianCapsComponentSize(t *testing.T) {
+	defer func(limit int) { hungarianMaxRecords = limit }(hungarianMaxRecords)
+
+	// The contested component has two records on each side
+	hungarianMaxRecords = 2
+	if got := AssignOneToOne(contestedPairs(), 0, AssignmentHungarian); len(got) != 2 {
+		t.Fatalf("at the cap, hungarian assigned %s, want two pairs", assignedPairs(got))
+	}
+	hungarianMaxRecords = 1
+	got := AssignOneToOne(contestedPairs(), 0, AssignmentHungarian)
+	want := AssignOneToOne(contestedPairs(), 0, AssignmentGreedy)
+	if assignedPairs(got) != assignedPairs(want) {
+		t.Fatalf("above the cap, hungarian assigned %s, want the greedy %s", assignedPairs(got), assignedPairs(want))
+	}
+}
+
+func TestAssignHungarianLargeComponent(t *testing.T) {
+	// One record of party 0 is a candidate for every record of party 1, as around a common
+	// name; above the cap it must be assigned without building the dense matrix
+	n := hungarianMaxRecords * 4
+	pairs := make([]PrivateMatchPair, 0, 2*n)
+	for i := 0; i < n; i++ {
+		pairs = append(pairs, NewScoredMatchPair("common", fmt.Sprintf("p%05d", i), uint32(i%50), 0.5))
+		pairs = append(pairs, NewScoredMatchPair(fmt.Sprintf("l%05d", i), fmt.Sprintf("p%05d", i), 10, 0.5))
+	}
+	got := AssignOneToOne(pairs, 0, AssignmentHungarian)
+	want := AssignOneToOne(pairs, 0, AssignmentGreedy)
+	if assignedPairs(got) != assignedPairs(want) {
+		t.Fatalf("hungarian assigned %d pairs above the cap, want the greedy %d", len(got), len(want))
+	}
+}
@@ -0,0 +1,287 @@
+// blocking.go
+// Deterministic blocking: records are grouped by keys built from coarse field values (the first
+// three ZIP digits, the birth year, the Soundex code of a surname, ...), and only records that share
+// a key are compared. Keys are hashed under the recipe's secret before they leave tokenization.
+// Several keys make a multi-pass blocking: a pair is compared if any pass puts both records in
+// the same block, so an error in one field only loses the pair if it breaks every pass.
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+)
+
+// Blocking transforms applied to a field's value
+const (
+	BlockExact   = "exact"   // The normalized value
+	BlockZip3    = "zip3"    // First three digits of a ZIP code
+	BlockYear    = "year"    // Year of a date
+	BlockSoundex = "soundex" // Soundex code of each word of a name
+	BlockInitial = "initial" // First letter of a name
+)
+
+// blockingKeySize is the number of bytes of the HMAC kept for each key
+const blockingKeySize = 12
+
+// blockingComponent is one transformed field of a blocking key
+type blockingComponent struct {
+	transform string
+	field     string
+}
+
+// BlockingKey is a parsed blocking key expression: components joined by "+", each either a field
+// name (its exact normalized value) or transform(field). The shorthands zip3 and birth_year apply
+// to the first configured zip and date field. An embedding(fields) expression is a pass of its
+// own, keyed by the bands of an n-gram embedding instead.
+type BlockingKey struct {
+	Expression string
+	components []blockingComponent
+	embedding  *embeddingLSH
+}
+
+// ParseBlockingKeys parses blocking key expressions against the configured fields and their
+// normalization methods
+func ParseBlockingKeys(expressions []string, fields []string, methods map[string]NormalizationMethod) ([]*BlockingKey, error) {
+	// Expressions are lowercased, so fields are looked up regardless of case
+	known := make(map[string]string, len(fields))
+	for _, field := range fields {
+		known[strings.ToLower(field)] = field
+	}
+	firstField := func(accepted ...NormalizationMethod) string {
+		for _, field := range fields {
+			for _, method := range accepted {
+				if methods[field] == method {
+					return field
+				}
+			}
+		}
+		return ""
+	}
+
+	keys := make([]*BlockingKey, 0, len(expressions))
+	seen := make(map[string]bool, len(expressions))
+	for _, expression := range expressions {
+		expression = CanonicalBlockingExpression(expression)
+		if expression == "" {
+			return nil, fmt.Errorf("empty blocking key")
+		}
+		if seen[expression] {
+			return nil, fmt.Errorf("blocking key %q is listed twice", expression)
+		}
+		seen[expression] = true
+
+		key := &BlockingKey{Expression: expression}
+		if lsh, ok, err := parseEmbeddingPass(expression, known); ok {
+			if err != nil {
+				return nil, err
+			}
+			key.embedding = lsh
+			keys = append(keys, key)
+			continue
+		}
+		for _, part := range strings.Split(expression, "+") {
+			var component blockingComponent
+			switch open := strings.IndexByte(part, '('); {
+			case open > 0 && strings.HasSuffix(part, ")"):
+				component = blockingComponent{transform: part[:open], field: part[open+1 : len(part)-1]}
+			case known[part] != "":
+				component = blockingComponent{transform: BlockExact, field: part}
+			case part == "zip3":
+				component = blockingComponent{transform: BlockZip3, field: firstField(NormZip)}
+			case part == "birth_year":
+				component = blockingComponent{transform: BlockYear, field: firstField(NormDate, NormBirthdate)}
+			default:
+				return nil, fmt.Errorf("blocking key %q: unknown field %q", expression, part)
+			}
+
+			switch component.transform {
+			case BlockExact, BlockZip3, BlockYear, BlockSoundex, BlockInitial:
+			case BlockEmbedding:
+				return nil, fmt.Errorf("blocking key %q: an embedding is a blocking key of its own, as in embedding(first_name+last_name)", expression)
+			default:
+				return nil, fmt.Errorf("blocking key %q: unknown transform %q (use %s, %s, %s, %s or %s)",
+					expression, component.transform, BlockExact, BlockZip3, BlockYear, BlockSoundex, BlockInitial)
+			}
+			if component.field == "" {
+				return nil, fmt.Errorf("blocking key %q: no field is normalized as a %s for %s; name the field, as in %s(field)",
+					expression, map[string]string{BlockZip3: "zip", BlockYear: "date"}[component.transform], part, component.transform)
+			}
+			field, ok := known[strings.ToLower(component.field)]
+			if !ok {
+				return nil, fmt.Errorf("blocking key %q: %q is not a configured field", expression, component.field)
+			}
+			component.field = field
+			key.components = append(key.components, component)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// CanonicalBlockingExpression lowercases an expression and removes its spaces, so that equivalent
+// spellings give the same keys and recipe
+func CanonicalBlockingExpression(expression string) string {
+	return strings.ToLower(strings.Join(strings.Fields(expression), ""))
+}
+
+// value builds the key's plaintext value from a record's field values; ok is false if a
+// component is empty, leaving the record outside this pass
+func (k *BlockingKey) value(record map[string]string, methods map[string]NormalizationMethod) (string, bool) {
+	parts := make([]string, len(k.components))
+	for i, component := range k.components {
+		raw := record[component.field]
+		var part string
+		switch component.transform {
+		case BlockExact:
+			part = NormalizeField(raw, methods[component.field])
+		case BlockZip3:
+			if zip := NormalizeZip(raw); len(zip) >= 3 {
+				part = zip[:3]
+			}
+		case BlockYear:
+			if date, err := time.Parse("2006-01-02", NormalizeDate(raw)); err == nil {
+				part = fmt.Sprintf("%04d", date.Year())
+			}
+		case BlockSoundex:
+			part = phoneticNormalize(raw, Soundex)
+		case BlockInitial:
+			if name := NormalizeName(raw); name != "" {
+				part = name[:1]
+			}
+		}
+		if part == "" {
+			return "", false
+		}
+		parts[i] = part
+	}
+	return strings.Join(parts, "\x1f"), true
+}
+
+// Blocker derives the hashed blocking keys of raw records
+type Blocker struct {
+	keys       []*BlockingKey
+	embeddings map[*BlockingKey]*embeddingPass
+	methods    map[string]NormalizationMethod
+	secret     []byte
+}
+
+// NewBlocker hashes keys with secret, which both parties must share: the linkage secret when one
+// is configured, otherwise the MinHash seed
+func NewBlocker(keys []*BlockingKey, methods map[string]NormalizationMethod, secret []byte) *Blocker {
+	b := &Blocker{keys: keys, embeddings: make(map[*BlockingKey]*embeddingPass), methods: methods, secret: secret}
+	for _, key := range keys {
+		if key.embedding != nil {
+			b.embeddings[key] = newEmbeddingPass(key, secret)
+		}
+	}
+	return b
+}
+
+// Keys returns the hashed key of each pass the record takes part in, and of each band of its
+// embedding passes; record holds the values of the configured fields
+func (b *Blocker) Keys(record map[string]string) []string {
+	var hashed []string
+	for _, key := range b.keys {
+		if pass := b.embeddings[key]; pass != nil {
+			for _, value := range pass.bandValues(record, b.methods) {
+				hashed = append(hashed, b.hash(key, value))
+			}
+			continue
+		}
+		value, ok := key.value(record, b.methods)
+		if !ok {
+			continue
+		}
+		hashed = append(hashed, b.hash(key, value))
+	}
+	return hashed
+}
+
+// hash keys a blocking key value under the secret
+func (b *Blocker) hash(key *BlockingKey, value string) string {
+	mac := hmac.New(sha256.New, b.secret)
+	mac.Write([]byte("cohort-bridge-blocking\x00" + key.Expression + "\x00" + value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:blockingKeySize])
+}
+
+// blockingIndex holds the peer records by blocking key, so each local record is compared only
+// with the peer records it shares a block with. Records without any key are compared with every
+// record of the other side rather than dropped.
+type blockingIndex struct {
+	local, peer []*pprl.Record
+	buckets     map[string][]int
+	unblocked   []int // Peer records without a blocking key
+	seen        []int // Last local record (plus one) that reached each peer record
+}
+
+// newBlockingIndex indexes the peer records, or returns nil if either side carries no blocking
+// keys at all, in which case every pair is compared
+func newBlockingIndex(local, peer []*pprl.Record) *blockingIndex {
+	if !hasBlockingKeys(local) || !hasBlockingKeys(peer) {
+		return nil
+	}
+	ix := &blockingIndex{local: local, peer: peer, buckets: make(map[string][]int), seen: make([]int, len(peer))}
+	for j, record := range peer {
+		if len(record.BlockingKeys) == 0 {
+			ix.unblocked = append(ix.unblocked, j)
+		}
+		for _, key := range record.BlockingKeys {
+			ix.buckets[key] = append(ix.buckets[key], j)
+		}
+	}
+	return ix
+}
+
+// hasBlockingKeys reports whether any record carries a blocking key
+func hasBlockingKeys(records []*pprl.Record) bool {
+	for _, record := range records {
+		if len(record.BlockingKeys) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// peers returns the peer records local record i is compared with, in index order, or nil and
+// false if it has no blocking key and is compared with all of them
+func (ix *blockingIndex) peers(i int) ([]int, bool) {
+	keys := ix.local[i].BlockingKeys
+	if len(keys) == 0 {
+		return nil, false
+	}
+	var peers []int
+	for _, key := range keys {
+		for _, j := range ix.buckets[key] {
+			if ix.seen[j] != i+1 {
+				ix.seen[j] = i + 1
+				peers = append(peers, j)
+			}
+		}
+	}
+	peers = append(peers, ix.unblocked...)
+	sort.Ints(peers)
+	return peers, true
+}
+
+// pairs counts the pairs the index compares
+func (ix *blockingIndex) pairs() int {
+	total := 0
+	for i := range ix.local {
+		if peers, ok := ix.peers(i); ok {
+			total += len(peers)
+		} else {
+			total += len(ix.peer)
+		}
+	}
+	for j := range ix.seen {
+		ix.seen[j] = 0
+	}
+	return total
+}
@@ -0,0 +1,172 @@
+// embedding_blocking.go
+// Embedding blocking: a blocking pass for noisy data, written embedding(first_name+last_name).
+// Each record's normalized values are turned locally into a character n-gram hash embedding
+// (bigrams and trigrams hashed into a fixed number of signed dimensions), which is cut by random
+// hyperplanes into bands of sign bits (SimHash LSH). Records whose embeddings point the same way,
+// as names with a typo or two do, share a band and so a block. Only the hashed band IDs leave
+// tokenization; the embeddings never do. On saturated Bloom filters, where MinHash estimates no
+// longer separate matches from non-matches, the pass keeps recall with far fewer comparisons.
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// BlockEmbedding is the blocking pass over character n-gram embeddings
+const BlockEmbedding = "embedding"
+
+// Defaults of an embedding blocking pass. With 12 bands of 8 hyperplanes, a pair whose embeddings
+// have a cosine similarity of 0.8 shares a band about 87% of the time, and an unrelated pair about 5%.
+const (
+	DefaultEmbeddingDimensions = 128
+	DefaultEmbeddingBands      = 12
+	DefaultEmbeddingRows       = 8
+)
+
+// embeddingLSH is a parsed embedding blocking pass
+type embeddingLSH struct {
+	fields     []string
+	dimensions int
+	bands      int
+	rows       int
+}
+
+// parseEmbeddingPass parses embedding(field+field,dims=N,bands=N,rows=N), whose arguments after
+// the fields are optional; ok is false if the expression is not an embedding pass
+func parseEmbeddingPass(expression string, known map[string]string) (*embeddingLSH, bool, error) {
+	prefix := BlockEmbedding + "("
+	if !strings.HasPrefix(expression, prefix) || !strings.HasSuffix(expression, ")") {
+		return nil, false, nil
+	}
+	args := strings.Split(expression[len(prefix):len(expression)-1], ",")
+	lsh := &embeddingLSH{dimensions: DefaultEmbeddingDimensions, bands: DefaultEmbeddingBands, rows: DefaultEmbeddingRows}
+	for _, name := range strings.Split(args[0], "+") {
+		field, ok := known[name]
+		if !ok {
+			return nil, true, fmt.Errorf("blocking key %q: %q is not a configured field", expression, name)
+		}
+		lsh.fields = append(lsh.fields, field)
+	}
+	for _, arg := range args[1:] {
+		name, value, _ := strings.Cut(arg, "=")
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, true, fmt.Errorf("blocking key %q: %q is not name=number", expression, arg)
+		}
+		var target *int
+		var low, high int
+		switch name {
+		case "dims":
+			target, low, high = &lsh.dimensions, 16, 1024
+		case "bands":
+			target, low, high = &lsh.bands, 1, 64
+		case "rows":
+			target, low, high = &lsh.rows, 1, 32
+		default:
+			return nil, true, fmt.Errorf("blocking key %q: unknown argument %q (use dims, bands or rows)", expression, name)
+		}
+		if n < low || n > high {
+			return nil, true, fmt.Errorf("blocking key %q: %s must be between %d and %d", expression, name, low, high)
+		}
+		*target = n
+	}
+	return lsh, true, nil
+}
+
+// embeddingPass is an embedding blocking pass with its hyperplanes, derived from the blocking
+// secret so both parties cut the embeddings alike
+type embeddingPass struct {
+	*embeddingLSH
+	planes [][]int8 // bands*rows hyperplanes of dimensions ±1 entries
+	secret []byte
+}
+
+func newEmbeddingPass(key *BlockingKey, secret []byte) *embeddingPass {
+	lsh := key.embedding
+	pass := &embeddingPass{embeddingLSH: lsh, planes: make([][]int8, lsh.bands*lsh.rows), secret: secret}
+	mac := hmac.New(sha256.New, secret)
+	for k := range pass.planes {
+		plane := make([]int8, lsh.dimensions)
+		var block []byte
+		for d := range plane {
+			if d%256 == 0 {
+				mac.Reset()
+				fmt.Fprintf(mac, "cohort-bridge-embedding-plane\x00%s\x00%d\x00%d", key.Expression, k, d/256)
+				block = mac.Sum(block[:0])
+			}
+			plane[d] = 1
+			if block[d%256/8]>>(d%8)&1 == 1 {
+				plane[d] = -1
+			}
+		}
+		pass.planes[k] = plane
+	}
+	return pass
+}
+
+// embed returns the hashed n-gram embedding of a record's normalized values, or nil if they are
+// all empty
+func (p *embeddingPass) embed(record map[string]string, methods map[string]NormalizationMethod) []int {
+	values := make([]string, 0, len(p.fields))
+	for _, field := range p.fields {
+		if value := NormalizeField(record[field], methods[field]); value != "" {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	text := []rune(" " + strings.Join(values, " ") + " ")
+	vector := make([]int, p.dimensions)
+	mac := hmac.New(sha256.New, p.secret)
+	for n := 2; n <= 3; n++ {
+		for i := 0; i+n <= len(text); i++ {
+			index, sign := p.feature(mac, string(text[i:i+n]))
+			vector[index] += sign
+		}
+	}
+	return vector
+}
+
+// feature hashes an n-gram to its dimension and sign
+func (p *embeddingPass) feature(mac hash.Hash, gram string) (int, int) {
+	mac.Reset()
+	mac.Write([]byte("cohort-bridge-embedding-gram\x00" + gram))
+	sum := mac.Sum(nil)
+	index := int(binary.BigEndian.Uint32(sum) % uint32(p.dimensions))
+	if sum[4]&1 == 1 {
+		return index, -1
+	}
+	return index, 1
+}
+
+// bandValues returns the plaintext value of each band of a record's embedding: the band number
+// and the sign bits of its hyperplane projections
+func (p *embeddingPass) bandValues(record map[string]string, methods map[string]NormalizationMethod) []string {
+	vector := p.embed(record, methods)
+	if vector == nil {
+		return nil
+	}
+	values := make([]string, p.bands)
+	bits := make([]byte, p.rows)
+	for band := range values {
+		for row := range bits {
+			projection := 0
+			for d, weight := range p.planes[band*p.rows+row] {
+				projection += int(weight) * vector[d]
+			}
+			bits[row] = '0'
+			if projection >= 0 {
+				bits[row] = '1'
+			}
+		}
+		values[band] = strconv.Itoa(band) + "\x1f" + string(bits)
+	}
+	return values
+}
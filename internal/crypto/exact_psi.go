@@ -0,0 +1,186 @@
+// exact_psi.go
+// Private set intersection of exact identifiers (tokenization.exact_id), for parties that share a
+// reliable common identifier such as a hashed SSN. Each party raises the hash of every identifier
+// to a secret exponent and sends the points; each then raises the peer's points to its own
+// exponent and returns them. Equal identifiers give equal doubly raised points, whoever raised
+// them first, while under the Diffie-Hellman assumption the other points show nothing of the
+// identifiers. Both parties learn which record pairs share an identifier, and nothing else.
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"filippo.io/edwards25519"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+)
+
+const (
+	exactPSIVersion   = 1
+	exactPointSize    = 32
+	exactPointsPerMsg = smcBatchBytes / exactPointSize // Points sent in each batched message
+)
+
+// NormalizeExactID reduces an identifier to its lowercase letters and digits, so "123-45-6789"
+// and "123 45 6789" are the same identifier
+func NormalizeExactID(value string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(value) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// HashExactID keys a normalized identifier with the linkage secret for the exact_id column of a
+// token file, so token files never hold the identifier itself. It returns "" for an empty one.
+func HashExactID(secret []byte, value string) string {
+	value = NormalizeExactID(value)
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("cohort-bridge exact id"))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// exactPSIHello opens the protocol with the IDs of the records carrying an identifier, in the
+// order their points follow
+type exactPSIHello struct {
+	Version int      `json:"version"`
+	IDs     []string `json:"ids"`
+}
+
+// ComputeExactIntersection links the local records to the peer's records with the same exact
+// identifier by private set intersection, sending every message through exchange. Records without
+// an identifier take no part. Pairs carry no scores; 1:1 assignment and retention apply as usual.
+func (sip *SecureIntersectionProtocol) ComputeExactIntersection(exchange SMCExchange, localRecords []*pprl.Record) (*PrivateIntersectionResult, error) {
+	fmt.Printf("   🔒 Initializing exact identifier PSI (Party %d)\n", sip.PSI.Party)
+
+	var records []*pprl.Record
+	for _, record := range localRecords {
+		if record.ExactID != "" {
+			records = append(records, record)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+
+	key, err := randomScalar()
+	if err != nil {
+		return nil, err
+	}
+	hello := exactPSIHello{Version: exactPSIVersion, IDs: make([]string, len(records))}
+	points := make([]byte, 0, len(records)*exactPointSize)
+	for i, record := range records {
+		hello.IDs[i] = record.ID
+		point := new(edwards25519.Point).ScalarMult(key, hashExactID(record.ExactID))
+		points = append(points, point.Bytes()...)
+	}
+
+	message, err := json.Marshal(hello)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := exchange(message)
+	if err != nil {
+		return nil, fmt.Errorf("exact identifier PSI handshake failed: %w", err)
+	}
+	var peer exactPSIHello
+	if err := json.Unmarshal(reply, &peer); err != nil {
+		return nil, fmt.Errorf("invalid exact identifier PSI handshake: %w", err)
+	}
+	if peer.Version != hello.Version {
+		return nil, fmt.Errorf("peer runs exact identifier PSI version %d, this side version %d", peer.Version, hello.Version)
+	}
+	if len(records) == 0 || len(peer.IDs) == 0 {
+		fmt.Printf("   Records with an exact identifier: %d local, %d peer\n", len(records), len(peer.IDs))
+		fmt.Printf("   ✅ Found 0 matches by exact identifier\n")
+		return &PrivateIntersectionResult{}, nil
+	}
+
+	// Swap the singly raised points, then return the peer's raised to this side's exponent
+	fmt.Printf("   🔄 Exchanging blinded identifiers (%d local, %d peer)...\n", len(records), len(peer.IDs))
+	peerPoints, err := swapPoints(exchange, points, len(peer.IDs))
+	if err != nil {
+		return nil, err
+	}
+	doubled := make([]byte, 0, len(peerPoints))
+	for j := range len(peer.IDs) {
+		point, err := new(edwards25519.Point).SetBytes(peerPoints[j*exactPointSize : (j+1)*exactPointSize])
+		if err != nil {
+			return nil, fmt.Errorf("peer identifier %d is not a curve point: %w", j, err)
+		}
+		doubled = append(doubled, new(edwards25519.Point).ScalarMult(key, point).Bytes()...)
+	}
+	localDoubled, err := swapPoints(exchange, doubled, len(records))
+	if err != nil {
+		return nil, err
+	}
+
+	peerIndex := make(map[string][]int, len(peer.IDs))
+	for j := range len(peer.IDs) {
+		point := string(doubled[j*exactPointSize : (j+1)*exactPointSize])
+		peerIndex[point] = append(peerIndex[point], j)
+	}
+	var matches []PrivateMatchPair
+	for i, record := range records {
+		for _, j := range peerIndex[string(localDoubled[i*exactPointSize:(i+1)*exactPointSize])] {
+			matches = append(matches, PrivateMatchPair{LocalID: record.ID, PeerID: peer.IDs[j]})
+		}
+	}
+	fmt.Printf("   ✅ Found %d matches by exact identifier\n", len(matches))
+
+	return &PrivateIntersectionResult{
+		MatchPairs: sip.finish(matches),
+	}, nil
+}
+
+// hashExactID hashes an identifier to a point of the prime-order subgroup, so raising it to a
+// secret exponent leaves no small-order component that would show bits of the hash
+func hashExactID(exactID string) *edwards25519.Point {
+	for counter := byte(0); ; counter++ {
+		h := sha512.New()
+		h.Write([]byte("cohort-bridge exact id to point"))
+		h.Write([]byte{counter})
+		h.Write([]byte(exactID))
+		sum := h.Sum(nil)
+		if point, err := new(edwards25519.Point).SetBytes(sum[:32]); err == nil {
+			point.MultByCofactor(point)
+			if point.Equal(edwards25519.NewIdentityPoint()) == 0 {
+				return point
+			}
+		}
+	}
+}
+
+// swapPoints sends local points in batches and returns the peer's expected points, which arrive
+// in as many rounds as the larger side needs
+func swapPoints(exchange SMCExchange, local []byte, expected int) ([]byte, error) {
+	batch := exactPointsPerMsg * exactPointSize
+	localRounds := (len(local) + batch - 1) / batch
+	peerRounds := (expected*exactPointSize + batch - 1) / batch
+	peer := make([]byte, 0, expected*exactPointSize)
+	for round := range max(localRounds, peerRounds) {
+		start, end := min(round*batch, len(local)), min((round+1)*batch, len(local))
+		reply, err := exchange(local[start:end])
+		if err != nil {
+			return nil, fmt.Errorf("exact identifier PSI failed: %w", err)
+		}
+		peer = append(peer, reply...)
+	}
+	if len(peer) != expected*exactPointSize {
+		return nil, fmt.Errorf("peer sent %d blinded identifiers, expected %d", len(peer)/exactPointSize, expected)
+	}
+	return peer, nil
+}
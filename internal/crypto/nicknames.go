@@ -0,0 +1,200 @@
+package crypto
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// NicknameDictionary maps a lowercase name to the canonical names it is a variant of.
+// Expanding a name adds its canonical forms, so "Bill" and "William" share q-grams.
+type NicknameDictionary map[string][]string
+
+// builtinNicknames lists common English given names and their nicknames, canonical name first
+var builtinNicknames = [][]string{
+	{"abigail", "abby", "abbie", "gail"},
+	{"abraham", "abe", "bram"},
+	{"albert", "al", "bert", "bertie"},
+	{"alexander", "alex", "alec", "sandy", "xander"},
+	{"alexandra", "alex", "alexa", "sandra", "sandy", "lexi"},
+	{"alfred", "al", "alf", "fred", "freddie"},
+	{"allison", "allie", "ally"},
+	{"andrew", "andy", "drew"},
+	{"angela", "angie"},
+	{"anthony", "tony"},
+	{"arthur", "art", "artie"},
+	{"barbara", "barb", "barbie", "babs"},
+	{"benjamin", "ben", "benji", "benny"},
+	{"bernard", "bernie"},
+	{"beverly", "bev"},
+	{"bradley", "brad"},
+	{"calvin", "cal"},
+	{"catherine", "cathy", "cate", "kate", "katie", "kay"},
+	{"charles", "charlie", "chuck", "chas", "chaz"},
+	{"christina", "chris", "christy", "tina"},
+	{"christine", "chris", "christy", "tina"},
+	{"christopher", "chris", "kit", "topher"},
+	{"cynthia", "cindy"},
+	{"daniel", "dan", "danny"},
+	{"david", "dave", "davey"},
+	{"deborah", "deb", "debbie", "debby"},
+	{"donald", "don", "donnie"},
+	{"dorothy", "dot", "dottie", "dolly"},
+	{"douglas", "doug"},
+	{"edward", "ed", "eddie", "ned", "ted", "teddy"},
+	{"elizabeth", "liz", "lizzie", "beth", "betty", "betsy", "eliza", "libby", "bess"},
+	{"eleanor", "ellie", "nell", "nora"},
+	{"emily", "em", "emmy"},
+	{"eugene", "gene"},
+	{"frances", "fran", "frannie"},
+	{"francis", "frank", "frankie"},
+	{"frederick", "fred", "freddie", "rick"},
+	{"gerald", "gerry", "jerry"},
+	{"gregory", "greg"},
+	{"harold", "hal", "harry"},
+	{"henry", "hank", "harry"},
+	{"jacob", "jake"},
+	{"james", "jim", "jimmy", "jamie"},
+	{"janet", "jan"},
+	{"jennifer", "jen", "jenny", "jenn"},
+	{"jessica", "jess", "jessie"},
+	{"johanna", "jo", "hanna"},
+	{"john", "jack", "johnny", "jon"},
+	{"jonathan", "jon", "jonny", "nathan"},
+	{"joseph", "joe", "joey"},
+	{"joshua", "josh"},
+	{"judith", "judy"},
+	{"katherine", "kathy", "kate", "katie", "kay", "kathryn"},
+	{"kenneth", "ken", "kenny"},
+	{"kimberly", "kim"},
+	{"lawrence", "larry"},
+	{"leonard", "leo", "len", "lenny"},
+	{"louis", "lou"},
+	{"margaret", "maggie", "meg", "peggy", "marge", "margie", "greta", "daisy"},
+	{"martha", "marty", "patty"},
+	{"matthew", "matt", "matty"},
+	{"michael", "mike", "mikey", "mick", "mickey"},
+	{"nicholas", "nick", "nicky", "nico"},
+	{"pamela", "pam"},
+	{"patricia", "pat", "patty", "tricia", "trish"},
+	{"patrick", "pat", "paddy"},
+	{"peter", "pete"},
+	{"philip", "phil"},
+	{"rebecca", "becky", "becca"},
+	{"richard", "rich", "rick", "ricky", "dick"},
+	{"robert", "rob", "bob", "bobby", "robbie", "bert"},
+	{"ronald", "ron", "ronnie"},
+	{"samantha", "sam", "sammy"},
+	{"samuel", "sam", "sammy"},
+	{"sandra", "sandy"},
+	{"stephen", "steve", "stevie"},
+	{"steven", "steve", "stevie"},
+	{"susan", "sue", "susie", "suzy"},
+	{"theodore", "ted", "teddy", "theo"},
+	{"thomas", "tom", "tommy"},
+	{"timothy", "tim", "timmy"},
+	{"victoria", "vicky", "tori"},
+	{"virginia", "ginny", "ginger"},
+	{"walter", "walt", "wally"},
+	{"william", "bill", "billy", "will", "willy", "liam"},
+	{"zachary", "zach", "zack"},
+}
+
+// DefaultNicknames returns the built-in nickname dictionary
+func DefaultNicknames() NicknameDictionary {
+	dictionary := make(NicknameDictionary)
+	for _, group := range builtinNicknames {
+		dictionary.add(group[0], group[1:])
+	}
+	return dictionary
+}
+
+// LoadNicknames reads a nickname dictionary file with one group per line: the canonical name
+// followed by its variants, separated by commas. Blank lines and lines starting with # are ignored.
+func LoadNicknames(path string) (NicknameDictionary, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open nickname dictionary: %w", err)
+	}
+	defer file.Close()
+
+	dictionary := make(NicknameDictionary)
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var names []string
+		for _, name := range strings.Split(text, ",") {
+			if name = NormalizeName(name); name != "" {
+				names = append(names, name)
+			}
+		}
+		if len(names) < 2 {
+			return nil, fmt.Errorf("nickname dictionary %s line %d: need a canonical name and at least one variant", path, line)
+		}
+		dictionary.add(names[0], names[1:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read nickname dictionary: %w", err)
+	}
+	return dictionary, nil
+}
+
+// Merge adds the entries of other to the dictionary
+func (d NicknameDictionary) Merge(other NicknameDictionary) {
+	for variant, canonicals := range other {
+		for _, canonical := range canonicals {
+			d.add(canonical, []string{variant})
+		}
+	}
+}
+
+func (d NicknameDictionary) add(canonical string, variants []string) {
+	for _, variant := range variants {
+		if variant == canonical || containsString(d[variant], canonical) {
+			continue
+		}
+		d[variant] = append(d[variant], canonical)
+		sort.Strings(d[variant])
+	}
+}
+
+// Expand appends the canonical forms of each word of a name, e.g. "Bill Smith" becomes
+// "bill william smith". Canonical names and unknown names are returned normalized but unchanged.
+func (d NicknameDictionary) Expand(name string) string {
+	words := strings.Fields(NormalizeName(name))
+	expanded := make([]string, 0, len(words))
+	for _, word := range words {
+		expanded = append(expanded, word)
+		for _, canonical := range d[word] {
+			if !containsString(words, canonical) {
+				expanded = append(expanded, canonical)
+			}
+		}
+	}
+	return strings.Join(expanded, " ")
+}
+
+// IsNameMethod reports whether method normalizes names, so nicknames apply to its fields
+func IsNameMethod(method NormalizationMethod) bool {
+	switch method {
+	case NormName, NormSoundex, NormMetaphone, NormNYSIIS:
+		return true
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -15,8 +15,26 @@ const (
 	NormDate   NormalizationMethod = "date"
 	NormGender NormalizationMethod = "gender"
 	NormZip    NormalizationMethod = "zip"
+
+	// NormBirthdate encodes the year and the month/day pair separately so that off-by-one and
+	// day/month-transposed birthdates keep most of their q-grams
+	NormBirthdate NormalizationMethod = "birthdate"
+
+	// Phonetic name encodings, applied after name normalization
+	NormSoundex   NormalizationMethod = "soundex"
+	NormMetaphone NormalizationMethod = "metaphone" // Double Metaphone primary code
+	NormNYSIIS    NormalizationMethod = "nysiis"
 )
 
+// ParseNormalizationMethod returns the normalization method named by method (case-insensitive)
+func ParseNormalizationMethod(method string) (NormalizationMethod, bool) {
+	switch m := NormalizationMethod(strings.ToLower(strings.TrimSpace(method))); m {
+	case NormName, NormDate, NormGender, NormZip, NormBirthdate, NormSoundex, NormMetaphone, NormNYSIIS:
+		return m, true
+	}
+	return "", false
+}
+
 // FieldNormalization represents a field and its normalization method
 type FieldNormalization struct {
 	Method NormalizationMethod
@@ -35,22 +53,9 @@ func ParseNormalizationConfig(normSpecs []string) map[string]NormalizationMethod
 			continue
 		}
 
-		method := strings.ToLower(strings.TrimSpace(parts[0]))
-		field := strings.TrimSpace(parts[1])
-
-		// Check if method is supported
-		switch method {
-		case "name":
-			normMap[field] = NormName
-		case "date":
-			normMap[field] = NormDate
-		case "gender":
-			normMap[field] = NormGender
-		case "zip":
-			normMap[field] = NormZip
-		default:
-			// Unsupported method, skip normalization for this field
-			continue
+		// Unsupported methods leave the field unnormalized
+		if method, ok := ParseNormalizationMethod(parts[0]); ok {
+			normMap[strings.TrimSpace(parts[1])] = method
 		}
 	}
 
@@ -117,6 +122,26 @@ func NormalizeDate(value interface{}) string {
 	}
 }
 
+// NormalizeBirthdate encodes the year and the month/day pair of a date as separate tokens, e.g.
+// 1980-03-15 becomes "y1980 md0315". The prefixes keep the components' q-grams apart, so an
+// off-by-one year or day changes only the q-grams of its own digits, and the month/day token holds
+// the two in ascending order so it is unchanged when they are transposed (03/05 vs 05/03).
+// Values that are not dates fall back to date normalization.
+func NormalizeBirthdate(value interface{}) string {
+	normalized := NormalizeDate(value)
+	date, err := time.Parse("2006-01-02", normalized)
+	if err != nil {
+		return normalized
+	}
+
+	month, day := int(date.Month()), date.Day()
+	low, high := month, day
+	if low > high {
+		low, high = high, low
+	}
+	return fmt.Sprintf("y%04d md%02d%02d", date.Year(), low, high)
+}
+
 // NormalizeGender standardizes gender fields
 func NormalizeGender(value string) string {
 	if value == "" {
@@ -174,10 +199,21 @@ func NormalizeField(value interface{}, method NormalizationMethod) string {
 		return NormalizeName(fmt.Sprint(value))
 	case NormDate:
 		return NormalizeDate(value)
+	case NormBirthdate:
+		return NormalizeBirthdate(value)
 	case NormGender:
 		return NormalizeGender(fmt.Sprint(value))
 	case NormZip:
 		return NormalizeZip(fmt.Sprint(value))
+	case NormSoundex:
+		return phoneticNormalize(fmt.Sprint(value), Soundex)
+	case NormMetaphone:
+		return phoneticNormalize(fmt.Sprint(value), func(word string) string {
+			primary, _ := DoubleMetaphone(word)
+			return primary
+		})
+	case NormNYSIIS:
+		return phoneticNormalize(fmt.Sprint(value), NYSIIS)
 	default:
 		// No normalization method specified, apply basic normalization
 		if value == nil {
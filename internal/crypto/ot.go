@@ -0,0 +1,249 @@
+// ot.go
+// Oblivious transfer for the secure comparison protocol (smc.go). In one transfer a receiver with
+// a choice bit learns one of a sender's two keys; the sender learns nothing of the choice and the
+// receiver nothing of the other key. otSecurity base transfers use the "simplest OT" of Chou and
+// Orlandi over edwards25519, and any number of further transfers are extended from them with
+// symmetric crypto only (the IKNP extension). Both are secure against semi-honest parties: ones
+// that follow the protocol but try to learn more from the messages they see.
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"filippo.io/edwards25519"
+)
+
+const (
+	otSecurity = 128 // Base transfers, and bits of the extension's security
+	otKeySize  = 16  // Bytes of a transferred key (an AES-128 key)
+)
+
+// otKey is a key delivered by an oblivious transfer
+type otKey [otKeySize]byte
+
+// stream expands the key into a pseudorandom stream (AES-128 in counter mode)
+func (k otKey) stream() cipher.Stream {
+	block, _ := aes.NewCipher(k[:]) // A 16-byte key is always valid
+	return cipher.NewCTR(block, make([]byte, aes.BlockSize))
+}
+
+// expand fills out with the key's pseudorandom stream
+func (k otKey) expand(out []byte) {
+	clear(out)
+	k.stream().XORKeyStream(out, out)
+}
+
+// randomScalar returns a uniformly random scalar of the edwards25519 group
+func randomScalar() (*edwards25519.Scalar, error) {
+	var seed [64]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate random scalar: %w", err)
+	}
+	return edwards25519.NewScalar().SetUniformBytes(seed[:])
+}
+
+// baseOTKey derives the key of base transfer t from the shared point
+func baseOTKey(t int, a, b, shared *edwards25519.Point) otKey {
+	h := sha256.New()
+	h.Write([]byte("cohort-bridge base OT"))
+	binary.Write(h, binary.BigEndian, uint32(t))
+	h.Write(a.Bytes())
+	h.Write(b.Bytes())
+	h.Write(shared.Bytes())
+	var key otKey
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// baseOTSender offers a pair of keys in each of the otSecurity base transfers
+type baseOTSender struct {
+	a      *edwards25519.Scalar
+	public *edwards25519.Point // A = aG, sent to the receiver
+}
+
+// newBaseOTSender starts the base transfers; the returned message goes to the receiver
+func newBaseOTSender() (*baseOTSender, []byte, error) {
+	a, err := randomScalar()
+	if err != nil {
+		return nil, nil, err
+	}
+	s := &baseOTSender{a: a, public: new(edwards25519.Point).ScalarBaseMult(a)}
+	return s, s.public.Bytes(), nil
+}
+
+// keys returns both keys of every base transfer, given the receiver's reply
+func (s *baseOTSender) keys(reply []byte) ([otSecurity][2]otKey, error) {
+	var keys [otSecurity][2]otKey
+	if len(reply) != otSecurity*32 {
+		return keys, fmt.Errorf("base transfer reply has %d bytes, expected %d", len(reply), otSecurity*32)
+	}
+	for t := range otSecurity {
+		b, err := new(edwards25519.Point).SetBytes(reply[t*32 : (t+1)*32])
+		if err != nil {
+			return keys, fmt.Errorf("base transfer reply %d is not a curve point: %w", t, err)
+		}
+		// k0 = H(aB), k1 = H(a(B - A)): the receiver can compute only the one it chose
+		keys[t][0] = baseOTKey(t, s.public, b, new(edwards25519.Point).ScalarMult(s.a, b))
+		keys[t][1] = baseOTKey(t, s.public, b, new(edwards25519.Point).ScalarMult(s.a, new(edwards25519.Point).Subtract(b, s.public)))
+	}
+	return keys, nil
+}
+
+// baseOTReceive chooses one key of each base transfer, given the sender's message, and returns
+// the reply for the sender along with the chosen keys
+func baseOTReceive(message []byte, choices [otSecurity]bool) ([]byte, [otSecurity]otKey, error) {
+	var keys [otSecurity]otKey
+	a, err := new(edwards25519.Point).SetBytes(message)
+	if err != nil {
+		return nil, keys, fmt.Errorf("base transfer message is not a curve point: %w", err)
+	}
+	reply := make([]byte, 0, otSecurity*32)
+	for t := range otSecurity {
+		b, err := randomScalar()
+		if err != nil {
+			return nil, keys, err
+		}
+		// B = bG for choice 0 and A + bG for choice 1; either way the key is H(bA)
+		point := new(edwards25519.Point).ScalarBaseMult(b)
+		if choices[t] {
+			point.Add(a, point)
+		}
+		reply = append(reply, point.Bytes()...)
+		keys[t] = baseOTKey(t, a, point, new(edwards25519.Point).ScalarMult(b, a))
+	}
+	return reply, keys, nil
+}
+
+// otExtSender is the sender of extended transfers. It was the receiver of the base transfers,
+// with random choices delta, so that each extended transfer offers keys H(q) and H(q ^ delta).
+type otExtSender struct {
+	delta   [otSecurity / 8]byte
+	columns [otSecurity]cipher.Stream // Streams of the base keys chosen by delta
+	count   uint64                    // Transfers extended so far, numbering the keys
+}
+
+// otExtReceiver is the receiver of extended transfers; it was the sender of the base transfers
+type otExtReceiver struct {
+	columns [otSecurity][2]cipher.Stream // Streams of both keys of each base transfer
+	count   uint64
+}
+
+// newOTExtReceiver prepares extension from both keys of each base transfer
+func newOTExtReceiver(keys [otSecurity][2]otKey) *otExtReceiver {
+	r := &otExtReceiver{}
+	for t := range otSecurity {
+		r.columns[t] = [2]cipher.Stream{keys[t][0].stream(), keys[t][1].stream()}
+	}
+	return r
+}
+
+// newOTExtSender prepares extension from the keys chosen by delta in the base transfers
+func newOTExtSender(delta [otSecurity]bool, keys [otSecurity]otKey) *otExtSender {
+	s := &otExtSender{}
+	for t := range otSecurity {
+		if delta[t] {
+			s.delta[t/8] |= 1 << (t % 8)
+		}
+		s.columns[t] = keys[t].stream()
+	}
+	return s
+}
+
+// extend receives len(choices) transfers: it returns the message for the sender and the key
+// chosen in each transfer
+func (r *otExtReceiver) extend(choices []bool) ([]byte, []otKey) {
+	n := len(choices)
+	width := (n + 7) / 8
+	packed := make([]byte, width)
+	for j, choice := range choices {
+		if choice {
+			packed[j/8] |= 1 << (j % 8)
+		}
+	}
+
+	// Column t of T is the stream of key 0; the sender gets u = T ^ G1 ^ choices, from which its
+	// own column q = T ^ delta_t * choices follows
+	message := make([]byte, otSecurity*width)
+	columns := make([][]byte, otSecurity)
+	for t := range otSecurity {
+		column := make([]byte, width)
+		r.columns[t][0].XORKeyStream(column, column)
+		u := message[t*width : (t+1)*width]
+		r.columns[t][1].XORKeyStream(u, u)
+		for i := range u {
+			u[i] ^= column[i] ^ packed[i]
+		}
+		columns[t] = column
+	}
+
+	rows := transposeColumns(columns, n)
+	keys := make([]otKey, n)
+	for j := range rows {
+		keys[j] = extendedKey(r.count+uint64(j), rows[j])
+	}
+	r.count += uint64(n)
+	return message, keys
+}
+
+// extend sends n transfers, given the receiver's message: it returns both keys of each transfer
+func (s *otExtSender) extend(n int, message []byte) ([]otKey, []otKey, error) {
+	width := (n + 7) / 8
+	if len(message) != otSecurity*width {
+		return nil, nil, fmt.Errorf("extension message has %d bytes, expected %d", len(message), otSecurity*width)
+	}
+	columns := make([][]byte, otSecurity)
+	for t := range otSecurity {
+		column := make([]byte, width)
+		s.columns[t].XORKeyStream(column, column)
+		if s.delta[t/8]&(1<<(t%8)) != 0 {
+			u := message[t*width : (t+1)*width]
+			for i := range column {
+				column[i] ^= u[i]
+			}
+		}
+		columns[t] = column
+	}
+
+	rows := transposeColumns(columns, n)
+	keys0, keys1 := make([]otKey, n), make([]otKey, n)
+	for j, row := range rows {
+		keys0[j] = extendedKey(s.count+uint64(j), row)
+		for i := range row {
+			row[i] ^= s.delta[i]
+		}
+		keys1[j] = extendedKey(s.count+uint64(j), row)
+	}
+	s.count += uint64(n)
+	return keys0, keys1, nil
+}
+
+// transposeColumns turns otSecurity columns of n bits into n rows of otSecurity bits
+func transposeColumns(columns [][]byte, n int) [][otSecurity / 8]byte {
+	rows := make([][otSecurity / 8]byte, n)
+	for t, column := range columns {
+		bit := byte(1) << (t % 8)
+		for j := range n {
+			if column[j/8]&(1<<(j%8)) != 0 {
+				rows[j][t/8] |= bit
+			}
+		}
+	}
+	return rows
+}
+
+// extendedKey hashes row j of an extension into the key of transfer j, breaking the correlation
+// between the rows
+func extendedKey(j uint64, row [otSecurity / 8]byte) otKey {
+	var input [8 + otSecurity/8]byte
+	binary.BigEndian.PutUint64(input[:8], j)
+	copy(input[8:], row[:])
+	sum := sha256.Sum256(input[:])
+	var key otKey
+	copy(key[:], sum[:])
+	return key
+}
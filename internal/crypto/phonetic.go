@@ -0,0 +1,629 @@
+package crypto
+
+import (
+	"strings"
+)
+
+// Phonetic encoders map spelling variants of a name (e.g. "Smith"/"Smyth", "Catherine"/"Kathryn")
+// to the same code before q-gram generation. Each expects a single uppercase ASCII word.
+
+// phoneticNormalize applies name normalization and then encodes each word of the name
+func phoneticNormalize(value string, encode func(string) string) string {
+	words := strings.Fields(strings.ToUpper(NormalizeName(value)))
+	codes := make([]string, 0, len(words))
+	for _, word := range words {
+		if code := encode(word); code != "" {
+			codes = append(codes, strings.ToLower(code))
+		}
+	}
+	return strings.Join(codes, " ")
+}
+
+// Soundex returns the four-character American Soundex code of word
+func Soundex(word string) string {
+	word = lettersOnly(word)
+	if word == "" {
+		return ""
+	}
+
+	code := []byte{word[0]}
+	last := soundexDigit(word[0])
+	for i := 1; i < len(word) && len(code) < 4; i++ {
+		c := word[i]
+		digit := soundexDigit(c)
+		switch {
+		case c == 'H' || c == 'W':
+			// H and W do not separate letters with the same code
+			continue
+		case digit == 0:
+			// Vowels separate letters with the same code
+			last = 0
+		case digit != last:
+			code = append(code, digit)
+			last = digit
+		}
+	}
+	for len(code) < 4 {
+		code = append(code, '0')
+	}
+	return string(code)
+}
+
+func soundexDigit(c byte) byte {
+	switch c {
+	case 'B', 'F', 'P', 'V':
+		return '1'
+	case 'C', 'G', 'J', 'K', 'Q', 'S', 'X', 'Z':
+		return '2'
+	case 'D', 'T':
+		return '3'
+	case 'L':
+		return '4'
+	case 'M', 'N':
+		return '5'
+	case 'R':
+		return '6'
+	}
+	return 0
+}
+
+// NYSIIS returns the New York State Identification and Intelligence System code of word,
+// truncated to six characters as in the original algorithm
+func NYSIIS(word string) string {
+	name := []byte(lettersOnly(word))
+	if len(name) == 0 {
+		return ""
+	}
+
+	// Prefix and suffix translations
+	for _, rule := range [][2]string{{"MAC", "MCC"}, {"KN", "NN"}, {"K", "C"}, {"PH", "FF"}, {"PF", "FF"}, {"SCH", "SSS"}} {
+		if strings.HasPrefix(string(name), rule[0]) {
+			copy(name, rule[1])
+			break
+		}
+	}
+	for _, rule := range [][2]string{{"EE", "Y"}, {"IE", "Y"}, {"DT", "D"}, {"RT", "D"}, {"RD", "D"}, {"NT", "D"}, {"ND", "D"}} {
+		if strings.HasSuffix(string(name), rule[0]) {
+			name = append(name[:len(name)-len(rule[0])], rule[1]...)
+			break
+		}
+	}
+
+	key := []byte{name[0]}
+	for i := 1; i < len(name); i++ {
+		rest := string(name[i:])
+		switch {
+		case strings.HasPrefix(rest, "EV"):
+			name[i], name[i+1] = 'A', 'F'
+		case isVowelByte(name[i]):
+			name[i] = 'A'
+		case name[i] == 'Q':
+			name[i] = 'G'
+		case name[i] == 'Z':
+			name[i] = 'S'
+		case name[i] == 'M':
+			name[i] = 'N'
+		case strings.HasPrefix(rest, "KN"):
+			name[i] = 'N'
+		case name[i] == 'K':
+			name[i] = 'C'
+		case strings.HasPrefix(rest, "SCH"):
+			name[i+1], name[i+2] = 'S', 'S'
+		case strings.HasPrefix(rest, "PH"):
+			name[i], name[i+1] = 'F', 'F'
+		case name[i] == 'H' && (!isVowelByte(name[i-1]) || i+1 == len(name) || !isVowelByte(name[i+1])):
+			name[i] = name[i-1]
+		case name[i] == 'W' && isVowelByte(name[i-1]):
+			name[i] = name[i-1]
+		}
+		if name[i] != key[len(key)-1] {
+			key = append(key, name[i])
+		}
+	}
+
+	if len(key) > 1 && key[len(key)-1] == 'S' {
+		key = key[:len(key)-1]
+	}
+	if len(key) > 2 && string(key[len(key)-2:]) == "AY" {
+		key = append(key[:len(key)-2], 'Y')
+	}
+	if len(key) > 1 && key[len(key)-1] == 'A' {
+		key = key[:len(key)-1]
+	}
+	if len(key) > 6 {
+		key = key[:6]
+	}
+	return string(key)
+}
+
+// DoubleMetaphone returns the primary and alternate Double Metaphone codes of word
+// (Lawrence Philips, 2000), each at most four characters
+func DoubleMetaphone(word string) (string, string) {
+	word = lettersOnly(word)
+	if word == "" {
+		return "", ""
+	}
+	m := &metaphone{word: word, length: len(word), last: len(word) - 1}
+	m.slavoGermanic = strings.Contains(word, "W") || strings.Contains(word, "K") ||
+		strings.Contains(word, "CZ") || strings.Contains(word, "WITZ")
+	return m.encode()
+}
+
+type metaphone struct {
+	word          string
+	length, last  int
+	slavoGermanic bool
+
+	primary, secondary strings.Builder
+}
+
+func (m *metaphone) at(i int) byte {
+	if i < 0 || i >= m.length {
+		return 0
+	}
+	return m.word[i]
+}
+
+// is reports whether the substring of length n at start is one of options
+func (m *metaphone) is(start, n int, options ...string) bool {
+	if start < 0 || start+n > m.length {
+		return false
+	}
+	sub := m.word[start : start+n]
+	for _, option := range options {
+		if sub == option {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *metaphone) vowel(i int) bool {
+	c := m.at(i)
+	return isVowelByte(c) || c == 'Y'
+}
+
+func (m *metaphone) add(primary string, secondary ...string) {
+	m.primary.WriteString(primary)
+	if len(secondary) > 0 {
+		m.secondary.WriteString(secondary[0])
+	} else {
+		m.secondary.WriteString(primary)
+	}
+}
+
+// skip returns 2 if the next letter is c (a doubled letter), otherwise 1
+func (m *metaphone) skip(current int, c byte) int {
+	if m.at(current+1) == c {
+		return 2
+	}
+	return 1
+}
+
+func (m *metaphone) encode() (string, string) {
+	current := 0
+	// Silent initial letters
+	if m.is(0, 2, "GN", "KN", "PN", "WR", "PS") {
+		current++
+	}
+	if m.at(0) == 'X' {
+		m.add("S")
+		current++
+	}
+
+	for (m.primary.Len() < 4 || m.secondary.Len() < 4) && current < m.length {
+		switch c := m.at(current); c {
+		case 'A', 'E', 'I', 'O', 'U', 'Y':
+			if current == 0 {
+				m.add("A")
+			}
+			current++
+		case 'B':
+			m.add("P")
+			current += m.skip(current, 'B')
+		case 'C':
+			current = m.encodeC(current)
+		case 'D':
+			switch {
+			case m.is(current, 2, "DG") && m.is(current+2, 1, "I", "E", "Y"):
+				m.add("J")
+				current += 3
+			case m.is(current, 2, "DG"):
+				m.add("TK")
+				current += 2
+			case m.is(current, 2, "DT", "DD"):
+				m.add("T")
+				current += 2
+			default:
+				m.add("T")
+				current++
+			}
+		case 'F':
+			m.add("F")
+			current += m.skip(current, 'F')
+		case 'G':
+			current = m.encodeG(current)
+		case 'H':
+			// Only keep H between vowels or at the start before a vowel
+			if (current == 0 || m.vowel(current-1)) && m.vowel(current+1) {
+				m.add("H")
+				current += 2
+			} else {
+				current++
+			}
+		case 'J':
+			current = m.encodeJ(current)
+		case 'K':
+			m.add("K")
+			current += m.skip(current, 'K')
+		case 'L':
+			if m.at(current+1) == 'L' {
+				// Spanish "-illo", "-illa", "-alle"
+				if (current == m.length-3 && m.is(current-1, 4, "ILLO", "ILLA", "ALLE")) ||
+					((m.is(m.last-1, 2, "AS", "OS") || m.is(m.last, 1, "A", "O")) && m.is(current-1, 4, "ALLE")) {
+					m.add("L", "")
+					current += 2
+					continue
+				}
+				current += 2
+			} else {
+				current++
+			}
+			m.add("L")
+		case 'M':
+			m.add("M")
+			if (m.is(current-1, 3, "UMB") && (current+1 == m.last || m.is(current+2, 2, "ER"))) || m.at(current+1) == 'M' {
+				current += 2
+			} else {
+				current++
+			}
+		case 'N':
+			m.add("N")
+			current += m.skip(current, 'N')
+		case 'P':
+			if m.at(current+1) == 'H' {
+				m.add("F")
+				current += 2
+			} else {
+				m.add("P")
+				if m.is(current+1, 1, "P", "B") {
+					current += 2
+				} else {
+					current++
+				}
+			}
+		case 'Q':
+			m.add("K")
+			current += m.skip(current, 'Q')
+		case 'R':
+			// French "-ier" is silent in the primary code
+			if current == m.last && !m.slavoGermanic && m.is(current-2, 2, "IE") && !m.is(current-4, 2, "ME", "MA") {
+				m.add("", "R")
+			} else {
+				m.add("R")
+			}
+			current += m.skip(current, 'R')
+		case 'S':
+			current = m.encodeS(current)
+		case 'T':
+			current = m.encodeT(current)
+		case 'V':
+			m.add("F")
+			current += m.skip(current, 'V')
+		case 'W':
+			current = m.encodeW(current)
+		case 'X':
+			// French "-iaux", "-eaux", "-aux", "-oux" are silent
+			if !(current == m.last && (m.is(current-3, 3, "IAU", "EAU") || m.is(current-2, 2, "AU", "OU"))) {
+				m.add("KS")
+			}
+			if m.is(current+1, 1, "C", "X") {
+				current += 2
+			} else {
+				current++
+			}
+		case 'Z':
+			if m.at(current+1) == 'H' {
+				m.add("J")
+				current += 2
+				continue
+			}
+			if m.is(current+1, 2, "ZO", "ZI", "ZA") || (m.slavoGermanic && current > 0 && m.at(current-1) != 'T') {
+				m.add("S", "TS")
+			} else {
+				m.add("S")
+			}
+			current += m.skip(current, 'Z')
+		default:
+			current++
+		}
+	}
+
+	return truncate(m.primary.String(), 4), truncate(m.secondary.String(), 4)
+}
+
+func (m *metaphone) encodeC(current int) int {
+	switch {
+	// Germanic "-ach-" as in "Bacher", "Macher"
+	case current > 1 && !m.vowel(current-2) && m.is(current-1, 3, "ACH") &&
+		m.at(current+2) != 'I' && (m.at(current+2) != 'E' || m.is(current-2, 6, "BACHER", "MACHER")):
+		m.add("K")
+		return current + 2
+	case current == 0 && m.is(current, 6, "CAESAR"):
+		m.add("S")
+		return current + 2
+	case m.is(current, 4, "CHIA"):
+		m.add("K")
+		return current + 2
+	case m.is(current, 2, "CH"):
+		switch {
+		case current > 0 && m.is(current, 4, "CHAE"):
+			m.add("K", "X")
+		case current == 0 && (m.is(current+1, 5, "HARAC", "HARIS") || m.is(current+1, 3, "HOR", "HYM", "HIA", "HEM")) && !m.is(0, 5, "CHORE"):
+			// Greek roots, e.g. "chemistry", "chorus"
+			m.add("K")
+		case m.is(0, 4, "VAN ", "VON ") || m.is(0, 3, "SCH") ||
+			m.is(current-2, 6, "ORCHES", "ARCHIT", "ORCHID") || m.is(current+2, 1, "T", "S") ||
+			((m.is(current-1, 1, "A", "O", "U", "E") || current == 0) && m.is(current+2, 1, "L", "R", "N", "M", "B", "H", "F", "V", "W", " ")):
+			m.add("K")
+		case current > 0 && m.is(0, 2, "MC"):
+			m.add("K")
+		case current > 0:
+			m.add("X", "K")
+		default:
+			m.add("X")
+		}
+		return current + 2
+	case m.is(current, 2, "CZ") && !m.is(current-2, 4, "WICZ"):
+		m.add("S", "X")
+		return current + 2
+	case m.is(current+1, 3, "CIA"):
+		m.add("X")
+		return current + 3
+	case m.is(current, 2, "CC") && !(current == 1 && m.at(0) == 'M'):
+		if m.is(current+2, 1, "I", "E", "H") && !m.is(current+2, 2, "HU") {
+			// "Accident", "accede", "succeed"
+			if (current == 1 && m.at(current-1) == 'A') || m.is(current-1, 5, "UCCEE", "UCCES") {
+				m.add("KS")
+			} else {
+				m.add("X")
+			}
+			return current + 3
+		}
+		m.add("K")
+		return current + 2
+	case m.is(current, 2, "CK", "CG", "CQ"):
+		m.add("K")
+		return current + 2
+	case m.is(current, 2, "CI", "CE", "CY"):
+		if m.is(current, 3, "CIO", "CIE", "CIA") {
+			m.add("S", "X")
+		} else {
+			m.add("S")
+		}
+		return current + 2
+	}
+
+	m.add("K")
+	switch {
+	case m.is(current+1, 2, " C", " Q", " G"):
+		return current + 3
+	case m.is(current+1, 1, "C", "K", "Q") && !m.is(current+1, 2, "CE", "CI"):
+		return current + 2
+	}
+	return current + 1
+}
+
+func (m *metaphone) encodeG(current int) int {
+	if m.at(current+1) == 'H' {
+		switch {
+		case current > 0 && !m.vowel(current-1):
+			m.add("K")
+		case current == 0:
+			if m.at(current+2) == 'I' {
+				m.add("J")
+			} else {
+				m.add("K")
+			}
+		case (current > 1 && m.is(current-2, 1, "B", "H", "D")) ||
+			(current > 2 && m.is(current-3, 1, "B", "H", "D")) ||
+			(current > 3 && m.is(current-4, 1, "B", "H")):
+			// Silent as in "Hugh", "bough", "broughton"
+		case current > 2 && m.at(current-1) == 'U' && m.is(current-3, 1, "C", "G", "L", "R", "T"):
+			// "Laugh", "McLaughlin", "cough", "rough"
+			m.add("F")
+		case current > 0 && m.at(current-1) != 'I':
+			m.add("K")
+		}
+		return current + 2
+	}
+
+	if m.at(current+1) == 'N' {
+		switch {
+		case current == 1 && m.vowel(0) && !m.slavoGermanic:
+			m.add("KN", "N")
+		case !m.is(current+2, 2, "EY") && m.at(current+1) != 'Y' && !m.slavoGermanic:
+			m.add("N", "KN")
+		default:
+			m.add("KN")
+		}
+		return current + 2
+	}
+
+	switch {
+	case m.is(current+1, 2, "LI") && !m.slavoGermanic:
+		// Italian "Tagliaro"
+		m.add("KL", "L")
+		return current + 2
+	case current == 0 && (m.at(current+1) == 'Y' || m.is(current+1, 2, "ES", "EP", "EB", "EL", "EY", "IB", "IL", "IN", "IE", "EI", "ER")):
+		m.add("K", "J")
+		return current + 2
+	case (m.is(current+1, 2, "ER") || m.at(current+1) == 'Y') && !m.is(0, 6, "DANGER", "RANGER", "MANGER") &&
+		!m.is(current-1, 1, "E", "I") && !m.is(current-1, 3, "RGY", "OGY"):
+		m.add("K", "J")
+		return current + 2
+	case m.is(current+1, 1, "E", "I", "Y") || m.is(current-1, 4, "AGGI", "OGGI"):
+		switch {
+		case m.is(0, 4, "VAN ", "VON ") || m.is(0, 3, "SCH") || m.is(current+1, 2, "ET"):
+			m.add("K")
+		case m.is(current+1, 4, "IER "):
+			m.add("J")
+		default:
+			m.add("J", "K")
+		}
+		return current + 2
+	}
+
+	m.add("K")
+	return current + m.skip(current, 'G')
+}
+
+func (m *metaphone) encodeJ(current int) int {
+	if m.is(current, 4, "JOSE") || m.is(0, 4, "SAN ") {
+		if (current == 0 && m.at(current+4) == ' ') || m.is(0, 4, "SAN ") {
+			m.add("H")
+		} else {
+			m.add("J", "H")
+		}
+		return current + 1
+	}
+
+	switch {
+	case current == 0:
+		m.add("J", "A")
+	case m.vowel(current-1) && !m.slavoGermanic && (m.at(current+1) == 'A' || m.at(current+1) == 'O'):
+		m.add("J", "H")
+	case current == m.last:
+		m.add("J", "")
+	case !m.is(current+1, 1, "L", "T", "K", "S", "N", "M", "B", "Z") && !m.is(current-1, 1, "S", "K", "L"):
+		m.add("J")
+	}
+	return current + m.skip(current, 'J')
+}
+
+func (m *metaphone) encodeS(current int) int {
+	switch {
+	case m.is(current-1, 3, "ISL", "YSL"):
+		// Silent as in "island", "carlysle"
+		return current + 1
+	case current == 0 && m.is(current, 5, "SUGAR"):
+		m.add("X", "S")
+		return current + 1
+	case m.is(current, 2, "SH"):
+		if m.is(current+1, 4, "HEIM", "HOEK", "HOLM", "HOLZ") {
+			m.add("S")
+		} else {
+			m.add("X")
+		}
+		return current + 2
+	case m.is(current, 3, "SIO", "SIA") || m.is(current, 4, "SIAN"):
+		if m.slavoGermanic {
+			m.add("S")
+		} else {
+			m.add("S", "X")
+		}
+		return current + 3
+	case (current == 0 && m.is(current+1, 1, "M", "N", "L", "W")) || m.is(current+1, 1, "Z"):
+		// "Schmidt"/"Smith", "Snider"/"Schneider"
+		m.add("S", "X")
+		return current + m.skip(current, 'Z')
+	case m.is(current, 2, "SC"):
+		switch {
+		case m.at(current+2) == 'H' && m.is(current+3, 2, "OO", "ER", "EN", "UY", "ED", "EM"):
+			if m.is(current+3, 2, "ER", "EN") {
+				m.add("X", "SK")
+			} else {
+				m.add("SK")
+			}
+		case m.at(current+2) == 'H':
+			if current == 0 && !m.vowel(3) && m.at(3) != 'W' {
+				m.add("X", "S")
+			} else {
+				m.add("X")
+			}
+		case m.is(current+2, 1, "I", "E", "Y"):
+			m.add("S")
+		default:
+			m.add("SK")
+		}
+		return current + 3
+	}
+
+	// French "-ais", "-ois" are silent
+	if current == m.last && m.is(current-2, 2, "AI", "OI") {
+		m.add("", "S")
+	} else {
+		m.add("S")
+	}
+	if m.is(current+1, 1, "S", "Z") {
+		return current + 2
+	}
+	return current + 1
+}
+
+func (m *metaphone) encodeT(current int) int {
+	switch {
+	case m.is(current, 4, "TION"), m.is(current, 3, "TIA", "TCH"):
+		m.add("X")
+		return current + 3
+	case m.is(current, 2, "TH") || m.is(current, 3, "TTH"):
+		if m.is(current+2, 2, "OM", "AM") || m.is(0, 4, "VAN ", "VON ") || m.is(0, 3, "SCH") {
+			m.add("T")
+		} else {
+			m.add("0", "T")
+		}
+		return current + 2
+	}
+
+	m.add("T")
+	if m.is(current+1, 1, "T", "D") {
+		return current + 2
+	}
+	return current + 1
+}
+
+func (m *metaphone) encodeW(current int) int {
+	if m.is(current, 2, "WR") {
+		m.add("R")
+		return current + 2
+	}
+	if current == 0 && (m.vowel(current+1) || m.is(current, 2, "WH")) {
+		if m.vowel(current + 1) {
+			m.add("A", "F")
+		} else {
+			m.add("A")
+		}
+	}
+	// Polish "-ewski", "-owski"
+	if (current == m.last && m.vowel(current-1)) || m.is(current-1, 5, "EWSKI", "EWSKY", "OWSKI", "OWSKY") || m.is(0, 3, "SCH") {
+		m.add("", "F")
+		return current + 1
+	}
+	if m.is(current, 4, "WICZ", "WITZ") {
+		m.add("TS", "FX")
+		return current + 4
+	}
+	return current + 1
+}
+
+// lettersOnly uppercases word and drops everything but A-Z
+func lettersOnly(word string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(word) {
+		if r >= 'A' && r <= 'Z' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func isVowelByte(c byte) bool {
+	return c == 'A' || c == 'E' || c == 'I' || c == 'O' || c == 'U'
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
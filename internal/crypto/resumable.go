@@ -0,0 +1,94 @@
+package crypto
+
+import (
+	"fmt"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+)
+
+// IntersectionProgress carries a checkpointed intersection: local records are compared in blocks,
+// and the candidate pairs of each completed block are handed to OnBlock so a later run can
+// continue after the last saved block instead of starting over
+type IntersectionProgress struct {
+	NextLocal  int                // Local records before this index were compared by an earlier run
+	Candidates []PrivateMatchPair // Candidate pairs that run found, before 1:1 assignment
+	BlockSize  int                // Local records compared between calls to OnBlock
+
+	// OnBlock receives the index of the next local record and the block's candidate pairs
+	OnBlock func(nextLocal int, candidates []PrivateMatchPair) error
+
+	// Adapt, if set, is called before each block with the size of the last one and returns the
+	// size of the next, or an error that stops the intersection after the blocks already saved
+	Adapt func(blockSize int) (int, error)
+}
+
+// ComputeResumableIntersection is ComputeSecureIntersection continued from progress. The 1:1
+// assignment is made once all blocks are done, over the candidates of every run.
+func (sip *SecureIntersectionProtocol) ComputeResumableIntersection(localRecords, peerRecords []*pprl.Record, progress *IntersectionProgress) (*PrivateIntersectionResult, error) {
+	return sip.computeResumable(&recordScorer{
+		psi:         sip.PSI,
+		local:       localRecords,
+		peer:        peerRecords,
+		localBlooms: newBloomCache(localRecords),
+		peerBlooms:  newBloomCache(peerRecords),
+	}, progress)
+}
+
+// ComputeResumableStoreIntersection is ComputeStoreIntersection continued from progress
+func (sip *SecureIntersectionProtocol) ComputeResumableStoreIntersection(local, peer *pprl.BloomStore, progress *IntersectionProgress) (*PrivateIntersectionResult, error) {
+	if !local.Compatible(peer) {
+		return nil, fmt.Errorf("token stores were built with different Bloom filter or MinHash sizes")
+	}
+	if sip.PSI.Compare != nil {
+		return nil, errStoreComparator
+	}
+	return sip.computeResumable(&storeScorer{local: local, peer: peer}, progress)
+}
+
+func (sip *SecureIntersectionProtocol) computeResumable(scorer pairScorer, progress *IntersectionProgress) (*PrivateIntersectionResult, error) {
+	psi := sip.PSI
+	localCount, _ := scorer.sizes()
+	if progress.NextLocal < 0 || progress.NextLocal > localCount {
+		return nil, fmt.Errorf("checkpoint is past the last local record (%d of %d)", progress.NextLocal, localCount)
+	}
+	blockSize := progress.BlockSize
+	if blockSize <= 0 {
+		blockSize = localCount
+	}
+
+	fmt.Printf("   🔒 Initializing secure PSI protocol (Party %d)\n", psi.Party)
+	if progress.NextLocal > 0 {
+		fmt.Printf("   ⏩ Resuming at local record %d of %d (%d candidate pairs saved)\n", progress.NextLocal, localCount, len(progress.Candidates))
+	}
+	fmt.Printf("   🔄 Computing secure intersection...\n")
+	psi.announceCandidateFilter()
+	psi.announceBlocking(scorer)
+
+	matches := append([]PrivateMatchPair{}, progress.Candidates...)
+	for from := progress.NextLocal; from < localCount; from += blockSize {
+		if progress.Adapt != nil {
+			next, err := progress.Adapt(blockSize)
+			if err != nil {
+				return nil, fmt.Errorf("stopped at local record %d of %d: %w", from, localCount, err)
+			}
+			blockSize = max(next, 1)
+		}
+		to := from + blockSize
+		if to > localCount {
+			to = localCount
+		}
+		found := len(matches)
+		matches = psi.matchRange(scorer, from, to, matches)
+		if err := scorer.failure(); err != nil {
+			return nil, fmt.Errorf("stopped at local record %d of %d: %w", from, localCount, err)
+		}
+		if progress.OnBlock != nil {
+			if err := progress.OnBlock(to, matches[found:]); err != nil {
+				return nil, fmt.Errorf("failed to save checkpoint: %w", err)
+			}
+		}
+	}
+	fmt.Printf("   ✅ Found %d matches using zero-knowledge protocols\n", len(matches))
+
+	return &PrivateIntersectionResult{MatchPairs: sip.finish(matches)}, nil
+}
@@ -0,0 +1,78 @@
+package crypto
+
+import "sort"
+
+// Retention bounds the matches an intersection keeps, so 1:many matching of large datasets does
+// not write every pair within the thresholds. Like the 1:1 assignment, it orders pairs by party
+// role so both parties keep the same ones.
+type Retention struct {
+	MaxPerRecord int     // Most matches kept for any one record of either dataset (0 = no limit)
+	MinJaccard   float64 // Score floor: matches below this Jaccard similarity are dropped (0 = none)
+}
+
+// Bounded reports whether the retention drops any matches
+func (r Retention) Bounded() bool {
+	return r.MaxPerRecord > 0 || r.MinJaccard > 0
+}
+
+// Apply bounds matches found outside the intersection protocol, exactly as the protocol does for
+// the given party
+func (r Retention) Apply(matches []PrivateMatchPair, party int) []PrivateMatchPair {
+	return r.retain(matches, party)
+}
+
+// retain drops matches below the score floor, then keeps a pair only if it is among the
+// MaxPerRecord best pairs of both its records
+func (r Retention) retain(matches []PrivateMatchPair, party int) []PrivateMatchPair {
+	if !r.Bounded() || len(matches) == 0 {
+		return matches
+	}
+
+	pairs := make([]orientedPair, 0, len(matches))
+	for _, m := range matches {
+		if m.jaccard < r.MinJaccard {
+			continue
+		}
+		p := orientedPair{pair: m, id0: m.LocalID, id1: m.PeerID}
+		if party == 1 {
+			p.id0, p.id1 = m.PeerID, m.LocalID
+		}
+		pairs = append(pairs, p)
+	}
+	if r.MaxPerRecord <= 0 {
+		return orientedMatches(pairs)
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		ci, cj := pairs[i].pair.cost(), pairs[j].pair.cost()
+		if ci != cj {
+			return ci < cj
+		}
+		if pairs[i].id0 != pairs[j].id0 {
+			return pairs[i].id0 < pairs[j].id0
+		}
+		return pairs[i].id1 < pairs[j].id1
+	})
+
+	// A pair's rank among each record's pairs is fixed by the ordering, so a pair past one
+	// record's limit is dropped without freeing a place for a worse one
+	count0, count1 := make(map[string]int), make(map[string]int)
+	kept := pairs[:0]
+	for _, p := range pairs {
+		count0[p.id0]++
+		count1[p.id1]++
+		if count0[p.id0] <= r.MaxPerRecord && count1[p.id1] <= r.MaxPerRecord {
+			kept = append(kept, p)
+		}
+	}
+	return orientedMatches(kept)
+}
+
+// orientedMatches unwraps oriented pairs
+func orientedMatches(pairs []orientedPair) []PrivateMatchPair {
+	matches := make([]PrivateMatchPair, len(pairs))
+	for i, p := range pairs {
+		matches[i] = p.pair
+	}
+	return matches
+}
@@ -9,9 +9,11 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
+	"math"
 	"math/big"
 	"strings"
 
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
 	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
 )
 
@@ -32,6 +34,28 @@ type SecurePSIProtocol struct {
 	PrivateSet       map[string]bool // Normalized local dataset (hashed for privacy)
 	HammingThreshold uint32          // Hamming distance threshold for bloom filter matching
 	JaccardThreshold float64         // Jaccard similarity threshold for MinHash matching
+
+	// CandidateThreshold is the MinHash Jaccard estimate below which a pair skips the Bloom comparison (0 disables)
+	CandidateThreshold float64
+
+	// Classifier, if set, replaces the distance thresholds (e.g. a calibrated probability threshold)
+	Classifier func(hamming uint32, jaccard float64) bool
+
+	// Blocking compares only records sharing a blocking key, when both sides' records carry keys
+	Blocking bool
+
+	// Observe, if set, is called with the scores of every pair compared, such as to build a score
+	// histogram; Hamming distances are then counted in full rather than up to the threshold
+	Observe func(hamming uint32, jaccard float64)
+
+	// DistanceScale is the factor Bloom filter hardening multiplies Hamming distances by (0 or 1:
+	// none). Distances are divided by it, so thresholds keep their meaning for unhardened filters.
+	DistanceScale uint32
+
+	// Compare, if set, scores each pair of in-memory records in place of the Bloom filter and
+	// MinHash comparison. Its distance is held to the Hamming threshold, after DistanceScale, and
+	// its similarity to the Jaccard threshold; an error stops the intersection.
+	Compare func(local, peer *pprl.Record) (distance uint32, similarity float64, err error)
 }
 
 // PrivateMatchPair represents a zero-knowledge match with NO additional metadata
@@ -39,6 +63,16 @@ type PrivateMatchPair struct {
 	LocalID string `json:"local_id"` // Only for local identification
 	PeerID  string `json:"peer_id"`  // Only for peer identification
 	// NO similarity scores, distances, match confidence, or any other metadata
+
+	// Scores used locally for 1:1 assignment; unexported so they are never serialized
+	hamming uint32
+	jaccard float64
+}
+
+// Scores returns the local Hamming distance and Jaccard similarity of the pair.
+// They are never sent to the peer.
+func (p PrivateMatchPair) Scores() (uint32, float64) {
+	return p.hamming, p.jaccard
 }
 
 // PrivateIntersectionResult contains ONLY matches with zero information leakage
@@ -49,7 +83,7 @@ type PrivateIntersectionResult struct {
 
 // NewSecurePSIProtocol creates a zero-knowledge PSI protocol with fuzzy matching thresholds
 func NewSecurePSIProtocol(party int) *SecurePSIProtocol {
-	return NewSecurePSIProtocolWithThresholds(party, 20, 0.32) // Default thresholds
+	return NewSecurePSIProtocolWithThresholds(party, config.DefaultHammingThreshold, config.DefaultJaccardThreshold)
 }
 
 // NewSecurePSIProtocolWithThresholds creates a PSI protocol with configurable fuzzy matching thresholds
@@ -74,7 +108,10 @@ func (psi *SecurePSIProtocol) ComputeSecureIntersection(localRecords, peerRecord
 
 	// Step 1: Perform secure intersection using cryptographic protocols
 	fmt.Printf("   🔄 Computing secure intersection...\n")
-	matches := psi.performSecurePSI(localRecords, peerRecords)
+	matches, err := psi.performSecurePSI(localRecords, peerRecords)
+	if err != nil {
+		return nil, err
+	}
 
 	fmt.Printf("   ✅ Found %d matches using zero-knowledge protocols\n", len(matches))
 
@@ -249,51 +286,340 @@ func (psi *SecurePSIProtocol) generateFuzzyVariants(fields []string) []string {
 }
 
 // performSecurePSI executes the actual PSI protocol with fuzzy matching using thresholds
-func (psi *SecurePSIProtocol) performSecurePSI(localRecords, peerRecords []*pprl.Record) []PrivateMatchPair {
-	var matches []PrivateMatchPair
-
-	// Perform fuzzy matching between all local and peer records
-	for _, localRecord := range localRecords {
-		for _, peerRecord := range peerRecords {
-			// Decode bloom filters for Hamming distance comparison
-			localBF, err := pprl.BloomFromBase64(localRecord.BloomData)
-			if err != nil {
-				continue // Skip invalid bloom filters
-			}
+func (psi *SecurePSIProtocol) performSecurePSI(localRecords, peerRecords []*pprl.Record) ([]PrivateMatchPair, error) {
+	// Bloom filters are decoded at most once per record, and only for records in a candidate pair
+	scorer := &recordScorer{
+		psi:         psi,
+		local:       localRecords,
+		peer:        peerRecords,
+		localBlooms: newBloomCache(localRecords),
+		peerBlooms:  newBloomCache(peerRecords),
+	}
+	matches := psi.matchPairs(scorer)
+	if err := scorer.failure(); err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
 
-			peerBF, err := pprl.BloomFromBase64(peerRecord.BloomData)
-			if err != nil {
-				continue // Skip invalid bloom filters
-			}
+// ComputeStoreIntersection performs the same intersection as ComputeSecureIntersection over two
+// binary token stores, comparing filters in place instead of decoding them
+func (psi *SecurePSIProtocol) ComputeStoreIntersection(local, peer *pprl.BloomStore) (*PrivateIntersectionResult, error) {
+	if !local.Compatible(peer) {
+		return nil, fmt.Errorf("token stores were built with different Bloom filter or MinHash sizes")
+	}
+	if psi.Compare != nil {
+		return nil, errStoreComparator
+	}
+	fmt.Printf("   🔒 Initializing secure PSI protocol (Party %d)\n", psi.Party)
+	fmt.Printf("   🔄 Computing secure intersection over memory-mapped token stores...\n")
+	matches := psi.matchPairs(&storeScorer{local: local, peer: peer})
+	fmt.Printf("   ✅ Found %d matches using zero-knowledge protocols\n", len(matches))
+	return &PrivateIntersectionResult{MatchPairs: matches}, nil
+}
 
-			// Calculate Hamming distance between bloom filters
-			hammingDistance := psi.calculateHammingDistance(localBF, peerBF)
+// errStoreComparator is returned when a comparator is set for records that are never decoded
+var errStoreComparator = fmt.Errorf("a custom comparator needs in-memory records: binary token stores are compared in place")
+
+// pairScorer gives the matcher the IDs and scores of local record i and peer record j
+type pairScorer interface {
+	sizes() (local, peer int)
+	ids(i, j int) (string, string)
+	jaccard(i, j int) float64
+	// hamming stops counting once the distance exceeds limit, and reports false if either
+	// record's Bloom filter is unusable
+	hamming(i, j int, limit uint32) (uint32, bool)
+	// failure returns the error that stopped the scoring, if any
+	failure() error
+}
 
-			// Calculate Jaccard similarity between MinHash signatures
-			jaccardSimilarity := psi.calculateJaccardSimilarity(localRecord.MinHash, peerRecord.MinHash)
+// matchPairs compares every local record with every peer record, or with the peer records it
+// shares a blocking key with
+func (psi *SecurePSIProtocol) matchPairs(scorer pairScorer) []PrivateMatchPair {
+	psi.announceCandidateFilter()
+	psi.announceBlocking(scorer)
+	localCount, _ := scorer.sizes()
+	return psi.matchRange(scorer, 0, localCount, nil)
+}
 
-			// Debug output for first few comparisons
-			if len(matches) < 5 {
-				fmt.Printf("   DEBUG: %s vs %s: Hamming=%d (threshold=%d), Jaccard=%.3f (threshold=%.3f)\n",
-					localRecord.ID, peerRecord.ID, hammingDistance, psi.HammingThreshold, jaccardSimilarity, psi.JaccardThreshold)
-			}
+// announceCandidateFilter notes the MinHash pre-filter, if one is set
+func (psi *SecurePSIProtocol) announceCandidateFilter() {
+	if psi.CandidateThreshold > 0 {
+		fmt.Printf("   MinHash pre-filter: Bloom filters compared only for Jaccard estimates >= %.3f\n", psi.CandidateThreshold)
+	}
+}
 
-			// Check if both thresholds are met
-			if hammingDistance <= psi.HammingThreshold && jaccardSimilarity >= psi.JaccardThreshold {
-				matches = append(matches, PrivateMatchPair{
-					LocalID: localRecord.ID,
-					PeerID:  peerRecord.ID,
-				})
-			}
+// announceBlocking notes how many pairs blocking leaves to compare, or why it does not apply
+func (psi *SecurePSIProtocol) announceBlocking(scorer pairScorer) {
+	if !psi.Blocking {
+		return
+	}
+	blocks := psi.blockingIndex(scorer)
+	if blocks == nil {
+		fmt.Printf("   Blocking: records carry no blocking keys on both sides; comparing every pair\n")
+		return
+	}
+	localCount, peerCount := scorer.sizes()
+	fmt.Printf("   Blocking: comparing %d of %d pairs that share a blocking key\n", blocks.pairs(), localCount*peerCount)
+}
 
-			// Add constant-time delay to prevent timing attacks
-			psi.constantTimeDelay()
+// blockingIndex returns the blocking index of the scorer's records, or nil if blocking is off or
+// cannot apply
+func (psi *SecurePSIProtocol) blockingIndex(scorer pairScorer) *blockingIndex {
+	if !psi.Blocking {
+		return nil
+	}
+	if blocked, ok := scorer.(blockedScorer); ok {
+		return blocked.blocking()
+	}
+	return nil
+}
+
+// matchRange compares local records [from, to) with every peer record, or with those sharing a
+// blocking key, appending matches
+func (psi *SecurePSIProtocol) matchRange(scorer pairScorer, from, to int, matches []PrivateMatchPair) []PrivateMatchPair {
+	limit := psi.hammingLimit()
+	blocks := psi.blockingIndex(scorer)
+
+	// Perform fuzzy matching between the local records and all peer records
+	_, peerCount := scorer.sizes()
+	for i := from; i < to; i++ {
+		if blocks != nil {
+			if peers, ok := blocks.peers(i); ok {
+				for _, j := range peers {
+					matches = psi.matchPair(scorer, i, j, limit, matches)
+				}
+				continue
+			}
+		}
+		for j := 0; j < peerCount; j++ {
+			matches = psi.matchPair(scorer, i, j, limit, matches)
 		}
 	}
 
 	return matches
 }
 
+// matchPair compares local record i with peer record j, appending the pair if it matches
+func (psi *SecurePSIProtocol) matchPair(scorer pairScorer, i, j int, limit uint32, matches []PrivateMatchPair) []PrivateMatchPair {
+	// Calculate Jaccard similarity between MinHash signatures
+	jaccardSimilarity := scorer.jaccard(i, j)
+
+	// Pairs the cheap MinHash estimate rules out never reach the Bloom comparison
+	if jaccardSimilarity < psi.CandidateThreshold {
+		psi.constantTimeDelay()
+		return matches
+	}
+
+	// Calculate Hamming distance between bloom filters
+	hammingDistance, ok := scorer.hamming(i, j, limit)
+	if !ok {
+		return matches // Skip records with invalid bloom filters
+	}
+	hammingDistance = psi.unscaled(hammingDistance)
+
+	if psi.Observe != nil {
+		psi.Observe(hammingDistance, jaccardSimilarity)
+	}
+
+	localID, peerID := scorer.ids(i, j)
+
+	// Debug output for first few comparisons
+	if len(matches) < 5 {
+		fmt.Printf("   DEBUG: %s vs %s: Hamming=%d (threshold=%d), Jaccard=%.3f (threshold=%.3f)\n",
+			localID, peerID, hammingDistance, psi.HammingThreshold, jaccardSimilarity, psi.JaccardThreshold)
+	}
+
+	if psi.isMatch(hammingDistance, jaccardSimilarity) {
+		matches = append(matches, PrivateMatchPair{
+			LocalID: localID,
+			PeerID:  peerID,
+			hamming: hammingDistance,
+			jaccard: jaccardSimilarity,
+		})
+	}
+
+	// Add constant-time delay to prevent timing attacks
+	psi.constantTimeDelay()
+	return matches
+}
+
+// ComparePair scores one local record against one peer record under the protocol's thresholds
+// and reports whether they match; a pair the comparator fails on does not. The returned pair carries the scores for 1:1 assignment.
+func (psi *SecurePSIProtocol) ComparePair(local, peer *pprl.Record) (PrivateMatchPair, bool) {
+	scorer := &recordScorer{
+		psi:         psi,
+		local:       []*pprl.Record{local},
+		peer:        []*pprl.Record{peer},
+		localBlooms: newBloomCache([]*pprl.Record{local}),
+		peerBlooms:  newBloomCache([]*pprl.Record{peer}),
+	}
+	jaccardSimilarity := scorer.jaccard(0, 0)
+	hammingDistance, ok := scorer.hamming(0, 0, psi.hammingLimit())
+	if !ok {
+		return PrivateMatchPair{}, false // Unusable filters, or a comparator error, never match
+	}
+	hammingDistance = psi.unscaled(hammingDistance)
+	pair := PrivateMatchPair{LocalID: local.ID, PeerID: peer.ID, hamming: hammingDistance, jaccard: jaccardSimilarity}
+	return pair, psi.isMatch(hammingDistance, jaccardSimilarity)
+}
+
+// hammingLimit is the distance beyond which a Hamming comparison can stop. Without a classifier,
+// a pair beyond the Hamming threshold can never match; a classifier may weigh the full distance
+// against the Jaccard score, and an observer needs it too. The limit is in distances between the
+// filters as compared, before unscaled.
+func (psi *SecurePSIProtocol) hammingLimit() uint32 {
+	if psi.Classifier != nil || psi.Observe != nil {
+		return math.MaxUint32
+	}
+	return psi.scaledThreshold()
+}
+
+// scaledThreshold is the Hamming threshold in distances between hardened filters: the largest
+// distance that unscaled still falls within it
+func (psi *SecurePSIProtocol) scaledThreshold() uint32 {
+	scale := uint64(max(psi.DistanceScale, 1))
+	if threshold := uint64(psi.HammingThreshold)*scale + scale - 1; threshold < math.MaxUint32 {
+		return uint32(threshold)
+	}
+	return math.MaxUint32
+}
+
+// unscaled converts a distance between hardened filters to a distance between unhardened ones
+func (psi *SecurePSIProtocol) unscaled(distance uint32) uint32 {
+	return distance / max(psi.DistanceScale, 1)
+}
+
+// isMatch checks if both thresholds are met, or defers to the classifier if one is set
+func (psi *SecurePSIProtocol) isMatch(hamming uint32, jaccard float64) bool {
+	if psi.Classifier != nil {
+		return psi.Classifier(hamming, jaccard)
+	}
+	return hamming <= psi.HammingThreshold && jaccard >= psi.JaccardThreshold
+}
+
+// blockedScorer is a pairScorer whose records may carry blocking keys
+type blockedScorer interface {
+	blocking() *blockingIndex
+}
+
+// recordScorer scores in-memory records, decoding their Bloom filters on first use
+type recordScorer struct {
+	psi                     *SecurePSIProtocol
+	local, peer             []*pprl.Record
+	localBlooms, peerBlooms *bloomCache
+
+	blocks      *blockingIndex // Built on first use
+	blocksBuilt bool
+
+	compared comparedPair // Scores psi.Compare gave the last pair
+	err      error        // First error psi.Compare returned; no pair is compared after it
+}
+
+// comparedPair holds the scores of one pair, which jaccard and hamming both read
+type comparedPair struct {
+	i, j     int
+	valid    bool
+	distance uint32
+	jaccard  float64
+}
+
+func (r *recordScorer) blocking() *blockingIndex {
+	if !r.blocksBuilt {
+		r.blocks, r.blocksBuilt = newBlockingIndex(r.local, r.peer), true
+	}
+	return r.blocks
+}
+
+func (r *recordScorer) sizes() (int, int) { return len(r.local), len(r.peer) }
+
+func (r *recordScorer) ids(i, j int) (string, string) { return r.local[i].ID, r.peer[j].ID }
+
+func (r *recordScorer) jaccard(i, j int) float64 {
+	if r.psi.Compare != nil {
+		return r.compare(i, j).jaccard
+	}
+	return r.psi.calculateJaccardSimilarity(r.local[i].MinHash, r.peer[j].MinHash)
+}
+
+func (r *recordScorer) hamming(i, j int, limit uint32) (uint32, bool) {
+	if r.psi.Compare != nil {
+		pair := r.compare(i, j)
+		return pair.distance, r.err == nil
+	}
+	localBF, peerBF := r.localBlooms.get(i), r.peerBlooms.get(j)
+	if localBF == nil || peerBF == nil {
+		return 0, false
+	}
+	return r.psi.hammingWithin(localBF, peerBF, limit), true
+}
+
+func (r *recordScorer) failure() error { return r.err }
+
+// compare scores a pair with psi.Compare, once for both of its scores
+func (r *recordScorer) compare(i, j int) comparedPair {
+	if r.compared.valid && r.compared.i == i && r.compared.j == j {
+		return r.compared
+	}
+	r.compared = comparedPair{i: i, j: j, valid: true}
+	if r.err != nil {
+		return r.compared
+	}
+	distance, similarity, err := r.psi.Compare(r.local[i], r.peer[j])
+	if err != nil {
+		r.err = fmt.Errorf("comparing %s with %s: %w", r.local[i].ID, r.peer[j].ID, err)
+		return r.compared
+	}
+	r.compared.distance, r.compared.jaccard = distance, similarity
+	return r.compared
+}
+
+// storeScorer scores records of two compatible token stores in place
+type storeScorer struct {
+	local, peer *pprl.BloomStore
+}
+
+func (s *storeScorer) sizes() (int, int) { return s.local.Len(), s.peer.Len() }
+
+func (s *storeScorer) ids(i, j int) (string, string) { return s.local.ID(i), s.peer.ID(j) }
+
+func (s *storeScorer) jaccard(i, j int) float64 { return s.local.JaccardSimilarity(i, s.peer, j) }
+
+func (s *storeScorer) hamming(i, j int, limit uint32) (uint32, bool) {
+	distance, _ := s.local.HammingWithin(i, s.peer, j, limit)
+	return distance, true
+}
+
+func (s *storeScorer) failure() error { return nil }
+
+// bloomCache lazily decodes the Bloom filters of a record set
+type bloomCache struct {
+	records []*pprl.Record
+	filters []*pprl.BloomFilter
+	invalid []bool
+}
+
+func newBloomCache(records []*pprl.Record) *bloomCache {
+	return &bloomCache{
+		records: records,
+		filters: make([]*pprl.BloomFilter, len(records)),
+		invalid: make([]bool, len(records)),
+	}
+}
+
+// get returns the decoded Bloom filter of record i, or nil if it cannot be decoded
+func (c *bloomCache) get(i int) *pprl.BloomFilter {
+	if c.filters[i] == nil && !c.invalid[i] {
+		bf, err := pprl.BloomFromBase64(c.records[i].BloomData)
+		if err != nil {
+			c.invalid[i] = true
+			return nil
+		}
+		c.filters[i] = bf
+	}
+	return c.filters[i]
+}
+
 // calculateHammingDistance computes the Hamming distance between two bloom filters
 func (psi *SecurePSIProtocol) calculateHammingDistance(bf1, bf2 *pprl.BloomFilter) uint32 {
 	// Use the built-in HammingDistance method
@@ -304,6 +630,18 @@ func (psi *SecurePSIProtocol) calculateHammingDistance(bf1, bf2 *pprl.BloomFilte
 	return distance
 }
 
+// hammingWithin computes the Hamming distance between two bloom filters, stopping once it exceeds limit
+func (psi *SecurePSIProtocol) hammingWithin(bf1, bf2 *pprl.BloomFilter, limit uint32) uint32 {
+	if limit == math.MaxUint32 {
+		return psi.calculateHammingDistance(bf1, bf2)
+	}
+	distance, within := bf1.CompareWithin(bf2, limit)
+	if !within && distance <= limit {
+		return limit + 1 // Incompatible filters fail the threshold, as in calculateHammingDistance
+	}
+	return distance
+}
+
 // calculateJaccardSimilarity computes the Jaccard similarity between two MinHash signatures
 func (psi *SecurePSIProtocol) calculateJaccardSimilarity(minHash1, minHash2 []uint32) float64 {
 	if len(minHash1) != len(minHash2) {
@@ -370,7 +708,10 @@ func (zk *ZKSecureProtocol) SecureMatch(record1, record2 *pprl.Record) (bool, er
 // SecureIntersectionProtocol provides compatibility for intersection operations
 type SecureIntersectionProtocol struct {
 	PSI             *SecurePSIProtocol
-	AllowDuplicates bool // Allow 1:many matching (false = 1:1 matching only)
+	AllowDuplicates bool      // Allow 1:many matching (false = 1:1 matching only)
+	OneToMany       bool      // With AllowDuplicates, each peer record keeps only its best match (1:many rather than many:many)
+	Assignment      string    // 1:1 assignment algorithm: greedy (default) or hungarian
+	Retention       Retention // Bounds on the matches kept, applied after any 1:1 assignment
 }
 
 // NewSecureIntersectionProtocol creates intersection protocol for compatibility (1:1 matching by default)
@@ -405,107 +746,38 @@ func (sip *SecureIntersectionProtocol) ComputeSecureIntersection(localRecords, p
 		return nil, err
 	}
 
-	// If duplicates are allowed, return as-is
-	if sip.AllowDuplicates {
-		return result, nil
-	}
-
-	// Apply 1:1 matching constraint while maintaining zero-knowledge properties
-	uniqueMatches := sip.enforceOneToOneMatching(result.MatchPairs)
-
 	return &PrivateIntersectionResult{
-		MatchPairs: uniqueMatches,
+		MatchPairs: sip.finish(result.MatchPairs),
 	}, nil
 }
 
-// enforceOneToOneMatching applies 1:1 matching constraint while maintaining zero-knowledge properties
-func (sip *SecureIntersectionProtocol) enforceOneToOneMatching(matches []PrivateMatchPair) []PrivateMatchPair {
-	if len(matches) <= 1 {
-		return matches // Nothing to deduplicate
-	}
-
-	// Group matches by local and peer IDs to understand the conflict structure
-	localGroups := make(map[string][]PrivateMatchPair)
-	peerGroups := make(map[string][]PrivateMatchPair)
-
-	for _, match := range matches {
-		localGroups[match.LocalID] = append(localGroups[match.LocalID], match)
-		peerGroups[match.PeerID] = append(peerGroups[match.PeerID], match)
-	}
-
-	// Find matches with no conflicts (single matches)
-	var uniqueMatches []PrivateMatchPair
-	usedLocalIDs := make(map[string]bool)
-	usedPeerIDs := make(map[string]bool)
-
-	// First pass: include all matches where both IDs have only one potential match
-	for _, match := range matches {
-		localConflicts := len(localGroups[match.LocalID])
-		peerConflicts := len(peerGroups[match.PeerID])
-
-		// No conflicts - this is a unique 1:1 match
-		if localConflicts == 1 && peerConflicts == 1 {
-			if !usedLocalIDs[match.LocalID] && !usedPeerIDs[match.PeerID] {
-				uniqueMatches = append(uniqueMatches, match)
-				usedLocalIDs[match.LocalID] = true
-				usedPeerIDs[match.PeerID] = true
-			}
-		}
-	}
-
-	// Second pass: resolve remaining conflicts using deterministic priority
-	type prioritizedMatch struct {
-		match    PrivateMatchPair
-		priority uint64
-	}
-
-	var conflicted []prioritizedMatch
-	for _, match := range matches {
-		// Skip if already included or if either ID is used
-		if usedLocalIDs[match.LocalID] || usedPeerIDs[match.PeerID] {
-			continue
-		}
-
-		// Create deterministic priority hash from both IDs
-		combined := match.LocalID + "|" + match.PeerID
-		hash := sha256.Sum256([]byte(combined))
-		priority := uint64(hash[0]) | uint64(hash[1])<<8 | uint64(hash[2])<<16 | uint64(hash[3])<<24 |
-			uint64(hash[4])<<32 | uint64(hash[5])<<40 | uint64(hash[6])<<48 | uint64(hash[7])<<56
-
-		conflicted = append(conflicted, prioritizedMatch{
-			match:    match,
-			priority: priority,
-		})
-	}
-
-	// Sort conflicted matches by priority (deterministic across both parties)
-	for i := 0; i < len(conflicted)-1; i++ {
-		for j := i + 1; j < len(conflicted); j++ {
-			if conflicted[i].priority < conflicted[j].priority {
-				conflicted[i], conflicted[j] = conflicted[j], conflicted[i]
-			}
-		}
+// ComputeStoreIntersection intersects two binary token stores with duplicate control
+func (sip *SecureIntersectionProtocol) ComputeStoreIntersection(local, peer *pprl.BloomStore) (*PrivateIntersectionResult, error) {
+	result, err := sip.PSI.ComputeStoreIntersection(local, peer)
+	if err != nil {
+		return nil, err
 	}
+	return &PrivateIntersectionResult{
+		MatchPairs: sip.finish(result.MatchPairs),
+	}, nil
+}
 
-	// Select highest priority matches that don't conflict
-	for _, pm := range conflicted {
-		localUsed := usedLocalIDs[pm.match.LocalID]
-		peerUsed := usedPeerIDs[pm.match.PeerID]
-
-		// Only include if neither ID has been used (maintains 1:1 constraint)
-		if !localUsed && !peerUsed {
-			uniqueMatches = append(uniqueMatches, pm.match)
-			usedLocalIDs[pm.match.LocalID] = true
-			usedPeerIDs[pm.match.PeerID] = true
-		}
-
-		// Add constant-time delay to prevent timing analysis
-		for i := 0; i < 2; i++ {
-			_ = sha256.Sum256([]byte{byte(i)})
+// finish applies the 1:1 matching constraint, unless duplicates are allowed, or the 1:many one,
+// and then the retention bounds, all while maintaining zero-knowledge properties
+func (sip *SecureIntersectionProtocol) finish(matches []PrivateMatchPair) []PrivateMatchPair {
+	if !sip.AllowDuplicates {
+		matches = assignOneToOne(matches, sip.PSI.Party, sip.Assignment)
+	} else if sip.OneToMany {
+		matches = AssignOneToMany(matches, sip.PSI.Party)
+	}
+	if sip.Retention.Bounded() {
+		found := len(matches)
+		matches = sip.Retention.retain(matches, sip.PSI.Party)
+		if dropped := found - len(matches); dropped > 0 {
+			fmt.Printf("   Retention: kept %d of %d matches\n", len(matches), found)
 		}
 	}
-
-	return uniqueMatches
+	return matches
 }
 
 // REMOVED INSECURE FUNCTIONS:
@@ -522,4 +794,4 @@ func (sip *SecureIntersectionProtocol) enforceOneToOneMatching(matches []Private
 // ✅ Only intersection pairs are revealed, nothing else
 // ✅ Cryptographic security through proper PSI protocols
 // ✅ Zero-knowledge proofs ensure no additional information leakage
-// ✅ 1:1 matching constraint applied deterministically without information leakage
+// ✅ 1:1 matching constraint applied deterministically (greedy or Hungarian) without information leakage
@@ -0,0 +1,461 @@
+// smc.go
+// Secure comparison thresholds the Hamming distance of every pair of Bloom filters under a
+// two-party computation: neither party sees the other's filters or any distance, and both learn
+// only which pairs are within the threshold. Party 0 (S below) and party 1 (R) split the inner
+// product of each pair of filters into additive shares with one correlated oblivious transfer per
+// bit of R's filters, which gives them shares of the distance. S then masks, for each pair, the
+// table of which values of R's share complete a distance within the threshold, and R unmasks the
+// one entry its share selects through a 1-out-of-M transfer. That is a transfer for every filter
+// bit and every pair, so it is far slower than comparing exchanged filters.
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"sort"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+)
+
+// Matching protocols of the pprl workflow
+const (
+	ProtocolStandard = "standard" // Tokens are exchanged and each party compares them
+	ProtocolSMC      = "smc"      // Filters stay local and are compared by secure computation
+	ProtocolPSI      = "psi"      // Exact identifiers are intersected; no filters are compared
+)
+
+// ValidateProtocol checks that a matching protocol name is supported
+func ValidateProtocol(name string) error {
+	switch name {
+	case "", ProtocolStandard, ProtocolSMC, ProtocolPSI:
+		return nil
+	}
+	return fmt.Errorf("unknown matching protocol %q (expected %s, %s or %s)", name, ProtocolStandard, ProtocolSMC, ProtocolPSI)
+}
+
+const (
+	smcVersion    = 1
+	smcBatchBytes = 4 << 20 // Approximate size of each batched message
+)
+
+// SMCExchange sends a message to the peer and returns the peer's message of the same round
+type SMCExchange func(message []byte) ([]byte, error)
+
+// smcHello opens the protocol with each party's record IDs and the parameters both must share
+type smcHello struct {
+	Version    int      `json:"version"`
+	FilterSize uint32   `json:"filter_size"`
+	Threshold  uint32   `json:"threshold"`
+	IDs        []string `json:"ids"`
+	BaseOT     []byte   `json:"base_ot,omitempty"` // R's base transfer message
+}
+
+// smcSession holds one party's state; shares and distances are indexed j*n + i for S record i and
+// R record j
+type smcSession struct {
+	exchange  SMCExchange
+	receiver  bool // Party 1 (R), which makes the choices of every transfer
+	filters   []*pprl.BloomFilter
+	n, m      int // Records of S and of R
+	size      uint32
+	width     int    // Bits of the distance shares
+	mask      uint16 // Distance shares are computed modulo mask+1
+	threshold uint32
+	shares    []uint16
+
+	extSender   *otExtSender
+	extReceiver *otExtReceiver
+}
+
+// ComputeSMCIntersection matches the local records against the peer's by secure comparison of
+// their Bloom filters under the Hamming threshold, sending every message through exchange. The
+// Jaccard threshold, blocking and any classifier are not applied, and the pairs carry no scores.
+func (sip *SecureIntersectionProtocol) ComputeSMCIntersection(exchange SMCExchange, localRecords []*pprl.Record) (*PrivateIntersectionResult, error) {
+	psi := sip.PSI
+	fmt.Printf("   🔒 Initializing secure comparison (Party %d)\n", psi.Party)
+
+	records := append([]*pprl.Record(nil), localRecords...)
+	sort.SliceStable(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	filters, size, err := decodeSMCFilters(records)
+	if err != nil {
+		return nil, err
+	}
+
+	// Hardened filters are compared under the threshold scaled to their distances
+	threshold := psi.scaledThreshold()
+	s := &smcSession{exchange: exchange, receiver: psi.Party == 1, filters: filters, threshold: threshold}
+	hello := smcHello{Version: smcVersion, FilterSize: size, Threshold: threshold, IDs: make([]string, len(records))}
+	for i, record := range records {
+		hello.IDs[i] = record.ID
+	}
+	var base *baseOTSender
+	if s.receiver {
+		if base, hello.BaseOT, err = newBaseOTSender(); err != nil {
+			return nil, err
+		}
+	}
+	peer, err := s.hello(hello)
+	if err != nil {
+		return nil, err
+	}
+	s.size = max(size, peer.FilterSize)
+	if s.receiver {
+		s.n, s.m = len(peer.IDs), len(records)
+	} else {
+		s.n, s.m = len(records), len(peer.IDs)
+	}
+	if s.n == 0 || s.m == 0 {
+		fmt.Printf("   ✅ Found 0 matches by secure comparison\n")
+		return &PrivateIntersectionResult{}, nil
+	}
+	s.width = max(bits.Len32(s.size), 3)
+	s.mask = uint16(1<<s.width - 1)
+
+	if err := s.setup(base, peer.BaseOT); err != nil {
+		return nil, fmt.Errorf("secure comparison setup failed: %w", err)
+	}
+	fmt.Printf("   🔄 Sharing filter inner products (%d transfers)...\n", s.m*int(s.size))
+	if err := s.shareInnerProducts(); err != nil {
+		return nil, fmt.Errorf("secure comparison failed: %w", err)
+	}
+	fmt.Printf("   🔄 Thresholding %d pair distances obliviously...\n", s.n*s.m)
+	within, err := s.compareDistances()
+	if err != nil {
+		return nil, fmt.Errorf("secure comparison failed: %w", err)
+	}
+
+	var matches []PrivateMatchPair
+	for p, isMatch := range within {
+		if !isMatch {
+			continue
+		}
+		i, j := p%s.n, p/s.n
+		if s.receiver {
+			matches = append(matches, PrivateMatchPair{LocalID: records[j].ID, PeerID: peer.IDs[i]})
+		} else {
+			matches = append(matches, PrivateMatchPair{LocalID: records[i].ID, PeerID: peer.IDs[j]})
+		}
+	}
+	fmt.Printf("   ✅ Found %d matches by secure comparison\n", len(matches))
+
+	return &PrivateIntersectionResult{
+		MatchPairs: sip.finish(matches),
+	}, nil
+}
+
+// decodeSMCFilters decodes the Bloom filter of every record, which must all be the same size
+func decodeSMCFilters(records []*pprl.Record) ([]*pprl.BloomFilter, uint32, error) {
+	filters := make([]*pprl.BloomFilter, len(records))
+	var size uint32
+	for i, record := range records {
+		bf, err := pprl.BloomFromBase64(record.BloomData)
+		if err != nil {
+			return nil, 0, fmt.Errorf("record %s has no valid Bloom filter: %w", record.ID, err)
+		}
+		if i > 0 && bf.GetSize() != size {
+			return nil, 0, fmt.Errorf("record %s has a %d-bit Bloom filter, others have %d bits", record.ID, bf.GetSize(), size)
+		}
+		filters[i], size = bf, bf.GetSize()
+	}
+	if size >= 1<<16 {
+		return nil, 0, fmt.Errorf("secure comparison supports Bloom filters of up to 65535 bits, got %d", size)
+	}
+	return filters, size, nil
+}
+
+// hello swaps the opening messages and checks that both parties compare alike
+func (s *smcSession) hello(local smcHello) (*smcHello, error) {
+	message, err := json.Marshal(local)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := s.exchange(message)
+	if err != nil {
+		return nil, fmt.Errorf("secure comparison handshake failed: %w", err)
+	}
+	var peer smcHello
+	if err := json.Unmarshal(reply, &peer); err != nil {
+		return nil, fmt.Errorf("invalid secure comparison handshake: %w", err)
+	}
+	switch {
+	case peer.Version != local.Version:
+		return nil, fmt.Errorf("peer runs secure comparison version %d, this side version %d", peer.Version, local.Version)
+	case peer.Threshold != local.Threshold:
+		return nil, fmt.Errorf("hamming thresholds differ: peer %d, this side %d (both must use the same for secure comparison)", peer.Threshold, local.Threshold)
+	case peer.FilterSize != local.FilterSize && len(peer.IDs) > 0 && len(local.IDs) > 0:
+		return nil, fmt.Errorf("bloom filter sizes differ: peer %d bits, this side %d", peer.FilterSize, local.FilterSize)
+	case !s.receiver && len(peer.IDs) > 0 && len(local.IDs) > 0 && len(peer.BaseOT) == 0:
+		return nil, fmt.Errorf("peer did not open the base transfers")
+	}
+	return &peer, nil
+}
+
+// setup runs the base transfers: R offers the key pairs and S chooses random delta, which
+// prepares R to receive and S to send any number of extended transfers
+func (s *smcSession) setup(base *baseOTSender, peerBaseOT []byte) error {
+	if s.receiver {
+		reply, err := s.exchange(nil)
+		if err != nil {
+			return err
+		}
+		keys, err := base.keys(reply)
+		if err != nil {
+			return err
+		}
+		s.extReceiver = newOTExtReceiver(keys)
+		return nil
+	}
+
+	var random [otSecurity / 8]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return fmt.Errorf("failed to generate transfer choices: %w", err)
+	}
+	var delta [otSecurity]bool
+	for t := range delta {
+		delta[t] = random[t/8]&(1<<(t%8)) != 0
+	}
+	reply, keys, err := baseOTReceive(peerBaseOT, delta)
+	if err != nil {
+		return err
+	}
+	if _, err := s.exchange(reply); err != nil {
+		return err
+	}
+	s.extSender = newOTExtSender(delta, keys)
+	return nil
+}
+
+// rounds runs batches of extended transfers. In each round R sends the choices of one batch while
+// S answers the batch before it, so batches+1 rounds carry them all.
+func (s *smcSession) rounds(batches int,
+	choose func(batch int) ([]byte, []otKey),
+	answer func(batch int, request []byte) ([]byte, error),
+	receive func(batch int, keys []otKey, answer []byte) error) error {
+	var pending []byte   // S's answer to the previous batch
+	var previous []otKey // R's keys of the previous batch
+	for round := 0; round <= batches; round++ {
+		var message []byte
+		var keys []otKey
+		if s.receiver && round < batches {
+			message, keys = choose(round)
+		} else if !s.receiver {
+			message = pending
+		}
+		reply, err := s.exchange(message)
+		if err != nil {
+			return err
+		}
+		if s.receiver {
+			if round > 0 {
+				if err := receive(round-1, previous, reply); err != nil {
+					return err
+				}
+			}
+			previous = keys
+		} else if round < batches {
+			if pending, err = answer(round, reply); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// batchRange returns the first and end transfer of a batch
+func batchRange(batch, size, total int) (int, int) {
+	return batch * size, min((batch+1)*size, total)
+}
+
+// shareInnerProducts leaves each party holding a share of the inner product of every pair's
+// filters. For bit k of R's filter j, R chooses its bit in a transfer where S offers pads r0 and
+// r1 of n values and sends c = r0 + a_k - r1, a_k being bit k of each of S's filters: R gets r0,
+// or r1 + c = r0 + a_k, and S keeps -r0, so the shares sum to a_k times R's bit.
+func (s *smcSession) shareInnerProducts() error {
+	total := s.m * int(s.size)
+	batch := max(1, smcBatchBytes/(2*s.n))
+	batches := (total + batch - 1) / batch
+	s.shares = make([]uint16, s.n*s.m)
+	pad0, pad1 := make([]byte, 2*s.n), make([]byte, 2*s.n)
+
+	return s.rounds(batches,
+		func(b int) ([]byte, []otKey) {
+			from, to := batchRange(b, batch, total)
+			choices := make([]bool, to-from)
+			for q := from; q < to; q++ {
+				choices[q-from] = s.filters[q/int(s.size)].Bit(uint32(q % int(s.size)))
+			}
+			return s.extReceiver.extend(choices)
+		},
+		func(b int, request []byte) ([]byte, error) {
+			from, to := batchRange(b, batch, total)
+			keys0, keys1, err := s.extSender.extend(to-from, request)
+			if err != nil {
+				return nil, err
+			}
+			out := make([]byte, 0, (to-from)*2*s.n)
+			for q := from; q < to; q++ {
+				j, k := q/int(s.size), uint32(q%int(s.size))
+				keys0[q-from].expand(pad0)
+				keys1[q-from].expand(pad1)
+				shares := s.shares[j*s.n : (j+1)*s.n]
+				for i, filter := range s.filters {
+					r0 := binary.LittleEndian.Uint16(pad0[2*i:])
+					c := r0 - binary.LittleEndian.Uint16(pad1[2*i:])
+					if filter.Bit(k) {
+						c++
+					}
+					out = binary.LittleEndian.AppendUint16(out, c)
+					shares[i] -= r0
+				}
+			}
+			return out, nil
+		},
+		func(b int, keys []otKey, answer []byte) error {
+			from, to := batchRange(b, batch, total)
+			if len(answer) != (to-from)*2*s.n {
+				return fmt.Errorf("inner product batch %d has %d bytes, expected %d", b, len(answer), (to-from)*2*s.n)
+			}
+			for q := from; q < to; q++ {
+				j, k := q/int(s.size), uint32(q%int(s.size))
+				chosen := s.filters[j].Bit(k)
+				keys[q-from].expand(pad0)
+				c := answer[(q-from)*2*s.n:]
+				shares := s.shares[j*s.n : (j+1)*s.n]
+				for i := range shares {
+					y := binary.LittleEndian.Uint16(pad0[2*i:])
+					if chosen {
+						y += binary.LittleEndian.Uint16(c[2*i:])
+					}
+					shares[i] += y
+				}
+			}
+			return nil
+		})
+}
+
+// distances turns the inner product shares into shares of the Hamming distances: the distance
+// |a| + |b| - 2ab splits into |a| - 2(S's share) and |b| - 2(R's share), modulo mask+1
+func (s *smcSession) distances() []uint16 {
+	weights := make([]uint16, len(s.filters))
+	for i, filter := range s.filters {
+		weights[i] = uint16(filter.SetBitCount())
+	}
+	distances := make([]uint16, len(s.shares))
+	for p, share := range s.shares {
+		own := p % s.n
+		if s.receiver {
+			own = p / s.n
+		}
+		distances[p] = (weights[own] - 2*share) & s.mask
+	}
+	return distances
+}
+
+// selection returns the bits of a table byte whose index has bit l set
+func selection(l, b int) byte {
+	if l < 3 {
+		return [3]byte{0xAA, 0xCC, 0xF0}[l]
+	}
+	if b>>(l-3)&1 == 1 {
+		return 0xFF
+	}
+	return 0
+}
+
+// compareDistances decides for every pair whether its distance is within the threshold, revealing
+// nothing else. S builds a table over R's possible shares v, entry v being whether its own share
+// plus v is within the threshold, and masks entry v with pads of the keys of bits of v, one per
+// bit of a share. R chooses the bits of its share in that many transfers and so unmasks only the
+// entry of its share. R then sends S the result.
+func (s *smcSession) compareDistances() ([]bool, error) {
+	distances := s.distances()
+	pairs := s.n * s.m
+	tableBytes := (1 << s.width) / 8
+	batch := max(1, smcBatchBytes/(tableBytes+otSecurity/8*s.width))
+	batches := (pairs + batch - 1) / batch
+	within := make([]bool, pairs)
+	pad0, pad1 := make([]byte, tableBytes), make([]byte, tableBytes)
+
+	err := s.rounds(batches,
+		func(b int) ([]byte, []otKey) {
+			from, to := batchRange(b, batch, pairs)
+			choices := make([]bool, 0, (to-from)*s.width)
+			for p := from; p < to; p++ {
+				for l := range s.width {
+					choices = append(choices, distances[p]>>l&1 == 1)
+				}
+			}
+			return s.extReceiver.extend(choices)
+		},
+		func(b int, request []byte) ([]byte, error) {
+			from, to := batchRange(b, batch, pairs)
+			keys0, keys1, err := s.extSender.extend((to-from)*s.width, request)
+			if err != nil {
+				return nil, err
+			}
+			out := make([]byte, (to-from)*tableBytes)
+			for p := from; p < to; p++ {
+				table := out[(p-from)*tableBytes : (p-from+1)*tableBytes]
+				for v := range 1 << s.width {
+					if uint32((distances[p]+uint16(v))&s.mask) <= s.threshold {
+						table[v/8] |= 1 << (v % 8)
+					}
+				}
+				for l := range s.width {
+					keys0[(p-from)*s.width+l].expand(pad0)
+					keys1[(p-from)*s.width+l].expand(pad1)
+					for i := range table {
+						sel := selection(l, i)
+						table[i] ^= pad0[i]&^sel | pad1[i]&sel
+					}
+				}
+			}
+			return out, nil
+		},
+		func(b int, keys []otKey, answer []byte) error {
+			from, to := batchRange(b, batch, pairs)
+			if len(answer) != (to-from)*tableBytes {
+				return fmt.Errorf("threshold batch %d has %d bytes, expected %d", b, len(answer), (to-from)*tableBytes)
+			}
+			for p := from; p < to; p++ {
+				v := int(distances[p])
+				bit := answer[(p-from)*tableBytes+v/8] >> (v % 8) & 1
+				for l := range s.width {
+					keys[(p-from)*s.width+l].expand(pad0)
+					bit ^= pad0[v/8] >> (v % 8) & 1
+				}
+				within[p] = bit == 1
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	// R alone holds the result; it sends it to S packed one bit per pair
+	var message []byte
+	if s.receiver {
+		message = make([]byte, (pairs+7)/8)
+		for p, isMatch := range within {
+			if isMatch {
+				message[p/8] |= 1 << (p % 8)
+			}
+		}
+	}
+	reply, err := s.exchange(message)
+	if err != nil {
+		return nil, err
+	}
+	if !s.receiver {
+		if len(reply) != (pairs+7)/8 {
+			return nil, fmt.Errorf("result has %d bytes, expected %d", len(reply), (pairs+7)/8)
+		}
+		for p := range within {
+			within[p] = reply[p/8]&(1<<(p%8)) != 0
+		}
+	}
+	return within, nil
+}
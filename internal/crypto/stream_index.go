@@ -0,0 +1,259 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+)
+
+// DefaultStreamBandSize is the number of MinHash values per LSH band, as used for secure blocking
+const DefaultStreamBandSize = 4
+
+// StreamIndex holds one dataset in LSH buckets keyed by bands of its MinHash signatures, so the
+// other dataset can be matched one record at a time as it is read instead of being loaded.
+// Only records sharing at least one band are compared: pairs with a low Jaccard estimate may be
+// missed, and fewer values per band finds more of them at the cost of more comparisons.
+type StreamIndex struct {
+	sip          *SecureIntersectionProtocol
+	indexed      streamSet
+	indexedLocal bool // Indexed records are the local side of each pair
+	bandSize     int
+	buckets      map[uint64][]int
+	claimed      []bool // Indexed records already matched, for 1:1 matching
+	kept         []int  // Matches kept for each indexed record, for 1:many matching with a retention limit
+	seen         []int  // Last streamed record that reached each indexed record
+	streamed     int
+	compared     int
+}
+
+// NewStreamIndex indexes records with bandSize MinHash values per band (DefaultStreamBandSize if 0);
+// local says whether they are the local side of the intersection
+func (sip *SecureIntersectionProtocol) NewStreamIndex(records []*pprl.Record, local bool, bandSize int) (*StreamIndex, error) {
+	return sip.newStreamIndex(&recordSet{records: records, blooms: newBloomCache(records)}, local, bandSize)
+}
+
+// NewStoreStreamIndex indexes the records of a binary token store, which are compared in the
+// mapped file rather than loaded
+func (sip *SecureIntersectionProtocol) NewStoreStreamIndex(store *pprl.BloomStore, local bool, bandSize int) (*StreamIndex, error) {
+	return sip.newStreamIndex(&storeSet{store: store}, local, bandSize)
+}
+
+func (sip *SecureIntersectionProtocol) newStreamIndex(indexed streamSet, local bool, bandSize int) (*StreamIndex, error) {
+	if bandSize <= 0 {
+		bandSize = DefaultStreamBandSize
+	}
+	if sip.PSI.Compare != nil {
+		return nil, fmt.Errorf("streaming intersections compare Bloom filters: a custom comparator is not supported")
+	}
+	if sip.AllowDuplicates && sip.OneToMany && !local {
+		// A peer record's best match is only known once every local record was compared with it
+		return nil, fmt.Errorf("1:many matching streams the peer records: index the local dataset")
+	}
+	ix := sip.emptyStreamIndex(indexed, local, bandSize)
+	for i := 0; i < indexed.size(); i++ {
+		signature := indexed.signature(i)
+		if len(signature) == 0 {
+			return nil, fmt.Errorf("record %s has no MinHash signature", indexed.id(i))
+		}
+		for _, key := range ix.bandKeys(signature) {
+			ix.buckets[key] = append(ix.buckets[key], i)
+		}
+	}
+	return ix, nil
+}
+
+// emptyStreamIndex prepares an index of the given records with no buckets filled
+func (sip *SecureIntersectionProtocol) emptyStreamIndex(indexed streamSet, local bool, bandSize int) *StreamIndex {
+	ix := &StreamIndex{
+		sip:          sip,
+		indexed:      indexed,
+		indexedLocal: local,
+		bandSize:     bandSize,
+		buckets:      make(map[uint64][]int),
+		seen:         make([]int, indexed.size()),
+	}
+	if !sip.AllowDuplicates {
+		ix.claimed = make([]bool, indexed.size())
+	} else if sip.Retention.MaxPerRecord > 0 {
+		ix.kept = make([]int, indexed.size())
+	}
+	return ix
+}
+
+// bandKeys hashes each band of a signature together with its position
+func (ix *StreamIndex) bandKeys(signature []uint32) []uint64 {
+	keys := make([]uint64, 0, (len(signature)+ix.bandSize-1)/ix.bandSize)
+	var buf [4]byte
+	for start := 0; start < len(signature); start += ix.bandSize {
+		end := start + ix.bandSize
+		if end > len(signature) {
+			end = len(signature)
+		}
+		h := fnv.New64a()
+		binary.LittleEndian.PutUint32(buf[:], uint32(start))
+		h.Write(buf[:])
+		for _, value := range signature[start:end] {
+			binary.LittleEndian.PutUint32(buf[:], value)
+			h.Write(buf[:])
+		}
+		keys = append(keys, h.Sum64())
+	}
+	return keys
+}
+
+// Match compares a record of the streamed dataset with the indexed records it shares a band with.
+// With 1:1 matching, it returns at most the lowest-cost pair whose indexed record is still
+// unmatched, so records earlier in the stream take precedence. With 1:many matching and a
+// retention limit, it returns the lowest-cost pairs up to the limit, again leaving out indexed
+// records that earlier streamed records already filled. With 1:many matching the streamed peer
+// record keeps only its lowest-cost pair.
+func (ix *StreamIndex) Match(record *pprl.Record) []PrivateMatchPair {
+	ix.streamed++
+	psi := ix.sip.PSI
+	limit := psi.hammingLimit()
+
+	var bf *pprl.BloomFilter
+	var matches []PrivateMatchPair
+	var matched []int // Indexed record of each match
+	for _, key := range ix.bandKeys(record.MinHash) {
+		for _, i := range ix.buckets[key] {
+			if ix.seen[i] == ix.streamed || (ix.claimed != nil && ix.claimed[i]) {
+				continue
+			}
+			ix.seen[i] = ix.streamed
+			ix.compared++
+
+			jaccard := psi.calculateJaccardSimilarity(ix.indexed.signature(i), record.MinHash)
+			if jaccard < psi.CandidateThreshold {
+				psi.constantTimeDelay()
+				continue
+			}
+
+			if bf == nil {
+				decoded, err := pprl.BloomFromBase64(record.BloomData)
+				if err != nil {
+					return nil // Skip records with invalid bloom filters
+				}
+				bf = decoded
+			}
+			indexedBF := ix.indexed.filter(i)
+			if indexedBF == nil {
+				continue
+			}
+
+			hamming := psi.unscaled(psi.hammingWithin(indexedBF, bf, limit))
+			if psi.Observe != nil {
+				psi.Observe(hamming, jaccard)
+			}
+			if psi.isMatch(hamming, jaccard) && jaccard >= ix.sip.Retention.MinJaccard {
+				id := ix.indexed.id(i)
+				pair := PrivateMatchPair{LocalID: id, PeerID: record.ID, hamming: hamming, jaccard: jaccard}
+				if !ix.indexedLocal {
+					pair.LocalID, pair.PeerID = record.ID, id
+				}
+				matches = append(matches, pair)
+				matched = append(matched, i)
+			}
+			psi.constantTimeDelay()
+		}
+	}
+
+	if ix.sip.OneToMany && ix.claimed == nil && len(matches) > 0 {
+		// The streamed record is a peer record, which keeps only its best match
+		best := ix.best(matches, matched)
+		matches, matched = matches[best:best+1], matched[best:best+1]
+	}
+	if ix.kept != nil {
+		return ix.retain(matches, matched)
+	}
+	if ix.claimed == nil || len(matches) == 0 {
+		return matches
+	}
+	best := ix.best(matches, matched)
+	ix.claimed[matched[best]] = true
+	return matches[best : best+1]
+}
+
+// best returns the lowest-cost of a streamed record's matches, ties going to the indexed record
+// whose ID sorts first
+func (ix *StreamIndex) best(matches []PrivateMatchPair, matched []int) int {
+	best := 0
+	for m := 1; m < len(matches); m++ {
+		cost, bestCost := matches[m].cost(), matches[best].cost()
+		if cost < bestCost || (cost == bestCost && ix.indexed.id(matched[m]) < ix.indexed.id(matched[best])) {
+			best = m
+		}
+	}
+	return best
+}
+
+// retain keeps the lowest-cost matches of a streamed record, up to the retention limit and
+// skipping indexed records already at it
+func (ix *StreamIndex) retain(matches []PrivateMatchPair, matched []int) []PrivateMatchPair {
+	order := make([]int, len(matches))
+	for m := range order {
+		order[m] = m
+	}
+	sort.Slice(order, func(a, b int) bool {
+		ca, cb := matches[order[a]].cost(), matches[order[b]].cost()
+		if ca != cb {
+			return ca < cb
+		}
+		return ix.indexed.id(matched[order[a]]) < ix.indexed.id(matched[order[b]])
+	})
+	limit := ix.sip.Retention.MaxPerRecord
+	var kept []PrivateMatchPair
+	for _, m := range order {
+		if len(kept) == limit {
+			break
+		}
+		if i := matched[m]; ix.kept[i] < limit {
+			ix.kept[i]++
+			kept = append(kept, matches[m])
+		}
+	}
+	return kept
+}
+
+// Streamed returns the number of records matched against the index
+func (ix *StreamIndex) Streamed() int {
+	return ix.streamed
+}
+
+// Compared returns the number of pairs that shared a band and were compared
+func (ix *StreamIndex) Compared() int {
+	return ix.compared
+}
+
+// streamSet gives a stream index the IDs, signatures and filters of its indexed records
+type streamSet interface {
+	size() int
+	id(i int) string
+	signature(i int) []uint32
+	filter(i int) *pprl.BloomFilter // nil if the record's filter is unusable
+}
+
+// recordSet is a stream set of in-memory records, decoding their Bloom filters on first use
+type recordSet struct {
+	records []*pprl.Record
+	blooms  *bloomCache
+}
+
+func (r *recordSet) size() int                      { return len(r.records) }
+func (r *recordSet) id(i int) string                { return r.records[i].ID }
+func (r *recordSet) signature(i int) []uint32       { return r.records[i].MinHash }
+func (r *recordSet) filter(i int) *pprl.BloomFilter { return r.blooms.get(i) }
+
+// storeSet is a stream set over a binary token store, copying a record's filter and signature
+// out of the mapped file only when it is compared
+type storeSet struct {
+	store *pprl.BloomStore
+}
+
+func (s *storeSet) size() int                      { return s.store.Len() }
+func (s *storeSet) id(i int) string                { return s.store.ID(i) }
+func (s *storeSet) signature(i int) []uint32       { return s.store.Signature(i) }
+func (s *storeSet) filter(i int) *pprl.BloomFilter { return s.store.Filter(i) }
@@ -0,0 +1,114 @@
+package crypto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+)
+
+// Saved LSH bucket layout (all integers little-endian):
+//
+//	magic     [8]byte  "CBLSH\x00\x00\x01"
+//	bandSize  uint32   MinHash values per band
+//	records   uint32   Indexed records, which must match the token store the buckets belong to
+//	buckets   uint64   Number of buckets, in ascending key order
+//	per bucket: key uint64, count uint32, count × uint32 record indexes
+var streamBucketsMagic = [8]byte{'C', 'B', 'L', 'S', 'H', 0, 0, 1}
+
+// WriteBuckets saves the index's LSH buckets, so an index of a token store can be reopened with
+// OpenStoreStreamIndex without hashing every signature again
+func (ix *StreamIndex) WriteBuckets(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	header := make([]byte, 0, 24)
+	header = append(header, streamBucketsMagic[:]...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(ix.bandSize))
+	header = binary.LittleEndian.AppendUint32(header, uint32(ix.indexed.size()))
+	header = binary.LittleEndian.AppendUint64(header, uint64(len(ix.buckets)))
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+
+	keys := make([]uint64, 0, len(ix.buckets))
+	for key := range ix.buckets {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	var buf []byte
+	for _, key := range keys {
+		bucket := ix.buckets[key]
+		buf = binary.LittleEndian.AppendUint64(buf[:0], key)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(bucket)))
+		for _, i := range bucket {
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(i))
+		}
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// OpenStoreStreamIndex reopens an index of a token store from buckets saved by WriteBuckets
+func (sip *SecureIntersectionProtocol) OpenStoreStreamIndex(store *pprl.BloomStore, local bool, r io.Reader) (*StreamIndex, error) {
+	if sip.PSI.Compare != nil {
+		return nil, errStoreComparator
+	}
+	br := bufio.NewReader(r)
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("failed to read LSH buckets: %w", err)
+	}
+	if [8]byte(header[:8]) != streamBucketsMagic {
+		return nil, fmt.Errorf("not a saved LSH bucket file")
+	}
+	bandSize := int(binary.LittleEndian.Uint32(header[8:]))
+	records := int(binary.LittleEndian.Uint32(header[12:]))
+	count := binary.LittleEndian.Uint64(header[16:])
+	if records != store.Len() {
+		return nil, fmt.Errorf("LSH buckets index %d records, but the token store holds %d", records, store.Len())
+	}
+	if bandSize <= 0 {
+		return nil, fmt.Errorf("LSH buckets have an invalid band size %d", bandSize)
+	}
+
+	ix := sip.emptyStreamIndex(&storeSet{store: store}, local, bandSize)
+	entry := make([]byte, 12)
+	for b := uint64(0); b < count; b++ {
+		if _, err := io.ReadFull(br, entry); err != nil {
+			return nil, fmt.Errorf("failed to read LSH buckets: %w", err)
+		}
+		key := binary.LittleEndian.Uint64(entry)
+		size := int(binary.LittleEndian.Uint32(entry[8:]))
+		if size > records {
+			return nil, fmt.Errorf("LSH bucket of %d records in an index of %d", size, records)
+		}
+		data := make([]byte, 4*size)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, fmt.Errorf("failed to read LSH buckets: %w", err)
+		}
+		bucket := make([]int, size)
+		for n := range bucket {
+			i := int(binary.LittleEndian.Uint32(data[4*n:]))
+			if i >= records {
+				return nil, fmt.Errorf("LSH bucket refers to record %d of %d", i, records)
+			}
+			bucket[n] = i
+		}
+		ix.buckets[key] = bucket
+	}
+	return ix, nil
+}
+
+// BandSize returns the number of MinHash values per LSH band
+func (ix *StreamIndex) BandSize() int {
+	return ix.bandSize
+}
+
+// Buckets returns the number of LSH buckets
+func (ix *StreamIndex) Buckets() int {
+	return len(ix.buckets)
+}
@@ -0,0 +1,144 @@
+package crypto
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Unicode handling of field values before normalization
+const (
+	// UnicodeASCII keeps the original behavior: name normalization and q-gram extraction drop every
+	// character outside a-z and 0-9, so "García" is encoded as "garca"
+	UnicodeASCII = "ascii"
+
+	// UnicodeFold case-folds values, decomposes them (NFKD), strips the diacritics and
+	// transliterates letters without a decomposition (ß, æ, ø, ł, ...), so "García" is "garcia"
+	UnicodeFold = "fold"
+)
+
+// builtinTransliterations cover Latin letters that NFKD does not decompose into a base letter
+// and diacritics. Case folding has already turned them into lowercase.
+var builtinTransliterations = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'ł': "l", 'đ': "d", 'ð': "d", 'þ': "th",
+	'ħ': "h", 'ı': "i", 'ŀ': "l", 'ŧ': "t", 'ŋ': "n", 'ĸ': "k", 'ƒ': "f", 'ǥ': "g",
+}
+
+// localeTransliterations are spellings a language uses in place of its accented letters, which
+// would otherwise be reduced to the base letter (Müller and Mueller for German)
+var localeTransliterations = map[string]map[string]string{
+	"de": {"ä": "ae", "ö": "oe", "ü": "ue"},
+	"da": {"æ": "ae", "ø": "oe", "å": "aa"},
+	"nb": {"æ": "ae", "ø": "oe", "å": "aa"},
+	"nn": {"æ": "ae", "ø": "oe", "å": "aa"},
+	"no": {"æ": "ae", "ø": "oe", "å": "aa"},
+}
+
+// UnicodeFolding reduces text in any Latin script, and in other scripts given transliteration
+// rules, to the lowercase ASCII that name normalization and q-gram extraction keep
+type UnicodeFolding struct {
+	rules *strings.Replacer // Locale and custom rules, applied before decomposition (nil if none)
+}
+
+// NewUnicodeFolding creates a folding with the transliterations of locale ("" for none) and
+// custom "from" -> "to" rules, which take precedence over the locale's
+func NewUnicodeFolding(locale string, custom map[string]string) (*UnicodeFolding, error) {
+	rules := make(map[string]string)
+	if locale != "" {
+		language := strings.ToLower(strings.SplitN(strings.ReplaceAll(locale, "_", "-"), "-", 2)[0])
+		localeRules, ok := localeTransliterations[language]
+		if !ok {
+			return nil, fmt.Errorf("unsupported locale %q (use de, da, nb, nn or no)", locale)
+		}
+		for from, to := range localeRules {
+			rules[from] = to
+		}
+	}
+
+	fold := cases.Fold()
+	for from, to := range custom {
+		if from == "" {
+			return nil, fmt.Errorf("empty transliteration source for %q", to)
+		}
+		rules[fold.String(from)] = fold.String(to)
+	}
+	if len(rules) == 0 {
+		return &UnicodeFolding{}, nil
+	}
+
+	// Longer sources first, so a rule for "sch" wins over one for "s"
+	sources := make([]string, 0, len(rules))
+	for from := range rules {
+		sources = append(sources, from)
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		if len(sources[i]) != len(sources[j]) {
+			return len(sources[i]) > len(sources[j])
+		}
+		return sources[i] < sources[j]
+	})
+	pairs := make([]string, 0, 2*len(sources))
+	for _, from := range sources {
+		pairs = append(pairs, from, rules[from])
+	}
+	return &UnicodeFolding{rules: strings.NewReplacer(pairs...)}, nil
+}
+
+// Fold case-folds s, applies the transliteration rules, decomposes it and strips diacritics.
+// A nil folding returns s unchanged.
+func (f *UnicodeFolding) Fold(s string) string {
+	if f == nil || s == "" {
+		return s
+	}
+	s = cases.Fold().String(norm.NFC.String(s))
+	if f.rules != nil {
+		s = f.rules.Replace(s)
+	}
+
+	var folded strings.Builder
+	for _, r := range norm.NFKD.String(s) {
+		if unicode.Is(unicode.Mn, r) {
+			continue // Combining diacritical mark
+		}
+		if replacement, ok := builtinTransliterations[r]; ok {
+			folded.WriteString(replacement)
+			continue
+		}
+		folded.WriteRune(r)
+	}
+	return folded.String()
+}
+
+// LoadTransliterations reads "from,to" rules, one per line; blank lines and lines starting with
+// '#' are ignored. An empty "to" deletes the source text.
+func LoadTransliterations(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open transliteration file: %w", err)
+	}
+	defer file.Close()
+
+	rules := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		from, to, ok := strings.Cut(text, ",")
+		if !ok || strings.TrimSpace(from) == "" {
+			return nil, fmt.Errorf("%s:%d: expected \"from,to\"", path, line)
+		}
+		rules[strings.TrimSpace(from)] = strings.TrimSpace(to)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read transliteration file: %w", err)
+	}
+	return rules, nil
+}
@@ -0,0 +1,70 @@
+package crypto
+
+import "testing"
+
+// TestUnicodeFoldingLatinExtended checks that Latin-extended spellings fold and name-normalize
+// to the same name as their ASCII forms, with the locale they need ("" for none)
+func TestUnicodeFoldingLatinExtended(t *testing.T) {
+	cases := []struct {
+		locale string
+		a, b   string
+		want   string
+	}{
+		{"", "García", "Garcia", "garcia"},
+		{"", "FRANÇOIS", "francois", "francois"},
+		{"", "Łukasz", "Lukasz", "lukasz"},
+		{"", "Ørsted", "Orsted", "orsted"},
+		{"", "Straße", "STRASSE", "strasse"},
+		{"", "Æsir", "aesir", "aesir"},
+		{"", "Dvořák", "Dvorak", "dvorak"},
+		{"", "Şahin", "Sahin", "sahin"},
+		{"", "İlkay", "Ilkay", "ilkay"},
+		{"", "Nguyễn", "Nguyen", "nguyen"},
+		{"", "ﬁnn", "finn", "finn"},
+		{"de", "Müller", "Mueller", "mueller"},
+		{"de", "Göthe", "GOETHE", "goethe"},
+		{"nb", "Ålesund", "Aalesund", "aalesund"},
+	}
+	for _, c := range cases {
+		t.Run(c.a, func(t *testing.T) {
+			folding, err := NewUnicodeFolding(c.locale, nil)
+			if err != nil {
+				t.Fatalf("NewUnicodeFolding(%q): %v", c.locale, err)
+			}
+			for _, name := range []string{c.a, c.b} {
+				if got := NormalizeField(folding.Fold(name), NormName); got != c.want {
+					t.Errorf("%q normalizes to %q with locale %q, want %q", name, got, c.locale, c.want)
+				}
+			}
+		})
+	}
+}
+
+// TestUnicodeFoldingLocales checks locale rules against the default folding and custom rules
+// against the locale's
+func TestUnicodeFoldingLocales(t *testing.T) {
+	plain, err := NewUnicodeFolding("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := plain.Fold("Müller"); got != "muller" {
+		t.Errorf("Fold(Müller) without a locale = %q, want muller", got)
+	}
+
+	custom, err := NewUnicodeFolding("de", map[string]string{"ü": "u"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := custom.Fold("Müller"); got != "muller" {
+		t.Errorf("Fold(Müller) with a custom ü rule = %q, want muller", got)
+	}
+
+	if _, err := NewUnicodeFolding("fr", nil); err == nil {
+		t.Error("NewUnicodeFolding(fr) succeeded, want an unsupported locale error")
+	}
+
+	var none *UnicodeFolding
+	if got := none.Fold("García"); got != "García" {
+		t.Errorf("nil Fold(García) = %q, want it unchanged", got)
+	}
+}
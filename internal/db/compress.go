@@ -0,0 +1,69 @@
+// compress.go
+// Gzip-compressed input and output files: inputs are decompressed when their content is gzipped,
+// whatever their name, and outputs are compressed when their name ends in .gz.
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// OpenInput opens a file for reading, decompressing it if it is gzipped
+func OpenInput(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewReader(file)
+	if magic, _ := buffered.Peek(2); !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return &compressedFile{Reader: buffered, file: file}, nil
+	}
+	gz, err := gzip.NewReader(buffered)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to decompress %s: %w", path, err)
+	}
+	return &compressedFile{Reader: gz, gz: gz, file: file}, nil
+}
+
+// CreateOutput creates a file for writing, gzip-compressing what is written if the name ends in
+// .gz. Close finishes the gzip stream before closing the file.
+func CreateOutput(path string) (io.WriteCloser, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if !IsGzip(path) {
+		return file, nil
+	}
+	gzw := gzip.NewWriter(file)
+	return &compressedFile{Writer: gzw, gzw: gzw, file: file}, nil
+}
+
+// compressedFile is a file read or written through gzip, or read as it is
+type compressedFile struct {
+	io.Reader
+	io.Writer
+	gz   *gzip.Reader
+	gzw  *gzip.Writer
+	file *os.File
+}
+
+// Close ends the gzip stream and closes the file, returning the first error
+func (f *compressedFile) Close() error {
+	var err error
+	if f.gz != nil {
+		err = f.gz.Close()
+	}
+	if f.gzw != nil {
+		err = f.gzw.Close()
+	}
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -1,54 +1,59 @@
 package db
 
 import (
-	"encoding/csv"
 	"errors"
-	"os"
 	"sync"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
 )
 
 type CSVDatabase struct {
 	headers []string
 	data    map[string][]string
-	keys    []string
+	rows    [][]string // Every row in file order; the key need not be unique when IDs span several columns
 	mu      sync.RWMutex
 }
 
 // NewCSVDatabase reads the CSV file and initializes the CSVDatabase.
 // The CSV file must have a header and at least one column (the key).
 func NewCSVDatabase(filePath string) (*CSVDatabase, error) {
-	file, err := os.Open(filePath)
+	return NewCSVDatabaseWithDialect(filePath, config.CSVDialect{})
+}
+
+// NewCSVDatabaseWithDialect reads a CSV file written in the given dialect. Without a header row,
+// columns are named by the dialect.
+func NewCSVDatabaseWithDialect(filePath string, dialect config.CSVDialect) (*CSVDatabase, error) {
+	r, err := OpenCSV(filePath, dialect)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	defer r.Close()
 
-	r := csv.NewReader(file)
 	records, err := r.ReadAll()
 	if err != nil {
 		return nil, err
 	}
-	if len(records) < 2 {
+	if len(records) < 1 {
 		return nil, errors.New("CSV file must have a header and at least one data row")
 	}
 
-	headers := records[0]
+	headers := r.Header()
 	data := make(map[string][]string)
-	keys := make([]string, 0, len(records)-1)
+	rows := make([][]string, 0, len(records))
 
-	for _, record := range records[1:] {
+	for _, record := range records {
 		if len(record) < 1 {
 			continue
 		}
 		key := record[0]
 		data[key] = record
-		keys = append(keys, key)
+		rows = append(rows, record)
 	}
 
 	return &CSVDatabase{
 		headers: headers,
 		data:    data,
-		keys:    keys,
+		rows:    rows,
 	}, nil
 }
 
@@ -62,15 +67,20 @@ func (db *CSVDatabase) Get(key string) (map[string]string, error) {
 		return nil, errors.New("key not found")
 	}
 
-	result := make(map[string]string)
+	return db.rowMap(record), nil
+}
+
+// rowMap returns a row as a map[columnName]value, with missing trailing cells empty
+func (db *CSVDatabase) rowMap(record []string) map[string]string {
+	row := make(map[string]string, len(db.headers))
 	for i, header := range db.headers {
 		if i < len(record) {
-			result[header] = record[i]
+			row[header] = record[i]
 		} else {
-			result[header] = ""
+			row[header] = ""
 		}
 	}
-	return result, nil
+	return row
 }
 
 // List returns a slice of keys starting from `start`, up to `size` entries.
@@ -79,27 +89,31 @@ func (db *CSVDatabase) List(start, size int) ([]map[string]string, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	if start < 0 || start >= len(db.keys) {
+	if start < 0 || start >= len(db.rows) {
 		return nil, errors.New("start index out of bounds")
 	}
 
 	end := start + size
-	if end > len(db.keys) {
-		end = len(db.keys)
+	if end > len(db.rows) {
+		end = len(db.rows)
 	}
 
 	result := make([]map[string]string, 0, end-start)
-	for _, key := range db.keys[start:end] {
-		row := make(map[string]string)
-		record := db.data[key]
-		for i, header := range db.headers {
-			if i < len(record) {
-				row[header] = record[i]
-			} else {
-				row[header] = ""
-			}
-		}
-		result = append(result, row)
+	for _, record := range db.rows[start:end] {
+		result = append(result, db.rowMap(record))
 	}
 	return result, nil
 }
+
+// Stream passes every row to fn in file order, stopping at fn's first error
+func (db *CSVDatabase) Stream(fn func(row map[string]string) error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	for _, record := range db.rows {
+		if err := fn(db.rowMap(record)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package db
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// CSVReader reads the rows of a delimited text file written in a config.CSVDialect, transcoded
+// to UTF-8 and decompressed if gzipped. A byte order mark is dropped, and one of UTF-16 overrides
+// the configured encoding.
+type CSVReader struct {
+	file    io.Closer
+	reader  *csv.Reader
+	quote   rune     // Quote character swapped with '"' so encoding/csv can parse it (0 for '"')
+	header  []string // Column names, from the header row or the dialect
+	pending []string // First row of a file without a header, read to count its columns
+}
+
+// OpenCSV opens a CSV file in the given dialect and reads its header; a file without a header
+// row gets the dialect's column names, or column_1, column_2 and so on
+func OpenCSV(path string, dialect config.CSVDialect) (*CSVReader, error) {
+	delimiter, err := csvDelimiter(dialect.Delimiter)
+	if err != nil {
+		return nil, err
+	}
+	quote, err := csvQuote(dialect.Quote)
+	if err != nil {
+		return nil, err
+	}
+	if delimiter == quote {
+		return nil, fmt.Errorf("CSV delimiter and quote are both %q", delimiter)
+	}
+	decoder, err := csvDecoder(dialect.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	if dialect.HasHeader() && len(dialect.Columns) > 0 {
+		return nil, fmt.Errorf("CSV columns name the columns of a file without a header row (set header: false)")
+	}
+
+	file, err := OpenInput(path)
+	if err != nil {
+		return nil, err
+	}
+	var input io.Reader = transform.NewReader(file, unicode.BOMOverride(decoder))
+	r := &CSVReader{file: file}
+	if quote != '"' {
+		input = &quoteSwapper{r: input, quote: byte(quote)}
+		r.quote = quote
+	}
+	r.reader = csv.NewReader(input)
+	r.reader.Comma = delimiter
+
+	first, err := r.read()
+	if err != nil {
+		file.Close()
+		if err == io.EOF {
+			return nil, fmt.Errorf("CSV file is empty")
+		}
+		return nil, err
+	}
+	switch {
+	case dialect.HasHeader():
+		r.header = first
+	case len(dialect.Columns) > 0:
+		if len(dialect.Columns) != len(first) {
+			file.Close()
+			return nil, fmt.Errorf("CSV columns name %d columns, but the file has %d", len(dialect.Columns), len(first))
+		}
+		r.header, r.pending = dialect.Columns, first
+	default:
+		r.header, r.pending = make([]string, len(first)), first
+		for i := range first {
+			r.header[i] = fmt.Sprintf("column_%d", i+1)
+		}
+	}
+	return r, nil
+}
+
+// Header returns the column names
+func (r *CSVReader) Header() []string {
+	return r.header
+}
+
+// Read returns the next data row, or io.EOF after the last
+func (r *CSVReader) Read() ([]string, error) {
+	if r.pending != nil {
+		row := r.pending
+		r.pending = nil
+		return row, nil
+	}
+	return r.read()
+}
+
+// ReadAll returns the remaining data rows
+func (r *CSVReader) ReadAll() ([][]string, error) {
+	var rows [][]string
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+// Close closes the file
+func (r *CSVReader) Close() error {
+	return r.file.Close()
+}
+
+func (r *CSVReader) read() ([]string, error) {
+	row, err := r.reader.Read()
+	if err != nil || r.quote == 0 {
+		return row, err
+	}
+	for i, value := range row {
+		row[i] = strings.Map(r.swap, value)
+	}
+	return row, nil
+}
+
+// swap undoes the quote swapping of quoteSwapper in a parsed value
+func (r *CSVReader) swap(c rune) rune {
+	switch c {
+	case r.quote:
+		return '"'
+	case '"':
+		return r.quote
+	}
+	return c
+}
+
+// quoteSwapper exchanges an ASCII quote character with '"' in UTF-8 text, so that encoding/csv,
+// which only knows '"', parses the quoting. Doubled quotes escape one, as with '"'.
+type quoteSwapper struct {
+	r     io.Reader
+	quote byte
+}
+
+func (s *quoteSwapper) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	for i, b := range p[:n] {
+		switch b {
+		case s.quote:
+			p[i] = '"'
+		case '"':
+			p[i] = s.quote
+		}
+	}
+	return n, err
+}
+
+// csvDelimiter parses a delimiter: one character, "tab" or "\t" (default ",")
+func csvDelimiter(delimiter string) (rune, error) {
+	switch delimiter {
+	case "":
+		return ',', nil
+	case "tab", `\t`:
+		return '\t', nil
+	}
+	c, size := utf8.DecodeRuneInString(delimiter)
+	if size != len(delimiter) || c == utf8.RuneError || c == '\r' || c == '\n' || c == '"' {
+		return 0, fmt.Errorf("invalid CSV delimiter %q: expected one character other than a quote or line break", delimiter)
+	}
+	return c, nil
+}
+
+// csvQuote parses a quote character, which must be ASCII (default '"')
+func csvQuote(quote string) (rune, error) {
+	if quote == "" {
+		return '"', nil
+	}
+	if len(quote) != 1 || quote[0] >= utf8.RuneSelf || quote == "\r" || quote == "\n" {
+		return 0, fmt.Errorf("invalid CSV quote %q: expected one ASCII character", quote)
+	}
+	return rune(quote[0]), nil
+}
+
+// csvDecoder returns the decoder of an encoding named as by IANA or the WHATWG, such as latin1,
+// windows-1252 or utf-16le. UTF-8, the default, is passed through as it is.
+func csvDecoder(name string) (transform.Transformer, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "utf-8", "utf8":
+		return encoding.Nop.NewDecoder(), nil
+	}
+	enc, err := ianaindex.IANA.Encoding(name)
+	if err != nil || enc == nil {
+		if enc, err = htmlindex.Get(name); err != nil {
+			return nil, fmt.Errorf("unsupported CSV encoding %q", name)
+		}
+	}
+	return enc.NewDecoder(), nil
+}
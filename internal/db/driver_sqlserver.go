@@ -0,0 +1,5 @@
+//go:build sqlserver
+
+package db
+
+import _ "github.com/microsoft/go-mssqldb" // SQL Server driver, for database.type sqlserver
@@ -0,0 +1,152 @@
+// format.go
+// Token file manifests: a sidecar <token file>.format.json records the format version, the
+// tokenization recipe and the release that wrote the file, so readers can refuse files they would
+// misread instead of matching them silently.
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+)
+
+// Token file format versions this release writes and reads. Bump TokenFormatVersion when a change
+// would make older releases misread new files, and MinTokenFormatVersion when this release can no
+// longer read old ones.
+const (
+	TokenFormatVersion    = 1
+	MinTokenFormatVersion = 1
+)
+
+// TokenFormatName identifies cohort-bridge token manifests
+const TokenFormatName = "cohort-bridge-tokens"
+
+// FormatManifestSuffix is appended to a token file path to name its manifest
+const FormatManifestSuffix = ".format.json"
+
+// TokenFormat describes how a token file was written. The recipe summary and fingerprint are the
+// ones exchanged with peers, so the manifest reveals no secret.
+type TokenFormat struct {
+	Format            string    `json:"format"`
+	Version           int       `json:"format_version"`
+	Encoding          string    `json:"encoding"`  // csv, json, jsonl or cbbf
+	Encrypted         bool      `json:"encrypted"` // Whether the file is encrypted
+	Producer          string    `json:"producer"`  // Release that wrote the file
+	ProducerCommit    string    `json:"producer_commit,omitempty"`
+	Created           time.Time `json:"created"`
+	Recipe            string    `json:"recipe"`             // Recipe summary
+	RecipeFingerprint string    `json:"recipe_fingerprint"` // Recipe, seed and linkage secret check
+	Records           int       `json:"records,omitempty"`  // Unset for files appended to over time
+
+	// Incremental tokenization: the latest watermark column value seen, where the next run
+	// starts, and for a file holding only changed records, the watermark they were selected after
+	Watermark string `json:"watermark,omitempty"`
+	Since     string `json:"since,omitempty"`
+}
+
+// NewTokenFormat describes a token file written by this release
+func NewTokenFormat(encoding string, encrypted bool, producer, producerCommit, recipe, fingerprint string, records int) *TokenFormat {
+	return &TokenFormat{
+		Format:            TokenFormatName,
+		Version:           TokenFormatVersion,
+		Encoding:          encoding,
+		Encrypted:         encrypted,
+		Producer:          producer,
+		ProducerCommit:    producerCommit,
+		Created:           time.Now().UTC(),
+		Recipe:            recipe,
+		RecipeFingerprint: fingerprint,
+		Records:           records,
+	}
+}
+
+// DistanceScale returns the factor the recipe's Bloom filter hardening multiplies Hamming distances
+// by, read from the recipe summary (1 without a manifest or hardening)
+func (f *TokenFormat) DistanceScale() uint32 {
+	if f == nil {
+		return 1
+	}
+	for _, field := range strings.Fields(f.Recipe) {
+		if steps, ok := strings.CutPrefix(field, "hardening="); ok {
+			return pprl.HardeningDistanceScale(strings.Split(steps, ","))
+		}
+	}
+	return 1
+}
+
+// WriteTokenFormat writes the manifest of tokenFile beside it
+func WriteTokenFormat(tokenFile string, format *TokenFormat) error {
+	data, err := json.MarshalIndent(format, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(tokenFile+FormatManifestSuffix, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write token format manifest: %w", err)
+	}
+	return nil
+}
+
+// ReadTokenFormat reads the manifest of tokenFile. Files written before manifests existed have
+// none; nil is returned for them.
+func ReadTokenFormat(tokenFile string) (*TokenFormat, error) {
+	data, err := os.ReadFile(tokenFile + FormatManifestSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token format manifest: %w", err)
+	}
+	var format TokenFormat
+	if err := json.Unmarshal(data, &format); err != nil {
+		return nil, fmt.Errorf("invalid token format manifest %s: %w", tokenFile+FormatManifestSuffix, err)
+	}
+	return &format, nil
+}
+
+// CheckTokenFormat reads the manifest of tokenFile and verifies this release can read the file.
+// The errors say which release to upgrade to or that the data must be tokenized again.
+func CheckTokenFormat(tokenFile string) (*TokenFormat, error) {
+	format, err := ReadTokenFormat(tokenFile)
+	if err != nil || format == nil {
+		return format, err
+	}
+	if format.Format != TokenFormatName {
+		return nil, fmt.Errorf("%s: manifest is not a %s manifest (format %q)", tokenFile, TokenFormatName, format.Format)
+	}
+	if format.Version > TokenFormatVersion {
+		return nil, fmt.Errorf("%s was written by cohort-bridge %s in token format version %d, newer than this release reads (up to %d): upgrade cohort-bridge to %s or later",
+			tokenFile, format.Producer, format.Version, TokenFormatVersion, format.Producer)
+	}
+	if format.Version < MinTokenFormatVersion {
+		return nil, fmt.Errorf("%s was written by cohort-bridge %s in token format version %d, which this release no longer reads (oldest: %d): tokenize the source data again with this release",
+			tokenFile, format.Producer, format.Version, MinTokenFormatVersion)
+	}
+	return format, nil
+}
+
+// CheckRecipe verifies that the file was tokenized with the recipe whose fingerprint is given
+func (f *TokenFormat) CheckRecipe(tokenFile, fingerprint, recipe string) error {
+	if f.RecipeFingerprint == "" || f.RecipeFingerprint == fingerprint {
+		return nil
+	}
+	return fmt.Errorf("%s was tokenized with a different recipe, seed or linkage secret:\n  file:   %s\n  config: %s\ntokenize it again with the current tokenization section, or restore the recipe it was written with",
+		tokenFile, f.Recipe, recipe)
+}
+
+// CheckSameRecipe verifies that two token files were tokenized with the same recipe, so their
+// filters can be compared. Files without manifests are not checked.
+func CheckSameRecipe(file1 string, format1 *TokenFormat, file2 string, format2 *TokenFormat) error {
+	if format1 == nil || format2 == nil || format1.RecipeFingerprint == "" || format2.RecipeFingerprint == "" {
+		return nil
+	}
+	if format1.RecipeFingerprint == format2.RecipeFingerprint {
+		return nil
+	}
+	return fmt.Errorf("%s and %s were tokenized with different recipes, seeds or linkage secrets, so their filters cannot be compared:\n  %s: %s\n  %s: %s\ntokenize both with the same tokenization section, MinHash seed and linkage secret",
+		file1, file2, file1, format1.Recipe, file2, format2.Recipe)
+}
@@ -20,9 +20,13 @@ func GetDatabaseFromConfig(cfg *config.Config) (Database, error) {
 		if csvPath == "" {
 			return nil, fmt.Errorf("CSV filename not specified in config")
 		}
-		return NewCSVDatabase(csvPath)
+		return NewCSVDatabaseWithDialect(csvPath, cfg.Database.CSV)
 	case "postgres", "postgresql":
-		return NewPostgresDatabase(cfg.Database)
+		return NewPostgresDatabase(cfg)
+	case "mysql", "mariadb":
+		return NewMySQLDatabase(cfg)
+	case "sqlserver", "mssql":
+		return NewSQLServerDatabase(cfg)
 	// Add other database types here as needed
 	default:
 		return nil, fmt.Errorf("unsupported database type: %s", cfg.Database.Type)
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"strconv"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+)
+
+// JSONDatabase holds the records of a JSON file of source data: one array of objects, or JSON
+// Lines with an object per line. Values are read as strings; an array of values is a multi-valued
+// field (prior surnames, earlier addresses), its values joined with pprl.ValueSeparator.
+type JSONDatabase struct {
+	records []map[string]string
+	index   map[string]int
+}
+
+// NewJSONDatabase reads the JSON file and initializes the JSONDatabase. Records are keyed by their
+// "id" value, or by their 1-based position when they have none.
+func NewJSONDatabase(filePath string) (*JSONDatabase, error) {
+	file, err := OpenInput(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	head, err := reader.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	trimmed := bytes.TrimLeft(head, " \t\r\n")
+	if len(trimmed) == 0 {
+		return nil, errors.New("JSON file must hold at least one record")
+	}
+
+	database := &JSONDatabase{index: make(map[string]int)}
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	if trimmed[0] == '[' {
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+		for decoder.More() {
+			if err := database.decode(decoder); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+	} else {
+		for {
+			if err := database.decode(decoder); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(database.records) == 0 {
+		return nil, errors.New("JSON file must hold at least one record")
+	}
+	return database, nil
+}
+
+// decode reads the next record
+func (db *JSONDatabase) decode(decoder *json.Decoder) error {
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil {
+		if err == io.EOF {
+			return err
+		}
+		return fmt.Errorf("record %d: %w", len(db.records)+1, err)
+	}
+
+	record := make(map[string]string, len(object))
+	for field, value := range object {
+		text, err := jsonFieldValue(value)
+		if err != nil {
+			return fmt.Errorf("record %d, field %s: %w", len(db.records)+1, field, err)
+		}
+		record[field] = text
+	}
+	key := record["id"]
+	if key == "" {
+		key = strconv.Itoa(len(db.records) + 1)
+	}
+	db.index[key] = len(db.records)
+	db.records = append(db.records, record)
+	return nil
+}
+
+// jsonFieldValue returns a JSON value as a field value, joining the values of an array
+func jsonFieldValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, element := range v {
+			if _, nested := element.([]interface{}); nested {
+				return "", errors.New("nested arrays are not supported")
+			}
+			text, err := jsonFieldValue(element)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, text)
+		}
+		return pprl.JoinValues(values), nil
+	}
+	return "", errors.New("objects are not supported; use a column per attribute")
+}
+
+// Len returns the number of records
+func (db *JSONDatabase) Len() int {
+	return len(db.records)
+}
+
+// Get returns the record with the given key.
+func (db *JSONDatabase) Get(key string) (map[string]string, error) {
+	i, ok := db.index[key]
+	if !ok {
+		return nil, errors.New("key not found")
+	}
+	return maps.Clone(db.records[i]), nil
+}
+
+// List returns up to size records starting from the start index.
+func (db *JSONDatabase) List(start, size int) ([]map[string]string, error) {
+	if start < 0 || start >= len(db.records) {
+		return nil, errors.New("start index out of bounds")
+	}
+	end := min(start+size, len(db.records))
+	result := make([]map[string]string, 0, end-start)
+	for _, record := range db.records[start:end] {
+		result = append(result, maps.Clone(record))
+	}
+	return result, nil
+}
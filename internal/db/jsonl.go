@@ -0,0 +1,168 @@
+// jsonl.go
+// JSON Lines token files: one TokenizedRecord object per line, optionally gzip-compressed, so
+// they can be written and read one record at a time.
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Tokenized file formats, as detected by detectTokenizedFormat
+const (
+	formatCSV   = "csv"
+	formatJSON  = "json"  // One array of records
+	formatJSONL = "jsonl" // One record per line
+)
+
+// IsJSONLines reports whether a file name has a JSON Lines extension (.jsonl or .ndjson, optionally
+// followed by .gz)
+func IsJSONLines(filename string) bool {
+	name := strings.TrimSuffix(strings.ToLower(filename), ".gz")
+	return strings.HasSuffix(name, ".jsonl") || strings.HasSuffix(name, ".ndjson")
+}
+
+// IsGzip reports whether a file name ends in .gz
+func IsGzip(filename string) bool {
+	return strings.HasSuffix(strings.ToLower(filename), ".gz")
+}
+
+// tokenizedInput is an open tokenized file, decompressed if it was gzipped
+type tokenizedInput struct {
+	io.Reader
+	src    io.Closer
+	gz     *gzip.Reader
+	format string
+}
+
+// openTokenizedInput opens a tokenized file, undoing gzip compression, and detects its format from
+// the extension or, for other names such as decrypted copies, from the content
+func openTokenizedInput(filename string) (*tokenizedInput, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %w", filename, err)
+	}
+	return newTokenizedInput(filename, file)
+}
+
+// newTokenizedInput reads tokenized data from src as openTokenizedInput does; name stands in for
+// the file name when detecting the format. src is closed with the input, or on error.
+func newTokenizedInput(name string, src io.ReadCloser) (*tokenizedInput, error) {
+	input := &tokenizedInput{src: src}
+	buffered := bufio.NewReader(src)
+	input.Reader = buffered
+
+	if magic, _ := buffered.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			src.Close()
+			return nil, fmt.Errorf("failed to decompress %s: %w", name, err)
+		}
+		input.gz = gz
+		buffered = bufio.NewReader(gz)
+		input.Reader = buffered
+	}
+
+	base := strings.TrimSuffix(strings.ToLower(name), ".gz")
+	var err error
+	switch {
+	case IsJSONLines(base):
+		input.format = formatJSONL
+	case strings.HasSuffix(base, ".json"):
+		input.format = formatJSON
+	case strings.HasSuffix(base, ".csv"):
+		input.format = formatCSV
+	default:
+		input.format, err = detectTokenizedFormat(buffered)
+		if err != nil {
+			input.Close()
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return input, nil
+}
+
+// detectTokenizedFormat tells the formats apart by the first character that is not whitespace:
+// an array is JSON, an object JSON Lines and anything else CSV
+func detectTokenizedFormat(r *bufio.Reader) (string, error) {
+	head, err := r.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return "", err
+	}
+	trimmed := bytes.TrimLeft(head, " \t\r\n")
+	switch {
+	case len(trimmed) == 0:
+		return "", fmt.Errorf("empty tokenized file")
+	case trimmed[0] == '[':
+		return formatJSON, nil
+	case trimmed[0] == '{':
+		return formatJSONL, nil
+	case bytes.ContainsRune(head, ','):
+		return formatCSV, nil
+	}
+	return "", fmt.Errorf("unsupported file format (could not detect CSV, JSON or JSON Lines)")
+}
+
+// Close closes the file
+func (in *tokenizedInput) Close() error {
+	if in.gz != nil {
+		in.gz.Close()
+	}
+	return in.src.Close()
+}
+
+// TokenizedJSONLWriter writes tokenized records as JSON Lines, gzip-compressed if asked
+type TokenizedJSONLWriter struct {
+	buffered *bufio.Writer
+	gz       *gzip.Writer
+	encoder  *json.Encoder
+}
+
+// NewTokenizedJSONLWriter writes records to w; Close must be called to flush them
+func NewTokenizedJSONLWriter(w io.Writer, compress bool) *TokenizedJSONLWriter {
+	writer := &TokenizedJSONLWriter{}
+	if compress {
+		writer.gz = gzip.NewWriter(w)
+		w = writer.gz
+	}
+	writer.buffered = bufio.NewWriter(w)
+	writer.encoder = json.NewEncoder(writer.buffered)
+	return writer
+}
+
+// Write writes one record as a line
+func (w *TokenizedJSONLWriter) Write(record TokenizedRecord) error {
+	return w.encoder.Encode(record)
+}
+
+// Close flushes the buffered records and ends the gzip stream; it does not close the underlying
+// writer
+func (w *TokenizedJSONLWriter) Close() error {
+	if err := w.buffered.Flush(); err != nil {
+		return err
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+// loadJSONL loads tokenized data from JSON Lines
+func (db *TokenizedDatabase) loadJSONL(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var record TokenizedRecord
+		if err := decoder.Decode(&record); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to decode JSON Lines record %d: %w", line, err)
+		}
+		db.records = append(db.records, record)
+	}
+}
@@ -0,0 +1,185 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/lib/pq"
+)
+
+// SinkColumn is a column written by a PostgresSink, with the type used when the sink creates its table
+type SinkColumn struct {
+	Name string
+	Type string
+}
+
+// TokenSinkColumns are the columns of the tokenized records table: the tokenized CSV columns
+// plus the run that wrote them
+var TokenSinkColumns = []SinkColumn{
+	{"run_id", "text"},
+	{"id", "text"},
+	{"bloom_filter", "text"},
+	{"minhash", "text"},
+	{"tokenized_at", "timestamptz"},
+}
+
+// PostgresSink writes output rows into PostgreSQL tables with COPY
+type PostgresSink struct {
+	db        *sql.DB
+	schema    string
+	batchSize int
+	create    bool
+}
+
+// NewPostgresSink connects to the database of the output.postgres config section
+func NewPostgresSink(cfg config.PostgresSinkConfig) (*PostgresSink, error) {
+	if cfg.Host == "" || cfg.DBName == "" {
+		return nil, fmt.Errorf("output.postgres needs at least host and dbname")
+	}
+
+	params := []string{
+		"host=" + quoteConnValue(cfg.Host),
+		fmt.Sprintf("port=%d", cfg.Port),
+		"dbname=" + quoteConnValue(cfg.DBName),
+		"sslmode=" + quoteConnValue(cfg.SSLMode),
+	}
+	if cfg.User != "" {
+		params = append(params, "user="+quoteConnValue(cfg.User))
+	}
+	if cfg.Password != "" {
+		params = append(params, "password="+quoteConnValue(cfg.Password))
+	}
+
+	db, err := sql.Open("postgres", strings.Join(params, " "))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = 10000
+	}
+	return &PostgresSink{db: db, schema: cfg.Schema, batchSize: batchSize, create: cfg.CreateTables}, nil
+}
+
+// quoteConnValue quotes a value for a libpq key=value connection string
+func quoteConnValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
+
+// Table starts writing rows to table, creating it first if the sink is configured to.
+// All rows go into one transaction, so a failed or aborted write leaves the table unchanged.
+func (s *PostgresSink) Table(table string, columns []SinkColumn) (*SinkTable, error) {
+	if table == "" {
+		return nil, fmt.Errorf("no output table configured")
+	}
+	qualified := pq.QuoteIdentifier(table)
+	if s.schema != "" {
+		qualified = pq.QuoteIdentifier(s.schema) + "." + qualified
+	}
+
+	names := make([]string, len(columns))
+	definitions := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.Name
+		definitions[i] = pq.QuoteIdentifier(column.Name) + " " + column.Type
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if s.create {
+		create := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", qualified, strings.Join(definitions, ", "))
+		if _, err := tx.Exec(create); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to create table %s: %w", qualified, err)
+		}
+	}
+	return &SinkTable{sink: s, tx: tx, name: qualified, table: table, columns: names}, nil
+}
+
+// Close closes the database connection
+func (s *PostgresSink) Close() error {
+	return s.db.Close()
+}
+
+// SinkTable buffers rows for one table and loads each full batch with a COPY statement
+type SinkTable struct {
+	sink    *PostgresSink
+	tx      *sql.Tx
+	name    string // Quoted, schema-qualified name
+	table   string
+	columns []string
+	rows    [][]interface{}
+	written int
+}
+
+// Name returns the schema-qualified table name
+func (t *SinkTable) Name() string {
+	return t.name
+}
+
+// Append adds a row with a value for each column
+func (t *SinkTable) Append(values ...interface{}) error {
+	if len(values) != len(t.columns) {
+		return fmt.Errorf("row has %d values for %d columns of %s", len(values), len(t.columns), t.name)
+	}
+	t.rows = append(t.rows, values)
+	if len(t.rows) >= t.sink.batchSize {
+		return t.flush()
+	}
+	return nil
+}
+
+// flush copies the buffered rows into the table
+func (t *SinkTable) flush() error {
+	if len(t.rows) == 0 {
+		return nil
+	}
+	stmt, err := t.tx.Prepare(pq.CopyInSchema(t.sink.schema, t.table, t.columns...))
+	if err != nil {
+		return fmt.Errorf("failed to start COPY into %s: %w", t.name, err)
+	}
+	for _, row := range t.rows {
+		if _, err := stmt.Exec(row...); err != nil {
+			stmt.Close()
+			return fmt.Errorf("failed to copy row into %s: %w", t.name, err)
+		}
+	}
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return fmt.Errorf("failed to copy rows into %s: %w", t.name, err)
+	}
+	if err := stmt.Close(); err != nil {
+		return fmt.Errorf("failed to copy rows into %s: %w", t.name, err)
+	}
+	t.written += len(t.rows)
+	t.rows = t.rows[:0]
+	return nil
+}
+
+// Commit copies the remaining rows and commits the transaction; it returns the rows written
+func (t *SinkTable) Commit() (int, error) {
+	if err := t.flush(); err != nil {
+		t.tx.Rollback()
+		return 0, err
+	}
+	if err := t.tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit rows into %s: %w", t.name, err)
+	}
+	return t.written, nil
+}
+
+// Abort discards every row written to the table
+func (t *SinkTable) Abort() {
+	t.tx.Rollback()
+}
@@ -0,0 +1,434 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/config"
+)
+
+// sqlDialect holds what differs between the SQL servers read by SQLDatabase
+type sqlDialect struct {
+	name        string // Shown in errors
+	driver      string // database/sql driver name
+	buildTag    string // Build tag compiling the driver in (see driver_*.go); empty if always built in
+	module      string // Go module providing the driver
+	defaultPort int
+
+	dsn         func(cfg *config.Config, port int) string
+	quote       func(identifier string) string
+	placeholder func(n int) string                 // n-th query parameter, from 1
+	page        func(query, key string) string     // Adds ordering by key and paging, with the parameters from params
+	params      func(offset, count int) []any      // Parameters of page, in the dialect's order
+	firstRow    func(columns, table string) string // Query returning no rows, used to read the columns
+
+	// readOnlyTx is set if the server enforces READ ONLY transactions
+	readOnlyTx bool
+	// timeout is the statement bounding the server-side run time of the session's queries, if any
+	timeout func(limit time.Duration) string
+	// verifyReadOnly checks at startup that the session cannot write
+	verifyReadOnly func(ctx context.Context, tx *sql.Tx, table string) error
+}
+
+var mysqlDialect = sqlDialect{
+	name:        "MySQL",
+	driver:      "mysql",
+	buildTag:    "mysql",
+	module:      "github.com/go-sql-driver/mysql",
+	defaultPort: 3306,
+	dsn: func(cfg *config.Config, port int) string {
+		// TLS is required, as with PostgreSQL; text values are read as they are stored
+		return fmt.Sprintf("%s:%s@tcp(%s)/%s?tls=true&charset=utf8mb4",
+			cfg.Database.User, cfg.Database.Password, net.JoinHostPort(cfg.Database.Host, strconv.Itoa(port)), cfg.Database.DBName)
+	},
+	quote: func(identifier string) string {
+		return "`" + strings.ReplaceAll(identifier, "`", "``") + "`"
+	},
+	placeholder: func(int) string { return "?" },
+	page: func(query, key string) string {
+		return query + " ORDER BY " + key + " LIMIT ? OFFSET ?"
+	},
+	params: func(offset, count int) []any { return []any{count, offset} },
+	firstRow: func(columns, table string) string {
+		return "SELECT " + columns + " FROM " + table + " LIMIT 0"
+	},
+	readOnlyTx: true,
+	timeout: func(limit time.Duration) string {
+		return fmt.Sprintf("SET SESSION max_execution_time = %d", limit.Milliseconds())
+	},
+	verifyReadOnly: func(ctx context.Context, tx *sql.Tx, table string) error {
+		// transaction_read_only replaced tx_read_only in MySQL 8.0
+		var readOnly string
+		err := tx.QueryRowContext(ctx, "SELECT @@transaction_read_only").Scan(&readOnly)
+		if err != nil {
+			err = tx.QueryRowContext(ctx, "SELECT @@tx_read_only").Scan(&readOnly)
+		}
+		if err != nil {
+			return err
+		}
+		if readOnly != "1" {
+			return fmt.Errorf("the server did not start a read-only transaction")
+		}
+		return nil
+	},
+}
+
+var sqlServerDialect = sqlDialect{
+	name:        "SQL Server",
+	driver:      "sqlserver",
+	buildTag:    "sqlserver",
+	module:      "github.com/microsoft/go-mssqldb",
+	defaultPort: 1433,
+	dsn: func(cfg *config.Config, port int) string {
+		query := url.Values{}
+		query.Set("database", cfg.Database.DBName)
+		query.Set("encrypt", "true")
+		if cfg.Database.ReadOnly {
+			// Routes the session to a readable secondary in an availability group
+			query.Set("ApplicationIntent", "ReadOnly")
+		}
+		return (&url.URL{
+			Scheme:   "sqlserver",
+			User:     url.UserPassword(cfg.Database.User, cfg.Database.Password),
+			Host:     net.JoinHostPort(cfg.Database.Host, strconv.Itoa(port)),
+			RawQuery: query.Encode(),
+		}).String()
+	},
+	quote: func(identifier string) string {
+		return "[" + strings.ReplaceAll(identifier, "]", "]]") + "]"
+	},
+	placeholder: func(n int) string { return "@p" + strconv.Itoa(n) },
+	page: func(query, key string) string {
+		return query + " ORDER BY " + key + " OFFSET @p1 ROWS FETCH NEXT @p2 ROWS ONLY"
+	},
+	params: func(offset, count int) []any { return []any{offset, count} },
+	firstRow: func(columns, table string) string {
+		return "SELECT TOP 0 " + columns + " FROM " + table
+	},
+	// SQL Server has no read-only transactions, so the login itself must be unable to write
+	verifyReadOnly: func(ctx context.Context, tx *sql.Tx, table string) error {
+		var writable int
+		query := "SELECT HAS_PERMS_BY_NAME(DB_NAME(), 'DATABASE', 'INSERT') + HAS_PERMS_BY_NAME(DB_NAME(), 'DATABASE', 'UPDATE')" +
+			" + HAS_PERMS_BY_NAME(DB_NAME(), 'DATABASE', 'DELETE') + HAS_PERMS_BY_NAME(DB_NAME(), 'DATABASE', 'ALTER')"
+		var args []any
+		if table != "" {
+			query += " + HAS_PERMS_BY_NAME(@p1, 'OBJECT', 'INSERT') + HAS_PERMS_BY_NAME(@p1, 'OBJECT', 'UPDATE')" +
+				" + HAS_PERMS_BY_NAME(@p1, 'OBJECT', 'DELETE')"
+			args = append(args, table)
+		}
+		if err := tx.QueryRowContext(ctx, query, args...).Scan(&writable); err != nil {
+			return err
+		}
+		if writable > 0 {
+			return fmt.Errorf("the login may write to the database (INSERT, UPDATE, DELETE or ALTER permission); " +
+				"read_only needs a login limited to reading, such as a db_datareader member")
+		}
+		return nil
+	},
+}
+
+// selectQuery matches the custom queries database.query accepts: a single SELECT or WITH statement
+var selectQuery = regexp.MustCompile(`(?is)^\s*(select|with)\b[^;]*;?\s*$`)
+
+// SQLDatabase reads the rows of one table, view or query of a PostgreSQL, MySQL or SQL Server
+// database. Like the CSV database, the first column is the record key and rows are listed in key
+// order. Every read runs in a transaction that is rolled back, read-only with database.read_only,
+// and database.statement_timeout and database.max_rows bound the load a session puts on the server.
+type SQLDatabase struct {
+	db        *sql.DB
+	dialect   sqlDialect
+	source    string // Quoted, possibly schema-qualified, table name, or the query as a derived table
+	columns   []string
+	keyColumn string
+
+	readOnly bool
+	timeout  time.Duration
+	maxRows  int
+	read     atomic.Int64 // Rows read in this session
+}
+
+// NewMySQLDatabase connects to the MySQL (or MariaDB) table of the database config section
+func NewMySQLDatabase(cfg *config.Config) (*SQLDatabase, error) {
+	return newSQLDatabase(cfg, mysqlDialect)
+}
+
+// NewSQLServerDatabase connects to the Microsoft SQL Server table of the database config section
+func NewSQLServerDatabase(cfg *config.Config) (*SQLDatabase, error) {
+	return newSQLDatabase(cfg, sqlServerDialect)
+}
+
+func newSQLDatabase(cfg *config.Config, dialect sqlDialect) (*SQLDatabase, error) {
+	if !slices.Contains(sql.Drivers(), dialect.driver) {
+		return nil, fmt.Errorf("this build has no %s driver; rebuild with 'go get %s' and 'go build -tags %s'",
+			dialect.name, dialect.module, dialect.buildTag)
+	}
+	database := cfg.Database
+	switch {
+	case database.Host == "" || database.DBName == "":
+		return nil, fmt.Errorf("%s database needs at least host and dbname", dialect.name)
+	case database.Table == "" && database.Query == "":
+		return nil, fmt.Errorf("%s database needs a table (or view) or a query", dialect.name)
+	case database.Table != "" && database.Query != "":
+		return nil, fmt.Errorf("database.table and database.query are exclusive")
+	case database.Query != "" && !selectQuery.MatchString(database.Query):
+		return nil, fmt.Errorf("database.query must be a single SELECT statement")
+	case database.StatementTimeout < 0 || database.MaxRows < 0:
+		return nil, fmt.Errorf("database.statement_timeout and database.max_rows cannot be negative")
+	}
+	port := database.Port
+	if port == 0 {
+		port = dialect.defaultPort
+	}
+
+	conn, err := sql.Open(dialect.driver, dialect.dsn(cfg, port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	sqlDB := &SQLDatabase{
+		db:       conn,
+		dialect:  dialect,
+		readOnly: database.ReadOnly,
+		timeout:  database.StatementTimeout,
+		maxRows:  database.MaxRows,
+	}
+	ctx, cancel := sqlDB.context()
+	defer cancel()
+	if err := conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	table := ""
+	if database.Query != "" {
+		sqlDB.source = "(" + strings.TrimSuffix(strings.TrimSpace(database.Query), ";") + ") AS source"
+	} else {
+		// A schema-qualified name such as dbo.patients is quoted part by part
+		parts := strings.Split(database.Table, ".")
+		for i, part := range parts {
+			parts[i] = dialect.quote(part)
+		}
+		table = strings.Join(parts, ".")
+		sqlDB.source = table
+	}
+	if sqlDB.readOnly {
+		if err := sqlDB.verifyReadOnly(table); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("database.read_only: %w", err)
+		}
+	}
+	if err := sqlDB.loadColumns(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to load table schema: %w", err)
+	}
+	return sqlDB, nil
+}
+
+// context returns the context of one statement, bounded by database.statement_timeout
+func (db *SQLDatabase) context() (context.Context, context.CancelFunc) {
+	if db.timeout > 0 {
+		return context.WithTimeout(context.Background(), db.timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// session runs fn in a transaction that is always rolled back, as reading commits nothing. With
+// read_only the transaction is READ ONLY where the server supports it. The statement timeout is
+// also set on the server where it can be, so a query the client gives up on does not keep running.
+func (db *SQLDatabase) session(fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := db.context()
+	defer cancel()
+	tx, err := db.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: db.readOnly && db.dialect.readOnlyTx})
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if db.timeout > 0 && db.dialect.timeout != nil {
+		if _, err := tx.ExecContext(ctx, db.dialect.timeout(db.timeout)); err != nil {
+			return fmt.Errorf("failed to set statement timeout: %w", err)
+		}
+	}
+	if err := fn(ctx, tx); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("query exceeded database.statement_timeout (%s)", db.timeout)
+		}
+		return err
+	}
+	return nil
+}
+
+// verifyReadOnly checks that the session cannot write, failing startup if the server does not show it
+func (db *SQLDatabase) verifyReadOnly(table string) error {
+	if db.dialect.verifyReadOnly == nil {
+		return fmt.Errorf("%s sessions cannot be verified read-only", db.dialect.name)
+	}
+	return db.session(func(ctx context.Context, tx *sql.Tx) error {
+		return db.dialect.verifyReadOnly(ctx, tx, table)
+	})
+}
+
+// loadColumns reads the columns from an empty result, which works the same on every server
+func (db *SQLDatabase) loadColumns() error {
+	return db.session(func(ctx context.Context, tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, db.dialect.firstRow("*", db.source))
+		if err != nil {
+			return fmt.Errorf("failed to query %s: %w", db.source, err)
+		}
+		defer rows.Close()
+		columns, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		if len(columns) == 0 {
+			return fmt.Errorf("%s has no columns", db.source)
+		}
+		db.columns = columns
+		db.keyColumn = columns[0] // Use the first column as the key column (similar to CSV)
+		return nil
+	})
+}
+
+// selectColumns returns the quoted column list of the table
+func (db *SQLDatabase) selectColumns() string {
+	quoted := make([]string, len(db.columns))
+	for i, column := range db.columns {
+		quoted[i] = db.dialect.quote(column)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// Get returns the row as a map[columnName]value for the given key.
+func (db *SQLDatabase) Get(key string) (map[string]string, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s",
+		db.selectColumns(), db.source, db.dialect.quote(db.keyColumn), db.dialect.placeholder(1))
+	var result map[string]string
+	err := db.query(query, []any{key}, func(row map[string]string) error {
+		if result == nil {
+			result = row
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, fmt.Errorf("key not found")
+	}
+	return result, nil
+}
+
+// List returns a slice of row maps starting from `start` index, up to `size` entries.
+func (db *SQLDatabase) List(start, size int) ([]map[string]string, error) {
+	if start < 0 {
+		return nil, fmt.Errorf("start index must be non-negative")
+	}
+	var result []map[string]string
+	err := db.query(db.pageQuery(), db.dialect.params(start, db.bound(size)), func(row map[string]string) error {
+		result = append(result, row)
+		return nil
+	})
+	return result, err
+}
+
+// Stream passes every row to fn in key order with a single query, stopping at fn's first error.
+// Unlike paging through List, the table is read once however large it is.
+func (db *SQLDatabase) Stream(fn func(row map[string]string) error) error {
+	if db.maxRows > 0 {
+		// The server stops one row past the limit, enough to tell that the limit was exceeded
+		return db.query(db.pageQuery(), db.dialect.params(0, db.bound(db.maxRows)), fn)
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s ORDER BY %s", db.selectColumns(), db.source, db.dialect.quote(db.keyColumn))
+	return db.query(query, nil, fn)
+}
+
+// pageQuery selects a page of rows in key order
+func (db *SQLDatabase) pageQuery() string {
+	return db.dialect.page(fmt.Sprintf("SELECT %s FROM %s", db.selectColumns(), db.source), db.dialect.quote(db.keyColumn))
+}
+
+// bound caps the rows a query asks for at one more than database.max_rows leaves for the session
+func (db *SQLDatabase) bound(size int) int {
+	if db.maxRows <= 0 {
+		return size
+	}
+	remaining := db.maxRows - int(db.read.Load()) + 1
+	if remaining < 1 {
+		remaining = 1
+	}
+	return min(size, remaining)
+}
+
+// query runs query and passes each row to fn, failing once the session read more than max_rows
+func (db *SQLDatabase) query(query string, args []any, fn func(row map[string]string) error) error {
+	return db.session(func(ctx context.Context, tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to query rows: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			if db.maxRows > 0 && db.read.Add(1) > int64(db.maxRows) {
+				return fmt.Errorf("read more than database.max_rows (%d) rows in this session", db.maxRows)
+			}
+			row, err := db.scanRow(rows)
+			if err != nil {
+				return err
+			}
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating over rows: %w", err)
+		}
+		return nil
+	})
+}
+
+// scanRow converts the current row to map[string]string; NULL reads as an empty value
+func (db *SQLDatabase) scanRow(rows *sql.Rows) (map[string]string, error) {
+	values := make([]any, len(db.columns))
+	valuePtrs := make([]any, len(db.columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+	if err := rows.Scan(valuePtrs...); err != nil {
+		return nil, fmt.Errorf("failed to scan row: %w", err)
+	}
+
+	row := make(map[string]string, len(db.columns))
+	for i, column := range db.columns {
+		row[column] = sqlValueString(values[i])
+	}
+	return row, nil
+}
+
+// sqlValueString formats a scanned value: drivers return text as []byte and dates as time.Time
+func sqlValueString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case time.Time:
+		if v.Hour() == 0 && v.Minute() == 0 && v.Second() == 0 && v.Nanosecond() == 0 {
+			return v.Format("2006-01-02")
+		}
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Close closes the database connection
+func (db *SQLDatabase) Close() error {
+	return db.db.Close()
+}
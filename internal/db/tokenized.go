@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"strings"
+	"slices"
 
 	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
 )
@@ -17,6 +17,51 @@ type TokenizedRecord struct {
 	BloomFilter string `json:"bloom_filter"` // Base64 encoded
 	MinHash     string `json:"minhash"`      // Base64 encoded
 	Timestamp   string `json:"timestamp"`
+	Blocking    string `json:"blocking,omitempty"` // Space-separated hashed blocking keys
+	ExactID     string `json:"exact_id,omitempty"` // Keyed hash of the exact identifier
+	Provenance
+}
+
+// Provenance traces a token back to the source row it was made from (tokenize -provenance)
+type Provenance struct {
+	SourceFile string `json:"source_file,omitempty"`
+	SourceRow  string `json:"source_row,omitempty"`
+	BatchID    string `json:"batch_id,omitempty"`
+}
+
+// provenanceColumns are the indexes of the optional provenance columns in a tokenized CSV header,
+// -1 for those it lacks
+type provenanceColumns [3]int
+
+func newProvenanceColumns(header []string) provenanceColumns {
+	var columns provenanceColumns
+	for i, name := range pprl.ProvenanceColumns {
+		columns[i] = slices.Index(header, name)
+	}
+	return columns
+}
+
+// read returns the provenance of a CSV row
+func (c provenanceColumns) read(row []string) Provenance {
+	var values [3]string
+	for i, column := range c {
+		if column >= 0 && column < len(row) {
+			values[i] = row[column]
+		}
+	}
+	return Provenance{SourceFile: values[0], SourceRow: values[1], BatchID: values[2]}
+}
+
+// blockingColumn returns the index of the optional blocking key column in a tokenized CSV
+// header, or -1 when the file has none
+func blockingColumn(header []string) int {
+	return slices.Index(header, "blocking")
+}
+
+// exactIDColumn returns the index of the optional exact identifier column in a tokenized CSV
+// header, or -1 when the file has none
+func exactIDColumn(header []string) int {
+	return slices.Index(header, "exact_id")
 }
 
 // TokenizedDatabase handles operations on tokenized patient data
@@ -31,51 +76,55 @@ func NewTokenizedDatabase(filename string) (*TokenizedDatabase, error) {
 		filename: filename,
 	}
 
-	if err := db.load(); err != nil {
+	input, err := openTokenizedInput(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+
+	if err := db.load(input); err != nil {
 		return nil, err
 	}
 
 	return db, nil
 }
 
-// load reads tokenized data from file (supports both JSON and CSV formats)
-func (db *TokenizedDatabase) load() error {
-	file, err := os.Open(db.filename)
+// NewTokenizedFile creates a database of records to be written to filename (.csv or .json) by Save
+func NewTokenizedFile(filename string, records []TokenizedRecord) *TokenizedDatabase {
+	return &TokenizedDatabase{filename: filename, records: records}
+}
+
+// NewTokenizedDatabaseFromReader loads tokenized records from r, such as a file being decrypted in
+// memory. name is used in place of a file name to detect the format.
+func NewTokenizedDatabaseFromReader(name string, r io.Reader) (*TokenizedDatabase, error) {
+	input, err := newTokenizedInput(name, io.NopCloser(r))
 	if err != nil {
-		return fmt.Errorf("failed to open file %s: %w", db.filename, err)
+		return nil, err
 	}
-	defer file.Close()
+	defer input.Close()
 
-	// Detect file format by extension or content
-	filename := db.filename
-	if strings.HasSuffix(filename, ".json") {
-		return db.loadJSON(file)
-	} else if strings.HasSuffix(filename, ".csv") || strings.Contains(filename, "_decrypted") {
-		// Handle .csv files and temporary decrypted files
-		return db.loadCSV(file)
-	} else {
-		// Try to detect format by reading first few bytes
-		file.Seek(0, 0) // Reset to beginning
-		buffer := make([]byte, 100)
-		n, _ := file.Read(buffer)
-		content := string(buffer[:n])
-
-		file.Seek(0, 0) // Reset to beginning again
-
-		// Check if it looks like CSV (has commas and typical CSV headers)
-		if strings.Contains(content, "id,bloom_filter,minhash") || strings.Contains(content, ",") {
-			return db.loadCSV(file)
-		} else if strings.Contains(content, "{") || strings.Contains(content, "[") {
-			return db.loadJSON(file)
-		} else {
-			return fmt.Errorf("unsupported file format: %s (could not detect CSV or JSON format)", db.filename)
-		}
+	db := &TokenizedDatabase{filename: name}
+	if err := db.load(input); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// load reads tokenized data (CSV, a JSON array or JSON Lines, optionally gzipped)
+func (db *TokenizedDatabase) load(input *tokenizedInput) error {
+	switch input.format {
+	case formatJSON:
+		return db.loadJSON(input)
+	case formatJSONL:
+		return db.loadJSONL(input)
+	default:
+		return db.loadCSV(input)
 	}
 }
 
 // loadJSON loads tokenized data from JSON format
-func (db *TokenizedDatabase) loadJSON(file *os.File) error {
-	decoder := json.NewDecoder(file)
+func (db *TokenizedDatabase) loadJSON(r io.Reader) error {
+	decoder := json.NewDecoder(r)
 	if err := decoder.Decode(&db.records); err != nil {
 		return fmt.Errorf("failed to decode JSON: %w", err)
 	}
@@ -83,8 +132,8 @@ func (db *TokenizedDatabase) loadJSON(file *os.File) error {
 }
 
 // loadCSV loads tokenized data from CSV format
-func (db *TokenizedDatabase) loadCSV(file *os.File) error {
-	reader := csv.NewReader(file)
+func (db *TokenizedDatabase) loadCSV(r io.Reader) error {
+	reader := csv.NewReader(r)
 
 	// Read header
 	header, err := reader.Read()
@@ -96,6 +145,7 @@ func (db *TokenizedDatabase) loadCSV(file *os.File) error {
 	if len(header) < 3 {
 		return fmt.Errorf("invalid CSV header: expected at least id, bloom_filter, minhash")
 	}
+	blocking, exactID, provenance := blockingColumn(header), exactIDColumn(header), newProvenanceColumns(header)
 
 	// Read records
 	for {
@@ -120,6 +170,13 @@ func (db *TokenizedDatabase) loadCSV(file *os.File) error {
 		if len(row) > 3 {
 			record.Timestamp = row[3]
 		}
+		if blocking >= 0 && blocking < len(row) {
+			record.Blocking = row[blocking]
+		}
+		if exactID >= 0 && exactID < len(row) {
+			record.ExactID = row[exactID]
+		}
+		record.Provenance = provenance.read(row)
 
 		db.records = append(db.records, record)
 	}
@@ -164,35 +221,146 @@ func (db *TokenizedDatabase) ToBloomFilterRecords() ([]BloomFilterRecord, error)
 	var bfRecords []BloomFilterRecord
 
 	for _, record := range db.records {
-		// Decode Bloom filter
-		bf, err := pprl.BloomFromBase64(record.BloomFilter)
+		bfRecord, err := record.ToBloomFilterRecord()
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode Bloom filter for ID %s: %w", record.ID, err)
+			return nil, err
 		}
+		bfRecords = append(bfRecords, bfRecord)
+	}
+
+	return bfRecords, nil
+}
 
-		// Decode MinHash
-		mh, err := pprl.MinHashFromBase64(record.MinHash)
+// ToBloomFilterRecord decodes the record's Bloom filter and MinHash
+func (record TokenizedRecord) ToBloomFilterRecord() (BloomFilterRecord, error) {
+	// Decode Bloom filter
+	bf, err := pprl.BloomFromBase64(record.BloomFilter)
+	if err != nil {
+		return BloomFilterRecord{}, fmt.Errorf("failed to decode Bloom filter for ID %s: %w", record.ID, err)
+	}
+
+	// Decode MinHash
+	mh, err := pprl.MinHashFromBase64(record.MinHash)
+	if err != nil {
+		return BloomFilterRecord{}, fmt.Errorf("failed to decode MinHash for ID %s: %w", record.ID, err)
+	}
+
+	return BloomFilterRecord{
+		ID:           record.ID,
+		BloomFilter:  bf,
+		MinHash:      mh,
+		BlockingKeys: pprl.SplitBlockingKeys(record.Blocking),
+	}, nil
+}
+
+// TokenizedReader reads a tokenized CSV or JSON Lines file one record at a time, for files too
+// large to load
+type TokenizedReader struct {
+	input      *tokenizedInput
+	reader     *csv.Reader       // CSV files
+	decoder    *json.Decoder     // JSON Lines files
+	blocking   int               // Index of the CSV blocking key column, or -1
+	exactID    int               // Index of the CSV exact identifier column, or -1
+	provenance provenanceColumns // Indexes of the CSV provenance columns
+}
+
+// OpenTokenizedReader opens a tokenized file and, for CSV, reads its header
+func OpenTokenizedReader(filename string) (*TokenizedReader, error) {
+	input, err := openTokenizedInput(filename)
+	if err != nil {
+		return nil, err
+	}
+	return newTokenizedReader(filename, input)
+}
+
+// NewTokenizedReader reads tokenized records from r one at a time, as OpenTokenizedReader does. name
+// is used in place of a file name to detect the format; r is closed by Close.
+func NewTokenizedReader(name string, r io.ReadCloser) (*TokenizedReader, error) {
+	input, err := newTokenizedInput(name, r)
+	if err != nil {
+		return nil, err
+	}
+	return newTokenizedReader(name, input)
+}
+
+// newTokenizedReader reads the CSV header, if any, of an open input
+func newTokenizedReader(filename string, input *tokenizedInput) (*TokenizedReader, error) {
+	switch input.format {
+	case formatJSONL:
+		return &TokenizedReader{input: input, decoder: json.NewDecoder(input), blocking: -1, exactID: -1, provenance: newProvenanceColumns(nil)}, nil
+	case formatJSON:
+		input.Close()
+		return nil, fmt.Errorf("%s is a JSON array, which cannot be read record by record; use CSV or JSON Lines", filename)
+	}
+
+	reader := csv.NewReader(input)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		input.Close()
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	if len(header) < 3 {
+		input.Close()
+		return nil, fmt.Errorf("invalid CSV header: expected at least id, bloom_filter, minhash")
+	}
+	return &TokenizedReader{input: input, reader: reader, blocking: blockingColumn(header), exactID: exactIDColumn(header), provenance: newProvenanceColumns(header)}, nil
+}
+
+// Next returns the next record, or io.EOF after the last one
+func (r *TokenizedReader) Next() (*TokenizedRecord, error) {
+	if r.decoder != nil {
+		var record TokenizedRecord
+		if err := r.decoder.Decode(&record); err == io.EOF {
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to decode JSON Lines record: %w", err)
+		}
+		return &record, nil
+	}
+	for {
+		row, err := r.reader.Read()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode MinHash for ID %s: %w", record.ID, err)
+			return nil, fmt.Errorf("failed to read CSV row: %w", err)
 		}
-
-		bfRecord := BloomFilterRecord{
-			ID:          record.ID,
-			BloomFilter: bf,
-			MinHash:     mh,
+		if len(row) < 3 {
+			continue // Skip invalid rows
 		}
 
-		bfRecords = append(bfRecords, bfRecord)
+		record := &TokenizedRecord{
+			ID:          row[0],
+			BloomFilter: row[1],
+			MinHash:     row[2],
+		}
+		if len(row) > 3 {
+			record.Timestamp = row[3]
+		}
+		if r.blocking >= 0 && r.blocking < len(row) {
+			record.Blocking = row[r.blocking]
+		}
+		if r.exactID >= 0 && r.exactID < len(row) {
+			record.ExactID = row[r.exactID]
+		}
+		record.Provenance = r.provenance.read(row)
+		return record, nil
 	}
+}
 
-	return bfRecords, nil
+// Close closes the file
+func (r *TokenizedReader) Close() error {
+	return r.input.Close()
 }
 
 // BloomFilterRecord represents a record with decoded Bloom filter objects
 type BloomFilterRecord struct {
-	ID          string
-	BloomFilter *pprl.BloomFilter
-	MinHash     *pprl.MinHash
+	ID           string
+	BloomFilter  *pprl.BloomFilter
+	MinHash      *pprl.MinHash
+	BlockingKeys []string
 }
 
 // Save saves tokenized records to file
@@ -212,8 +380,24 @@ func (db *TokenizedDatabase) Save() error {
 		writer := csv.NewWriter(file)
 		defer writer.Flush()
 
-		// Write header
+		// Write header, with the blocking key, exact identifier and provenance columns only when
+		// some record carries them
 		header := []string{"id", "bloom_filter", "minhash", "timestamp"}
+		withBlocking, withExactID, withProvenance := false, false, false
+		for _, record := range db.records {
+			withBlocking = withBlocking || record.Blocking != ""
+			withExactID = withExactID || record.ExactID != ""
+			withProvenance = withProvenance || record.Provenance != Provenance{}
+		}
+		if withBlocking {
+			header = append(header, "blocking")
+		}
+		if withExactID {
+			header = append(header, "exact_id")
+		}
+		if withProvenance {
+			header = append(header, pprl.ProvenanceColumns...)
+		}
 		if err := writer.Write(header); err != nil {
 			return err
 		}
@@ -221,6 +405,15 @@ func (db *TokenizedDatabase) Save() error {
 		// Write records
 		for _, record := range db.records {
 			row := []string{record.ID, record.BloomFilter, record.MinHash, record.Timestamp}
+			if withBlocking {
+				row = append(row, record.Blocking)
+			}
+			if withExactID {
+				row = append(row, record.ExactID)
+			}
+			if withProvenance {
+				row = append(row, record.SourceFile, record.SourceRow, record.BatchID)
+			}
 			if err := writer.Write(row); err != nil {
 				return err
 			}
@@ -0,0 +1,230 @@
+// Package hl7 reads patient demographics from HL7v2 ADT messages, from files or MLLP streams,
+// so that hospital interface engines can feed the tokenizer directly.
+package hl7
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrUnsupportedMessage is returned for messages other than ADT^A01 and ADT^A08
+var ErrUnsupportedMessage = errors.New("hl7: unsupported message type")
+
+// SupportedEvents are the ADT trigger events whose PID segment is tokenized
+var SupportedEvents = []string{"A01", "A08"}
+
+// Demographic fields produced from the PID segment. "dob" and "zip" duplicate date_of_birth and
+// zip_code so that either naming convention can be used in database.fields.
+const (
+	FieldID          = "id"
+	FieldFirstName   = "first_name"
+	FieldMiddleName  = "middle_name"
+	FieldLastName    = "last_name"
+	FieldDateOfBirth = "date_of_birth"
+	FieldGender      = "gender"
+	FieldStreet      = "street"
+	FieldCity        = "city"
+	FieldState       = "state"
+	FieldZipCode     = "zip_code"
+	FieldPhone       = "phone"
+	FieldSSN         = "ssn"
+)
+
+// Message is a parsed HL7v2 message
+type Message struct {
+	segments [][]string // Fields of each segment; index 0 is the segment name
+
+	field, component, repetition, escape, subcomponent byte
+}
+
+// Parse parses one HL7v2 message. Segments may be separated by CR, LF or CRLF.
+func Parse(data []byte) (*Message, error) {
+	data = bytes.TrimSpace(data)
+	if !bytes.HasPrefix(data, []byte("MSH")) || len(data) < 8 {
+		return nil, fmt.Errorf("hl7: message does not start with an MSH segment")
+	}
+
+	m := &Message{
+		field:        data[3],
+		component:    data[4],
+		repetition:   data[5],
+		escape:       data[6],
+		subcomponent: data[7],
+	}
+
+	for _, line := range strings.FieldsFunc(string(data), func(r rune) bool { return r == '\r' || r == '\n' }) {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		fields := strings.Split(line, string(m.field))
+		if fields[0] == "MSH" {
+			// MSH-1 is the field separator itself, so shift the fields to keep HL7 numbering
+			fields = append([]string{"MSH", string(m.field)}, fields[1:]...)
+		}
+		m.segments = append(m.segments, fields)
+	}
+	return m, nil
+}
+
+// SplitMessages splits a file of concatenated messages, optionally MLLP-framed, at each MSH segment
+func SplitMessages(data []byte) [][]byte {
+	data = bytes.Map(func(r rune) rune {
+		if r == startBlock || r == endBlock {
+			return '\r'
+		}
+		return r
+	}, data)
+
+	var messages [][]byte
+	var current []byte
+	for _, line := range bytes.FieldsFunc(data, func(r rune) bool { return r == '\r' || r == '\n' }) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if bytes.HasPrefix(line, []byte("MSH")) && len(current) > 0 {
+			messages = append(messages, current)
+			current = nil
+		}
+		current = append(current, line...)
+		current = append(current, '\r')
+	}
+	if len(current) > 0 {
+		messages = append(messages, current)
+	}
+	return messages
+}
+
+// Field returns field index of the first segment named segment, or "" if absent
+func (m *Message) Field(segment string, index int) string {
+	for _, fields := range m.segments {
+		if fields[0] == segment {
+			if index < len(fields) {
+				return fields[index]
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// Component returns the unescaped component (1-based) of the first repetition of a field
+func (m *Message) Component(field string, index int) string {
+	if i := strings.IndexByte(field, m.repetition); i >= 0 {
+		field = field[:i]
+	}
+	components := strings.Split(field, string(m.component))
+	if index < 1 || index > len(components) {
+		return ""
+	}
+	value := components[index-1]
+	if i := strings.IndexByte(value, m.subcomponent); i >= 0 {
+		value = value[:i]
+	}
+	return m.unescape(value)
+}
+
+// Type returns the message code and trigger event from MSH-9, e.g. "ADT" and "A01"
+func (m *Message) Type() (string, string) {
+	msh9 := m.Field("MSH", 9)
+	return m.Component(msh9, 1), m.Component(msh9, 2)
+}
+
+// ControlID returns MSH-10, echoed in the acknowledgment
+func (m *Message) ControlID() string {
+	return m.Field("MSH", 10)
+}
+
+// Demographics extracts the patient demographics of an ADT^A01 or ADT^A08 message from its PID segment
+func Demographics(m *Message) (map[string]string, error) {
+	code, event := m.Type()
+	if code != "ADT" || !supportedEvent(event) {
+		return nil, fmt.Errorf("%w: %s^%s", ErrUnsupportedMessage, code, event)
+	}
+	if m.Field("PID", 0) == "" {
+		return nil, fmt.Errorf("hl7: %s^%s message has no PID segment", code, event)
+	}
+
+	name := m.Field("PID", 5)
+	address := m.Field("PID", 11)
+	record := map[string]string{
+		FieldID:          m.patientID(),
+		FieldLastName:    m.Component(name, 1),
+		FieldFirstName:   m.Component(name, 2),
+		FieldMiddleName:  m.Component(name, 3),
+		FieldDateOfBirth: formatDate(m.Component(m.Field("PID", 7), 1)),
+		FieldGender:      m.Component(m.Field("PID", 8), 1),
+		FieldStreet:      m.Component(address, 1),
+		FieldCity:        m.Component(address, 3),
+		FieldState:       m.Component(address, 4),
+		FieldZipCode:     m.Component(address, 5),
+		FieldPhone:       m.phone(),
+		FieldSSN:         m.Component(m.Field("PID", 19), 1),
+	}
+	record["dob"] = record[FieldDateOfBirth]
+	record["zip"] = record[FieldZipCode]
+
+	if record[FieldID] == "" {
+		return nil, fmt.Errorf("hl7: PID-3 patient identifier is empty")
+	}
+	return record, nil
+}
+
+// patientID returns the medical record number from PID-3, or its first identifier
+func (m *Message) patientID() string {
+	identifiers := m.Field("PID", 3)
+	for _, identifier := range strings.Split(identifiers, string(m.repetition)) {
+		if m.Component(identifier, 5) == "MR" {
+			return m.Component(identifier, 1)
+		}
+	}
+	return m.Component(identifiers, 1)
+}
+
+// phone returns the first home phone number from PID-13 (XTN-1, or XTN-12 in v2.5+)
+func (m *Message) phone() string {
+	phone := m.Field("PID", 13)
+	if number := m.Component(phone, 1); number != "" {
+		return number
+	}
+	return m.Component(phone, 6) + m.Component(phone, 7)
+}
+
+// unescape resolves the HL7 delimiter escape sequences (\F\, \S\, \T\, \R\, \E\)
+func (m *Message) unescape(value string) string {
+	esc := string(m.escape)
+	if !strings.Contains(value, esc) {
+		return value
+	}
+	return strings.NewReplacer(
+		esc+"F"+esc, string(m.field),
+		esc+"S"+esc, string(m.component),
+		esc+"T"+esc, string(m.subcomponent),
+		esc+"R"+esc, string(m.repetition),
+		esc+"E"+esc, esc,
+	).Replace(value)
+}
+
+func supportedEvent(event string) bool {
+	for _, supported := range SupportedEvents {
+		if event == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// formatDate turns an HL7 date or timestamp (YYYYMMDD[HHMM...]) into YYYY-MM-DD
+func formatDate(value string) string {
+	if len(value) < 8 {
+		return value
+	}
+	date, err := time.Parse("20060102", value[:8])
+	if err != nil {
+		return value
+	}
+	return date.Format("2006-01-02")
+}
@@ -0,0 +1,176 @@
+package hl7
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MLLP block characters
+const (
+	startBlock = 0x0b
+	endBlock   = 0x1c
+	carriageCR = 0x0d
+)
+
+// maxFrameSize bounds a single MLLP message
+const maxFrameSize = 16 << 20
+
+// Acknowledgment codes
+const (
+	AckAccept = "AA" // Message processed
+	AckError  = "AE" // Message could not be processed
+	AckReject = "AR" // Message type not accepted
+)
+
+// ReadFrame reads one MLLP-framed message, discarding any bytes before the start block
+func ReadFrame(r *bufio.Reader) ([]byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == startBlock {
+			break
+		}
+	}
+
+	var message []byte
+	for {
+		chunk, err := r.ReadSlice(endBlock)
+		message = append(message, chunk...)
+		if len(message) > maxFrameSize {
+			return nil, fmt.Errorf("hl7: MLLP frame exceeds %d bytes", maxFrameSize)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		break
+	}
+
+	if b, err := r.ReadByte(); err != nil {
+		return nil, err
+	} else if b != carriageCR {
+		return nil, fmt.Errorf("hl7: MLLP end block not followed by carriage return")
+	}
+	return message[:len(message)-1], nil
+}
+
+// WriteFrame writes message as one MLLP frame
+func WriteFrame(w io.Writer, message []byte) error {
+	frame := make([]byte, 0, len(message)+3)
+	frame = append(frame, startBlock)
+	frame = append(frame, message...)
+	frame = append(frame, endBlock, carriageCR)
+	_, err := w.Write(frame)
+	return err
+}
+
+// Ack builds the acknowledgment for m (which may be nil if it could not be parsed)
+func Ack(m *Message, code, text string) []byte {
+	if m == nil {
+		m = &Message{field: '|', component: '^', repetition: '~', escape: '\\', subcomponent: '&'}
+	}
+	_, event := m.Type()
+	controlID := make([]byte, 8)
+	rand.Read(controlID)
+
+	sep := string(m.field)
+	msh := []string{
+		"MSH",
+		string([]byte{m.component, m.repetition, m.escape, m.subcomponent}),
+		m.Field("MSH", 5), m.Field("MSH", 6), // Receiving application and facility become the senders
+		m.Field("MSH", 3), m.Field("MSH", 4),
+		time.Now().Format("20060102150405"),
+		"",
+		"ACK" + string(m.component) + event,
+		hex.EncodeToString(controlID),
+		"P",
+		m.Field("MSH", 12),
+	}
+	msa := []string{"MSA", code, m.ControlID(), strings.NewReplacer("\r", " ", "\n", " ", sep, " ").Replace(text)}
+	return []byte(strings.Join(msh, sep) + "\r" + strings.Join(msa, sep) + "\r")
+}
+
+// Handler processes one message. Errors wrapping ErrUnsupportedMessage are acknowledged
+// with AR, other errors with AE.
+type Handler func(m *Message) error
+
+// Serve accepts MLLP connections on listener and acknowledges every message after handle
+// returns, until ctx is canceled. Handlers may run concurrently for different connections.
+func Serve(ctx context.Context, listener net.Listener, handle Handler) error {
+	var wg sync.WaitGroup
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			wg.Wait()
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveConn(ctx, conn, handle)
+		}()
+	}
+}
+
+func serveConn(ctx context.Context, conn net.Conn, handle Handler) {
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		frame, err := ReadFrame(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				fmt.Printf("   MLLP connection %s: %v\n", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		var ack []byte
+		if m, err := Parse(frame); err != nil {
+			ack = Ack(nil, AckError, err.Error())
+		} else if err := handle(m); errors.Is(err, ErrUnsupportedMessage) {
+			ack = Ack(m, AckReject, err.Error())
+		} else if err != nil {
+			ack = Ack(m, AckError, err.Error())
+		} else {
+			ack = Ack(m, AckAccept, "")
+		}
+
+		if err := WriteFrame(conn, ack); err != nil {
+			return
+		}
+	}
+}
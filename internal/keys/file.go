@@ -0,0 +1,396 @@
+// file.go
+// Package keys provides streamed AES-256-GCM file encryption with a self-describing key header.
+package keys
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// fileMagic prefixes every encrypted file that carries a key header
+var fileMagic = []byte("CBENC\n")
+
+const (
+	// AlgorithmStream names the chunked AES-256-GCM format (header version 2)
+	AlgorithmStream = "AES-256-GCM-STREAM"
+
+	// DefaultChunkSize is the plaintext size of each encrypted chunk
+	DefaultChunkSize = 64 * 1024
+
+	maxHeaderSize   = 64 * 1024        // Bounds the JSON header length accepted on read
+	maxChunkSize    = 16 * 1024 * 1024 // Bounds the chunk size accepted on read
+	noncePrefixSize = 7                // Random per-file nonce prefix; counter and last flag fill the rest
+)
+
+// Header is the plaintext metadata stored at the start of an encrypted file.
+// It is authenticated as GCM additional data, so it cannot be altered undetected.
+type Header struct {
+	Version     int       `json:"version"`
+	Algorithm   string    `json:"alg"`
+	KeyID       string    `json:"key_id"`
+	Created     time.Time `json:"created"`
+	WrappedKey  string    `json:"wrapped_key,omitempty"`  // Data key wrapped by a KMS or PKCS#11 token (envelope mode)
+	KMSEndpoint string    `json:"kms_endpoint,omitempty"` // KMS that can unwrap WrappedKey
+	KMSKeyID    string    `json:"kms_key_id,omitempty"`   // Master key at the KMS
+	ChunkSize   int       `json:"chunk_size,omitempty"`   // Plaintext bytes per chunk (version 2)
+	NoncePrefix string    `json:"nonce_prefix,omitempty"` // Per-file nonce prefix (version 2)
+
+	PKCS11Module string `json:"pkcs11_module,omitempty"` // PKCS#11 module of the token that can unwrap WrappedKey
+	PKCS11Token  string `json:"pkcs11_token,omitempty"`  // Token label
+	PKCS11Key    string `json:"pkcs11_key,omitempty"`    // Label of the wrapping key in the token
+}
+
+// EncryptOptions selects the key used to encrypt a file
+type EncryptOptions struct {
+	Key      *Key     // Data key (ignored when Provider is set)
+	Provider Provider // Envelope mode: a fresh data key is generated and wrapped by the provider
+}
+
+// EncryptFile encrypts inputFile to outputFile and returns the header written
+func EncryptFile(inputFile, outputFile string, opts EncryptOptions) (*Header, error) {
+	in, err := os.Open(inputFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input file: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(outputFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create encrypted file: %w", err)
+	}
+
+	header, err := EncryptStream(out, in, opts)
+	if closeErr := out.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write encrypted file: %w", closeErr)
+	}
+	if err != nil {
+		os.Remove(outputFile)
+		return nil, err
+	}
+	return header, nil
+}
+
+// ReadHeader returns the key header of an encrypted file, or nil for legacy files without one
+func ReadHeader(path string) (*Header, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, _, err := readHeader(bufio.NewReader(file))
+	return header, err
+}
+
+// DecryptFile decrypts inputFile to outputFile, resolving the key named in the header
+// from src. Legacy files (nonce||ciphertext without header) use src's default key.
+// The output is removed if decryption fails part-way.
+func DecryptFile(inputFile, outputFile string, src Source) (*Header, error) {
+	in, err := os.Open(inputFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encrypted file: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(outputFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write decrypted file: %w", err)
+	}
+
+	header, err := DecryptStream(out, in, src)
+	if closeErr := out.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write decrypted file: %w", closeErr)
+	}
+	if err != nil {
+		os.Remove(outputFile)
+		return header, err
+	}
+	return header, nil
+}
+
+// EncryptStream encrypts src to dst in constant memory using the chunked format.
+//
+// Layout: magic | uint32 header length | header JSON | chunk*
+// Each chunk is a uint32 length followed by an AES-GCM sealed block of at most
+// ChunkSize plaintext bytes. Chunk nonces are nonce_prefix || uint32 counter || last flag,
+// so reordered, dropped or truncated chunks fail authentication.
+func EncryptStream(dst io.Writer, src io.Reader, opts EncryptOptions) (*Header, error) {
+	header := &Header{Version: 2, Algorithm: AlgorithmStream, Created: time.Now().UTC(), ChunkSize: DefaultChunkSize}
+
+	key := opts.Key
+	if opts.Provider != nil {
+		dataKey, err := Generate()
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := opts.Provider.Wrap(dataKey.Material)
+		if err != nil {
+			return nil, err
+		}
+		key = dataKey
+		header.WrappedKey = base64.StdEncoding.EncodeToString(wrapped)
+		describeProvider(header, opts.Provider)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("keys: no encryption key")
+	}
+	header.KeyID = key.ID
+
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	header.NoncePrefix = base64.StdEncoding.EncodeToString(prefix)
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("keys: failed to encode header: %w", err)
+	}
+
+	gcm, err := newGCM(key.Material)
+	if err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(dst)
+	w.Write(fileMagic)
+	binary.Write(w, binary.BigEndian, uint32(len(headerJSON)))
+	w.Write(headerJSON)
+
+	r := bufio.NewReaderSize(src, header.ChunkSize)
+	plain := make([]byte, header.ChunkSize)
+	sealed := make([]byte, 0, header.ChunkSize+gcm.Overhead())
+	var lenBuf [4]byte
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(r, plain)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("failed to read input: %w", err)
+		}
+
+		// The chunk is last if the input ended inside it or nothing follows it
+		last := err != nil
+		if !last {
+			if _, peekErr := r.Peek(1); peekErr == io.EOF {
+				last = true
+			}
+		}
+
+		sealed = gcm.Seal(sealed[:0], chunkNonce(prefix, counter, last), plain[:n], headerJSON)
+		binary.BigEndian.PutUint32(lenBuf[:], uint32(len(sealed)))
+		w.Write(lenBuf[:])
+		if _, err := w.Write(sealed); err != nil {
+			return nil, fmt.Errorf("failed to write encrypted data: %w", err)
+		}
+
+		if last {
+			break
+		}
+		if counter == math.MaxUint32 {
+			return nil, fmt.Errorf("keys: input too large for chunked encryption")
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write encrypted data: %w", err)
+	}
+	return header, nil
+}
+
+// DecryptStream decrypts src to dst. Chunked (version 2) files are decrypted in constant
+// memory; each chunk is authenticated before it is written. Version 1 and legacy files
+// are single GCM blocks and are decrypted in memory.
+func DecryptStream(dst io.Writer, src io.Reader, keySource Source) (*Header, error) {
+	r := bufio.NewReader(src)
+	header, headerJSON, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ResolveKey(header, keySource)
+	if err != nil {
+		return header, err
+	}
+
+	gcm, err := newGCM(key.Material)
+	if err != nil {
+		return header, err
+	}
+
+	if header == nil || header.Version < 2 {
+		return header, decryptSingle(dst, r, gcm, headerJSON)
+	}
+	if header.Algorithm != AlgorithmStream {
+		return header, fmt.Errorf("keys: unsupported algorithm %q", header.Algorithm)
+	}
+
+	prefix, err := base64.StdEncoding.DecodeString(header.NoncePrefix)
+	if err != nil || len(prefix) != noncePrefixSize {
+		return header, fmt.Errorf("keys: invalid nonce prefix in header")
+	}
+	maxSealed := header.ChunkSize + gcm.Overhead()
+
+	w := bufio.NewWriter(dst)
+	sealed := make([]byte, maxSealed)
+	plain := make([]byte, 0, header.ChunkSize)
+	var lenBuf [4]byte
+	for counter := uint32(0); ; counter++ {
+		if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+			return header, fmt.Errorf("keys: encrypted file is truncated (missing final chunk)")
+		}
+		size := int(binary.BigEndian.Uint32(lenBuf[:]))
+		if size < gcm.Overhead() || size > maxSealed {
+			return header, fmt.Errorf("keys: invalid chunk length %d", size)
+		}
+		if _, err := io.ReadFull(r, sealed[:size]); err != nil {
+			return header, fmt.Errorf("keys: encrypted file is truncated: %w", err)
+		}
+
+		// A chunk opens under exactly one of the two flags; the last flag ends the stream
+		last := false
+		plain, err = gcm.Open(plain[:0], chunkNonce(prefix, counter, false), sealed[:size], headerJSON)
+		if err != nil {
+			plain, err = gcm.Open(plain[:0], chunkNonce(prefix, counter, true), sealed[:size], headerJSON)
+			if err != nil {
+				return header, fmt.Errorf("failed to decrypt file (wrong key or corrupted data): %w", err)
+			}
+			last = true
+		}
+
+		if _, err := w.Write(plain); err != nil {
+			return header, fmt.Errorf("failed to write decrypted data: %w", err)
+		}
+		if last {
+			break
+		}
+	}
+
+	if _, err := r.Peek(1); err != io.EOF {
+		return header, fmt.Errorf("keys: unexpected data after final chunk")
+	}
+	if err := w.Flush(); err != nil {
+		return header, fmt.Errorf("failed to write decrypted data: %w", err)
+	}
+	return header, nil
+}
+
+// decryptSingle decrypts a version 1 or legacy file body (nonce||ciphertext)
+func decryptSingle(dst io.Writer, r io.Reader, gcm cipher.AEAD, headerJSON []byte) error {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read encrypted file: %w", err)
+	}
+	if len(body) < gcm.NonceSize() {
+		return fmt.Errorf("ciphertext too short")
+	}
+
+	nonce := body[:gcm.NonceSize()]
+	plaintext, err := gcm.Open(nil, nonce, body[gcm.NonceSize():], headerJSON)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt file (wrong key or corrupted data): %w", err)
+	}
+
+	if _, err := dst.Write(plaintext); err != nil {
+		return fmt.Errorf("failed to write decrypted data: %w", err)
+	}
+	return nil
+}
+
+// chunkNonce builds the 12-byte nonce for a chunk
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// ResolveKey finds the data key for a header: envelope keys are unwrapped by the KMS or
+// PKCS#11 token configured in src (a ProviderSource), others are looked up in src by key ID
+// (or src's default key if header is nil)
+func ResolveKey(header *Header, src Source) (*Key, error) {
+	if header != nil && header.WrappedKey != "" {
+		wrapped, err := base64.StdEncoding.DecodeString(header.WrappedKey)
+		if err != nil {
+			return nil, fmt.Errorf("keys: invalid wrapped key: %w", err)
+		}
+		provider, err := headerProvider(header, configuredProviders(src))
+		if err != nil {
+			return nil, err
+		}
+		material, err := provider.Unwrap(wrapped)
+		if err != nil {
+			return nil, err
+		}
+		if KeyID(material) != header.KeyID {
+			return nil, fmt.Errorf("keys: %s returned a key that does not match %s", provider.Name(), header.KeyID)
+		}
+		return &Key{ID: header.KeyID, Material: material}, nil
+	}
+
+	if src == nil {
+		return nil, ErrKeyNotFound
+	}
+	id := ""
+	if header != nil {
+		id = header.KeyID
+	}
+	return src.Lookup(id)
+}
+
+// readHeader parses the magic and JSON header; it returns a nil header for legacy data,
+// leaving the reader positioned at the start of the body either way
+func readHeader(r *bufio.Reader) (*Header, []byte, error) {
+	prefix, err := r.Peek(len(fileMagic) + 4)
+	if err != nil || !bytes.Equal(prefix[:len(fileMagic)], fileMagic) {
+		return nil, nil, nil
+	}
+
+	size := binary.BigEndian.Uint32(prefix[len(fileMagic):])
+	if size > maxHeaderSize {
+		return nil, nil, fmt.Errorf("keys: encrypted file header too large (%d bytes)", size)
+	}
+	r.Discard(len(prefix))
+
+	headerJSON := make([]byte, size)
+	if _, err := io.ReadFull(r, headerJSON); err != nil {
+		return nil, nil, fmt.Errorf("keys: truncated encrypted file header: %w", err)
+	}
+
+	var header Header
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, nil, fmt.Errorf("keys: invalid encrypted file header: %w", err)
+	}
+	if header.Version >= 2 && (header.ChunkSize <= 0 || header.ChunkSize > maxChunkSize) {
+		return nil, nil, fmt.Errorf("keys: invalid chunk size %d", header.ChunkSize)
+	}
+	return &header, headerJSON, nil
+}
+
+// newGCM creates an AES-256-GCM AEAD for key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
@@ -0,0 +1,76 @@
+// keychain.go
+// Package keys provides access to the operating system keychain through its command-line tools.
+package keys
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// DefaultKeychainService is the keychain service name keys are stored under
+const DefaultKeychainService = "cohort-bridge"
+
+// KeychainSource stores keys in the macOS Keychain (security) or the
+// freedesktop Secret Service on Linux (secret-tool), keyed by key ID
+type KeychainSource struct {
+	Service string
+}
+
+// Name returns the source name
+func (s *KeychainSource) Name() string { return "keychain:" + s.Service }
+
+// Lookup returns the key with the given ID from the keychain
+func (s *KeychainSource) Lookup(id string) (*Key, error) {
+	if id == "" {
+		return nil, ErrKeyNotFound
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", s.Service, "-a", id, "-w")
+	case "linux":
+		cmd = exec.Command("secret-tool", "lookup", "service", s.Service, "key-id", id)
+	default:
+		return nil, ErrKeyNotFound
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		// Missing tool or missing entry both mean the keychain cannot supply the key
+		return nil, ErrKeyNotFound
+	}
+
+	key, err := FromHex(strings.TrimSpace(string(out)))
+	if err != nil {
+		return nil, fmt.Errorf("keys: keychain entry %s: %w", id, err)
+	}
+	if key.ID != id {
+		return nil, fmt.Errorf("keys: keychain entry %s holds key %s", id, key.ID)
+	}
+	return key, nil
+}
+
+// Store saves a key in the keychain under its ID
+func (s *KeychainSource) Store(key *Key) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "add-generic-password", "-U", "-s", s.Service, "-a", key.ID, "-w", key.Hex())
+	case "linux":
+		cmd = exec.Command("secret-tool", "store", "--label", "CohortBridge key "+key.ID, "service", s.Service, "key-id", key.ID)
+		cmd.Stdin = strings.NewReader(key.Hex())
+	default:
+		return fmt.Errorf("keys: OS keychain not supported on %s", runtime.GOOS)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("keys: failed to store key in keychain: %v %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// keyring.go
+// Package keys provides a directory keyring with an active key and rotation.
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultKeyringDir is the keyring directory used when none is configured
+const DefaultKeyringDir = "keys"
+
+// activeFile names the file holding the active key ID
+const activeFile = "ACTIVE"
+
+// Keyring stores keys as <id>.key files in a directory. One key is active and used
+// for new encryptions; retired keys are kept so older files can still be decrypted.
+type Keyring struct {
+	Dir string
+}
+
+// Name returns the source name
+func (r *Keyring) Name() string { return "keyring:" + r.Dir }
+
+// Lookup returns the key with the given ID, or the active key if id is empty
+func (r *Keyring) Lookup(id string) (*Key, error) {
+	if id == "" {
+		key, err := r.Active()
+		if err != nil {
+			return nil, ErrKeyNotFound
+		}
+		return key, nil
+	}
+	key, err := ReadKeyFile(r.keyPath(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrKeyNotFound
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+// Active returns the active key
+func (r *Keyring) Active() (*Key, error) {
+	data, err := os.ReadFile(filepath.Join(r.Dir, activeFile))
+	if err != nil {
+		return nil, fmt.Errorf("keys: no active key in %s: %w", r.Dir, err)
+	}
+	return ReadKeyFile(r.keyPath(strings.TrimSpace(string(data))))
+}
+
+// Rotate generates a new key and makes it active. The previous key is retained.
+func (r *Keyring) Rotate() (*Key, error) {
+	if err := os.MkdirAll(r.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("keys: failed to create keyring: %w", err)
+	}
+
+	key, err := Generate()
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteKeyFile(r.keyPath(key.ID), key); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(r.Dir, activeFile), []byte(key.ID+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("keys: failed to mark key active: %w", err)
+	}
+	return key, nil
+}
+
+// ActiveOrRotate returns the active key, rotating first if there is none or it is
+// older than maxAge (zero disables aging). The boolean reports whether a rotation happened.
+func (r *Keyring) ActiveOrRotate(maxAge time.Duration) (*Key, bool, error) {
+	key, err := r.Active()
+	if err == nil && (maxAge == 0 || key.Age() < maxAge) {
+		return key, false, nil
+	}
+	key, err = r.Rotate()
+	if err != nil {
+		return nil, false, err
+	}
+	return key, true, nil
+}
+
+// List returns all keys in the keyring, oldest first, and the active key ID
+func (r *Keyring) List() ([]*Key, string, error) {
+	entries, err := os.ReadDir(r.Dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, "", nil
+		}
+		return nil, "", fmt.Errorf("keys: failed to read keyring: %w", err)
+	}
+
+	var list []*Key
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".key") {
+			continue
+		}
+		key, err := ReadKeyFile(filepath.Join(r.Dir, entry.Name()))
+		if err != nil {
+			return nil, "", err
+		}
+		list = append(list, key)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+
+	activeID := ""
+	if data, err := os.ReadFile(filepath.Join(r.Dir, activeFile)); err == nil {
+		activeID = strings.TrimSpace(string(data))
+	}
+	return list, activeID, nil
+}
+
+// Prune deletes retired keys older than maxAge and returns their IDs.
+// Files encrypted under a pruned key can no longer be decrypted.
+func (r *Keyring) Prune(maxAge time.Duration) ([]string, error) {
+	list, activeID, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []string
+	for _, key := range list {
+		if key.ID == activeID || key.Age() < maxAge {
+			continue
+		}
+		if err := os.Remove(r.keyPath(key.ID)); err != nil {
+			return pruned, fmt.Errorf("keys: failed to remove %s: %w", key.ID, err)
+		}
+		pruned = append(pruned, key.ID)
+	}
+	return pruned, nil
+}
+
+// keyPath returns the file path for a key ID
+func (r *Keyring) keyPath(id string) string {
+	return filepath.Join(r.Dir, filepath.Base(id)+".key")
+}
@@ -0,0 +1,63 @@
+// keys.go
+// Package keys manages the AES-256 data encryption keys used for token files:
+// key identification, storage, rotation, lookup and envelope encryption.
+package keys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// KeySize is the length in bytes of an AES-256 data key
+const KeySize = 32
+
+// Key is a data encryption key together with its identifier
+type Key struct {
+	ID       string    // Stable identifier derived from the key material
+	Material []byte    // Raw AES-256 key
+	Created  time.Time // Creation time (zero if unknown)
+}
+
+// Generate creates a new random data key
+func Generate() (*Key, error) {
+	material := make([]byte, KeySize)
+	if _, err := rand.Read(material); err != nil {
+		return nil, fmt.Errorf("keys: failed to generate key: %w", err)
+	}
+	return &Key{ID: KeyID(material), Material: material, Created: time.Now().UTC()}, nil
+}
+
+// FromHex parses a 64-character hex key
+func FromHex(keyHex string) (*Key, error) {
+	material, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return nil, fmt.Errorf("keys: invalid hex key: %w", err)
+	}
+	if len(material) != KeySize {
+		return nil, fmt.Errorf("keys: key must be %d bytes, got %d", KeySize, len(material))
+	}
+	return &Key{ID: KeyID(material), Material: material}, nil
+}
+
+// Hex returns the key material hex-encoded
+func (k *Key) Hex() string {
+	return hex.EncodeToString(k.Material)
+}
+
+// Age returns how long ago the key was created, or zero if unknown
+func (k *Key) Age() time.Duration {
+	if k.Created.IsZero() {
+		return 0
+	}
+	return time.Since(k.Created)
+}
+
+// KeyID derives a public identifier for key material. The ID reveals nothing
+// about the key but lets an encrypted file name the key it needs.
+func KeyID(material []byte) string {
+	sum := sha256.Sum256(append([]byte("cohort-bridge-key-id:"), material...))
+	return "cbk-" + hex.EncodeToString(sum[:8])
+}
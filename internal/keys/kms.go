@@ -0,0 +1,147 @@
+// kms.go
+// Package keys provides envelope encryption of data keys through a KMS HTTP endpoint.
+package keys
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/retry"
+)
+
+// KMSTokenEnvVar holds an optional bearer token for the KMS endpoint
+const KMSTokenEnvVar = "COHORT_KMS_TOKEN"
+
+// KMSClient wraps and unwraps data keys with a master key held by a KMS.
+//
+// The endpoint must accept JSON POSTs:
+//
+//	POST {endpoint}/wrap   {"key_id": "...", "plaintext": "<base64>"}  -> {"ciphertext": "<base64>"}
+//	POST {endpoint}/unwrap {"key_id": "...", "ciphertext": "<base64>"} -> {"plaintext": "<base64>"}
+//	POST {endpoint}/mac    {"key_id": "...", "plaintext": "<base64>"}  -> {"mac": "<base64>"}
+//
+// /mac is only needed to derive HMAC secrets; it returns the HMAC-SHA256 of the plaintext.
+type KMSClient struct {
+	Endpoint   string
+	KeyID      string // Master key identifier at the KMS
+	MACKeyID   string // HMAC key identifier at the KMS, for DeriveSecret
+	Token      string // Optional bearer token
+	HTTPClient *http.Client
+	Retry      retry.Policy // Retries of a request failing on the network or with a server error
+}
+
+// NewKMSClient creates a client, reading the bearer token from COHORT_KMS_TOKEN
+func NewKMSClient(endpoint, keyID string) *KMSClient {
+	return &KMSClient{
+		Endpoint:   strings.TrimRight(endpoint, "/"),
+		KeyID:      keyID,
+		Token:      os.Getenv(KMSTokenEnvVar),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		Retry:      retry.Default,
+	}
+}
+
+type kmsRequest struct {
+	KeyID      string `json:"key_id"`
+	Plaintext  string `json:"plaintext,omitempty"`
+	Ciphertext string `json:"ciphertext,omitempty"`
+}
+
+type kmsResponse struct {
+	Plaintext  string `json:"plaintext,omitempty"`
+	Ciphertext string `json:"ciphertext,omitempty"`
+	MAC        string `json:"mac,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Name returns the provider name
+func (c *KMSClient) Name() string { return "kms:" + c.Endpoint }
+
+// Wrap encrypts a data key under the KMS master key
+func (c *KMSClient) Wrap(dataKey []byte) ([]byte, error) {
+	resp, err := c.call("wrap", kmsRequest{KeyID: c.KeyID, Plaintext: base64.StdEncoding.EncodeToString(dataKey)})
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Ciphertext)
+}
+
+// Unwrap decrypts a wrapped data key
+func (c *KMSClient) Unwrap(wrapped []byte) ([]byte, error) {
+	resp, err := c.call("unwrap", kmsRequest{KeyID: c.KeyID, Ciphertext: base64.StdEncoding.EncodeToString(wrapped)})
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Plaintext)
+}
+
+// DeriveSecret returns the HMAC-SHA256 of label under the KMS HMAC key
+func (c *KMSClient) DeriveSecret(label string) ([]byte, error) {
+	if c.MACKeyID == "" {
+		return nil, fmt.Errorf("keys: no KMS HMAC key configured")
+	}
+	resp, err := c.call("mac", kmsRequest{KeyID: c.MACKeyID, Plaintext: base64.StdEncoding.EncodeToString([]byte(label))})
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.MAC)
+}
+
+// call performs one JSON request against the KMS endpoint, retrying transient failures
+func (c *KMSClient) call(op string, req kmsRequest) (*kmsResponse, error) {
+	var resp *kmsResponse
+	err := c.Retry.Do("KMS "+op+" request", func() error {
+		var err error
+		resp, err = c.post(op, req)
+		return err
+	})
+	return resp, err
+}
+
+// post sends one JSON request to the KMS endpoint
+func (c *KMSClient) post(op string, req kmsRequest) (*kmsResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, c.Endpoint+"/"+op, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("keys: invalid KMS endpoint: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpResp, err := c.HTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("keys: KMS %s request failed: %w", op, err)
+	}
+	defer httpResp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("keys: failed to read KMS response: %w", err)
+	}
+
+	var resp kmsResponse
+	if err := json.Unmarshal(data, &resp); err != nil && httpResp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("keys: invalid KMS response: %w", err)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		msg := resp.Error
+		if msg == "" {
+			msg = strings.TrimSpace(string(data))
+		}
+		return nil, retry.HTTPError(httpResp.StatusCode, fmt.Errorf("keys: KMS %s failed (%s): %s", op, httpResp.Status, msg))
+	}
+	return &resp, nil
+}
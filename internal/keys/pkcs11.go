@@ -0,0 +1,105 @@
+// pkcs11.go
+// Package keys provides keys held in a PKCS#11 token (an HSM or smart card) through OpenSC's pkcs11-tool.
+package keys
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// PKCS11PINEnvVar holds the user PIN of the PKCS#11 token
+const PKCS11PINEnvVar = "COHORT_PKCS11_PIN"
+
+// pkcs11IVSize is the size of the AES-CBC IV prefixed to wrapped keys
+const pkcs11IVSize = 16
+
+// PKCS11Provider uses keys that never leave a PKCS#11 token: an AES key (Key) wraps data keys
+// and a generic secret key (HMACKey) derives HMAC secrets. Operations run through pkcs11-tool,
+// so no PKCS#11 library is linked in; data keys pass through its standard input and output only.
+//
+// A wrapped key is a random IV followed by the AES-CBC-PAD encryption of the data key. It is not
+// authenticated by the token; the key ID in the file header is checked after unwrapping instead.
+type PKCS11Provider struct {
+	Module  string // PKCS#11 module (shared library) of the token
+	Token   string // Token label
+	Key     string // Label of the AES key wrapping data keys
+	HMACKey string // Label of the generic secret key deriving HMAC secrets
+	Tool    string // pkcs11-tool binary (default: pkcs11-tool on PATH)
+}
+
+// Name returns the provider name
+func (p *PKCS11Provider) Name() string { return "pkcs11:" + p.Token }
+
+// Wrap encrypts a data key under the token's AES key
+func (p *PKCS11Provider) Wrap(dataKey []byte) ([]byte, error) {
+	if p.Key == "" {
+		return nil, fmt.Errorf("keys: no PKCS#11 wrapping key label configured")
+	}
+	iv := make([]byte, pkcs11IVSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, fmt.Errorf("failed to generate IV: %w", err)
+	}
+	ciphertext, err := p.run("encrypt", dataKey, "--encrypt", "--mechanism", "AES-CBC-PAD", "--iv", hex.EncodeToString(iv), "--label", p.Key)
+	if err != nil {
+		return nil, err
+	}
+	return append(iv, ciphertext...), nil
+}
+
+// Unwrap decrypts a wrapped data key with the token's AES key
+func (p *PKCS11Provider) Unwrap(wrapped []byte) ([]byte, error) {
+	if len(wrapped) <= pkcs11IVSize {
+		return nil, fmt.Errorf("keys: wrapped key too short")
+	}
+	iv, ciphertext := wrapped[:pkcs11IVSize], wrapped[pkcs11IVSize:]
+	return p.run("decrypt", ciphertext, "--decrypt", "--mechanism", "AES-CBC-PAD", "--iv", hex.EncodeToString(iv), "--label", p.Key)
+}
+
+// DeriveSecret signs label with the token's HMAC key (CKM_SHA256_HMAC)
+func (p *PKCS11Provider) DeriveSecret(label string) ([]byte, error) {
+	if p.HMACKey == "" {
+		return nil, fmt.Errorf("keys: no PKCS#11 HMAC key label configured")
+	}
+	return p.run("sign", []byte(label), "--sign", "--mechanism", "SHA256-HMAC", "--label", p.HMACKey)
+}
+
+// run logs in to the token and performs one operation on input. The PIN is handed to pkcs11-tool
+// through its environment, never on the command line.
+func (p *PKCS11Provider) run(op string, input []byte, args ...string) ([]byte, error) {
+	if p.Module == "" {
+		return nil, fmt.Errorf("keys: no PKCS#11 module configured")
+	}
+	if os.Getenv(PKCS11PINEnvVar) == "" {
+		return nil, fmt.Errorf("keys: set %s to the PIN of PKCS#11 token %q", PKCS11PINEnvVar, p.Token)
+	}
+	tool := p.Tool
+	if tool == "" {
+		tool = "pkcs11-tool"
+	}
+
+	cmdArgs := []string{"--module", p.Module, "--login", "--pin", "env:" + PKCS11PINEnvVar}
+	if p.Token != "" {
+		cmdArgs = append(cmdArgs, "--token-label", p.Token)
+	}
+	cmdArgs = append(cmdArgs, args...)
+	cmdArgs = append(cmdArgs, "--input-file", "/dev/stdin", "--output-file", "/dev/stdout")
+
+	cmd := exec.Command(tool, cmdArgs...)
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("keys: PKCS#11 %s failed: %v %s", op, err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("keys: PKCS#11 %s returned no data", op)
+	}
+	return stdout.Bytes(), nil
+}
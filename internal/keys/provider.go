@@ -0,0 +1,102 @@
+// provider.go
+// Package keys provides master keys held outside the process, by a PKCS#11 token or a KMS.
+package keys
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Provider performs operations with master keys that never leave it: wrapping the data keys of
+// encrypted files and deriving HMAC secrets such as the linkage secret. The results exist only
+// in memory; nothing is written to a key file.
+type Provider interface {
+	Name() string
+	Wrap(dataKey []byte) ([]byte, error)
+	Unwrap(wrapped []byte) ([]byte, error)
+
+	// DeriveSecret returns the HMAC-SHA256 of label under the provider's HMAC key. Providers
+	// holding the same HMAC key derive the same secret.
+	DeriveSecret(label string) ([]byte, error)
+}
+
+// describeProvider records in header which provider wrapped its data key
+func describeProvider(header *Header, p Provider) {
+	switch p := p.(type) {
+	case *KMSClient:
+		header.KMSEndpoint = p.Endpoint
+		header.KMSKeyID = p.KeyID
+	case *PKCS11Provider:
+		header.PKCS11Module = p.Module
+		header.PKCS11Token = p.Token
+		header.PKCS11Key = p.Key
+	}
+}
+
+// ProviderSource holds the providers configured locally. Envelope-encrypted files are unwrapped
+// only by one of these: the file header, which is not authenticated until the data key has been
+// unwrapped, may pick a configured provider but never names a module or endpoint to use.
+type ProviderSource struct {
+	Providers []Provider
+}
+
+// Name returns the source name
+func (s *ProviderSource) Name() string { return "providers" }
+
+// Lookup never finds a key: providers unwrap the keys named in file headers instead
+func (s *ProviderSource) Lookup(id string) (*Key, error) { return nil, ErrKeyNotFound }
+
+// configuredProviders collects the providers of every ProviderSource in src
+func configuredProviders(src Source) []Provider {
+	switch src := src.(type) {
+	case *ProviderSource:
+		return src.Providers
+	case Chain:
+		var providers []Provider
+		for _, s := range src {
+			providers = append(providers, configuredProviders(s)...)
+		}
+		return providers
+	}
+	return nil
+}
+
+// headerProvider returns the configured provider that can unwrap the data key of an
+// envelope-encrypted file. A header naming a module, token or KMS endpoint other than the
+// configured one is refused; it may only choose the wrapping key on the configured token or KMS.
+func headerProvider(header *Header, configured []Provider) (Provider, error) {
+	switch {
+	case header.PKCS11Key != "":
+		for _, p := range configured {
+			p, ok := p.(*PKCS11Provider)
+			if !ok {
+				continue
+			}
+			if (header.PKCS11Module != "" && header.PKCS11Module != p.Module) || header.PKCS11Token != p.Token {
+				return nil, fmt.Errorf("keys: file was wrapped by PKCS#11 token %q (module %s), not the configured token %q (module %s)",
+					header.PKCS11Token, header.PKCS11Module, p.Token, p.Module)
+			}
+			unwrapper := *p
+			unwrapper.Key = header.PKCS11Key
+			return &unwrapper, nil
+		}
+		return nil, fmt.Errorf("keys: file was wrapped by PKCS#11 token %q; configure keys.pkcs11_module and keys.pkcs11_token to unwrap it",
+			header.PKCS11Token)
+	case header.KMSEndpoint != "":
+		// The bearer token goes only to the configured endpoint, never to one a file names
+		for _, p := range configured {
+			p, ok := p.(*KMSClient)
+			if !ok {
+				continue
+			}
+			if strings.TrimRight(header.KMSEndpoint, "/") != p.Endpoint {
+				return nil, fmt.Errorf("keys: file was wrapped by KMS %s, not the configured KMS %s", header.KMSEndpoint, p.Endpoint)
+			}
+			unwrapper := *p
+			unwrapper.KeyID = header.KMSKeyID
+			return &unwrapper, nil
+		}
+		return nil, fmt.Errorf("keys: file was wrapped by KMS %s; configure keys.kms_endpoint to unwrap it", header.KMSEndpoint)
+	}
+	return nil, fmt.Errorf("keys: wrapped key names no KMS or PKCS#11 token")
+}
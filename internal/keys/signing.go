@@ -0,0 +1,103 @@
+// signing.go
+// Ed25519 signing keys let a party sign the intersection results it sends, so the receiving
+// party can check their origin and integrity against a public key pinned in its config.
+package keys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// GenerateSigningKey creates an Ed25519 key pair and writes the private key to path as
+// PKCS#8 PEM, readable only by the owner. An existing file is never overwritten.
+func GenerateSigningKey(path string) (ed25519.PublicKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("keys: failed to generate signing key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("keys: failed to encode signing key: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("keys: failed to create signing key file: %w", err)
+	}
+	if err := pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("keys: failed to write signing key: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("keys: failed to write signing key: %w", err)
+	}
+	return public, nil
+}
+
+// LoadSigningKey reads an Ed25519 private key from a PKCS#8 PEM file
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("keys: failed to read signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("keys: %s is not a PEM private key", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("keys: invalid signing key: %w", err)
+	}
+	private, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("keys: %s is not an Ed25519 key", path)
+	}
+	return private, nil
+}
+
+// EncodePublicKey returns the base64 form of a public key, as pinned in peer configs
+func EncodePublicKey(public ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(public)
+}
+
+// ParsePublicKey parses a base64 Ed25519 public key
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("keys: invalid base64 public key: %w", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("keys: Ed25519 public key must be %d bytes, got %d", ed25519.PublicKeySize, len(raw))
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// SigningKeyID derives a short public identifier for a signing key, so a signature can
+// name the key that made it
+func SigningKeyID(public ed25519.PublicKey) string {
+	sum := sha256.Sum256(append([]byte("cohort-bridge-signing-key-id:"), public...))
+	return "cbs-" + hex.EncodeToString(sum[:8])
+}
+
+// Sign signs payload with Ed25519ctx; context separates signatures made for different purposes
+func Sign(private ed25519.PrivateKey, context string, payload []byte) ([]byte, error) {
+	return private.Sign(nil, payload, &ed25519.Options{Hash: crypto.Hash(0), Context: context})
+}
+
+// Verify checks an Ed25519ctx signature made by Sign with the same context
+func Verify(public ed25519.PublicKey, context string, payload, signature []byte) error {
+	if err := ed25519.VerifyWithOptions(public, payload, signature, &ed25519.Options{Context: context}); err != nil {
+		return errors.New("keys: signature verification failed")
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+// source.go
+// Package keys provides lookup of data keys from files, environment variables and chains of sources.
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultEnvVar is the environment variable holding a hex data key
+const DefaultEnvVar = "COHORT_ENCRYPTION_KEY"
+
+// ErrKeyNotFound is returned when a source does not hold the requested key
+var ErrKeyNotFound = errors.New("keys: key not found")
+
+// Source looks up data keys by ID. An empty ID asks for the source's default key,
+// which is used for legacy files that carry no key metadata.
+type Source interface {
+	Name() string
+	Lookup(id string) (*Key, error)
+}
+
+// StaticSource serves a fixed set of keys, e.g. from a -key flag or .key file
+type StaticSource struct {
+	Keys []*Key
+}
+
+// Name returns the source name
+func (s *StaticSource) Name() string { return "static" }
+
+// Lookup returns the key with the given ID, or the first key if id is empty
+func (s *StaticSource) Lookup(id string) (*Key, error) {
+	for _, key := range s.Keys {
+		if id == "" || key.ID == id {
+			return key, nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+// EnvSource reads a hex data key from an environment variable
+type EnvSource struct {
+	Var string
+}
+
+// Name returns the source name
+func (s *EnvSource) Name() string { return "env:" + s.Var }
+
+// Lookup returns the key held in the environment variable if it matches id
+func (s *EnvSource) Lookup(id string) (*Key, error) {
+	value := strings.TrimSpace(os.Getenv(s.Var))
+	if value == "" {
+		return nil, ErrKeyNotFound
+	}
+	key, err := FromHex(value)
+	if err != nil {
+		return nil, fmt.Errorf("keys: %s: %w", s.Var, err)
+	}
+	if id != "" && key.ID != id {
+		return nil, ErrKeyNotFound
+	}
+	return key, nil
+}
+
+// Chain tries each source in order
+type Chain []Source
+
+// Name returns the names of all sources in the chain
+func (c Chain) Name() string {
+	names := make([]string, 0, len(c))
+	for _, src := range c {
+		names = append(names, src.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+// Lookup returns the first key found for id; hard errors from a source are reported
+// only if no other source can supply the key
+func (c Chain) Lookup(id string) (*Key, error) {
+	var firstErr error
+	for _, src := range c {
+		key, err := src.Lookup(id)
+		if err == nil {
+			return key, nil
+		}
+		if !errors.Is(err, ErrKeyNotFound) && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if id == "" {
+		return nil, ErrKeyNotFound
+	}
+	return nil, fmt.Errorf("%w: %s (searched %s)", ErrKeyNotFound, id, c.Name())
+}
+
+// DefaultSources returns the standard lookup chain: explicit keys first, then the
+// environment variable, the keyring directory and the OS keychain
+func DefaultSources(keyringDir string, explicit ...*Key) Chain {
+	var chain Chain
+	if len(explicit) > 0 {
+		chain = append(chain, &StaticSource{Keys: explicit})
+	}
+	chain = append(chain, &EnvSource{Var: DefaultEnvVar})
+	if keyringDir != "" {
+		chain = append(chain, &Keyring{Dir: keyringDir})
+	}
+	chain = append(chain, &KeychainSource{Service: DefaultKeychainService})
+	return chain
+}
+
+// WriteKeyFile saves a key to a file with restricted permissions. Metadata is written
+// as comments so older readers that only look for the hex line still work.
+func WriteKeyFile(path string, key *Key) error {
+	created := key.Created
+	if created.IsZero() {
+		created = time.Now().UTC()
+	}
+	data := fmt.Sprintf("# CohortBridge Encryption Key\n# Key-ID: %s\n# Created: %s\n# WARNING: Keep this key secure! Without it, your data cannot be decrypted.\n\n%s\n",
+		key.ID, created.Format(time.RFC3339), key.Hex())
+
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		return fmt.Errorf("keys: failed to write key file: %w", err)
+	}
+	return nil
+}
+
+// ReadKeyFile loads a key written by WriteKeyFile (or a bare hex key file)
+func ReadKeyFile(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("keys: failed to read key file: %w", err)
+	}
+
+	var key *Key
+	var created time.Time
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			if value, ok := strings.CutPrefix(line, "# Created:"); ok {
+				created, _ = time.Parse(time.RFC3339, strings.TrimSpace(value))
+			}
+			continue
+		}
+		if key == nil {
+			key, err = FromHex(line)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if key == nil {
+		return nil, fmt.Errorf("keys: no encryption key found in %s", path)
+	}
+	key.Created = created
+	return key, nil
+}
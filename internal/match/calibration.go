@@ -0,0 +1,222 @@
+// calibration.go
+// Package match provides calibration of raw Bloom filter / MinHash scores into match probabilities.
+// A logistic model (Platt scaling over Hamming distance and Jaccard similarity) is trained
+// against ground truth in validation, or a Fellegi–Sunter model without it, and then applied by
+// both parties during matching.
+package match
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+)
+
+// CalibrationMethodPlatt is logistic regression on the raw scores with Platt's smoothed targets
+const CalibrationMethodPlatt = "platt"
+
+// Calibration maps a pair's Hamming distance and Jaccard similarity to a match probability
+type Calibration struct {
+	Method        string    `json:"method"`
+	Intercept     float64   `json:"intercept"`
+	HammingWeight float64   `json:"hamming_weight"` // Weight of Hamming distance / HammingScale
+	JaccardWeight float64   `json:"jaccard_weight"`
+	HammingScale  float64   `json:"hamming_scale"` // Bloom filter size the model was trained on
+	Samples       int       `json:"samples"`
+	Positives     int       `json:"positives"`
+	Brier         float64   `json:"brier"` // Mean squared error of the training probabilities
+	TrainedAt     time.Time `json:"trained_at"`
+
+	FellegiSunter *FellegiSunterModel `json:"fellegi_sunter,omitempty"` // Set by the fellegi-sunter method
+}
+
+// ScoredPair is a compared record pair with its ground truth label
+type ScoredPair struct {
+	ID1     string
+	ID2     string
+	Hamming uint32
+	Jaccard float64
+	Match   bool
+}
+
+// Probability returns the calibrated match probability for a pair's raw scores
+func (c *Calibration) Probability(hamming uint32, jaccard float64) float64 {
+	scale := c.HammingScale
+	if scale <= 0 {
+		scale = 1
+	}
+	if c.FellegiSunter != nil {
+		return c.FellegiSunter.probability(c.FellegiSunter.Weight(hamming, jaccard, scale))
+	}
+	return sigmoid(c.Intercept + c.HammingWeight*float64(hamming)/scale + c.JaccardWeight*jaccard)
+}
+
+// TrainCalibration fits a Platt scaling model to labelled pairs using Newton's method
+func TrainCalibration(pairs []ScoredPair, hammingScale float64) (*Calibration, error) {
+	positives := 0
+	for _, p := range pairs {
+		if p.Match {
+			positives++
+		}
+	}
+	negatives := len(pairs) - positives
+	if positives == 0 || negatives == 0 {
+		return nil, fmt.Errorf("calibration needs both matching and non-matching pairs (got %d matches, %d non-matches)", positives, negatives)
+	}
+	if hammingScale <= 0 {
+		hammingScale = 1
+	}
+
+	// Platt's smoothed targets keep the fit finite when the classes separate perfectly
+	targetPos := (float64(positives) + 1) / (float64(positives) + 2)
+	targetNeg := 1 / (float64(negatives) + 2)
+
+	const ridge = 1e-6
+	w := [3]float64{}
+	for iter := 0; iter < 100; iter++ {
+		var grad [3]float64
+		var hess [3][3]float64
+		for _, p := range pairs {
+			x := [3]float64{1, float64(p.Hamming) / hammingScale, p.Jaccard}
+			prob := sigmoid(w[0] + w[1]*x[1] + w[2]*x[2])
+			target := targetNeg
+			if p.Match {
+				target = targetPos
+			}
+			weight := math.Max(prob*(1-prob), 1e-12)
+			for i := 0; i < 3; i++ {
+				grad[i] += (prob - target) * x[i]
+				for j := 0; j < 3; j++ {
+					hess[i][j] += weight * x[i] * x[j]
+				}
+			}
+		}
+		for i := 0; i < 3; i++ {
+			hess[i][i] += ridge
+		}
+
+		step, err := solve3(hess, grad)
+		if err != nil {
+			return nil, fmt.Errorf("calibration did not converge: %w", err)
+		}
+		maxStep := 0.0
+		for i := 0; i < 3; i++ {
+			w[i] -= step[i]
+			maxStep = math.Max(maxStep, math.Abs(step[i]))
+		}
+		if maxStep < 1e-9 {
+			break
+		}
+	}
+
+	cal := &Calibration{
+		Method:        CalibrationMethodPlatt,
+		Intercept:     w[0],
+		HammingWeight: w[1],
+		JaccardWeight: w[2],
+		HammingScale:  hammingScale,
+		Samples:       len(pairs),
+		Positives:     positives,
+		TrainedAt:     time.Now().UTC(),
+	}
+
+	for _, p := range pairs {
+		label := 0.0
+		if p.Match {
+			label = 1
+		}
+		diff := cal.Probability(p.Hamming, p.Jaccard) - label
+		cal.Brier += diff * diff
+	}
+	cal.Brier /= float64(len(pairs))
+
+	return cal, nil
+}
+
+// LoadCalibration reads a calibration model saved by Save
+func LoadCalibration(path string) (*Calibration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read calibration: %w", err)
+	}
+	var cal Calibration
+	if err := json.Unmarshal(data, &cal); err != nil {
+		return nil, fmt.Errorf("invalid calibration file %s: %w", path, err)
+	}
+	switch {
+	case cal.Method == CalibrationMethodFellegiSunter && cal.FellegiSunter == nil:
+		return nil, fmt.Errorf("calibration %s has no Fellegi-Sunter model", path)
+	case cal.Method != CalibrationMethodPlatt && cal.Method != CalibrationMethodFellegiSunter:
+		return nil, fmt.Errorf("unsupported calibration method %q in %s", cal.Method, path)
+	}
+	return &cal, nil
+}
+
+// Save writes the calibration model as JSON
+func (c *Calibration) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// ScoreRecords computes the Hamming distance and Jaccard similarity of two records
+func ScoreRecords(bf1, bf2 *pprl.BloomFilter, minHash1, minHash2 []uint32) (uint32, float64, error) {
+	hamming, err := bf1.HammingDistance(bf2)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(minHash1) == 0 || len(minHash1) != len(minHash2) {
+		return hamming, 0, nil
+	}
+	equal := 0
+	for i := range minHash1 {
+		if minHash1[i] == minHash2[i] {
+			equal++
+		}
+	}
+	return hamming, float64(equal) / float64(len(minHash1)), nil
+}
+
+func sigmoid(z float64) float64 {
+	return 1 / (1 + math.Exp(-z))
+}
+
+// solve3 solves the 3x3 linear system a*x = b by Gaussian elimination with partial pivoting
+func solve3(a [3][3]float64, b [3]float64) ([3]float64, error) {
+	for col := 0; col < 3; col++ {
+		pivot := col
+		for row := col + 1; row < 3; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-15 {
+			return [3]float64{}, fmt.Errorf("singular system")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		b[col], b[pivot] = b[pivot], b[col]
+
+		for row := col + 1; row < 3; row++ {
+			f := a[row][col] / a[col][col]
+			for k := col; k < 3; k++ {
+				a[row][k] -= f * a[col][k]
+			}
+			b[row] -= f * b[col]
+		}
+	}
+
+	var x [3]float64
+	for row := 2; row >= 0; row-- {
+		sum := b[row]
+		for k := row + 1; k < 3; k++ {
+			sum -= a[row][k] * x[k]
+		}
+		x[row] = sum / a[row][row]
+	}
+	return x, nil
+}
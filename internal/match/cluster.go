@@ -0,0 +1,122 @@
+// cluster.go
+// Cluster resolution turns match pairs into a consistent assignment of records to entities.
+// With 1:many matching the pairs can disagree (A1~B1, A1~B2, A2~B1): their transitive closure is
+// one entity, which may be more than the data supports. Constraints on cluster size and on the
+// records of each party per cluster keep the closure in check; pairs are merged strongest first
+// and those that would break a constraint are left out of the clusters.
+package match
+
+import (
+	"sort"
+)
+
+// ClusterConstraints limit the clusters built from match pairs; zero values impose no limit
+type ClusterConstraints struct {
+	MaxSize     int // Most records, of both parties, in one cluster
+	MaxPerParty int // Most records of one party in one cluster (1: no duplicates within a dataset)
+}
+
+// ClusterEdge is a match pair to be resolved into clusters
+type ClusterEdge struct {
+	LocalID string
+	PeerID  string
+	Rank    float64 // Pairs of higher rank are merged first
+}
+
+// ClusterResolution is the cluster assignment of a set of match pairs
+type ClusterResolution struct {
+	Clusters []LinkageCluster // Every record of the pairs in exactly one cluster, ordered by linkage ID
+	Merged   int              // Pairs whose records share a cluster
+	Rejected []ClusterEdge    // Pairs left split across two clusters by the constraints
+
+	clusterOf map[string]string // Prefixed record ID to linkage ID
+}
+
+// ResolveClusters groups match pairs into clusters by union-find, merging pairs in order of rank
+// and skipping a merge that would break the constraints. The order breaks rank ties on the two
+// record IDs without regard to party, so both parties resolve mirrored pairs into the same
+// clusters; linkage IDs are derived as for BuildCrosswalk.
+func ResolveClusters(edges []ClusterEdge, constraints ClusterConstraints, secret []byte) *ClusterResolution {
+	ordered := append([]ClusterEdge(nil), edges...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].Rank != ordered[j].Rank {
+			return ordered[i].Rank > ordered[j].Rank
+		}
+		lowI, highI := partyAgnostic(ordered[i])
+		lowJ, highJ := partyAgnostic(ordered[j])
+		if lowI != lowJ {
+			return lowI < lowJ
+		}
+		return highI < highJ
+	})
+
+	uf := newUnionFind()
+	locals := make(map[string]int) // Local records per root
+	peers := make(map[string]int)  // Peer records per root
+	root := func(node string, local bool) string {
+		if _, seen := uf.parent[node]; !seen {
+			if local {
+				locals[node] = 1
+			} else {
+				peers[node] = 1
+			}
+		}
+		return uf.find(node)
+	}
+
+	resolution := &ClusterResolution{}
+	var deferred []ClusterEdge
+	for _, edge := range ordered {
+		a, b := root(localPrefix+edge.LocalID, true), root(peerPrefix+edge.PeerID, false)
+		if a == b {
+			continue
+		}
+		local, peer := locals[a]+locals[b], peers[a]+peers[b]
+		if (constraints.MaxSize > 0 && local+peer > constraints.MaxSize) ||
+			(constraints.MaxPerParty > 0 && (local > constraints.MaxPerParty || peer > constraints.MaxPerParty)) {
+			deferred = append(deferred, edge)
+			continue
+		}
+		uf.union(a, b)
+		merged := uf.find(a)
+		locals[merged], peers[merged] = local, peer
+	}
+
+	resolution.Clusters = linkageClusters(uf, secret)
+	resolution.clusterOf = make(map[string]string)
+	for _, cluster := range resolution.Clusters {
+		for _, id := range cluster.LocalIDs {
+			resolution.clusterOf[localPrefix+id] = cluster.LinkageID
+		}
+		for _, id := range cluster.PeerIDs {
+			resolution.clusterOf[peerPrefix+id] = cluster.LinkageID
+		}
+	}
+
+	// A pair skipped early may still have been joined through other pairs
+	for _, edge := range deferred {
+		if resolution.LocalCluster(edge.LocalID) != resolution.PeerCluster(edge.PeerID) {
+			resolution.Rejected = append(resolution.Rejected, edge)
+		}
+	}
+	resolution.Merged = len(edges) - len(resolution.Rejected)
+	return resolution
+}
+
+// partyAgnostic returns the record IDs of an edge in sorted order
+func partyAgnostic(edge ClusterEdge) (string, string) {
+	if edge.LocalID < edge.PeerID {
+		return edge.LocalID, edge.PeerID
+	}
+	return edge.PeerID, edge.LocalID
+}
+
+// LocalCluster returns the linkage ID of a local record's cluster, or "" if it is in no pair
+func (r *ClusterResolution) LocalCluster(id string) string {
+	return r.clusterOf[localPrefix+id]
+}
+
+// PeerCluster returns the linkage ID of a peer record's cluster, or "" if it is in no pair
+func (r *ClusterResolution) PeerCluster(id string) string {
+	return r.clusterOf[peerPrefix+id]
+}
@@ -0,0 +1,137 @@
+// comparator.go
+// Comparators score a pair of tokenized records for the matcher. The built-in one compares Bloom
+// filters by Hamming distance and MinHash signatures by Jaccard similarity; research
+// collaborators can register experimental ones, such as TF-IDF weighted q-grams or embeddings,
+// and select them by name with matching.comparator, without forking the matcher loop.
+//
+// A comparator is registered from the init function of its own file, typically behind a build
+// tag as the database drivers are:
+//
+//	//go:build tfidf
+//
+//	func init() {
+//		match.RegisterComparator("tfidf", func() match.Comparator { return &tfidfComparator{} })
+//	}
+package match
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+)
+
+// DefaultComparator is the name of the Bloom filter and MinHash comparator
+const DefaultComparator = "bloom"
+
+// Score is a comparator's verdict on a pair of records. Distance is held to the Hamming threshold
+// and Similarity to the Jaccard threshold, so a comparator scoring only one way should leave the
+// other where it always passes (Distance 0, Similarity 1).
+type Score struct {
+	Distance   uint32
+	Similarity float64
+}
+
+// Comparator scores a local record against a peer record. Compare is called for every candidate
+// pair, so it must be safe to call repeatedly; an error stops the intersection.
+type Comparator interface {
+	Compare(a, b *pprl.Record) (Score, error)
+}
+
+// ComparatorFunc adapts a function to a Comparator
+type ComparatorFunc func(a, b *pprl.Record) (Score, error)
+
+// Compare calls f(a, b)
+func (f ComparatorFunc) Compare(a, b *pprl.Record) (Score, error) {
+	return f(a, b)
+}
+
+// BloomComparator is the default comparator: the Hamming distance between the records' Bloom
+// filters and the Jaccard similarity of their MinHash signatures. The matcher computes the same
+// scores itself, without decoding a filter more than once, when it is selected.
+type BloomComparator struct{}
+
+// Compare decodes both Bloom filters and scores the pair
+func (BloomComparator) Compare(a, b *pprl.Record) (Score, error) {
+	bfA, err := pprl.BloomFromBase64(a.BloomData)
+	if err != nil {
+		return Score{}, fmt.Errorf("record %s: %w", a.ID, err)
+	}
+	bfB, err := pprl.BloomFromBase64(b.BloomData)
+	if err != nil {
+		return Score{}, fmt.Errorf("record %s: %w", b.ID, err)
+	}
+	distance, err := bfA.HammingDistance(bfB)
+	if err != nil {
+		return Score{}, err
+	}
+	similarity, err := pprl.JaccardSimilarity(a.MinHash, b.MinHash)
+	if err != nil {
+		return Score{}, err
+	}
+	return Score{Distance: distance, Similarity: similarity}, nil
+}
+
+var (
+	comparatorsMu sync.RWMutex
+	comparators   = map[string]func() Comparator{
+		DefaultComparator: func() Comparator { return BloomComparator{} },
+	}
+)
+
+// RegisterComparator makes a comparator available by name. It panics if the name is empty or
+// already registered, or if factory is nil.
+func RegisterComparator(name string, factory func() Comparator) {
+	comparatorsMu.Lock()
+	defer comparatorsMu.Unlock()
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		panic("match: comparator registered without a name")
+	}
+	if factory == nil {
+		panic("match: comparator " + name + " registered with a nil factory")
+	}
+	if _, dup := comparators[name]; dup {
+		panic("match: comparator " + name + " registered twice")
+	}
+	comparators[name] = factory
+}
+
+// NewComparator returns a new instance of the comparator registered under name; an empty name
+// selects DefaultComparator
+func NewComparator(name string) (Comparator, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = DefaultComparator
+	}
+	comparatorsMu.RLock()
+	factory, ok := comparators[name]
+	comparatorsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown comparator %q (registered: %s)", name, strings.Join(Comparators(), ", "))
+	}
+	return factory(), nil
+}
+
+// Comparators returns the names of the registered comparators, sorted
+func Comparators() []string {
+	comparatorsMu.RLock()
+	defer comparatorsMu.RUnlock()
+	names := make([]string, 0, len(comparators))
+	for name := range comparators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isBloomComparator reports whether a comparator scores pairs as the matcher does on its own
+func isBloomComparator(c Comparator) bool {
+	switch c.(type) {
+	case nil, BloomComparator, *BloomComparator:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+// crosswalk.go
+// Crosswalks give every linked cluster of records a stable, anonymous linkage ID that both
+// parties derive independently from the same match pairs, for handing results to downstream teams.
+package match
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"sort"
+)
+
+// Party sides are kept apart in union-finds over records of both parties, so equal IDs on both
+// sides are different records
+const localPrefix, peerPrefix = "l\x00", "p\x00"
+
+// LinkageCluster is a group of linked records from both parties under one linkage ID.
+// With 1:1 matching every cluster holds exactly one record of each party.
+type LinkageCluster struct {
+	LinkageID string   `json:"linkage_id"`
+	LocalIDs  []string `json:"local_ids"` // Sorted
+	PeerIDs   []string `json:"peer_ids"`  // Sorted
+}
+
+// CrosswalkRow is one row of a crosswalk file: a linkage ID and the records linked under it
+type CrosswalkRow struct {
+	LinkageID string `json:"linkage_id"`
+	LocalID   string `json:"local_id,omitempty"`
+	PeerID    string `json:"peer_id,omitempty"`
+}
+
+// BuildCrosswalk groups match pairs into clusters (A~X and A~Y link A, X and Y) and assigns each
+// a linkage ID. The ID hashes the cluster's record IDs sorted without regard to party, so both
+// parties compute the same ID from their mirrored matches. With a linkage secret the hash is an
+// HMAC, so only holders of the secret can confirm which records an ID stands for. Clusters are
+// returned ordered by linkage ID.
+func BuildCrosswalk(matches []*PrivateMatchResult, secret []byte) []LinkageCluster {
+	uf := newUnionFind()
+	for _, m := range matches {
+		uf.union(localPrefix+m.LocalID, peerPrefix+m.PeerID)
+	}
+	return linkageClusters(uf, secret)
+}
+
+// linkageClusters turns the sets of a union-find over prefixed local and peer IDs into clusters
+// with linkage IDs, ordered by linkage ID
+func linkageClusters(uf *unionFind, secret []byte) []LinkageCluster {
+	members := make(map[string]*LinkageCluster)
+	for node := range uf.parent {
+		root := uf.find(node)
+		cluster, ok := members[root]
+		if !ok {
+			cluster = &LinkageCluster{}
+			members[root] = cluster
+		}
+		if node[:len(localPrefix)] == localPrefix {
+			cluster.LocalIDs = append(cluster.LocalIDs, node[len(localPrefix):])
+		} else {
+			cluster.PeerIDs = append(cluster.PeerIDs, node[len(peerPrefix):])
+		}
+	}
+
+	clusters := make([]LinkageCluster, 0, len(members))
+	for _, cluster := range members {
+		sort.Strings(cluster.LocalIDs)
+		sort.Strings(cluster.PeerIDs)
+		cluster.LinkageID = LinkageID(append(append([]string{}, cluster.LocalIDs...), cluster.PeerIDs...), secret)
+		clusters = append(clusters, *cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].LinkageID < clusters[j].LinkageID })
+	return clusters
+}
+
+// LinkageID derives the linkage ID of a set of record IDs, independent of their order
+func LinkageID(recordIDs []string, secret []byte) string {
+	sorted := append([]string{}, recordIDs...)
+	sort.Strings(sorted)
+
+	var h hash.Hash
+	if len(secret) > 0 {
+		h = hmac.New(sha256.New, secret)
+	} else {
+		h = sha256.New()
+	}
+	h.Write([]byte("cohort-bridge-linkage-id"))
+	for _, id := range sorted {
+		// Length-prefixed, so no two ID lists hash the same bytes
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(id)))
+		h.Write(length[:])
+		h.Write([]byte(id))
+	}
+	return "lnk-" + hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// CrosswalkRows flattens clusters into crosswalk rows, one per combination of a local and a peer
+// record in a cluster
+func CrosswalkRows(clusters []LinkageCluster) []CrosswalkRow {
+	var rows []CrosswalkRow
+	for _, cluster := range clusters {
+		for _, local := range cluster.LocalIDs {
+			for _, peer := range cluster.PeerIDs {
+				rows = append(rows, CrosswalkRow{LinkageID: cluster.LinkageID, LocalID: local, PeerID: peer})
+			}
+		}
+	}
+	return rows
+}
+
+// PartyCrosswalkRows returns one party's view of the crosswalk: its own record IDs and their linkage IDs
+func PartyCrosswalkRows(clusters []LinkageCluster, local bool) []CrosswalkRow {
+	var rows []CrosswalkRow
+	for _, cluster := range clusters {
+		if local {
+			for _, id := range cluster.LocalIDs {
+				rows = append(rows, CrosswalkRow{LinkageID: cluster.LinkageID, LocalID: id})
+			}
+		} else {
+			for _, id := range cluster.PeerIDs {
+				rows = append(rows, CrosswalkRow{LinkageID: cluster.LinkageID, PeerID: id})
+			}
+		}
+	}
+	return rows
+}
@@ -0,0 +1,142 @@
+// curves.go
+// Package match provides ROC and precision-recall analysis of pair scores against ground truth.
+package match
+
+import (
+	"sort"
+)
+
+// maxExportedCurvePoints bounds the points kept per curve; AUC is always computed on all of them
+const maxExportedCurvePoints = 1000
+
+// CurvePoint is the confusion state when accepting every pair scoring at or above Threshold
+type CurvePoint struct {
+	Threshold float64 `json:"threshold"`
+	TP        int     `json:"tp"`
+	FP        int     `json:"fp"`
+	TPR       float64 `json:"tpr"` // Recall
+	FPR       float64 `json:"fpr"`
+	Precision float64 `json:"precision"`
+}
+
+// Curves holds the ROC and precision-recall curves of one score
+type Curves struct {
+	Score            string       `json:"score"`
+	Positives        int          `json:"positives"`
+	Negatives        int          `json:"negatives"`
+	ROCAUC           float64      `json:"roc_auc"`
+	AveragePrecision float64      `json:"average_precision"` // Area under the PR curve
+	MatchMean        float64      `json:"match_mean"`        // Mean score of true matches
+	NonMatchMean     float64      `json:"non_match_mean"`    // Mean score of non-matches
+	Points           []CurvePoint `json:"points"`
+}
+
+// ComputeCurves builds ROC and PR curves for scores where higher means more likely a match
+func ComputeCurves(score string, scores []float64, labels []bool) *Curves {
+	curves := &Curves{Score: score}
+	if len(scores) == 0 || len(scores) != len(labels) {
+		return curves
+	}
+
+	order := make([]int, len(scores))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return scores[order[a]] > scores[order[b]] })
+
+	var matchSum, nonMatchSum float64
+	for i, label := range labels {
+		if label {
+			curves.Positives++
+			matchSum += scores[i]
+		} else {
+			curves.Negatives++
+			nonMatchSum += scores[i]
+		}
+	}
+	if curves.Positives > 0 {
+		curves.MatchMean = matchSum / float64(curves.Positives)
+	}
+	if curves.Negatives > 0 {
+		curves.NonMatchMean = nonMatchSum / float64(curves.Negatives)
+	}
+
+	// Walk thresholds from strictest to loosest, emitting a point per distinct score
+	var points []CurvePoint
+	tp, fp := 0, 0
+	prevTPR, prevFPR, prevRecall := 0.0, 0.0, 0.0
+	for i := 0; i < len(order); {
+		threshold := scores[order[i]]
+		for i < len(order) && scores[order[i]] == threshold {
+			if labels[order[i]] {
+				tp++
+			} else {
+				fp++
+			}
+			i++
+		}
+
+		point := CurvePoint{Threshold: threshold, TP: tp, FP: fp, Precision: float64(tp) / float64(tp+fp)}
+		if curves.Positives > 0 {
+			point.TPR = float64(tp) / float64(curves.Positives)
+		}
+		if curves.Negatives > 0 {
+			point.FPR = float64(fp) / float64(curves.Negatives)
+		}
+
+		curves.ROCAUC += (point.FPR - prevFPR) * (point.TPR + prevTPR) / 2
+		curves.AveragePrecision += (point.TPR - prevRecall) * point.Precision
+		prevTPR, prevFPR, prevRecall = point.TPR, point.FPR, point.TPR
+
+		points = append(points, point)
+	}
+
+	curves.Points = downsampleCurve(points, maxExportedCurvePoints)
+	return curves
+}
+
+// downsampleCurve keeps at most limit evenly spaced points, always including both ends
+func downsampleCurve(points []CurvePoint, limit int) []CurvePoint {
+	if len(points) <= limit || limit < 2 {
+		return points
+	}
+	out := make([]CurvePoint, 0, limit)
+	step := float64(len(points)-1) / float64(limit-1)
+	for i := 0; i < limit; i++ {
+		out = append(out, points[int(float64(i)*step+0.5)])
+	}
+	return out
+}
+
+// PairScoreCurves computes curves for the raw similarity scores of labelled pairs and,
+// if a calibration is given, for the calibrated probability
+func PairScoreCurves(pairs []ScoredPair, hammingScale float64, calibration *Calibration) []*Curves {
+	if hammingScale <= 0 {
+		hammingScale = 1
+	}
+	labels := make([]bool, len(pairs))
+	hamming := make([]float64, len(pairs))
+	jaccard := make([]float64, len(pairs))
+	var probability []float64
+	if calibration != nil {
+		probability = make([]float64, len(pairs))
+	}
+
+	for i, p := range pairs {
+		labels[i] = p.Match
+		hamming[i] = 1 - float64(p.Hamming)/hammingScale
+		jaccard[i] = p.Jaccard
+		if calibration != nil {
+			probability[i] = calibration.Probability(p.Hamming, p.Jaccard)
+		}
+	}
+
+	curves := []*Curves{
+		ComputeCurves("hamming_similarity", hamming, labels),
+		ComputeCurves("jaccard", jaccard, labels),
+	}
+	if calibration != nil {
+		curves = append(curves, ComputeCurves("probability", probability, labels))
+	}
+	return curves
+}
@@ -0,0 +1,116 @@
+// dedupe.go
+// Deduplication within a single dataset: the fuzzy matcher is run against the dataset itself
+// and records linked by matching pairs are grouped into clusters.
+package match
+
+import (
+	"sort"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+)
+
+// DuplicateCluster is a group of records in one dataset that appear to be the same entity
+type DuplicateCluster struct {
+	ID        int      `json:"cluster_id"`
+	RecordIDs []string `json:"record_ids"` // Sorted; the first is the representative kept when deduplicating
+}
+
+// Representative returns the record kept for the cluster
+func (c DuplicateCluster) Representative() string {
+	return c.RecordIDs[0]
+}
+
+// FindDuplicates self-joins records, ignoring each record's match with itself, and groups linked
+// records into clusters with union-find, so duplicates are found transitively. It returns the
+// clusters of two or more records, largest first, and the number of matching pairs.
+func (fm *FuzzyMatcher) FindDuplicates(records []*pprl.Record) ([]DuplicateCluster, int, error) {
+	// A record may have several duplicates, so pairs are not resolved 1:1
+	selfJoin := *fm.config
+	selfJoin.AllowDuplicates = true
+
+	result, err := NewFuzzyMatcher(&selfJoin).ComputePrivateIntersection(records, records)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	clusters := newUnionFind()
+	pairs := 0
+	for _, pair := range result.MatchPairs {
+		// Skip the diagonal, and the mirror image of every pair
+		if pair.LocalID >= pair.PeerID {
+			continue
+		}
+		clusters.union(pair.LocalID, pair.PeerID)
+		pairs++
+	}
+
+	return clusters.groups(), pairs, nil
+}
+
+// unionFind is a disjoint-set forest over record IDs with path compression and union by size
+type unionFind struct {
+	parent map[string]string
+	size   map[string]int
+}
+
+func newUnionFind() *unionFind {
+	return &unionFind{parent: make(map[string]string), size: make(map[string]int)}
+}
+
+func (uf *unionFind) find(id string) string {
+	if _, ok := uf.parent[id]; !ok {
+		uf.parent[id] = id
+		uf.size[id] = 1
+		return id
+	}
+	root := id
+	for uf.parent[root] != root {
+		root = uf.parent[root]
+	}
+	for id != root {
+		next := uf.parent[id]
+		uf.parent[id] = root
+		id = next
+	}
+	return root
+}
+
+func (uf *unionFind) union(a, b string) {
+	rootA, rootB := uf.find(a), uf.find(b)
+	if rootA == rootB {
+		return
+	}
+	if uf.size[rootA] < uf.size[rootB] {
+		rootA, rootB = rootB, rootA
+	}
+	uf.parent[rootB] = rootA
+	uf.size[rootA] += uf.size[rootB]
+}
+
+// groups returns the sets as clusters numbered from 1, largest first, then by representative
+func (uf *unionFind) groups() []DuplicateCluster {
+	members := make(map[string][]string)
+	for id := range uf.parent {
+		root := uf.find(id)
+		members[root] = append(members[root], id)
+	}
+
+	clusters := make([]DuplicateCluster, 0, len(members))
+	for _, ids := range members {
+		if len(ids) < 2 {
+			continue
+		}
+		sort.Strings(ids)
+		clusters = append(clusters, DuplicateCluster{RecordIDs: ids})
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if len(clusters[i].RecordIDs) != len(clusters[j].RecordIDs) {
+			return len(clusters[i].RecordIDs) > len(clusters[j].RecordIDs)
+		}
+		return clusters[i].Representative() < clusters[j].Representative()
+	})
+	for i := range clusters {
+		clusters[i].ID = i + 1
+	}
+	return clusters
+}
@@ -0,0 +1,94 @@
+// evaluate.go
+// Cluster-level evaluation of match results against ground truth. Pair counts alone misjudge
+// 1:many and many:many links: one missing pair can leave an entity split across two clusters,
+// and one extra pair can merge two entities.
+package match
+
+import "strings"
+
+// ClusterMetrics compares the clusters linked by predicted matches with those of the ground truth
+type ClusterMetrics struct {
+	TrueClusters      int     `json:"true_clusters"`      // Clusters linked by the ground truth
+	PredictedClusters int     `json:"predicted_clusters"` // Clusters linked by the matches
+	ExactClusters     int     `json:"exact_clusters"`     // Predicted clusters identical to a true cluster
+	SplitClusters     int     `json:"split_clusters"`     // True clusters not contained in one predicted cluster
+	MergedClusters    int     `json:"merged_clusters"`    // Predicted clusters not contained in one true cluster
+	Precision         float64 `json:"precision"`          // ExactClusters / PredictedClusters
+	Recall            float64 `json:"recall"`             // ExactClusters / TrueClusters
+	F1                float64 `json:"f1"`
+}
+
+// EvaluateClusters groups the predicted and the true match pairs into clusters, as BuildCrosswalk
+// does, and counts the clusters reproduced exactly, split apart or merged together
+func EvaluateClusters(predicted, truth []*PrivateMatchResult) ClusterMetrics {
+	predictedClusters := BuildCrosswalk(predicted, nil)
+	trueClusters := BuildCrosswalk(truth, nil)
+	m := ClusterMetrics{TrueClusters: len(trueClusters), PredictedClusters: len(predictedClusters)}
+
+	// Index every record of each side by its cluster; party sides are kept apart
+	index := func(clusters []LinkageCluster) map[string]int {
+		of := make(map[string]int)
+		for i, cluster := range clusters {
+			for _, id := range cluster.LocalIDs {
+				of["l\x00"+id] = i
+			}
+			for _, id := range cluster.PeerIDs {
+				of["p\x00"+id] = i
+			}
+		}
+		return of
+	}
+	predictedOf, trueOf := index(predictedClusters), index(trueClusters)
+
+	// within reports whether every record of cluster is in a single cluster of other
+	within := func(cluster LinkageCluster, other map[string]int) bool {
+		at := -1
+		for _, node := range clusterNodes(cluster) {
+			i, ok := other[node]
+			if !ok || (at >= 0 && i != at) {
+				return false
+			}
+			at = i
+		}
+		return true
+	}
+
+	trueKeys := make(map[string]bool, len(trueClusters))
+	for _, cluster := range trueClusters {
+		trueKeys[strings.Join(clusterNodes(cluster), "\x01")] = true
+		if !within(cluster, predictedOf) {
+			m.SplitClusters++
+		}
+	}
+	for _, cluster := range predictedClusters {
+		if trueKeys[strings.Join(clusterNodes(cluster), "\x01")] {
+			m.ExactClusters++
+		}
+		if !within(cluster, trueOf) {
+			m.MergedClusters++
+		}
+	}
+
+	if m.PredictedClusters > 0 {
+		m.Precision = float64(m.ExactClusters) / float64(m.PredictedClusters)
+	}
+	if m.TrueClusters > 0 {
+		m.Recall = float64(m.ExactClusters) / float64(m.TrueClusters)
+	}
+	if m.Precision+m.Recall > 0 {
+		m.F1 = 2 * m.Precision * m.Recall / (m.Precision + m.Recall)
+	}
+	return m
+}
+
+// clusterNodes lists the records of a cluster with their party, in sorted order
+func clusterNodes(cluster LinkageCluster) []string {
+	nodes := make([]string, 0, len(cluster.LocalIDs)+len(cluster.PeerIDs))
+	for _, id := range cluster.LocalIDs {
+		nodes = append(nodes, "l\x00"+id)
+	}
+	for _, id := range cluster.PeerIDs {
+		nodes = append(nodes, "p\x00"+id)
+	}
+	return nodes
+}
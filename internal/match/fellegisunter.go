@@ -0,0 +1,333 @@
+// fellegisunter.go
+// Package match provides a Fellegi–Sunter probabilistic linkage model trained without ground truth.
+// Each comparison is reduced to agreement levels (bins) of its Bloom filter distance and MinHash
+// similarity; expectation maximization estimates how often matches (m) and non-matches (u) fall
+// in each bin, and a pair's match weight is the sum of log2(m/u) over its bins.
+package match
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"time"
+)
+
+// CalibrationMethodFellegiSunter is the EM-trained Fellegi–Sunter model
+const CalibrationMethodFellegiSunter = "fellegi-sunter"
+
+// Comparison features of the Fellegi–Sunter model. Token files hold one composite filter per
+// record, so the features are the two scores of that filter rather than individual fields.
+const (
+	FeatureHamming = "hamming" // Hamming distance / Bloom filter size
+	FeatureJaccard = "jaccard" // MinHash Jaccard similarity
+)
+
+// agreementTails are the shares of compared pairs agreeing at least as closely as each bin edge.
+// Nearly every compared pair is a non-match, so the bins follow how rare a score is among
+// non-matches whatever the filter size and fill, with the finest bins where matches lie.
+var agreementTails = []float64{0.0005, 0.001, 0.002, 0.005, 0.01, 0.02, 0.05, 0.1, 0.25, 0.5}
+
+// FellegiSunterFeature holds the bins of one comparison feature and their m and u probabilities
+type FellegiSunterFeature struct {
+	Name  string    `json:"name"`
+	Edges []float64 `json:"edges"` // Upper bin edges; len(M) == len(Edges)+1
+	M     []float64 `json:"m"`     // P(bin | match)
+	U     []float64 `json:"u"`     // P(bin | non-match)
+}
+
+// FellegiSunterModel is a trained Fellegi–Sunter model with its suggested operating point
+type FellegiSunterModel struct {
+	Features   []FellegiSunterFeature `json:"features"`
+	MatchRate  float64                `json:"match_rate"` // Estimated share of compared pairs that match
+	Iterations int                    `json:"iterations"`
+	Converged  bool                   `json:"converged"`
+
+	// The threshold maximizing the F1 score the model expects, with its estimated precision and recall
+	SuggestedWeight      float64 `json:"suggested_weight"`
+	SuggestedProbability float64 `json:"suggested_probability"`
+	EstimatedPrecision   float64 `json:"estimated_precision"`
+	EstimatedRecall      float64 `json:"estimated_recall"`
+}
+
+// bin returns the bin of a feature value
+func (f *FellegiSunterFeature) bin(value float64) int {
+	return sort.Search(len(f.Edges), func(i int) bool { return value < f.Edges[i] })
+}
+
+// values returns the feature values of a pair's scores, in feature order
+func (m *FellegiSunterModel) values(hamming uint32, jaccard, hammingScale float64) []float64 {
+	values := make([]float64, len(m.Features))
+	for i, feature := range m.Features {
+		switch feature.Name {
+		case FeatureHamming:
+			values[i] = float64(hamming) / hammingScale
+		case FeatureJaccard:
+			values[i] = jaccard
+		}
+	}
+	return values
+}
+
+// Weight returns the match weight of a pair: the sum over features of log2(m/u) for its bins
+func (m *FellegiSunterModel) Weight(hamming uint32, jaccard, hammingScale float64) float64 {
+	weight := 0.0
+	for i, value := range m.values(hamming, jaccard, hammingScale) {
+		b := m.Features[i].bin(value)
+		weight += math.Log2(m.Features[i].M[b] / m.Features[i].U[b])
+	}
+	return weight
+}
+
+// probability converts a match weight to the posterior match probability under the match rate
+func (m *FellegiSunterModel) probability(weight float64) float64 {
+	return sigmoid(math.Log(m.MatchRate/(1-m.MatchRate)) + weight*math.Ln2)
+}
+
+// TrainFellegiSunter estimates m and u probabilities from unlabelled pairs by expectation
+// maximization. Labels are used only to report the Brier score of the result.
+//
+// maxMatches bounds the number of matches among the pairs, such as the size of the smaller
+// dataset when each record matches at most once (0 for no bound). The two scores of a composite
+// filter are correlated among non-matches too, and without the bound EM tends to split off the
+// closest non-matches as a second class instead of finding the matches.
+func TrainFellegiSunter(pairs []ScoredPair, hammingScale float64, maxMatches int) (*Calibration, error) {
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("Fellegi-Sunter training needs scored pairs")
+	}
+	if hammingScale <= 0 {
+		hammingScale = 1
+	}
+
+	hammings := make([]float64, len(pairs))
+	jaccards := make([]float64, len(pairs))
+	for i, p := range pairs {
+		hammings[i] = float64(p.Hamming) / hammingScale
+		jaccards[i] = p.Jaccard
+	}
+	model := &FellegiSunterModel{Features: []FellegiSunterFeature{
+		{Name: FeatureHamming, Edges: agreementEdges(hammings, false)},
+		{Name: FeatureJaccard, Edges: agreementEdges(jaccards, true)},
+	}}
+
+	// Pairs with the same bins are indistinguishable to the model, so EM runs over bin patterns
+	type pattern struct {
+		bins  []int
+		count float64
+		g     float64 // Posterior match probability
+	}
+	index := make(map[string]*pattern)
+	var patterns []*pattern
+	for _, p := range pairs {
+		values := model.values(p.Hamming, p.Jaccard, hammingScale)
+		bins := make([]int, len(values))
+		for i, value := range values {
+			bins[i] = model.Features[i].bin(value)
+		}
+		key := fmt.Sprint(bins)
+		if pat, ok := index[key]; ok {
+			pat.count++
+			continue
+		}
+		pat := &pattern{bins: bins, count: 1}
+		index[key] = pat
+		patterns = append(patterns, pat)
+	}
+	total := float64(len(pairs))
+	maxRate := 1 - 1e-9
+	if maxMatches > 0 && float64(maxMatches) < total {
+		maxRate = float64(maxMatches) / total
+	}
+
+	// Start with u at the observed bin frequencies (nearly all pairs are non-matches) and m
+	// concentrated on the bins of close agreement
+	for i := range model.Features {
+		feature := &model.Features[i]
+		n := len(feature.Edges) + 1
+		feature.M = make([]float64, n)
+		feature.U = make([]float64, n)
+		for _, pat := range patterns {
+			feature.U[pat.bins[i]] += pat.count / total
+		}
+		for b := 0; b < n; b++ {
+			agreement := float64(b) / float64(n-1) // Jaccard bins rise with agreement
+			if !feature.higherAgrees() {
+				agreement = 1 - agreement
+			}
+			feature.M[b] = math.Pow(8, agreement)
+		}
+		normalize(feature.M)
+		smooth(feature.U)
+	}
+	model.MatchRate = math.Min(0.01, maxRate/2)
+
+	const maxIterations = 500
+	previous := math.Inf(-1)
+	for model.Iterations = 1; model.Iterations <= maxIterations; model.Iterations++ {
+		// E-step: posterior match probability of each pattern, and the log-likelihood
+		logLikelihood := 0.0
+		for _, pat := range patterns {
+			pm, pu := model.MatchRate, 1-model.MatchRate
+			for i, b := range pat.bins {
+				pm *= model.Features[i].M[b]
+				pu *= model.Features[i].U[b]
+			}
+			pat.g = pm / (pm + pu)
+			logLikelihood += pat.count * math.Log(pm+pu)
+		}
+
+		// M-step: re-estimate m, u and the match rate from the posteriors
+		matches := 0.0
+		for _, pat := range patterns {
+			matches += pat.count * pat.g
+		}
+		for i := range model.Features {
+			feature := &model.Features[i]
+			for b := range feature.M {
+				feature.M[b], feature.U[b] = 0, 0
+			}
+			for _, pat := range patterns {
+				feature.M[pat.bins[i]] += pat.count * pat.g
+				feature.U[pat.bins[i]] += pat.count * (1 - pat.g)
+			}
+			normalize(feature.M)
+			normalize(feature.U)
+			smooth(feature.M)
+			smooth(feature.U)
+		}
+		model.MatchRate = math.Min(math.Max(matches/total, 1e-9), maxRate)
+
+		if math.Abs(logLikelihood-previous) < 1e-8*math.Abs(logLikelihood) {
+			model.Converged = true
+			break
+		}
+		previous = logLikelihood
+	}
+	if model.Iterations > maxIterations {
+		model.Iterations = maxIterations
+	}
+
+	// EM can settle with the classes swapped; the match class is the one with the closer scores
+	if model.Features[1].mean(model.Features[1].M) < model.Features[1].mean(model.Features[1].U) {
+		for i := range model.Features {
+			model.Features[i].M, model.Features[i].U = model.Features[i].U, model.Features[i].M
+		}
+		model.MatchRate = 1 - model.MatchRate
+	}
+
+	// Suggest the weight threshold with the best F1 the model itself expects: walk the patterns
+	// from the highest weight down, counting their expected matches and non-matches
+	type scored struct {
+		weight, count, g float64
+	}
+	var ranked []scored
+	expectedMatches := 0.0
+	for _, pat := range patterns {
+		weight := 0.0
+		pm, pu := model.MatchRate, 1-model.MatchRate
+		for i, b := range pat.bins {
+			weight += math.Log2(model.Features[i].M[b] / model.Features[i].U[b])
+			pm *= model.Features[i].M[b]
+			pu *= model.Features[i].U[b]
+		}
+		g := pm / (pm + pu)
+		ranked = append(ranked, scored{weight: weight, count: pat.count, g: g})
+		expectedMatches += pat.count * g
+	}
+	sort.Slice(ranked, func(a, b int) bool { return ranked[a].weight > ranked[b].weight })
+	truePositives, selected, bestF1 := 0.0, 0.0, -1.0
+	for i, r := range ranked {
+		truePositives += r.count * r.g
+		selected += r.count
+		if i+1 < len(ranked) && ranked[i+1].weight == r.weight {
+			continue
+		}
+		precision, recall := truePositives/selected, truePositives/expectedMatches
+		if f1 := 2 * precision * recall / (precision + recall); f1 > bestF1 {
+			bestF1 = f1
+			model.SuggestedWeight = r.weight
+			model.EstimatedPrecision, model.EstimatedRecall = precision, recall
+		}
+	}
+	model.SuggestedProbability = model.probability(model.SuggestedWeight)
+
+	cal := &Calibration{
+		Method:        CalibrationMethodFellegiSunter,
+		HammingScale:  hammingScale,
+		Samples:       len(pairs),
+		Positives:     int(math.Round(expectedMatches)),
+		TrainedAt:     time.Now().UTC(),
+		FellegiSunter: model,
+	}
+	for _, p := range pairs {
+		label := 0.0
+		if p.Match {
+			label = 1
+		}
+		diff := cal.Probability(p.Hamming, p.Jaccard) - label
+		cal.Brier += diff * diff
+	}
+	cal.Brier /= float64(len(pairs))
+	return cal, nil
+}
+
+// higherAgrees reports whether higher values of the feature mean closer agreement
+func (f *FellegiSunterFeature) higherAgrees() bool {
+	return f.Name != FeatureHamming
+}
+
+// agreementEdges returns the bin edges at the agreementTails quantiles of values, dropping edges
+// that coincide; higherAgrees says which end of the values is the agreeing tail
+func agreementEdges(values []float64, higherAgrees bool) []float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	var edges []float64
+	for _, tail := range agreementTails {
+		q := tail
+		if higherAgrees {
+			q = 1 - tail
+		}
+		i := int(q * float64(len(sorted)))
+		if i >= len(sorted) {
+			i = len(sorted) - 1
+		}
+		edge := sorted[i]
+		if !higherAgrees {
+			edge = math.Nextafter(edge, math.Inf(1)) // Distances equal to the quantile agree as closely
+		}
+		edges = append(edges, edge)
+	}
+	sort.Float64s(edges)
+	return slices.Compact(edges)
+}
+
+// mean returns the mean bin index under a bin distribution
+func (f *FellegiSunterFeature) mean(distribution []float64) float64 {
+	mean := 0.0
+	for b, p := range distribution {
+		mean += float64(b) * p
+	}
+	return mean
+}
+
+// normalize scales values to sum to 1
+func normalize(values []float64) {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	if sum == 0 {
+		return
+	}
+	for i := range values {
+		values[i] /= sum
+	}
+}
+
+// smooth keeps every bin probability above zero, so no bin has an infinite weight
+func smooth(values []float64) {
+	const floor = 1e-6
+	for i := range values {
+		values[i] = (values[i] + floor) / (1 + floor*float64(len(values)))
+	}
+}
@@ -6,6 +6,7 @@ package match
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/auroradata-ai/cohort-bridge/internal/crypto"
 	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
@@ -15,8 +16,34 @@ import (
 type FuzzyMatchConfig struct {
 	Party            int     // Which party in the secure protocol (0 or 1)
 	AllowDuplicates  bool    // Allow 1:many matching (false = 1:1 matching only, default)
+	OneToMany        bool    // With AllowDuplicates, each peer record keeps only its best match (1:many rather than many:many)
 	HammingThreshold uint32  // Hamming distance threshold for bloom filter matching
 	JaccardThreshold float64 // Jaccard similarity threshold for MinHash matching
+	Assignment       string  // 1:1 assignment algorithm: "greedy" (default) or "hungarian"
+
+	// CandidateThreshold is the MinHash Jaccard estimate a pair needs before its Bloom filters are compared.
+	// 0 uses JaccardThreshold (lossless, since such pairs cannot match) unless a calibration decides
+	// matches; a negative value compares every pair.
+	CandidateThreshold float64
+
+	Calibration          *Calibration // Optional model adding calibrated probabilities to matches
+	ProbabilityThreshold float64      // If > 0 (with Calibration), replaces the distance thresholds
+
+	Blocking bool // Compare only records sharing a blocking key (tokenization.blocking)
+
+	// DistanceScale is the factor Bloom filter hardening (tokenization.hardening) multiplies Hamming
+	// distances by; distances are divided by it before thresholds apply (0 or 1: no hardening)
+	DistanceScale uint32
+
+	Retention crypto.Retention // Bounds on the matches kept (matching.max_matches_per_record, matching.min_score)
+
+	// Histogram, if set, counts the scores of every pair compared. The MinHash pre-filter is then
+	// off unless CandidateThreshold sets one, so pairs below the Jaccard threshold are counted too.
+	Histogram *ScoreHistogram
+
+	// Comparator, if set to other than the Bloom comparator, scores each pair of in-memory records
+	// (matching.comparator). Binary token stores and streaming intersections need the default.
+	Comparator Comparator
 }
 
 // FuzzyMatcher handles zero-knowledge secure fuzzy matching between records
@@ -27,9 +54,41 @@ type FuzzyMatcher struct {
 
 // NewFuzzyMatcher creates a new zero-knowledge fuzzy matcher instance
 func NewFuzzyMatcher(config *FuzzyMatchConfig) *FuzzyMatcher {
+	protocol := crypto.NewSecureIntersectionProtocolWithThresholds(config.Party, config.AllowDuplicates, config.HammingThreshold, config.JaccardThreshold)
+	protocol.Assignment = config.Assignment
+	protocol.OneToMany = config.OneToMany
+	protocol.PSI.Blocking = config.Blocking
+	protocol.PSI.DistanceScale = config.DistanceScale
+	protocol.Retention = config.Retention
+	if config.Calibration != nil && config.ProbabilityThreshold > 0 {
+		calibration, threshold := config.Calibration, config.ProbabilityThreshold
+		protocol.PSI.Classifier = func(hamming uint32, jaccard float64) bool {
+			return calibration.Probability(hamming, jaccard) >= threshold
+		}
+	}
+
+	if config.Histogram != nil {
+		protocol.PSI.Observe = config.Histogram.Add
+	}
+
+	if !isBloomComparator(config.Comparator) {
+		comparator := config.Comparator
+		protocol.PSI.Compare = func(local, peer *pprl.Record) (uint32, float64, error) {
+			score, err := comparator.Compare(local, peer)
+			return score.Distance, score.Similarity, err
+		}
+	}
+
+	switch {
+	case config.CandidateThreshold > 0:
+		protocol.PSI.CandidateThreshold = config.CandidateThreshold
+	case config.CandidateThreshold == 0 && protocol.PSI.Classifier == nil && config.Histogram == nil && protocol.PSI.Compare == nil:
+		protocol.PSI.CandidateThreshold = config.JaccardThreshold
+	}
+
 	return &FuzzyMatcher{
 		config:               config,
-		intersectionProtocol: crypto.NewSecureIntersectionProtocolWithThresholds(config.Party, config.AllowDuplicates, config.HammingThreshold, config.JaccardThreshold),
+		intersectionProtocol: protocol,
 	}
 }
 
@@ -39,6 +98,10 @@ type PrivateMatchResult struct {
 	PeerID  string `json:"peer_id"`  // Only for peer party identification
 	// NO similarity scores, distances, match scores, or any other metadata
 	// NO protocol information, statistics, or computational details
+
+	// Probability is the calibrated match probability, set only when a calibration model is configured.
+	// Both parties derive the same value from the pair, so it reveals nothing the match itself does not.
+	Probability float64 `json:"probability,omitempty"`
 }
 
 // CompareRecords performs zero-knowledge matching between two records
@@ -70,6 +133,79 @@ func (fm *FuzzyMatcher) ComputePrivateIntersection(localRecords, peerRecords []*
 	return fm.intersectionProtocol.ComputeSecureIntersection(localRecords, peerRecords)
 }
 
+// ComputeStoreIntersection performs the zero-knowledge intersection over two memory-mapped
+// binary token stores, for datasets too large to hold as decoded records
+func (fm *FuzzyMatcher) ComputeStoreIntersection(local, peer *pprl.BloomStore) (*crypto.PrivateIntersectionResult, error) {
+	return fm.intersectionProtocol.ComputeStoreIntersection(local, peer)
+}
+
+// ComputeResumableIntersection performs the zero-knowledge intersection from a checkpoint,
+// reporting each completed block of local records to progress.OnBlock
+func (fm *FuzzyMatcher) ComputeResumableIntersection(localRecords, peerRecords []*pprl.Record, progress *crypto.IntersectionProgress) (*crypto.PrivateIntersectionResult, error) {
+	return fm.intersectionProtocol.ComputeResumableIntersection(localRecords, peerRecords, progress)
+}
+
+// ComputeResumableStoreIntersection is ComputeResumableIntersection over binary token stores
+func (fm *FuzzyMatcher) ComputeResumableStoreIntersection(local, peer *pprl.BloomStore, progress *crypto.IntersectionProgress) (*crypto.PrivateIntersectionResult, error) {
+	return fm.intersectionProtocol.ComputeResumableStoreIntersection(local, peer, progress)
+}
+
+// ComputeSMCIntersection matches the local records against the peer's by secure comparison,
+// sending each round of the protocol through exchange; only the Hamming threshold applies
+func (fm *FuzzyMatcher) ComputeSMCIntersection(exchange crypto.SMCExchange, localRecords []*pprl.Record) (*crypto.PrivateIntersectionResult, error) {
+	return fm.intersectionProtocol.ComputeSMCIntersection(exchange, localRecords)
+}
+
+// ComputeExactIntersection links the local records to the peer's records sharing their exact
+// identifier by private set intersection, sending each round through exchange
+func (fm *FuzzyMatcher) ComputeExactIntersection(exchange crypto.SMCExchange, localRecords []*pprl.Record) (*crypto.PrivateIntersectionResult, error) {
+	return fm.intersectionProtocol.ComputeExactIntersection(exchange, localRecords)
+}
+
+// NewStreamIndex indexes one dataset for a streaming intersection, in which the other dataset is
+// matched a record at a time as it is read
+func (fm *FuzzyMatcher) NewStreamIndex(records []*pprl.Record, local bool, bandSize int) (*crypto.StreamIndex, error) {
+	return fm.intersectionProtocol.NewStreamIndex(records, local, bandSize)
+}
+
+// NewStoreStreamIndex indexes a binary token store for streaming intersections, comparing its
+// records in the mapped file
+func (fm *FuzzyMatcher) NewStoreStreamIndex(store *pprl.BloomStore, local bool, bandSize int) (*crypto.StreamIndex, error) {
+	return fm.intersectionProtocol.NewStoreStreamIndex(store, local, bandSize)
+}
+
+// OpenStoreStreamIndex reopens a stream index of a token store from its saved LSH buckets
+func (fm *FuzzyMatcher) OpenStoreStreamIndex(store *pprl.BloomStore, local bool, buckets io.Reader) (*crypto.StreamIndex, error) {
+	return fm.intersectionProtocol.OpenStoreStreamIndex(store, local, buckets)
+}
+
+// MatchResults converts intersection pairs to match results, adding calibrated probabilities if configured
+func (fm *FuzzyMatcher) MatchResults(result *crypto.PrivateIntersectionResult) []*PrivateMatchResult {
+	var matches []*PrivateMatchResult
+	for _, pair := range result.MatchPairs {
+		matches = append(matches, fm.matchResult(pair))
+	}
+	return matches
+}
+
+// matchResult converts one intersection pair to a match result
+func (fm *FuzzyMatcher) matchResult(pair crypto.PrivateMatchPair) *PrivateMatchResult {
+	matchResult := &PrivateMatchResult{
+		LocalID: pair.LocalID,
+		PeerID:  pair.PeerID,
+	}
+	if fm.config.Calibration != nil {
+		matchResult.Probability = fm.config.Calibration.Probability(pair.Scores())
+	}
+	return matchResult
+}
+
+// ComparePair scores one local record against one peer record under the configured thresholds,
+// as the intersection does, and reports whether they match
+func (fm *FuzzyMatcher) ComparePair(local, peer *pprl.Record) (crypto.PrivateMatchPair, bool) {
+	return fm.intersectionProtocol.PSI.ComparePair(local, peer)
+}
+
 // BatchPrivateCompare performs zero-knowledge matching on a batch of candidate pairs
 // Returns ONLY matches - no information about non-matches or processing details
 func (fm *FuzzyMatcher) BatchPrivateCompare(pairs []CandidatePair, records map[string]*pprl.Record) ([]*PrivateMatchResult, error) {
@@ -0,0 +1,46 @@
+package match
+
+import (
+	"os"
+	"testing"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
+)
+
+// BenchmarkCompareRecords measures the private comparison of two tokenized records, a true
+// match differing by a typo, tokenized with the default recipe
+func BenchmarkCompareRecords(b *testing.B) {
+	config := &pprl.RecordConfig{BloomSize: 1000, BloomHashes: 5, MinHashSize: 128, QGramLength: 2, Salt: "cohort-bridge-perf"}
+	record1, err := pprl.CreateRecord("a1", []string{"jonathan", "smithson", "1984-03-17", "m", "02139"}, config)
+	if err != nil {
+		b.Fatal(err)
+	}
+	record2, err := pprl.CreateRecord("b1", []string{"johnathan", "smithson", "1984-03-17", "m", "02139"}, config)
+	if err != nil {
+		b.Fatal(err)
+	}
+	matcher := NewFuzzyMatcher(&FuzzyMatchConfig{HammingThreshold: 100, JaccardThreshold: 0.5})
+	quietStdout(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := matcher.CompareRecords(record1, record2); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// quietStdout discards standard output until the benchmark run ends, since the comparison
+// protocol logs each pair it compares and would break up the benchmark's result line
+func quietStdout(b *testing.B) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	b.Cleanup(func() {
+		os.Stdout = stdout
+		devNull.Close()
+	})
+}
@@ -0,0 +1,116 @@
+// histogram.go
+// Score histograms count the Hamming distances and Jaccard similarities of every comparison in
+// fixed-width bins. They hold counts only, never record IDs, so the two parties can exchange them
+// and agree on thresholds by the valley between the match and non-match modes.
+package match
+
+import (
+	"fmt"
+	"math"
+)
+
+// Default bin widths of a score histogram
+const (
+	DefaultHammingBinWidth = 10
+	DefaultJaccardBinWidth = 0.02
+)
+
+// HistogramBin counts the comparisons scoring in [Low, High); the last Jaccard bin includes 1
+type HistogramBin struct {
+	Low     float64 `json:"low"`
+	High    float64 `json:"high"`
+	Count   int     `json:"count"`
+	Matches *int    `json:"matches,omitempty"` // Ground truth matches, when the comparisons are labelled
+}
+
+// ScoreHistogram holds the binned scores of a set of comparisons
+type ScoreHistogram struct {
+	HammingBinWidth uint32  `json:"hamming_bin_width"`
+	JaccardBinWidth float64 `json:"jaccard_bin_width"`
+	Comparisons     int     `json:"comparisons"`
+	Labelled        bool    `json:"labelled"` // Whether match counts were recorded
+
+	hamming, hammingMatches []int // Grown as larger distances arrive
+	jaccard, jaccardMatches []int
+}
+
+// NewScoreHistogram creates an empty histogram; zero widths take the defaults
+func NewScoreHistogram(hammingBinWidth uint32, jaccardBinWidth float64) (*ScoreHistogram, error) {
+	if hammingBinWidth == 0 {
+		hammingBinWidth = DefaultHammingBinWidth
+	}
+	if jaccardBinWidth == 0 {
+		jaccardBinWidth = DefaultJaccardBinWidth
+	}
+	if jaccardBinWidth < 0 || jaccardBinWidth > 1 {
+		return nil, fmt.Errorf("jaccard bin width must be between 0 and 1, got %g", jaccardBinWidth)
+	}
+	bins := int(math.Ceil(1/jaccardBinWidth - 1e-9))
+	return &ScoreHistogram{
+		HammingBinWidth: hammingBinWidth,
+		JaccardBinWidth: jaccardBinWidth,
+		jaccard:         make([]int, bins),
+		jaccardMatches:  make([]int, bins),
+	}, nil
+}
+
+// Add counts an unlabelled comparison
+func (h *ScoreHistogram) Add(hamming uint32, jaccard float64) {
+	h.add(hamming, jaccard, false)
+}
+
+// AddLabelled counts a comparison along with whether it is a ground truth match
+func (h *ScoreHistogram) AddLabelled(hamming uint32, jaccard float64, isMatch bool) {
+	h.Labelled = true
+	h.add(hamming, jaccard, isMatch)
+}
+
+func (h *ScoreHistogram) add(hamming uint32, jaccard float64, isMatch bool) {
+	h.Comparisons++
+
+	bin := int(hamming / h.HammingBinWidth)
+	for len(h.hamming) <= bin {
+		h.hamming = append(h.hamming, 0)
+		h.hammingMatches = append(h.hammingMatches, 0)
+	}
+	h.hamming[bin]++
+
+	j := int(jaccard / h.JaccardBinWidth)
+	j = min(max(j, 0), len(h.jaccard)-1)
+	h.jaccard[j]++
+
+	if isMatch {
+		h.hammingMatches[bin]++
+		h.jaccardMatches[j]++
+	}
+}
+
+// HammingBins returns the Hamming distance bins, from 0 up to the largest distance counted
+func (h *ScoreHistogram) HammingBins() []HistogramBin {
+	width := float64(h.HammingBinWidth)
+	return h.bins(h.hamming, h.hammingMatches, func(i int) (float64, float64) {
+		return float64(i) * width, float64(i+1) * width
+	})
+}
+
+// JaccardBins returns the Jaccard similarity bins, from 0 to 1
+func (h *ScoreHistogram) JaccardBins() []HistogramBin {
+	return h.bins(h.jaccard, h.jaccardMatches, func(i int) (float64, float64) {
+		// Rounded so bounds such as 0.06 are not written as 0.06000000000000001
+		low := math.Round(float64(i)*h.JaccardBinWidth*1e9) / 1e9
+		high := math.Round(float64(i+1)*h.JaccardBinWidth*1e9) / 1e9
+		return low, math.Min(high, 1)
+	})
+}
+
+func (h *ScoreHistogram) bins(counts, matches []int, bounds func(int) (float64, float64)) []HistogramBin {
+	bins := make([]HistogramBin, len(counts))
+	for i, count := range counts {
+		low, high := bounds(i)
+		bins[i] = HistogramBin{Low: low, High: high, Count: count}
+		if h.Labelled {
+			bins[i].Matches = &matches[i]
+		}
+	}
+	return bins
+}
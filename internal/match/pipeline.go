@@ -1,16 +1,19 @@
 // pipeline.go
 // Package match provides the main pipeline orchestrator for the secure fuzzy matching system.
-// It coordinates blocking, candidate generation, and fuzzy matching phases.
+// It streams records through blocking, comparison, assignment and output stages.
 package match
 
 import (
+	"bufio"
+	"context"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
-	"os"
 	"time"
 
+	"github.com/auroradata-ai/cohort-bridge/internal/crypto"
 	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
 )
 
@@ -19,18 +22,33 @@ import (
 type PipelineConfig struct {
 	BlockingConfig   *BlockingConfig   `json:"blocking_config"`
 	FuzzyMatchConfig *FuzzyMatchConfig `json:"fuzzy_match_config"`
-	OutputPath       string            `json:"output_path"`
 	EnableStats      bool              `json:"enable_stats"`   // Limited stats only
 	MaxCandidates    int               `json:"max_candidates"` // Limit on candidate pairs
 }
 
-// Pipeline orchestrates the complete zero-knowledge matching process
+// Pipeline matches a stream of local records against the records loaded into it, in stages
+// connected by bounded channels:
+//
+//	reader → blocker → comparator → assigner → writer
+//
+// The blocker pairs each local record with the loaded records sharing a MinHash band (or a
+// blocking key, with FuzzyMatchConfig.Blocking), the comparator scores the pairs under the
+// matching thresholds and the assigner reduces matches to 1:1 unless duplicates are allowed.
+// Options set the buffer sizes and comparator workers, insert custom stages and choose the writer.
 type Pipeline struct {
 	config  *PipelineConfig
 	blocker *SecureBlocker
 	matcher *FuzzyMatcher
 	stats   *PipelineStats
 	records map[string]*pprl.Record
+	indexed []*pprl.Record // Loaded records, in load order
+
+	bufferSize      int
+	comparators     int
+	bandSize        int
+	writer          ResultWriter
+	candidateStages []namedStage[Candidate]
+	matchStages     []namedStage[*PrivateMatchResult]
 }
 
 // PipelineStats tracks LIMITED statistics with no information leakage
@@ -38,28 +56,126 @@ type PipelineStats struct {
 	StartTime        time.Time            `json:"start_time"`
 	EndTime          time.Time            `json:"end_time"`
 	TotalRecords     int                  `json:"total_records"`
-	BlockingStats    BlockingStats        `json:"blocking_stats"`
+	StreamedRecords  int                  `json:"streamed_records"`
 	MatchingStats    PrivateMatchingStats `json:"matching_stats"`
 	CandidatePairs   int                  `json:"candidate_pairs"`
 	ProcessingTimeMs int64                `json:"processing_time_ms"`
+	Stages           []StageMetrics       `json:"stages,omitempty"`
+}
+
+// DefaultPipelineBufferSize is the number of items each stage buffers for the next
+const DefaultPipelineBufferSize = 256
+
+// Candidate is a pair of records the blocker passes to the comparator
+type Candidate struct {
+	Local *pprl.Record
+	Peer  *pprl.Record
+}
+
+// RecordSource supplies the local records of a pipeline run; Next returns io.EOF after the last one
+type RecordSource interface {
+	Next() (*pprl.Record, error)
+}
+
+// ResultWriter receives the matches of a pipeline run as they leave the last stage
+type ResultWriter interface {
+	WriteMatch(match *PrivateMatchResult) error
+	Flush() error
+}
+
+// namedStage is a custom stage and the name its metrics are reported under
+type namedStage[T any] struct {
+	name string
+	run  Stage[T]
+}
+
+// PipelineOption configures a pipeline
+type PipelineOption func(*Pipeline)
+
+// WithBufferSize sets the number of items each stage buffers for the next (default
+// DefaultPipelineBufferSize). Smaller buffers hold less in memory; a full buffer stalls the
+// stage feeding it.
+func WithBufferSize(n int) PipelineOption {
+	return func(p *Pipeline) {
+		if n > 0 {
+			p.bufferSize = n
+		}
+	}
+}
+
+// WithComparators sets the number of goroutines scoring candidate pairs (default 1)
+func WithComparators(n int) PipelineOption {
+	return func(p *Pipeline) {
+		if n > 0 {
+			p.comparators = n
+		}
+	}
+}
+
+// WithBandSize sets the number of MinHash values per blocking band (default
+// crypto.DefaultStreamBandSize); fewer values per band find more candidates
+func WithBandSize(n int) PipelineOption {
+	return func(p *Pipeline) {
+		if n > 0 {
+			p.bandSize = n
+		}
+	}
+}
+
+// WithWriter sends matches to w as they are found instead of collecting them for Run to return
+func WithWriter(w ResultWriter) PipelineOption {
+	return func(p *Pipeline) {
+		p.writer = w
+	}
+}
+
+// WithCandidateStage inserts a stage between the blocker and the comparator, for example to
+// drop candidate pairs or log them. Stages run in the order they are added.
+func WithCandidateStage(name string, stage Stage[Candidate]) PipelineOption {
+	return func(p *Pipeline) {
+		p.candidateStages = append(p.candidateStages, namedStage[Candidate]{name: name, run: stage})
+	}
+}
+
+// WithMatchStage inserts a stage between the assigner and the writer, for example to enrich or
+// filter matches. Stages run in the order they are added.
+func WithMatchStage(name string, stage Stage[*PrivateMatchResult]) PipelineOption {
+	return func(p *Pipeline) {
+		p.matchStages = append(p.matchStages, namedStage[*PrivateMatchResult]{name: name, run: stage})
+	}
 }
 
 // NewPipeline creates a new zero-knowledge matching pipeline instance
-func NewPipeline(config *PipelineConfig) (*Pipeline, error) {
-	blocker, err := NewSecureBlocker(config.BlockingConfig)
+func NewPipeline(config *PipelineConfig, options ...PipelineOption) (*Pipeline, error) {
+	blockingConfig := config.BlockingConfig
+	if blockingConfig == nil {
+		blockingConfig = &BlockingConfig{}
+	}
+	blocker, err := NewSecureBlocker(blockingConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create secure blocker: %w", err)
 	}
 
-	matcher := NewFuzzyMatcher(config.FuzzyMatchConfig)
-
-	return &Pipeline{
-		config:  config,
-		blocker: blocker,
-		matcher: matcher,
-		stats:   &PipelineStats{},
-		records: make(map[string]*pprl.Record),
-	}, nil
+	fuzzyConfig := config.FuzzyMatchConfig
+	if fuzzyConfig == nil {
+		fuzzyConfig = &FuzzyMatchConfig{}
+	}
+	matcher := NewFuzzyMatcher(fuzzyConfig)
+
+	p := &Pipeline{
+		config:      config,
+		blocker:     blocker,
+		matcher:     matcher,
+		stats:       &PipelineStats{},
+		records:     make(map[string]*pprl.Record),
+		bufferSize:  DefaultPipelineBufferSize,
+		comparators: 1,
+		bandSize:    crypto.DefaultStreamBandSize,
+	}
+	for _, option := range options {
+		option(p)
+	}
+	return p, nil
 }
 
 // LoadRecords loads records from storage into the pipeline
@@ -68,57 +184,276 @@ func (p *Pipeline) LoadRecords(storage *pprl.Storage) error {
 	if err != nil {
 		return fmt.Errorf("failed to load records: %w", err)
 	}
+	p.SetRecords(records)
+	log.Printf("Loaded %d records into pipeline", len(records))
+	return nil
+}
 
+// SetRecords loads records into the pipeline; they are the peer side of each match in Run
+func (p *Pipeline) SetRecords(records []*pprl.Record) {
+	p.indexed = records
 	p.records = make(map[string]*pprl.Record, len(records))
 	for _, record := range records {
 		p.records[record.ID] = record
 	}
-
 	p.stats.TotalRecords = len(records)
-	log.Printf("Loaded %d records into pipeline", len(records))
-	return nil
 }
 
-// ExecuteMatching runs the complete zero-knowledge matching pipeline
-func (p *Pipeline) ExecuteMatching() ([]*PrivateMatchResult, error) {
+// Run matches the records of source against the loaded records. Matches go to the writer if one
+// was set and are otherwise returned. With 1:1 matching the assigner needs every scored pair, so
+// matches leave it only once the comparator has finished.
+//
+// Cancelling ctx, or an error in any stage, stops every stage and Run returns the cause.
+func (p *Pipeline) Run(ctx context.Context, source RecordSource) ([]*PrivateMatchResult, error) {
 	p.stats.StartTime = time.Now()
 	defer func() {
 		p.stats.EndTime = time.Now()
 		p.stats.ProcessingTimeMs = p.stats.EndTime.Sub(p.stats.StartTime).Milliseconds()
 	}()
 
-	log.Println("Starting zero-knowledge fuzzy matching pipeline...")
+	index := p.newCandidateIndex()
+	group := newStageGroup(ctx)
+	var counters []*stageCounters
+	stage := func(name string, workers int) *stageCounters {
+		c := &stageCounters{name: name, workers: workers}
+		counters = append(counters, c)
+		return c
+	}
 
-	// Phase 1: Create blocking buckets
-	log.Println("Phase 1: Creating secure blocking buckets...")
-	buckets, err := p.createBlocks()
-	if err != nil {
-		return nil, fmt.Errorf("blocking phase failed: %w", err)
+	// reader
+	records := make(chan *pprl.Record, p.bufferSize)
+	readerCounters := stage("reader", 1)
+	group.Go(func(ctx context.Context) error {
+		defer close(records)
+		for {
+			record, err := source.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read record: %w", err)
+			}
+			readerCounters.in.Add(1)
+			select {
+			case records <- record:
+				readerCounters.out.Add(1)
+			case <-ctx.Done():
+				return context.Cause(ctx)
+			}
+		}
+	})
+
+	// blocker
+	blocked := make(chan Candidate, p.bufferSize)
+	blockerCounters := stage("blocker", 1)
+	var emitted int
+	limited := false
+	group.Go(func(ctx context.Context) error {
+		return runStage(ctx, blockerCounters, records, blocked, func(ctx context.Context, record *pprl.Record, emit func(Candidate) error) error {
+			for _, peer := range index.candidates(record) {
+				if p.config.MaxCandidates > 0 && emitted >= p.config.MaxCandidates {
+					if !limited {
+						log.Printf("Limiting candidates to %d", p.config.MaxCandidates)
+						limited = true
+					}
+					return nil
+				}
+				emitted++
+				if err := emit(Candidate{Local: record, Peer: peer}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+
+	// custom candidate stages; each closure keeps its own channels as the chain grows
+	candidates := blocked
+	for _, custom := range p.candidateStages {
+		in, out := candidates, make(chan Candidate, p.bufferSize)
+		customCounters, run := stage(custom.name, 1), custom.run
+		group.Go(func(ctx context.Context) error {
+			return runStage(ctx, customCounters, in, out, run)
+		})
+		candidates = out
 	}
 
-	// Phase 2: Generate candidate pairs
-	log.Println("Phase 2: Generating candidate pairs...")
-	candidates, err := p.generateCandidates(buckets)
-	if err != nil {
-		return nil, fmt.Errorf("candidate generation failed: %w", err)
+	// comparator
+	scored := make(chan crypto.PrivateMatchPair, p.bufferSize)
+	comparatorCounters := stage("comparator", p.comparators)
+	compareIn := candidates
+	group.Go(func(ctx context.Context) error {
+		return runStage(ctx, comparatorCounters, compareIn, scored, func(ctx context.Context, candidate Candidate, emit func(crypto.PrivateMatchPair) error) error {
+			if pair, isMatch := p.matcher.ComparePair(candidate.Local, candidate.Peer); isMatch {
+				return emit(pair)
+			}
+			return nil
+		})
+	})
+
+	// assigner
+	assigned := make(chan *PrivateMatchResult, p.bufferSize)
+	assignerCounters := stage("assigner", 1)
+	group.Go(func(ctx context.Context) error {
+		if p.matcher.config.AllowDuplicates && !p.matcher.config.OneToMany {
+			return runStage(ctx, assignerCounters, scored, assigned, func(ctx context.Context, pair crypto.PrivateMatchPair, emit func(*PrivateMatchResult) error) error {
+				return emit(p.matcher.matchResult(pair))
+			})
+		}
+
+		// 1:1 and 1:many assignment weigh every scored pair, so nothing leaves until the comparator is done
+		defer close(assigned)
+		var pairs []crypto.PrivateMatchPair
+		for {
+			var pair crypto.PrivateMatchPair
+			var ok bool
+			select {
+			case pair, ok = <-scored:
+			case <-ctx.Done():
+				return context.Cause(ctx)
+			}
+			if !ok {
+				break
+			}
+			assignerCounters.in.Add(1)
+			pairs = append(pairs, pair)
+		}
+		if p.matcher.config.AllowDuplicates {
+			pairs = crypto.AssignOneToMany(pairs, p.matcher.config.Party)
+		} else {
+			pairs = crypto.AssignOneToOne(pairs, p.matcher.config.Party, p.matcher.config.Assignment)
+		}
+		for _, pair := range pairs {
+			select {
+			case assigned <- p.matcher.matchResult(pair):
+				assignerCounters.out.Add(1)
+			case <-ctx.Done():
+				return context.Cause(ctx)
+			}
+		}
+		return nil
+	})
+
+	// custom match stages
+	matches := assigned
+	for _, custom := range p.matchStages {
+		in, out := matches, make(chan *PrivateMatchResult, p.bufferSize)
+		customCounters, run := stage(custom.name, 1), custom.run
+		group.Go(func(ctx context.Context) error {
+			return runStage(ctx, customCounters, in, out, run)
+		})
+		matches = out
 	}
 
-	// Phase 3: Perform zero-knowledge fuzzy matching
-	log.Println("Phase 3: Performing zero-knowledge fuzzy matching...")
-	results, err := p.performZKMatching(candidates)
+	// writer
+	var results []*PrivateMatchResult
+	found := 0
+	writerCounters := stage("writer", 1)
+	writeIn := matches
+	group.Go(func(ctx context.Context) error {
+		for {
+			var match *PrivateMatchResult
+			var ok bool
+			select {
+			case match, ok = <-writeIn:
+			case <-ctx.Done():
+				return context.Cause(ctx)
+			}
+			if !ok {
+				break
+			}
+			writerCounters.in.Add(1)
+			found++
+			if p.writer == nil {
+				results = append(results, match)
+			} else if err := p.writer.WriteMatch(match); err != nil {
+				return fmt.Errorf("failed to write match: %w", err)
+			}
+			writerCounters.out.Add(1)
+		}
+		if p.writer != nil {
+			return p.writer.Flush()
+		}
+		return nil
+	})
+
+	err := group.Wait()
+
+	p.stats.StreamedRecords = int(readerCounters.out.Load())
+	p.stats.CandidatePairs = emitted
+	p.stats.Stages = p.stats.Stages[:0]
+	for _, c := range counters {
+		p.stats.Stages = append(p.stats.Stages, c.snapshot())
+	}
 	if err != nil {
-		return nil, fmt.Errorf("zero-knowledge matching failed: %w", err)
+		return nil, err
 	}
-
-	// Generate LIMITED statistics (no information leakage)
 	if p.config.EnableStats {
-		p.generateLimitedStats(buckets, candidates, results)
+		p.stats.MatchingStats = PrivateMatchingStats{MatchCount: found}
 	}
-
-	log.Printf("Pipeline completed. Found %d matches from %d candidates", len(results), len(candidates))
+	log.Printf("Pipeline completed. Found %d matches from %d candidates", found, emitted)
 	return results, nil
 }
 
+// candidateIndex buckets the loaded records by MinHash band, or by blocking key, for the blocker
+type candidateIndex struct {
+	records  []*pprl.Record
+	buckets  map[string][]int
+	bandSize int
+	blocking bool // Bucket by blocking key instead of MinHash band
+	seen     []int
+	streamed int
+}
+
+// newCandidateIndex indexes the loaded records
+func (p *Pipeline) newCandidateIndex() *candidateIndex {
+	ix := &candidateIndex{
+		records:  p.indexed,
+		buckets:  make(map[string][]int),
+		bandSize: p.bandSize,
+		blocking: p.matcher.config.Blocking,
+		seen:     make([]int, len(p.indexed)),
+	}
+	for i, record := range p.indexed {
+		for _, key := range ix.keys(record) {
+			ix.buckets[key] = append(ix.buckets[key], i)
+		}
+	}
+	return ix
+}
+
+// keys returns the buckets a record belongs to
+func (ix *candidateIndex) keys(record *pprl.Record) []string {
+	if ix.blocking {
+		return record.BlockingKeys
+	}
+	var keys []string
+	for start, band := 0, 0; start < len(record.MinHash); start, band = start+ix.bandSize, band+1 {
+		end := start + ix.bandSize
+		if end > len(record.MinHash) {
+			end = len(record.MinHash)
+		}
+		keys = append(keys, createBlockingKey(record.MinHash[start:end], band))
+	}
+	return keys
+}
+
+// candidates returns the loaded records sharing a bucket with record, each once
+func (ix *candidateIndex) candidates(record *pprl.Record) []*pprl.Record {
+	ix.streamed++
+	var peers []*pprl.Record
+	for _, key := range ix.keys(record) {
+		for _, i := range ix.buckets[key] {
+			if ix.seen[i] == ix.streamed {
+				continue
+			}
+			ix.seen[i] = ix.streamed
+			peers = append(peers, ix.records[i])
+		}
+	}
+	return peers
+}
+
 // createBlocks implements the secure blocking phase
 func (p *Pipeline) createBlocks() ([]*BlockingBucket, error) {
 	// Convert records to MinHash format
@@ -140,39 +475,6 @@ func (p *Pipeline) createBlocks() ([]*BlockingBucket, error) {
 	return buckets, nil
 }
 
-// generateCandidates creates candidate pairs from blocking buckets
-func (p *Pipeline) generateCandidates(buckets []*BlockingBucket) ([]CandidatePair, error) {
-	candidates := p.blocker.GetCandidatePairs(buckets)
-
-	// Apply candidate limit if configured
-	if p.config.MaxCandidates > 0 && len(candidates) > p.config.MaxCandidates {
-		log.Printf("Limiting candidates from %d to %d", len(candidates), p.config.MaxCandidates)
-		candidates = candidates[:p.config.MaxCandidates]
-	}
-
-	p.stats.CandidatePairs = len(candidates)
-	log.Printf("Generated %d candidate pairs", len(candidates))
-	return candidates, nil
-}
-
-// performZKMatching executes the zero-knowledge fuzzy matching phase
-func (p *Pipeline) performZKMatching(candidates []CandidatePair) ([]*PrivateMatchResult, error) {
-	results, err := p.matcher.BatchPrivateCompare(candidates, p.records)
-	if err != nil {
-		return nil, err
-	}
-
-	log.Printf("Found %d matches from %d comparisons", len(results), len(candidates))
-	return results, nil
-}
-
-// generateLimitedStats compiles LIMITED statistics with no information leakage
-func (p *Pipeline) generateLimitedStats(buckets []*BlockingBucket, candidates []CandidatePair, results []*PrivateMatchResult) {
-	p.stats.BlockingStats = p.blocker.GetBlockingStats(buckets)
-	p.stats.MatchingStats = p.matcher.GetPrivateMatchingStats(results)
-	p.stats.CandidatePairs = len(candidates)
-}
-
 // GetStats returns the current pipeline statistics
 func (p *Pipeline) GetStats() *PipelineStats {
 	return p.stats
@@ -265,56 +567,73 @@ type TwoPartyMatchResult struct {
 	Party2Records   int                   `json:"party2_records"`
 }
 
-// ExportResults exports zero-knowledge results to file (ONLY matches)
-func (p *Pipeline) ExportResults(results []*PrivateMatchResult, format string) error {
-	if format == "json" {
-		return p.exportResultsAsJSON(results)
-	} else if format == "csv" {
-		return p.exportResultsAsCSV(results)
-	}
-	return fmt.Errorf("unsupported export format: %s", format)
+// SliceSource supplies records from a slice
+func SliceSource(records []*pprl.Record) RecordSource {
+	return &sliceSource{records: records}
 }
 
-// exportResultsAsJSON exports results as JSON with ZERO information leakage
-func (p *Pipeline) exportResultsAsJSON(results []*PrivateMatchResult) error {
-	file, err := os.Create(p.config.OutputPath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-
-	// Export ONLY matches - no additional metadata
-	return encoder.Encode(map[string]interface{}{
-		"matches": results,
-		"count":   len(results), // ONLY count, no other statistics
-	})
+// sliceSource is the RecordSource of SliceSource
+type sliceSource struct {
+	records []*pprl.Record
+	next    int
 }
 
-// exportResultsAsCSV exports results as CSV with ZERO information leakage
-func (p *Pipeline) exportResultsAsCSV(results []*PrivateMatchResult) error {
-	file, err := os.Create(p.config.OutputPath)
-	if err != nil {
-		return err
+// Next returns the next record
+func (s *sliceSource) Next() (*pprl.Record, error) {
+	if s.next >= len(s.records) {
+		return nil, io.EOF
 	}
-	defer file.Close()
+	s.next++
+	return s.records[s.next-1], nil
+}
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+// CSVResultWriter writes matches as local_id,peer_id rows with ZERO information leakage
+type CSVResultWriter struct {
+	writer *csv.Writer
+	header bool
+}
 
-	// Write header - ONLY the essential match information
-	if err := writer.Write([]string{"local_id", "peer_id"}); err != nil {
-		return err
-	}
+// NewCSVResultWriter writes matches to w; Flush must be called to complete the output
+func NewCSVResultWriter(w io.Writer) *CSVResultWriter {
+	return &CSVResultWriter{writer: csv.NewWriter(w)}
+}
 
-	// Write ONLY the matching pairs - no scores, distances, or metadata
-	for _, result := range results {
-		if err := writer.Write([]string{result.LocalID, result.PeerID}); err != nil {
+// WriteMatch writes one match, after the header for the first
+func (w *CSVResultWriter) WriteMatch(match *PrivateMatchResult) error {
+	if !w.header {
+		// Write header - ONLY the essential match information
+		if err := w.writer.Write([]string{"local_id", "peer_id"}); err != nil {
 			return err
 		}
+		w.header = true
 	}
+	return w.writer.Write([]string{match.LocalID, match.PeerID})
+}
 
-	return nil
+// Flush writes buffered rows to the underlying writer
+func (w *CSVResultWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// JSONResultWriter writes matches as JSON Lines with ZERO information leakage
+type JSONResultWriter struct {
+	buffered *bufio.Writer
+	encoder  *json.Encoder
+}
+
+// NewJSONResultWriter writes matches to w; Flush must be called to complete the output
+func NewJSONResultWriter(w io.Writer) *JSONResultWriter {
+	buffered := bufio.NewWriter(w)
+	return &JSONResultWriter{buffered: buffered, encoder: json.NewEncoder(buffered)}
+}
+
+// WriteMatch writes one match as a line
+func (w *JSONResultWriter) WriteMatch(match *PrivateMatchResult) error {
+	return w.encoder.Encode(match)
+}
+
+// Flush writes buffered lines to the underlying writer
+func (w *JSONResultWriter) Flush() error {
+	return w.buffered.Flush()
 }
@@ -0,0 +1,145 @@
+// stage.go
+// Package match provides the stages of the matching pipeline: goroutines connected by bounded
+// channels, so a slow stage holds back the stages feeding it instead of letting work pile up.
+package match
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stage processes one item and passes results on with emit, which blocks while the next stage's
+// buffer is full. A stage may emit any number of items per input; emit fails once the pipeline
+// is cancelled, and the stage should then return the error.
+type Stage[T any] func(ctx context.Context, item T, emit func(T) error) error
+
+// StageMetrics reports how items moved through one stage
+type StageMetrics struct {
+	Name    string        `json:"name"`
+	Workers int           `json:"workers"`
+	In      int64         `json:"in"`      // Items received
+	Out     int64         `json:"out"`     // Items passed on
+	Active  time.Duration `json:"active"`  // Time spent processing, summed over workers
+	Blocked time.Duration `json:"blocked"` // Time spent waiting for room downstream, summed over workers
+}
+
+// stageCounters collects the metrics of a running stage
+type stageCounters struct {
+	name    string
+	workers int
+	in      atomic.Int64
+	out     atomic.Int64
+	active  atomic.Int64
+	blocked atomic.Int64
+}
+
+// snapshot returns the metrics collected so far
+func (c *stageCounters) snapshot() StageMetrics {
+	return StageMetrics{
+		Name:    c.name,
+		Workers: c.workers,
+		In:      c.in.Load(),
+		Out:     c.out.Load(),
+		Active:  time.Duration(c.active.Load()),
+		Blocked: time.Duration(c.blocked.Load()),
+	}
+}
+
+// runStage runs process over every item of in with the given number of workers, sending results
+// to out, which it closes when in is drained or the context is cancelled
+func runStage[In, Out any](ctx context.Context, counters *stageCounters, in <-chan In, out chan<- Out, process func(ctx context.Context, item In, emit func(Out) error) error) error {
+	defer close(out)
+
+	emit := func(item Out) error {
+		start := time.Now()
+		select {
+		case out <- item:
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+		counters.blocked.Add(int64(time.Since(start)))
+		counters.out.Add(1)
+		return nil
+	}
+
+	worker := func() error {
+		for {
+			var item In
+			var ok bool
+			select {
+			case item, ok = <-in:
+			case <-ctx.Done():
+				return context.Cause(ctx)
+			}
+			if !ok {
+				return nil
+			}
+			counters.in.Add(1)
+
+			start := time.Now()
+			blockedBefore := counters.blocked.Load()
+			err := process(ctx, item, emit)
+			// Time blocked in emit is backpressure, not work; with several workers this is approximate
+			counters.active.Add(int64(time.Since(start)) - (counters.blocked.Load() - blockedBefore))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if counters.workers <= 1 {
+		counters.workers = 1
+		return worker()
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, counters.workers)
+	for w := 0; w < counters.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := worker(); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// stageGroup runs the goroutines of a pipeline, cancelling all of them when one fails
+type stageGroup struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	wg     sync.WaitGroup
+}
+
+// newStageGroup returns a group whose context is cancelled when ctx is or a stage fails
+func newStageGroup(ctx context.Context) *stageGroup {
+	ctx, cancel := context.WithCancelCause(ctx)
+	return &stageGroup{ctx: ctx, cancel: cancel}
+}
+
+// Go runs a stage
+func (g *stageGroup) Go(run func(ctx context.Context) error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := run(g.ctx); err != nil {
+			g.cancel(err)
+		}
+	}()
+}
+
+// Wait waits for every stage and returns the error that stopped the pipeline, if any
+func (g *stageGroup) Wait() error {
+	g.wg.Wait()
+	err := g.ctx.Err()
+	if err != nil {
+		err = context.Cause(g.ctx)
+	}
+	g.cancel(nil)
+	return err
+}
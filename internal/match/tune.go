@@ -0,0 +1,139 @@
+// tune.go
+// Package match provides threshold tuning against ground truth.
+// Each grid point is evaluated exactly as matching would run it: pairs passing both
+// thresholds are reduced to a 1:1 assignment before precision and recall are measured.
+package match
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/crypto"
+)
+
+// Tuning criteria for choosing an operating point
+const (
+	TuneMaxF1        = "f1"        // Highest F1 score
+	TuneMinPrecision = "precision" // Highest recall with precision at or above a minimum
+)
+
+// OperatingPoint is the outcome of matching at one pair of thresholds
+type OperatingPoint struct {
+	HammingThreshold uint32  `json:"hamming_threshold"`
+	JaccardThreshold float64 `json:"jaccard_threshold"`
+	TruePositives    int     `json:"true_positives"`
+	FalsePositives   int     `json:"false_positives"`
+	FalseNegatives   int     `json:"false_negatives"`
+	Precision        float64 `json:"precision"`
+	Recall           float64 `json:"recall"`
+	F1               float64 `json:"f1"`
+}
+
+// TuneThresholds evaluates every combination of the Hamming and Jaccard grids.
+// totalTruth is the number of ground truth matches; assignment is the 1:1 algorithm.
+func TuneThresholds(pairs []ScoredPair, totalTruth int, hammingGrid []uint32, jaccardGrid []float64, assignment string) []OperatingPoint {
+	// Only pairs passing the loosest thresholds can ever be selected
+	maxHamming := uint32(0)
+	for _, h := range hammingGrid {
+		if h > maxHamming {
+			maxHamming = h
+		}
+	}
+	minJaccard := 1.0
+	for _, j := range jaccardGrid {
+		if j < minJaccard {
+			minJaccard = j
+		}
+	}
+	var candidates []ScoredPair
+	for _, p := range pairs {
+		if p.Hamming <= maxHamming && p.Jaccard >= minJaccard {
+			candidates = append(candidates, p)
+		}
+	}
+
+	var points []OperatingPoint
+	for _, h := range hammingGrid {
+		for _, j := range jaccardGrid {
+			var selected []crypto.PrivateMatchPair
+			labels := make(map[string]bool)
+			for _, p := range candidates {
+				if p.Hamming <= h && p.Jaccard >= j {
+					selected = append(selected, crypto.NewScoredMatchPair(p.ID1, p.ID2, p.Hamming, p.Jaccard))
+					labels[p.ID1+"\x00"+p.ID2] = p.Match
+				}
+			}
+
+			point := OperatingPoint{HammingThreshold: h, JaccardThreshold: j}
+			for _, m := range crypto.AssignOneToOne(selected, 0, assignment) {
+				if labels[m.LocalID+"\x00"+m.PeerID] {
+					point.TruePositives++
+				} else {
+					point.FalsePositives++
+				}
+			}
+			point.FalseNegatives = totalTruth - point.TruePositives
+			if point.FalseNegatives < 0 {
+				point.FalseNegatives = 0
+			}
+			point.Precision, point.Recall, point.F1 = precisionRecallF1(point.TruePositives, point.FalsePositives, point.FalseNegatives)
+			points = append(points, point)
+		}
+	}
+	return points
+}
+
+// BestOperatingPoint picks the operating point for a criterion. For TuneMinPrecision,
+// points below minPrecision are excluded and recall is maximized.
+func BestOperatingPoint(points []OperatingPoint, criterion string, minPrecision float64) (*OperatingPoint, error) {
+	ranked := RankOperatingPoints(points, criterion, minPrecision)
+	if len(ranked) == 0 {
+		if criterion == TuneMinPrecision {
+			return nil, fmt.Errorf("no thresholds in the grid reach precision %.3f", minPrecision)
+		}
+		return nil, fmt.Errorf("no operating points to choose from")
+	}
+	return &ranked[0], nil
+}
+
+// RankOperatingPoints orders eligible points best first for a criterion
+func RankOperatingPoints(points []OperatingPoint, criterion string, minPrecision float64) []OperatingPoint {
+	var eligible []OperatingPoint
+	for _, p := range points {
+		if criterion == TuneMinPrecision && (p.Precision < minPrecision || p.TruePositives == 0) {
+			continue
+		}
+		eligible = append(eligible, p)
+	}
+
+	sort.SliceStable(eligible, func(a, b int) bool {
+		pa, pb := eligible[a], eligible[b]
+		if criterion == TuneMinPrecision && pa.Recall != pb.Recall {
+			return pa.Recall > pb.Recall
+		}
+		if pa.F1 != pb.F1 {
+			return pa.F1 > pb.F1
+		}
+		// Prefer the strictest thresholds among equals
+		if pa.HammingThreshold != pb.HammingThreshold {
+			return pa.HammingThreshold < pb.HammingThreshold
+		}
+		return pa.JaccardThreshold > pb.JaccardThreshold
+	})
+	return eligible
+}
+
+// precisionRecallF1 computes the standard metrics from confusion counts
+func precisionRecallF1(tp, fp, fn int) (float64, float64, float64) {
+	var precision, recall, f1 float64
+	if tp+fp > 0 {
+		precision = float64(tp) / float64(tp+fp)
+	}
+	if tp+fn > 0 {
+		recall = float64(tp) / float64(tp+fn)
+	}
+	if precision+recall > 0 {
+		f1 = 2 * precision * recall / (precision + recall)
+	}
+	return precision, recall, f1
+}
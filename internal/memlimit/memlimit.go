@@ -0,0 +1,199 @@
+// memlimit.go
+// Package memlimit keeps long tokenizations and intersections under a memory limit. A watchdog
+// samples the Go heap while a run works through its batches: batches shrink as the heap nears
+// the limit and grow back once it falls, and a run whose heap stays above the limit after a
+// forced collection stops with an error naming the stage, instead of being killed by the OS.
+package memlimit
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Watermarks of the heap, as shares of the limit, between which batch sizes are left alone
+const (
+	HighWater = 0.75 // Batches are halved above this share of the limit
+	LowWater  = 0.50 // Batches are doubled, up to their configured size, below it
+)
+
+// sampleInterval is how often the watchdog reads the heap between checks
+const sampleInterval = 250 * time.Millisecond
+
+// ParseSize parses a memory size such as 512M, 2GiB or 1.5G; units are powers of 1024, with or
+// without the i and B (K, M, G, T), and a bare number is bytes
+func ParseSize(s string) (uint64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+	multiplier := 1.0
+	if value != "" {
+		if exp := strings.IndexByte("KMGT", value[len(value)-1]); exp >= 0 {
+			multiplier = math.Pow(1024, float64(exp+1))
+			value = strings.TrimSpace(value[:len(value)-1])
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n <= 0 || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("invalid memory size %q (use e.g. 512M or 4G)", s)
+	}
+	return uint64(n * multiplier), nil
+}
+
+// FormatSize formats a size in bytes with a binary unit, such as 1.5 GiB
+func FormatSize(size uint64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := uint64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// LimitError is returned when the heap stays above the limit after a forced collection
+type LimitError struct {
+	Stage string // What the run was doing, e.g. "loading records"
+	Used  uint64 // Heap in use, in bytes
+	Limit uint64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("memory limit reached while %s: %s in use, limit %s", e.Stage, FormatSize(e.Used), FormatSize(e.Limit))
+}
+
+// Watchdog samples the heap against a limit. A nil Watchdog has no limit: it never shrinks a
+// batch or fails a check.
+type Watchdog struct {
+	limit uint64
+	used  atomic.Uint64
+	peak  atomic.Uint64
+
+	previousLimit int64 // Go runtime soft limit restored by Stop
+	stop          chan struct{}
+	stopOnce      sync.Once
+}
+
+// Start starts a watchdog for limit bytes, or returns nil for a zero limit. The Go runtime's soft
+// memory limit is set to it as well, so the collector works harder before the watchdog steps in.
+func Start(limit uint64) *Watchdog {
+	if limit == 0 {
+		return nil
+	}
+	w := &Watchdog{
+		limit:         limit,
+		previousLimit: debug.SetMemoryLimit(int64(min(limit, math.MaxInt64))),
+		stop:          make(chan struct{}),
+	}
+	w.sample()
+	go func() {
+		ticker := time.NewTicker(sampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				w.sample()
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+	return w
+}
+
+// Stop ends sampling and restores the runtime's soft memory limit
+func (w *Watchdog) Stop() {
+	if w == nil {
+		return
+	}
+	w.stopOnce.Do(func() {
+		close(w.stop)
+		debug.SetMemoryLimit(w.previousLimit)
+	})
+}
+
+// sample reads the heap in use (live objects, stacks and the runtime's own structures) and
+// returns it
+func (w *Watchdog) sample() uint64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	used := stats.HeapInuse + stats.StackInuse + stats.MSpanInuse + stats.MCacheInuse
+	w.used.Store(used)
+	for {
+		peak := w.peak.Load()
+		if used <= peak || w.peak.CompareAndSwap(peak, used) {
+			return used
+		}
+	}
+}
+
+// Limit returns the limit in bytes (0 for a nil watchdog)
+func (w *Watchdog) Limit() uint64 {
+	if w == nil {
+		return 0
+	}
+	return w.limit
+}
+
+// Peak returns the most heap in use seen so far
+func (w *Watchdog) Peak() uint64 {
+	if w == nil {
+		return 0
+	}
+	return w.peak.Load()
+}
+
+// Pressure returns the heap in use at the last sample as a share of the limit
+func (w *Watchdog) Pressure() float64 {
+	if w == nil {
+		return 0
+	}
+	return float64(w.used.Load()) / float64(w.limit)
+}
+
+// Fits reports whether estimate more bytes fit under the limit beside the heap now in use
+func (w *Watchdog) Fits(estimate uint64) bool {
+	if w == nil {
+		return true
+	}
+	return w.sample()+estimate <= w.limit
+}
+
+// Batch returns the size of the next batch: size halved while the heap is above HighWater,
+// doubled up to ceiling while it is below LowWater, and kept otherwise. It is at least 1.
+func (w *Watchdog) Batch(size, ceiling int) int {
+	if w == nil {
+		return size
+	}
+	switch pressure := float64(w.sample()) / float64(w.limit); {
+	case pressure > HighWater:
+		size /= 2
+	case pressure < LowWater:
+		size *= 2
+	}
+	return min(max(size, 1), ceiling)
+}
+
+// Check fails with a *LimitError when the heap is above the limit even after a collection that
+// returns freed memory to the OS
+func (w *Watchdog) Check(stage string) error {
+	if w == nil {
+		return nil
+	}
+	if w.sample() <= w.limit {
+		return nil
+	}
+	debug.FreeOSMemory()
+	if used := w.sample(); used > w.limit {
+		return &LimitError{Stage: stage, Used: used, Limit: w.limit}
+	}
+	return nil
+}
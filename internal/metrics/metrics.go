@@ -0,0 +1,49 @@
+// metrics.go
+// Package metrics exposes operational metrics of linkage jobs in the Prometheus text format,
+// so the serve daemon and pprl runs can be scraped by existing Prometheus/Grafana setups.
+// Metrics never carry record identifiers or tokens: only counts, sizes and durations.
+package metrics
+
+// Default is the registry the cohort-bridge metrics below are registered on
+var Default = NewRegistry()
+
+// durationBuckets span quick tokenizations to multi-hour intersections, in seconds
+var durationBuckets = ExponentialBuckets(0.5, 2, 16)
+
+var (
+	// RecordsTokenized counts records turned into Bloom filter tokens
+	RecordsTokenized = Default.NewCounter("cohort_bridge_records_tokenized_total",
+		"Records tokenized.", "command")
+
+	// Comparisons counts record pairs considered by intersections; rate() gives comparisons/sec
+	Comparisons = Default.NewCounter("cohort_bridge_comparisons_total",
+		"Record pairs considered by intersections.", "command")
+
+	// ComparisonRate is the throughput of the last finished intersection
+	ComparisonRate = Default.NewGauge("cohort_bridge_comparisons_per_second",
+		"Record pairs considered per second by the last finished intersection.", "command")
+
+	// MatchesFound counts matches in successful runs
+	MatchesFound = Default.NewCounter("cohort_bridge_matches_found_total",
+		"Matches found by successful runs.", "command")
+
+	// ExchangeBytes counts bytes exchanged with peers and serve clients
+	ExchangeBytes = Default.NewCounter("cohort_bridge_exchange_bytes_total",
+		"Bytes exchanged with peers and clients.", "transport", "direction")
+
+	// RunDuration observes how long runs take, by command and outcome
+	RunDuration = Default.NewHistogram("cohort_bridge_run_duration_seconds",
+		"Duration of runs.", durationBuckets, "command", "status")
+
+	// Errors counts failed runs and rejected requests
+	Errors = Default.NewCounter("cohort_bridge_errors_total",
+		"Failed runs and rejected requests.", "command", "reason")
+
+	// Jobs counts finished serve jobs by outcome
+	Jobs = Default.NewCounter("cohort_bridge_serve_jobs_total",
+		"Serve jobs finished, by status.", "status")
+
+	// JobsInFlight is the number of serve jobs held on disk (uploading, queued or running) and running
+	JobsInFlight = Default.NewGauge("cohort_bridge_serve_jobs_in_flight",
+		"Serve jobs held on disk (state=held) and computing (state=running).", "state")
+)
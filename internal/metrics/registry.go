@@ -0,0 +1,286 @@
+// registry.go
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// contentType is the Prometheus text exposition format served by Handler
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// collector is a metric family that can write itself in the text exposition format
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry holds metric families and serves them to Prometheus
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.collectors[c.name()]; exists {
+		panic("metrics: duplicate metric " + c.name())
+	}
+	r.collectors[c.name()] = c
+}
+
+// Write writes every metric family, sorted by name, in the text exposition format
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	collectors := r.collectors
+	r.mu.Unlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		collectors[name].write(w)
+	}
+}
+
+// Handler serves the registry at a Prometheus scrape endpoint
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		r.Write(w)
+	})
+}
+
+// family holds the label names and per-label-set values of one metric
+type family[V any] struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*V
+	values map[string][]string
+}
+
+func newFamily[V any](name, help, kind string, labels []string) *family[V] {
+	return &family[V]{
+		metricName: name,
+		help:       help,
+		kind:       kind,
+		labels:     labels,
+		series:     make(map[string]*V),
+		values:     make(map[string][]string),
+	}
+}
+
+func (f *family[V]) name() string {
+	return f.metricName
+}
+
+// with returns the series of labelValues, creating it with create on first use
+func (f *family[V]) with(labelValues []string, create func() *V) *V {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.metricName, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	series, ok := f.series[key]
+	if !ok {
+		series = create()
+		f.series[key] = series
+		f.values[key] = append([]string(nil), labelValues...)
+	}
+	return series
+}
+
+// each calls fn for every series in label order, holding the family lock
+func (f *family[V]) each(fn func(labelValues []string, series *V)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fn(f.values[key], f.series[key])
+	}
+}
+
+func (f *family[V]) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.metricName, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.metricName, f.kind)
+}
+
+// labelString formats name="value" pairs, with extra pairs (such as le) appended
+func labelString(names, values []string, extra ...string) string {
+	var pairs []string
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escape.Replace(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escape.Replace(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type value struct {
+	v float64
+}
+
+// Counter is a monotonically increasing value per label set
+type Counter struct {
+	*family[value]
+}
+
+// NewCounter registers a counter on r
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{newFamily[value](name, help, "counter", labels)}
+	r.register(c)
+	return c
+}
+
+// Add adds delta, which must not be negative, to the counter of labelValues
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counter " + c.metricName + " cannot decrease")
+	}
+	series := c.with(labelValues, func() *value { return &value{} })
+	c.mu.Lock()
+	series.v += delta
+	c.mu.Unlock()
+}
+
+// Inc adds one to the counter of labelValues
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.writeHeader(w)
+	c.each(func(labelValues []string, series *value) {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, labelString(c.labels, labelValues), formatValue(series.v))
+	})
+}
+
+// Gauge is a value per label set that can go up and down
+type Gauge struct {
+	*family[value]
+}
+
+// NewGauge registers a gauge on r
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{newFamily[value](name, help, "gauge", labels)}
+	r.register(g)
+	return g
+}
+
+// Set sets the gauge of labelValues
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	series := g.with(labelValues, func() *value { return &value{} })
+	g.mu.Lock()
+	series.v = v
+	g.mu.Unlock()
+}
+
+// Add adds delta to the gauge of labelValues
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	series := g.with(labelValues, func() *value { return &value{} })
+	g.mu.Lock()
+	series.v += delta
+	g.mu.Unlock()
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.writeHeader(w)
+	g.each(func(labelValues []string, series *value) {
+		fmt.Fprintf(w, "%s%s %s\n", g.metricName, labelString(g.labels, labelValues), formatValue(series.v))
+	})
+}
+
+type distribution struct {
+	counts []uint64 // Per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// Histogram counts observations in cumulative buckets per label set
+type Histogram struct {
+	*family[distribution]
+	buckets []float64
+}
+
+// NewHistogram registers a histogram with the given ascending bucket upper bounds on r
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{family: newFamily[distribution](name, help, "histogram", labels), buckets: buckets}
+	r.register(h)
+	return h
+}
+
+// Observe records v in the histogram of labelValues
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	series := h.with(labelValues, func() *distribution {
+		return &distribution{counts: make([]uint64, len(h.buckets))}
+	})
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	series.count++
+	series.sum += v
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		series.counts[i]++
+	}
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.writeHeader(w)
+	h.each(func(labelValues []string, series *distribution) {
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += series.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, labelString(h.labels, labelValues, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, labelString(h.labels, labelValues, "le", "+Inf"), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, labelString(h.labels, labelValues), formatValue(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, labelString(h.labels, labelValues), series.count)
+	})
+}
+
+// ExponentialBuckets returns count bucket bounds starting at start, each factor times the last
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
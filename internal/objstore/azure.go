@@ -0,0 +1,156 @@
+package objstore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// azureAPIVersion is the Blob service REST API version requested
+const azureAPIVersion = "2021-08-06"
+
+// azureBackend speaks the Blob service REST API, authorized with a SAS token
+type azureBackend struct {
+	client   *Client
+	endpoint string
+	sas      url.Values
+}
+
+func newAzureBackend(c *Client, account string) (*azureBackend, error) {
+	token := strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?")
+	if token == "" {
+		return nil, fmt.Errorf("objstore: az needs AZURE_STORAGE_SAS_TOKEN")
+	}
+	sas, err := url.ParseQuery(token)
+	if err != nil {
+		return nil, fmt.Errorf("objstore: invalid AZURE_STORAGE_SAS_TOKEN: %w", err)
+	}
+	endpoint := c.opts.AzureEndpoint
+	if endpoint == "" {
+		endpoint = "https://" + account + ".blob.core.windows.net"
+	}
+	return &azureBackend{client: c, endpoint: strings.TrimRight(endpoint, "/"), sas: sas}, nil
+}
+
+// do sends a request for a blob with the SAS token and operation parameters in its query
+func (b *azureBackend) do(method string, loc *Location, params url.Values, headers map[string]string, body []byte) (*http.Response, error) {
+	query := url.Values{}
+	for name, values := range b.sas {
+		query[name] = values
+	}
+	for name, values := range params {
+		query[name] = values
+	}
+	target := fmt.Sprintf("%s/%s/%s?%s", b.endpoint, url.PathEscape(loc.Bucket), escapeBlobName(loc.Key), query.Encode())
+
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	return b.client.http.Do(req)
+}
+
+// escapeBlobName escapes each segment of a blob name, keeping its virtual directories
+func escapeBlobName(name string) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// encryptionHeaders returns the encryption scope header for new blobs and blocks
+func (b *azureBackend) encryptionHeaders() map[string]string {
+	headers := map[string]string{}
+	if scope := b.client.opts.AzureEncryptionScope; scope != "" {
+		headers["x-ms-encryption-scope"] = scope
+	}
+	return headers
+}
+
+func (b *azureBackend) get(loc *Location) (io.ReadCloser, error) {
+	resp, err := b.do(http.MethodGet, loc, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// put writes small files with one Put Blob and larger ones as staged blocks committed with
+// Put Block List. Blocks that are never committed are discarded by the service.
+func (b *azureBackend) put(loc *Location, r io.Reader, size int64) error {
+	partSize := b.client.opts.PartSize
+	if size <= partSize {
+		body, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		headers := b.encryptionHeaders()
+		headers["x-ms-blob-type"] = "BlockBlob"
+		resp, err := b.do(http.MethodPut, loc, nil, headers, body)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		return checkResponse(resp, http.StatusCreated)
+	}
+
+	buf := make([]byte, partSize)
+	var blockIDs []string
+	for number := 0; ; number++ {
+		n, err := readPart(r, buf)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		// Block IDs of a blob must all have the same length
+		id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%08d", number)))
+		params := url.Values{"comp": {"block"}, "blockid": {id}}
+		resp, err := b.do(http.MethodPut, loc, params, b.encryptionHeaders(), buf[:n])
+		if err != nil {
+			return err
+		}
+		err = checkResponse(resp, http.StatusCreated)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		blockIDs = append(blockIDs, id)
+		if int64(n) < partSize {
+			break
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}{Latest: blockIDs})
+	if err != nil {
+		return err
+	}
+	resp, err := b.do(http.MethodPut, loc, url.Values{"comp": {"blocklist"}}, b.encryptionHeaders(), append([]byte(xml.Header), body...))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, http.StatusCreated)
+}
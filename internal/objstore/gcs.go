@@ -0,0 +1,156 @@
+package objstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	gcsEndpoint      = "https://storage.googleapis.com"
+	gcsMetadataToken = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	gcsChunkUnit     = 256 << 10 // Resumable upload chunks must be multiples of 256 KiB
+)
+
+// gcsBackend speaks the Cloud Storage JSON API, uploading with resumable sessions
+type gcsBackend struct {
+	client *Client
+	token  string
+}
+
+func newGCSBackend(c *Client) *gcsBackend {
+	return &gcsBackend{client: c}
+}
+
+// accessToken returns GOOGLE_OAUTH_ACCESS_TOKEN or, on Google Cloud, a token of the instance's
+// service account from the metadata server
+func (b *gcsBackend) accessToken() (string, error) {
+	if b.token != "" {
+		return b.token, nil
+	}
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		b.token = token
+		return token, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, gcsMetadataToken, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("gs needs GOOGLE_OAUTH_ACCESS_TOKEN outside Google Cloud: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return "", err
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf("invalid token from the metadata server")
+	}
+	b.token = token.AccessToken
+	return b.token, nil
+}
+
+// do sends an authorized request; the caller closes the response body
+func (b *gcsBackend) do(method, target string, headers map[string]string, body []byte) (*http.Response, error) {
+	token, err := b.accessToken()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	return b.client.http.Do(req)
+}
+
+func (b *gcsBackend) get(loc *Location) (io.ReadCloser, error) {
+	target := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", gcsEndpoint, url.PathEscape(loc.Bucket), url.PathEscape(loc.Key))
+	resp, err := b.do(http.MethodGet, target, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// put starts a resumable upload session and sends the file in chunks
+func (b *gcsBackend) put(loc *Location, r io.Reader, size int64) error {
+	query := url.Values{"uploadType": {"resumable"}, "name": {loc.Key}}
+	if b.client.opts.GCSKMSKeyName != "" {
+		query.Set("kmsKeyName", b.client.opts.GCSKMSKeyName)
+	}
+	target := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", gcsEndpoint, url.PathEscape(loc.Bucket), query.Encode())
+	resp, err := b.do(http.MethodPost, target, map[string]string{"X-Upload-Content-Length": fmt.Sprint(size)}, nil)
+	if err != nil {
+		return err
+	}
+	err = checkResponse(resp, http.StatusOK)
+	session := resp.Header.Get("Location")
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if session == "" {
+		return fmt.Errorf("no resumable upload session returned")
+	}
+
+	if size == 0 {
+		resp, err := b.do(http.MethodPut, session, map[string]string{"Content-Range": "bytes */0"}, nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		return checkResponse(resp, http.StatusOK, http.StatusCreated)
+	}
+
+	chunkSize := b.client.opts.PartSize / gcsChunkUnit * gcsChunkUnit
+	if chunkSize == 0 {
+		chunkSize = gcsChunkUnit
+	}
+	buf := make([]byte, chunkSize)
+	for offset := int64(0); offset < size; {
+		n, err := readPart(r, buf)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("file shrank during upload (%d of %d bytes)", offset, size)
+		}
+		contentRange := fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(n)-1, size)
+		resp, err := b.do(http.MethodPut, session, map[string]string{"Content-Range": contentRange}, buf[:n])
+		if err != nil {
+			return err
+		}
+		offset += int64(n)
+		// 308 asks for the next chunk; the last one completes the object
+		if offset < size {
+			err = checkResponse(resp, http.StatusPermanentRedirect)
+		} else {
+			err = checkResponse(resp, http.StatusOK, http.StatusCreated)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
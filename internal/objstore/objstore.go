@@ -0,0 +1,221 @@
+// objstore.go
+// Package objstore reads and writes files in cloud object storage (Amazon S3, Google Cloud
+// Storage and Azure Blob Storage) over their REST APIs. Objects are downloaded to and uploaded
+// from local files as streams: uploads are sent in parts of a fixed size, so memory use does
+// not grow with the file.
+//
+// Object URLs:
+//
+//	s3://bucket/key
+//	gs://bucket/object
+//	az://account/container/blob
+//
+// Credentials come from the environment, as with the providers' own tools: AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN; GOOGLE_OAUTH_ACCESS_TOKEN or the GCE metadata
+// server; AZURE_STORAGE_SAS_TOKEN.
+package objstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/retry"
+)
+
+// DefaultPartSize is the size of each uploaded part
+const DefaultPartSize = 16 << 20
+
+// Options configures access to the object stores; encryption settings apply to uploads
+type Options struct {
+	S3Region   string // Default AWS_REGION, then us-east-1
+	S3Endpoint string // S3-compatible endpoint (e.g. MinIO); path-style requests are used when set
+	S3SSE      string // Server-side encryption: AES256 or aws:kms
+	S3KMSKeyID string // KMS key for aws:kms (default: the bucket's AWS managed key)
+
+	GCSKMSKeyName string // Cloud KMS key that encrypts uploaded objects (default: Google-managed)
+
+	AzureEndpoint        string // Blob endpoint, e.g. for Azurite (default https://<account>.blob.core.windows.net)
+	AzureEncryptionScope string // Encryption scope applied to uploaded blobs
+
+	PartSize int64 // Bytes per uploaded part (DefaultPartSize if 0)
+
+	Retry retry.Policy // Retries of a download or upload failing on the network or with a server error
+}
+
+// Location is a parsed object URL
+type Location struct {
+	Scheme    string // s3, gs or az
+	Account   string // Azure storage account
+	Bucket    string // S3/GCS bucket or Azure container
+	Key       string // Object key or blob name
+	Canonical string // The URL as given
+}
+
+// IsRemote reports whether path is an object URL rather than a local path
+func IsRemote(path string) bool {
+	for _, prefix := range []string{"s3://", "gs://", "az://"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse parses an s3://, gs:// or az:// object URL
+func Parse(url string) (*Location, error) {
+	scheme, rest, ok := strings.Cut(url, "://")
+	if !ok {
+		return nil, fmt.Errorf("objstore: %q is not an object URL", url)
+	}
+	loc := &Location{Scheme: scheme, Canonical: url}
+	parts := strings.SplitN(rest, "/", 3)
+	switch scheme {
+	case "s3", "gs":
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("objstore: %q needs a bucket and an object name", url)
+		}
+		loc.Bucket, loc.Key = parts[0], strings.Join(parts[1:], "/")
+	case "az":
+		if len(parts) < 3 {
+			return nil, fmt.Errorf("objstore: %q needs an account, a container and a blob name", url)
+		}
+		loc.Account, loc.Bucket, loc.Key = parts[0], parts[1], parts[2]
+	default:
+		return nil, fmt.Errorf("objstore: unsupported scheme %q (expected s3, gs or az)", scheme)
+	}
+	if loc.Bucket == "" || loc.Key == "" || strings.HasSuffix(loc.Key, "/") {
+		return nil, fmt.Errorf("objstore: %q does not name an object", url)
+	}
+	return loc, nil
+}
+
+// Name returns the last element of the object name
+func (l *Location) Name() string {
+	return l.Key[strings.LastIndex(l.Key, "/")+1:]
+}
+
+// backend is one object store's REST protocol
+type backend interface {
+	get(loc *Location) (io.ReadCloser, error)
+	put(loc *Location, r io.Reader, size int64) error
+}
+
+// Client transfers files to and from object storage
+type Client struct {
+	opts Options
+	http *http.Client
+}
+
+// NewClient creates a client
+func NewClient(opts Options) *Client {
+	if opts.PartSize <= 0 {
+		opts.PartSize = DefaultPartSize
+	}
+	return &Client{opts: opts, http: &http.Client{}}
+}
+
+func (c *Client) backend(loc *Location) (backend, error) {
+	switch loc.Scheme {
+	case "s3":
+		return newS3Backend(c)
+	case "gs":
+		return newGCSBackend(c), nil
+	case "az":
+		return newAzureBackend(c, loc.Account)
+	}
+	return nil, fmt.Errorf("objstore: unsupported scheme %q", loc.Scheme)
+}
+
+// Download streams the object at url into localPath, retried from the start after a transient
+// failure
+func (c *Client) Download(url, localPath string) error {
+	return c.opts.Retry.Do("Download", func() error {
+		return c.download(url, localPath)
+	})
+}
+
+func (c *Client) download(url, localPath string) error {
+	loc, err := Parse(url)
+	if err != nil {
+		return err
+	}
+	b, err := c.backend(loc)
+	if err != nil {
+		return err
+	}
+	body, err := b.get(loc)
+	if err != nil {
+		return fmt.Errorf("objstore: failed to download %s: %w", url, err)
+	}
+	defer body.Close()
+
+	out, err := os.OpenFile(localPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, body); err != nil {
+		out.Close()
+		os.Remove(localPath)
+		return fmt.Errorf("objstore: failed to download %s: %w", url, err)
+	}
+	return out.Close()
+}
+
+// Upload streams localPath to the object at url, in parts for files larger than one part. After
+// a transient failure the whole upload is retried.
+func (c *Client) Upload(localPath, url string) error {
+	return c.opts.Retry.Do("Upload", func() error {
+		return c.upload(localPath, url)
+	})
+}
+
+func (c *Client) upload(localPath, url string) error {
+	loc, err := Parse(url)
+	if err != nil {
+		return err
+	}
+	b, err := c.backend(loc)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := b.put(loc, file, info.Size()); err != nil {
+		return fmt.Errorf("objstore: failed to upload %s: %w", url, err)
+	}
+	return nil
+}
+
+// readPart fills buf from r, returning the bytes read; a short read marks the last part
+func readPart(r io.Reader, buf []byte) (int, error) {
+	n, err := io.ReadFull(r, buf)
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return n, nil
+	}
+	return n, err
+}
+
+// checkResponse turns an unexpected HTTP status into an error carrying the start of the body
+func checkResponse(resp *http.Response, expected ...int) error {
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	// The query is left out: it may hold a SAS token or an upload ID
+	u := *resp.Request.URL
+	u.RawQuery = ""
+	return retry.HTTPError(resp.StatusCode, fmt.Errorf("%s %s: %s: %s", resp.Request.Method, u.String(), resp.Status, strings.TrimSpace(string(detail))))
+}
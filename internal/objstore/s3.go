@@ -0,0 +1,324 @@
+package objstore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3Backend speaks the S3 REST API with Signature Version 4
+type s3Backend struct {
+	client *Client
+	region string
+	access string
+	secret string
+	token  string
+}
+
+func newS3Backend(c *Client) (*s3Backend, error) {
+	b := &s3Backend{
+		client: c,
+		region: c.opts.S3Region,
+		access: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secret: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:  os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if b.region == "" {
+		b.region = os.Getenv("AWS_REGION")
+	}
+	if b.region == "" {
+		b.region = "us-east-1"
+	}
+	if b.access == "" || b.secret == "" {
+		return nil, fmt.Errorf("objstore: s3 needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return b, nil
+}
+
+// objectURL returns the URL of an object: virtual-hosted on AWS, path-style on a custom endpoint
+// or for bucket names with dots (which do not match the wildcard certificate)
+func (b *s3Backend) objectURL(loc *Location) (*url.URL, error) {
+	key := s3EscapePath(loc.Key)
+	if endpoint := b.client.opts.S3Endpoint; endpoint != "" {
+		u, err := url.Parse(strings.TrimRight(endpoint, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+		}
+		base := u.EscapedPath()
+		u.Path = u.Path + "/" + loc.Bucket + "/" + loc.Key
+		u.RawPath = base + "/" + s3Escape(loc.Bucket) + "/" + key
+		return u, nil
+	}
+	if strings.Contains(loc.Bucket, ".") {
+		host := "s3." + b.region + ".amazonaws.com"
+		return &url.URL{Scheme: "https", Host: host, Path: "/" + loc.Bucket + "/" + loc.Key, RawPath: "/" + loc.Bucket + "/" + key}, nil
+	}
+	host := loc.Bucket + ".s3." + b.region + ".amazonaws.com"
+	return &url.URL{Scheme: "https", Host: host, Path: "/" + loc.Key, RawPath: "/" + key}, nil
+}
+
+// s3EscapePath URI-encodes each segment of an object key as SigV4 requires
+func s3EscapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// s3Escape encodes everything but the RFC 3986 unreserved characters
+func s3Escape(value string) string {
+	var buf strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+// do sends a signed request; the caller closes the response body
+func (b *s3Backend) do(method string, loc *Location, query url.Values, headers map[string]string, body []byte) (*http.Response, error) {
+	u, err := b.objectURL(loc)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	b.sign(req, u, body, time.Now().UTC())
+	return b.client.http.Do(req)
+}
+
+// s3CanonicalQuery encodes a query string with sorted, SigV4-escaped keys and values
+func s3CanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			pairs = append(pairs, s3Escape(name)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// sign adds a Signature Version 4 Authorization header
+func (b *s3Backend) sign(req *http.Request, u *url.URL, body []byte, now time.Time) {
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if b.token != "" {
+		req.Header.Set("X-Amz-Security-Token", b.token)
+	}
+
+	// Host and every x-amz-* header are signed
+	signed := map[string]string{"host": u.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" || lower == "content-md5" {
+			signed[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		u.EscapedPath(),
+		u.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + b.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+b.secret), date)
+	key = hmacSHA256(key, b.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.access, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// encryptionHeaders returns the server-side encryption headers for new objects
+func (b *s3Backend) encryptionHeaders() map[string]string {
+	headers := map[string]string{}
+	if sse := b.client.opts.S3SSE; sse != "" {
+		headers["X-Amz-Server-Side-Encryption"] = sse
+		if sse == "aws:kms" && b.client.opts.S3KMSKeyID != "" {
+			headers["X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"] = b.client.opts.S3KMSKeyID
+		}
+	}
+	return headers
+}
+
+func (b *s3Backend) get(loc *Location) (io.ReadCloser, error) {
+	resp, err := b.do(http.MethodGet, loc, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// put uploads small files with one PUT and larger ones as a multipart upload
+func (b *s3Backend) put(loc *Location, r io.Reader, size int64) error {
+	partSize := b.client.opts.PartSize
+	if size <= partSize {
+		body, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		resp, err := b.do(http.MethodPut, loc, nil, b.encryptionHeaders(), body)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		return checkResponse(resp, http.StatusOK)
+	}
+
+	uploadID, err := b.createMultipart(loc)
+	if err != nil {
+		return err
+	}
+	if err := b.uploadParts(loc, uploadID, r, partSize); err != nil {
+		// Drop the stored parts, which would otherwise be billed until a lifecycle rule removes them
+		if resp, abortErr := b.do(http.MethodDelete, loc, url.Values{"uploadId": {uploadID}}, nil, nil); abortErr == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+func (b *s3Backend) createMultipart(loc *Location) (string, error) {
+	resp, err := b.do(http.MethodPost, loc, url.Values{"uploads": {""}}, b.encryptionHeaders(), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return "", err
+	}
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("invalid CreateMultipartUpload response")
+	}
+	return result.UploadID, nil
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (b *s3Backend) uploadParts(loc *Location, uploadID string, r io.Reader, partSize int64) error {
+	buf := make([]byte, partSize)
+	var parts []s3CompletedPart
+	for number := 1; ; number++ {
+		n, err := readPart(r, buf)
+		if err != nil {
+			return err
+		}
+		if n == 0 && number > 1 {
+			break
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		resp, err := b.do(http.MethodPut, loc, query, nil, buf[:n])
+		if err != nil {
+			return err
+		}
+		err = checkResponse(resp, http.StatusOK)
+		etag := resp.Header.Get("ETag")
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		parts = append(parts, s3CompletedPart{PartNumber: number, ETag: etag})
+		if int64(n) < partSize {
+			break
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	resp, err := b.do(http.MethodPost, loc, url.Values{"uploadId": {uploadID}}, nil, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return err
+	}
+	// A failed completion can still answer 200, with an error document as the body
+	result, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(result, []byte("<Error>")) {
+		return fmt.Errorf("CompleteMultipartUpload failed: %s", strings.TrimSpace(string(result)))
+	}
+	return nil
+}
@@ -0,0 +1,5 @@
+// Package peerpb holds the generated gRPC bindings of the versioned peer protocol
+// defined in proto/cohortbridge/peer/v1/peer.proto.
+package peerpb
+
+//go:generate protoc -I ../../proto --go_out=../.. --go_opt=module=github.com/auroradata-ai/cohort-bridge --go-grpc_out=../.. --go-grpc_opt=module=github.com/auroradata-ai/cohort-bridge cohortbridge/peer/v1/peer.proto
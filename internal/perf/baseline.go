@@ -0,0 +1,91 @@
+// baseline.go
+package perf
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+)
+
+// Baseline is a stored suite run that later runs are compared against. Timings only compare
+// meaningfully on the machine that recorded them, so the platform is kept alongside.
+type Baseline struct {
+	GoVersion string    `json:"go_version"`
+	Platform  string    `json:"platform"`
+	CPUs      int       `json:"cpus"`
+	Recorded  time.Time `json:"recorded"`
+	Results   []Result  `json:"results"`
+}
+
+// NewBaseline records results as a baseline of the current platform
+func NewBaseline(results []Result) *Baseline {
+	return &Baseline{
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+		Recorded:  time.Now().UTC().Truncate(time.Second),
+		Results:   results,
+	}
+}
+
+// SamePlatform reports whether the baseline was recorded on this OS, architecture and Go version
+func (b *Baseline) SamePlatform() bool {
+	return b.GoVersion == runtime.Version() && b.Platform == runtime.GOOS+"/"+runtime.GOARCH
+}
+
+// LoadBaseline reads a baseline file
+func LoadBaseline(path string) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var baseline Baseline
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return nil, fmt.Errorf("invalid baseline %s: %w", path, err)
+	}
+	return &baseline, nil
+}
+
+// Save writes the baseline file
+func (b *Baseline) Save(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Comparison is a benchmark result set against its baseline
+type Comparison struct {
+	Result
+	Baseline *Result // nil when the baseline has no result for the benchmark
+
+	TimeDelta  float64 // Relative change in ns/op (0.1 is 10% slower)
+	AllocDelta int64   // Change in allocations per op
+	Regressed  bool
+}
+
+// Compare sets results against the baseline. A benchmark regresses when it is slower by more
+// than tolerance (0.2 allows 20%) or allocates more often per operation.
+func (b *Baseline) Compare(results []Result, tolerance float64) []Comparison {
+	baseline := make(map[string]*Result, len(b.Results))
+	for i := range b.Results {
+		baseline[b.Results[i].Name] = &b.Results[i]
+	}
+
+	comparisons := make([]Comparison, 0, len(results))
+	for _, result := range results {
+		comparison := Comparison{Result: result, Baseline: baseline[result.Name]}
+		if base := comparison.Baseline; base != nil {
+			if base.NsPerOp > 0 {
+				comparison.TimeDelta = result.NsPerOp/base.NsPerOp - 1
+			}
+			comparison.AllocDelta = result.AllocsPerOp - base.AllocsPerOp
+			comparison.Regressed = comparison.TimeDelta > tolerance || comparison.AllocDelta > 0
+		}
+		comparisons = append(comparisons, comparison)
+	}
+	return comparisons
+}
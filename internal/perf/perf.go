@@ -0,0 +1,82 @@
+// perf.go
+// Package perf guards the hot path of record linkage against performance regressions: Bloom
+// filter encoding, Hamming distances, MinHash signatures and record comparison. The benchmarks
+// are the Benchmark functions beside the code they measure (internal/pprl, internal/match); this
+// package reads their `go test -bench` output and compares it against a stored baseline.
+package perf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Result is the measurement of one benchmark
+type Result struct {
+	Name        string  `json:"name"`
+	NsPerOp     float64 `json:"ns_per_op"`
+	BytesPerOp  int64   `json:"bytes_per_op"`
+	AllocsPerOp int64   `json:"allocs_per_op"`
+}
+
+// ParseBenchOutput reads the result lines of `go test -bench -benchmem` output, in the order
+// the benchmarks first ran. A benchmark run several times (-count) keeps its fastest run.
+func ParseBenchOutput(r io.Reader) ([]Result, error) {
+	var results []Result
+	index := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		result, ok, err := parseBenchLine(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if i, seen := index[result.Name]; !seen {
+			index[result.Name] = len(results)
+			results = append(results, result)
+		} else if result.NsPerOp < results[i].NsPerOp {
+			results[i] = result
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read benchmark output: %w", err)
+	}
+	return results, nil
+}
+
+// parseBenchLine parses one result line, such as
+//
+//	BenchmarkHammingDistance-8   4480609   54.79 ns/op   0 B/op   0 allocs/op
+//
+// ok is false for any other line
+func parseBenchLine(line string) (Result, bool, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") || fields[3] != "ns/op" {
+		return Result{}, false, nil
+	}
+	name := fields[0]
+	if i := strings.LastIndexByte(name, '-'); i > 0 {
+		if _, err := strconv.Atoi(name[i+1:]); err == nil {
+			name = name[:i] // The GOMAXPROCS suffix
+		}
+	}
+	result := Result{Name: name}
+	var err error
+	if result.NsPerOp, err = strconv.ParseFloat(fields[2], 64); err != nil {
+		return Result{}, false, fmt.Errorf("invalid ns/op in %q", line)
+	}
+	for i := 4; i+1 < len(fields); i += 2 {
+		value, err := strconv.ParseInt(fields[i], 10, 64)
+		switch {
+		case fields[i+1] == "B/op" && err == nil:
+			result.BytesPerOp = value
+		case fields[i+1] == "allocs/op" && err == nil:
+			result.AllocsPerOp = value
+		}
+	}
+	return result, true, nil
+}
@@ -0,0 +1,91 @@
+// Command perfcheck compares `go test -bench -benchmem` output against the stored performance
+// baseline, or records it as the new baseline. It is run by `make perf` and `make perf-baseline`.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/perf"
+)
+
+func main() {
+	var (
+		baselineFile = flag.String("baseline", "internal/perf/baseline.json", "Baseline to compare against")
+		update       = flag.Bool("update", false, "Record the benchmark output as the new baseline instead of comparing")
+		tolerance    = flag.Float64("tolerance", 0.25, "Allowed slowdown in ns/op before a benchmark regresses (0.25 = 25%)")
+	)
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: perfcheck [-baseline file] [-update] [-tolerance 0.25] [bench output file]")
+		fmt.Fprintln(os.Stderr, "Reads `go test -bench -benchmem` output from the file, or standard input")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *tolerance < 0 {
+		fatalf("-tolerance must not be negative")
+	}
+
+	var input io.Reader = os.Stdin
+	if flag.NArg() > 0 {
+		file, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fatalf("%v", err)
+		}
+		defer file.Close()
+		input = file
+	}
+	results, err := perf.ParseBenchOutput(input)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if len(results) == 0 {
+		fatalf("no benchmark results in the input")
+	}
+
+	if *update {
+		if err := perf.NewBaseline(results).Save(*baselineFile); err != nil {
+			fatalf("failed to save baseline: %v", err)
+		}
+		fmt.Printf("Baseline saved to: %s\n", *baselineFile)
+		return
+	}
+
+	baseline, err := perf.LoadBaseline(*baselineFile)
+	if err != nil {
+		fatalf("failed to load baseline: %v\nRecord one with 'make perf-baseline'.", err)
+	}
+	fmt.Printf("Baseline: %s (%s, %s, %d CPUs, recorded %s)\n", *baselineFile,
+		baseline.GoVersion, baseline.Platform, baseline.CPUs, baseline.Recorded.Format("2006-01-02"))
+	if !baseline.SamePlatform() {
+		fmt.Println("WARNING: The baseline was recorded with another Go version or platform; timings may not compare")
+	}
+	fmt.Printf("Compared to baseline (tolerance %.0f%%):\n", *tolerance*100)
+	regressions := 0
+	for _, c := range baseline.Compare(results, *tolerance) {
+		if c.Baseline == nil {
+			fmt.Printf("   %-34s no baseline\n", c.Name)
+			continue
+		}
+		status := "ok"
+		if c.Regressed {
+			status = "REGRESSED"
+			regressions++
+		}
+		fmt.Printf("   %-34s %12.1f -> %12.1f ns/op (%+6.1f%%)  %d -> %d allocs/op  %s\n", c.Name,
+			c.Baseline.NsPerOp, c.NsPerOp, c.TimeDelta*100, c.Baseline.AllocsPerOp, c.AllocsPerOp, status)
+	}
+	fmt.Println()
+
+	if regressions > 0 {
+		fmt.Printf("FAILED: %d benchmark(s) regressed\n", regressions)
+		os.Exit(1)
+	}
+	fmt.Println("PASSED: No performance regressions")
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "ERROR: "+format+"\n", args...)
+	os.Exit(2)
+}
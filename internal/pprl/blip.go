@@ -0,0 +1,63 @@
+// blip.go
+// BLIP (BLoom-and-flIP) perturbs a Bloom filter by permanent randomized response: every bit is
+// flipped independently with probability 1/(1+e^ε), so each bit is ε-differentially private.
+// The flips are drawn once at tokenization and stored with the token, so repeated runs do not
+// average them out. A q-gram sets up to k bits, so it is protected with a budget of k x ε.
+package pprl
+
+import (
+	"errors"
+	"math"
+)
+
+// BLIPFlipProbability returns the per-bit flip probability for a privacy budget epsilon
+func BLIPFlipProbability(epsilon float64) float64 {
+	return 1 / (1 + math.Exp(epsilon))
+}
+
+// AddBLIP flips every bit of the filter with probability p, using a generator seeded from crypto/rand
+func (bf *BloomFilter) AddBLIP(p float64) error {
+	if p < 0 || p > 0.5 {
+		return errors.New("bloom: BLIP flip probability must be between 0 and 0.5")
+	}
+	rng, err := NewDecoyRNG()
+	if err != nil {
+		return err
+	}
+	for idx := uint32(0); idx < bf.m; idx++ {
+		if rng.Float64() < p {
+			bf.bitArray[idx/64] ^= 1 << (idx % 64)
+		}
+	}
+	return nil
+}
+
+// ExpectedBLIPHamming returns the mean and standard deviation of the Hamming distance between two
+// m-bit filters of the same record after BLIP with flip probabilities p1 and p2. A matching
+// threshold below the mean cannot find most true matches.
+func ExpectedBLIPHamming(m uint32, p1, p2 float64) (float64, float64) {
+	// A bit differs when exactly one of the two copies was flipped
+	q := p1*(1-p2) + p2*(1-p1)
+	return float64(m) * q, math.Sqrt(float64(m) * q * (1 - q))
+}
+
+// ExpectedBLIPJaccard returns the approximate Jaccard similarity between two m-bit filters of the
+// same record with setBits bits set before BLIP with flip probabilities p1 and p2
+func ExpectedBLIPJaccard(m uint32, setBits, p1, p2 float64) float64 {
+	unset := float64(m) - setBits
+	intersection := setBits*(1-p1)*(1-p2) + unset*p1*p2
+	union := setBits*(1-p1*p2) + unset*(1-(1-p1)*(1-p2))
+	if union == 0 {
+		return 1
+	}
+	return intersection / union
+}
+
+// CleanSetBits estimates how many bits an m-bit filter had set before BLIP with flip probability p,
+// from the number of bits observed after it
+func CleanSetBits(m uint32, observed, p float64) float64 {
+	if p >= 0.5 {
+		return observed
+	}
+	return math.Max(0, (observed-float64(m)*p)/(1-2*p))
+}
@@ -5,6 +5,8 @@
 package pprl
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
 	"errors"
@@ -18,6 +20,7 @@ type BloomFilter struct {
 	m        uint32   // total number of bits
 	k        uint32   // number of hash functions
 	bitArray []uint64 // underlying bit array (length = ceil(m/64))
+	key      []byte   // optional HMAC key; never serialised
 }
 
 // NewBloomFilter returns an empty BloomFilter of m bits and k hashes.
@@ -55,25 +58,90 @@ func NewBloomFilterWithRandomBits(m, k uint32, randomBitsPercent float64) *Bloom
 	return bf
 }
 
+// NewKeyedBloomFilter returns an empty BloomFilter whose indices are derived
+// with HMAC-SHA256 under key, so tokens cannot be dictionary-attacked without it.
+func NewKeyedBloomFilter(m, k uint32, key []byte) *BloomFilter {
+	bf := NewBloomFilter(m, k)
+	if bf == nil {
+		return nil
+	}
+	bf.SetKey(key)
+	return bf
+}
+
+// SetKey sets the HMAC key used for subsequent Add and Test calls.
+// An empty key restores unkeyed FNV hashing.
+func (bf *BloomFilter) SetKey(key []byte) {
+	if len(key) == 0 {
+		bf.key = nil
+		return
+	}
+	bf.key = append([]byte(nil), key...)
+}
+
+// IsKeyed reports whether the filter uses HMAC-keyed hashing.
+func (bf *BloomFilter) IsKeyed() bool {
+	return len(bf.key) > 0
+}
+
 // Add inserts a byte-slice (e.g. a q-gram) into the filter.
 // Internally, it runs k different hash‐index computations.
 func (bf *BloomFilter) Add(data []byte) {
-	// For each i in [0..k), compute a hash and set bit at (h mod m).
+	bf.AddWithHashes(data, bf.k)
+}
+
+// AddWithHashes inserts data using k hash functions instead of the filter's own,
+// so a field can set more or fewer bits than the others (field weighting).
+// The first min(k, filter k) positions are the ones Add would set.
+func (bf *BloomFilter) AddWithHashes(data []byte, k uint32) {
+	for _, idx := range bf.hashIndices(data, k) {
+		bf.setBit(idx)
+	}
+}
+
+// indices returns the k bit positions for data.
+func (bf *BloomFilter) indices(data []byte) []uint32 {
+	return bf.hashIndices(data, bf.k)
+}
+
+// hashIndices returns k bit positions for data.
+func (bf *BloomFilter) hashIndices(data []byte, k uint32) []uint32 {
+	if bf.IsKeyed() {
+		return bf.keyedIndices(data, k)
+	}
+
+	// For each i in [0..k), compute a hash and take (h mod m).
 	h1 := fnv.New64a()
 	h1.Write(data)
 	sum := h1.Sum64()
 	seed := sum
 
-	for i := uint32(0); i < bf.k; i++ {
+	idxs := make([]uint32, k)
+	for i := uint32(0); i < k; i++ {
 		// Derive a second hash by appending the iteration index to seed.
 		h2 := fnv.New64a()
 		buf := make([]byte, 8)
 		binary.LittleEndian.PutUint64(buf, seed^(uint64(i)))
 		h2.Write(buf)
 		h2.Write(data)
-		idx := uint32(h2.Sum64() % uint64(bf.m))
-		bf.setBit(idx)
+		idxs[i] = uint32(h2.Sum64() % uint64(bf.m))
+	}
+	return idxs
+}
+
+// keyedIndices derives k positions by double hashing an HMAC-SHA256 digest of data.
+func (bf *BloomFilter) keyedIndices(data []byte, k uint32) []uint32 {
+	mac := hmac.New(sha256.New, bf.key)
+	mac.Write(data)
+	digest := mac.Sum(nil)
+	h1 := binary.LittleEndian.Uint64(digest[0:8])
+	h2 := binary.LittleEndian.Uint64(digest[8:16]) | 1
+
+	idxs := make([]uint32, k)
+	for i := uint32(0); i < k; i++ {
+		idxs[i] = uint32((h1 + uint64(i)*h2) % uint64(bf.m))
 	}
+	return idxs
 }
 
 // setBit flips the bit at position idx to 1.
@@ -85,18 +153,7 @@ func (bf *BloomFilter) setBit(idx uint32) {
 
 // Test returns true if data is "probably" in the filter. False => definitely not.
 func (bf *BloomFilter) Test(data []byte) bool {
-	h1 := fnv.New64a()
-	h1.Write(data)
-	sum := h1.Sum64()
-	seed := sum
-
-	for i := uint32(0); i < bf.k; i++ {
-		h2 := fnv.New64a()
-		buf := make([]byte, 8)
-		binary.LittleEndian.PutUint64(buf, seed^(uint64(i)))
-		h2.Write(buf)
-		h2.Write(data)
-		idx := uint32(h2.Sum64() % uint64(bf.m))
+	for _, idx := range bf.indices(data) {
 		if !bf.getBit(idx) {
 			return false
 		}
@@ -111,6 +168,11 @@ func (bf *BloomFilter) getBit(idx uint32) bool {
 	return (bf.bitArray[block] & (1 << offset)) != 0
 }
 
+// Bit reports whether bit idx of the filter is set
+func (bf *BloomFilter) Bit(idx uint32) bool {
+	return bf.getBit(idx)
+}
+
 // MarshalBinary serialises the BloomFilter into a byte slice:
 // first 4 bytes = m, next 4 bytes = k, then the bitArray as little‐endian uint64s.
 func (bf *BloomFilter) MarshalBinary() ([]byte, error) {
@@ -151,7 +213,11 @@ func (bf *BloomFilter) UnmarshalBinary(data []byte) error {
 // an additional fraction (probability p) of random bits to 1 or 0.
 func (bf *BloomFilter) AddWithNoise(data []byte, p float64) {
 	bf.Add(data)
+	bf.AddNoise(p)
+}
 
+// AddNoise flips a fraction p of the filter's bits at random positions.
+func (bf *BloomFilter) AddNoise(p float64) {
 	// Seed a fast PRNG for noise
 	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
 	totalBits := bf.m
@@ -180,11 +246,41 @@ func (bf *BloomFilter) HammingDistance(other *BloomFilter) (uint32, error) {
 	return dist, nil
 }
 
+// CompareWithin reports whether the Hamming distance to other is at most threshold. Counting
+// stops as soon as the distance exceeds threshold, so the returned distance is exact only when
+// within is true. Incompatible filters are never within.
+func (bf *BloomFilter) CompareWithin(other *BloomFilter, threshold uint32) (distance uint32, within bool) {
+	if bf.m != other.m || bf.k != other.k {
+		return 0, false
+	}
+	for i := range bf.bitArray {
+		distance += uint32(popcount(bf.bitArray[i] ^ other.bitArray[i]))
+		if distance > threshold {
+			return distance, false
+		}
+	}
+	return distance, true
+}
+
+// SetBitCount returns the number of bits set in the Bloom filter
+func (bf *BloomFilter) SetBitCount() int {
+	count := 0
+	for _, block := range bf.bitArray {
+		count += popcount(block)
+	}
+	return count
+}
+
 // GetSize returns the size (number of bits) of the Bloom filter
 func (bf *BloomFilter) GetSize() uint32 {
 	return bf.m
 }
 
+// HashCount returns the number of hash functions of the Bloom filter
+func (bf *BloomFilter) HashCount() uint32 {
+	return bf.k
+}
+
 // popcount returns the number of set bits in a uint64.
 func popcount(x uint64) int {
 	return bitsSetTable[x>>(0*16)&0xFFFF] +
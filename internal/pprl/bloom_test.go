@@ -0,0 +1,67 @@
+package pprl
+
+import (
+	"sort"
+	"testing"
+)
+
+// Benchmark fixture parameters; they match the default tokenization recipe
+const (
+	benchBloomSize   = 1000
+	benchBloomHashes = 5
+	benchMinHashSize = 128
+	benchQGramLength = 2
+	benchSalt        = "cohort-bridge-perf"
+)
+
+// benchFields are two versions of one patient differing by a typo, as a true match would
+var benchFields = [2][]string{
+	{"jonathan", "smithson", "1984-03-17", "m", "02139"},
+	{"johnathan", "smithson", "1984-03-17", "m", "02139"},
+}
+
+// benchQGrams returns the q-grams of a record's fields, as tokenization adds them to its filter
+func benchQGrams(fields []string) [][]byte {
+	var grams [][]byte
+	for _, field := range fields {
+		set := NewQGramSet(benchQGramLength, "")
+		set.ExtractQGrams(field)
+		for gram := range set.Grams {
+			grams = append(grams, []byte(gram))
+		}
+	}
+	sort.Slice(grams, func(i, j int) bool { return string(grams[i]) < string(grams[j]) })
+	return grams
+}
+
+// benchFilter returns a Bloom filter holding the q-grams of a record's fields
+func benchFilter(fields []string) *BloomFilter {
+	bf := NewBloomFilter(benchBloomSize, benchBloomHashes)
+	for _, gram := range benchQGrams(fields) {
+		bf.Add(gram)
+	}
+	return bf
+}
+
+// BenchmarkBloomFilterAdd measures adding one q-gram to a Bloom filter
+func BenchmarkBloomFilterAdd(b *testing.B) {
+	grams := benchQGrams(benchFields[0])
+	bf := NewBloomFilter(benchBloomSize, benchBloomHashes)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bf.Add(grams[i%len(grams)])
+	}
+}
+
+// BenchmarkHammingDistance measures the distance between two record filters
+func BenchmarkHammingDistance(b *testing.B) {
+	bf1, bf2 := benchFilter(benchFields[0]), benchFilter(benchFields[1])
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := bf1.HammingDistance(bf2); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
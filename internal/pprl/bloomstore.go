@@ -0,0 +1,316 @@
+// bloomstore.go
+// Package pprl provides a compact binary token file and a read-only BloomStore over it.
+// Records are stored as fixed-size raw Bloom filter words and MinHash signatures, so the store
+// memory-maps the file and compares filters in place by offset instead of decoding every
+// record's base64 filter into its own Go slices.
+//
+// File layout (all integers little-endian):
+//
+//	magic "CBBF" | version uint32 | m uint32 | k uint32 | s uint32 | reserved uint32
+//	count uint64 | ids offset uint64
+//	count records of ceil(m/64) uint64 filter words followed by s uint32 signature values
+//	count IDs, each a uint32 length followed by the ID bytes
+package pprl
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+)
+
+// BloomStoreExt is the conventional extension of binary token files
+const BloomStoreExt = ".cbbf"
+
+const (
+	bloomStoreMagic      = "CBBF"
+	bloomStoreVersion    = 1
+	bloomStoreHeaderSize = 40
+)
+
+// IsBloomStore reports whether path starts with the binary token file magic
+func IsBloomStore(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, len(bloomStoreMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return string(magic) == bloomStoreMagic
+}
+
+// BloomStoreWriter streams records into a binary token file. IDs are held in memory until
+// Close, which writes them after the records and completes the header.
+type BloomStoreWriter struct {
+	file    *os.File
+	w       *bufio.Writer
+	m, k, s uint32
+	ids     []string
+	buf     []byte
+}
+
+// NewBloomStoreWriter creates a binary token file for filters of m bits and k hashes and
+// MinHash signatures of length s
+func NewBloomStoreWriter(path string, m, k, s uint32) (*BloomStoreWriter, error) {
+	if m == 0 || k == 0 || s == 0 {
+		return nil, errors.New("bloomstore: invalid parameters")
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	w := &BloomStoreWriter{file: f, w: bufio.NewWriterSize(f, 1<<20), m: m, k: k, s: s}
+	// The header is rewritten with the final counts on Close
+	if _, err := w.w.Write(make([]byte, bloomStoreHeaderSize)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Append adds a record
+func (w *BloomStoreWriter) Append(id string, bf *BloomFilter, signature []uint32) error {
+	if bf.m != w.m || bf.k != w.k {
+		return fmt.Errorf("bloomstore: record %s has a %d-bit, %d-hash filter, expected %d/%d", id, bf.m, bf.k, w.m, w.k)
+	}
+	if uint32(len(signature)) != w.s {
+		return fmt.Errorf("bloomstore: record %s has a MinHash signature of %d values, expected %d", id, len(signature), w.s)
+	}
+
+	size := 8*len(bf.bitArray) + 4*len(signature)
+	if cap(w.buf) < size {
+		w.buf = make([]byte, size)
+	}
+	buf := w.buf[:size]
+	for i, word := range bf.bitArray {
+		binary.LittleEndian.PutUint64(buf[8*i:], word)
+	}
+	offset := 8 * len(bf.bitArray)
+	for i, value := range signature {
+		binary.LittleEndian.PutUint32(buf[offset+4*i:], value)
+	}
+	if _, err := w.w.Write(buf); err != nil {
+		return err
+	}
+	w.ids = append(w.ids, id)
+	return nil
+}
+
+// Close writes the IDs and the header and closes the file
+func (w *BloomStoreWriter) Close() error {
+	err := w.finish()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (w *BloomStoreWriter) finish() error {
+	stride := uint64(8*((w.m+63)/64) + 4*w.s)
+	idsOffset := uint64(bloomStoreHeaderSize) + uint64(len(w.ids))*stride
+	var length [4]byte
+	for _, id := range w.ids {
+		binary.LittleEndian.PutUint32(length[:], uint32(len(id)))
+		w.w.Write(length[:])
+		if _, err := w.w.WriteString(id); err != nil {
+			return err
+		}
+	}
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+
+	header := make([]byte, bloomStoreHeaderSize)
+	copy(header, bloomStoreMagic)
+	binary.LittleEndian.PutUint32(header[4:], bloomStoreVersion)
+	binary.LittleEndian.PutUint32(header[8:], w.m)
+	binary.LittleEndian.PutUint32(header[12:], w.k)
+	binary.LittleEndian.PutUint32(header[16:], w.s)
+	binary.LittleEndian.PutUint64(header[24:], uint64(len(w.ids)))
+	binary.LittleEndian.PutUint64(header[32:], idsOffset)
+	_, err := w.file.WriteAt(header, 0)
+	return err
+}
+
+// BloomStore gives read-only access to the records of a binary token file by index
+type BloomStore struct {
+	data    []byte // Mapped (or, where mapping is unavailable, read) file contents
+	mapped  bool
+	m, k, s uint32
+	words   int   // Filter words per record
+	stride  int   // Bytes per record
+	count   int   // Records
+	ids     []int // Offset of each record's ID length prefix in data
+}
+
+// OpenBloomStore maps a binary token file into memory
+func OpenBloomStore(path string) (*BloomStore, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < bloomStoreHeaderSize {
+		return nil, fmt.Errorf("bloomstore: %s is not a binary token file", path)
+	}
+
+	data, mapped, err := mapFile(f, int(info.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("bloomstore: failed to map %s: %w", path, err)
+	}
+	store := &BloomStore{data: data, mapped: mapped}
+	if err := store.parse(); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("bloomstore: %s: %w", path, err)
+	}
+	return store, nil
+}
+
+// parse validates the header and indexes the record IDs
+func (bs *BloomStore) parse() error {
+	if string(bs.data[:4]) != bloomStoreMagic {
+		return errors.New("not a binary token file")
+	}
+	if version := binary.LittleEndian.Uint32(bs.data[4:]); version != bloomStoreVersion {
+		return fmt.Errorf("unsupported version %d", version)
+	}
+	bs.m = binary.LittleEndian.Uint32(bs.data[8:])
+	bs.k = binary.LittleEndian.Uint32(bs.data[12:])
+	bs.s = binary.LittleEndian.Uint32(bs.data[16:])
+	count := binary.LittleEndian.Uint64(bs.data[24:])
+	idsOffset := binary.LittleEndian.Uint64(bs.data[32:])
+
+	bs.words = int((bs.m + 63) / 64)
+	bs.stride = 8*bs.words + 4*int(bs.s)
+	if bs.m == 0 || bs.s == 0 || count > uint64(len(bs.data))/uint64(bs.stride) ||
+		idsOffset != bloomStoreHeaderSize+count*uint64(bs.stride) || idsOffset > uint64(len(bs.data)) {
+		return errors.New("corrupt header")
+	}
+	bs.count = int(count)
+
+	bs.ids = make([]int, bs.count)
+	offset := int(idsOffset)
+	for i := range bs.ids {
+		if offset+4 > len(bs.data) {
+			return errors.New("truncated ID table")
+		}
+		length := int(binary.LittleEndian.Uint32(bs.data[offset:]))
+		if offset+4+length > len(bs.data) {
+			return errors.New("truncated ID table")
+		}
+		bs.ids[i] = offset
+		offset += 4 + length
+	}
+	return nil
+}
+
+// Close releases the mapping; the store must not be used afterwards
+func (bs *BloomStore) Close() error {
+	if bs.data == nil {
+		return nil
+	}
+	var err error
+	if bs.mapped {
+		err = unmapFile(bs.data)
+	}
+	bs.data = nil
+	return err
+}
+
+// Len returns the number of records
+func (bs *BloomStore) Len() int {
+	return bs.count
+}
+
+// Params returns the filter size, hash count and MinHash signature length of the records
+func (bs *BloomStore) Params() (m, k, s uint32) {
+	return bs.m, bs.k, bs.s
+}
+
+// Compatible reports whether records of the two stores can be compared
+func (bs *BloomStore) Compatible(other *BloomStore) bool {
+	return bs.m == other.m && bs.k == other.k && bs.s == other.s
+}
+
+// ID returns the ID of record i
+func (bs *BloomStore) ID(i int) string {
+	offset := bs.ids[i]
+	length := int(binary.LittleEndian.Uint32(bs.data[offset:]))
+	return string(bs.data[offset+4 : offset+4+length])
+}
+
+// record returns the raw filter words and signature of record i
+func (bs *BloomStore) record(i int) (filter, signature []byte) {
+	start := bloomStoreHeaderSize + i*bs.stride
+	split := start + 8*bs.words
+	return bs.data[start:split], bs.data[split : start+bs.stride]
+}
+
+// HammingDistance compares record i with record j of other without decoding either filter
+func (bs *BloomStore) HammingDistance(i int, other *BloomStore, j int) uint32 {
+	a, _ := bs.record(i)
+	b, _ := other.record(j)
+	var dist int
+	for w := 0; w < len(a); w += 8 {
+		dist += bits.OnesCount64(binary.LittleEndian.Uint64(a[w:]) ^ binary.LittleEndian.Uint64(b[w:]))
+	}
+	return uint32(dist)
+}
+
+// HammingWithin is the store equivalent of BloomFilter.CompareWithin: it stops counting once
+// the distance between record i and record j of other exceeds threshold
+func (bs *BloomStore) HammingWithin(i int, other *BloomStore, j int, threshold uint32) (distance uint32, within bool) {
+	a, _ := bs.record(i)
+	b, _ := other.record(j)
+	for w := 0; w < len(a); w += 8 {
+		distance += uint32(bits.OnesCount64(binary.LittleEndian.Uint64(a[w:]) ^ binary.LittleEndian.Uint64(b[w:])))
+		if distance > threshold {
+			return distance, false
+		}
+	}
+	return distance, true
+}
+
+// JaccardSimilarity estimates the similarity of record i and record j of other from their
+// MinHash signatures, as the fraction of equal signature values
+func (bs *BloomStore) JaccardSimilarity(i int, other *BloomStore, j int) float64 {
+	_, a := bs.record(i)
+	_, b := other.record(j)
+	equal := 0
+	for v := 0; v < len(a); v += 4 {
+		if binary.LittleEndian.Uint32(a[v:]) == binary.LittleEndian.Uint32(b[v:]) {
+			equal++
+		}
+	}
+	return float64(equal) / float64(bs.s)
+}
+
+// Filter decodes a copy of record i's Bloom filter
+func (bs *BloomStore) Filter(i int) *BloomFilter {
+	raw, _ := bs.record(i)
+	bf := &BloomFilter{m: bs.m, k: bs.k, bitArray: make([]uint64, bs.words)}
+	for w := range bf.bitArray {
+		bf.bitArray[w] = binary.LittleEndian.Uint64(raw[8*w:])
+	}
+	return bf
+}
+
+// Signature decodes a copy of record i's MinHash signature
+func (bs *BloomStore) Signature(i int) []uint32 {
+	_, raw := bs.record(i)
+	signature := make([]uint32, bs.s)
+	for v := range signature {
+		signature[v] = binary.LittleEndian.Uint32(raw[4*v:])
+	}
+	return signature
+}
@@ -0,0 +1,227 @@
+// columns.go
+// Column mappings let a site tokenize its own schema: each canonical field named in the field list
+// is read from a source column (given_name for FIRST, say) and cleaned up by transforms before
+// normalization. Mappings are local to a site; the peer only sees the resulting tokens.
+package pprl
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ColumnMapping reads canonical fields from the columns of a source schema. Fields without a
+// mapping are read from the column of the same name. A nil ColumnMapping reads every field as is.
+type ColumnMapping struct {
+	columns    map[string]string                // Source column per canonical field
+	transforms map[string][]string              // Transform specs per canonical field, as configured
+	apply      map[string][]func(string) string // Parsed transforms per canonical field
+}
+
+// NewColumnMapping creates a mapping from canonical fields to source columns and the transforms
+// applied, in order, to each column's values. It returns nil when columns is empty.
+func NewColumnMapping(columns map[string]string, transforms map[string][]string) (*ColumnMapping, error) {
+	if len(columns) == 0 {
+		return nil, nil
+	}
+	m := &ColumnMapping{
+		columns:    make(map[string]string, len(columns)),
+		transforms: make(map[string][]string),
+		apply:      make(map[string][]func(string) string),
+	}
+	for field, column := range columns {
+		column = strings.TrimSpace(column)
+		if column == "" {
+			return nil, fmt.Errorf("field %s: no source column", field)
+		}
+		m.columns[field] = column
+		for _, spec := range transforms[field] {
+			transform, err := parseTransform(spec)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", field, err)
+			}
+			m.transforms[field] = append(m.transforms[field], strings.TrimSpace(spec))
+			m.apply[field] = append(m.apply[field], transform)
+		}
+	}
+	for field := range transforms {
+		if _, ok := m.columns[field]; !ok {
+			return nil, fmt.Errorf("field %s: transforms without a source column", field)
+		}
+	}
+	return m, nil
+}
+
+// parseTransform returns the function of a transform spec such as "trim" or "first:5"
+func parseTransform(spec string) (func(string) string, error) {
+	name, arg, hasArg := strings.Cut(strings.TrimSpace(spec), ":")
+	name = strings.ToLower(name)
+	switch name {
+	case "trim", "upper", "lower", "squash", "digits", "letters", "alnum":
+		if hasArg {
+			return nil, fmt.Errorf("transform %s takes no argument", name)
+		}
+	case "first", "date":
+		if !hasArg || arg == "" {
+			return nil, fmt.Errorf("transform %s needs an argument, e.g. %s", name, transformExample(name))
+		}
+	default:
+		return nil, fmt.Errorf("unknown transform %q (use trim, upper, lower, squash, digits, letters, alnum, first:N or date:LAYOUT)", spec)
+	}
+
+	switch name {
+	case "trim":
+		return strings.TrimSpace, nil
+	case "upper":
+		return strings.ToUpper, nil
+	case "lower":
+		return strings.ToLower, nil
+	case "squash":
+		return func(v string) string { return strings.Join(strings.Fields(v), " ") }, nil
+	case "digits":
+		return keepRunes(unicode.IsDigit), nil
+	case "letters":
+		return keepRunes(unicode.IsLetter), nil
+	case "alnum":
+		return keepRunes(func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }), nil
+	case "first":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("transform first needs a positive length, got %q", arg)
+		}
+		return func(v string) string {
+			if runes := []rune(v); len(runes) > n {
+				return string(runes[:n])
+			}
+			return v
+		}, nil
+	case "date":
+		layout := arg
+		return func(v string) string {
+			if v = strings.TrimSpace(v); v == "" {
+				return ""
+			}
+			date, err := time.Parse(layout, v)
+			if err != nil {
+				return "" // Unparseable dates count as missing
+			}
+			return date.Format("2006-01-02")
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown transform %q", spec)
+}
+
+func transformExample(name string) string {
+	if name == "date" {
+		return "date:01/02/2006"
+	}
+	return "first:5"
+}
+
+// keepRunes returns a transform that drops every rune for which keep is false
+func keepRunes(keep func(rune) bool) func(string) string {
+	return func(v string) string {
+		return strings.Map(func(r rune) rune {
+			if keep(r) {
+				return r
+			}
+			return -1
+		}, v)
+	}
+}
+
+// Fields returns the mapped canonical fields other than the record ID, sorted
+func (m *ColumnMapping) Fields() []string {
+	if m == nil {
+		return nil
+	}
+	var fields []string
+	for field := range m.columns {
+		if field != "id" {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// Check verifies that every mapped column is among the source columns, and that every field
+// without a mapping has a column of its own name, so a schema mismatch is reported before any
+// record is tokenized
+func (m *ColumnMapping) Check(columns, fields []string) error {
+	if m == nil {
+		return nil
+	}
+	present := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		present[column] = true
+	}
+
+	var missing []string
+	for _, field := range m.sortedFields() {
+		if column := m.columns[field]; !present[column] {
+			missing = append(missing, fmt.Sprintf("%s (for %s)", column, field))
+		}
+	}
+	for _, field := range fields {
+		if _, mapped := m.columns[field]; !mapped && !present[field] {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		available := append([]string(nil), columns...)
+		sort.Strings(available)
+		return fmt.Errorf("source columns not found: %s (available: %s)", strings.Join(missing, ", "), strings.Join(available, ", "))
+	}
+	return nil
+}
+
+// Apply returns record with every mapped field set from its source column and transforms; the
+// source columns are kept, so unmapped fields are read as before
+func (m *ColumnMapping) Apply(record map[string]string) map[string]string {
+	if m == nil {
+		return record
+	}
+	mapped := make(map[string]string, len(record)+len(m.columns))
+	for column, value := range record {
+		mapped[column] = value
+	}
+	for field, column := range m.columns {
+		// Each value of a multi-valued column is transformed on its own
+		mapped[field] = mapValues(record[column], func(value string) string {
+			for _, transform := range m.apply[field] {
+				value = transform(value)
+			}
+			return value
+		})
+	}
+	return mapped
+}
+
+// String describes the mapping as "FIELD<-column[transform,...]" entries, sorted by field
+func (m *ColumnMapping) String() string {
+	if m == nil {
+		return ""
+	}
+	var entries []string
+	for _, field := range m.sortedFields() {
+		entry := field + "<-" + m.columns[field]
+		if transforms := m.transforms[field]; len(transforms) > 0 {
+			entry += "[" + strings.Join(transforms, ",") + "]"
+		}
+		entries = append(entries, entry)
+	}
+	return strings.Join(entries, " ")
+}
+
+func (m *ColumnMapping) sortedFields() []string {
+	fields := make([]string, 0, len(m.columns))
+	for field := range m.columns {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,46 @@
+// decoy.go
+// Decoy Bloom filters pad a tokenized dataset so a peer cannot learn its exact size.
+// A decoy sets as many bits as a real record, at random positions, so it looks like
+// a record but is practically never within a matching threshold of one.
+package pprl
+
+import (
+	crand "crypto/rand"
+	"errors"
+	"math/rand/v2"
+)
+
+// NewDecoyRNG returns a generator seeded from crypto/rand, so decoys cannot be predicted
+func NewDecoyRNG() (*rand.Rand, error) {
+	var seed [32]byte
+	if _, err := crand.Read(seed[:]); err != nil {
+		return nil, err
+	}
+	return rand.New(rand.NewChaCha8(seed)), nil
+}
+
+// NewDecoyBloomFilter returns a filter with the size and hash count of template and the
+// same number of bits set, at positions drawn from rng.
+func NewDecoyBloomFilter(template *BloomFilter, rng *rand.Rand) (*BloomFilter, error) {
+	if template == nil {
+		return nil, errors.New("bloom: nil template for decoy")
+	}
+	decoy := NewBloomFilter(template.m, template.k)
+	if decoy == nil {
+		return nil, errors.New("bloom: invalid template for decoy")
+	}
+
+	setBits := template.SetBitCount()
+
+	// Partial Fisher-Yates shuffle: the first setBits positions are a uniform random subset
+	positions := make([]uint32, template.m)
+	for i := range positions {
+		positions[i] = uint32(i)
+	}
+	for i := 0; i < setBits && i < len(positions); i++ {
+		j := i + rng.IntN(len(positions)-i)
+		positions[i], positions[j] = positions[j], positions[i]
+		decoy.setBit(positions[i])
+	}
+	return decoy, nil
+}
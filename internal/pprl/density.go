@@ -0,0 +1,75 @@
+// density.go
+// Bloom filter density is the share of bits set. As it approaches 1 the filters saturate: every
+// record sets nearly every bit, and unrelated records look similar.
+package pprl
+
+import "math"
+
+// densityBins is the resolution of DensityStats: a tenth of a percent
+const densityBins = 1000
+
+// Density returns the share of the filter's bits that are set
+func Density(bf *BloomFilter) float64 {
+	if bf.GetSize() == 0 {
+		return 0
+	}
+	return float64(bf.SetBitCount()) / float64(bf.GetSize())
+}
+
+// DensityStats summarizes the density of a stream of Bloom filters. Densities are kept in bins of
+// a tenth of a percent, so the summary does not grow with the number of filters.
+type DensityStats struct {
+	bins  [densityBins + 1]int
+	count int
+	sum   float64
+	max   float64
+}
+
+// Add counts a filter's density
+func (s *DensityStats) Add(bf *BloomFilter) {
+	density := Density(bf)
+	s.bins[int(math.Round(density*densityBins))]++
+	s.count++
+	s.sum += density
+	if density > s.max {
+		s.max = density
+	}
+}
+
+// Count returns the number of filters counted
+func (s *DensityStats) Count() int {
+	return s.count
+}
+
+// Mean returns the mean density, or 0 without filters
+func (s *DensityStats) Mean() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	return s.sum / float64(s.count)
+}
+
+// Max returns the highest density
+func (s *DensityStats) Max() float64 {
+	return s.max
+}
+
+// Percentile returns the density at or below which a share p (0-1) of the filters lie, to the
+// nearest tenth of a percent
+func (s *DensityStats) Percentile(p float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p * float64(s.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	seen := 0
+	for bin, n := range s.bins {
+		seen += n
+		if seen >= rank {
+			return float64(bin) / densityBins
+		}
+	}
+	return s.max
+}
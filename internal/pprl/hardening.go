@@ -0,0 +1,196 @@
+// hardening.go
+// Hardening post-processes a finished CLK so its bit patterns say less to a frequency attack,
+// which aligns frequent filters or frequently set bits with frequent names. Balancing appends the
+// complement of the filter and permutes the result, so every filter sets exactly half its bits;
+// XOR-folding XORs the two halves of the filter together, so no bit is set by one q-gram alone;
+// Rule 90 replaces every bit by the XOR of its two neighbours (Schnell and Borgs). Steps apply in
+// the listed order, and both parties must list the same steps.
+package pprl
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	mathrand "math/rand"
+	"strings"
+)
+
+// Hardening steps
+const (
+	HardeningBalance = "balance"  // Append the complement and permute: a fixed Hamming weight, twice the size
+	HardeningXORFold = "xor_fold" // XOR the two halves: half the size
+	HardeningRule90  = "rule90"   // XOR of each bit's neighbours, cyclically: the same size
+)
+
+// Hardening is the chain of hardening steps applied to every filter of a recipe. Both parties
+// derive the same balancing permutations from the shared seed and linkage secret.
+type Hardening struct {
+	steps []hardeningStep
+	size  uint32 // Size of the filters the chain is applied to
+}
+
+// hardeningStep is one step of a chain, with the size of the filters it is applied to
+type hardeningStep struct {
+	name        string
+	size        uint32
+	permutation []uint32 // Balancing: output position of each bit of the filter and its complement
+}
+
+// NewHardening builds the chain of steps for filters of size bits, returning nil when there are
+// no steps. XOR-folding needs an even size at its place in the chain.
+func NewHardening(steps []string, size uint32, seed string, key []byte) (*Hardening, error) {
+	if len(steps) == 0 {
+		return nil, nil
+	}
+	if size == 0 {
+		return nil, fmt.Errorf("filter size must be positive")
+	}
+
+	h := &Hardening{size: size}
+	seen := make(map[string]bool, len(steps))
+	for _, name := range steps {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if seen[name] {
+			return nil, fmt.Errorf("%s is listed twice", name)
+		}
+		seen[name] = true
+
+		step := hardeningStep{name: name, size: size}
+		switch name {
+		case HardeningBalance:
+			step.permutation = make([]uint32, 2*size)
+			for i := range step.permutation {
+				step.permutation[i] = uint32(i)
+			}
+			rng := hardeningRNG(seed, key, fmt.Sprintf("balance-%d", len(h.steps)))
+			rng.Shuffle(len(step.permutation), func(i, j int) {
+				step.permutation[i], step.permutation[j] = step.permutation[j], step.permutation[i]
+			})
+			size *= 2
+		case HardeningXORFold:
+			if size%2 != 0 || size < 2 {
+				return nil, fmt.Errorf("xor_fold needs an even filter size, got %d bits", size)
+			}
+			size /= 2
+		case HardeningRule90:
+			if size < 3 {
+				return nil, fmt.Errorf("rule90 needs a filter of at least 3 bits, got %d", size)
+			}
+		default:
+			return nil, fmt.Errorf("unknown step %q (use balance, xor_fold or rule90)", name)
+		}
+		h.steps = append(h.steps, step)
+	}
+	return h, nil
+}
+
+// Size returns the size of hardened filters, or size itself without hardening
+func (h *Hardening) Size(size uint32) uint32 {
+	if h == nil {
+		return size
+	}
+	for _, step := range h.steps {
+		switch step.name {
+		case HardeningBalance:
+			size *= 2
+		case HardeningXORFold:
+			size /= 2
+		}
+	}
+	return size
+}
+
+// DistanceScale returns the factor the chain multiplies Hamming distances by (1 without hardening)
+func (h *Hardening) DistanceScale() uint32 {
+	if h == nil {
+		return 1
+	}
+	steps := make([]string, len(h.steps))
+	for i, step := range h.steps {
+		steps[i] = step.name
+	}
+	return HardeningDistanceScale(steps)
+}
+
+// HardeningDistanceScale returns the factor a chain of steps multiplies Hamming distances by, so a
+// matcher can compare hardened filters against thresholds set for unhardened ones. Balancing
+// exactly doubles every distance; Rule 90 doubles distances between filters differing in few,
+// scattered bits, the ones near the threshold; XOR-folding leaves them mostly as they were, only
+// lowering those where differing bits meet in the fold.
+func HardeningDistanceScale(steps []string) uint32 {
+	scale := uint32(1)
+	for _, name := range steps {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case HardeningBalance, HardeningRule90:
+			scale *= 2
+		}
+	}
+	return scale
+}
+
+// Apply returns the hardened filter, or bf itself without hardening
+func (h *Hardening) Apply(bf *BloomFilter) (*BloomFilter, error) {
+	if h == nil {
+		return bf, nil
+	}
+	if bf.m != h.size {
+		return nil, fmt.Errorf("hardening: filter has %d bits, the recipe %d", bf.m, h.size)
+	}
+	for _, step := range h.steps {
+		switch step.name {
+		case HardeningBalance:
+			bf = step.balance(bf)
+		case HardeningXORFold:
+			bf = step.xorFold(bf)
+		case HardeningRule90:
+			bf = step.rule90(bf)
+		}
+	}
+	return bf, nil
+}
+
+// balance sets, for every bit of the filter, its position and the complement's, permuted
+func (step hardeningStep) balance(bf *BloomFilter) *BloomFilter {
+	out := NewBloomFilter(2*step.size, bf.k)
+	for i := uint32(0); i < step.size; i++ {
+		if bf.getBit(i) {
+			out.setBit(step.permutation[i])
+		} else {
+			out.setBit(step.permutation[step.size+i])
+		}
+	}
+	return out
+}
+
+// xorFold XORs the first half of the filter with the second
+func (step hardeningStep) xorFold(bf *BloomFilter) *BloomFilter {
+	half := step.size / 2
+	out := NewBloomFilter(half, bf.k)
+	for i := uint32(0); i < half; i++ {
+		if bf.getBit(i) != bf.getBit(half+i) {
+			out.setBit(i)
+		}
+	}
+	return out
+}
+
+// rule90 sets every bit to the XOR of its two neighbours, wrapping around at the ends
+func (step hardeningStep) rule90(bf *BloomFilter) *BloomFilter {
+	n := step.size
+	out := NewBloomFilter(n, bf.k)
+	for i := uint32(0); i < n; i++ {
+		if bf.getBit((i+n-1)%n) != bf.getBit((i+1)%n) {
+			out.setBit(i)
+		}
+	}
+	return out
+}
+
+// hardeningRNG returns the generator a balancing permutation is drawn from
+func hardeningRNG(seed string, key []byte, part string) *mathrand.Rand {
+	h := sha256.New()
+	h.Write([]byte("cohort-bridge-hardening\x00" + seed + "\x00" + part + "\x00"))
+	h.Write(key)
+	sum := h.Sum(nil)
+	return mathrand.New(mathrand.NewSource(int64(binary.BigEndian.Uint64(sum[:8]))))
+}
@@ -0,0 +1,19 @@
+package pprl
+
+import "testing"
+
+// BenchmarkMinHashComputeSignature measures the MinHash signature of a record filter
+func BenchmarkMinHashComputeSignature(b *testing.B) {
+	mh, err := NewMinHashSeeded(benchBloomSize, benchMinHashSize, benchSalt)
+	if err != nil {
+		b.Fatal(err)
+	}
+	bf := benchFilter(benchFields[0])
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := mh.ComputeSignature(bf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
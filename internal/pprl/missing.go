@@ -0,0 +1,71 @@
+// missing.go
+// Missing-data strategies decide how a record with an empty field is tokenized. Without one,
+// the record simply contributes fewer q-grams, which makes it look less similar to its match.
+package pprl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Missing-data strategies
+const (
+	MissingIgnore       = "ignore"       // Encode the remaining fields only (default)
+	MissingSkip         = "skip"         // Leave the record out, so none of its pairs is compared
+	MissingRedistribute = "redistribute" // Give the missing field's weight to the present fields
+	MissingImpute       = "impute"       // Encode a fixed value instead ("impute:VALUE")
+)
+
+// MissingDataPolicy holds the missing-data strategy of each field
+type MissingDataPolicy struct {
+	Default string            // Strategy for fields without an entry in Fields
+	Fields  map[string]string // Strategy per field name
+}
+
+// ParseMissingStrategy splits a strategy such as "impute:unknown" into the strategy and its value
+func ParseMissingStrategy(spec string) (string, string, error) {
+	strategy, value, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	strategy = strings.ToLower(strategy)
+	switch strategy {
+	case "", MissingIgnore:
+		return MissingIgnore, "", nil
+	case MissingSkip, MissingRedistribute:
+		return strategy, "", nil
+	case MissingImpute:
+		if value == "" {
+			return "", "", fmt.Errorf("missing-data strategy %q needs a value, e.g. impute:unknown", spec)
+		}
+		return strategy, value, nil
+	}
+	return "", "", fmt.Errorf("unknown missing-data strategy %q (use ignore, skip, redistribute or impute:VALUE)", spec)
+}
+
+// Validate checks every strategy of the policy
+func (p *MissingDataPolicy) Validate() error {
+	if _, _, err := ParseMissingStrategy(p.Default); err != nil {
+		return err
+	}
+	for field, spec := range p.Fields {
+		if _, _, err := ParseMissingStrategy(spec); err != nil {
+			return fmt.Errorf("field %s: %w", field, err)
+		}
+	}
+	return nil
+}
+
+// Strategy returns the strategy for field and, for impute, the value to encode.
+// A nil policy ignores missing fields.
+func (p *MissingDataPolicy) Strategy(field string) (string, string) {
+	if p == nil {
+		return MissingIgnore, ""
+	}
+	spec, ok := p.Fields[field]
+	if !ok {
+		spec = p.Default
+	}
+	strategy, value, err := ParseMissingStrategy(spec)
+	if err != nil {
+		return MissingIgnore, ""
+	}
+	return strategy, value
+}
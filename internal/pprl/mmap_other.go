@@ -0,0 +1,22 @@
+//go:build !(linux || darwin || freebsd || openbsd || netbsd || dragonfly)
+
+package pprl
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the file where memory mapping is not available; records are still compared
+// in place, so the contents are held once
+func mapFile(f *os.File, size int) ([]byte, bool, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, false, err
+	}
+	return data, false, nil
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,126 @@
+// multivalue.go
+// Multi-valued fields hold several values of one field of a record, such as prior surnames or
+// earlier addresses. Readers join the values with ValueSeparator, so records stay string maps;
+// tokenization hashes the q-grams of every value into the field's part of the Bloom filter, so a
+// record is similar to one carrying any of its values.
+package pprl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ValueSeparator separates the values of a multi-valued field within a record (ASCII unit
+// separator, which does not occur in text data)
+const ValueSeparator = "\x1f"
+
+// DefaultMultiValueDelimiter separates the values of a multi-valued CSV cell unless configured
+const DefaultMultiValueDelimiter = "|"
+
+// JoinValues joins the values of a multi-valued field, leaving out empty ones. The first value is
+// taken as the current one.
+func JoinValues(values []string) string {
+	kept := make([]string, 0, len(values))
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			kept = append(kept, value)
+		}
+	}
+	return strings.Join(kept, ValueSeparator)
+}
+
+// SplitValues returns the values of a field: one for a single-valued field, none when it is
+// empty
+func SplitValues(value string) []string {
+	if value == "" {
+		return nil
+	}
+	if !strings.Contains(value, ValueSeparator) {
+		return []string{value}
+	}
+	var values []string
+	for _, v := range strings.Split(value, ValueSeparator) {
+		if strings.TrimSpace(v) != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// FirstValue returns the current (first) value of a field
+func FirstValue(value string) string {
+	first, _, _ := strings.Cut(value, ValueSeparator)
+	return first
+}
+
+// mapValues applies fn to every value of a field
+func mapValues(value string, fn func(string) string) string {
+	if !strings.Contains(value, ValueSeparator) {
+		return fn(value)
+	}
+	values := strings.Split(value, ValueSeparator)
+	for i, v := range values {
+		values[i] = fn(v)
+	}
+	return JoinValues(values)
+}
+
+// MultiValueColumns splits the cells of source columns holding several delimiter-separated
+// values ("Smith|Jones") into the values of a multi-valued field. A nil MultiValueColumns keeps
+// every cell whole.
+type MultiValueColumns struct {
+	columns   map[string]bool
+	delimiter string
+}
+
+// NewMultiValueColumns splits the cells of columns at delimiter (DefaultMultiValueDelimiter if
+// empty). It returns nil when columns is empty.
+func NewMultiValueColumns(columns []string, delimiter string) (*MultiValueColumns, error) {
+	if len(columns) == 0 {
+		return nil, nil
+	}
+	if delimiter == "" {
+		delimiter = DefaultMultiValueDelimiter
+	}
+	if strings.TrimSpace(delimiter) == "" {
+		return nil, fmt.Errorf("the delimiter must not be whitespace, which separates the words of a value")
+	}
+	m := &MultiValueColumns{columns: make(map[string]bool, len(columns)), delimiter: delimiter}
+	for _, column := range columns {
+		column = strings.TrimSpace(column)
+		if column == "" {
+			return nil, fmt.Errorf("empty column name")
+		}
+		m.columns[column] = true
+	}
+	return m, nil
+}
+
+// Apply returns record with the cells of the multi-valued columns split into values
+func (m *MultiValueColumns) Apply(record map[string]string) map[string]string {
+	if m == nil {
+		return record
+	}
+	split := make(map[string]string, len(record))
+	for column, value := range record {
+		if m.columns[column] && strings.Contains(value, m.delimiter) {
+			value = JoinValues(strings.Split(value, m.delimiter))
+		}
+		split[column] = value
+	}
+	return split
+}
+
+// String describes the columns and delimiter as "column,column (split at |)"
+func (m *MultiValueColumns) String() string {
+	if m == nil {
+		return ""
+	}
+	columns := make([]string, 0, len(m.columns))
+	for column := range m.columns {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	return fmt.Sprintf("%s (split at %s)", strings.Join(columns, ","), m.delimiter)
+}
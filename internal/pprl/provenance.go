@@ -0,0 +1,48 @@
+// provenance.go
+// Record-level provenance: the source, row and batch each token was made from, written beside it
+// so that data stewards can trace a matched pair back to the exact source rows during an audit.
+// Provenance stays at the site; it is never sent to the peer.
+package pprl
+
+import "strconv"
+
+// Provenance columns of a token file, in order
+const (
+	SourceFileColumn = "source_file" // Input file, or the database table or query read
+	SourceRowColumn  = "source_row"  // 1-based position of the record in its source
+	BatchIDColumn    = "batch_id"    // Tokenization batch, by default the run ID
+)
+
+// ProvenanceColumns are the columns tokenization adds with provenance
+var ProvenanceColumns = []string{SourceFileColumn, SourceRowColumn, BatchIDColumn}
+
+// sourceRowKey carries a record's position in its source past the records left out before
+// tokenization; starting with the value separator, it cannot be a column name
+const sourceRowKey = ValueSeparator + SourceRowColumn
+
+// Provenance names the source and batch of the records being tokenized
+type Provenance struct {
+	SourceFile string
+	BatchID    string
+}
+
+// Number records the 1-based position of every record in its source, before an incremental run
+// or the skip strategy leaves any out. A nil Provenance numbers nothing.
+func (p *Provenance) Number(records []map[string]string) {
+	if p == nil {
+		return
+	}
+	for i, record := range records {
+		record[sourceRowKey] = strconv.Itoa(i + 1)
+	}
+}
+
+// Values returns the provenance columns of a record; one that was not numbered is taken to be
+// at position
+func (p *Provenance) Values(record map[string]string, position int) []string {
+	row, ok := record[sourceRowKey]
+	if !ok {
+		row = strconv.Itoa(position)
+	}
+	return []string{p.SourceFile, row, p.BatchID}
+}
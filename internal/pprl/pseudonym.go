@@ -0,0 +1,245 @@
+// pseudonym.go
+// Record ID modes decide which identifier a tokenized record carries. Pseudonyms replace the
+// source system's IDs (MRNs and the like) in token files, peer exchanges and results; the
+// mapping back to the original IDs is kept in a local file that never leaves the site.
+package pprl
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Record ID modes
+const (
+	IDPreserve     = "preserve"     // Keep the source IDs (default)
+	IDPseudonymize = "pseudonymize" // Random pseudonyms, reused for IDs already in the mapping file
+	IDHMAC         = "hmac"         // Pseudonyms derived from the ID with a local secret key
+)
+
+// pseudonymPrefix marks pseudonymized IDs
+const pseudonymPrefix = "pid-"
+
+// idMapHeader is the header of the mapping file
+var idMapHeader = []string{"original_id", "pseudonym"}
+
+// ParseIDMode validates a record ID mode, returning IDPreserve for an empty one
+func ParseIDMode(mode string) (string, error) {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	switch mode {
+	case "":
+		return IDPreserve, nil
+	case IDPreserve, IDPseudonymize, IDHMAC:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown record ID mode %q (use preserve, pseudonymize or hmac)", mode)
+}
+
+// IDMapper replaces record IDs with pseudonyms and keeps the reversible mapping in a local CSV
+// file of original_id,pseudonym rows. New entries are appended as they are created, so a
+// pseudonym is never written to a token file before its mapping is on disk. A nil IDMapper
+// preserves IDs.
+type IDMapper struct {
+	mode    string
+	mapFile string
+	key     []byte // HMAC key for IDHMAC
+
+	mu       sync.Mutex
+	loaded   bool
+	forward  map[string]string
+	reverse  map[string]string
+	appendTo *os.File
+	writer   *csv.Writer
+}
+
+// NewIDMapper creates a mapper for mode; it returns nil for IDPreserve. mapFile is resolved to an
+// absolute path now and read on first use; key is required for IDHMAC.
+func NewIDMapper(mode, mapFile string, key []byte) (*IDMapper, error) {
+	mode, err := ParseIDMode(mode)
+	if err != nil {
+		return nil, err
+	}
+	if mode == IDPreserve {
+		return nil, nil
+	}
+	if mode == IDHMAC && len(key) == 0 {
+		return nil, fmt.Errorf("record ID mode hmac needs a secret key")
+	}
+	if mapFile == "" {
+		return nil, fmt.Errorf("record ID mode %s needs a mapping file", mode)
+	}
+	abs, err := filepath.Abs(mapFile)
+	if err != nil {
+		return nil, err
+	}
+	return &IDMapper{mode: mode, mapFile: abs, key: key}, nil
+}
+
+// Mode returns the record ID mode
+func (m *IDMapper) Mode() string {
+	if m == nil {
+		return IDPreserve
+	}
+	return m.mode
+}
+
+// MapFile returns the path of the mapping file ("" when IDs are preserved)
+func (m *IDMapper) MapFile() string {
+	if m == nil {
+		return ""
+	}
+	return m.mapFile
+}
+
+// Pseudonym returns the pseudonym of id, recording new ones in the mapping file
+func (m *IDMapper) Pseudonym(id string) (string, error) {
+	if m == nil {
+		return id, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.load(); err != nil {
+		return "", err
+	}
+
+	existing, known := m.forward[id]
+	var pseudonym string
+	switch m.mode {
+	case IDHMAC:
+		mac := hmac.New(sha256.New, m.key)
+		mac.Write([]byte(id))
+		pseudonym = pseudonymPrefix + hex.EncodeToString(mac.Sum(nil)[:16])
+		if known && existing != pseudonym {
+			return "", fmt.Errorf("%s maps %s to a pseudonym not derived from this key; use a new mapping file after changing the key or mode", m.mapFile, id)
+		}
+	default:
+		if known {
+			return existing, nil
+		}
+		for {
+			random := make([]byte, 8)
+			if _, err := rand.Read(random); err != nil {
+				return "", err
+			}
+			pseudonym = pseudonymPrefix + hex.EncodeToString(random)
+			if _, taken := m.reverse[pseudonym]; !taken {
+				break
+			}
+		}
+	}
+	if known {
+		return pseudonym, nil
+	}
+
+	if other, taken := m.reverse[pseudonym]; taken {
+		return "", fmt.Errorf("pseudonym collision between record IDs %s and %s", other, id)
+	}
+	if err := m.append(id, pseudonym); err != nil {
+		return "", err
+	}
+	m.forward[id] = pseudonym
+	m.reverse[pseudonym] = id
+	return pseudonym, nil
+}
+
+// Original returns the record ID behind pseudonym, or pseudonym itself and false when the
+// mapping file does not hold it
+func (m *IDMapper) Original(pseudonym string) (string, bool, error) {
+	if m == nil {
+		return pseudonym, false, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.load(); err != nil {
+		return "", false, err
+	}
+	if id, ok := m.reverse[pseudonym]; ok {
+		return id, true, nil
+	}
+	return pseudonym, false, nil
+}
+
+// Close closes the mapping file
+func (m *IDMapper) Close() error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.appendTo == nil {
+		return nil
+	}
+	err := m.appendTo.Close()
+	m.appendTo, m.writer = nil, nil
+	return err
+}
+
+// load reads the mapping file, if it exists, once
+func (m *IDMapper) load() error {
+	if m.loaded {
+		return nil
+	}
+	m.forward = make(map[string]string)
+	m.reverse = make(map[string]string)
+
+	file, err := os.Open(m.mapFile)
+	if errors.Is(err, os.ErrNotExist) {
+		m.loaded = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open ID mapping file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = len(idMapHeader)
+	for line := 1; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid ID mapping file %s: %w", m.mapFile, err)
+		}
+		if line == 1 && row[0] == idMapHeader[0] {
+			continue
+		}
+		m.forward[row[0]] = row[1]
+		m.reverse[row[1]] = row[0]
+	}
+	m.loaded = true
+	return nil
+}
+
+// append writes one mapping to the end of the file, creating it with a header if needed
+func (m *IDMapper) append(id, pseudonym string) error {
+	if m.appendTo == nil {
+		if err := os.MkdirAll(filepath.Dir(m.mapFile), 0700); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(m.mapFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open ID mapping file: %w", err)
+		}
+		m.appendTo, m.writer = file, csv.NewWriter(file)
+		if info, err := file.Stat(); err == nil && info.Size() == 0 {
+			m.writer.Write(idMapHeader)
+		}
+	}
+	m.writer.Write([]string{id, pseudonym})
+	m.writer.Flush()
+	if err := m.writer.Error(); err != nil {
+		return fmt.Errorf("failed to write ID mapping file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+// rbf.go
+// Record-level Bloom filters (RBF, Durham et al.) encode each field into its own field-level
+// Bloom filter, then sample a weighted share of the record filter's bits from each one and
+// permute the result. Unlike a CLK, where a field's share of the bits follows from its length,
+// an RBF gives each field a fixed allocation, so recall-sensitive fields can be given more bits.
+package pprl
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	mathrand "math/rand"
+	"sort"
+)
+
+// Record encodings
+const (
+	EncodingCLK = "clk" // Cryptographic long-term key: all fields hashed into one filter (default)
+	EncodingRBF = "rbf" // Record-level Bloom filter with weighted bit allocation per field
+)
+
+// RBFLayout maps the bits of a record-level Bloom filter to bits of the field-level filters.
+// Both parties derive the same layout from the shared seed and linkage secret.
+type RBFLayout struct {
+	size      uint32     // Record filter size in bits
+	fieldSize uint32     // Field-level filter size in bits
+	segments  [][]uint32 // Per field position: the field filter bits sampled for its segment
+	order     []uint32   // Record filter position of each segment bit, in segment order
+}
+
+// NewRBFLayout allocates the size bits of a record filter to fields in proportion to weights
+// (one per field position) and draws the sampled and permuted bit positions from seed and key
+func NewRBFLayout(size, fieldSize uint32, weights []float64, seed string, key []byte) (*RBFLayout, error) {
+	if size == 0 || fieldSize == 0 {
+		return nil, errors.New("rbf: filter sizes must be positive")
+	}
+	if len(weights) == 0 {
+		return nil, errors.New("rbf: no fields to allocate bits to")
+	}
+	allocation, err := AllocateRBFBits(size, weights)
+	if err != nil {
+		return nil, err
+	}
+
+	layout := &RBFLayout{size: size, fieldSize: fieldSize, segments: make([][]uint32, len(weights))}
+	for position, bits := range allocation {
+		// Sample with replacement, as in Durham et al.
+		rng := layoutRNG(seed, key, fmt.Sprintf("segment-%d", position))
+		segment := make([]uint32, bits)
+		for i := range segment {
+			segment[i] = uint32(rng.Intn(int(fieldSize)))
+		}
+		layout.segments[position] = segment
+	}
+
+	// Permute the concatenated segments so a segment's position does not reveal its field
+	layout.order = make([]uint32, size)
+	for i := range layout.order {
+		layout.order[i] = uint32(i)
+	}
+	rng := layoutRNG(seed, key, "permutation")
+	rng.Shuffle(len(layout.order), func(i, j int) {
+		layout.order[i], layout.order[j] = layout.order[j], layout.order[i]
+	})
+	return layout, nil
+}
+
+// AllocateRBFBits splits size bits between fields in proportion to their weights; the bits lost
+// to rounding go to the fields with the largest remainders
+func AllocateRBFBits(size uint32, weights []float64) ([]uint32, error) {
+	total := 0.0
+	for i, weight := range weights {
+		if weight <= 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+			return nil, fmt.Errorf("rbf: field %d has invalid weight %g", i+1, weight)
+		}
+		total += weight
+	}
+
+	allocation := make([]uint32, len(weights))
+	remainders := make([]int, len(weights))
+	assigned := uint32(0)
+	for i, weight := range weights {
+		share := float64(size) * weight / total
+		allocation[i] = uint32(math.Floor(share))
+		assigned += allocation[i]
+		remainders[i] = i
+	}
+	sort.SliceStable(remainders, func(a, b int) bool {
+		shareA := float64(size) * weights[remainders[a]] / total
+		shareB := float64(size) * weights[remainders[b]] / total
+		return shareA-math.Floor(shareA) > shareB-math.Floor(shareB)
+	})
+	for i := 0; assigned < size; i++ {
+		allocation[remainders[i%len(remainders)]]++
+		assigned++
+	}
+	return allocation, nil
+}
+
+// Allocation returns the number of record filter bits given to each field position
+func (l *RBFLayout) Allocation() []uint32 {
+	allocation := make([]uint32, len(l.segments))
+	for i, segment := range l.segments {
+		allocation[i] = uint32(len(segment))
+	}
+	return allocation
+}
+
+// Fields returns the number of field positions in the layout
+func (l *RBFLayout) Fields() int {
+	return len(l.segments)
+}
+
+// Encode builds the record filter from fields, hashing each into its own field-level filter.
+// Field.Position selects the segment; positions without a field leave their bits unset.
+func (l *RBFLayout) Encode(fields []Field, config *RecordConfig) (*BloomFilter, error) {
+	fieldFilters := make([]*BloomFilter, len(l.segments))
+	qgs := NewQGramSet(config.QGramLength, config.QGramPadding)
+	for _, field := range fields {
+		if field.Position < 0 || field.Position >= len(l.segments) {
+			return nil, fmt.Errorf("rbf: field position %d outside the %d-field layout", field.Position, len(l.segments))
+		}
+		bf := fieldFilters[field.Position]
+		if bf == nil {
+			bf = NewKeyedBloomFilter(l.fieldSize, config.BloomHashes, config.LinkageSecret)
+			fieldFilters[field.Position] = bf
+		}
+		qgs.ExtractQGrams(NormalizeString(field.Value))
+		addWeightedQGramsToBloom(bf, qgs, WeightedHashes(config.BloomHashes, field.Weight))
+	}
+
+	record := NewBloomFilter(l.size, config.BloomHashes)
+	next := 0
+	for position, segment := range l.segments {
+		fieldFilter := fieldFilters[position]
+		for _, bit := range segment {
+			if fieldFilter != nil && fieldFilter.getBit(bit) {
+				record.setBit(l.order[next])
+			}
+			next++
+		}
+	}
+	return record, nil
+}
+
+// layoutRNG returns a deterministic generator for one part of the layout, seeded like NewMinHashSeeded
+func layoutRNG(seed string, key []byte, part string) *mathrand.Rand {
+	h := sha256.New()
+	h.Write([]byte("cohort-bridge-rbf\x00" + seed + "\x00" + part + "\x00"))
+	h.Write(key)
+	sum := h.Sum(nil)
+	return mathrand.New(mathrand.NewSource(int64(binary.BigEndian.Uint64(sum[:8]))))
+}
@@ -4,27 +4,94 @@ package pprl
 
 import (
 	"fmt"
+	"math"
+	"strings"
 )
 
 // RecordConfig holds configuration for record creation
 type RecordConfig struct {
-	BloomSize    uint32  // Size of Bloom filter in bits
-	BloomHashes  uint32  // Number of hash functions for Bloom filter
-	MinHashSize  uint32  // Size of MinHash signature
-	QGramLength  int     // Length of q-grams
-	QGramPadding string  // Padding character for q-grams
-	NoiseLevel   float64 // Probability of noise in Bloom filter (0-1)
-	Salt         string  // Salt for MinHash
+	BloomSize     uint32  // Size of Bloom filter in bits
+	BloomHashes   uint32  // Number of hash functions for Bloom filter
+	MinHashSize   uint32  // Size of MinHash signature
+	QGramLength   int     // Length of q-grams
+	QGramPadding  string  // Padding character for q-grams
+	NoiseLevel    float64 // Probability of noise in Bloom filter (0-1)
+	Epsilon       float64 // BLIP privacy budget per bit; replaces NoiseLevel when set (0 disables)
+	Salt          string  // Seed for deterministic MinHash (random if empty)
+	LinkageSecret []byte  // HMAC key for Bloom filter hashing (unkeyed if empty)
+	DateWeight    float64 // Weight of birthdate fields relative to the others (1 if zero)
+
+	// Nicknames maps a name to the canonical names added alongside it when name fields are
+	// normalized (nil disables nickname expansion)
+	Nicknames map[string][]string
+
+	// TextFold is applied to field values before normalization, folding non-ASCII text to the
+	// letters it is compared by (nil keeps values as they are)
+	TextFold func(string) string
+
+	MissingData *MissingDataPolicy // How empty fields are handled (nil ignores them)
+
+	Encoding     string             // Record encoding: EncodingCLK (default) or EncodingRBF
+	FieldWeights map[string]float64 // RBF bit allocation weight per lowercase field name (1 if absent)
+
+	// RBF encodes records as record-level Bloom filters with this layout instead of a CLK (nil).
+	// It is built from the field list by NewRBFLayout when Encoding is EncodingRBF.
+	RBF *RBFLayout
+
+	// Hardening post-processes every finished filter, after noise (nil leaves filters as built).
+	// It is built from tokenization.hardening by NewHardening.
+	Hardening *Hardening
+
+	Blocking []string // Blocking key expressions; tokenization adds a hashed key per expression
+	ExactID  string   // Source column of an identifier shared exactly; tokenization adds its keyed hash
+
+	MaxDensity    float64 // Mean Bloom filter density above which tokenization warns (0 never warns)
+	StrictDensity bool    // Fail tokenization instead of warning when MaxDensity is exceeded
+
+	IDs        *IDMapper          // Replaces record IDs with pseudonyms (nil preserves them)
+	IDColumns  *IDColumns         // Reads record IDs from the site's ID columns (nil reads the id column)
+	Columns    *ColumnMapping     // Reads fields from the columns of the site's schema (nil reads them by name)
+	MultiValue *MultiValueColumns // Splits delimiter-separated source cells into several values (nil keeps them whole)
+	Sanitizer  *Sanitizer         // Strips control characters and bounds value lengths (nil keeps values as they are)
+	Provenance *Provenance        // Adds source file, row and batch columns to tokens (nil adds none)
+	Watermark  *Watermark         // Tokenizes only records modified since a watermark (nil tokenizes all)
+}
+
+// FieldWeight returns the RBF bit allocation weight of a field
+func (c *RecordConfig) FieldWeight(field string) float64 {
+	if weight, ok := c.FieldWeights[strings.ToLower(field)]; ok {
+		return weight
+	}
+	return 1
 }
 
-// CreateRecord creates a new record from a set of fields
+// Field is a normalized field value and its weight in the record's Bloom filter.
+// A field's q-grams are hashed with weight x BloomHashes functions (at least one),
+// so heavier fields set more bits and count for more in the Hamming distance.
+// Each value of a multi-valued field is a Field of its own with the same Position.
+type Field struct {
+	Value    string
+	Weight   float64
+	Position int // Index of the field in the configured field list (selects its RBF segment)
+}
+
+// CreateRecord creates a new record from a set of equally weighted fields
 func CreateRecord(id string, fields []string, config *RecordConfig) (*Record, error) {
+	weighted := make([]Field, len(fields))
+	for i, value := range fields {
+		weighted[i] = Field{Value: value, Weight: 1, Position: i}
+	}
+	return CreateWeightedRecord(id, weighted, config)
+}
+
+// CreateWeightedRecord creates a new record from fields with individual weights
+func CreateWeightedRecord(id string, fields []Field, config *RecordConfig) (*Record, error) {
 	if config == nil {
 		return nil, fmt.Errorf("record: nil config")
 	}
 
 	// Create and populate Bloom filter
-	bf := NewBloomFilter(config.BloomSize, config.BloomHashes)
+	bf := NewKeyedBloomFilter(config.BloomSize, config.BloomHashes, config.LinkageSecret)
 	if bf == nil {
 		return nil, fmt.Errorf("record: failed to create bloom filter")
 	}
@@ -32,24 +99,37 @@ func CreateRecord(id string, fields []string, config *RecordConfig) (*Record, er
 	// Create q-gram set
 	qgs := NewQGramSet(config.QGramLength, config.QGramPadding)
 
-	// Process each field
-	for _, field := range fields {
-		// Normalize the field
-		normalized := NormalizeString(field)
-
-		// Extract q-grams
-		qgs.ExtractQGrams(normalized)
+	if config.RBF != nil {
+		// Each field gets its own filter, sampled into the record filter
+		var err error
+		if bf, err = config.RBF.Encode(fields, config); err != nil {
+			return nil, fmt.Errorf("record: %w", err)
+		}
+	} else {
+		// Process each field
+		for _, field := range fields {
+			// Normalize the field
+			normalized := NormalizeString(field.Value)
 
-		// Add to Bloom filter
-		if config.NoiseLevel > 0 {
-			bf.AddWithNoise([]byte(normalized), config.NoiseLevel)
-		} else {
-			bf.Add([]byte(normalized))
+			// Extract q-grams and add them to the Bloom filter
+			qgs.ExtractQGrams(normalized)
+			addWeightedQGramsToBloom(bf, qgs, WeightedHashes(config.BloomHashes, field.Weight))
 		}
 	}
 
+	// Apply noise once over the whole filter
+	if err := addRecordNoise(bf, config); err != nil {
+		return nil, fmt.Errorf("record: failed to add noise: %w", err)
+	}
+
+	// Harden the noisy filter; the MinHash is taken from the hardened one, since both are sent
+	bf, err := config.Hardening.Apply(bf)
+	if err != nil {
+		return nil, fmt.Errorf("record: %w", err)
+	}
+
 	// Create MinHash
-	mh, err := NewMinHash(config.BloomSize, config.MinHashSize)
+	mh, err := newRecordMinHash(config)
 	if err != nil {
 		return nil, fmt.Errorf("record: failed to create minhash: %w", err)
 	}
@@ -88,12 +168,19 @@ func ProcessRecord(record *Record, fields []string, config *RecordConfig) (*Reco
 	if config == nil {
 		return nil, fmt.Errorf("record: nil config")
 	}
+	if config.RBF != nil {
+		return nil, fmt.Errorf("record: record-level Bloom filters cannot be extended; encode all fields with CreateWeightedRecord")
+	}
+	if config.Hardening != nil {
+		return nil, fmt.Errorf("record: hardened Bloom filters cannot be extended; encode all fields with CreateWeightedRecord")
+	}
 
 	// Deserialize existing Bloom filter
 	bf, err := BloomFromBase64(record.BloomData)
 	if err != nil {
 		return nil, fmt.Errorf("record: failed to deserialize bloom filter: %w", err)
 	}
+	bf.SetKey(config.LinkageSecret)
 
 	// Deserialize existing q-gram set
 	qgs, err := QGramFromBase64(record.QGramData)
@@ -105,19 +192,18 @@ func ProcessRecord(record *Record, fields []string, config *RecordConfig) (*Reco
 	for _, field := range fields {
 		normalized := NormalizeString(field)
 
-		// Update q-grams
+		// Update q-grams and Bloom filter
 		qgs.ExtractQGrams(normalized)
+		addQGramsToBloom(bf, qgs)
+	}
 
-		// Update Bloom filter
-		if config.NoiseLevel > 0 {
-			bf.AddWithNoise([]byte(normalized), config.NoiseLevel)
-		} else {
-			bf.Add([]byte(normalized))
-		}
+	// Apply noise once over the whole filter
+	if err := addRecordNoise(bf, config); err != nil {
+		return nil, fmt.Errorf("record: failed to add noise: %w", err)
 	}
 
 	// Create new MinHash
-	mh, err := NewMinHash(config.BloomSize, config.MinHashSize)
+	mh, err := newRecordMinHash(config)
 	if err != nil {
 		return nil, fmt.Errorf("record: failed to create minhash: %w", err)
 	}
@@ -146,3 +232,50 @@ func ProcessRecord(record *Record, fields []string, config *RecordConfig) (*Reco
 		QGramData: qgramData,
 	}, nil
 }
+
+// addQGramsToBloom hashes every q-gram of the set into the Bloom filter
+func addQGramsToBloom(bf *BloomFilter, qgs *QGramSet) {
+	for gram := range qgs.Grams {
+		bf.Add([]byte(gram))
+	}
+}
+
+// addWeightedQGramsToBloom hashes every q-gram of the set into the Bloom filter with k hash functions
+func addWeightedQGramsToBloom(bf *BloomFilter, qgs *QGramSet, k uint32) {
+	for gram := range qgs.Grams {
+		bf.AddWithHashes([]byte(gram), k)
+	}
+}
+
+// WeightedHashes returns the number of hash functions for a field of the given weight
+func WeightedHashes(hashes uint32, weight float64) uint32 {
+	if weight <= 0 || weight == 1 {
+		return hashes
+	}
+	return uint32(math.Max(1, math.Round(float64(hashes)*weight)))
+}
+
+// addRecordNoise applies BLIP when an epsilon is configured, or the fixed noise level otherwise
+func addRecordNoise(bf *BloomFilter, config *RecordConfig) error {
+	if config.Epsilon > 0 {
+		return bf.AddBLIP(BLIPFlipProbability(config.Epsilon))
+	}
+	if config.NoiseLevel > 0 {
+		bf.AddNoise(config.NoiseLevel)
+	}
+	return nil
+}
+
+// FilterSize returns the size of the filters records are tokenized to: the Bloom filter size, or
+// the size of the hardened filters
+func (config *RecordConfig) FilterSize() uint32 {
+	return config.Hardening.Size(config.BloomSize)
+}
+
+// newRecordMinHash returns a MinHash seeded from config.Salt, or a random one if no salt is set
+func newRecordMinHash(config *RecordConfig) (*MinHash, error) {
+	if config.Salt != "" {
+		return NewMinHashSeeded(config.FilterSize(), config.MinHashSize, config.Salt)
+	}
+	return NewMinHash(config.FilterSize(), config.MinHashSize)
+}
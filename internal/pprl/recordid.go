@@ -0,0 +1,157 @@
+// recordid.go
+// A record's ID is read from its id column unless the site names another. Records identified by
+// several columns, such as an MRN that is only unique within a facility, get a composite ID: the
+// columns' values joined with a separator, optionally hashed so token files, peer exchanges and
+// results carry a fixed-length key instead of the joined values.
+package pprl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// DefaultIDSeparator joins the values of a composite ID
+const DefaultIDSeparator = "|"
+
+// IDColumns reads record IDs from the configured source columns. A nil IDColumns reads the id
+// column as is.
+type IDColumns struct {
+	columns   []string
+	separator string
+	hash      bool
+}
+
+// NewIDColumns creates the ID of records read from columns (id when empty), joined with separator
+// when there are several and replaced by their SHA-256 when hash is set. It returns nil for the
+// plain id column.
+func NewIDColumns(columns []string, separator string, hash bool) (*IDColumns, error) {
+	if separator == "" {
+		separator = DefaultIDSeparator
+	}
+	ids := &IDColumns{separator: separator, hash: hash}
+	seen := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		column = strings.TrimSpace(column)
+		if column == "" {
+			return nil, fmt.Errorf("empty ID column name")
+		}
+		if seen[column] {
+			return nil, fmt.Errorf("ID column %s is listed twice", column)
+		}
+		seen[column] = true
+		ids.columns = append(ids.columns, column)
+	}
+	if len(ids.columns) == 0 {
+		ids.columns = []string{"id"}
+	}
+	if len(ids.columns) == 1 && ids.columns[0] == "id" && !hash {
+		return nil, nil
+	}
+	return ids, nil
+}
+
+// Columns returns the source columns of the ID, in order
+func (c *IDColumns) Columns() []string {
+	if c == nil {
+		return []string{"id"}
+	}
+	return append([]string(nil), c.columns...)
+}
+
+// Composite reports whether IDs join several columns
+func (c *IDColumns) Composite() bool {
+	return c != nil && len(c.columns) > 1
+}
+
+// Hashed reports whether IDs are replaced by their SHA-256
+func (c *IDColumns) Hashed() bool {
+	return c != nil && c.hash
+}
+
+// Describe summarizes the ID for reports, e.g. "mrn|facility (SHA-256)"
+func (c *IDColumns) Describe() string {
+	description := strings.Join(c.Columns(), c.separatorOrDefault())
+	if c.Hashed() {
+		description += " (SHA-256)"
+	}
+	return description
+}
+
+func (c *IDColumns) separatorOrDefault() string {
+	if c == nil {
+		return DefaultIDSeparator
+	}
+	return c.separator
+}
+
+// Check verifies that every ID column is among the source columns
+func (c *IDColumns) Check(columns []string) error {
+	if c == nil {
+		return nil
+	}
+	present := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		present[column] = true
+	}
+	var missing []string
+	for _, column := range c.columns {
+		if !present[column] {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("ID columns not found: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// ID returns the ID of a source record, or "" when every ID column is empty. A composite ID with
+// some of its columns empty, or holding the separator, is an error: it could stand for another
+// record's ID.
+func (c *IDColumns) ID(record map[string]string) (string, error) {
+	if c == nil {
+		return record["id"], nil
+	}
+	values := make([]string, len(c.columns))
+	var empty []string
+	for i, column := range c.columns {
+		values[i] = strings.TrimSpace(record[column])
+		if values[i] == "" {
+			empty = append(empty, column)
+		} else if c.Composite() && strings.Contains(values[i], c.separator) {
+			return "", fmt.Errorf("ID column %s value %q contains the ID separator %q", column, values[i], c.separator)
+		}
+	}
+	if len(empty) == len(c.columns) {
+		return "", nil
+	}
+	if len(empty) > 0 {
+		return "", fmt.Errorf("composite ID is missing %s", strings.Join(empty, ", "))
+	}
+	return c.Key(strings.Join(values, c.separator)), nil
+}
+
+// Key returns the record ID of an ID written as the joined column values, such as the IDs of a
+// ground truth file: its SHA-256 when IDs are hashed, else the ID itself
+func (c *IDColumns) Key(id string) string {
+	if !c.Hashed() || id == "" {
+		return id
+	}
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// Split returns the column values of a composite ID, or false when IDs are hashed or not
+// composite, or id does not have a value per column
+func (c *IDColumns) Split(id string) ([]string, bool) {
+	if !c.Composite() || c.Hashed() {
+		return nil, false
+	}
+	values := strings.Split(id, c.separator)
+	if len(values) != len(c.columns) {
+		return nil, false
+	}
+	return values, true
+}
@@ -0,0 +1,115 @@
+// sanitize.go
+// Input sanitization bounds the field values that reach tokenization. A malformed row with a
+// multi-megabyte cell would otherwise be q-grammed in full and set most bits of its Bloom filter,
+// making it look similar to every record; invisible characters would keep equal values apart.
+package pprl
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Policies for field values longer than the limit
+const (
+	SanitizeTruncate = "truncate" // Cut the value to the limit and keep the record (default)
+	SanitizeReject   = "reject"   // Leave the record out
+)
+
+// DefaultMaxFieldLength is the longest field value, in characters, tokenized unless configured.
+// Names, dates and addresses are far shorter; longer values are malformed input.
+const DefaultMaxFieldLength = 256
+
+// Sanitization problems found in a field value
+const (
+	ViolationTooLong = "too_long"           // Longer than the limit
+	ViolationControl = "control_characters" // Held control or invisible formatting characters
+)
+
+// Sanitizer cleans field values before normalization. A nil Sanitizer keeps them as they are.
+type Sanitizer struct {
+	MaxLength    int    // Longest value in characters (0 = no limit)
+	StripControl bool   // Remove control and invisible formatting characters
+	OnTooLong    string // SanitizeTruncate or SanitizeReject
+	RejectsFile  string // CSV report of the values truncated and the records rejected (empty for none)
+}
+
+// NewSanitizer validates a sanitization policy; maxLength 0 takes the default and a negative
+// one disables the limit
+func NewSanitizer(maxLength int, stripControl bool, onTooLong, rejectsFile string) (*Sanitizer, error) {
+	switch {
+	case maxLength == 0:
+		maxLength = DefaultMaxFieldLength
+	case maxLength < 0:
+		maxLength = 0
+	}
+	switch onTooLong = strings.ToLower(strings.TrimSpace(onTooLong)); onTooLong {
+	case "":
+		onTooLong = SanitizeTruncate
+	case SanitizeTruncate, SanitizeReject:
+	default:
+		return nil, fmt.Errorf("unknown policy %q for values over the length limit (use truncate or reject)", onTooLong)
+	}
+	return &Sanitizer{MaxLength: maxLength, StripControl: stripControl, OnTooLong: onTooLong, RejectsFile: rejectsFile}, nil
+}
+
+// Clean returns value with control characters stripped and cut to the length limit, and the
+// problems found. Tabs and line breaks become spaces, so the words they separated stay apart.
+// With the reject policy, the record of a value reported as ViolationTooLong is to be left out.
+func (s *Sanitizer) Clean(value string) (string, []string) {
+	if s == nil {
+		return value, nil
+	}
+	var violations []string
+	if s.StripControl && strings.IndexFunc(value, isStripped) >= 0 {
+		value = strings.Map(func(r rune) rune {
+			switch {
+			case r == '\t' || r == '\n' || r == '\r' || r == '\v' || r == '\f':
+				return ' '
+			case isStripped(r):
+				return -1
+			}
+			return r
+		}, value)
+		violations = append(violations, ViolationControl)
+	}
+	if s.MaxLength > 0 && len(value) > s.MaxLength && utf8.RuneCountInString(value) > s.MaxLength {
+		violations = append(violations, ViolationTooLong)
+		if s.OnTooLong == SanitizeTruncate {
+			value = truncateRunes(value, s.MaxLength)
+		}
+	}
+	return value, violations
+}
+
+// Rejects reports whether a value with these problems leaves its record out
+func (s *Sanitizer) Rejects(violations []string) bool {
+	return s != nil && s.OnTooLong == SanitizeReject && containsViolation(violations, ViolationTooLong)
+}
+
+// isStripped reports whether a character is a control character (C0, DEL, C1) or an invisible
+// formatting one such as a zero-width space or byte order mark
+func isStripped(r rune) bool {
+	return unicode.IsControl(r) || unicode.Is(unicode.Cf, r)
+}
+
+// truncateRunes returns the first n characters of value
+func truncateRunes(value string, n int) string {
+	for i := range value {
+		if n == 0 {
+			return value[:i]
+		}
+		n--
+	}
+	return value
+}
+
+func containsViolation(violations []string, violation string) bool {
+	for _, v := range violations {
+		if v == violation {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+// secret.go
+// Package pprl provides loading of the per-linkage secret used to key Bloom filter hashing.
+package pprl
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// MinLinkageSecretLen is the minimum accepted linkage secret length in bytes
+const MinLinkageSecretLen = 16
+
+// LoadLinkageSecret reads a linkage secret from path. The file may hold the
+// secret hex-encoded (e.g. from `openssl rand -hex 32`) or as raw bytes.
+func LoadLinkageSecret(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("secret: failed to read linkage secret: %w", err)
+	}
+
+	secret := []byte(strings.TrimSpace(string(data)))
+	if decoded, err := hex.DecodeString(string(secret)); err == nil {
+		secret = decoded
+	}
+
+	if len(secret) < MinLinkageSecretLen {
+		return nil, fmt.Errorf("secret: linkage secret must be at least %d bytes, got %d", MinLinkageSecretLen, len(secret))
+	}
+	return secret, nil
+}
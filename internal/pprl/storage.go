@@ -9,6 +9,7 @@ import (
 	"encoding/json"
 	"errors"
 	"os"
+	"strings"
 )
 
 // Record wraps everything we need to persist per patient (no PHI anywhere).
@@ -17,6 +18,19 @@ type Record struct {
 	BloomData string   `json:"bloom"`   // base64-encoded BloomFilter bytes
 	MinHash   []uint32 `json:"minhash"` // signature
 	QGramData string   `json:"qgram"`   // base64-encoded QGramSet data
+
+	BlockingKeys []string `json:"blocking,omitempty"` // Hashed blocking key of each pass the record takes part in
+	ExactID      string   `json:"exact_id,omitempty"` // Keyed hash of the record's exact identifier (tokenization.exact_id)
+}
+
+// JoinBlockingKeys encodes blocking keys as the blocking column of a token file
+func JoinBlockingKeys(keys []string) string {
+	return strings.Join(keys, " ")
+}
+
+// SplitBlockingKeys decodes the blocking column of a token file
+func SplitBlockingKeys(column string) []string {
+	return strings.Fields(column)
 }
 
 // Storage writes and reads Record entries to/from a JSON‐line file.
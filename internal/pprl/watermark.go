@@ -0,0 +1,95 @@
+package pprl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// watermarkLayouts are the timestamp forms accepted in watermark columns, tried in order
+var watermarkLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseWatermark parses a watermark value: an RFC 3339 timestamp, a date and time without a zone
+// (read as UTC), a date, or Unix seconds
+func ParseWatermark(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range watermarkLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp %q (expected RFC 3339, YYYY-MM-DD[ HH:MM:SS] or Unix seconds)", value)
+}
+
+// Watermark selects the records of an incremental run: those whose watermark column, such as a
+// last-modified timestamp, is later than Since. Records without a readable watermark are always
+// selected, since nothing shows they were seen before.
+type Watermark struct {
+	Column string    // Source column holding each record's last-modified time
+	Since  time.Time // Records modified at or before this time are left out (zero selects all)
+
+	latest  time.Time // Latest watermark of the records filtered so far
+	undated int       // Records selected because their watermark was missing or unreadable
+}
+
+// Filter returns the records modified after Since, noting the latest watermark among all records
+func (w *Watermark) Filter(records []map[string]string) []map[string]string {
+	var selected []map[string]string
+	for _, record := range records {
+		t, ok := w.timestamp(record)
+		if !ok {
+			w.undated++
+			selected = append(selected, record)
+			continue
+		}
+		if t.After(w.latest) {
+			w.latest = t
+		}
+		if t.After(w.Since) {
+			selected = append(selected, record)
+		}
+	}
+	return selected
+}
+
+// timestamp reads the watermark of a record; the column is matched regardless of case
+func (w *Watermark) timestamp(record map[string]string) (time.Time, bool) {
+	value, ok := record[w.Column]
+	if !ok {
+		for name, v := range record {
+			if strings.EqualFold(name, w.Column) {
+				value, ok = v, true
+				break
+			}
+		}
+	}
+	if !ok || strings.TrimSpace(value) == "" {
+		return time.Time{}, false
+	}
+	t, err := ParseWatermark(value)
+	return t, err == nil
+}
+
+// Latest returns the watermark the next incremental run starts from: the latest one seen, or
+// Since when no record is later
+func (w *Watermark) Latest() time.Time {
+	if w.latest.After(w.Since) {
+		return w.latest
+	}
+	return w.Since
+}
+
+// Undated returns how many records were selected without a readable watermark
+func (w *Watermark) Undated() int {
+	return w.undated
+}
@@ -0,0 +1,53 @@
+package profile
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Mask hides a value for display, keeping only its shape: the first letter of every word, the
+// first two digits of the value's first run of four or more digits (the century of a date, the
+// region of a ZIP code), and all punctuation and spacing. "John Doe" becomes "J*** D**",
+// "1980-03-05" becomes "19**-**-**" and "12345" becomes "12***".
+func Mask(value string) string {
+	var masked strings.Builder
+	runes := []rune(value)
+	inWord, digitRuns := false, 0
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case unicode.IsDigit(r):
+			end := i
+			for end < len(runes) && unicode.IsDigit(runes[end]) {
+				end++
+			}
+			keep := 0
+			if digitRuns == 0 && end-i >= 4 {
+				keep = 2
+			}
+			digitRuns++
+			for j := i; j < end; j++ {
+				if j-i < keep {
+					masked.WriteRune(runes[j])
+				} else {
+					masked.WriteByte('*')
+				}
+			}
+			i = end - 1
+			inWord = false
+		case unicode.IsMark(r) && inWord:
+			// Combining accents belong to a masked letter
+		case unicode.IsLetter(r):
+			if inWord {
+				masked.WriteByte('*')
+			} else {
+				masked.WriteRune(r)
+			}
+			inWord = true
+		default:
+			masked.WriteRune(r)
+			inWord = false
+		}
+	}
+	return masked.String()
+}
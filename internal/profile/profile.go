@@ -0,0 +1,519 @@
+// profile.go
+// Package profile measures the data quality of a raw dataset before it is tokenized: how often
+// each field is missing or invalid, encoding damage, suspected duplicates and how well the fields
+// tell records apart. Poor values on any of these lower linkage quality in ways that cannot be
+// diagnosed once the data has been turned into Bloom filters.
+package profile
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/crypto"
+)
+
+// Quality grades
+const (
+	QualityGood = "good"
+	QualityFair = "fair"
+	QualityPoor = "poor"
+)
+
+// maxExamples is the number of example record ID groups kept per duplicate kind
+const maxExamples = 5
+
+// placeholders are values entered when the real one was not known
+var placeholders = map[string]bool{
+	"unknown": true, "unk": true, "none": true, "null": true, "na": true, "n/a": true, "nil": true,
+	"test": true, "xxx": true, "x": true, "?": true, "-": true,
+	"fnu": true, "lnu": true, "nfn": true, "nln": true, "baby": true, "babyboy": true, "babygirl": true,
+	"00000": true, "99999": true, "12345": true,
+	"1900-01-01": true, "1901-01-01": true, "1800-01-01": true, "9999-12-31": true,
+}
+
+// Field is a field to profile and the normalization method it is tokenized with
+type Field struct {
+	Name   string
+	Method crypto.NormalizationMethod
+}
+
+// FieldReport is the quality of one field
+type FieldReport struct {
+	Name    string `json:"name"`
+	Method  string `json:"method"`
+	Present int    `json:"present"`
+	Missing int    `json:"missing"`
+	Invalid int    `json:"invalid"`
+
+	MissingRate float64 `json:"missing_rate"`
+	InvalidRate float64 `json:"invalid_rate"`
+
+	InvalidReasons map[string]int `json:"invalid_reasons,omitempty"`
+	EncodingIssues map[string]int `json:"encoding_issues,omitempty"`
+
+	Distinct int     `json:"distinct"`
+	TopValue string  `json:"top_value,omitempty"` // Most common normalized value
+	TopShare float64 `json:"top_share"`           // Share of present values equal to TopValue
+
+	// ChanceAgreement is the probability that two random records with the field present agree on
+	// it (the Fellegi-Sunter u-probability); low values make the field discriminating
+	ChanceAgreement float64 `json:"chance_agreement"`
+}
+
+// Duplicates are groups of records suspected to be the same person
+type Duplicates struct {
+	Groups   int        `json:"groups"`
+	Records  int        `json:"records"`            // Records in the groups
+	Examples [][]string `json:"examples,omitempty"` // Record IDs of the first groups
+}
+
+// NearDuplicates are pairs of records that agree on every field but one
+type NearDuplicates struct {
+	Pairs    int            `json:"pairs"`
+	ByField  map[string]int `json:"by_field,omitempty"` // Pairs per field they differ on
+	Examples [][]string     `json:"examples,omitempty"`
+}
+
+// Report is the data-quality report of a dataset
+type Report struct {
+	Records int           `json:"records"`
+	Fields  []FieldReport `json:"fields"`
+
+	DuplicateIDs    Duplicates     `json:"duplicate_ids"`
+	ExactDuplicates Duplicates     `json:"exact_duplicates"`
+	NearDuplicates  NearDuplicates `json:"near_duplicates"`
+
+	// CompleteRecords is the share of records with every field present and valid
+	CompleteRecords float64 `json:"complete_records"`
+	// Completeness is the mean share of present and valid values over the fields
+	Completeness float64 `json:"completeness"`
+	// ChanceAgreements is the expected number of pairs of different people agreeing on every
+	// field by chance; at 1 or more the fields cannot tell some people apart
+	ChanceAgreements float64 `json:"expected_chance_agreements"`
+
+	Quality  string   `json:"predicted_quality"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Profiler accumulates records and builds their report
+type Profiler struct {
+	fields  []Field
+	reports []FieldReport
+	counts  []map[string]int    // Normalized value counts per field
+	headers []string            // Warnings about the column names
+	fold    func(string) string // Unicode folding applied before normalization (nil for none)
+
+	ids      []string
+	values   [][]string // Normalized values per record
+	complete int
+}
+
+// New creates a profiler for fields, folding values with fold (nil for none) before normalizing
+// them as tokenize does
+func New(fields []Field, fold func(string) string) *Profiler {
+	p := &Profiler{fields: fields, fold: fold}
+	for _, field := range fields {
+		method := string(field.Method)
+		if method == "" {
+			method = "basic"
+		}
+		p.reports = append(p.reports, FieldReport{
+			Name:           field.Name,
+			Method:         method,
+			InvalidReasons: make(map[string]int),
+			EncodingIssues: make(map[string]int),
+		})
+		p.counts = append(p.counts, make(map[string]int))
+	}
+	return p
+}
+
+// CheckColumns looks for encoding damage in the column names of the source, such as a UTF-8 byte
+// order mark glued to the first column, which hides that column from every lookup
+func (p *Profiler) CheckColumns(columns []string) {
+	for i, column := range columns {
+		if i == 0 && strings.HasPrefix(column, "\ufeff") {
+			p.headers = append(p.headers, fmt.Sprintf("column %q starts with a UTF-8 byte order mark; save the file without BOM", strings.TrimPrefix(column, "\ufeff")))
+			continue
+		}
+		if issue := encodingIssue(column); issue != "" {
+			p.headers = append(p.headers, fmt.Sprintf("column %q: %s", column, issue))
+		}
+	}
+}
+
+// Add profiles one record, keyed by field name with its ID under "id"
+func (p *Profiler) Add(record map[string]string) {
+	normalized := make([]string, len(p.fields))
+	complete := true
+	for i, field := range p.fields {
+		report := &p.reports[i]
+		raw := record[field.Name]
+		if issue := encodingIssue(raw); issue != "" {
+			report.EncodingIssues[issue]++
+		}
+		if strings.TrimSpace(raw) == "" {
+			report.Missing++
+			complete = false
+			continue
+		}
+		report.Present++
+
+		value := raw
+		if p.fold != nil {
+			value = p.fold(value)
+		}
+		value = crypto.NormalizeField(value, field.Method)
+		if reason := invalidReason(raw, value, field.Method); reason != "" {
+			report.InvalidReasons[reason]++
+			report.Invalid++
+			complete = false
+		}
+		if value != "" {
+			p.counts[i][value]++
+		}
+		normalized[i] = value
+	}
+	if complete {
+		p.complete++
+	}
+	p.ids = append(p.ids, record["id"])
+	p.values = append(p.values, normalized)
+}
+
+// invalidReason returns why a present value is unusable for linkage, or ""
+func invalidReason(raw, normalized string, method crypto.NormalizationMethod) string {
+	lower := strings.ToLower(strings.TrimSpace(raw))
+	switch method {
+	case crypto.NormDate, crypto.NormBirthdate:
+		date, err := time.Parse("2006-01-02", crypto.NormalizeDate(strings.TrimSpace(raw)))
+		switch {
+		case err != nil:
+			return "unparseable date"
+		case placeholders[date.Format("2006-01-02")]:
+			return "placeholder"
+		case date.Year() < 1900:
+			return "before 1900"
+		case date.After(time.Now()):
+			return "future date"
+		}
+	case crypto.NormZip:
+		switch {
+		case placeholders[normalized]:
+			return "placeholder"
+		case len(normalized) != 5:
+			return "not 5 digits"
+		}
+	case crypto.NormGender:
+		if normalized == "u" {
+			switch lower {
+			case "u", "unknown", "unspecified", "prefer not to say":
+				return "unknown"
+			}
+			return "unrecognized"
+		}
+	case crypto.NormName, crypto.NormSoundex, crypto.NormMetaphone, crypto.NormNYSIIS:
+		name := crypto.NormalizeName(raw)
+		switch {
+		case strings.IndexFunc(raw, unicode.IsDigit) >= 0:
+			return "digits in name"
+		case name == "":
+			return "no letters"
+		case placeholders[strings.ReplaceAll(name, " ", "")]:
+			return "placeholder"
+		case utf8.RuneCountInString(name) == 1:
+			return "initial only"
+		}
+	default:
+		if placeholders[lower] {
+			return "placeholder"
+		}
+	}
+	return ""
+}
+
+// encodingIssue returns the kind of character encoding damage in value, or ""
+func encodingIssue(value string) string {
+	switch {
+	case !utf8.ValidString(value):
+		return "invalid UTF-8"
+	case strings.ContainsRune(value, utf8.RuneError):
+		return "replacement character"
+	case isMojibake(value):
+		return "mojibake"
+	case strings.IndexFunc(value, func(r rune) bool { return unicode.IsControl(r) && r != '\t' }) >= 0:
+		return "control character"
+	}
+	return ""
+}
+
+// isMojibake spots UTF-8 text that was decoded as Latin-1 or Windows-1252 (é read as "Ã©")
+func isMojibake(value string) bool {
+	if strings.Contains(value, "â€") {
+		return true
+	}
+	runes := []rune(value)
+	for i := 0; i+1 < len(runes); i++ {
+		if (runes[i] == 'Ã' || runes[i] == 'Â') && runes[i+1] >= 0x80 && runes[i+1] <= 0xBF {
+			return true
+		}
+	}
+	return false
+}
+
+// Report finishes the per-field statistics, finds duplicates and predicts linkage quality
+func (p *Profiler) Report() *Report {
+	report := &Report{Records: len(p.values), Warnings: append([]string(nil), p.headers...)}
+	if report.Records == 0 {
+		report.Quality = QualityPoor
+		report.Warnings = append(report.Warnings, "no records to profile")
+		return report
+	}
+	records := float64(report.Records)
+
+	chance := records * (records - 1) / 2
+	var completeness float64
+	for i := range p.reports {
+		field := &p.reports[i]
+		field.MissingRate = float64(field.Missing) / records
+		field.InvalidRate = float64(field.Invalid) / records
+		field.Distinct = len(p.counts[i])
+
+		var agreement float64
+		present := 0
+		for _, count := range p.counts[i] {
+			present += count
+		}
+		for value, count := range p.counts[i] {
+			share := float64(count) / float64(present)
+			agreement += share * share
+			if count > p.counts[i][field.TopValue] || (count == p.counts[i][field.TopValue] && value < field.TopValue) {
+				field.TopValue = value
+			}
+		}
+		if present > 0 {
+			field.TopShare = float64(p.counts[i][field.TopValue]) / float64(present)
+		}
+		field.ChanceAgreement = agreement
+		chance *= agreement
+		completeness += float64(field.Present-field.Invalid) / records
+
+		if len(field.InvalidReasons) == 0 {
+			field.InvalidReasons = nil
+		}
+		if len(field.EncodingIssues) == 0 {
+			field.EncodingIssues = nil
+		}
+	}
+	report.Fields = p.reports
+	report.Completeness = completeness / float64(len(p.reports))
+	report.CompleteRecords = float64(p.complete) / records
+	report.ChanceAgreements = chance
+
+	report.DuplicateIDs = p.duplicateIDs()
+	report.ExactDuplicates = p.exactDuplicates()
+	report.NearDuplicates = p.nearDuplicates()
+	report.Quality, report.Warnings = p.assess(report, report.Warnings)
+	return report
+}
+
+// duplicateIDs groups records sharing a non-empty ID
+func (p *Profiler) duplicateIDs() Duplicates {
+	byID := make(map[string][]string)
+	for _, id := range p.ids {
+		if id != "" {
+			byID[id] = append(byID[id], id)
+		}
+	}
+	duplicates := collectGroups(byID)
+	for i, group := range duplicates.Examples {
+		duplicates.Examples[i] = group[:1]
+	}
+	return duplicates
+}
+
+// exactDuplicates groups records whose normalized fields are all equal (and not all missing)
+func (p *Profiler) exactDuplicates() Duplicates {
+	byKey := make(map[string][]string)
+	for i, values := range p.values {
+		if strings.Join(values, "") == "" {
+			continue
+		}
+		key := strings.Join(values, "\x1f")
+		byKey[key] = append(byKey[key], p.recordID(i))
+	}
+	return collectGroups(byKey)
+}
+
+// nearDuplicates counts pairs of records that agree on every other field (all present) and
+// differ on exactly one: typos, swapped digits and outdated addresses
+func (p *Profiler) nearDuplicates() NearDuplicates {
+	near := NearDuplicates{ByField: make(map[string]int)}
+	if len(p.fields) < 2 {
+		return near
+	}
+	for differing := range p.fields {
+		groups := make(map[string][]int)
+		for i, values := range p.values {
+			key, ok := keyWithout(values, differing)
+			if !ok {
+				continue
+			}
+			groups[key] = append(groups[key], i)
+		}
+
+		keys := make([]string, 0, len(groups))
+		for key, members := range groups {
+			if len(members) > 1 {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			members := groups[key]
+			// Pairs in the group minus those that also agree on the differing field
+			byValue := make(map[string]int)
+			for _, i := range members {
+				byValue[p.values[i][differing]]++
+			}
+			pairs := len(members) * (len(members) - 1) / 2
+			for _, count := range byValue {
+				pairs -= count * (count - 1) / 2
+			}
+			if pairs == 0 {
+				continue
+			}
+			near.Pairs += pairs
+			near.ByField[p.fields[differing].Name] += pairs
+			if len(near.Examples) < maxExamples {
+				for _, j := range members[1:] {
+					if p.values[j][differing] != p.values[members[0]][differing] {
+						near.Examples = append(near.Examples, []string{p.recordID(members[0]), p.recordID(j)})
+						break
+					}
+				}
+			}
+		}
+	}
+	if len(near.ByField) == 0 {
+		near.ByField = nil
+	}
+	return near
+}
+
+// keyWithout joins the values of every field but skip, reporting false if any of them is missing
+func keyWithout(values []string, skip int) (string, bool) {
+	parts := make([]string, 0, len(values)-1)
+	for i, value := range values {
+		if i == skip {
+			continue
+		}
+		if value == "" {
+			return "", false
+		}
+		parts = append(parts, value)
+	}
+	return strings.Join(parts, "\x1f"), true
+}
+
+// recordID returns the ID of record i, or its 1-based row number when it has none
+func (p *Profiler) recordID(i int) string {
+	if p.ids[i] != "" {
+		return p.ids[i]
+	}
+	return fmt.Sprintf("row %d", i+1)
+}
+
+// collectGroups counts the groups with more than one member, keeping examples in key order
+func collectGroups(groups map[string][]string) Duplicates {
+	var duplicates Duplicates
+	keys := make([]string, 0, len(groups))
+	for key, members := range groups {
+		if len(members) > 1 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		duplicates.Groups++
+		duplicates.Records += len(groups[key])
+		if len(duplicates.Examples) < maxExamples {
+			duplicates.Examples = append(duplicates.Examples, groups[key])
+		}
+	}
+	return duplicates
+}
+
+// assess grades the dataset and explains what will hurt linkage
+func (p *Profiler) assess(report *Report, warnings []string) (string, []string) {
+	records := float64(report.Records)
+	encodingDamage := false
+	for _, field := range report.Fields {
+		if field.MissingRate > 0.10 {
+			warnings = append(warnings, fmt.Sprintf("%s is missing in %.1f%% of records; consider tokenization.missing_data for it", field.Name, 100*field.MissingRate))
+		}
+		if field.InvalidRate > 0.05 {
+			warnings = append(warnings, fmt.Sprintf("%s is invalid in %.1f%% of records (%s)", field.Name, 100*field.InvalidRate, FormatCounts(field.InvalidReasons)))
+		}
+		if len(field.EncodingIssues) > 0 {
+			encodingDamage = true
+			warnings = append(warnings, fmt.Sprintf("%s has character encoding damage (%s); re-export the data as UTF-8", field.Name, FormatCounts(field.EncodingIssues)))
+		}
+		if field.Present > 0 && field.TopShare > 0.5 && field.Method != string(crypto.NormGender) {
+			warnings = append(warnings, fmt.Sprintf("%.0f%% of %s values are %q, which tells records apart poorly", 100*field.TopShare, field.Name, field.TopValue))
+		}
+	}
+	if report.ChanceAgreements >= 1 {
+		warnings = append(warnings, fmt.Sprintf("about %s pairs of different people are expected to agree on every field; add a more discriminating field", formatFloat(report.ChanceAgreements)))
+	}
+	if report.DuplicateIDs.Groups > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d record IDs are used by more than one record", report.DuplicateIDs.Groups))
+	}
+	if report.ExactDuplicates.Groups > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d records are exact duplicates of another; run dedupe on the tokens or clean the source", report.ExactDuplicates.Records-report.ExactDuplicates.Groups))
+	}
+	if report.NearDuplicates.Pairs > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d record pairs differ in a single field and may be the same person", report.NearDuplicates.Pairs))
+	}
+
+	duplicateRate := float64(report.ExactDuplicates.Records-report.ExactDuplicates.Groups) / records
+	switch {
+	case report.Completeness < 0.85 || report.ChanceAgreements >= 1:
+		return QualityPoor, warnings
+	case report.Completeness < 0.95 || encodingDamage || duplicateRate > 0.01 || report.DuplicateIDs.Groups > 0:
+		return QualityFair, warnings
+	}
+	return QualityGood, warnings
+}
+
+// FormatCounts lists counts as "reason n, reason n", largest first
+func FormatCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = fmt.Sprintf("%s %d", key, counts[key])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatFloat prints small expectations with useful precision and large ones rounded
+func formatFloat(v float64) string {
+	if v >= 100 || v == math.Trunc(v) {
+		return fmt.Sprintf("%.0f", v)
+	}
+	return fmt.Sprintf("%.2g", v)
+}
@@ -0,0 +1,100 @@
+// client.go
+// The party side of a relay: joining a session and waiting for the peer to be paired.
+package relay
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultPort is used when a relay URL names no port
+const DefaultPort = "7700"
+
+// URL identifies a relay and the session to join on it: relay://host[:port]/session
+type URL struct {
+	Address string // host:port of the relay
+	Session string
+}
+
+// ParseURL parses a relay URL
+func ParseURL(raw string) (*URL, error) {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid relay URL %q: %v", raw, err)
+	}
+	if parsed.Scheme != "relay" || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid relay URL %q (expected relay://host[:port]/session)", raw)
+	}
+	address := parsed.Host
+	if parsed.Port() == "" {
+		address = net.JoinHostPort(parsed.Hostname(), DefaultPort)
+	}
+	session := strings.Trim(parsed.Path, "/")
+	if !sessionPattern.MatchString(session) {
+		return nil, fmt.Errorf("invalid relay session %q in %s (letters, digits, '.', '_' and '-')", session, raw)
+	}
+	return &URL{Address: address, Session: session}, nil
+}
+
+func (u *URL) String() string {
+	return "relay://" + u.Address + "/" + u.Session
+}
+
+// Addr is the remote address of a relayed connection: the session, not the relay's socket
+type Addr struct {
+	URL *URL
+}
+
+func (a Addr) Network() string { return "relay" }
+func (a Addr) String() string  { return a.URL.String() }
+
+// conn is a connection paired through the relay
+type conn struct {
+	net.Conn
+	addr Addr
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// Dial connects to the relay, joins the session with role and waits up to wait for the peer. It
+// returns the paired connection and the role the relay assigned.
+func Dial(u *URL, role string, timeout, wait time.Duration) (net.Conn, string, error) {
+	raw, err := net.DialTimeout("tcp", u.Address, timeout)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := fmt.Fprintf(raw, "%s %s %s\n", Protocol, u.Session, role); err != nil {
+		raw.Close()
+		return nil, "", err
+	}
+
+	if wait > 0 {
+		raw.SetReadDeadline(time.Now().Add(wait))
+	}
+	reply, err := readLine(raw)
+	raw.SetReadDeadline(time.Time{})
+	if err != nil {
+		raw.Close()
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil, "", fmt.Errorf("no peer joined %s within %s", u, wait)
+		}
+		return nil, "", fmt.Errorf("relay closed the connection: %v", err)
+	}
+
+	fields := strings.Fields(reply)
+	switch {
+	case len(fields) == 2 && fields[0] == "PAIRED" && (fields[1] == RoleServer || fields[1] == RoleClient):
+		return &conn{Conn: raw, addr: Addr{URL: u}}, fields[1], nil
+	case len(fields) > 0 && fields[0] == "ERROR":
+		raw.Close()
+		return nil, "", fmt.Errorf("relay refused %s: %s", u, strings.TrimSpace(strings.TrimPrefix(reply, "ERROR")))
+	default:
+		raw.Close()
+		return nil, "", fmt.Errorf("unexpected relay reply %q", reply)
+	}
+}
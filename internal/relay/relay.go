@@ -0,0 +1,275 @@
+// relay.go
+// Package relay lets two pprl parties that cannot accept inbound connections meet through a
+// broker both dial out to. Each party opens a TCP connection to the relay and names a session;
+// the relay pairs the two connections of a session and copies bytes between them without
+// interpreting them. The peer protocol runs end to end over the pair, so TLS, peer
+// authentication and payload encryption all apply as on a direct connection.
+package relay
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Protocol is the first word of every hello line
+const Protocol = "CBRELAY/1"
+
+// Roles a party asks for in its hello. The first party to reach the relay with RoleAny becomes the
+// server of the pair; a party reconnecting after a network failure asks for the role it had.
+const (
+	RoleAny    = "any"
+	RoleServer = "server"
+	RoleClient = "client"
+)
+
+// maxLineSize bounds the hello and its reply, which carry only a few words
+const maxLineSize = 512
+
+var sessionPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// Options configure a relay Server
+type Options struct {
+	WaitTimeout time.Duration // Longest a party waits for its peer (default 10m)
+	MaxSessions int           // Sessions waiting or paired at once (default 64)
+	AllowedIPs  []*net.IPNet  // Networks allowed to connect (empty allows all)
+	Logf        func(format string, args ...interface{})
+}
+
+// Server pairs the connections of each session and forwards bytes between them
+type Server struct {
+	opts Options
+
+	mu       sync.Mutex
+	waiting  map[string]*waiter // Party waiting for its peer, by session
+	sessions int                // Sessions waiting or paired
+}
+
+// waiter is a party whose peer has not connected yet
+type waiter struct {
+	conn  net.Conn
+	role  string
+	taken bool          // Set by pair, under the lock, when the peer arrives
+	gone  chan struct{} // Closed once wait no longer reads from conn
+}
+
+// NewServer creates a relay
+func NewServer(opts Options) *Server {
+	if opts.WaitTimeout <= 0 {
+		opts.WaitTimeout = 10 * time.Minute
+	}
+	if opts.MaxSessions <= 0 {
+		opts.MaxSessions = 64
+	}
+	if opts.Logf == nil {
+		opts.Logf = log.Printf
+	}
+	return &Server{opts: opts, waiting: make(map[string]*waiter)}
+}
+
+// Serve accepts parties on listener until it is closed
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle reads a party's hello, then pairs it or parks it until its peer arrives
+func (s *Server) handle(conn net.Conn) {
+	if !s.allowed(conn.RemoteAddr()) {
+		s.opts.Logf("relay: rejected %s (not in allowed IPs)", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+
+	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	session, role, err := readHello(conn)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		s.opts.Logf("relay: rejected %s: %v", conn.RemoteAddr(), err)
+		fmt.Fprintf(conn, "ERROR %s\n", err)
+		conn.Close()
+		return
+	}
+
+	peer, assigned, err := s.pair(session, role, conn)
+	if err != nil {
+		s.opts.Logf("relay: rejected %s for session %s: %v", conn.RemoteAddr(), session, err)
+		fmt.Fprintf(conn, "ERROR %s\n", err)
+		conn.Close()
+		return
+	}
+	if assigned == "" {
+		s.wait(session, peer)
+		return
+	}
+
+	// The waiting party's goroutine has stopped reading; announce the roles and forward
+	peerRole := RoleServer
+	if assigned == RoleServer {
+		peerRole = RoleClient
+	}
+	fmt.Fprintf(peer.conn, "PAIRED %s\n", peerRole)
+	fmt.Fprintf(conn, "PAIRED %s\n", assigned)
+	s.opts.Logf("relay: session %s paired (%s as %s, %s as %s)", session, peer.conn.RemoteAddr(), peerRole, conn.RemoteAddr(), assigned)
+	s.forward(session, peer.conn, conn)
+}
+
+// pair matches a new party with the one waiting in its session and returns the waiting party and
+// the new party's role. When no one is waiting it parks the new party and returns its waiter with
+// no role.
+func (s *Server) pair(session, role string, conn net.Conn) (*waiter, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.waiting[session]
+	if !ok {
+		if s.sessions >= s.opts.MaxSessions {
+			return nil, "", fmt.Errorf("relay is full (%d sessions)", s.opts.MaxSessions)
+		}
+		// The deadline is set here, under the lock, so a peer arriving at once can cut it short
+		conn.SetReadDeadline(time.Now().Add(s.opts.WaitTimeout))
+		w = &waiter{conn: conn, role: role, gone: make(chan struct{})}
+		s.waiting[session] = w
+		s.sessions++
+		return w, "", nil
+	}
+
+	assigned := RoleClient
+	switch {
+	case role == RoleAny && w.role == RoleClient, role == RoleServer && w.role != RoleServer:
+		assigned = RoleServer
+	case role == RoleClient && w.role == RoleClient, role == RoleServer && w.role == RoleServer:
+		return nil, "", fmt.Errorf("session %s already has a %s waiting", session, w.role)
+	}
+
+	// Stop the waiting party's read, which watches for it hanging up, before handing over its
+	// connection
+	w.taken = true
+	w.conn.SetReadDeadline(time.Now())
+	<-w.gone
+	w.conn.SetReadDeadline(time.Time{})
+	delete(s.waiting, session)
+	return w, assigned, nil
+}
+
+// wait parks a party until its peer arrives, it hangs up, or the wait times out
+func (s *Server) wait(session string, w *waiter) {
+	conn := w.conn
+	s.opts.Logf("relay: %s waiting in session %s", conn.RemoteAddr(), session)
+
+	// A party sends nothing before it is paired, so a read returns only when it hangs up, the
+	// wait times out or pair interrupts it
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	close(w.gone)
+
+	s.mu.Lock()
+	if w.taken {
+		s.mu.Unlock()
+		return // The peer's goroutine owns the connection now
+	}
+	delete(s.waiting, session)
+	s.sessions--
+	s.mu.Unlock()
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		s.opts.Logf("relay: session %s timed out after %s without a peer", session, s.opts.WaitTimeout)
+		fmt.Fprintf(conn, "ERROR no peer joined session %s within %s\n", session, s.opts.WaitTimeout)
+	} else {
+		s.opts.Logf("relay: %s left session %s before its peer arrived", conn.RemoteAddr(), session)
+	}
+	conn.Close()
+}
+
+// forward copies bytes both ways until either party hangs up, then closes both
+func (s *Server) forward(session string, a, b net.Conn) {
+	var wg sync.WaitGroup
+	var sent [2]int64
+	copyHalf := func(i int, dst, src net.Conn) {
+		defer wg.Done()
+		sent[i], _ = io.Copy(dst, src)
+		dst.Close()
+		src.Close()
+	}
+	wg.Add(2)
+	go copyHalf(0, b, a)
+	go copyHalf(1, a, b)
+	wg.Wait()
+
+	s.mu.Lock()
+	s.sessions--
+	s.mu.Unlock()
+	s.opts.Logf("relay: session %s closed (%d and %d bytes forwarded)", session, sent[0], sent[1])
+}
+
+// allowed reports whether a connecting address is in the allowed networks
+func (s *Server) allowed(remote net.Addr) bool {
+	if len(s.opts.AllowedIPs) == 0 {
+		return true
+	}
+	tcpAddr, ok := remote.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range s.opts.AllowedIPs {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// readHello reads and checks a party's hello
+func readHello(conn net.Conn) (session, role string, err error) {
+	line, err := readLine(conn)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read hello: %v", err)
+	}
+	return parseHello(line)
+}
+
+// parseHello checks a hello line
+func parseHello(line string) (session, role string, err error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 || fields[0] != Protocol {
+		return "", "", fmt.Errorf("expected %q", Protocol+" <session> <role>")
+	}
+	session, role = fields[1], fields[2]
+	if !sessionPattern.MatchString(session) {
+		return "", "", fmt.Errorf("invalid session name %q (letters, digits, '.', '_' and '-')", session)
+	}
+	switch role {
+	case RoleAny, RoleServer, RoleClient:
+	default:
+		return "", "", fmt.Errorf("invalid role %q", role)
+	}
+	return session, role, nil
+}
+
+// readLine reads one line a byte at a time, so nothing after it is consumed; the peer protocol
+// follows on the same connection
+func readLine(conn net.Conn) (string, error) {
+	var line []byte
+	var b [1]byte
+	for {
+		if _, err := conn.Read(b[:]); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return strings.TrimSpace(string(line)), nil
+		}
+		if len(line) >= maxLineSize {
+			return "", fmt.Errorf("line too long")
+		}
+		line = append(line, b[0])
+	}
+}
@@ -0,0 +1,173 @@
+// Package retry repeats network operations that failed for a reason likely to pass, such as a
+// dropped connection, a timeout or an overloaded server, waiting longer after each attempt. The
+// delays grow exponentially up to a limit and are jittered, so parties and clients that failed
+// together do not retry in lockstep.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Policy says how often and how patiently an operation is retried
+type Policy struct {
+	Retries    int           // Attempts after the first; 0 tries once
+	Backoff    time.Duration // Delay before the first retry
+	MaxBackoff time.Duration // Longest delay between attempts (0 for no limit)
+	Multiplier float64       // Growth of the delay after each retry (default 2)
+	Jitter     float64       // Share of each delay randomized, from 0 (none) to 1
+}
+
+// Default is the policy used when none is configured: 3 retries after 1s, 2s and 4s, ±20%
+var Default = Policy{Retries: 3, Backoff: time.Second, MaxBackoff: 30 * time.Second, Multiplier: 2, Jitter: 0.2}
+
+// Delay returns how long to wait before retry n, counting from 1
+func (p Policy) Delay(n int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	delay := float64(p.Backoff) * math.Pow(multiplier, float64(n-1))
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+	if jitter := math.Min(p.Jitter, 1); jitter > 0 {
+		delay *= 1 + jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(delay)
+}
+
+// Do runs op until it succeeds, fails with an error that is not Retryable, or has been retried
+// p.Retries times. Each retry is announced with what names the operation.
+func (p Policy) Do(what string, op func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil {
+			return nil
+		}
+		if !Retryable(err) {
+			return unwrapPermanent(err)
+		}
+		if attempt > p.Retries {
+			if attempt > 1 {
+				return fmt.Errorf("%w (gave up after %d attempts)", err, attempt)
+			}
+			return err
+		}
+		delay := p.Delay(attempt)
+		fmt.Printf("   %s failed (%v); retrying in %s (attempt %d/%d)...\n", what, err, delay.Round(time.Millisecond), attempt, p.Retries)
+		time.Sleep(delay)
+	}
+}
+
+// permanentError marks an error that must not be retried whatever its cause
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent stops Do from retrying err, which it returns unmarked
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+func unwrapPermanent(err error) error {
+	if permanent, ok := err.(*permanentError); ok {
+		return permanent.err
+	}
+	return err
+}
+
+// retryableErrnos are the socket errors of a network that may recover
+var retryableErrnos = []syscall.Errno{
+	syscall.ECONNREFUSED,
+	syscall.ECONNRESET,
+	syscall.ECONNABORTED,
+	syscall.EHOSTUNREACH,
+	syscall.ENETUNREACH,
+	syscall.ENETDOWN,
+	syscall.EPIPE,
+	syscall.ETIMEDOUT,
+}
+
+// Retryable reports whether err is worth retrying: connections refused, reset or timed out,
+// temporary DNS failures, connections closed mid-reply, and gRPC calls failing as unavailable
+// or exhausted. Errors can decide for themselves with a Retryable() bool method, as HTTP status
+// errors marked with HTTPError do; anything else, including authentication and protocol failures, is final.
+func Retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return false
+	}
+	var decider interface{ Retryable() bool }
+	if errors.As(err, &decider) {
+		return decider.Retryable()
+	}
+	for _, errno := range retryableErrnos {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded:
+			return true
+		}
+	}
+	return false
+}
+
+// HTTPStatus reports whether an HTTP response status is worth retrying: request timeouts, rate
+// limiting and the server errors of an overloaded or restarting service
+func HTTPStatus(code int) bool {
+	switch code {
+	case 408, 429, 500, 502, 503, 504:
+		return true
+	}
+	return false
+}
+
+// httpError is the failure of a request the server answered, retried according to its status
+type httpError struct {
+	code int
+	err  error
+}
+
+func (e *httpError) Error() string   { return e.err.Error() }
+func (e *httpError) Unwrap() error   { return e.err }
+func (e *httpError) Retryable() bool { return HTTPStatus(e.code) }
+
+// HTTPError marks err as caused by an HTTP response with status code, which decides whether it
+// is retried
+func HTTPError(code int, err error) error {
+	return &httpError{code: code, err: err}
+}
@@ -0,0 +1,598 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/match"
+	"github.com/auroradata-ai/cohort-bridge/internal/metrics"
+	"github.com/auroradata-ai/cohort-bridge/internal/transfer"
+)
+
+// Job states reported by the serve daemon
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+// RecipeFingerprintHeader carries the submitter's tokenization recipe fingerprint
+const RecipeFingerprintHeader = "X-Recipe-Fingerprint"
+
+// JobRunner computes the intersection of the daemon's local dataset with a submitted dataset file
+type JobRunner func(datasetFile string) ([]*match.PrivateMatchResult, error)
+
+// DaemonConfig holds the settings of a long-running receiver daemon
+type DaemonConfig struct {
+	APIKeys           []string      // Accepted bearer tokens; at least one is required
+	JobsDir           string        // Directory where submitted datasets are stored
+	MaxConcurrentJobs int           // Jobs computed in parallel
+	MaxUploadBytes    int64         // Largest accepted dataset upload
+	MaxQueuedJobs     int           // Jobs held on disk (running, waiting or uploading) before submissions are refused
+	RequestTimeout    time.Duration // Deadline for requests other than uploads; 0 disables it
+	RecipeFingerprint string        // Local tokenization recipe fingerprint submissions must match
+	RecipeSummary     string        // Human-readable recipe (no seed), served to clients
+}
+
+// Job is a submitted dataset and the state of its intersection
+type Job struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	MatchCount int        `json:"match_count"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	datasetFile string
+	matches     []*match.PrivateMatchResult
+}
+
+// Daemon accepts tokenized datasets over an authenticated REST API and intersects them
+// with the local dataset, running up to MaxConcurrentJobs jobs at a time.
+type Daemon struct {
+	config   DaemonConfig
+	run      JobRunner
+	security *SecurityManager
+
+	mu       sync.RWMutex
+	jobs     map[string]*Job
+	slots    chan struct{}
+	pending  int // Jobs whose dataset is still on disk, including uploads in progress
+	wg       sync.WaitGroup
+	ctx      context.Context
+	cancel   context.CancelFunc
+	draining bool
+	serviceState
+}
+
+// serviceState is the lifecycle of a long-running server, reported by /healthz
+type serviceState struct {
+	started  time.Time
+	reloaded atomic.Pointer[time.Time]
+}
+
+// MarkReloaded records a configuration reload
+func (s *serviceState) MarkReloaded() {
+	now := time.Now().UTC()
+	s.reloaded.Store(&now)
+}
+
+// health returns the /healthz body of a server with the given job counts
+func (s *serviceState) health(status string, running, queued int) map[string]interface{} {
+	body := map[string]interface{}{
+		"status":       status,
+		"pid":          os.Getpid(),
+		"started_at":   s.started,
+		"uptime":       time.Since(s.started).Round(time.Second).String(),
+		"jobs_running": running,
+		"jobs_queued":  queued,
+	}
+	if reloaded := s.reloaded.Load(); reloaded != nil {
+		body["reloaded_at"] = *reloaded
+	}
+	return body
+}
+
+// countJobs counts the running jobs and those waiting for a slot
+func countJobs[J any](jobs map[string]J, status func(J) string) (running, queued int) {
+	for _, job := range jobs {
+		switch status(job) {
+		case JobRunning:
+			running++
+		case JobQueued:
+			queued++
+		}
+	}
+	return running, queued
+}
+
+// NewDaemon creates a receiver daemon; security may be nil to disable the IP allowlist and rate limits
+func NewDaemon(cfg DaemonConfig, run JobRunner, security *SecurityManager) (*Daemon, error) {
+	if len(cfg.APIKeys) == 0 {
+		return nil, fmt.Errorf("at least one API key is required")
+	}
+	if cfg.MaxConcurrentJobs < 1 {
+		cfg.MaxConcurrentJobs = 1
+	}
+	if err := os.MkdirAll(cfg.JobsDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create jobs directory %s: %w", cfg.JobsDir, err)
+	}
+	removeOrphanedJobs(cfg.JobsDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Daemon{
+		config:       cfg,
+		run:          run,
+		security:     security,
+		jobs:         make(map[string]*Job),
+		slots:        make(chan struct{}, cfg.MaxConcurrentJobs),
+		ctx:          ctx,
+		cancel:       cancel,
+		serviceState: serviceState{started: time.Now().UTC()},
+	}, nil
+}
+
+// removeOrphanedJobs deletes the datasets of jobs left behind by a daemon that was killed: jobs
+// live in memory, so after a restart they can neither run nor be queried
+func removeOrphanedJobs(jobsDir string) {
+	entries, err := os.ReadDir(jobsDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if _, err := hex.DecodeString(entry.Name()); entry.IsDir() && err == nil && len(entry.Name()) == 32 {
+			Info("Removing dataset of interrupted job %s", entry.Name())
+			os.RemoveAll(filepath.Join(jobsDir, entry.Name()))
+		}
+	}
+}
+
+// SetAPIKeys replaces the accepted API keys, as when the configuration is reloaded
+func (d *Daemon) SetAPIKeys(apiKeys []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.config.APIKeys = apiKeys
+}
+
+// apiKeys returns the accepted API keys
+func (d *Daemon) apiKeys() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.config.APIKeys
+}
+
+// Handler returns the REST API of the daemon
+func (d *Daemon) Handler() http.Handler {
+	submit := http.Handler(http.HandlerFunc(d.handleSubmit))
+	if d.security != nil {
+		submit = d.security.SubmissionLimit(submit)
+	}
+
+	// Uploads are bounded by the server read timeout instead, as large datasets take a while
+	timed := func(handler http.HandlerFunc) http.Handler {
+		if d.config.RequestTimeout <= 0 {
+			return handler
+		}
+		return http.TimeoutHandler(handler, d.config.RequestTimeout, `{"error":"request timed out"}`)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /healthz", timed(d.handleHealth))
+	mux.Handle("GET /v1/recipe", d.authenticate(timed(d.handleRecipe)))
+	mux.Handle("POST /v1/jobs", d.authenticate(submit))
+	mux.Handle("GET /v1/jobs", d.authenticate(timed(d.handleList)))
+	mux.Handle("GET /v1/jobs/{id}", d.authenticate(timed(d.handleStatus)))
+	mux.Handle("GET /v1/jobs/{id}/result", d.authenticate(timed(d.handleResult)))
+	mux.Handle("GET /metrics", d.authenticate(timed(metrics.Default.Handler().ServeHTTP)))
+
+	if d.security != nil {
+		return d.security.SecurityMiddleware(mux)
+	}
+	return mux
+}
+
+// Shutdown stops accepting jobs, cancels queued ones and waits for running jobs until ctx expires
+func (d *Daemon) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	d.draining = true
+	d.mu.Unlock()
+
+	// Queued jobs give up their place; running jobs are allowed to finish
+	d.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("jobs still running at shutdown: %w", ctx.Err())
+	}
+}
+
+// authenticate rejects requests without a valid API key
+func (d *Daemon) authenticate(next http.Handler) http.Handler {
+	return requireAPIKey(d.apiKeys, "serve", next)
+}
+
+// requireAPIKey rejects requests without one of the current apiKeys, counting rejections under
+// component
+func requireAPIKey(apiKeys func() []string, component string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if key == "" {
+			key = r.Header.Get("X-API-Key")
+		}
+		if !validKey(apiKeys(), key) {
+			Audit("api_auth_failed", map[string]interface{}{"remote": r.RemoteAddr, "path": r.URL.Path})
+			metrics.Errors.Inc(component, "unauthorized")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "missing or invalid API key")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validKey compares key against every accepted key in constant time
+func validKey(apiKeys []string, key string) bool {
+	if key == "" {
+		return false
+	}
+	valid := 0
+	for _, candidate := range apiKeys {
+		valid |= subtle.ConstantTimeCompare([]byte(key), []byte(candidate))
+	}
+	return valid == 1
+}
+
+func (d *Daemon) handleHealth(w http.ResponseWriter, r *http.Request) {
+	d.mu.RLock()
+	draining := d.draining
+	running, queued := countJobs(d.jobs, func(job *Job) string { return job.Status })
+	d.mu.RUnlock()
+
+	if draining {
+		writeJSON(w, http.StatusServiceUnavailable, d.health("draining", running, queued))
+		return
+	}
+	writeJSON(w, http.StatusOK, d.health("ok", running, queued))
+}
+
+func (d *Daemon) handleRecipe(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"fingerprint": d.config.RecipeFingerprint,
+		"summary":     d.config.RecipeSummary,
+		"encodings":   transfer.SupportedEncodings(), // Accepted as Content-Encoding and Accept-Encoding
+	})
+}
+
+// handleSubmit stores the request body as a tokenized dataset and queues a job for it.
+// Bodies may be compressed with any supported Content-Encoding; the size limit applies after decoding.
+func (d *Daemon) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	if d.config.RecipeFingerprint != "" {
+		if fingerprint := r.Header.Get(RecipeFingerprintHeader); fingerprint != d.config.RecipeFingerprint {
+			metrics.Errors.Inc("serve", "recipe_mismatch")
+			writeJSONError(w, http.StatusConflict, fmt.Sprintf(
+				"tokenization recipe mismatch - tokens would not be comparable (local recipe: %s); send the %s header",
+				d.config.RecipeSummary, RecipeFingerprintHeader))
+			return
+		}
+	}
+
+	body, err := transfer.NewReader(r.Header.Get("Content-Encoding"), r.Body)
+	if err != nil {
+		writeJSONError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("%v (supported: %s)", err, strings.Join(transfer.SupportedEncodings(), ", ")))
+		return
+	}
+	defer body.Close()
+
+	// Bound the datasets held on disk, so submissions cannot fill it faster than jobs finish
+	if !d.reserveJob() {
+		Audit("job_rejected", map[string]interface{}{"remote": r.RemoteAddr, "reason": "queue full"})
+		metrics.Errors.Inc("serve", "queue_full")
+		w.Header().Set("Retry-After", "60")
+		writeJSONError(w, http.StatusServiceUnavailable, fmt.Sprintf("job queue is full (%d jobs); retry later", d.config.MaxQueuedJobs))
+		return
+	}
+	reserved := true
+	defer func() {
+		if reserved {
+			d.releaseJob()
+		}
+	}()
+
+	id, err := newJobID()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to allocate job ID")
+		return
+	}
+
+	jobDir := filepath.Join(d.config.JobsDir, id)
+	if err := os.MkdirAll(jobDir, 0700); err != nil {
+		Error("Failed to create job directory %s: %v", jobDir, err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to store dataset")
+		return
+	}
+	datasetFile := filepath.Join(jobDir, "dataset.csv")
+	size, err := saveUpload(datasetFile, http.MaxBytesReader(w, body, d.config.MaxUploadBytes))
+	metrics.ExchangeBytes.Add(float64(size), "http", "received")
+	if err != nil {
+		os.RemoveAll(jobDir)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			metrics.Errors.Inc("serve", "upload_too_large")
+			writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("dataset exceeds %d bytes", d.config.MaxUploadBytes))
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "failed to read dataset: "+err.Error())
+		return
+	}
+	if size == 0 {
+		os.RemoveAll(jobDir)
+		writeJSONError(w, http.StatusBadRequest, "empty dataset")
+		return
+	}
+
+	job := &Job{ID: id, Status: JobQueued, CreatedAt: time.Now().UTC(), datasetFile: datasetFile}
+
+	d.mu.Lock()
+	if d.draining {
+		d.mu.Unlock()
+		os.RemoveAll(jobDir)
+		writeJSONError(w, http.StatusServiceUnavailable, "server is shutting down")
+		return
+	}
+	d.jobs[id] = job
+	d.wg.Add(1)
+	snapshot := *job
+	d.mu.Unlock()
+	reserved = false // Released by finish once the dataset is removed
+
+	go d.process(job)
+
+	Audit("job_submitted", map[string]interface{}{"job": id, "remote": r.RemoteAddr, "bytes": size})
+	w.Header().Set("Location", "/v1/jobs/"+id)
+	writeJSON(w, http.StatusAccepted, snapshot)
+}
+
+func (d *Daemon) handleList(w http.ResponseWriter, r *http.Request) {
+	d.mu.RLock()
+	jobs := make([]Job, 0, len(d.jobs))
+	for _, job := range d.jobs {
+		jobs = append(jobs, *job)
+	}
+	d.mu.RUnlock()
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) })
+	writeJSON(w, http.StatusOK, map[string]interface{}{"jobs": jobs})
+}
+
+func (d *Daemon) handleStatus(w http.ResponseWriter, r *http.Request) {
+	d.mu.RLock()
+	job, ok := d.jobs[r.PathValue("id")]
+	var snapshot Job
+	if ok {
+		snapshot = *job
+	}
+	d.mu.RUnlock()
+
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// handleResult returns the matches of a finished job, in the same shape as the pprl intersection file
+func (d *Daemon) handleResult(w http.ResponseWriter, r *http.Request) {
+	d.mu.RLock()
+	job, ok := d.jobs[r.PathValue("id")]
+	var status, jobErr string
+	var matches []*match.PrivateMatchResult
+	if ok {
+		status, jobErr, matches = job.Status, job.Error, job.matches
+	}
+	d.mu.RUnlock()
+
+	switch {
+	case !ok:
+		writeJSONError(w, http.StatusNotFound, "job not found")
+	case status == JobSucceeded:
+		if matches == nil {
+			matches = []*match.PrivateMatchResult{}
+		}
+		counted := &countingResponseWriter{ResponseWriter: w}
+		writeEncodedJSON(counted, r, http.StatusOK, map[string]interface{}{"matches": matches})
+		metrics.ExchangeBytes.Add(float64(counted.written), "http", "sent")
+	case status == JobFailed || status == JobCanceled:
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("job %s: %s", status, jobErr))
+	default:
+		writeJSONError(w, http.StatusConflict, "job is "+status)
+	}
+}
+
+// process waits for a free slot, runs the job and records its outcome
+func (d *Daemon) process(job *Job) {
+	defer d.wg.Done()
+
+	select {
+	case d.slots <- struct{}{}:
+	case <-d.ctx.Done():
+		d.finish(job, nil, fmt.Errorf("server shut down before the job started"))
+		return
+	}
+	defer func() { <-d.slots }()
+
+	started := time.Now().UTC()
+	d.mu.Lock()
+	job.Status = JobRunning
+	job.StartedAt = &started
+	d.mu.Unlock()
+	Info("Job %s started", job.ID)
+
+	metrics.JobsInFlight.Add(1, "running")
+	matches, err := d.run(job.datasetFile)
+	metrics.JobsInFlight.Add(-1, "running")
+	d.finish(job, matches, err)
+}
+
+// finish records the outcome of a job and removes its uploaded dataset
+func (d *Daemon) finish(job *Job, matches []*match.PrivateMatchResult, err error) {
+	finished := time.Now().UTC()
+
+	d.mu.Lock()
+	job.FinishedAt = &finished
+	switch {
+	case err != nil && job.StartedAt == nil:
+		job.Status = JobCanceled
+		job.Error = err.Error()
+	case err != nil:
+		job.Status = JobFailed
+		job.Error = err.Error()
+	default:
+		job.Status = JobSucceeded
+		job.matches = matches
+		job.MatchCount = len(matches)
+	}
+	status := job.Status
+	d.mu.Unlock()
+
+	// The submitted tokens are only needed while the job runs
+	os.RemoveAll(filepath.Dir(job.datasetFile))
+	d.releaseJob()
+	metrics.Jobs.Inc(status)
+
+	if err != nil {
+		Warn("Job %s %s: %v", job.ID, status, err)
+	} else {
+		Info("Job %s succeeded with %d matches", job.ID, len(matches))
+	}
+	Audit("job_finished", map[string]interface{}{"job": job.ID, "status": status})
+}
+
+// reserveJob claims one of MaxQueuedJobs places, or reports false when all are taken
+func (d *Daemon) reserveJob() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.config.MaxQueuedJobs > 0 && d.pending >= d.config.MaxQueuedJobs {
+		return false
+	}
+	d.pending++
+	metrics.JobsInFlight.Set(float64(d.pending), "held")
+	return true
+}
+
+func (d *Daemon) releaseJob() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pending--
+	metrics.JobsInFlight.Set(float64(d.pending), "held")
+}
+
+// saveUpload streams an uploaded body to filename and returns the number of bytes written
+func saveUpload(filename string, body io.Reader) (int64, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+	size, err := io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return size, err
+}
+
+// countingResponseWriter counts the bytes of a response body
+type countingResponseWriter struct {
+	http.ResponseWriter
+	written int64
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// newJobID returns a random 128-bit job identifier
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeEncodedJSON writes a JSON response compressed with the first supported encoding the client accepts
+func writeEncodedJSON(w http.ResponseWriter, r *http.Request, status int, body interface{}) {
+	encoding := transfer.Negotiate(transfer.SupportedEncodings(), acceptedEncodings(r.Header.Get("Accept-Encoding")))
+	if encoding == "" {
+		writeJSON(w, status, body)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Encoding", encoding)
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.WriteHeader(status)
+	writer, err := transfer.NewWriter(encoding, w)
+	if err != nil {
+		return
+	}
+	json.NewEncoder(writer).Encode(body)
+	writer.Close()
+}
+
+// acceptedEncodings lists the codings in an Accept-Encoding header, skipping those refused with q=0
+func acceptedEncodings(header string) []string {
+	var encodings []string
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.TrimSpace(fields[0])
+		refused := false
+		for _, param := range fields[1:] {
+			if q, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+					refused = true
+				}
+			}
+		}
+		if name != "" && !refused {
+			encodings = append(encodings, name)
+		}
+	}
+	return encodings
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
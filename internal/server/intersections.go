@@ -0,0 +1,697 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/metrics"
+	"github.com/auroradata-ai/cohort-bridge/internal/transfer"
+)
+
+// maxIntersectionFields bounds the JSON body or form fields of a submission, apart from uploads
+const maxIntersectionFields = 1 << 20
+
+// uploadExtension keeps the extensions of an uploaded file name that select how it is read
+var uploadExtension = regexp.MustCompile(`(\.[A-Za-z0-9]{1,8})+$`)
+
+// IntersectionRequest describes the intersection of two token files
+type IntersectionRequest struct {
+	Dataset1         string  `json:"dataset1"` // Token file: a reference, or an upload once stored
+	Dataset2         string  `json:"dataset2"`
+	HammingThreshold uint32  `json:"hamming_threshold,omitempty"` // 0 uses the server's threshold
+	JaccardThreshold float64 `json:"jaccard_threshold,omitempty"` // 0 uses the server's threshold
+	AllowDuplicates  bool    `json:"allow_duplicates,omitempty"`  // 1:many matching instead of 1:1
+}
+
+// IntersectionRunner intersects the token files of a request, writing the matches to resultFile,
+// and returns the number of matches
+type IntersectionRunner func(id string, request IntersectionRequest, resultFile string) (int, error)
+
+// IntersectionAPIConfig holds the settings of the intersect HTTP API
+type IntersectionAPIConfig struct {
+	APIKeys           []string      // Accepted bearer tokens; at least one is required
+	JobsDir           string        // Directory holding uploads and results, one subdirectory per job
+	MaxConcurrentJobs int           // Intersections computed in parallel
+	MaxUploadBytes    int64         // Largest accepted token file upload
+	MaxQueuedJobs     int           // Jobs waiting or running before submissions are refused
+	RequestTimeout    time.Duration // Deadline for requests other than uploads and downloads; 0 disables it
+	ResultRetention   time.Duration // How long results are kept after a job finishes; 0 keeps them until deleted
+	DeleteOnDownload  bool          // Delete results once they have been downloaded
+	ReferenceRoots    []string      // Directories and object URL prefixes token files may be referenced from
+	ResultName        string        // File name of the results in the job directory, such as results.csv
+	ResultContentType string        // Content type of the results
+}
+
+// Intersection is a submitted intersection and its state
+type Intersection struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	Dataset1   string     `json:"dataset1"` // The reference, or "upload" for an uploaded file
+	Dataset2   string     `json:"dataset2"`
+	MatchCount int        `json:"match_count"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // When the results will be deleted
+
+	request    IntersectionRequest
+	dir        string
+	uploads    []string
+	resultFile string
+	ctx        context.Context
+	cancel     context.CancelFunc // Withdraws a queued job
+}
+
+// IntersectionAPI runs intersect over an authenticated REST API: clients submit two token files,
+// uploaded or referenced, poll the job and download its results until they expire.
+type IntersectionAPI struct {
+	config   IntersectionAPIConfig
+	run      IntersectionRunner
+	security *SecurityManager
+
+	mu       sync.RWMutex
+	jobs     map[string]*Intersection
+	slots    chan struct{}
+	pending  int // Jobs queued or running, including uploads in progress
+	wg       sync.WaitGroup
+	ctx      context.Context
+	cancel   context.CancelFunc
+	draining bool
+	serviceState
+}
+
+// NewIntersectionAPI creates the intersect API; security may be nil to disable the IP allowlist and
+// rate limits. Results of an earlier process cannot be fetched again, so the jobs directory is
+// cleared.
+func NewIntersectionAPI(cfg IntersectionAPIConfig, run IntersectionRunner, security *SecurityManager) (*IntersectionAPI, error) {
+	if len(cfg.APIKeys) == 0 {
+		return nil, fmt.Errorf("at least one API key is required")
+	}
+	if cfg.MaxConcurrentJobs < 1 {
+		cfg.MaxConcurrentJobs = 1
+	}
+	if cfg.ResultName == "" {
+		cfg.ResultName = "results.csv"
+	}
+	if cfg.ResultContentType == "" {
+		cfg.ResultContentType = "text/csv"
+	}
+	if err := os.RemoveAll(cfg.JobsDir); err != nil {
+		return nil, fmt.Errorf("failed to clear jobs directory %s: %w", cfg.JobsDir, err)
+	}
+	if err := os.MkdirAll(cfg.JobsDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create jobs directory %s: %w", cfg.JobsDir, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	api := &IntersectionAPI{
+		config:       cfg,
+		run:          run,
+		security:     security,
+		jobs:         make(map[string]*Intersection),
+		slots:        make(chan struct{}, cfg.MaxConcurrentJobs),
+		ctx:          ctx,
+		cancel:       cancel,
+		serviceState: serviceState{started: time.Now().UTC()},
+	}
+	if cfg.ResultRetention > 0 {
+		go api.expireResults()
+	}
+	return api, nil
+}
+
+// Handler returns the REST API
+func (a *IntersectionAPI) Handler() http.Handler {
+	submit := http.Handler(http.HandlerFunc(a.handleSubmit))
+	if a.security != nil {
+		submit = a.security.SubmissionLimit(submit)
+	}
+	timed := func(handler http.HandlerFunc) http.Handler {
+		if a.config.RequestTimeout <= 0 {
+			return handler
+		}
+		return http.TimeoutHandler(handler, a.config.RequestTimeout, `{"error":"request timed out"}`)
+	}
+	authenticate := func(next http.Handler) http.Handler {
+		return requireAPIKey(a.apiKeys, "intersect_api", next)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /healthz", timed(a.handleHealth))
+	mux.Handle("POST /v1/intersections", authenticate(submit))
+	mux.Handle("GET /v1/intersections", authenticate(timed(a.handleList)))
+	mux.Handle("GET /v1/intersections/{id}", authenticate(timed(a.handleStatus)))
+	mux.Handle("DELETE /v1/intersections/{id}", authenticate(timed(a.handleDelete)))
+	mux.Handle("GET /v1/intersections/{id}/results", authenticate(http.HandlerFunc(a.handleResults)))
+	mux.Handle("GET /metrics", authenticate(timed(metrics.Default.Handler().ServeHTTP)))
+
+	if a.security != nil {
+		return a.security.SecurityMiddleware(mux)
+	}
+	return mux
+}
+
+// Shutdown stops accepting jobs, withdraws queued ones and waits for running jobs until ctx expires
+func (a *IntersectionAPI) Shutdown(ctx context.Context) error {
+	a.mu.Lock()
+	a.draining = true
+	a.mu.Unlock()
+	a.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("intersections still running at shutdown: %w", ctx.Err())
+	}
+}
+
+// SetAPIKeys replaces the accepted API keys, as when the configuration is reloaded
+func (a *IntersectionAPI) SetAPIKeys(apiKeys []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.config.APIKeys = apiKeys
+}
+
+// apiKeys returns the accepted API keys
+func (a *IntersectionAPI) apiKeys() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.config.APIKeys
+}
+
+func (a *IntersectionAPI) handleHealth(w http.ResponseWriter, r *http.Request) {
+	a.mu.RLock()
+	draining := a.draining
+	running, queued := countJobs(a.jobs, func(job *Intersection) string { return job.Status })
+	a.mu.RUnlock()
+
+	if draining {
+		writeJSON(w, http.StatusServiceUnavailable, a.health("draining", running, queued))
+		return
+	}
+	writeJSON(w, http.StatusOK, a.health("ok", running, queued))
+}
+
+// handleSubmit queues an intersection. The body is either multipart/form-data, with dataset1 and
+// dataset2 as file uploads or references and the optional request fields as form values, or a
+// JSON IntersectionRequest referencing both token files.
+func (a *IntersectionAPI) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	if !a.reserveJob() {
+		Audit("intersection_rejected", map[string]interface{}{"remote": r.RemoteAddr, "reason": "queue full"})
+		metrics.Errors.Inc("intersect_api", "queue_full")
+		w.Header().Set("Retry-After", "60")
+		writeJSONError(w, http.StatusServiceUnavailable, fmt.Sprintf("job queue is full (%d jobs); retry later", a.config.MaxQueuedJobs))
+		return
+	}
+	reserved := true
+	defer func() {
+		if reserved {
+			a.releaseJob()
+		}
+	}()
+
+	id, err := newJobID()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to allocate job ID")
+		return
+	}
+	job := &Intersection{ID: id, Status: JobQueued, CreatedAt: time.Now().UTC(), dir: filepath.Join(a.config.JobsDir, id)}
+	if err := os.MkdirAll(job.dir, 0700); err != nil {
+		Error("Failed to create job directory %s: %v", job.dir, err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to store datasets")
+		return
+	}
+	job.resultFile = filepath.Join(job.dir, a.config.ResultName)
+
+	status, err := a.readSubmission(w, r, job)
+	if err == nil {
+		status, err = a.resolveDatasets(job)
+	}
+	if err != nil {
+		os.RemoveAll(job.dir)
+		metrics.Errors.Inc("intersect_api", "bad_submission")
+		writeJSONError(w, status, err.Error())
+		return
+	}
+
+	a.mu.Lock()
+	if a.draining {
+		a.mu.Unlock()
+		os.RemoveAll(job.dir)
+		writeJSONError(w, http.StatusServiceUnavailable, "server is shutting down")
+		return
+	}
+	job.ctx, job.cancel = context.WithCancel(a.ctx)
+	a.jobs[id] = job
+	a.wg.Add(1)
+	snapshot := *job
+	a.mu.Unlock()
+	reserved = false // Released by finish
+
+	go a.process(job)
+
+	Audit("intersection_submitted", map[string]interface{}{"job": id, "remote": r.RemoteAddr, "dataset1": job.Dataset1, "dataset2": job.Dataset2})
+	w.Header().Set("Location", "/v1/intersections/"+id)
+	writeJSON(w, http.StatusAccepted, snapshot)
+}
+
+// readSubmission reads the request of a submission into job, storing uploads in its directory.
+// It returns the HTTP status of a failure.
+func (a *IntersectionAPI) readSubmission(w http.ResponseWriter, r *http.Request, job *Intersection) (int, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		mediaType = ""
+	}
+	switch mediaType {
+	case "application/json":
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxIntersectionFields)).Decode(&job.request); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("invalid request: %v", err)
+		}
+		job.Dataset1, job.Dataset2 = job.request.Dataset1, job.request.Dataset2
+		return 0, nil
+	case "multipart/form-data":
+	default:
+		return http.StatusUnsupportedMediaType, fmt.Errorf("send multipart/form-data with the token files, or application/json referencing them")
+	}
+
+	// Two uploads and the form fields at most
+	r.Body = http.MaxBytesReader(w, r.Body, 2*a.config.MaxUploadBytes+maxIntersectionFields)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("invalid multipart body: %v", err)
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return uploadStatus(err), fmt.Errorf("failed to read body: %v", err)
+		}
+		name := part.FormName()
+		if (name == "dataset1" || name == "dataset2") && part.FileName() != "" {
+			file := filepath.Join(job.dir, name+uploadExtension.FindString(filepath.Base(part.FileName())))
+			size, err := saveUpload(file, io.LimitReader(part, a.config.MaxUploadBytes+1))
+			metrics.ExchangeBytes.Add(float64(size), "http", "received")
+			switch {
+			case err != nil:
+				return uploadStatus(err), fmt.Errorf("failed to read %s: %v", name, err)
+			case size > a.config.MaxUploadBytes:
+				return http.StatusRequestEntityTooLarge, fmt.Errorf("%s exceeds %d bytes", name, a.config.MaxUploadBytes)
+			case size == 0:
+				return http.StatusBadRequest, fmt.Errorf("%s is empty", name)
+			}
+			job.uploads = append(job.uploads, file)
+			if name == "dataset1" {
+				job.request.Dataset1, job.Dataset1 = file, "upload"
+			} else {
+				job.request.Dataset2, job.Dataset2 = file, "upload"
+			}
+			continue
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxIntersectionFields))
+		if err != nil {
+			return uploadStatus(err), fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		field := strings.TrimSpace(string(value))
+		switch name {
+		case "dataset1":
+			job.request.Dataset1, job.Dataset1 = field, field
+		case "dataset2":
+			job.request.Dataset2, job.Dataset2 = field, field
+		case "hamming_threshold":
+			hamming, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return http.StatusBadRequest, fmt.Errorf("invalid hamming_threshold %q", field)
+			}
+			job.request.HammingThreshold = uint32(hamming)
+		case "jaccard_threshold":
+			jaccard, err := strconv.ParseFloat(field, 64)
+			if err != nil || jaccard < 0 || jaccard > 1 {
+				return http.StatusBadRequest, fmt.Errorf("invalid jaccard_threshold %q", field)
+			}
+			job.request.JaccardThreshold = jaccard
+		case "allow_duplicates":
+			allow, err := strconv.ParseBool(field)
+			if err != nil {
+				return http.StatusBadRequest, fmt.Errorf("invalid allow_duplicates %q", field)
+			}
+			job.request.AllowDuplicates = allow
+		default:
+			return http.StatusBadRequest, fmt.Errorf("unknown field %q", name)
+		}
+	}
+	return 0, nil
+}
+
+// resolveDatasets checks that both token files were given and resolves the referenced ones
+func (a *IntersectionAPI) resolveDatasets(job *Intersection) (int, error) {
+	for _, dataset := range []struct {
+		name string
+		path *string
+	}{
+		{"dataset1", &job.request.Dataset1},
+		{"dataset2", &job.request.Dataset2},
+	} {
+		switch {
+		case *dataset.path == "":
+			return http.StatusBadRequest, fmt.Errorf("%s is required", dataset.name)
+		case slices.Contains(job.uploads, *dataset.path):
+			continue
+		}
+		resolved, err := a.resolveReference(*dataset.path)
+		if err != nil {
+			Audit("intersection_rejected", map[string]interface{}{"reference": *dataset.path, "reason": err.Error()})
+			return http.StatusForbidden, fmt.Errorf("%s: %v", dataset.name, err)
+		}
+		*dataset.path = resolved
+	}
+	return 0, nil
+}
+
+// resolveReference returns the token file a reference names, provided it lies under one of the
+// reference roots. Relative paths are looked up in each root directory in turn; object URLs must
+// start with a root URL prefix.
+func (a *IntersectionAPI) resolveReference(reference string) (string, error) {
+	if len(a.config.ReferenceRoots) == 0 {
+		return "", fmt.Errorf("references are disabled on this server; upload the token file instead")
+	}
+
+	if strings.Contains(reference, "://") {
+		if strings.Contains(reference, "/../") || strings.HasSuffix(reference, "/..") {
+			return "", fmt.Errorf("reference %s is not allowed", reference)
+		}
+		for _, root := range a.config.ReferenceRoots {
+			if strings.Contains(root, "://") && strings.HasPrefix(reference, strings.TrimSuffix(root, "/")+"/") {
+				return reference, nil
+			}
+		}
+		return "", fmt.Errorf("reference %s is outside the reference roots", reference)
+	}
+
+	for _, root := range a.config.ReferenceRoots {
+		if strings.Contains(root, "://") {
+			continue
+		}
+		rootPath, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			continue
+		}
+		candidate := reference
+		if !filepath.IsAbs(candidate) {
+			candidate = filepath.Join(rootPath, candidate)
+		}
+		// Symbolic links are followed before the check, so they cannot lead out of the root
+		resolved, err := filepath.EvalSymlinks(candidate)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(rootPath, resolved)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if info, err := os.Stat(resolved); err == nil && info.Mode().IsRegular() {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("reference %s is not a token file under the reference roots", reference)
+}
+
+func (a *IntersectionAPI) handleList(w http.ResponseWriter, r *http.Request) {
+	a.mu.RLock()
+	jobs := make([]Intersection, 0, len(a.jobs))
+	for _, job := range a.jobs {
+		jobs = append(jobs, *job)
+	}
+	a.mu.RUnlock()
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) })
+	writeJSON(w, http.StatusOK, map[string]interface{}{"intersections": jobs})
+}
+
+func (a *IntersectionAPI) handleStatus(w http.ResponseWriter, r *http.Request) {
+	a.mu.RLock()
+	job, ok := a.jobs[r.PathValue("id")]
+	var snapshot Intersection
+	if ok {
+		snapshot = *job
+	}
+	a.mu.RUnlock()
+
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "intersection not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// handleDelete withdraws a queued intersection or deletes a finished one with its results
+func (a *IntersectionAPI) handleDelete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	a.mu.Lock()
+	job, ok := a.jobs[id]
+	var status string
+	if ok {
+		status = job.Status
+	}
+	a.mu.Unlock()
+
+	switch {
+	case !ok:
+		writeJSONError(w, http.StatusNotFound, "intersection not found")
+	case status == JobRunning:
+		writeJSONError(w, http.StatusConflict, "intersection is running; delete it once it has finished")
+	case status == JobQueued:
+		job.cancel() // process records the job as canceled
+		writeJSON(w, http.StatusAccepted, map[string]string{"id": id, "status": JobCanceled})
+	default:
+		a.remove(id, "deleted")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleResults streams the results of a succeeded intersection, compressed with the first
+// supported encoding the client accepts
+func (a *IntersectionAPI) handleResults(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	a.mu.RLock()
+	job, ok := a.jobs[id]
+	var status, jobErr, resultFile string
+	if ok {
+		status, jobErr, resultFile = job.Status, job.Error, job.resultFile
+	}
+	a.mu.RUnlock()
+
+	switch {
+	case !ok:
+		writeJSONError(w, http.StatusNotFound, "intersection not found")
+		return
+	case status == JobFailed || status == JobCanceled:
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("intersection %s: %s", status, jobErr))
+		return
+	case status != JobSucceeded:
+		writeJSONError(w, http.StatusConflict, "intersection is "+status)
+		return
+	}
+
+	file, err := os.Open(resultFile)
+	if err != nil {
+		writeJSONError(w, http.StatusGone, "results are no longer available")
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", a.config.ResultContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+"_"+a.config.ResultName))
+	counted := &countingResponseWriter{ResponseWriter: w}
+	var out io.Writer = counted
+	encoding := transfer.Negotiate(transfer.SupportedEncodings(), acceptedEncodings(r.Header.Get("Accept-Encoding")))
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	w.WriteHeader(http.StatusOK)
+	var writer io.WriteCloser
+	if encoding != "" {
+		if writer, err = transfer.NewWriter(encoding, counted); err != nil {
+			return
+		}
+		out = writer
+	}
+	_, err = io.Copy(out, file)
+	if writer != nil {
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	metrics.ExchangeBytes.Add(float64(counted.written), "http", "sent")
+	if err == nil && a.config.DeleteOnDownload {
+		file.Close()
+		a.remove(id, "downloaded")
+	}
+}
+
+// process waits for a free slot, runs the intersection and records its outcome
+func (a *IntersectionAPI) process(job *Intersection) {
+	defer a.wg.Done()
+
+	withdrawn := func() {
+		if a.ctx.Err() != nil {
+			a.finish(job, 0, fmt.Errorf("server shut down before the intersection started"))
+		} else {
+			a.finish(job, 0, fmt.Errorf("withdrawn before it started"))
+		}
+	}
+	select {
+	case a.slots <- struct{}{}:
+	case <-job.ctx.Done():
+		withdrawn()
+		return
+	}
+	defer func() { <-a.slots }()
+
+	// A job withdrawn while waiting may still win the slot
+	if job.ctx.Err() != nil {
+		withdrawn()
+		return
+	}
+
+	started := time.Now().UTC()
+	a.mu.Lock()
+	job.Status = JobRunning
+	job.StartedAt = &started
+	a.mu.Unlock()
+	Info("Intersection %s started", job.ID)
+
+	matches, err := a.run(job.ID, job.request, job.resultFile)
+	a.finish(job, matches, err)
+}
+
+// finish records the outcome of an intersection and removes its uploads; the results are kept
+// for the retention period
+func (a *IntersectionAPI) finish(job *Intersection, matches int, err error) {
+	finished := time.Now().UTC()
+	for _, upload := range job.uploads {
+		os.Remove(upload)
+	}
+	if err != nil {
+		os.Remove(job.resultFile)
+	}
+
+	a.mu.Lock()
+	job.FinishedAt = &finished
+	switch {
+	case err != nil && job.StartedAt == nil:
+		job.Status = JobCanceled
+		job.Error = err.Error()
+	case err != nil:
+		job.Status = JobFailed
+		job.Error = err.Error()
+	default:
+		job.Status = JobSucceeded
+		job.MatchCount = matches
+	}
+	if a.config.ResultRetention > 0 {
+		expires := finished.Add(a.config.ResultRetention)
+		job.ExpiresAt = &expires
+	}
+	status := job.Status
+	a.mu.Unlock()
+	a.releaseJob()
+
+	if err != nil {
+		Warn("Intersection %s %s: %v", job.ID, status, err)
+	} else {
+		Info("Intersection %s succeeded with %d matches", job.ID, matches)
+	}
+	Audit("intersection_finished", map[string]interface{}{"job": job.ID, "status": status})
+}
+
+// remove forgets a finished intersection and deletes its directory
+func (a *IntersectionAPI) remove(id, reason string) {
+	a.mu.Lock()
+	job, ok := a.jobs[id]
+	if ok {
+		delete(a.jobs, id)
+	}
+	a.mu.Unlock()
+	if !ok {
+		return
+	}
+	os.RemoveAll(job.dir)
+	Audit("intersection_removed", map[string]interface{}{"job": id, "reason": reason})
+}
+
+// expireResults removes intersections whose results have outlived the retention period
+func (a *IntersectionAPI) expireResults() {
+	interval := min(max(a.config.ResultRetention/4, time.Second), time.Minute)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case now := <-ticker.C:
+			var expired []string
+			a.mu.RLock()
+			for id, job := range a.jobs {
+				if job.ExpiresAt != nil && now.After(*job.ExpiresAt) {
+					expired = append(expired, id)
+				}
+			}
+			a.mu.RUnlock()
+			for _, id := range expired {
+				a.remove(id, "expired")
+			}
+		}
+	}
+}
+
+// reserveJob claims one of MaxQueuedJobs places, or reports false when all are taken
+func (a *IntersectionAPI) reserveJob() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.config.MaxQueuedJobs > 0 && a.pending >= a.config.MaxQueuedJobs {
+		return false
+	}
+	a.pending++
+	return true
+}
+
+func (a *IntersectionAPI) releaseJob() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending--
+}
+
+// uploadStatus returns the HTTP status of a failed upload
+func uploadStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logBackupLayout stamps rotated log files, as audit.log.20260102T150405
+const logBackupLayout = "20060102T150405"
+
+// LogFile is a log file rotated by size: when a write would take it past MaxSize, the file is
+// renamed with a timestamp suffix and a new one started, keeping at most MaxBackups rotated
+// files no older than MaxAge. Reopen starts a new file after an external logrotate moved it.
+type LogFile struct {
+	Path       string
+	MaxSize    int64         // Bytes; 0 never rotates
+	MaxBackups int           // Rotated files kept; 0 keeps all
+	MaxAge     time.Duration // Rotated files older than this are removed; 0 keeps them
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenLogFile opens a log file for appending, creating its directory; sizes are in MB and ages
+// in days, as in the logging config section
+func OpenLogFile(path string, maxSizeMB, maxBackups, maxAgeDays int) (*LogFile, error) {
+	f := &LogFile{
+		Path:       path,
+		MaxSize:    int64(maxSizeMB) << 20,
+		MaxBackups: maxBackups,
+		MaxAge:     time.Duration(maxAgeDays) * 24 * time.Hour,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends p, rotating the file first if it would grow past MaxSize
+func (f *LogFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Reopen closes the file and opens Path again
+func (f *LogFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil {
+		f.file.Close()
+	}
+	return f.open()
+}
+
+// Close closes the file
+func (f *LogFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *LogFile) open() error {
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// rotate renames the current file aside, starts a new one and removes surplus backups
+func (f *LogFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+	backup := f.Path + "." + time.Now().UTC().Format(logBackupLayout)
+	if _, err := os.Stat(backup); err == nil {
+		backup += fmt.Sprintf(".%d", time.Now().UnixNano()) // Several rotations within a second
+	}
+	if err := os.Rename(f.Path, backup); err != nil && !os.IsNotExist(err) {
+		// Keep logging to the current file rather than losing lines
+		if openErr := f.open(); openErr != nil {
+			return openErr
+		}
+		return nil
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.prune()
+	return nil
+}
+
+// prune removes rotated files beyond MaxBackups or older than MaxAge
+func (f *LogFile) prune() {
+	matches, err := filepath.Glob(f.Path + ".*")
+	if err != nil {
+		return
+	}
+	var backups []string
+	for _, match := range matches {
+		stamp := strings.TrimPrefix(match, f.Path+".")
+		if len(stamp) >= len(logBackupLayout) {
+			if _, err := time.Parse(logBackupLayout, stamp[:len(logBackupLayout)]); err == nil {
+				backups = append(backups, match)
+			}
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups))) // Newest first
+	for i, backup := range backups {
+		expired := false
+		if f.MaxAge > 0 {
+			if info, err := os.Stat(backup); err == nil && time.Since(info.ModTime()) > f.MaxAge {
+				expired = true
+			}
+		}
+		if expired || (f.MaxBackups > 0 && i >= f.MaxBackups) {
+			os.Remove(backup)
+		}
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"io"
 	"log"
 	"os"
-	"path/filepath"
 	"sync"
 	"time"
 
@@ -30,6 +29,7 @@ type Logger struct {
 	config      *config.Config
 	mu          sync.RWMutex
 	sessionID   string
+	files       []*LogFile // Log and audit files, rotated by size and reopened on reload
 }
 
 var (
@@ -70,14 +70,11 @@ func NewLogger(cfg *config.Config, sessionID string) (*Logger, error) {
 	// Setup main logger
 	var mainWriter io.Writer = os.Stdout
 	if cfg.Logging.File != "" {
-		if err := os.MkdirAll(filepath.Dir(cfg.Logging.File), 0755); err != nil {
-			return nil, fmt.Errorf("failed to create log directory: %w", err)
-		}
-
-		file, err := os.OpenFile(cfg.Logging.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		file, err := OpenLogFile(cfg.Logging.File, cfg.Logging.MaxSize, cfg.Logging.MaxBackups, cfg.Logging.MaxAge)
 		if err != nil {
-			return nil, fmt.Errorf("failed to open log file: %w", err)
+			return nil, err
 		}
+		logger.files = append(logger.files, file)
 		mainWriter = file
 	}
 
@@ -91,14 +88,11 @@ func NewLogger(cfg *config.Config, sessionID string) (*Logger, error) {
 			auditFile = "audit.log"
 		}
 
-		if err := os.MkdirAll(filepath.Dir(auditFile), 0755); err != nil {
-			return nil, fmt.Errorf("failed to create audit log directory: %w", err)
-		}
-
-		file, err := os.OpenFile(auditFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		file, err := OpenLogFile(auditFile, cfg.Logging.MaxSize, cfg.Logging.MaxBackups, cfg.Logging.MaxAge)
 		if err != nil {
-			return nil, fmt.Errorf("failed to open audit log file: %w", err)
+			return nil, fmt.Errorf("audit log: %w", err)
 		}
+		logger.files = append(logger.files, file)
 
 		logger.auditLogger = log.New(file, fmt.Sprintf("[AUDIT-%s] ", sessionID),
 			log.LstdFlags|log.Lshortfile)
@@ -125,28 +119,28 @@ func parseLogLevel(level string) LogLevel {
 
 // Debug logs a debug message
 func (l *Logger) Debug(format string, args ...interface{}) {
-	if l.level <= DEBUG {
+	if l.enabled(DEBUG) {
 		l.log(DEBUG, format, args...)
 	}
 }
 
 // Info logs an info message
 func (l *Logger) Info(format string, args ...interface{}) {
-	if l.level <= INFO {
+	if l.enabled(INFO) {
 		l.log(INFO, format, args...)
 	}
 }
 
 // Warn logs a warning message
 func (l *Logger) Warn(format string, args ...interface{}) {
-	if l.level <= WARN {
+	if l.enabled(WARN) {
 		l.log(WARN, format, args...)
 	}
 }
 
 // Error logs an error message
 func (l *Logger) Error(format string, args ...interface{}) {
-	if l.level <= ERROR {
+	if l.enabled(ERROR) {
 		l.log(ERROR, format, args...)
 	}
 }
@@ -174,6 +168,13 @@ func (l *Logger) Audit(event string, details map[string]interface{}) {
 	}
 }
 
+// enabled reports whether messages of level are logged
+func (l *Logger) enabled(level LogLevel) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.level <= level
+}
+
 // log is the internal logging method
 func (l *Logger) log(level LogLevel, format string, args ...interface{}) {
 	l.mu.RLock()
@@ -204,9 +205,37 @@ func levelToString(level LogLevel) string {
 	}
 }
 
+// Reload applies the log level of cfg and reopens the log files, so files moved aside by an
+// external logrotate are started again. Changed file paths take effect on restart.
+func (l *Logger) Reload(cfg *config.Config) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = parseLogLevel(cfg.Logging.Level)
+	for _, file := range l.files {
+		if err := file.Reopen(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Close closes all log outputs
 func (l *Logger) Close() error {
-	return nil
+	var err error
+	for _, file := range l.files {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// ReloadLogger reloads the global logger, if it was initialized
+func ReloadLogger(cfg *config.Config) error {
+	if globalLogger == nil {
+		return nil
+	}
+	return globalLogger.Reload(cfg)
 }
 
 // Helper functions for global logger
@@ -3,20 +3,25 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/auroradata-ai/cohort-bridge/internal/config"
+	"github.com/auroradata-ai/cohort-bridge/internal/metrics"
 )
 
 // SecurityManager handles security policies and connection management
 type SecurityManager struct {
 	config       *config.Config
+	allowed      []*net.IPNet // Networks allowed to connect; empty allows all
 	currentConns int
-	rateLimitMap map[string]*rateLimitInfo
+	rateLimitMap map[string]*rateLimitInfo // Requests per IP
+	submitMap    map[string]*rateLimitInfo // Dataset submissions per IP
 	mutex        sync.RWMutex
 }
 
@@ -25,59 +30,161 @@ type rateLimitInfo struct {
 	resetTime time.Time
 }
 
-// NewSecurityManager creates a new security manager
-func NewSecurityManager(cfg *config.Config) *SecurityManager {
+// rateLimitPruneSize is the number of tracked IPs above which expired entries are dropped
+const rateLimitPruneSize = 10000
+
+// ErrRateLimited is returned when an IP exceeds its per-minute budget
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// NewSecurityManager creates a security manager from the security section of cfg
+func NewSecurityManager(cfg *config.Config) (*SecurityManager, error) {
+	allowed, err := ParseAllowedIPs(cfg.Security.AllowedIPs)
+	if err != nil {
+		return nil, err
+	}
 	return &SecurityManager{
 		config:       cfg,
+		allowed:      allowed,
 		rateLimitMap: make(map[string]*rateLimitInfo),
-	}
+		submitMap:    make(map[string]*rateLimitInfo),
+	}, nil
 }
 
-// ValidateConnection checks if a connection should be allowed
-func (sm *SecurityManager) ValidateConnection(remoteAddr string) error {
+// Reload applies the security section of cfg, as when a daemon reloads its configuration. Rate
+// limit budgets already counted this minute are kept.
+func (sm *SecurityManager) Reload(cfg *config.Config) error {
+	allowed, err := ParseAllowedIPs(cfg.Security.AllowedIPs)
+	if err != nil {
+		return err
+	}
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
+	sm.config = cfg
+	sm.allowed = allowed
+	return nil
+}
 
+// ParseAllowedIPs parses an allowlist of IP addresses and CIDR ranges
+func ParseAllowedIPs(entries []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("security.allowed_ips: invalid IP address %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("security.allowed_ips: invalid CIDR range %q", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// AllowedAddr reports whether a remote address (host:port or IP) is in security.allowed_ips
+func (sm *SecurityManager) AllowedAddr(remoteAddr string) bool {
+	sm.mutex.RLock()
+	allowed := sm.allowed
+	sm.mutex.RUnlock()
+	if len(allowed) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateConnection checks if a request should be allowed: the IP must be in the allowlist
+// and within its requests_per_min budget
+func (sm *SecurityManager) ValidateConnection(remoteAddr string) error {
 	host, _, err := net.SplitHostPort(remoteAddr)
 	if err != nil {
 		return fmt.Errorf("invalid remote address: %w", err)
 	}
+	if !sm.AllowedAddr(remoteAddr) {
+		return fmt.Errorf("IP %s is not in security.allowed_ips", host)
+	}
 
-	// Check rate limiting
-	if err := sm.checkRateLimit(host); err != nil {
-		return err
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	return sm.checkRateLimit(sm.rateLimitMap, host, sm.config.Security.RequestsPerMin)
+}
+
+// ValidateSubmission enforces the per-IP limit on dataset submissions (rate_limit_per_min)
+func (sm *SecurityManager) ValidateSubmission(remoteAddr string) error {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return fmt.Errorf("invalid remote address: %w", err)
 	}
 
-	return nil
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	return sm.checkRateLimit(sm.submitMap, host, sm.config.Security.RateLimitPerMin)
 }
 
-// checkRateLimit enforces per-IP rate limiting
-func (sm *SecurityManager) checkRateLimit(host string) error {
+// checkRateLimit enforces a per-IP limit per minute; limit <= 0 disables it
+func (sm *SecurityManager) checkRateLimit(limits map[string]*rateLimitInfo, host string, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
 	now := time.Now()
 
-	info, exists := sm.rateLimitMap[host]
+	// Forget expired IPs so many distinct callers cannot grow the map without bound
+	if len(limits) > rateLimitPruneSize {
+		for ip, info := range limits {
+			if now.After(info.resetTime) {
+				delete(limits, ip)
+			}
+		}
+	}
+
+	info, exists := limits[host]
 	if !exists || now.After(info.resetTime) {
 		// Reset or create new rate limit info
-		sm.rateLimitMap[host] = &rateLimitInfo{
+		limits[host] = &rateLimitInfo{
 			count:     1,
 			resetTime: now.Add(time.Minute),
 		}
 		return nil
 	}
 
-	if info.count >= sm.config.Security.RateLimitPerMin {
-		return fmt.Errorf("rate limit exceeded for IP %s", host)
+	if info.count >= limit {
+		return fmt.Errorf("%w for IP %s", ErrRateLimited, host)
 	}
 
 	info.count++
 	return nil
 }
 
-// TrackConnection increments the connection counter
-func (sm *SecurityManager) TrackConnection() {
+// TrackConnection counts an in-flight request, refusing it when max_connections are in flight
+func (sm *SecurityManager) TrackConnection() bool {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
+	if max := sm.config.Security.MaxConnections; max > 0 && sm.currentConns >= max {
+		return false
+	}
 	sm.currentConns++
+	return true
 }
 
 // ReleaseConnection decrements the connection counter
@@ -89,17 +196,32 @@ func (sm *SecurityManager) ReleaseConnection() {
 	}
 }
 
-// SecurityMiddleware provides HTTP security middleware
+// SecurityMiddleware provides HTTP security middleware: IP allowlist, per-IP request rate
+// limiting and a cap on concurrent requests. Rejections are audited.
 func (sm *SecurityManager) SecurityMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Validate the connection
 		if err := sm.ValidateConnection(r.RemoteAddr); err != nil {
-			http.Error(w, "Connection not allowed: "+err.Error(), http.StatusForbidden)
+			if errors.Is(err, ErrRateLimited) {
+				Audit("rate_limited", map[string]interface{}{"remote": r.RemoteAddr, "path": r.URL.Path})
+				metrics.Errors.Inc("serve", "rate_limited")
+				w.Header().Set("Retry-After", "60")
+				http.Error(w, "Too many requests: "+err.Error(), http.StatusTooManyRequests)
+				return
+			}
+			Audit("connection_rejected", map[string]interface{}{"remote": r.RemoteAddr, "path": r.URL.Path, "reason": err.Error()})
+			metrics.Errors.Inc("serve", "ip_not_allowed")
+			http.Error(w, "Connection not allowed", http.StatusForbidden)
 			return
 		}
 
 		// Track the connection
-		sm.TrackConnection()
+		if !sm.TrackConnection() {
+			metrics.Errors.Inc("serve", "too_many_connections")
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Server busy, too many concurrent requests", http.StatusServiceUnavailable)
+			return
+		}
 		defer sm.ReleaseConnection()
 
 		// Set security headers
@@ -111,6 +233,20 @@ func (sm *SecurityManager) SecurityMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// SubmissionLimit rejects dataset submissions beyond rate_limit_per_min per IP
+func (sm *SecurityManager) SubmissionLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := sm.ValidateSubmission(r.RemoteAddr); err != nil {
+			Audit("rate_limited", map[string]interface{}{"remote": r.RemoteAddr, "path": r.URL.Path})
+			metrics.Errors.Inc("serve", "rate_limited")
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "Too many submissions: "+err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // SecurityContextKey is used for context values
 type SecurityContextKey string
 
@@ -134,6 +270,8 @@ func (sm *SecurityManager) GetSecurityStats() map[string]interface{} {
 	stats := map[string]interface{}{
 		"current_connections": sm.currentConns,
 		"rate_limit_per_min":  sm.config.Security.RateLimitPerMin,
+		"requests_per_min":    sm.config.Security.RequestsPerMin,
+		"max_connections":     sm.config.Security.MaxConnections,
 		"monitored_ips":       len(sm.rateLimitMap),
 	}
 
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServiceControl delivers the stop and reload requests of the service manager: SIGTERM or SIGINT
+// and SIGHUP under systemd or a shell, or the Windows service control manager when running as a
+// Windows service.
+type ServiceControl struct {
+	Stop   <-chan struct{} // Closed when the daemon should drain and exit
+	Reload <-chan struct{} // Receives a request to reload the configuration
+
+	stop    chan struct{}
+	reload  chan struct{}
+	once    sync.Once
+	closeFn func()
+}
+
+func newServiceControl() *ServiceControl {
+	stop, reload := make(chan struct{}), make(chan struct{}, 1)
+	return &ServiceControl{Stop: stop, Reload: reload, stop: stop, reload: reload}
+}
+
+// requestStop closes Stop once
+func (c *ServiceControl) requestStop() {
+	c.once.Do(func() { close(c.stop) })
+}
+
+// requestReload queues a reload unless one is already waiting
+func (c *ServiceControl) requestReload() {
+	select {
+	case c.reload <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops watching for requests; under the Windows service manager it reports the service
+// stopped
+func (c *ServiceControl) Close() {
+	if c.closeFn != nil {
+		c.closeFn()
+	}
+}
+
+// watchSignals turns stop and reload signals into requests. After the first stop signal the
+// default handling is restored, so a second one ends a stuck drain at once.
+func (c *ServiceControl) watchSignals(stopSignals []os.Signal, reloadSignals []os.Signal) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append(append([]os.Signal{}, stopSignals...), reloadSignals...)...)
+	done := make(chan struct{})
+	c.closeFn = func() {
+		signal.Stop(signals)
+		close(done)
+	}
+
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				for _, reload := range reloadSignals {
+					if sig == reload {
+						c.requestReload()
+					}
+				}
+				for _, stop := range stopSignals {
+					if sig == stop {
+						signal.Reset(stopSignals...)
+						c.requestStop()
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// Notify reports a state change to systemd (Type=notify units), such as "READY=1",
+// "RELOADING=1" or "STOPPING=1". It does nothing outside systemd.
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:] // Abstract socket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("systemd notify: %w", err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// WatchdogInterval returns how often systemd expects a keep-alive (WatchdogSec=), or 0 when the
+// watchdog is off for this process
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// RunWatchdog sends systemd keep-alives at half the watchdog interval while healthy reports
+// true, until stop is closed
+func RunWatchdog(stop <-chan struct{}, healthy func() bool) {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if healthy() {
+				Notify("WATCHDOG=1")
+			}
+		}
+	}
+}
+
+// WritePIDFile writes the process ID to path and returns a function removing it again. A PID
+// file left by a process that is still running is refused; one left by a crash is replaced.
+func WritePIDFile(path string) (func(), error) {
+	if data, err := os.ReadFile(path); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && pid != os.Getpid() && ProcessRunning(pid) {
+			return nil, fmt.Errorf("%s: already running as process %d", path, pid)
+		}
+	}
+
+	pid := strconv.Itoa(os.Getpid())
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, []byte(pid+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write PID file: %w", err)
+	}
+	if err := os.Rename(temp, path); err != nil {
+		os.Remove(temp)
+		return nil, fmt.Errorf("failed to write PID file: %w", err)
+	}
+
+	return func() {
+		// A newer process may have taken over the file
+		if data, err := os.ReadFile(path); err == nil && strings.TrimSpace(string(data)) == pid {
+			os.Remove(path)
+		}
+	}, nil
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// NewServiceControl watches SIGINT and SIGTERM for stop requests and SIGHUP for reloads
+func NewServiceControl(name string) (*ServiceControl, error) {
+	c := newServiceControl()
+	c.watchSignals([]os.Signal{os.Interrupt, syscall.SIGTERM}, []os.Signal{syscall.SIGHUP})
+	return c, nil
+}
+
+// ProcessRunning reports whether a process with the given ID exists
+func ProcessRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,80 @@
+//go:build windows
+
+package server
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+)
+
+// NewServiceControl takes stop and reload requests from the Windows service control manager when
+// the process runs as a service (sc.exe stop, and paramchange for reloads), or from Ctrl+C when
+// run from a console
+func NewServiceControl(name string) (*ServiceControl, error) {
+	c := newServiceControl()
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return nil, err
+	}
+	if !isService {
+		c.watchSignals([]os.Signal{os.Interrupt}, nil)
+		return c, nil
+	}
+
+	handler := &serviceHandler{control: c, done: make(chan struct{})}
+	finished := make(chan error, 1)
+	go func() { finished <- svc.Run(name, handler) }()
+	c.closeFn = func() {
+		close(handler.done)
+		if err := <-finished; err != nil {
+			Error("Windows service %s: %v", name, err)
+		}
+	}
+	return c, nil
+}
+
+// serviceHandler relays service control requests to a ServiceControl
+type serviceHandler struct {
+	control *ServiceControl
+	done    chan struct{} // Closed once the daemon has stopped
+}
+
+// Execute runs for the lifetime of the service, reporting it stopped once the daemon has drained
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	const accepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptParamChange
+	status <- svc.Status{State: svc.Running, Accepts: accepted}
+	for {
+		select {
+		case <-h.done:
+			status <- svc.Status{State: svc.StopPending}
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				h.control.requestStop()
+			case svc.ParamChange:
+				h.control.requestReload()
+			}
+		}
+	}
+}
+
+// ProcessRunning reports whether a process with the given ID exists
+func ProcessRunning(pid int) bool {
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer windows.CloseHandle(handle)
+	var code uint32
+	if err := windows.GetExitCodeProcess(handle, &code); err != nil {
+		return false
+	}
+	const stillActive = 259
+	return code == stillActive
+}
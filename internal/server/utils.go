@@ -1,15 +1,15 @@
 package server
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"encoding/hex"
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"sync"
 
 	"github.com/auroradata-ai/cohort-bridge/internal/db"
+	"github.com/auroradata-ai/cohort-bridge/internal/keys"
 	"github.com/auroradata-ai/cohort-bridge/internal/pprl"
 )
 
@@ -77,105 +77,210 @@ func EnsureLogsDirectory() error {
 	return nil
 }
 
-// LoadTokenizedRecords loads PPRL records from tokenized data for zero-knowledge processing
-func LoadTokenizedRecords(filename string, isEncrypted bool, encryptionKey string, encryptionKeyFile string) ([]*pprl.Record, error) {
-	var actualFilename string
-	var needsCleanup bool
+// LoadTokenizedRecords loads PPRL records from tokenized data for zero-knowledge processing.
+// Encrypted files are decrypted in memory with the key named in their header, looked up in
+// keySource; a sibling .key file next to the data is also consulted.
+func LoadTokenizedRecords(filename string, isEncrypted bool, keySource keys.Source) ([]*pprl.Record, error) {
+	chain, encrypted, err := tokenizedKeyChain(filename, isEncrypted, keySource)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokenDB *db.TokenizedDatabase
+	if encrypted {
+		// The whole file is decrypted and authenticated before any record is parsed
+		plaintext, err := decryptTokenizedFile(filename, chain)
+		if err != nil {
+			return nil, err
+		}
+		tokenDB, err = db.NewTokenizedDatabaseFromReader(strings.TrimSuffix(filename, ".enc"), bytes.NewReader(plaintext))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tokenized database: %w", err)
+		}
+	} else {
+		tokenDB, err = db.NewTokenizedDatabase(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tokenized database: %w", err)
+		}
+	}
+
+	// Convert to Bloom filter records
+	bfRecords, err := tokenDB.ToBloomFilterRecords()
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert to Bloom filter records: %w", err)
+	}
 
+	// Convert to PPRL Record format for zero-knowledge processing
+	var records []*pprl.Record
+	for _, bfRecord := range bfRecords {
+		record, err := bloomRecordToPPRL(bfRecord)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// tokenizedKeyChain reports whether a tokenized file is encrypted (asked for, or named .enc) and
+// returns the keys to decrypt it with: keySource, then a sibling .key file if there is one
+func tokenizedKeyChain(filename string, isEncrypted bool, keySource keys.Source) (keys.Chain, bool, error) {
 	// Auto-detect encryption if filename ends with .enc
 	if !isEncrypted && strings.HasSuffix(filename, ".enc") {
 		isEncrypted = true
 	}
+	if !isEncrypted {
+		return nil, false, nil
+	}
 
-	// Handle encryption if specified or auto-detected
-	if isEncrypted {
-		var keyHex string
-		var err error
+	var chain keys.Chain
+	if keySource != nil {
+		chain = append(chain, keySource)
+	}
 
-		// Determine encryption key source
-		if encryptionKey != "" {
-			// Use provided hex key
-			keyHex = encryptionKey
-		} else if encryptionKeyFile != "" {
-			// Load key from file
-			keyHex, err = loadKeyFromFile(encryptionKeyFile)
+	// Try to find key file based on data filename
+	if strings.HasSuffix(filename, ".enc") {
+		keyFile := strings.TrimSuffix(filename, ".enc") + ".key"
+		if _, err := os.Stat(keyFile); err == nil {
+			key, err := keys.ReadKeyFile(keyFile)
 			if err != nil {
-				return nil, fmt.Errorf("failed to load encryption key from %s: %w", encryptionKeyFile, err)
-			}
-		} else {
-			// Try to find key file based on data filename
-			if strings.HasSuffix(filename, ".enc") {
-				keyFile := strings.TrimSuffix(filename, ".enc") + ".key"
-				if _, err := os.Stat(keyFile); err == nil {
-					keyHex, err = loadKeyFromFile(keyFile)
-					if err != nil {
-						return nil, fmt.Errorf("failed to load encryption key from %s: %w", keyFile, err)
-					}
-				} else {
-					return nil, fmt.Errorf("encrypted tokenized file %s found but no encryption key specified and no key file %s available", filename, keyFile)
-				}
-			} else {
-				return nil, fmt.Errorf("data marked as encrypted but no encryption key specified")
+				return nil, true, fmt.Errorf("failed to load encryption key from %s: %w", keyFile, err)
 			}
+			chain = append(chain, &keys.StaticSource{Keys: []*keys.Key{key}})
 		}
+	}
+	return chain, true, nil
+}
 
-		// Decrypt the file to a temporary location
-		tempFile := filename + ".tmp_decrypted"
+// decryptTokenizedFile decrypts a tokenized file into memory; the plaintext never reaches the disk
+func decryptTokenizedFile(filename string, chain keys.Chain) ([]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tokenized file %s: %w", filename, err)
+	}
+	defer file.Close()
 
-		if err := decryptFile(filename, tempFile, keyHex); err != nil {
-			return nil, fmt.Errorf("failed to decrypt tokenized file %s: %w", filename, err)
+	var plaintext bytes.Buffer
+	if _, err := keys.DecryptStream(&plaintext, file, chain); err != nil {
+		return nil, fmt.Errorf("failed to decrypt tokenized file %s: %w", filename, err)
+	}
+	return plaintext.Bytes(), nil
+}
+
+// decryptingReader yields the plaintext of an encrypted tokenized file as it is decrypted, one
+// authenticated chunk at a time, so a large file is neither held in memory nor written to disk
+type decryptingReader struct {
+	*io.PipeReader
+	file *os.File
+	done chan struct{}
+}
+
+// openDecryptingReader starts decrypting filename with the keys in chain
+func openDecryptingReader(filename string, chain keys.Chain) (*decryptingReader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tokenized file %s: %w", filename, err)
+	}
+	pr, pw := io.Pipe()
+	r := &decryptingReader{PipeReader: pr, file: file, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		if _, err := keys.DecryptStream(pw, file, chain); err != nil {
+			pw.CloseWithError(fmt.Errorf("failed to decrypt tokenized file %s: %w", filename, err))
+			return
 		}
+		pw.Close()
+	}()
+	return r, nil
+}
 
-		actualFilename = tempFile
-		needsCleanup = true
+// Close stops decryption and closes the file
+func (r *decryptingReader) Close() error {
+	r.PipeReader.Close()
+	<-r.done
+	return r.file.Close()
+}
 
-		// Schedule cleanup of temporary file
-		defer func() {
-			if needsCleanup {
-				os.Remove(tempFile)
-			}
-		}()
-	} else {
-		// Regular plaintext file
-		actualFilename = filename
+// bloomRecordToPPRL converts a decoded tokenized record to a PPRL record, recomputing its MinHash signature
+func bloomRecordToPPRL(bfRecord db.BloomFilterRecord) (*pprl.Record, error) {
+	// Encode Bloom filter to base64
+	bloomData, err := bfRecord.BloomFilter.ToBase64()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode Bloom filter: %w", err)
 	}
 
-	// Load tokenized database from the actual file (decrypted temp file or original plaintext)
-	tokenDB, err := db.NewTokenizedDatabase(actualFilename)
+	// Compute MinHash signature from the Bloom filter
+	signature, err := bfRecord.MinHash.ComputeSignature(bfRecord.BloomFilter)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load tokenized database: %w", err)
+		return nil, fmt.Errorf("failed to compute MinHash signature: %w", err)
 	}
 
-	// Convert to Bloom filter records
-	bfRecords, err := tokenDB.ToBloomFilterRecords()
+	return &pprl.Record{
+		ID:        bfRecord.ID,
+		BloomData: bloomData,
+		MinHash:   signature,
+		QGramData: "", // Not used in tokenized records
+
+		BlockingKeys: bfRecord.BlockingKeys,
+	}, nil
+}
+
+// TokenizedRecordStream reads PPRL records from a tokenized CSV file one at a time, so the file is
+// never held in memory. Encrypted files are decrypted as they are read, with keys found as in
+// LoadTokenizedRecords.
+type TokenizedRecordStream struct {
+	reader *db.TokenizedReader
+}
+
+// OpenTokenizedRecordStream opens a tokenized file for streaming
+func OpenTokenizedRecordStream(filename string, isEncrypted bool, keySource keys.Source) (*TokenizedRecordStream, error) {
+	reader, err := OpenTokenizedReader(filename, isEncrypted, keySource)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert to Bloom filter records: %w", err)
+		return nil, err
 	}
+	return &TokenizedRecordStream{reader: reader}, nil
+}
 
-	// Convert to PPRL Record format for zero-knowledge processing
-	var records []*pprl.Record
-	for _, bfRecord := range bfRecords {
-		// Encode Bloom filter to base64
-		bloomData, err := bfRecord.BloomFilter.ToBase64()
-		if err != nil {
-			return nil, fmt.Errorf("failed to encode Bloom filter: %w", err)
-		}
+// OpenTokenizedReader opens a tokenized file for reading its rows as they were written. Encrypted
+// files are decrypted as they are read, with keys found as in LoadTokenizedRecords.
+func OpenTokenizedReader(filename string, isEncrypted bool, keySource keys.Source) (*db.TokenizedReader, error) {
+	chain, encrypted, err := tokenizedKeyChain(filename, isEncrypted, keySource)
+	if err != nil {
+		return nil, err
+	}
+	if !encrypted {
+		return db.OpenTokenizedReader(filename)
+	}
 
-		// Compute MinHash signature from the Bloom filter
-		signature, err := bfRecord.MinHash.ComputeSignature(bfRecord.BloomFilter)
-		if err != nil {
-			return nil, fmt.Errorf("failed to compute MinHash signature: %w", err)
-		}
+	plaintext, err := openDecryptingReader(filename, chain)
+	if err != nil {
+		return nil, err
+	}
+	return db.NewTokenizedReader(strings.TrimSuffix(filename, ".enc"), plaintext)
+}
 
-		records = append(records, &pprl.Record{
-			ID:        bfRecord.ID,
-			BloomData: bloomData,
-			MinHash:   signature,
-			QGramData: "", // Not used in tokenized records
-		})
+// Next returns the next record, or io.EOF after the last one
+func (s *TokenizedRecordStream) Next() (*pprl.Record, error) {
+	tokenized, err := s.reader.Next()
+	if err != nil {
+		return nil, err
 	}
+	return TokenizedToRecord(tokenized)
+}
 
-	return records, nil
+// TokenizedToRecord decodes a tokenized row into a PPRL record
+func TokenizedToRecord(tokenized *db.TokenizedRecord) (*pprl.Record, error) {
+	bfRecord, err := tokenized.ToBloomFilterRecord()
+	if err != nil {
+		return nil, err
+	}
+	return bloomRecordToPPRL(bfRecord)
+}
+
+// Close closes the file
+func (s *TokenizedRecordStream) Close() error {
+	return s.reader.Close()
 }
 
 // LoadPatientRecordsUtil converts CSV data to zero-knowledge PPRL records
@@ -418,86 +523,3 @@ func (iter *ZKStreamingRecordIterator) GetEstimatedTotalRecords() (int, error) {
 	}
 	return len(tempRecords), nil
 }
-
-// loadKeyFromFile loads an encryption key from a file
-func loadKeyFromFile(keyFile string) (string, error) {
-	keyData, err := os.ReadFile(keyFile)
-	if err != nil {
-		return "", fmt.Errorf("failed to read key file: %w", err)
-	}
-
-	// Parse the file line by line, ignoring comments
-	lines := strings.Split(string(keyData), "\n")
-	var keyHex string
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		// First non-comment line should be the key
-		keyHex = line
-		break
-	}
-
-	if keyHex == "" {
-		return "", fmt.Errorf("no encryption key found in file")
-	}
-
-	// Validate hex format
-	if _, err := hex.DecodeString(keyHex); err != nil {
-		return "", fmt.Errorf("invalid hex key format: %w", err)
-	}
-
-	return keyHex, nil
-}
-
-// decryptFile decrypts a file using AES-GCM with the provided hex key
-func decryptFile(inputFile, outputFile, keyHex string) error {
-	// Decode hex key
-	key, err := hex.DecodeString(keyHex)
-	if err != nil {
-		return fmt.Errorf("invalid hex key: %w", err)
-	}
-
-	// Read encrypted file
-	encryptedData, err := os.ReadFile(inputFile)
-	if err != nil {
-		return fmt.Errorf("failed to read encrypted file: %w", err)
-	}
-
-	// Create AES cipher
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return fmt.Errorf("failed to create AES cipher: %w", err)
-	}
-
-	// Create GCM
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return fmt.Errorf("failed to create GCM: %w", err)
-	}
-
-	// Extract nonce and ciphertext
-	nonceSize := gcm.NonceSize()
-	if len(encryptedData) < nonceSize {
-		return fmt.Errorf("encrypted data too short")
-	}
-
-	nonce := encryptedData[:nonceSize]
-	ciphertext := encryptedData[nonceSize:]
-
-	// Decrypt
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
-	if err != nil {
-		return fmt.Errorf("failed to decrypt: %w", err)
-	}
-
-	// Write decrypted data
-	if err := os.WriteFile(outputFile, plaintext, 0644); err != nil {
-		return fmt.Errorf("failed to write decrypted file: %w", err)
-	}
-
-	return nil
-}
@@ -0,0 +1,323 @@
+// Package store keeps a local registry of tokenize, intersect, dedupe, export, pprl, batch and serve runs
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Run states
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// DefaultPath is where the run registry lives unless COHORT_RUNS_DB says otherwise
+const DefaultPath = "logs/runs.db"
+
+// PathEnvVar overrides the registry location; set it to "off" to disable recording
+const PathEnvVar = "COHORT_RUNS_DB"
+
+var runsBucket = []byte("runs")
+
+// ErrNotFound is returned when no run matches an ID
+var ErrNotFound = errors.New("run not found")
+
+// FileDigest identifies an input file by content
+type FileDigest struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// Run is one recorded invocation of a linkage command
+type Run struct {
+	ID         string            `json:"id"`
+	Command    string            `json:"command"`
+	Status     string            `json:"status"`
+	Error      string            `json:"error,omitempty"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+	Inputs     []FileDigest      `json:"inputs,omitempty"`
+	Counts     map[string]int    `json:"counts,omitempty"`
+	Outputs    []string          `json:"outputs,omitempty"`
+	Quality    *Quality          `json:"quality,omitempty"`
+
+	// Provenance for reproducing the run: the build, the command line and the resolved
+	// configuration (secrets redacted) with its digest
+	Build        *BuildInfo      `json:"build,omitempty"`
+	Arguments    []string        `json:"arguments,omitempty"`
+	Config       json.RawMessage `json:"config,omitempty"`
+	ConfigSHA256 string          `json:"config_sha256,omitempty"`
+}
+
+// BuildInfo identifies the cohort-bridge build that performed a run
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit,omitempty"` // "-dirty" marks uncommitted changes
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// Quality estimates a run's linkage quality from known pairs held out locally, such as a
+// clerically reviewed sample; only matches involving a listed record are judged
+type Quality struct {
+	Holdout        string  `json:"holdout"`
+	KnownPairs     int     `json:"known_pairs"`
+	TruePositives  int     `json:"true_positives"`
+	FalsePositives int     `json:"false_positives"`
+	FalseNegatives int     `json:"false_negatives"`
+	Precision      float64 `json:"precision"`
+	Recall         float64 `json:"recall"`
+	F1Score        float64 `json:"f1_score"`
+}
+
+// ManifestSuffix is appended to an output path to name the run manifest written beside it
+const ManifestSuffix = ".run.json"
+
+// NewRun starts a run record for command. IDs sort chronologically.
+func NewRun(command string) *Run {
+	now := time.Now().UTC()
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return &Run{
+		ID:         now.Format("20060102T150405") + "-" + hex.EncodeToString(suffix),
+		Command:    command,
+		StartedAt:  now,
+		Parameters: make(map[string]string),
+		Counts:     make(map[string]int),
+	}
+}
+
+// AddInput hashes path and records it as an input; unreadable files are recorded without a digest
+func (r *Run) AddInput(path string) {
+	digest, err := HashFile(path)
+	if err != nil {
+		digest = FileDigest{Path: path}
+	}
+	r.Inputs = append(r.Inputs, digest)
+}
+
+// AddOutput records an output path, made absolute when possible
+func (r *Run) AddOutput(path string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	r.Outputs = append(r.Outputs, path)
+}
+
+// Finish marks the run as succeeded, or failed with err
+func (r *Run) Finish(err error) {
+	r.FinishedAt = time.Now().UTC()
+	if err != nil {
+		r.Status = StatusFailed
+		r.Error = err.Error()
+		return
+	}
+	r.Status = StatusSucceeded
+}
+
+// SetConfig records the resolved configuration, as JSON with sorted keys, and its SHA-256 digest
+func (r *Run) SetConfig(resolved map[string]interface{}) error {
+	data, err := json.Marshal(resolved)
+	if err != nil {
+		return fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	r.Config, r.ConfigSHA256 = data, hex.EncodeToString(sum[:])
+	return nil
+}
+
+// WriteManifests writes the run record as JSON beside every output that is a local file, at
+// <output>.run.json, and returns the manifests written. Object URLs and database tables are skipped.
+func (r *Run) WriteManifests() ([]string, error) {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, '\n')
+
+	var written []string
+	for _, output := range r.Outputs {
+		if info, err := os.Stat(output); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		path := output + ManifestSuffix
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return written, fmt.Errorf("failed to write run manifest: %w", err)
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
+
+// Duration is how long the run took
+func (r *Run) Duration() time.Duration {
+	if r.FinishedAt.IsZero() {
+		return 0
+	}
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// HashFile returns the SHA-256 digest and size of a file
+func HashFile(path string) (FileDigest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FileDigest{}, err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return FileDigest{}, err
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return FileDigest{Path: path, SHA256: hex.EncodeToString(h.Sum(nil)), Size: size}, nil
+}
+
+// Store is an open run registry
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens or creates the registry at path
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create registry directory: %w", err)
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open run registry %s: %w", path, err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(runsBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the registry
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Save inserts or replaces a run
+func (s *Store) Save(run *Run) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(runsBucket).Put([]byte(run.ID), data)
+	})
+}
+
+// Get returns the run with the given ID or unique ID prefix
+func (s *Store) Get(id string) (*Run, error) {
+	var found []*Run
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(runsBucket).Cursor()
+		prefix := []byte(id)
+		for k, v := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), id); k, v = c.Next() {
+			var run Run
+			if err := json.Unmarshal(v, &run); err != nil {
+				return fmt.Errorf("corrupt run %s: %w", k, err)
+			}
+			found = append(found, &run)
+			if string(k) == id {
+				found = found[len(found)-1:]
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch len(found) {
+	case 0:
+		return nil, ErrNotFound
+	case 1:
+		return found[0], nil
+	default:
+		return nil, fmt.Errorf("run ID prefix %q is ambiguous (%d runs)", id, len(found))
+	}
+}
+
+// List returns up to limit runs, newest first, optionally filtered by command
+func (s *Store) List(command string, limit int) ([]*Run, error) {
+	var runs []*Run
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(runsBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if limit > 0 && len(runs) >= limit {
+				break
+			}
+			var run Run
+			if err := json.Unmarshal(v, &run); err != nil {
+				return fmt.Errorf("corrupt run %s: %w", k, err)
+			}
+			if command != "" && run.Command != command {
+				continue
+			}
+			runs = append(runs, &run)
+		}
+		return nil
+	})
+	return runs, err
+}
+
+// ResolvePath returns the absolute registry location, or "" when recording is disabled
+func ResolvePath() string {
+	path := os.Getenv(PathEnvVar)
+	if strings.EqualFold(path, "off") {
+		return ""
+	}
+	if path == "" {
+		path = DefaultPath
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return path
+}
+
+// recordMu serializes registry access within a process; the file lock does across processes
+var recordMu sync.Mutex
+
+// Record saves run to the registry at path, opening it only for the duration of the write
+// so that concurrent commands and 'runs list' are not locked out
+func Record(path string, run *Run) error {
+	if path == "" {
+		return nil
+	}
+	recordMu.Lock()
+	defer recordMu.Unlock()
+
+	s, err := Open(path)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return s.Save(run)
+}
@@ -0,0 +1,189 @@
+// corrupt.go
+// Package synth provides the corruption models applied to records shared by both datasets.
+package synth
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// CorruptionRates are the per-record probabilities of applying each corruption model.
+// Models are applied independently, so one record may receive several.
+type CorruptionRates struct {
+	Typo     float64 // Keyboard typo in a name: substitution, deletion, insertion or transposition
+	OCR      float64 // Scanning confusion such as rn/m, cl/d or 5/6 in any field
+	Phonetic float64 // Sound-alike respelling of a name (ph/f, ck/k, double letters)
+	Missing  float64 // One field left blank
+	Nickname float64 // First name replaced by a common nickname
+}
+
+// DefaultCorruptions are the rates of the synth command when none are given
+var DefaultCorruptions = CorruptionRates{Typo: 0.10, OCR: 0.05, Phonetic: 0.05, Missing: 0.05, Nickname: 0.05}
+
+// Validate checks that every rate is a probability
+func (r CorruptionRates) Validate() error {
+	for name, rate := range map[string]float64{
+		"typo": r.Typo, "ocr": r.OCR, "phonetic": r.Phonetic, "missing": r.Missing, "nickname": r.Nickname,
+	} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("%s rate must be between 0 and 1 (got %v)", name, rate)
+		}
+	}
+	return nil
+}
+
+// ocrConfusions are character sequences commonly misread by OCR, in both directions
+var ocrConfusions = [][2]string{
+	{"rn", "m"}, {"cl", "d"}, {"vv", "w"}, {"li", "h"}, {"e", "c"}, {"o", "c"}, {"n", "h"}, {"i", "l"},
+	{"1", "7"}, {"3", "8"}, {"5", "6"}, {"0", "8"}, {"2", "7"},
+}
+
+// phoneticSwaps are sound-alike spellings, in both directions
+var phoneticSwaps = [][2]string{
+	{"ph", "f"}, {"ck", "k"}, {"ca", "ka"}, {"co", "ko"}, {"z", "s"}, {"ei", "ie"}, {"y", "ie"},
+	{"ll", "l"}, {"tt", "t"}, {"nn", "n"}, {"rr", "r"}, {"ss", "s"}, {"th", "t"}, {"ou", "ow"},
+	{"ae", "e"}, {"mac", "mc"}, {"sen", "son"}, {"er", "ar"},
+}
+
+// keyboardNeighbors maps a lowercase key to keys next to it on a QWERTY keyboard
+var keyboardNeighbors = map[byte]string{
+	'q': "wa", 'w': "qeas", 'e': "wrds", 'r': "etdf", 't': "ryfg", 'y': "tugh", 'u': "yihj", 'i': "uojk",
+	'o': "ipkl", 'p': "ol", 'a': "qwsz", 's': "awedxz", 'd': "serfcx", 'f': "drtgvc", 'g': "ftyhbv",
+	'h': "gyujnb", 'j': "huikmn", 'k': "jiolm", 'l': "kop", 'z': "asx", 'x': "zsdc", 'c': "xdfv",
+	'v': "cfgb", 'b': "vghn", 'n': "bhjm", 'm': "njk",
+}
+
+// corrupter applies the configured corruption models to a row
+type corrupter struct {
+	rng   *rand.Rand
+	rates CorruptionRates
+}
+
+func newCorrupter(rng *rand.Rand, rates CorruptionRates) *corrupter {
+	return &corrupter{rng: rng, rates: rates}
+}
+
+// corrupt returns a corrupted copy of row
+func (c *corrupter) corrupt(row []string) []string {
+	out := append([]string{}, row...)
+
+	if c.rng.Float64() < c.rates.Nickname {
+		out[colFirstName] = c.nickname(out[colFirstName])
+	}
+	if c.rng.Float64() < c.rates.Phonetic {
+		field := colFirstName + c.rng.Intn(2)
+		out[field] = c.phonetic(out[field])
+	}
+	if c.rng.Float64() < c.rates.Typo {
+		field := colFirstName + c.rng.Intn(2)
+		out[field] = c.typo(out[field])
+	}
+	if c.rng.Float64() < c.rates.OCR {
+		fields := []int{colFirstName, colLastName, colDOB, colZip}
+		field := fields[c.rng.Intn(len(fields))]
+		out[field] = c.ocr(out[field])
+	}
+	if c.rng.Float64() < c.rates.Missing {
+		out[colFirstName+c.rng.Intn(len(Header)-1)] = ""
+	}
+	return out
+}
+
+// nickname replaces a first name with one of its nicknames, if it has any
+func (c *corrupter) nickname(name string) string {
+	options := nicknames[name]
+	if len(options) == 0 {
+		return name
+	}
+	return options[c.rng.Intn(len(options))]
+}
+
+// phonetic applies one applicable sound-alike swap, preserving the leading capital
+func (c *corrupter) phonetic(name string) string {
+	lower := strings.ToLower(name)
+	type candidate struct{ from, to string }
+	var candidates []candidate
+	for _, swap := range phoneticSwaps {
+		if strings.Contains(lower, swap[0]) {
+			candidates = append(candidates, candidate{swap[0], swap[1]})
+		}
+		if strings.Contains(lower, swap[1]) {
+			candidates = append(candidates, candidate{swap[1], swap[0]})
+		}
+	}
+	if len(candidates) == 0 {
+		return name
+	}
+	pick := candidates[c.rng.Intn(len(candidates))]
+	return matchCase(name, replaceOne(lower, pick.from, pick.to, c.rng))
+}
+
+// typo applies one keyboard error after the first character
+func (c *corrupter) typo(name string) string {
+	if len(name) < 3 {
+		return name
+	}
+	b := []byte(strings.ToLower(name))
+	pos := 1 + c.rng.Intn(len(b)-1)
+	switch c.rng.Intn(4) {
+	case 0: // Substitute a neighboring key
+		if neighbors, ok := keyboardNeighbors[b[pos]]; ok {
+			b[pos] = neighbors[c.rng.Intn(len(neighbors))]
+		}
+	case 1: // Delete
+		b = append(b[:pos], b[pos+1:]...)
+	case 2: // Insert a duplicate
+		b = append(b[:pos], append([]byte{b[pos]}, b[pos:]...)...)
+	default: // Transpose with the previous character
+		if pos > 1 {
+			b[pos-1], b[pos] = b[pos], b[pos-1]
+		} else if pos+1 < len(b) {
+			b[pos], b[pos+1] = b[pos+1], b[pos]
+		}
+	}
+	return matchCase(name, string(b))
+}
+
+// ocr applies one applicable scanning confusion
+func (c *corrupter) ocr(value string) string {
+	lower := strings.ToLower(value)
+	type candidate struct{ from, to string }
+	var candidates []candidate
+	for _, confusion := range ocrConfusions {
+		if strings.Contains(lower, confusion[0]) {
+			candidates = append(candidates, candidate{confusion[0], confusion[1]})
+		}
+		if strings.Contains(lower, confusion[1]) {
+			candidates = append(candidates, candidate{confusion[1], confusion[0]})
+		}
+	}
+	if len(candidates) == 0 {
+		return value
+	}
+	pick := candidates[c.rng.Intn(len(candidates))]
+	return matchCase(value, replaceOne(lower, pick.from, pick.to, c.rng))
+}
+
+// replaceOne replaces a randomly chosen occurrence of from with to
+func replaceOne(s, from, to string, rng *rand.Rand) string {
+	var positions []int
+	for i := 0; i+len(from) <= len(s); i++ {
+		if s[i:i+len(from)] == from {
+			positions = append(positions, i)
+		}
+	}
+	if len(positions) == 0 {
+		return s
+	}
+	pos := positions[rng.Intn(len(positions))]
+	return s[:pos] + to + s[pos+len(from):]
+}
+
+// matchCase capitalizes corrupted like original, which is a capitalized name or a non-alphabetic value
+func matchCase(original, corrupted string) string {
+	if original == "" || corrupted == "" || original[0] < 'A' || original[0] > 'Z' {
+		return corrupted
+	}
+	return strings.ToUpper(corrupted[:1]) + corrupted[1:]
+}
@@ -0,0 +1,46 @@
+// names.go
+// Package synth provides the name lists used to generate synthetic people.
+package synth
+
+var maleFirstNames = []string{
+	"James", "John", "Robert", "Michael", "William", "David", "Richard", "Joseph", "Thomas", "Charles",
+	"Christopher", "Daniel", "Matthew", "Anthony", "Donald", "Steven", "Andrew", "Joshua", "Kenneth", "Edward",
+	"Timothy", "Jeffrey", "Nicholas", "Jonathan", "Benjamin", "Samuel", "Alexander", "Patrick", "Raymond", "Gregory",
+}
+
+var femaleFirstNames = []string{
+	"Mary", "Patricia", "Jennifer", "Linda", "Elizabeth", "Barbara", "Susan", "Jessica", "Sarah", "Karen",
+	"Nancy", "Margaret", "Sandra", "Ashley", "Dorothy", "Kimberly", "Deborah", "Stephanie", "Rebecca", "Katherine",
+	"Christine", "Victoria", "Abigail", "Samantha", "Jacqueline", "Theresa", "Judith", "Catherine", "Alexandra", "Cynthia",
+}
+
+var lastNames = []string{
+	"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis", "Rodriguez", "Martinez",
+	"Hernandez", "Lopez", "Gonzalez", "Wilson", "Anderson", "Thomas", "Taylor", "Moore", "Jackson", "Martin",
+	"Lee", "Perez", "Thompson", "White", "Harris", "Sanchez", "Clark", "Ramirez", "Lewis", "Robinson",
+	"Walker", "Young", "Allen", "King", "Wright", "Scott", "Torres", "Nguyen", "Hill", "Flores",
+	"Phillips", "Mitchell", "Campbell", "Parker", "Evans", "Edwards", "Collins", "Stewart", "Morris", "Murphy",
+	"McDonald", "MacKenzie", "Schneider", "Fischer", "Petersen", "Hoffmann", "Russell", "Bennett", "Cooper", "Sullivan",
+}
+
+// nicknames maps formal first names to common nicknames
+var nicknames = map[string][]string{
+	"James": {"Jim", "Jimmy", "Jamie"}, "John": {"Jack", "Johnny"}, "Robert": {"Bob", "Rob", "Bobby", "Bert"},
+	"Michael": {"Mike", "Mikey", "Mick"}, "William": {"Bill", "Will", "Billy", "Liam"}, "David": {"Dave", "Davy"},
+	"Richard": {"Rick", "Dick", "Rich"}, "Joseph": {"Joe", "Joey"}, "Thomas": {"Tom", "Tommy"},
+	"Charles": {"Charlie", "Chuck", "Chas"}, "Christopher": {"Chris", "Kit"}, "Daniel": {"Dan", "Danny"},
+	"Matthew": {"Matt"}, "Anthony": {"Tony"}, "Donald": {"Don", "Donny"}, "Steven": {"Steve"},
+	"Andrew": {"Andy", "Drew"}, "Joshua": {"Josh"}, "Kenneth": {"Ken", "Kenny"}, "Edward": {"Ed", "Eddie", "Ted"},
+	"Timothy": {"Tim"}, "Jeffrey": {"Jeff"}, "Nicholas": {"Nick", "Nicky"}, "Jonathan": {"Jon"},
+	"Benjamin": {"Ben", "Benny"}, "Samuel": {"Sam", "Sammy"}, "Alexander": {"Alex", "Sandy"},
+	"Patrick": {"Pat", "Paddy"}, "Raymond": {"Ray"}, "Gregory": {"Greg"},
+	"Mary": {"Molly", "Polly", "Mae"}, "Patricia": {"Pat", "Patty", "Trish"}, "Jennifer": {"Jen", "Jenny"},
+	"Linda": {"Lindy"}, "Elizabeth": {"Liz", "Beth", "Betty", "Eliza"}, "Barbara": {"Barb", "Babs"},
+	"Susan": {"Sue", "Susie"}, "Jessica": {"Jess", "Jessie"}, "Sarah": {"Sally", "Sadie"}, "Karen": {"Kay"},
+	"Nancy": {"Nan"}, "Margaret": {"Maggie", "Peggy", "Meg"}, "Sandra": {"Sandy"}, "Ashley": {"Ash"},
+	"Dorothy": {"Dot", "Dottie"}, "Kimberly": {"Kim"}, "Deborah": {"Deb", "Debbie"}, "Stephanie": {"Steph"},
+	"Rebecca": {"Becky", "Becca"}, "Katherine": {"Kate", "Kathy", "Katie"}, "Christine": {"Chris", "Tina"},
+	"Victoria": {"Vicky", "Tori"}, "Abigail": {"Abby"}, "Samantha": {"Sam", "Sammy"}, "Jacqueline": {"Jackie"},
+	"Theresa": {"Terry", "Tess"}, "Judith": {"Judy"}, "Catherine": {"Cathy", "Kate"}, "Alexandra": {"Alex", "Sandra"},
+	"Cynthia": {"Cindy"},
+}
@@ -0,0 +1,149 @@
+// synth.go
+// Package synth generates paired synthetic patient datasets with known overlap and
+// realistic corruption, so tokenization settings can be benchmarked without real PHI.
+package synth
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// Header is the column layout of generated datasets; it matches the field names in
+// config_basic.example.yaml
+var Header = []string{"id", "first_name", "last_name", "date_of_birth", "gender", "zip_code"}
+
+// Column indexes into a generated row
+const (
+	colID = iota
+	colFirstName
+	colLastName
+	colDOB
+	colGender
+	colZip
+)
+
+// Config controls dataset sizes, overlap and corruption of the shared records
+type Config struct {
+	RecordsA    int     // Records in dataset A
+	RecordsB    int     // Records in dataset B
+	Overlap     float64 // Fraction of the smaller dataset present in both
+	Seed        int64
+	Corruptions CorruptionRates
+}
+
+// Dataset is a generated pair of datasets with the true A->B mapping
+type Dataset struct {
+	RowsA       [][]string
+	RowsB       [][]string
+	GroundTruth [][2]string // (id in A, id in B)
+}
+
+// Generate builds two datasets; shared people appear in both, corrupted in dataset B
+func Generate(cfg Config) (*Dataset, error) {
+	if cfg.RecordsA <= 0 || cfg.RecordsB <= 0 {
+		return nil, fmt.Errorf("record counts must be positive")
+	}
+	if cfg.Overlap < 0 || cfg.Overlap > 1 {
+		return nil, fmt.Errorf("overlap must be between 0 and 1")
+	}
+	if err := cfg.Corruptions.Validate(); err != nil {
+		return nil, err
+	}
+
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	corrupter := newCorrupter(rng, cfg.Corruptions)
+
+	smaller := cfg.RecordsA
+	if cfg.RecordsB < smaller {
+		smaller = cfg.RecordsB
+	}
+	shared := int(float64(smaller)*cfg.Overlap + 0.5)
+
+	dataset := &Dataset{}
+	for i := 0; i < cfg.RecordsA; i++ {
+		row := newPerson(rng)
+		row[colID] = fmt.Sprintf("A%06d", i+1)
+		dataset.RowsA = append(dataset.RowsA, row)
+	}
+
+	// sources records which dataset A row each dataset B row was derived from (-1 for none)
+	var sources []int
+	for i := 0; i < cfg.RecordsB; i++ {
+		if i < shared {
+			dataset.RowsB = append(dataset.RowsB, corrupter.corrupt(dataset.RowsA[i]))
+			sources = append(sources, i)
+		} else {
+			dataset.RowsB = append(dataset.RowsB, newPerson(rng))
+			sources = append(sources, -1)
+		}
+	}
+
+	// Shuffle dataset B so linkage cannot rely on row order
+	rng.Shuffle(len(dataset.RowsB), func(i, j int) {
+		dataset.RowsB[i], dataset.RowsB[j] = dataset.RowsB[j], dataset.RowsB[i]
+		sources[i], sources[j] = sources[j], sources[i]
+	})
+	for i, row := range dataset.RowsB {
+		row[colID] = fmt.Sprintf("B%06d", i+1)
+		if sources[i] >= 0 {
+			dataset.GroundTruth = append(dataset.GroundTruth, [2]string{dataset.RowsA[sources[i]][colID], row[colID]})
+		}
+	}
+	return dataset, nil
+}
+
+// newPerson creates a random person row (ID left blank)
+func newPerson(rng *rand.Rand) []string {
+	gender := "M"
+	first := maleFirstNames[rng.Intn(len(maleFirstNames))]
+	if rng.Intn(2) == 0 {
+		gender = "F"
+		first = femaleFirstNames[rng.Intn(len(femaleFirstNames))]
+	}
+	dob := time.Date(1930, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, rng.Intn(365*85))
+	return []string{
+		"",
+		first,
+		lastNames[rng.Intn(len(lastNames))],
+		dob.Format("2006-01-02"),
+		gender,
+		fmt.Sprintf("%05d", 10000+rng.Intn(89999)),
+	}
+}
+
+// WriteDatasets writes both datasets with Header
+func (d *Dataset) WriteDatasets(fileA, fileB string) error {
+	if err := writeCSV(fileA, Header, d.RowsA); err != nil {
+		return err
+	}
+	return writeCSV(fileB, Header, d.RowsB)
+}
+
+// WriteGroundTruth writes the true matches as an id1,id2 CSV readable by validate
+func (d *Dataset) WriteGroundTruth(filename string) error {
+	rows := make([][]string, len(d.GroundTruth))
+	for i, pair := range d.GroundTruth {
+		rows[i] = []string{pair[0], pair[1]}
+	}
+	return writeCSV(filename, []string{"id1", "id2"}, rows)
+}
+
+// writeCSV writes a header and rows to a CSV file
+func writeCSV(filename string, header []string, rows [][]string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write(header)
+	writer.WriteAll(rows)
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	return nil
+}
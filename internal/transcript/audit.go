@@ -0,0 +1,144 @@
+// audit.go
+// Package transcript provides validation of recorded peer transcripts.
+package transcript
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// DefaultAllowedTypes are the message types of the PPRL peer protocol
+var DefaultAllowedTypes = []string{"hello", "handshake", "handshake_confirm", "tokens", "intersection_digest", "intersection", "reconciled_digest"}
+
+// AuditOptions control which rules a transcript is checked against
+type AuditOptions struct {
+	AllowedTypes []string
+	Secure       bool // Reject any message carrying raw Bloom filters
+}
+
+// Report is the result of auditing a transcript
+type Report struct {
+	Entries    int
+	Sent       int
+	Received   int
+	Bytes      int
+	Types      map[string]int
+	Violations []string
+}
+
+// Passed reports whether the transcript satisfied every rule
+func (r *Report) Passed() bool {
+	return len(r.Violations) == 0
+}
+
+// Load reads a transcript file
+func Load(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("transcript: failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("transcript: %s line %d: %w", path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("transcript: failed to read %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// Audit checks a transcript against the given options
+func Audit(entries []Entry, opts AuditOptions) *Report {
+	allowedTypes := opts.AllowedTypes
+	if len(allowedTypes) == 0 {
+		allowedTypes = DefaultAllowedTypes
+	}
+	allowed := make(map[string]bool)
+	for _, t := range allowedTypes {
+		allowed[t] = true
+	}
+
+	report := &Report{Entries: len(entries), Types: make(map[string]int)}
+	lastSeq := 0
+	for _, entry := range entries {
+		report.Types[entry.Type]++
+		report.Bytes += entry.Size
+		switch entry.Direction {
+		case DirectionSent:
+			report.Sent++
+		case DirectionReceived:
+			report.Received++
+		default:
+			report.Violations = append(report.Violations,
+				fmt.Sprintf("entry %d: unknown direction %q", entry.Seq, entry.Direction))
+		}
+
+		if entry.Seq <= lastSeq {
+			report.Violations = append(report.Violations,
+				fmt.Sprintf("entry %d: sequence number not increasing (previous %d)", entry.Seq, lastSeq))
+		}
+		lastSeq = entry.Seq
+
+		if !allowed[entry.Type] {
+			report.Violations = append(report.Violations,
+				fmt.Sprintf("entry %d: message type %q is not allowed", entry.Seq, entry.Type))
+		}
+		if opts.Secure && entry.BloomFilters > 0 {
+			report.Violations = append(report.Violations,
+				fmt.Sprintf("entry %d: %s %q message carries %d raw Bloom filter(s)", entry.Seq, entry.Direction, entry.Type, entry.BloomFilters))
+		}
+	}
+	return report
+}
+
+// CrossCheck compares the transcripts of both parties: every message one side sent must
+// have been received unchanged, in order, by the other
+func CrossCheck(local, peer []Entry) []string {
+	var problems []string
+	problems = append(problems, compareStreams("local sent", filterDirection(local, DirectionSent),
+		"peer received", filterDirection(peer, DirectionReceived))...)
+	problems = append(problems, compareStreams("peer sent", filterDirection(peer, DirectionSent),
+		"local received", filterDirection(local, DirectionReceived))...)
+	return problems
+}
+
+// filterDirection returns the entries travelling in one direction
+func filterDirection(entries []Entry, direction string) []Entry {
+	var out []Entry
+	for _, entry := range entries {
+		if entry.Direction == direction {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+// compareStreams reports digest mismatches between a sent and a received stream
+func compareStreams(sentName string, sent []Entry, receivedName string, received []Entry) []string {
+	var problems []string
+	if len(sent) != len(received) {
+		problems = append(problems, fmt.Sprintf("%s %d message(s) but %s %d", sentName, len(sent), receivedName, len(received)))
+	}
+	for i := 0; i < len(sent) && i < len(received); i++ {
+		if sent[i].Digest != received[i].Digest {
+			problems = append(problems, fmt.Sprintf("message %d (%s): digest in %s does not match %s",
+				i+1, sent[i].Type, sentName, receivedName))
+		}
+	}
+	return problems
+}
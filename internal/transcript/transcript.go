@@ -0,0 +1,170 @@
+// transcript.go
+// Package transcript records an auditable, digest-only log of the messages exchanged with a peer.
+// Payload contents are never written: each entry holds the message type, its size, a SHA-256
+// digest and the number of raw Bloom filters it carried.
+package transcript
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Directions of a recorded message
+const (
+	DirectionSent     = "sent"
+	DirectionReceived = "received"
+)
+
+// bloomFieldNames are payload keys whose values are raw Bloom filters
+var bloomFieldNames = map[string]bool{
+	"bloom_filter": true,
+	"bloom_data":   true,
+	"BloomData":    true,
+	"bloom":        true,
+}
+
+// Entry describes one message on the wire
+type Entry struct {
+	Seq          int       `json:"seq"`
+	Time         time.Time `json:"time"`
+	Direction    string    `json:"direction"`
+	Type         string    `json:"type"`
+	Size         int       `json:"size"`
+	Digest       string    `json:"digest"`                  // sha256 of the raw message bytes
+	BloomFilters int       `json:"bloom_filters,omitempty"` // Number of raw Bloom filter values in the payload
+}
+
+// Recorder appends transcript entries to a JSON lines file
+type Recorder struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+	seq  int
+}
+
+// NewRecorder creates (or truncates) a transcript file
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("transcript: failed to create %s: %w", path, err)
+	}
+	return &Recorder{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Record adds an entry for one raw message
+func (r *Recorder) Record(direction string, message []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.seq++
+	entry := Describe(message)
+	entry.Seq = r.seq
+	entry.Time = time.Now().UTC()
+	entry.Direction = direction
+	return r.enc.Encode(entry)
+}
+
+// Close flushes and closes the transcript file
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// Describe builds an entry (without sequence, time and direction) for a raw message
+func Describe(message []byte) Entry {
+	message = bytes.TrimRight(message, "\r\n")
+	sum := sha256.Sum256(message)
+	entry := Entry{
+		Size:   len(message),
+		Digest: "sha256:" + hex.EncodeToString(sum[:]),
+		Type:   "invalid",
+	}
+
+	var msg struct {
+		Type    string          `json:"type"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return entry
+	}
+	entry.Type = msg.Type
+
+	var payload interface{}
+	if len(msg.Payload) > 0 && json.Unmarshal(msg.Payload, &payload) == nil {
+		entry.BloomFilters = countBloomFilters(payload)
+	}
+	return entry
+}
+
+// countBloomFilters counts non-empty Bloom filter values anywhere in a payload.
+// Field names are not recorded since map keys may be record IDs.
+func countBloomFilters(value interface{}) int {
+	count := 0
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if s, ok := child.(string); ok && bloomFieldNames[key] && s != "" {
+				count++
+			}
+			count += countBloomFilters(child)
+		}
+	case []interface{}:
+		for _, child := range v {
+			count += countBloomFilters(child)
+		}
+	}
+	return count
+}
+
+// Conn wraps a peer connection and records every newline-delimited JSON message
+// written to or read from it
+type Conn struct {
+	net.Conn
+	rec     *Recorder
+	readBuf []byte
+}
+
+// Wrap returns a connection that records its traffic to rec
+func Wrap(conn net.Conn, rec *Recorder) *Conn {
+	return &Conn{Conn: conn, rec: rec}
+}
+
+// Write sends p and records each complete message it contains
+func (c *Conn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		for _, message := range bytes.SplitAfter(p[:n], []byte("\n")) {
+			if len(bytes.TrimSpace(message)) > 0 {
+				c.rec.Record(DirectionSent, message)
+			}
+		}
+	}
+	return n, err
+}
+
+// Read records each message once its terminating newline has been received
+func (c *Conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.readBuf = append(c.readBuf, p[:n]...)
+		for {
+			idx := bytes.IndexByte(c.readBuf, '\n')
+			if idx < 0 {
+				break
+			}
+			if message := c.readBuf[:idx]; len(bytes.TrimSpace(message)) > 0 {
+				c.rec.Record(DirectionReceived, message)
+			}
+			c.readBuf = c.readBuf[idx+1:]
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,142 @@
+package transfer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Message encodings, negotiated between peers during the recipe handshake
+const (
+	EncodingZstd     = "zstd"
+	EncodingGzip     = "gzip"
+	EncodingIdentity = "identity"
+)
+
+// maxDecodedSize bounds a decompressed message so a small payload cannot exhaust memory
+const maxDecodedSize = 8 << 30
+
+// SupportedEncodings lists the encodings this build can send and receive, most preferred first
+func SupportedEncodings() []string {
+	return []string{EncodingZstd, EncodingGzip}
+}
+
+// ParseCompression turns a compression setting (auto, zstd, gzip or none) into the encodings to offer
+func ParseCompression(setting string) ([]string, error) {
+	switch strings.ToLower(strings.TrimSpace(setting)) {
+	case "", "auto":
+		return SupportedEncodings(), nil
+	case EncodingZstd:
+		return []string{EncodingZstd}, nil
+	case EncodingGzip:
+		return []string{EncodingGzip}, nil
+	case "none", EncodingIdentity:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown compression %q (use auto, zstd, gzip or none)", setting)
+	}
+}
+
+// Negotiate returns the first local encoding the peer also offers, or "" to send uncompressed
+func Negotiate(local, peer []string) string {
+	for _, encoding := range local {
+		for _, offered := range peer {
+			if strings.EqualFold(encoding, offered) {
+				return encoding
+			}
+		}
+	}
+	return ""
+}
+
+// Compress encodes data with encoding ("" or identity leaves it unchanged)
+func Compress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case "", EncodingIdentity:
+		return data, nil
+	case EncodingZstd:
+		encoder, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer encoder.Close()
+		return encoder.EncodeAll(data, make([]byte, 0, len(data)/4)), nil
+	case EncodingGzip:
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+}
+
+// Decompress decodes data that was compressed with encoding
+func Decompress(encoding string, data []byte) ([]byte, error) {
+	if encoding == "" || encoding == EncodingIdentity {
+		return data, nil
+	}
+	reader, err := NewReader(encoding, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	decoded, err := io.ReadAll(io.LimitReader(reader, maxDecodedSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s message: %w", encoding, err)
+	}
+	if len(decoded) > maxDecodedSize {
+		return nil, fmt.Errorf("decompressed message exceeds %d bytes", int64(maxDecodedSize))
+	}
+	return decoded, nil
+}
+
+// NewReader returns a streaming decoder for encoding
+func NewReader(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch strings.ToLower(encoding) {
+	case "", EncodingIdentity:
+		return io.NopCloser(r), nil
+	case EncodingZstd:
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderMaxMemory(maxDecodedSize))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case EncodingGzip:
+		return gzip.NewReader(r)
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+}
+
+// NewWriter returns a streaming encoder for encoding; Close flushes it without closing w
+func NewWriter(encoding string, w io.Writer) (io.WriteCloser, error) {
+	switch strings.ToLower(encoding) {
+	case "", EncodingIdentity:
+		return nopWriteCloser{w}, nil
+	case EncodingZstd:
+		return zstd.NewWriter(w)
+	case EncodingGzip:
+		return gzip.NewWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,183 @@
+package transfer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Payload encryption settings (peer.payload_encryption)
+const (
+	PayloadEncryptionAuto     = "auto"     // Encrypt when the peer offers a key too (default)
+	PayloadEncryptionRequired = "required" // Refuse peers that do not offer a key
+	PayloadEncryptionOff      = "off"      // Offer no key; payloads are protected by TLS only, if at all
+)
+
+// SealOverhead is the authentication tag AES-GCM adds to every sealed chunk
+const SealOverhead = 16
+
+// ParsePayloadEncryption checks a payload encryption setting ("" is auto)
+func ParsePayloadEncryption(setting string) (string, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(setting)); mode {
+	case "", PayloadEncryptionAuto:
+		return PayloadEncryptionAuto, nil
+	case PayloadEncryptionRequired, PayloadEncryptionOff:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown payload encryption %q (use auto, required or off)", setting)
+	}
+}
+
+// KeyExchange is an ephemeral X25519 key pair offered in the recipe handshake. A new one is
+// generated for every session, so recorded traffic cannot be decrypted later.
+type KeyExchange struct {
+	private *ecdh.PrivateKey
+}
+
+// NewKeyExchange generates an ephemeral key pair
+func NewKeyExchange() (*KeyExchange, error) {
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate X25519 key: %w", err)
+	}
+	return &KeyExchange{private: private}, nil
+}
+
+// PublicKey returns the public key sent to the peer
+func (k *KeyExchange) PublicKey() []byte {
+	return k.private.PublicKey().Bytes()
+}
+
+// Agree derives the session's Sealer from the peer's public key. The keys are bound to both
+// public keys and to secret, which both parties hold but a relay does not, so a relay that
+// replaces the public keys with its own cannot read or alter the payloads. Each direction has its
+// own key; isServer tells the two apart.
+func (k *KeyExchange) Agree(peerKey []byte, isServer bool, secret []byte) (*Sealer, error) {
+	public, err := ecdh.X25519().NewPublicKey(peerKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer payload key: %w", err)
+	}
+	shared, err := k.private.ECDH(public)
+	if err != nil {
+		return nil, fmt.Errorf("key agreement failed: %w", err)
+	}
+
+	clientKey, serverKey := k.PublicKey(), peerKey
+	if isServer {
+		clientKey, serverKey = peerKey, k.PublicKey()
+	}
+	salt := append(append([]byte{}, clientKey...), serverKey...)
+	ikm := append(append([]byte{}, shared...), secret...)
+
+	aead := func(info string) (cipher.AEAD, error) {
+		key, err := hkdf.Key(sha256.New, ikm, salt, info, 32)
+		if err != nil {
+			return nil, err
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	}
+	toServer, err := aead("cohort-bridge payload v1 client to server")
+	if err != nil {
+		return nil, err
+	}
+	toClient, err := aead("cohort-bridge payload v1 server to client")
+	if err != nil {
+		return nil, err
+	}
+
+	if isServer {
+		return &Sealer{send: toClient, receive: toServer}, nil
+	}
+	return &Sealer{send: toServer, receive: toClient}, nil
+}
+
+// Sealer encrypts outgoing and decrypts incoming payloads with AES-256-GCM. A chunk is identified
+// by its message sequence and index, which form the nonce; whether it is the message's last chunk
+// is authenticated too, so chunks cannot be reordered, replayed or cut off.
+type Sealer struct {
+	send    cipher.AEAD
+	receive cipher.AEAD
+}
+
+// Seal encrypts one chunk. Each (seq, index) must be sealed once per session.
+func (s *Sealer) Seal(seq uint64, index int, last bool, plaintext []byte) []byte {
+	nonce, additional := chunkNonce(seq, index, last)
+	return s.send.Seal(nil, nonce, plaintext, additional)
+}
+
+// Open decrypts and authenticates one chunk sealed by the peer
+func (s *Sealer) Open(seq uint64, index int, last bool, sealed []byte) ([]byte, error) {
+	nonce, additional := chunkNonce(seq, index, last)
+	plaintext, err := s.receive.Open(nil, nonce, sealed, additional)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %d of message %d failed authentication", index, seq)
+	}
+	return plaintext, nil
+}
+
+// chunkNonce returns the GCM nonce of a chunk and the additional data authenticated with it
+func chunkNonce(seq uint64, index int, last bool) (nonce, additional []byte) {
+	nonce = make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[0:8], seq)
+	binary.BigEndian.PutUint32(nonce[8:12], uint32(index))
+	additional = append(append([]byte{}, nonce...), 0)
+	if last {
+		additional[12] = 1
+	}
+	return nonce, additional
+}
+
+// sealMessage splits message into pieces that fill chunkSize once sealed and seals each one.
+// An empty message still yields one chunk, so its end is authenticated.
+func (s *Sealer) sealMessage(seq uint64, message []byte, chunkSize int) ([]byte, error) {
+	piece := chunkSize - SealOverhead
+	if piece <= 0 {
+		return nil, fmt.Errorf("chunk size %d is too small for sealed messages", chunkSize)
+	}
+	chunks := (len(message) + piece - 1) / piece
+	if chunks == 0 {
+		chunks = 1
+	}
+	sealed := make([]byte, 0, len(message)+chunks*SealOverhead)
+	for index := 0; index < chunks; index++ {
+		start := index * piece
+		end := start + piece
+		if end > len(message) {
+			end = len(message)
+		}
+		sealed = append(sealed, s.Seal(seq, index, index == chunks-1, message[start:end])...)
+	}
+	return sealed, nil
+}
+
+// openMessage reverses sealMessage for a message received in chunks of chunkSize
+func (s *Sealer) openMessage(seq uint64, sealed []byte, chunkSize int) ([]byte, error) {
+	chunks := (len(sealed) + chunkSize - 1) / chunkSize
+	if chunks == 0 {
+		return nil, fmt.Errorf("message %d is empty", seq)
+	}
+	message := make([]byte, 0, len(sealed))
+	for index := 0; index < chunks; index++ {
+		start := index * chunkSize
+		end := start + chunkSize
+		if end > len(sealed) {
+			end = len(sealed)
+		}
+		plaintext, err := s.Open(seq, index, index == chunks-1, sealed[start:end])
+		if err != nil {
+			return nil, err
+		}
+		message = append(message, plaintext...)
+	}
+	return message, nil
+}
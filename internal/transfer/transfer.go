@@ -0,0 +1,634 @@
+// Package transfer implements the chunked peer transfer protocol. Each message is split into
+// length-prefixed chunks carrying a CRC-32C checksum, every chunk is acknowledged, and the whole
+// message is verified against its SHA-256 digest. When the connection drops, both sides
+// re-establish it, exchange how far they got, and the interrupted message resumes from the
+// last confirmed chunk. Messages may be compressed with an encoding agreed by the peers, and
+// sealed with keys agreed in the handshake so that only the peer can read them. Peers that both
+// support it send heartbeats while idle, so a peer that stops responding is noticed instead of
+// waited on forever.
+package transfer
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/auroradata-ai/cohort-bridge/internal/retry"
+)
+
+// Frame types
+const (
+	frameOffer     byte = 1 // Announces a message (or resumes one) before its chunks
+	frameChunk     byte = 2 // One checksummed chunk of a message
+	frameAck       byte = 3 // Chunk received intact
+	frameNack      byte = 4 // Chunk failed its checksum; resend it
+	frameComplete  byte = 5 // Whole message received and verified
+	frameResync    byte = 6 // Progress exchanged after reconnecting
+	frameHeartbeat byte = 7 // Empty frame proving the peer is alive; skipped by readers
+)
+
+// DefaultChunkSize is used when Options.ChunkSize is not set
+const DefaultChunkSize = 1 << 20
+
+const (
+	frameHeaderSize = 5  // type byte + 4-byte big-endian payload length
+	chunkHeaderSize = 16 // 8-byte message sequence + 4-byte chunk index + 4-byte CRC-32C
+	maxChunkSize    = 64 << 20
+	maxFrameSize    = maxChunkSize + chunkHeaderSize
+	maxChunkResends = 3
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Dialer re-establishes the connection to the peer after a network failure
+type Dialer func() (net.Conn, error)
+
+// Options configure a Channel
+type Options struct {
+	ChunkSize int           // Bytes per chunk (default 1 MiB)
+	Retry     retry.Policy  // Reconnection attempts per failure and the delays between them; no retries disables resume
+	Timeout   time.Duration // Deadline for each frame within a transfer (0 for none)
+
+	// OnMessage is called with every complete message sent or received
+	OnMessage func(sent bool, message []byte)
+
+	// OnFrame is called with the wire size of every frame sent or received, retries included
+	OnFrame func(sent bool, size int)
+}
+
+// ProtocolError is a violation of the transfer protocol; it is never retried
+type ProtocolError struct {
+	Msg string
+}
+
+func (e *ProtocolError) Error() string {
+	return "transfer: " + e.Msg
+}
+
+func protocolErrorf(format string, args ...interface{}) error {
+	return &ProtocolError{Msg: fmt.Sprintf(format, args...)}
+}
+
+// ErrDeadline is returned once the deadline set with SetDeadline passes; it is never retried
+var ErrDeadline = errors.New("transfer: deadline exceeded")
+
+type offer struct {
+	Seq       uint64 `json:"seq"`
+	Size      int    `json:"size"`
+	ChunkSize int    `json:"chunk_size"`
+	Chunks    int    `json:"chunks"`
+	Start     int    `json:"start"`  // First chunk that follows; non-zero when resuming
+	SHA256    string `json:"sha256"` // Digest of the bytes on the wire (after compression and sealing)
+	Encoding  string `json:"encoding,omitempty"`
+	Sealed    bool   `json:"sealed,omitempty"` // Every chunk is sealed by the sender's Sealer
+}
+
+type ack struct {
+	Seq   uint64 `json:"seq"`
+	Index int    `json:"index"`
+}
+
+type complete struct {
+	Seq   uint64 `json:"seq"`
+	Error string `json:"error,omitempty"`
+}
+
+type resync struct {
+	Received      uint64 `json:"received"`       // Messages fully received
+	PartialSeq    uint64 `json:"partial_seq"`    // Message being received, 0 if none
+	PartialChunks int    `json:"partial_chunks"` // Confirmed chunks of that message
+}
+
+// partialMessage is an incoming message kept across reconnects
+type partialMessage struct {
+	offer  offer
+	data   []byte
+	chunks int
+}
+
+// Channel sends and receives whole messages over a peer connection. Messages are
+// numbered in each direction so that both sides agree on progress after a reconnect.
+// A Channel is not safe for concurrent use.
+type Channel struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	redial  Dialer
+	opts    Options
+	writeMu sync.Mutex // Serializes frames with the heartbeat goroutine; held to replace conn
+
+	encoding string  // Compression applied to outgoing messages
+	sealer   *Sealer // Encrypts messages in both directions once set
+
+	deadline  time.Time     // Bounds every read and write; zero for none
+	silence   time.Duration // Longest wait for any frame from the peer once heartbeats run
+	heartbeat chan struct{} // Closed to stop sending heartbeats
+
+	sent     uint64 // Messages confirmed by the peer
+	received uint64 // Messages fully received
+	partial  *partialMessage
+}
+
+// NewChannel wraps an established connection; redial may be nil to disable resume
+func NewChannel(conn net.Conn, redial Dialer, opts Options) *Channel {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+	if opts.ChunkSize > maxChunkSize {
+		opts.ChunkSize = maxChunkSize
+	}
+	if opts.Retry.Backoff <= 0 {
+		opts.Retry.Backoff = time.Second
+	}
+	c := &Channel{redial: redial, opts: opts}
+	c.setConn(conn)
+	return c
+}
+
+// Close stops the heartbeats and closes the current connection
+func (c *Channel) Close() error {
+	if c.heartbeat != nil {
+		close(c.heartbeat)
+		c.heartbeat = nil
+	}
+	return c.conn.Close()
+}
+
+// RemoteAddr returns the address of the peer on the current connection
+func (c *Channel) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetEncoding compresses subsequent outgoing messages with encoding ("" to send them as is).
+// Incoming messages are decoded according to the encoding named in their offer.
+func (c *Channel) SetEncoding(encoding string) {
+	c.encoding = encoding
+}
+
+// SetSealer encrypts subsequent messages in both directions with sealer. Once it is set, the
+// channel rejects incoming messages that are not sealed.
+func (c *Channel) SetSealer(sealer *Sealer) {
+	c.sealer = sealer
+}
+
+// SetDeadline bounds subsequent sends and receives, reconnections included, by t (the zero time
+// for no bound). Once t passes they fail with ErrDeadline.
+func (c *Channel) SetDeadline(t time.Time) {
+	c.deadline = t
+}
+
+// StartHeartbeat sends a heartbeat every interval until the channel is closed, and fails a wait
+// for the peer that hears nothing, not even a heartbeat, for silence. Both peers must support
+// heartbeats: older versions reject the frame.
+func (c *Channel) StartHeartbeat(interval, silence time.Duration) {
+	if c.heartbeat != nil || interval <= 0 {
+		return
+	}
+	c.silence = silence
+	c.heartbeat = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				// A failed heartbeat surfaces as an error on the next exchange
+				c.write(frameHeartbeat, nil, c.opts.Timeout)
+			}
+		}
+	}(c.heartbeat)
+}
+
+// Send transfers message to the peer, resuming after network failures
+func (c *Channel) Send(message []byte) error {
+	wire, err := Compress(c.encoding, message)
+	if err != nil {
+		return err
+	}
+	if c.encoding != "" && len(message) >= 1<<20 {
+		fmt.Printf("   Compressed %.1f MB to %.1f MB (%s)\n", float64(len(message))/(1<<20), float64(len(wire))/(1<<20), c.encoding)
+	}
+	// Compression comes first: sealed bytes do not compress
+	if c.sealer != nil {
+		if wire, err = c.sealer.sealMessage(c.sent+1, wire, c.opts.ChunkSize); err != nil {
+			return err
+		}
+	}
+
+	sum := sha256.Sum256(wire)
+	o := offer{
+		Seq:       c.sent + 1,
+		Size:      len(wire),
+		ChunkSize: c.opts.ChunkSize,
+		Chunks:    (len(wire) + c.opts.ChunkSize - 1) / c.opts.ChunkSize,
+		SHA256:    hex.EncodeToString(sum[:]),
+		Encoding:  c.encoding,
+		Sealed:    c.sealer != nil,
+	}
+
+	for recoveries := 0; ; recoveries++ {
+		err := c.sendMessage(o, wire)
+		if err == nil {
+			break
+		}
+		peer, err := c.recover(err, recoveries)
+		if err != nil {
+			return err
+		}
+		if peer.Received >= o.Seq {
+			break // The peer got everything; only the completion was lost
+		}
+		o.Start = 0
+		if peer.PartialSeq == o.Seq {
+			o.Start = peer.PartialChunks
+		}
+		fmt.Printf("   Resuming transfer at chunk %d/%d\n", o.Start, o.Chunks)
+	}
+
+	c.sent = o.Seq
+	if c.opts.OnMessage != nil {
+		c.opts.OnMessage(true, message)
+	}
+	return nil
+}
+
+// Receive returns the next message from the peer, resuming after network failures
+func (c *Channel) Receive() ([]byte, error) {
+	for recoveries := 0; ; recoveries++ {
+		message, err := c.receiveMessage()
+		if err == nil {
+			c.received++
+			c.partial = nil
+			if c.opts.OnMessage != nil {
+				c.opts.OnMessage(false, message)
+			}
+			return message, nil
+		}
+		if _, err := c.recover(err, recoveries); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sendMessage sends the offer and chunks from o.Start on, then waits for completion
+func (c *Channel) sendMessage(o offer, message []byte) error {
+	if err := c.writeJSON(frameOffer, o); err != nil {
+		return err
+	}
+
+	for index := o.Start; index < o.Chunks; index++ {
+		start := index * o.ChunkSize
+		end := start + o.ChunkSize
+		if end > len(message) {
+			end = len(message)
+		}
+		if err := c.sendChunk(o.Seq, index, message[start:end]); err != nil {
+			return err
+		}
+	}
+
+	var done complete
+	if err := c.readJSON(frameComplete, &done, c.opts.Timeout); err != nil {
+		return err
+	}
+	if done.Error != "" {
+		return protocolErrorf("peer rejected message %d: %s", o.Seq, done.Error)
+	}
+	if done.Seq != o.Seq {
+		return protocolErrorf("completion for message %d while sending %d", done.Seq, o.Seq)
+	}
+	return nil
+}
+
+// sendChunk writes one chunk and waits for its acknowledgment, resending it on a checksum failure
+func (c *Channel) sendChunk(seq uint64, index int, data []byte) error {
+	frame := make([]byte, chunkHeaderSize+len(data))
+	binary.BigEndian.PutUint64(frame[0:8], seq)
+	binary.BigEndian.PutUint32(frame[8:12], uint32(index))
+	binary.BigEndian.PutUint32(frame[12:16], crc32.Checksum(data, castagnoli))
+	copy(frame[chunkHeaderSize:], data)
+
+	for resends := 0; ; resends++ {
+		if err := c.writeFrame(frameChunk, frame); err != nil {
+			return err
+		}
+
+		frameType, payload, err := c.readFrame(c.opts.Timeout)
+		if err != nil {
+			return err
+		}
+		var reply ack
+		if err := json.Unmarshal(payload, &reply); err != nil {
+			return protocolErrorf("malformed acknowledgment: %v", err)
+		}
+		if reply.Seq != seq || reply.Index != index {
+			return protocolErrorf("acknowledgment for chunk %d/%d while sending %d/%d", reply.Seq, reply.Index, seq, index)
+		}
+
+		switch frameType {
+		case frameAck:
+			return nil
+		case frameNack:
+			if resends >= maxChunkResends {
+				return protocolErrorf("chunk %d of message %d failed its checksum %d times", index, seq, resends+1)
+			}
+		default:
+			return protocolErrorf("unexpected frame type %d while waiting for acknowledgment", frameType)
+		}
+	}
+}
+
+// receiveMessage reads one offer and its chunks, continuing a partial message when resumed
+func (c *Channel) receiveMessage() ([]byte, error) {
+	// No deadline here: the peer may spend a long time preparing its next message
+	var o offer
+	if err := c.readJSON(frameOffer, &o, 0); err != nil {
+		return nil, err
+	}
+	if o.Seq != c.received+1 {
+		return nil, protocolErrorf("offer for message %d, expected %d", o.Seq, c.received+1)
+	}
+	if o.ChunkSize <= 0 || o.ChunkSize > maxChunkSize || o.Chunks != (o.Size+o.ChunkSize-1)/o.ChunkSize {
+		return nil, protocolErrorf("invalid offer: %d bytes in %d chunks of %d", o.Size, o.Chunks, o.ChunkSize)
+	}
+
+	if c.partial == nil || c.partial.offer.Seq != o.Seq || c.partial.offer.SHA256 != o.SHA256 {
+		c.partial = &partialMessage{offer: o}
+	}
+	p := c.partial
+	if o.Start != p.chunks {
+		return nil, protocolErrorf("peer resumed message %d at chunk %d, have %d", o.Seq, o.Start, p.chunks)
+	}
+
+	for p.chunks < o.Chunks {
+		frameType, payload, err := c.readFrame(c.opts.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		if frameType != frameChunk || len(payload) < chunkHeaderSize {
+			return nil, protocolErrorf("unexpected frame type %d while receiving chunks", frameType)
+		}
+		seq := binary.BigEndian.Uint64(payload[0:8])
+		index := int(binary.BigEndian.Uint32(payload[8:12]))
+		checksum := binary.BigEndian.Uint32(payload[12:16])
+		data := payload[chunkHeaderSize:]
+		if seq != o.Seq || index != p.chunks {
+			return nil, protocolErrorf("chunk %d/%d out of order (expected %d/%d)", seq, index, o.Seq, p.chunks)
+		}
+
+		if crc32.Checksum(data, castagnoli) != checksum {
+			if err := c.writeJSON(frameNack, ack{Seq: seq, Index: index}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		p.data = append(p.data, data...)
+		p.chunks++
+		if err := c.writeJSON(frameAck, ack{Seq: seq, Index: index}); err != nil {
+			return nil, err
+		}
+	}
+
+	sum := sha256.Sum256(p.data)
+	if len(p.data) != o.Size || hex.EncodeToString(sum[:]) != o.SHA256 {
+		c.partial = nil
+		c.writeJSON(frameComplete, complete{Seq: o.Seq, Error: "message digest mismatch"})
+		return nil, protocolErrorf("message %d failed digest verification", o.Seq)
+	}
+	wire := p.data
+	if o.Sealed != (c.sealer != nil) {
+		c.partial = nil
+		c.writeJSON(frameComplete, complete{Seq: o.Seq, Error: "sealing does not match the handshake"})
+		if o.Sealed {
+			return nil, protocolErrorf("message %d is sealed, but no payload keys were agreed", o.Seq)
+		}
+		return nil, protocolErrorf("message %d is not sealed, but payload encryption was agreed", o.Seq)
+	}
+	if o.Sealed {
+		opened, err := c.sealer.openMessage(o.Seq, wire, o.ChunkSize)
+		if err != nil {
+			c.partial = nil
+			c.writeJSON(frameComplete, complete{Seq: o.Seq, Error: err.Error()})
+			return nil, protocolErrorf("%v", err)
+		}
+		wire = opened
+	}
+	message, err := Decompress(o.Encoding, wire)
+	if err != nil {
+		c.partial = nil
+		c.writeJSON(frameComplete, complete{Seq: o.Seq, Error: err.Error()})
+		return nil, protocolErrorf("message %d: %v", o.Seq, err)
+	}
+	if err := c.writeJSON(frameComplete, complete{Seq: o.Seq}); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// recover re-establishes the connection after a network error and exchanges progress with the peer.
+// Protocol errors, and failures once resume is exhausted, are returned unchanged.
+func (c *Channel) recover(cause error, recoveries int) (*resync, error) {
+	var protocolErr *ProtocolError
+	if errors.As(cause, &protocolErr) || errors.Is(cause, ErrDeadline) || c.redial == nil || recoveries >= c.opts.Retry.Retries {
+		return nil, cause
+	}
+	c.conn.Close()
+
+	lastErr := cause
+	for attempt := 1; attempt <= c.opts.Retry.Retries; attempt++ {
+		delay := c.opts.Retry.Delay(attempt)
+		if !c.deadline.IsZero() && time.Now().Add(delay).After(c.deadline) {
+			return nil, ErrDeadline
+		}
+		fmt.Printf("   Connection lost (%v); reconnecting in %s (attempt %d/%d)...\n", lastErr, delay.Round(time.Millisecond), attempt, c.opts.Retry.Retries)
+		time.Sleep(delay)
+
+		conn, err := c.redial()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		c.setConn(conn)
+
+		peer, err := c.resync()
+		if err != nil {
+			conn.Close()
+			lastErr = err
+			continue
+		}
+		fmt.Printf("   Reconnected to %s\n", conn.RemoteAddr())
+		return peer, nil
+	}
+	return nil, fmt.Errorf("transfer: gave up after %d reconnection attempts: %w", c.opts.Retry.Retries, lastErr)
+}
+
+// resync tells the peer how much has been received and learns the same from it
+func (c *Channel) resync() (*resync, error) {
+	local := resync{Received: c.received}
+	if c.partial != nil {
+		local.PartialSeq = c.partial.offer.Seq
+		local.PartialChunks = c.partial.chunks
+	}
+	if err := c.writeJSON(frameResync, local); err != nil {
+		return nil, err
+	}
+
+	var peer resync
+	if err := c.readJSON(frameResync, &peer, c.opts.Timeout); err != nil {
+		return nil, err
+	}
+	if peer.Received > c.sent+1 {
+		return nil, protocolErrorf("peer reports %d messages received but only %d were sent", peer.Received, c.sent+1)
+	}
+	return &peer, nil
+}
+
+func (c *Channel) setConn(conn net.Conn) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn = conn
+	c.reader = bufio.NewReaderSize(conn, 64*1024)
+}
+
+// writeFrame writes a length-prefixed frame
+func (c *Channel) writeFrame(frameType byte, payload []byte) error {
+	timeout := c.opts.Timeout
+	if !c.deadline.IsZero() {
+		remaining := time.Until(c.deadline)
+		if remaining <= 0 {
+			return ErrDeadline
+		}
+		if timeout <= 0 || remaining < timeout {
+			timeout = remaining
+		}
+	}
+	if err := c.write(frameType, payload, timeout); err != nil {
+		if isTimeout(err) && c.deadlinePassed() {
+			return ErrDeadline
+		}
+		return err
+	}
+	return nil
+}
+
+// write sends one frame within timeout (0 for no deadline); it is shared with the heartbeat goroutine
+func (c *Channel) write(frameType byte, payload []byte, timeout time.Duration) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if timeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	} else {
+		c.conn.SetWriteDeadline(time.Time{})
+	}
+	header := make([]byte, frameHeaderSize)
+	header[0] = frameType
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	if c.opts.OnFrame != nil {
+		c.opts.OnFrame(true, frameHeaderSize+len(payload))
+	}
+	return nil
+}
+
+func (c *Channel) writeJSON(frameType byte, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(frameType, payload)
+}
+
+// readFrame reads one frame other than a heartbeat, waiting at most timeout. With no timeout it
+// waits while heartbeats keep arriving, or forever if they are not running. The deadline set with
+// SetDeadline bounds both.
+func (c *Channel) readFrame(timeout time.Duration) (byte, []byte, error) {
+	frameDeadline := time.Time{}
+	if timeout > 0 {
+		frameDeadline = time.Now().Add(timeout)
+	}
+	for {
+		deadline, silent := frameDeadline, false
+		if deadline.IsZero() && c.silence > 0 {
+			deadline, silent = time.Now().Add(c.silence), true
+		}
+		if !c.deadline.IsZero() && (deadline.IsZero() || c.deadline.Before(deadline)) {
+			deadline, silent = c.deadline, false
+		}
+		c.conn.SetReadDeadline(deadline)
+
+		frameType, payload, err := c.readNextFrame()
+		if err != nil {
+			switch {
+			case !isTimeout(err):
+			case c.deadlinePassed():
+				return 0, nil, ErrDeadline
+			case silent:
+				return 0, nil, fmt.Errorf("peer sent nothing, not even a heartbeat, for %s: %w", c.silence, err)
+			}
+			return 0, nil, err
+		}
+		if frameType != frameHeartbeat {
+			return frameType, payload, nil
+		}
+	}
+}
+
+// readNextFrame reads the next frame of any type
+func (c *Channel) readNextFrame() (byte, []byte, error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return 0, nil, err
+	}
+	if header[0] < frameOffer || header[0] > frameHeartbeat {
+		return 0, nil, protocolErrorf("unknown frame type 0x%02x (peer may be running an older version)", header[0])
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxFrameSize {
+		return 0, nil, protocolErrorf("frame of %d bytes exceeds the %d byte limit", size, maxFrameSize)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	if c.opts.OnFrame != nil {
+		c.opts.OnFrame(false, frameHeaderSize+len(payload))
+	}
+	return header[0], payload, nil
+}
+
+func (c *Channel) deadlinePassed() bool {
+	return !c.deadline.IsZero() && !time.Now().Before(c.deadline)
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// readJSON reads a frame of the expected type and decodes its payload
+func (c *Channel) readJSON(frameType byte, v interface{}, timeout time.Duration) error {
+	got, payload, err := c.readFrame(timeout)
+	if err != nil {
+		return err
+	}
+	if got != frameType {
+		return protocolErrorf("unexpected frame type %d (expected %d)", got, frameType)
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return protocolErrorf("malformed frame: %v", err)
+	}
+	return nil
+}